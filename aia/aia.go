@@ -0,0 +1,334 @@
+// Package aia fetches the issuer certificates named by the Authority
+// Information Access URLs of certificates, and caches them.
+//
+// A Cache keeps each certificate for a TTL, in memory and, if it has a
+// directory, on disk, so that the cache survives restarts. Failed
+// fetches are remembered for a shorter time, so that an unreachable CA
+// server is not asked again for every certificate it issued. Concurrent
+// fetches of the same URL share a single request.
+package aia
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/log"
+)
+
+const (
+	// DefaultTTL is how long a fetched certificate is kept.
+	DefaultTTL = 24 * time.Hour
+	// DefaultNegativeTTL is how long a failed fetch is remembered.
+	DefaultNegativeTTL = 5 * time.Minute
+	// DefaultMaxEntries is the number of URLs a cache holds.
+	DefaultMaxEntries = 1024
+)
+
+// Config configures a Cache. Zero fields take the defaults.
+type Config struct {
+	// Dir is the directory in which fetched certificates are
+	// persisted. If empty, they are kept in memory only.
+	Dir string
+	// TTL is how long a fetched certificate is kept.
+	TTL time.Duration
+	// NegativeTTL is how long a failed fetch is remembered.
+	NegativeTTL time.Duration
+	// MaxEntries is the number of URLs the cache holds; the least
+	// recently used are evicted first.
+	MaxEntries int
+	// Client makes the requests. It defaults to a client with a
+	// ten second timeout.
+	Client *http.Client
+}
+
+type entry struct {
+	url     string
+	cert    *x509.Certificate
+	err     error
+	expires time.Time
+	elem    *list.Element
+}
+
+// A call is a fetch in progress, shared by the callers asking for
+// the same URL.
+type call struct {
+	done chan struct{}
+	cert *x509.Certificate
+	err  error
+}
+
+// A Cache fetches and caches issuer certificates. It is safe for
+// concurrent use.
+type Cache struct {
+	cfg Config
+
+	lock    sync.Mutex
+	read    func(io.Reader) ([]byte, error)
+	entries map[string]*entry
+	lru     *list.List
+	calls   map[string]*call
+
+	now func() time.Time
+}
+
+// New returns a cache configured by cfg.
+func New(cfg Config) *Cache {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	if cfg.NegativeTTL <= 0 {
+		cfg.NegativeTTL = DefaultNegativeTTL
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = DefaultMaxEntries
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Cache{
+		cfg:     cfg,
+		read:    ioutil.ReadAll,
+		entries: map[string]*entry{},
+		lru:     list.New(),
+		calls:   map[string]*call{},
+		now:     time.Now,
+	}
+}
+
+// Default is the cache shared by the bundler, the revocation checker
+// and the scanners.
+var Default = New(Config{})
+
+// SetReader sets the function used to read response bodies, such as
+// one limiting their size.
+func (c *Cache) SetReader(fn func(io.Reader) ([]byte, error)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.read = fn
+}
+
+// Fetch returns the certificate at url, from the cache if it holds
+// one. The certificate may be DER- or PEM-encoded.
+func (c *Cache) Fetch(url string) (*x509.Certificate, error) {
+	return c.FetchWith(url, nil)
+}
+
+// FetchWith is like Fetch, but reads the response body with read
+// rather than with the cache's reader, if the certificate has to be
+// fetched.
+func (c *Cache) FetchWith(url string, read func(io.Reader) ([]byte, error)) (*x509.Certificate, error) {
+	c.lock.Lock()
+	if e, ok := c.entries[url]; ok {
+		if c.now().Before(e.expires) {
+			c.lru.MoveToFront(e.elem)
+			c.lock.Unlock()
+			log.Debugf("aia: cache hit for %s", url)
+			return e.cert, e.err
+		}
+		c.remove(e)
+	}
+	if cl, ok := c.calls[url]; ok {
+		c.lock.Unlock()
+		log.Debugf("aia: waiting for fetch of %s in progress", url)
+		<-cl.done
+		return cl.cert, cl.err
+	}
+	cl := &call{done: make(chan struct{})}
+	c.calls[url] = cl
+	if read == nil {
+		read = c.read
+	}
+	c.lock.Unlock()
+
+	var ttl time.Duration
+	cl.cert, ttl = c.load(url)
+	if cl.cert == nil {
+		cl.cert, cl.err = c.fetch(url, read)
+		if cl.err != nil {
+			ttl = c.cfg.NegativeTTL
+		} else {
+			ttl = c.cfg.TTL
+			c.persist(url, cl.cert)
+		}
+	}
+
+	c.lock.Lock()
+	delete(c.calls, url)
+	c.add(&entry{url: url, cert: cl.cert, err: cl.err, expires: c.now().Add(ttl)})
+	c.lock.Unlock()
+	close(cl.done)
+
+	return cl.cert, cl.err
+}
+
+// Len returns the number of URLs in the cache.
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.entries)
+}
+
+// Purge empties the cache, on disk as well as in memory.
+func (c *Cache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, e := range c.entries {
+		c.remove(e)
+	}
+	if c.cfg.Dir == "" {
+		return
+	}
+	names, _ := filepath.Glob(filepath.Join(c.cfg.Dir, "*.pem"))
+	for _, name := range names {
+		os.Remove(name)
+	}
+}
+
+// add caches e, evicting the least recently used entries over the
+// limit. The lock must be held.
+func (c *Cache) add(e *entry) {
+	if old, ok := c.entries[e.url]; ok {
+		c.remove(old)
+	}
+	e.elem = c.lru.PushFront(e)
+	c.entries[e.url] = e
+	for c.lru.Len() > c.cfg.MaxEntries {
+		c.remove(c.lru.Back().Value.(*entry))
+	}
+}
+
+// remove drops e from the cache and the disk. The lock must be held.
+func (c *Cache) remove(e *entry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.url)
+	if c.cfg.Dir != "" && e.cert != nil {
+		os.Remove(c.path(e.url))
+	}
+}
+
+func (c *Cache) fetch(url string, read func(io.Reader) ([]byte, error)) (*x509.Certificate, error) {
+	log.Debugf("aia: fetching %s", url)
+	resp, err := c.cfg.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("aia: fetching %s: %s", url, resp.Status)
+	}
+
+	in, err := read(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(in)
+	if err != nil {
+		cert, err = helpers.ParseCertificatePEM(in)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cert, nil
+}
+
+// path returns the file persisting the certificate at url.
+func (c *Cache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.cfg.Dir, hex.EncodeToString(sum[:])+".pem")
+}
+
+// persist writes the certificate fetched from url to disk, where it
+// expires after the TTL. Failure only costs a fetch after a restart.
+func (c *Cache) persist(url string, cert *x509.Certificate) {
+	if c.cfg.Dir == "" {
+		return
+	}
+	block := &pem.Block{
+		Type: "CERTIFICATE",
+		Headers: map[string]string{
+			"URL":     url,
+			"Expires": c.now().Add(c.cfg.TTL).UTC().Format(time.RFC3339),
+		},
+		Bytes: cert.Raw,
+	}
+
+	if err := os.MkdirAll(c.cfg.Dir, 0755); err != nil {
+		log.Warningf("aia: failed to create cache directory: %v", err)
+		return
+	}
+	// Write to a temporary file and rename it, so that readers
+	// never see a partial file.
+	tmp, err := ioutil.TempFile(c.cfg.Dir, ".tmp-")
+	if err != nil {
+		log.Warningf("aia: failed to persist %s: %v", url, err)
+		return
+	}
+	_, err = tmp.Write(pem.EncodeToMemory(block))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(url))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Warningf("aia: failed to persist %s: %v", url, err)
+	}
+}
+
+var errStale = errors.New("aia: persisted certificate has expired")
+
+// load returns the certificate persisted for url, if it has not
+// expired, and how long it has left.
+func (c *Cache) load(url string) (*x509.Certificate, time.Duration) {
+	if c.cfg.Dir == "" {
+		return nil, 0
+	}
+	in, err := ioutil.ReadFile(c.path(url))
+	if err != nil {
+		return nil, 0
+	}
+
+	cert, ttl, err := c.decode(url, in)
+	if err != nil {
+		log.Debugf("aia: ignoring persisted %s: %v", url, err)
+		os.Remove(c.path(url))
+		return nil, 0
+	}
+	log.Debugf("aia: loaded %s from disk", url)
+	return cert, ttl
+}
+
+func (c *Cache) decode(url string, in []byte) (*x509.Certificate, time.Duration, error) {
+	block, _ := pem.Decode(in)
+	if block == nil || block.Headers["URL"] != url {
+		return nil, 0, errors.New("aia: malformed persisted certificate")
+	}
+	expires, err := time.Parse(time.RFC3339, block.Headers["Expires"])
+	if err != nil {
+		return nil, 0, err
+	}
+	ttl := expires.Sub(c.now())
+	if ttl <= 0 {
+		return nil, 0, errStale
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cert, ttl, nil
+}
@@ -0,0 +1,257 @@
+package aia
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCert(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "AIA Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// server serves a DER certificate at /der, a PEM one at /pem, and
+// fails elsewhere, counting the requests.
+type server struct {
+	*httptest.Server
+	hits  int32
+	block chan struct{}
+}
+
+func newServer(t *testing.T, der []byte) *server {
+	s := &server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.hits, 1)
+		if s.block != nil {
+			<-s.block
+		}
+		switch r.URL.Path {
+		case "/der":
+			w.Write(der)
+		case "/pem":
+			w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return s
+}
+
+func (s *server) Hits() int {
+	return int(atomic.LoadInt32(&s.hits))
+}
+
+func TestFetchTTL(t *testing.T) {
+	der := newCert(t)
+	s := newServer(t, der)
+	defer s.Close()
+
+	now := time.Now()
+	c := New(Config{TTL: time.Hour, NegativeTTL: time.Minute})
+	c.now = func() time.Time { return now }
+
+	for _, path := range []string{"/der", "/pem", "/der", "/pem"} {
+		cert, err := c.Fetch(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(cert.Raw) != string(der) {
+			t.Fatalf("%s: wrong certificate", path)
+		}
+	}
+	if s.Hits() != 2 {
+		t.Fatalf("expected 2 fetches, got %d", s.Hits())
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := c.Fetch(s.URL + "/der"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Hits() != 3 {
+		t.Fatalf("expected an expired certificate to be fetched again, got %d fetches", s.Hits())
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	s := newServer(t, newCert(t))
+	defer s.Close()
+
+	now := time.Now()
+	c := New(Config{NegativeTTL: time.Minute})
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Fetch(s.URL + "/missing"); err == nil {
+			t.Fatal("expected a failed fetch")
+		}
+	}
+	if s.Hits() != 1 {
+		t.Fatalf("expected the failure to be cached, got %d fetches", s.Hits())
+	}
+
+	now = now.Add(2 * time.Minute)
+	c.Fetch(s.URL + "/missing")
+	if s.Hits() != 2 {
+		t.Fatalf("expected the failure to expire, got %d fetches", s.Hits())
+	}
+}
+
+func TestFetchWith(t *testing.T) {
+	s := newServer(t, newCert(t))
+	defer s.Close()
+
+	c := New(Config{})
+	var cacheReads, callerReads int
+	c.SetReader(func(r io.Reader) ([]byte, error) {
+		cacheReads++
+		return ioutil.ReadAll(r)
+	})
+	read := func(r io.Reader) ([]byte, error) {
+		callerReads++
+		return ioutil.ReadAll(r)
+	}
+
+	if _, err := c.FetchWith(s.URL+"/der", read); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Fetch(s.URL + "/pem"); err != nil {
+		t.Fatal(err)
+	}
+	if callerReads != 1 || cacheReads != 1 {
+		t.Fatalf("expected each reader to be used once, got %d and %d", callerReads, cacheReads)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	s := newServer(t, newCert(t))
+	defer s.Close()
+
+	c := New(Config{MaxEntries: 2})
+	for _, q := range []string{"?a", "?b", "?a", "?c"} {
+		if _, err := c.Fetch(s.URL + "/der" + q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.Len())
+	}
+
+	// b was the least recently used, so was evicted; a was kept.
+	hits := s.Hits()
+	c.Fetch(s.URL + "/der?a")
+	if s.Hits() != hits {
+		t.Fatal("expected a to be cached")
+	}
+	c.Fetch(s.URL + "/der?b")
+	if s.Hits() != hits+1 {
+		t.Fatal("expected b to have been evicted")
+	}
+}
+
+func TestConcurrentFetch(t *testing.T) {
+	s := newServer(t, newCert(t))
+	defer s.Close()
+	s.block = make(chan struct{})
+
+	c := New(Config{})
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Fetch(s.URL + "/der")
+			errs <- err
+		}()
+	}
+
+	// Let the fetch finish once it has started; the other callers
+	// wait for it.
+	for s.Hits() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(s.block)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Hits() != 1 {
+		t.Fatalf("expected concurrent fetches to share a request, got %d", s.Hits())
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	der := newCert(t)
+	s := newServer(t, der)
+	dir, err := ioutil.TempDir("", "aia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	c := New(Config{Dir: dir, TTL: time.Hour})
+	if _, err := c.Fetch(s.URL + "/der"); err != nil {
+		t.Fatal(err)
+	}
+	url := s.URL + "/der"
+	s.Close()
+
+	// A new cache, as after a restart, finds the certificate on
+	// disk with the server gone.
+	c = New(Config{Dir: dir, TTL: time.Hour})
+	c.now = func() time.Time { return now }
+	cert, err := c.Fetch(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cert.Raw) != string(der) {
+		t.Fatal("wrong certificate loaded from disk")
+	}
+
+	// Once the TTL has passed, the certificate on disk is stale.
+	c = New(Config{Dir: dir, TTL: time.Hour})
+	c.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if _, err = c.Fetch(url); err == nil {
+		t.Fatal("expected a stale certificate to be fetched again")
+	}
+
+	c.Purge()
+	if c.Len() != 0 {
+		t.Fatal("expected an empty cache")
+	}
+}
@@ -16,10 +16,13 @@ type Handler interface {
 }
 
 // HTTPHandler is a wrapper that encapsulates Handler interface as http.Handler.
-// HTTPHandler also enforces that the Handler only responds to requests with registered HTTP methods.
+// HTTPHandler also enforces that the Handler only responds to requests with registered HTTP methods,
+// and, if it has schemas, that their bodies and query parameters match them.
 type HTTPHandler struct {
 	Handler          // CFSSL handler
 	Methods []string // The associated HTTP methods
+	Body    *Schema  // The schema of request bodies, other than those of GET requests
+	Query   *Schema  // The schema of the query parameters, as the properties of an object
 }
 
 // HandlerFunc is similar to the http.HandlerFunc type; it serves as
@@ -44,8 +47,15 @@ func HandleError(w http.ResponseWriter, err error) (code int) {
 
 	// If it is recognized as HttpError emitted from cfssl,
 	// we rewrite the status code accordingly. If it is a
-	// cfssl error, set the http status to StatusBadRequest
+	// cfssl error, set the http status to StatusBadRequest.
+	// Validation errors report each field that is invalid,
+	// with the cfssl error code of its schema.
+	var fields []FieldError
 	switch err := err.(type) {
+	case *ValidationError:
+		httpCode = http.StatusBadRequest
+		code = err.Code()
+		fields = err.Fields
 	case *errors.HTTPError:
 		httpCode = err.StatusCode
 		code = err.StatusCode
@@ -56,6 +66,12 @@ func HandleError(w http.ResponseWriter, err error) (code int) {
 	}
 
 	response := NewErrorResponse(msg, code)
+	if fields != nil {
+		response.Errors = make([]ResponseMessage, len(fields))
+		for i, fe := range fields {
+			response.Errors[i] = ResponseMessage{Code: fe.Code, Message: fieldMessage(fe), Field: fe.Field}
+		}
+	}
 	jsonMessage, err := json.Marshal(response)
 	if err != nil {
 		log.Errorf("Failed to marshal JSON: %v", err)
@@ -78,7 +94,10 @@ func (h HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if match {
-		err = h.Handle(w, r)
+		err = h.validateRequest(r)
+		if err == nil {
+			err = h.Handle(w, r)
+		}
 	} else {
 		err = errors.NewMethodNotAllowed(r.Method)
 	}
@@ -161,10 +180,12 @@ func matchKeywords(blob map[string]string, keywords []string) bool {
 }
 
 // ResponseMessage implements the standard for response errors and
-// messages. A message has a code and a string message.
+// messages. A message has a code and a string message. Errors for a
+// field of the request also name the field.
 type ResponseMessage struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
 }
 
 // Response implements the CloudFlare standard for API
@@ -194,7 +215,7 @@ func NewSuccessResponseWithMessage(result interface{}, message string, code int)
 		Success:  true,
 		Result:   result,
 		Errors:   []ResponseMessage{},
-		Messages: []ResponseMessage{{code, message}},
+		Messages: []ResponseMessage{{Code: code, Message: message}},
 	}
 }
 
@@ -204,7 +225,7 @@ func NewErrorResponse(message string, code int) Response {
 	return Response{
 		Success:  false,
 		Result:   nil,
-		Errors:   []ResponseMessage{{code, message}},
+		Errors:   []ResponseMessage{{Code: code, Message: message}},
 		Messages: []ResponseMessage{},
 	}
 }
@@ -2,12 +2,16 @@
 package bundle
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"net/http"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/bundler"
 	"github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/starttls"
 )
 
 // Handler accepts requests for either remote or uploaded
@@ -18,17 +22,18 @@ type Handler struct {
 }
 
 // NewHandler creates a new bundler that uses the root bundle and
-// intermediate bundle in the trust chain.
-func NewHandler(caBundleFile, intBundleFile string) (http.Handler, error) {
+// intermediate bundle in the trust chain. The options may add flavors
+// with their own chain policies.
+func NewHandler(caBundleFile, intBundleFile string, opts ...bundler.Option) (http.Handler, error) {
 	var err error
 
 	b := new(Handler)
-	if b.bundler, err = bundler.NewBundler(caBundleFile, intBundleFile); err != nil {
+	if b.bundler, err = bundler.NewBundler(caBundleFile, intBundleFile, opts...); err != nil {
 		return nil, err
 	}
 
 	log.Info("bundler API ready")
-	return api.HTTPHandler{Handler: b, Methods: []string{"POST"}}, nil
+	return api.HTTPHandler{Handler: b, Methods: []string{"POST"}, Body: openapi.BundleRequest}, nil
 }
 
 // Handle implements an http.Handler interface for the bundle handler.
@@ -47,13 +52,18 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 	bf := bundler.Ubiquitous
 	if flavor != "" {
 		bf = bundler.BundleFlavor(flavor)
+		if !h.bundler.HasFlavor(bf) {
+			log.Warningf("unknown flavor %s, using %s", flavor, bundler.Ubiquitous)
+			bf = bundler.Ubiquitous
+		}
 	}
 	log.Infof("request for flavor %v", bf)
 
 	var result *bundler.Bundle
 	switch matched[0] {
 	case "domain":
-		bundle, err := h.bundler.BundleFromRemote(blob["domain"], blob["ip"], bf)
+		domain := starttls.Join(blob["starttls"], blob["domain"])
+		bundle, err := h.bundler.BundleFromRemote(domain, blob["ip"], bf)
 		if err != nil {
 			log.Warningf("couldn't bundle from remote: %v", err)
 			return err
@@ -86,6 +96,53 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 
 		result = bundle
 	}
+
+	response, err := encodeBundle(result, blob["format"], blob["password"])
+	if err != nil {
+		log.Warningf("couldn't encode bundle: %v", err)
+		return err
+	}
 	log.Info("wrote response")
-	return api.SendResponse(w, result)
+	return api.SendResponse(w, response)
+}
+
+// encodeBundle adds the bundle chain, encoded in the requested format,
+// to the JSON representation of the bundle. The PEM format is always
+// present as the "bundle" field, so only "pkcs7" and "pkcs12" add a
+// field, holding the base64-encoded DER.
+func encodeBundle(bundle *bundler.Bundle, format, password string) (interface{}, error) {
+	var der []byte
+	var err error
+	switch format {
+	case "", "pem":
+		return bundle, nil
+	case "pkcs7":
+		der, err = bundle.PKCS7()
+	case "pkcs12":
+		// The archive holds the private key, if one was presented,
+		// which mustn't be sent without a password protecting it.
+		if bundle.Key != nil && password == "" {
+			return nil, errors.NewBadRequestString("a password is required for a PKCS #12 archive holding a private key")
+		}
+		der, err = bundle.PKCS12(password)
+	default:
+		return nil, errors.NewBadRequestString("unsupported format " + format)
+	}
+	if err != nil {
+		if _, ok := err.(*errors.Error); ok {
+			return nil, err
+		}
+		return nil, errors.Wrap(errors.CertificateError, errors.Unknown, err)
+	}
+
+	jsonBytes, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, errors.Wrap(errors.CertificateError, errors.Unknown, err)
+	}
+	var response map[string]interface{}
+	if err = json.Unmarshal(jsonBytes, &response); err != nil {
+		return nil, errors.Wrap(errors.CertificateError, errors.Unknown, err)
+	}
+	response[format] = base64.StdEncoding.EncodeToString(der)
+	return response, nil
 }
@@ -2,6 +2,7 @@ package bundle
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -9,6 +10,8 @@ import (
 	"testing"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/bundler"
+	"github.com/cloudflare/cfssl/helpers"
 )
 
 const (
@@ -211,3 +214,128 @@ func TestBundle(t *testing.T) {
 		}
 	}
 }
+
+func TestBundleFormats(t *testing.T) {
+	ts := newBundleServer(t)
+	defer ts.Close()
+	certPEM, err := ioutil.ReadFile(testLeafCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := ioutil.ReadFile(testLeafKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"pkcs7", "pkcs12", "bogus"} {
+		blob, err := json.Marshal(map[string]string{
+			"certificate": string(certPEM),
+			"private_key": string(keyPEM),
+			"flavor":      "force",
+			"format":      format,
+			"password":    "password",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if format == "bogus" {
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("expected %d for an unsupported format, got %d", http.StatusBadRequest, resp.StatusCode)
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("format %s: unexpected status %d: %s", format, resp.StatusCode, body)
+		}
+
+		var message struct {
+			Result map[string]interface{} `json:"result"`
+		}
+		if err = json.Unmarshal(body, &message); err != nil {
+			t.Fatal(err)
+		}
+		encoded, ok := message.Result[format].(string)
+		if !ok {
+			t.Fatalf("format %s: missing from the result", format)
+		}
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs, key, err := helpers.ParseCertificatesDER(der, "password")
+		if err != nil {
+			t.Fatalf("format %s: %v", format, err)
+		}
+		if (format == "pkcs12") != (key != nil) || len(certs) == 0 {
+			t.Fatalf("format %s: unexpected contents", format)
+		}
+		if _, ok = message.Result["bundle"]; !ok {
+			t.Fatalf("format %s: bundle missing from the result", format)
+		}
+	}
+
+	// The private key isn't sent without a password protecting it.
+	blob, err := json.Marshal(map[string]string{
+		"certificate": string(certPEM),
+		"private_key": string(keyPEM),
+		"flavor":      "force",
+		"format":      "pkcs12",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected %d for a PKCS #12 archive without a password, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestBundleFlavors(t *testing.T) {
+	h, err := NewHandler(testCaBundleFile, testIntBundleFile,
+		bundler.WithChainPolicy("short", bundler.ChainPolicy{Ranking: []string{"chain_length"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	certPEM, err := ioutil.ReadFile(testLeafCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := func(flavor string) int {
+		blob, err := json.Marshal(map[string]string{
+			"certificate": string(certPEM),
+			"flavor":      flavor,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A flavor the bundler doesn't know falls back to ubiquitous.
+	want := status("ubiquitous")
+	for _, flavor := range []string{"short", "fastest"} {
+		if have := status(flavor); have != want {
+			t.Fatalf("flavor %s: expected %d, got %d", flavor, want, have)
+		}
+	}
+}
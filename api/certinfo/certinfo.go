@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/certinfo"
 	"github.com/cloudflare/cfssl/log"
 )
@@ -17,7 +18,7 @@ type Handler struct{}
 // NewHandler creates a new bundler that uses the root bundle and
 // intermediate bundle in the trust chain.
 func NewHandler() http.Handler {
-	return api.HTTPHandler{Handler: new(Handler), Methods: []string{"POST"}}
+	return api.HTTPHandler{Handler: new(Handler), Methods: []string{"POST"}, Body: openapi.CertInfoRequest}
 }
 
 // Handle implements an http.Handler interface for the bundle handler.
@@ -1,6 +1,178 @@
 package client
 
+import (
+	"math/big"
+	"time"
+
+	"github.com/cloudflare/cfssl/bundler"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/signer"
+)
+
 // SignResult is the result of signing a CSR.
 type SignResult struct {
 	Certificate []byte `json:"certificate"`
 }
+
+// SignRequest is a request to the sign and authsign endpoints.
+type SignRequest struct {
+	Hosts   []string        `json:"hosts,omitempty"`
+	Request string          `json:"certificate_request"`
+	Subject *signer.Subject `json:"subject,omitempty"`
+	Profile string          `json:"profile,omitempty"`
+	Label   string          `json:"label,omitempty"`
+	Serial  *big.Int        `json:"serial,omitempty"`
+	// Bundle asks for the certificate's chain to be returned.
+	Bundle bool `json:"bundle,omitempty"`
+	// Format asks for the chain to also be returned as "pkcs7" or
+	// "pkcs12", the latter protected by Password.
+	Format   string `json:"format,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// SignResponse is the result of the sign and authsign endpoints.
+type SignResponse struct {
+	Certificate string  `json:"certificate"`
+	Bundle      *Bundle `json:"bundle,omitempty"`
+	PKCS7       []byte  `json:"pkcs7,omitempty"`
+	PKCS12      []byte  `json:"pkcs12,omitempty"`
+}
+
+// A Bundle is a certificate with the chain to a trusted root, as
+// returned by the bundle endpoint, or by the sign and newcert endpoints
+// when asked to bundle. PKCS7 and PKCS12 hold the chain in the format
+// the bundle endpoint was asked for, if any.
+type Bundle struct {
+	Bundle      string                `json:"bundle"`
+	Root        string                `json:"root"`
+	Certificate string                `json:"crt"`
+	Key         string                `json:"key"`
+	KeyType     string                `json:"key_type"`
+	KeySize     int                   `json:"key_size"`
+	Issuer      string                `json:"issuer"`
+	Subject     string                `json:"subject"`
+	Expires     time.Time             `json:"expires"`
+	LeafExpires time.Time             `json:"leaf_expires"`
+	Hostnames   []string              `json:"hostnames"`
+	OCSPSupport bool                  `json:"ocsp_support"`
+	CRLSupport  bool                  `json:"crl_support"`
+	OCSP        []string              `json:"ocsp"`
+	Signature   string                `json:"signature"`
+	Status      *bundler.BundleStatus `json:"status"`
+	PKCS7       []byte                `json:"pkcs7,omitempty"`
+	PKCS12      []byte                `json:"pkcs12,omitempty"`
+}
+
+// BundleRequest is a request to the bundle endpoint, which bundles
+// either the given Certificate or the one served by Domain.
+type BundleRequest struct {
+	Certificate string `json:"certificate,omitempty"`
+	PrivateKey  string `json:"private_key,omitempty"`
+	Domain      string `json:"domain,omitempty"`
+	IP          string `json:"ip,omitempty"`
+	StartTLS    string `json:"starttls,omitempty"`
+	Flavor      string `json:"flavor,omitempty"`
+	Format      string `json:"format,omitempty"`
+	Password    string `json:"password,omitempty"`
+}
+
+// GenCRLRequest is a request to the gencrl endpoint, for a CRL revoking
+// the decimal SerialNumbers, signed by Certificate and PrivateKey and
+// expiring after Expiry seconds (a week if empty).
+type GenCRLRequest struct {
+	Certificate   string   `json:"certificate"`
+	SerialNumbers []string `json:"serialNumber"`
+	PrivateKey    string   `json:"issuingKey"`
+	Expiry        string   `json:"expireTime,omitempty"`
+}
+
+// Sum contains digests of a certificate or certificate request.
+type Sum struct {
+	MD5  string `json:"md5"`
+	SHA1 string `json:"sha-1"`
+}
+
+// NewKeyResponse is the result of the newkey endpoint.
+type NewKeyResponse struct {
+	PrivateKey         string         `json:"private_key"`
+	CertificateRequest string         `json:"certificate_request"`
+	Sums               map[string]Sum `json:"sums"`
+}
+
+// NewCertRequest is a request to the newcert endpoint.
+type NewCertRequest struct {
+	Request *csr.CertificateRequest `json:"request"`
+	Profile string                  `json:"profile,omitempty"`
+	Label   string                  `json:"label,omitempty"`
+	Bundle  bool                    `json:"bundle,omitempty"`
+}
+
+// NewCertResponse is the result of the newcert endpoint.
+type NewCertResponse struct {
+	PrivateKey         string         `json:"private_key"`
+	CertificateRequest string         `json:"certificate_request"`
+	Certificate        string         `json:"certificate"`
+	Sums               map[string]Sum `json:"sums"`
+	Bundle             *Bundle        `json:"bundle,omitempty"`
+}
+
+// InitCAResponse is the result of the init_ca endpoint.
+type InitCAResponse struct {
+	PrivateKey  string `json:"private_key"`
+	Certificate string `json:"certificate"`
+}
+
+// ScanRequest is a request to the scan endpoint. Family and Scanner are
+// regular expressions selecting the scans to run; Timeout defaults to a
+// minute.
+type ScanRequest struct {
+	Host     string
+	IP       string
+	StartTLS string
+	Family   string
+	Scanner  string
+	Timeout  time.Duration
+}
+
+// CertInfoRequest is a request to the certinfo endpoint, for either the
+// given Certificate or the one served by Domain.
+type CertInfoRequest struct {
+	Certificate string `json:"certificate,omitempty"`
+	Domain      string `json:"domain,omitempty"`
+}
+
+// LintRequest is a request to the lint endpoint.
+type LintRequest struct {
+	Certificate  string   `json:"certificate"`
+	IgnoredLints []string `json:"ignored_lints,omitempty"`
+}
+
+// OCSPSignRequest is a request to the ocspsign endpoint. RevokedAt is
+// either "now" or a date, as 2006-01-02.
+type OCSPSignRequest struct {
+	Certificate string `json:"certificate"`
+	Status      string `json:"status"`
+	Reason      int    `json:"reason,omitempty"`
+	RevokedAt   string `json:"revoked_at,omitempty"`
+	IssuerHash  string `json:"issuer_hash,omitempty"`
+}
+
+// RevokeRequest is a request to the revoke endpoint. Reason is the name
+// of a revocation reason, such as "keyCompromise".
+type RevokeRequest struct {
+	Serial string `json:"serial"`
+	AKI    string `json:"authority_key_id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// CertAddRequest is a request to the certadd endpoint.
+type CertAddRequest struct {
+	Serial    string    `json:"serial_number"`
+	AKI       string    `json:"authority_key_identifier"`
+	CALabel   string    `json:"ca_label,omitempty"`
+	Status    string    `json:"status"`
+	Reason    int       `json:"reason,omitempty"`
+	Expiry    time.Time `json:"expiry"`
+	RevokedAt time.Time `json:"revoked_at"`
+	PEM       string    `json:"pem"`
+}
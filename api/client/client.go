@@ -3,10 +3,12 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	stderr "errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -29,6 +31,7 @@ type server struct {
 	reqModifier    func(*http.Request, []byte)
 	RequestTimeout time.Duration
 	proxy          func(*http.Request) (*url.URL, error)
+	health         *health
 }
 
 // A Remote points to at least one (but possibly multiple) remote
@@ -46,6 +49,16 @@ type Remote interface {
 	SetProxy(func(*http.Request) (*url.URL, error))
 }
 
+// A ContextRemote is a Remote whose requests may be bounded by a
+// context. For a group, the context's deadline bounds the whole call,
+// including any retries.
+type ContextRemote interface {
+	Remote
+	AuthSignContext(ctx context.Context, req, id []byte, provider auth.Provider) ([]byte, error)
+	SignContext(ctx context.Context, jsonData []byte) ([]byte, error)
+	InfoContext(ctx context.Context, jsonData []byte) (*info.Resp, error)
+}
+
 // NewServer sets up a new server target. The address should be of
 // The format [protocol:]name[:port] of the remote CFSSL instance.
 // If no protocol is given http is default. If no port
@@ -77,6 +90,26 @@ func NewServerTLS(addr string, tlsConfig *tls.Config) Remote {
 	return remote
 }
 
+// NewServerTLSWithStrategy is NewServerTLS, choosing among the hosts of
+// a comma-separated list with the named strategy, which defaults to
+// ordered_list, and the given options.
+func NewServerTLSWithStrategy(addr string, tlsConfig *tls.Config, strategy string, opts ...GroupOption) (Remote, error) {
+	addrs := strings.Split(addr, ",")
+	if len(addrs) > 1 {
+		var s Strategy = StrategyOrderedList
+		if strategy != "" {
+			s = StrategyFromString(strategy)
+		}
+		return NewGroup(addrs, tlsConfig, s, opts...)
+	}
+
+	u, err := normalizeURL(addrs[0])
+	if err != nil {
+		return nil, err
+	}
+	return newServer(u, tlsConfig), nil
+}
+
 func (srv *server) Hosts() []string {
 	return []string{srv.URL}
 }
@@ -95,9 +128,15 @@ func (srv *server) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
 
 func newServer(u *url.URL, tlsConfig *tls.Config) *server {
 	URL := u.String()
+	// The configuration is shared by concurrent requests, such as
+	// health checks, so it's completed here rather than per request.
+	if tlsConfig != nil {
+		tlsConfig.BuildNameToCertificate()
+	}
 	return &server{
 		URL:       URL,
 		TLSConfig: tlsConfig,
+		health:    newHealth(0, DefaultBackoffInterval, DefaultBackoffMax),
 	}
 }
 
@@ -108,18 +147,17 @@ func (srv *server) getURL(endpoint string) string {
 func (srv *server) createTransport() (transport *http.Transport) {
 	transport = new(http.Transport)
 	// Setup HTTPS client
-	tlsConfig := srv.TLSConfig
-	tlsConfig.BuildNameToCertificate()
-	transport.TLSClientConfig = tlsConfig
+	transport.TLSClientConfig = srv.TLSConfig
 	// Setup Proxy
 	transport.Proxy = srv.proxy
 	return transport
 }
 
-// post connects to the remote server and returns a Response struct
-func (srv *server) post(url string, jsonData []byte) (*api.Response, error) {
-	var resp *http.Response
-	var err error
+// send makes a request to the remote server, returning the body of
+// the response if it succeeded. Failures to reach the server, and
+// server errors, count against its health; other responses count for
+// it.
+func (srv *server) send(ctx context.Context, method, url, contentType string, data []byte) ([]byte, error) {
 	client := &http.Client{}
 	if srv.TLSConfig != nil {
 		client.Transport = srv.createTransport()
@@ -127,33 +165,76 @@ func (srv *server) post(url string, jsonData []byte) (*api.Response, error) {
 	if srv.RequestTimeout != 0 {
 		client.Timeout = srv.RequestTimeout
 	}
-	req, err := http.NewRequest("POST", url, bytes.NewReader(jsonData))
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		err = fmt.Errorf("failed POST to %s: %v", url, err)
+		err = fmt.Errorf("failed %s to %s: %v", method, url, err)
 		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, err)
 	}
+	req = req.WithContext(ctx)
 	req.Close = true
-	req.Header.Set("content-type", "application/json")
+	if data != nil {
+		req.Header.Set("content-type", contentType)
+	}
 	if srv.reqModifier != nil {
-		srv.reqModifier(req, jsonData)
+		srv.reqModifier(req, data)
 	}
-	resp, err = client.Do(req)
+	start := time.Now()
+	resp, err := client.Do(req)
 	if err != nil {
-		err = fmt.Errorf("failed POST to %s: %v", url, err)
+		srv.observe(ctx, false, 0)
+		err = fmt.Errorf("failed %s to %s: %v", method, url, err)
 		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, err)
 	}
-	defer req.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
+		srv.observe(ctx, false, 0)
 		return nil, errors.Wrap(errors.APIClientError, errors.IOError, err)
 	}
+	srv.observe(ctx, resp.StatusCode < http.StatusInternalServerError, time.Since(start))
 
 	if resp.StatusCode != http.StatusOK {
 		log.Errorf("http error with %s", url)
-		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, stderr.New(string(body)))
+		return nil, responseError(respBody)
 	}
+	return respBody, nil
+}
 
+// responseError returns the error reported by a failed response: the
+// first error of an API response, with the code and message the server
+// gave it, or the body itself if it isn't one.
+func responseError(body []byte) error {
 	var response api.Response
+	if err := json.Unmarshal(body, &response); err == nil && len(response.Errors) > 0 {
+		return &errors.Error{ErrorCode: response.Errors[0].Code, Message: response.Errors[0].Message}
+	}
+	return errors.Wrap(errors.APIClientError, errors.ClientHTTPError, stderr.New(string(body)))
+}
+
+// fetch makes a request to an endpoint of the remote server, returning
+// the body of the response if it succeeded.
+func (srv *server) fetch(ctx context.Context, method, endpoint string, query url.Values, contentType string, data []byte) ([]byte, error) {
+	url := srv.getURL(endpoint)
+	if len(query) > 0 {
+		url += "?" + query.Encode()
+	}
+	return srv.send(ctx, method, url, contentType, data)
+}
+
+// call makes a request to an API endpoint of the remote server,
+// returning its response if it succeeded. If result isn't nil, the
+// result of the response is decoded into it.
+func (srv *server) call(ctx context.Context, method, endpoint string, query url.Values, jsonData []byte, result interface{}) (*api.Response, error) {
+	body, err := srv.fetch(ctx, method, endpoint, query, "application/json", jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	response := api.Response{Result: result}
 	err = json.Unmarshal(body, &response)
 	if err != nil {
 		log.Debug("Unable to parse response body:", string(body))
@@ -162,7 +243,7 @@ func (srv *server) post(url string, jsonData []byte) (*api.Response, error) {
 
 	if !response.Success || response.Result == nil {
 		if len(response.Errors) > 0 {
-			return nil, errors.Wrap(errors.APIClientError, errors.ServerRequestFailed, stderr.New(response.Errors[0].Message))
+			return nil, responseError(body)
 		}
 		return nil, errors.New(errors.APIClientError, errors.ServerRequestFailed)
 	}
@@ -170,12 +251,23 @@ func (srv *server) post(url string, jsonData []byte) (*api.Response, error) {
 	return &response, nil
 }
 
+// post sends a JSON request to an API endpoint of the remote server,
+// returning its response if it succeeded.
+func (srv *server) post(ctx context.Context, endpoint string, jsonData []byte) (*api.Response, error) {
+	return srv.call(ctx, "POST", endpoint, nil, jsonData, nil)
+}
+
 // AuthSign fills out an authenticated signing request to the server,
 // receiving a certificate or error in response.
 // It takes the serialized JSON request to send, remote address and
 // authentication provider.
 func (srv *server) AuthSign(req, id []byte, provider auth.Provider) ([]byte, error) {
-	return srv.authReq(req, id, provider, "sign")
+	return srv.AuthSignContext(context.Background(), req, id, provider)
+}
+
+// AuthSignContext is AuthSign, bounded by ctx.
+func (srv *server) AuthSignContext(ctx context.Context, req, id []byte, provider auth.Provider) ([]byte, error) {
+	return srv.authReq(ctx, req, id, provider, "sign")
 }
 
 // AuthInfo fills out an authenticated info request to the server,
@@ -183,15 +275,13 @@ func (srv *server) AuthSign(req, id []byte, provider auth.Provider) ([]byte, err
 // It takes the serialized JSON request to send, remote address and
 // authentication provider.
 func (srv *server) AuthInfo(req, id []byte, provider auth.Provider) ([]byte, error) {
-	return srv.authReq(req, id, provider, "info")
+	return srv.authReq(context.Background(), req, id, provider, "info")
 }
 
 // authReq is the common logic for AuthSign and AuthInfo -- perform the given
 // request, and return the resultant certificate.
 // The target is either 'sign' or 'info'.
-func (srv *server) authReq(req, ID []byte, provider auth.Provider, target string) ([]byte, error) {
-	url := srv.getURL("auth" + target)
-
+func (srv *server) authReq(ctx context.Context, req, ID []byte, provider auth.Provider, target string) ([]byte, error) {
 	token, err := provider.Token(req)
 	if err != nil {
 		return nil, errors.Wrap(errors.APIClientError, errors.AuthenticationFailure, err)
@@ -209,7 +299,7 @@ func (srv *server) authReq(req, ID []byte, provider auth.Provider, target string
 		return nil, errors.Wrap(errors.APIClientError, errors.JSONError, err)
 	}
 
-	response, err := srv.post(url, jsonData)
+	response, err := srv.post(ctx, "auth"+target, jsonData)
 	if err != nil {
 		return nil, err
 	}
@@ -231,14 +321,24 @@ func (srv *server) authReq(req, ID []byte, provider auth.Provider, target string
 // receiving a signed certificate or an error in response.
 // It takes the serialized JSON request to send.
 func (srv *server) Sign(jsonData []byte) ([]byte, error) {
-	return srv.request(jsonData, "sign")
+	return srv.SignContext(context.Background(), jsonData)
+}
+
+// SignContext is Sign, bounded by ctx.
+func (srv *server) SignContext(ctx context.Context, jsonData []byte) ([]byte, error) {
+	return srv.request(ctx, jsonData, "sign")
 }
 
 // Info sends an info request to the remote CFSSL server, receiving a
 // response or an error in response.
 // It takes the serialized JSON request to send.
 func (srv *server) Info(jsonData []byte) (*info.Resp, error) {
-	res, err := srv.getResultMap(jsonData, "info")
+	return srv.InfoContext(context.Background(), jsonData)
+}
+
+// InfoContext is Info, bounded by ctx.
+func (srv *server) InfoContext(ctx context.Context, jsonData []byte) (*info.Resp, error) {
+	res, err := srv.getResultMap(ctx, jsonData, "info")
 	if err != nil {
 		return nil, err
 	}
@@ -264,9 +364,8 @@ func (srv *server) Info(jsonData []byte) (*info.Resp, error) {
 	return info, nil
 }
 
-func (srv *server) getResultMap(jsonData []byte, target string) (result map[string]interface{}, err error) {
-	url := srv.getURL(target)
-	response, err := srv.post(url, jsonData)
+func (srv *server) getResultMap(ctx context.Context, jsonData []byte, target string) (result map[string]interface{}, err error) {
+	response, err := srv.post(ctx, target, jsonData)
 	if err != nil {
 		return
 	}
@@ -280,8 +379,8 @@ func (srv *server) getResultMap(jsonData []byte, target string) (result map[stri
 
 // request performs the common logic for Sign and Info, performing the actual
 // request and returning the resultant certificate.
-func (srv *server) request(jsonData []byte, target string) ([]byte, error) {
-	result, err := srv.getResultMap(jsonData, target)
+func (srv *server) request(ctx context.Context, jsonData []byte, target string) ([]byte, error) {
+	result, err := srv.getResultMap(ctx, jsonData, target)
 	if err != nil {
 		return nil, err
 	}
@@ -105,7 +105,7 @@ func TestNewMutualTLSServer(t *testing.T) {
 func TestNewServerGroup(t *testing.T) {
 	s := NewServer("cfssl1.local:8888, cfssl2.local:8888, http://cfssl3.local:8888, http://cfssl4.local:8888")
 
-	ogl, ok := s.(*orderedListGroup)
+	ogl, ok := s.(*group)
 	if !ok {
 		t.Fatalf("expected NewServer to return an ordered group list with a list of servers, instead got a %T = %+v", ogl, ogl)
 	}
@@ -152,7 +152,7 @@ func TestNewMutualTLSServerGroup(t *testing.T) {
 func NewTLSServerGroup(t *testing.T, cert *tls.Certificate) {
 	s := NewServerTLS("https://cfssl1.local:8888, https://cfssl2.local:8888", helpers.CreateTLSConfig(nil, cert))
 
-	ogl, ok := s.(*orderedListGroup)
+	ogl, ok := s.(*group)
 	if !ok {
 		t.Fatalf("expected NewServer to return an ordered group list with a list of servers, instead got a %T = %+v", ogl, ogl)
 	}
@@ -192,9 +192,9 @@ func TestNewOGLGroup(t *testing.T) {
 		t.Fatalf("%v", err)
 	}
 
-	ogl, ok := rem.(*orderedListGroup)
+	ogl, ok := rem.(*group)
 	if !ok {
-		t.Fatalf("expected to get an orderedListGroup but got %T", rem)
+		t.Fatalf("expected to get a group but got %T", rem)
 	}
 
 	if len(ogl.remotes) != 2 {
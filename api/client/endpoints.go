@@ -0,0 +1,347 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	stderr "errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/whitelist"
+	"github.com/cloudflare/cfssl/auth"
+	"github.com/cloudflare/cfssl/certinfo"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/errors"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/info"
+	"github.com/cloudflare/cfssl/lint"
+	"github.com/cloudflare/cfssl/scan"
+)
+
+// A caller makes requests to any endpoint of a remote; both servers and
+// groups are callers.
+type caller interface {
+	call(ctx context.Context, method, endpoint string, query url.Values, jsonData []byte, result interface{}) (*api.Response, error)
+	fetch(ctx context.Context, method, endpoint string, query url.Values, contentType string, data []byte) ([]byte, error)
+}
+
+// A Client calls the endpoints of the CFSSL API on a remote, taking
+// and returning typed values. Errors reported by the remote are
+// returned as *errors.Error, with the code and message the remote gave.
+type Client struct {
+	remote   caller
+	provider auth.Provider
+}
+
+// NewClient returns a Client for a remote returned by this package,
+// such as by NewServerTLS or NewGroup. The provider authenticates
+// requests to the authsign and whitelist endpoints; if it is nil, that
+// of an AuthRemote is used.
+func NewClient(remote Remote, provider auth.Provider) (*Client, error) {
+	if ar, ok := remote.(*AuthRemote); ok {
+		if provider == nil {
+			provider = ar.provider
+		}
+		remote = ar.Remote
+	}
+	c, ok := remote.(caller)
+	if !ok {
+		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, stderr.New("unsupported remote"))
+	}
+	return &Client{remote: c, provider: provider}, nil
+}
+
+// post sends req, as JSON, to the endpoint, decoding the result into
+// result.
+func (c *Client) post(ctx context.Context, endpoint string, req, result interface{}) error {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(errors.APIClientError, errors.JSONError, err)
+	}
+	_, err = c.remote.call(ctx, "POST", endpoint, nil, jsonData, result)
+	return err
+}
+
+// get requests the endpoint with the query, decoding the result into
+// result.
+func (c *Client) get(ctx context.Context, endpoint string, query url.Values, result interface{}) error {
+	_, err := c.remote.call(ctx, "GET", endpoint, query, nil, result)
+	return err
+}
+
+// authPost sends req to the endpoint as an authenticated request.
+func (c *Client) authPost(ctx context.Context, endpoint string, req, result interface{}) error {
+	if c.provider == nil {
+		return errors.Wrap(errors.APIClientError, errors.AuthenticationFailure, stderr.New("no authentication provider"))
+	}
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(errors.APIClientError, errors.JSONError, err)
+	}
+	token, err := c.provider.Token(reqJSON)
+	if err != nil {
+		return errors.Wrap(errors.APIClientError, errors.AuthenticationFailure, err)
+	}
+	return c.post(ctx, endpoint, &auth.AuthenticatedRequest{
+		Timestamp: time.Now().Unix(),
+		Token:     token,
+		Request:   reqJSON,
+	}, result)
+}
+
+// Sign asks the remote to sign a certificate request.
+func (c *Client) Sign(ctx context.Context, req *SignRequest) (*SignResponse, error) {
+	resp := new(SignResponse)
+	if err := c.post(ctx, "sign", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// AuthSign asks the remote to sign a certificate request, authenticated
+// by the client's provider.
+func (c *Client) AuthSign(ctx context.Context, req *SignRequest) (*SignResponse, error) {
+	resp := new(SignResponse)
+	if err := c.authPost(ctx, "authsign", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Info returns the certificate and usages of a signer of the remote.
+func (c *Client) Info(ctx context.Context, req *info.Req) (*info.Resp, error) {
+	resp := new(info.Resp)
+	if err := c.post(ctx, "info", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CRL returns the DER-encoded CRL of the certificates revoked in the
+// remote's database, expiring after expiry, or the remote's default if
+// it is zero.
+func (c *Client) CRL(ctx context.Context, expiry time.Duration) ([]byte, error) {
+	query := url.Values{}
+	if expiry != 0 {
+		query.Set("expiry", expiry.String())
+	}
+	var crl []byte
+	if err := c.get(ctx, "crl", query, &crl); err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+// GenCRL returns a DER-encoded CRL generated by the remote.
+func (c *Client) GenCRL(ctx context.Context, req *GenCRLRequest) ([]byte, error) {
+	var crl []byte
+	if err := c.post(ctx, "gencrl", req, &crl); err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+// NewKey has the remote generate a key and certificate request.
+func (c *Client) NewKey(ctx context.Context, req *csr.CertificateRequest) (*NewKeyResponse, error) {
+	resp := new(NewKeyResponse)
+	if err := c.post(ctx, "newkey", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// NewCert has the remote generate a key and certificate request, and
+// sign the certificate.
+func (c *Client) NewCert(ctx context.Context, req *NewCertRequest) (*NewCertResponse, error) {
+	resp := new(NewCertResponse)
+	if err := c.post(ctx, "newcert", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// InitCA has the remote generate a key and self-signed CA certificate.
+func (c *Client) InitCA(ctx context.Context, req *csr.CertificateRequest) (*InitCAResponse, error) {
+	resp := new(InitCAResponse)
+	if err := c.post(ctx, "init_ca", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Bundle has the remote build the chain of a certificate.
+func (c *Client) Bundle(ctx context.Context, req *BundleRequest) (*Bundle, error) {
+	resp := new(Bundle)
+	if err := c.post(ctx, "bundle", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Scan has the remote scan a host, returning the results of each
+// scanner, by family.
+func (c *Client) Scan(ctx context.Context, req *ScanRequest) (map[string]scan.FamilyResult, error) {
+	query := url.Values{"host": {req.Host}}
+	for name, value := range map[string]string{
+		"ip":       req.IP,
+		"starttls": req.StartTLS,
+		"family":   req.Family,
+		"scanner":  req.Scanner,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if req.Timeout != 0 {
+		query.Set("timeout", req.Timeout.String())
+	}
+	var results map[string]scan.FamilyResult
+	if err := c.get(ctx, "scan", query, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ScanDiff returns the changes between the two most recent scans of a
+// host recorded by the remote.
+func (c *Client) ScanDiff(ctx context.Context, host string) (*scan.Diff, error) {
+	diff := new(scan.Diff)
+	if err := c.get(ctx, "scandiff", url.Values{"host": {host}}, diff); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// ScanInfo returns the families of scanners the remote can run.
+func (c *Client) ScanInfo(ctx context.Context) (scan.FamilySet, error) {
+	var families scan.FamilySet
+	if err := c.get(ctx, "scaninfo", nil, &families); err != nil {
+		return nil, err
+	}
+	return families, nil
+}
+
+// CertInfo has the remote describe a certificate.
+func (c *Client) CertInfo(ctx context.Context, req *CertInfoRequest) (*certinfo.Certificate, error) {
+	cert := new(certinfo.Certificate)
+	if err := c.post(ctx, "certinfo", req, cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// Lint has the remote lint each of the certificates in a request.
+func (c *Client) Lint(ctx context.Context, req *LintRequest) ([]lint.Report, error) {
+	var reports []lint.Report
+	if err := c.post(ctx, "lint", req, &reports); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// LintRules returns the lint rules of the remote.
+func (c *Client) LintRules(ctx context.Context) ([]*lint.Rule, error) {
+	var rules []*lint.Rule
+	if err := c.get(ctx, "lint", nil, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// OCSPSign returns a DER-encoded OCSP response signed by the remote.
+func (c *Client) OCSPSign(ctx context.Context, req *OCSPSignRequest) ([]byte, error) {
+	var resp struct {
+		OCSPResponse []byte `json:"ocspResponse"`
+	}
+	if err := c.post(ctx, "ocspsign", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.OCSPResponse, nil
+}
+
+// Revoke has the remote revoke a certificate in its database.
+func (c *Client) Revoke(ctx context.Context, req *RevokeRequest) error {
+	return c.post(ctx, "revoke", req, &map[string]interface{}{})
+}
+
+// CertAdd has the remote add a certificate to its database, returning
+// the DER-encoded OCSP response it signed for it, if any. The certadd
+// endpoint isn't served by 'cfssl serve', only by servers built with
+// the api/certadd package.
+func (c *Client) CertAdd(ctx context.Context, req *CertAddRequest) ([]byte, error) {
+	var resp struct {
+		OCSPResponse []byte `json:"ocsp_response"`
+	}
+	if err := c.post(ctx, "certadd", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.OCSPResponse, nil
+}
+
+// Whitelist lists, changes or shows the history of the remote's
+// whitelists, authenticated by the client's provider. The request's
+// timestamp is set to the current time if it is zero, and its nonce
+// to a random one if it is empty, as the remote only accepts each
+// request once.
+func (c *Client) Whitelist(ctx context.Context, req *whitelist.Request) (*whitelist.Response, error) {
+	r := *req
+	if r.Timestamp == 0 {
+		r.Timestamp = time.Now().Unix()
+	}
+	if r.Nonce == "" {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, err)
+		}
+		r.Nonce = hex.EncodeToString(nonce)
+	}
+	req = &r
+	resp := new(whitelist.Response)
+	if err := c.authPost(ctx, "whitelist", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// scep requests a SCEP operation from the remote.
+func (c *Client) scep(ctx context.Context, operation string, message []byte) ([]byte, error) {
+	query := url.Values{"operation": {operation}}
+	if message == nil {
+		return c.remote.fetch(ctx, "GET", "scep", query, "", nil)
+	}
+	return c.remote.fetch(ctx, "POST", "scep", query, "application/x-pki-message", message)
+}
+
+// SCEPCACaps returns the capabilities of the remote's SCEP responder.
+func (c *Client) SCEPCACaps(ctx context.Context) ([]string, error) {
+	body, err := c.scep(ctx, "GetCACaps", nil)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(body)), nil
+}
+
+// SCEPCACert returns the certificates of the remote's SCEP responder:
+// the CA, preceded by the RA if it is a different certificate.
+func (c *Client) SCEPCACert(ctx context.Context) ([]*x509.Certificate, error) {
+	body, err := c.scep(ctx, "GetCACert", nil)
+	if err != nil {
+		return nil, err
+	}
+	certs, _, err := helpers.ParseCertificatesDER(body, "")
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// SCEPPKIOperation sends a DER-encoded SCEP message, such as a PKCSReq,
+// to the remote, returning its DER-encoded CertRep.
+func (c *Client) SCEPPKIOperation(ctx context.Context, message []byte) ([]byte, error) {
+	return c.scep(ctx, "PKIOperation", message)
+}
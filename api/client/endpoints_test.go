@@ -0,0 +1,496 @@
+package client_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/api/certadd"
+	"github.com/cloudflare/cfssl/api/certinfo"
+	"github.com/cloudflare/cfssl/api/client"
+	"github.com/cloudflare/cfssl/api/crl"
+	"github.com/cloudflare/cfssl/api/gencrl"
+	"github.com/cloudflare/cfssl/api/generator"
+	apiinfo "github.com/cloudflare/cfssl/api/info"
+	"github.com/cloudflare/cfssl/api/initca"
+	apilint "github.com/cloudflare/cfssl/api/lint"
+	apiocsp "github.com/cloudflare/cfssl/api/ocsp"
+	"github.com/cloudflare/cfssl/api/revoke"
+	apiscan "github.com/cloudflare/cfssl/api/scan"
+	"github.com/cloudflare/cfssl/api/signhandler"
+	apiwhitelist "github.com/cloudflare/cfssl/api/whitelist"
+	"github.com/cloudflare/cfssl/auth"
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/certdb/sql"
+	"github.com/cloudflare/cfssl/certdb/testdb"
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/errors"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/info"
+	cfinitca "github.com/cloudflare/cfssl/initca"
+	"github.com/cloudflare/cfssl/ocsp"
+	"github.com/cloudflare/cfssl/scan"
+	"github.com/cloudflare/cfssl/scep"
+	"github.com/cloudflare/cfssl/signer/local"
+	"github.com/cloudflare/cfssl/whitelist/admin"
+
+	stdocsp "golang.org/x/crypto/ocsp"
+)
+
+const testKey = "0123456789ABCDEF0123456789ABCDEF"
+
+// A testAPI serves the real handlers of the endpoints, with a CA
+// generated for the test, until stopped.
+type testAPI struct {
+	ca       *x509.Certificate
+	caPEM    string
+	caKeyPEM string
+	db       certdb.Accessor
+	url      string
+	c        *client.Client
+	stop     func()
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	caPEM, _, caKeyPEM, err := cfinitca.New(&csr.CertificateRequest{
+		CN:         "client test CA",
+		KeyRequest: &csr.BasicKeyRequest{A: "rsa", S: 2048},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := helpers.ParseCertificatePEM(caPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKey, err := helpers.ParsePrivateKeyPEM(caKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			os.RemoveAll(dir)
+		}
+	}()
+	caFile, caKeyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	if err = ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(caKeyFile, caKeyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := auth.New(testKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := func() *config.SigningProfile {
+		return &config.SigningProfile{
+			Usage:        []string{"signing", "key encipherment", "server auth"},
+			Expiry:       time.Hour,
+			ExpiryString: "1h",
+		}
+	}
+	policy := &config.Signing{Default: profile(), Profiles: map[string]*config.SigningProfile{"auth": profile()}}
+	policy.Profiles["auth"].Provider = provider
+	s, err := local.NewSigner(caKey, ca, x509.SHA256WithRSA, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ocspSigner, err := ocsp.NewSigner(ca, ca, caKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The checked-in database doesn't record scan results.
+	sqlDB := testdb.MigratedSQLiteDB(filepath.Join(dir, "certdb.db"), "../../certdb/sqlite/migrations")
+	db := sql.NewAccessor(sqlDB)
+	store, err := admin.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	whitelists := admin.NewManager(store)
+	if _, err = whitelists.Register("serve", nil); err != nil {
+		t.Fatal(err)
+	}
+	responder, err := scep.NewResponder(s, ca, caKey, scep.StaticChallenge("challenge"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	must := func(h http.Handler, err error) http.Handler {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	signHandler, err := signhandler.NewHandlerFromSigner(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	for endpoint, h := range map[string]http.Handler{
+		"sign":      signHandler,
+		"authsign":  must(signhandler.NewAuthHandlerFromSigner(s)),
+		"info":      must(apiinfo.NewHandler(s)),
+		"crl":       must(crl.NewHandler(db, caFile, caKeyFile)),
+		"gencrl":    gencrl.NewHandler(),
+		"newcert":   generator.NewCertGeneratorHandlerFromSigner(generator.CSRValidate, s),
+		"newkey":    must(generator.NewHandler(generator.CSRValidate)),
+		"init_ca":   initca.NewHandler(),
+		"scan":      must(apiscan.NewHandler("")),
+		"scandiff":  apiscan.NewDiffHandler(db),
+		"scaninfo":  apiscan.NewInfoHandler(),
+		"certinfo":  certinfo.NewHandler(),
+		"lint":      apilint.NewHandler(),
+		"ocspsign":  apiocsp.NewHandler(ocspSigner),
+		"revoke":    revoke.NewHandler(db),
+		"certadd":   certadd.NewHandler(db, ocspSigner),
+		"scep":      responder,
+		"whitelist": apiwhitelist.NewHandler(whitelists, provider),
+	} {
+		mux.Handle("/api/v1/cfssl/"+endpoint, h)
+	}
+
+	ts := httptest.NewServer(mux)
+	stop := func() {
+		ts.Close()
+		sqlDB.Close()
+		os.RemoveAll(dir)
+	}
+	c, err := client.NewClient(client.NewServer(ts.URL), provider)
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	ok = true
+	return &testAPI{ca: ca, caPEM: string(caPEM), caKeyPEM: string(caKeyPEM), db: db, url: ts.URL, c: c, stop: stop}
+}
+
+// newCert has the API generate a key and request, and sign it.
+func (a *testAPI) newCert(t *testing.T) *x509.Certificate {
+	ctx := context.Background()
+	key, err := a.c.NewKey(ctx, &csr.CertificateRequest{
+		CN:         "client.test",
+		Hosts:      []string{"client.test"},
+		KeyRequest: csr.NewBasicKeyRequest(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.PrivateKey == "" || key.Sums["certificate_request"].SHA1 == "" {
+		t.Fatalf("unexpected newkey response %+v", key)
+	}
+
+	resp, err := a.c.Sign(ctx, &client.SignRequest{Request: key.CertificateRequest, Hosts: []string{"client.test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM([]byte(resp.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestSignEndpoints(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.stop()
+	ctx := context.Background()
+
+	cert := a.newCert(t)
+	if err := cert.CheckSignatureFrom(a.ca); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := a.c.NewCert(ctx, &client.NewCertRequest{Request: &csr.CertificateRequest{
+		CN:         "newcert.test",
+		Hosts:      []string{"newcert.test"},
+		KeyRequest: csr.NewBasicKeyRequest(),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Certificate == "" || resp.PrivateKey == "" || resp.Sums["certificate"].MD5 == "" {
+		t.Fatalf("unexpected newcert response %+v", resp)
+	}
+
+	// Profiles requiring authentication are only signed by authsign.
+	req := &client.SignRequest{Request: resp.CertificateRequest, Profile: "auth"}
+	if _, err = a.c.Sign(ctx, req); err == nil {
+		t.Fatal("expected sign to refuse an authenticated profile")
+	}
+	if _, err = a.c.AuthSign(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	wrong, _ := auth.New("00000000000000000000000000000000", nil)
+	c, _ := client.NewClient(client.NewAuthServer(a.url, nil, wrong), nil)
+	if _, err = c.AuthSign(ctx, req); err == nil {
+		t.Fatal("expected authsign to fail with the wrong key")
+	}
+
+	infoResp, err := a.c.Info(ctx, &info.Req{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infoResp.Certificate != strings.TrimSpace(a.caPEM) || infoResp.ExpiryString != "1h" {
+		t.Fatalf("unexpected info response %+v", infoResp)
+	}
+
+	ca, err := a.c.InitCA(ctx, &csr.CertificateRequest{CN: "init_ca.test", KeyRequest: csr.NewBasicKeyRequest()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caCert, err := helpers.ParseCertificatePEM([]byte(ca.Certificate)); err != nil || !caCert.IsCA {
+		t.Fatalf("unexpected init_ca certificate: %v", err)
+	}
+}
+
+func TestCertificateEndpoints(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.stop()
+	ctx := context.Background()
+	cert := a.newCert(t)
+	certPEM := string(helpers.EncodeCertificatePEM(cert))
+
+	ci, err := a.c.CertInfo(ctx, &client.CertInfoRequest{Certificate: certPEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ci.Subject.CommonName != "client.test" || ci.Issuer.CommonName != "client test CA" {
+		t.Fatalf("unexpected certinfo response %+v", ci)
+	}
+
+	reports, err := a.c.Lint(ctx, &client.LintRequest{Certificate: certPEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Subject == "" {
+		t.Fatalf("unexpected lint reports %+v", reports)
+	}
+	if rules, err := a.c.LintRules(ctx); err != nil || len(rules) == 0 {
+		t.Fatalf("no lint rules: %v", err)
+	}
+	if _, err = a.c.Lint(ctx, &client.LintRequest{Certificate: certPEM, IgnoredLints: []string{"e_unknown"}}); err == nil {
+		t.Fatal("expected failure for an unknown lint")
+	}
+
+	ocspResp, err := a.c.OCSPSign(ctx, &client.OCSPSignRequest{Certificate: certPEM, Status: "good"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := stdocsp.ParseResponse(ocspResp, a.ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Status != stdocsp.Good || parsed.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Fatalf("unexpected OCSP response %+v", parsed)
+	}
+
+	crlDER, err := a.c.GenCRL(ctx, &client.GenCRLRequest{
+		Certificate:   a.caPEM,
+		SerialNumbers: []string{cert.SerialNumber.String()},
+		PrivateKey:    a.caKeyPEM,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := x509.ParseCRL(crlDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked := list.TBSCertList.RevokedCertificates
+	if len(revoked) != 1 || revoked[0].SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Fatalf("unexpected CRL entries %+v", revoked)
+	}
+}
+
+func TestDatabaseEndpoints(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.stop()
+	ctx := context.Background()
+	cert := a.newCert(t)
+
+	req := &client.CertAddRequest{
+		Serial: cert.SerialNumber.Text(16),
+		AKI:    hex.EncodeToString(cert.AuthorityKeyId),
+		Status: "good",
+		Expiry: cert.NotAfter,
+		PEM:    string(helpers.EncodeCertificatePEM(cert)),
+	}
+	ocspResp, err := a.c.CertAdd(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stdocsp.ParseResponse(ocspResp, a.ca); err != nil {
+		t.Fatal(err)
+	}
+
+	err = a.c.Revoke(ctx, &client.RevokeRequest{Serial: req.Serial, AKI: req.AKI, Reason: "keyCompromise"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlDER, err := a.c.CRL(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := x509.ParseCRL(crlDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = a.ca.CheckCRLSignature(list); err != nil {
+		t.Fatal(err)
+	}
+	if list.TBSCertList.NextUpdate.After(time.Now().Add(2 * time.Hour)) {
+		t.Fatalf("CRL expires at %v, expected within the hour", list.TBSCertList.NextUpdate)
+	}
+	if err = a.c.Revoke(ctx, &client.RevokeRequest{Serial: req.Serial, Reason: "no such reason"}); err == nil {
+		t.Fatal("expected failure with an invalid reason")
+	}
+
+	host := "diff.client.test:443"
+	for _, grade := range []string{"Good", "Bad"} {
+		err = scan.SaveRun(a.db, host, time.Now(), map[string]scan.FamilyResult{
+			"TLSSession": {"SessionResume": {Grade: grade}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	diff, err := a.c.ScanDiff(ctx, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.GradeChanges) != 1 || diff.GradeChanges[0].To != "Bad" {
+		t.Fatalf("unexpected scan diff %+v", diff)
+	}
+}
+
+func TestScanEndpoints(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.stop()
+	ctx := context.Background()
+
+	families, err := a.c.ScanInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if families["Connectivity"] == nil || families["Connectivity"].Scanners["DNSLookup"] == nil {
+		t.Fatalf("unexpected scan families %+v", families)
+	}
+
+	_, err = a.c.Scan(ctx, &client.ScanRequest{Host: "example.com", Timeout: time.Hour})
+	cferr, ok := err.(*errors.Error)
+	if !ok || !strings.Contains(cferr.Message, "invalid timeout") {
+		t.Fatalf("expected the server's error, got %v", err)
+	}
+}
+
+func TestSCEPEndpoint(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.stop()
+	ctx := context.Background()
+
+	caps, err := a.c.SCEPCACaps(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caps) == 0 {
+		t.Fatal("no SCEP capabilities")
+	}
+	certs, err := a.c.SCEPCACert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(a.ca) {
+		t.Fatalf("unexpected SCEP CA certificates %v", certs)
+	}
+	if _, err = a.c.SCEPPKIOperation(ctx, []byte("not a message")); err == nil {
+		t.Fatal("expected a malformed message to fail")
+	}
+}
+
+func TestWhitelistEndpoint(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.stop()
+	ctx := context.Background()
+
+	resp, err := a.c.Whitelist(ctx, &apiwhitelist.Request{
+		Whitelist: "serve",
+		Action:    apiwhitelist.ActionAdd,
+		Entries:   []string{"10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := resp.Whitelists["serve"]; len(entries) != 1 || entries[0] != "10.0.0.0/8" {
+		t.Fatalf("unexpected whitelist response %+v", resp)
+	}
+	resp, err = a.c.Whitelist(ctx, &apiwhitelist.Request{Whitelist: "serve", Action: apiwhitelist.ActionHistory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.History) != 1 || resp.History[0].Action != admin.ActionAdd {
+		t.Fatalf("unexpected whitelist history %+v", resp.History)
+	}
+
+	// Requests are only accepted once, so the client gives each a
+	// nonce, unless it has one.
+	list := &apiwhitelist.Request{Action: apiwhitelist.ActionList, Timestamp: time.Now().Unix()}
+	for i := 0; i < 2; i++ {
+		if _, err = a.c.Whitelist(ctx, list); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if list.Nonce != "" {
+		t.Fatal("the client changed the caller's request")
+	}
+	list.Nonce = "fixed"
+	if _, err = a.c.Whitelist(ctx, list); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.c.Whitelist(ctx, list); err == nil {
+		t.Fatal("expected a repeated request to be rejected")
+	}
+
+	c, _ := client.NewClient(client.NewServer(a.url), nil)
+	if _, err = c.Whitelist(ctx, &apiwhitelist.Request{Action: apiwhitelist.ActionList}); err == nil {
+		t.Fatal("expected failure without an authentication provider")
+	}
+}
+
+func TestGroupClient(t *testing.T) {
+	a := newTestAPI(t)
+	defer a.stop()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	g, err := client.NewGroup([]string{down.URL, a.url}, nil, client.StrategyOrderedList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.NewClient(g, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.LintRules(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
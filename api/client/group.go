@@ -1,14 +1,22 @@
 package client
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
-	"errors"
+	"encoding/binary"
+	stderr "errors"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/cloudflare/cfssl/api"
 	"github.com/cloudflare/cfssl/auth"
+	"github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/info"
 )
 
@@ -26,13 +34,32 @@ const (
 	// client will proceed in this manner until the list of
 	// servers is exhausted, and then an error is returned.
 	StrategyOrderedList
+
+	// StrategyRoundRobin starts each request with the server after
+	// the one the previous request started with, spreading requests
+	// evenly over the servers.
+	StrategyRoundRobin
+
+	// StrategyLeastLatency starts each request with the server that
+	// has answered fastest recently. Servers that haven't answered
+	// yet are tried first, so that their latency is measured.
+	StrategyLeastLatency
+
+	// StrategyConsistentHash starts each request with the server
+	// its content hashes to, so that identical requests go to the
+	// same server, and few move when a server is added or removed.
+	StrategyConsistentHash
 )
 
 var strategyStrings = map[string]Strategy{
-	"ordered_list": StrategyOrderedList,
+	"ordered_list":    StrategyOrderedList,
+	"round_robin":     StrategyRoundRobin,
+	"least_latency":   StrategyLeastLatency,
+	"consistent_hash": StrategyConsistentHash,
 }
 
-// StrategyFromString takes a string describing a
+// StrategyFromString takes a string describing a strategy, and returns
+// it, or StrategyInvalid if it isn't known.
 func StrategyFromString(s string) Strategy {
 	s = strings.TrimSpace(strings.ToLower(s))
 	strategy, ok := strategyStrings[s]
@@ -42,9 +69,60 @@ func StrategyFromString(s string) Strategy {
 	return strategy
 }
 
+// ringReplicas is the number of points each server has on the ring of
+// a consistent hashing group.
+const ringReplicas = 100
+
+// A GroupOption configures a group of remotes.
+type GroupOption func(*group)
+
+// WithCircuitBreaker makes the group avoid a server after threshold
+// consecutive failures, for a backoff that starts at interval and
+// doubles up to max each time the server fails again. Without it,
+// servers are never avoided.
+func WithCircuitBreaker(threshold int, interval, max time.Duration) GroupOption {
+	return func(g *group) {
+		for _, srv := range g.remotes {
+			srv.health = newHealth(threshold, interval, max)
+		}
+	}
+}
+
+// WithRetryBudget sets the retry budget of the group: each call adds
+// ratio to the budget, up to max, and each retry on another server
+// spends one from it. Without it, retries are unlimited.
+func WithRetryBudget(ratio float64, max int) GroupOption {
+	return func(g *group) {
+		g.budget = newRetryBudget(ratio, max)
+	}
+}
+
+// WithHealthCheck makes the group send an info request to each server
+// at every interval, until ctx is done, so that failed servers are
+// noticed, and recovered ones used again, without waiting for
+// requests to fail.
+func WithHealthCheck(ctx context.Context, interval time.Duration) GroupOption {
+	return func(g *group) {
+		g.checkCtx = ctx
+		g.checkInterval = interval
+	}
+}
+
+// SignerGroupOptions returns the options of the groups that remote
+// signers and transport CAs send requests to: a circuit breaker and a
+// retry budget with the default parameters and, if healthCheck is
+// positive, health checks at that interval until ctx is done.
+func SignerGroupOptions(ctx context.Context, healthCheck time.Duration) []GroupOption {
+	return []GroupOption{
+		WithCircuitBreaker(DefaultFailureThreshold, DefaultBackoffInterval, DefaultBackoffMax),
+		WithRetryBudget(DefaultRetryRatio, DefaultRetryMax),
+		WithHealthCheck(ctx, healthCheck),
+	}
+}
+
 // NewGroup will use the collection of remotes specified with the
 // given strategy.
-func NewGroup(remotes []string, tlsConfig *tls.Config, strategy Strategy) (Remote, error) {
+func NewGroup(remotes []string, tlsConfig *tls.Config, strategy Strategy, opts ...GroupOption) (Remote, error) {
 	var servers = make([]*server, len(remotes))
 	for i := range remotes {
 		u, err := normalizeURL(remotes[i])
@@ -55,18 +133,145 @@ func NewGroup(remotes []string, tlsConfig *tls.Config, strategy Strategy) (Remot
 	}
 
 	switch strategy {
-	case StrategyOrderedList:
-		return newOrdererdListGroup(servers)
+	case StrategyOrderedList, StrategyRoundRobin, StrategyLeastLatency, StrategyConsistentHash:
+	default:
+		return nil, stderr.New("unrecognised strategy")
+	}
+
+	g := &group{
+		remotes:  servers,
+		strategy: strategy,
+	}
+	if strategy == StrategyConsistentHash {
+		g.buildRing()
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.checkCtx != nil && g.checkInterval > 0 {
+		go g.checkHealth()
+	}
+	return g, nil
+}
+
+// A group sends requests to the first of its servers that answers,
+// in an order chosen by its strategy, skipping those whose circuit is
+// open. An ordered list retries any failed request on the next server,
+// while the other strategies only retry those where the server failed,
+// rather than the request; either way, only as the retry budget allows.
+type group struct {
+	remotes  []*server
+	strategy Strategy
+	budget   *retryBudget
+
+	// next is the index of the server the next round-robin request
+	// starts with.
+	next uint32
+
+	// ring holds the points of the servers on the hash ring, in
+	// order, and owners the index of the server owning each point.
+	ring   []uint32
+	owners []int
+
+	checkCtx      context.Context
+	checkInterval time.Duration
+}
+
+// hashKey places a key on the hash ring.
+func hashKey(key []byte) uint32 {
+	sum := sha256.Sum256(key)
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func (g *group) buildRing() {
+	type point struct {
+		hash  uint32
+		owner int
+	}
+	var points []point
+	for i, srv := range g.remotes {
+		for r := 0; r < ringReplicas; r++ {
+			points = append(points, point{hashKey([]byte(srv.URL + "#" + strconv.Itoa(r))), i})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	g.ring = make([]uint32, len(points))
+	g.owners = make([]int, len(points))
+	for i, p := range points {
+		g.ring[i], g.owners[i] = p.hash, p.owner
+	}
+}
+
+// order returns the servers in the order the strategy tries them for a
+// request with the given content.
+func (g *group) order(key []byte) []*server {
+	n := len(g.remotes)
+	servers := make([]*server, 0, n)
+	switch g.strategy {
+	case StrategyRoundRobin:
+		start := int((atomic.AddUint32(&g.next, 1) - 1) % uint32(n))
+		for i := 0; i < n; i++ {
+			servers = append(servers, g.remotes[(start+i)%n])
+		}
+	case StrategyLeastLatency:
+		servers = append(servers, g.remotes...)
+		latencies := make(map[*server]time.Duration, n)
+		for _, srv := range servers {
+			latencies[srv] = srv.health.averageLatency()
+		}
+		sort.SliceStable(servers, func(i, j int) bool {
+			return latencies[servers[i]] < latencies[servers[j]]
+		})
+	case StrategyConsistentHash:
+		h := hashKey(key)
+		start := sort.Search(len(g.ring), func(i int) bool { return g.ring[i] >= h })
+		seen := make([]bool, n)
+		for i := 0; i < len(g.ring) && len(servers) < n; i++ {
+			owner := g.owners[(start+i)%len(g.ring)]
+			if !seen[owner] {
+				seen[owner] = true
+				servers = append(servers, g.remotes[owner])
+			}
+		}
 	default:
-		return nil, errors.New("unrecognised strategy")
+		servers = append(servers, g.remotes...)
 	}
+	return servers
 }
 
-type orderedListGroup struct {
-	remotes []*server
+// do calls f on the servers in order until one succeeds, the request
+// itself fails (unless the group is an ordered list), ctx is done, or
+// the retry budget is spent.
+func (g *group) do(ctx context.Context, key []byte, f func(context.Context, *server) error) error {
+	g.budget.deposit()
+
+	var err error
+	tried := false
+	now := time.Now()
+	for _, srv := range g.order(key) {
+		if !srv.health.available(now) {
+			continue
+		}
+		if tried && !g.budget.withdraw() {
+			break
+		}
+		tried = true
+
+		a := &attempt{}
+		err = f(context.WithValue(ctx, attemptKey{}, a), srv)
+		if err == nil || ctx.Err() != nil || (!a.failed && g.strategy != StrategyOrderedList) {
+			return err
+		}
+	}
+
+	if !tried {
+		return errors.Wrap(errors.APIClientError, errors.ClientHTTPError, stderr.New("no remote is available"))
+	}
+	return err
 }
 
-func (g *orderedListGroup) Hosts() []string {
+func (g *group) Hosts() []string {
 	var hosts = make([]string, 0, len(g.remotes))
 	for _, srv := range g.remotes {
 		srvHosts := srv.Hosts()
@@ -75,58 +280,100 @@ func (g *orderedListGroup) Hosts() []string {
 	return hosts
 }
 
-func (g *orderedListGroup) SetRequestTimeout(timeout time.Duration) {
+func (g *group) SetRequestTimeout(timeout time.Duration) {
 	for _, srv := range g.remotes {
 		srv.SetRequestTimeout(timeout)
 	}
 }
 
-func (g *orderedListGroup) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
+func (g *group) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
 	for _, srv := range g.remotes {
 		srv.SetProxy(proxy)
 	}
 }
 
-func newOrdererdListGroup(remotes []*server) (Remote, error) {
-	return &orderedListGroup{
-		remotes: remotes,
-	}, nil
+func (g *group) AuthSign(req, id []byte, provider auth.Provider) ([]byte, error) {
+	return g.AuthSignContext(context.Background(), req, id, provider)
 }
 
-func (g *orderedListGroup) AuthSign(req, id []byte, provider auth.Provider) (resp []byte, err error) {
-	for i := range g.remotes {
-		resp, err = g.remotes[i].AuthSign(req, id, provider)
-		if err == nil {
-			return resp, nil
-		}
-	}
+// AuthSignContext is AuthSign, bounded by ctx.
+func (g *group) AuthSignContext(ctx context.Context, req, id []byte, provider auth.Provider) (resp []byte, err error) {
+	err = g.do(ctx, req, func(ctx context.Context, srv *server) (err error) {
+		resp, err = srv.AuthSignContext(ctx, req, id, provider)
+		return err
+	})
+	return resp, err
+}
 
-	return nil, err
+func (g *group) Sign(jsonData []byte) ([]byte, error) {
+	return g.SignContext(context.Background(), jsonData)
 }
 
-func (g *orderedListGroup) Sign(jsonData []byte) (resp []byte, err error) {
-	for i := range g.remotes {
-		resp, err = g.remotes[i].Sign(jsonData)
-		if err == nil {
-			return resp, nil
-		}
-	}
+// SignContext is Sign, bounded by ctx.
+func (g *group) SignContext(ctx context.Context, jsonData []byte) (resp []byte, err error) {
+	err = g.do(ctx, jsonData, func(ctx context.Context, srv *server) (err error) {
+		resp, err = srv.SignContext(ctx, jsonData)
+		return err
+	})
+	return resp, err
+}
 
-	return nil, err
+func (g *group) Info(jsonData []byte) (*info.Resp, error) {
+	return g.InfoContext(context.Background(), jsonData)
 }
 
-func (g *orderedListGroup) Info(jsonData []byte) (resp *info.Resp, err error) {
-	for i := range g.remotes {
-		resp, err = g.remotes[i].Info(jsonData)
-		if err == nil {
-			return resp, nil
-		}
-	}
+// InfoContext is Info, bounded by ctx.
+func (g *group) InfoContext(ctx context.Context, jsonData []byte) (resp *info.Resp, err error) {
+	err = g.do(ctx, jsonData, func(ctx context.Context, srv *server) (err error) {
+		resp, err = srv.InfoContext(ctx, jsonData)
+		return err
+	})
+	return resp, err
+}
 
-	return nil, err
+func (g *group) call(ctx context.Context, method, endpoint string, query url.Values, jsonData []byte, result interface{}) (resp *api.Response, err error) {
+	err = g.do(ctx, requestKey(query, jsonData), func(ctx context.Context, srv *server) (err error) {
+		resp, err = srv.call(ctx, method, endpoint, query, jsonData, result)
+		return err
+	})
+	return resp, err
+}
+
+func (g *group) fetch(ctx context.Context, method, endpoint string, query url.Values, contentType string, data []byte) (body []byte, err error) {
+	err = g.do(ctx, requestKey(query, data), func(ctx context.Context, srv *server) (err error) {
+		body, err = srv.fetch(ctx, method, endpoint, query, contentType, data)
+		return err
+	})
+	return body, err
+}
+
+// requestKey returns the content of a request, which places it on the
+// hash ring: its body, or its query if it has none.
+func requestKey(query url.Values, body []byte) []byte {
+	if len(body) > 0 {
+		return body
+	}
+	return []byte(query.Encode())
 }
 
 // SetReqModifier does nothing because there is no request modifier for group
-func (g *orderedListGroup) SetReqModifier(mod func(*http.Request, []byte)) {
+func (g *group) SetReqModifier(mod func(*http.Request, []byte)) {
 	// noop
 }
+
+// checkHealth checks the health of each server at every interval until
+// the group's check context is done.
+func (g *group) checkHealth() {
+	ticker := time.NewTicker(g.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.checkCtx.Done():
+			return
+		case <-ticker.C:
+			for _, srv := range g.remotes {
+				srv.checkHealth(g.checkCtx, g.checkInterval)
+			}
+		}
+	}
+}
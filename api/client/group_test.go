@@ -0,0 +1,300 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/api"
+)
+
+// testRemote is a server answering sign requests with its name, after
+// a delay, or failing with its status if that isn't 200.
+type testRemote struct {
+	name     string
+	delay    time.Duration
+	status   int32
+	requests int32
+	srv      *httptest.Server
+}
+
+func newTestRemote(name string) *testRemote {
+	r := &testRemote{name: name, status: http.StatusOK}
+	r.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&r.requests, 1)
+		time.Sleep(r.delay)
+		if status := int(atomic.LoadInt32(&r.status)); status != http.StatusOK {
+			w.WriteHeader(status)
+			api.SendResponse(w, nil)
+			return
+		}
+		api.SendResponse(w, map[string]string{"certificate": r.name})
+	}))
+	return r
+}
+
+// newTestRemotes starts a remote for each name, returning them with a
+// function stopping them all.
+func newTestRemotes(names ...string) ([]*testRemote, func()) {
+	var remotes []*testRemote
+	for _, name := range names {
+		remotes = append(remotes, newTestRemote(name))
+	}
+	return remotes, func() {
+		for _, r := range remotes {
+			r.srv.Close()
+		}
+	}
+}
+
+func (r *testRemote) count() int {
+	return int(atomic.LoadInt32(&r.requests))
+}
+
+func newTestGroup(t *testing.T, strategy Strategy, remotes []*testRemote, opts ...GroupOption) *group {
+	var urls []string
+	for _, r := range remotes {
+		urls = append(urls, r.srv.URL)
+	}
+	g, err := NewGroup(urls, nil, strategy, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g.(*group)
+}
+
+func sign(t *testing.T, g *group, req string) string {
+	cert, err := g.Sign([]byte(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(cert)
+}
+
+func TestStrategyFromString(t *testing.T) {
+	for s, strategy := range map[string]Strategy{
+		"ordered_list":       StrategyOrderedList,
+		" Round_Robin ":      StrategyRoundRobin,
+		"least_latency":      StrategyLeastLatency,
+		"consistent_hash":    StrategyConsistentHash,
+		"random":             StrategyInvalid,
+		"":                   StrategyInvalid,
+		"consistent-hashing": StrategyInvalid,
+	} {
+		if StrategyFromString(s) != strategy {
+			t.Fatalf("StrategyFromString(%q) != %d", s, strategy)
+		}
+	}
+
+	if _, err := NewGroup([]string{"ca1.local", "ca2.local"}, nil, StrategyInvalid); err == nil {
+		t.Fatal("expected failure with an invalid strategy")
+	}
+	if _, err := NewServerTLSWithStrategy("ca1.local,ca2.local", nil, "random"); err == nil {
+		t.Fatal("expected failure with an unknown strategy")
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	remotes, stop := newTestRemotes("a", "b", "c")
+	defer stop()
+	g := newTestGroup(t, StrategyRoundRobin, remotes)
+	for i := 0; i < 6; i++ {
+		sign(t, g, "request")
+	}
+	for _, r := range remotes {
+		if r.count() != 2 {
+			t.Fatalf("%s received %d requests, expected 2", r.name, r.count())
+		}
+	}
+}
+
+func TestLeastLatency(t *testing.T) {
+	remotes, stop := newTestRemotes("slow", "fast")
+	defer stop()
+	remotes[0].delay = 50 * time.Millisecond
+	g := newTestGroup(t, StrategyLeastLatency, remotes)
+
+	// The first two requests measure both remotes.
+	sign(t, g, "request")
+	sign(t, g, "request")
+	for i := 0; i < 3; i++ {
+		if name := sign(t, g, "request"); name != "fast" {
+			t.Fatalf("request went to %s", name)
+		}
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	remotes, stop := newTestRemotes("a", "b", "c")
+	defer stop()
+	g := newTestGroup(t, StrategyConsistentHash, remotes)
+
+	names := map[string]bool{}
+	for i := 0; i < 30; i++ {
+		req := "request " + strconv.Itoa(i)
+		name := sign(t, g, req)
+		if again := sign(t, g, req); again != name {
+			t.Fatalf("%s went to %s, then %s", req, name, again)
+		}
+		names[name] = true
+	}
+	if len(names) != 3 {
+		t.Fatalf("requests only went to %v", names)
+	}
+
+	// Requests move to another remote while theirs is down.
+	name := sign(t, g, "request 0")
+	for _, r := range remotes {
+		if r.name == name {
+			atomic.StoreInt32(&r.status, http.StatusServiceUnavailable)
+		}
+	}
+	if other := sign(t, g, "request 0"); other == name {
+		t.Fatal("request wasn't moved to another remote")
+	}
+}
+
+func TestOrderedList(t *testing.T) {
+	remotes, stop := newTestRemotes("down", "bad", "up")
+	defer stop()
+	down, bad, up := remotes[0], remotes[1], remotes[2]
+	down.status = http.StatusServiceUnavailable
+	bad.status = http.StatusBadRequest
+	g := newTestGroup(t, StrategyOrderedList, remotes)
+
+	// Any error fails over to the next remote, and without a circuit
+	// breaker or retry budget, every remote is tried each time.
+	for i := 0; i < 5; i++ {
+		if name := sign(t, g, "request"); name != "up" {
+			t.Fatalf("request went to %s", name)
+		}
+	}
+	for _, r := range []*testRemote{down, bad, up} {
+		if r.count() != 5 {
+			t.Fatalf("%s received %d requests, expected 5", r.name, r.count())
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	remotes, stop := newTestRemotes("down", "up")
+	defer stop()
+	down, up := remotes[0], remotes[1]
+	down.status = http.StatusServiceUnavailable
+	g := newTestGroup(t, StrategyOrderedList, remotes,
+		WithCircuitBreaker(2, time.Hour, time.Hour))
+
+	for i := 0; i < 5; i++ {
+		if name := sign(t, g, "request"); name != "up" {
+			t.Fatalf("request went to %s", name)
+		}
+	}
+	if down.count() != 2 {
+		t.Fatalf("failing remote received %d requests, expected its circuit to open after 2", down.count())
+	}
+
+	// With every circuit open, requests fail without being sent.
+	atomic.StoreInt32(&up.status, http.StatusServiceUnavailable)
+	g.Sign([]byte("request"))
+	g.Sign([]byte("request"))
+	before := up.count()
+	if _, err := g.Sign([]byte("request")); err == nil || up.count() != before {
+		t.Fatal("request was sent to a remote whose circuit is open")
+	}
+}
+
+func TestNoRetryOnBadRequest(t *testing.T) {
+	remotes, stop := newTestRemotes("bad", "good")
+	defer stop()
+	bad, good := remotes[0], remotes[1]
+	bad.status = http.StatusBadRequest
+	g := newTestGroup(t, StrategyRoundRobin, remotes,
+		WithCircuitBreaker(1, time.Hour, time.Hour))
+
+	// Requests alternate between starting with the bad remote, where
+	// they fail, and the good one.
+	for i := 0; i < 3; i++ {
+		if _, err := g.Sign([]byte("request")); err == nil {
+			t.Fatal("expected the bad request to fail")
+		}
+		sign(t, g, "request")
+	}
+	if bad.count() != 3 || good.count() != 3 {
+		t.Fatal("a bad request was retried")
+	}
+	if !g.remotes[0].health.available(time.Now()) {
+		t.Fatal("a bad request opened the remote's circuit")
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	remotes, stop := newTestRemotes("down", "up")
+	defer stop()
+	down, up := remotes[0], remotes[1]
+	down.status = http.StatusServiceUnavailable
+	g := newTestGroup(t, StrategyOrderedList, remotes, WithRetryBudget(0, 1))
+
+	if name := sign(t, g, "request"); name != "up" {
+		t.Fatalf("request went to %s", name)
+	}
+	if _, err := g.Sign([]byte("request")); err == nil {
+		t.Fatal("expected failure once the retry budget was spent")
+	}
+	if up.count() != 1 {
+		t.Fatalf("retried %d times, expected the budget to allow 1", up.count())
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	remotes, stop := newTestRemotes("slow", "fast")
+	defer stop()
+	slow, fast := remotes[0], remotes[1]
+	slow.delay = time.Second
+	g := newTestGroup(t, StrategyOrderedList, remotes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := g.SignContext(ctx, []byte("request")); err == nil {
+		t.Fatal("expected the call to outlast its deadline")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("the deadline didn't bound the call")
+	}
+	if fast.count() != 0 {
+		t.Fatal("the call was retried after its deadline")
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	remotes, stop := newTestRemotes("flaky", "up")
+	defer stop()
+	remotes[0].status = http.StatusServiceUnavailable
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := newTestGroup(t, StrategyOrderedList, remotes,
+		WithCircuitBreaker(1, time.Hour, time.Hour), WithHealthCheck(ctx, 10*time.Millisecond))
+
+	if name := sign(t, g, "request"); name != "up" {
+		t.Fatalf("request went to %s", name)
+	}
+
+	// The health check notices the remote recover, closing its
+	// circuit long before its backoff elapses.
+	atomic.StoreInt32(&remotes[0].status, http.StatusOK)
+	deadline := time.Now().Add(5 * time.Second)
+	for !g.remotes[0].health.available(time.Now()) {
+		if time.Now().After(deadline) {
+			t.Fatal("the health check didn't close the circuit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if name := sign(t, g, "request"); name != "flaky" {
+		t.Fatalf("request went to %s", name)
+	}
+}
@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/transport/core"
+)
+
+// DefaultFailureThreshold is a suitable number of consecutive failures
+// after which WithCircuitBreaker opens a remote's circuit, so that no
+// more requests are sent to it until it has backed off.
+const DefaultFailureThreshold = 3
+
+// DefaultBackoffInterval and DefaultBackoffMax are suitable bounds for
+// how long WithCircuitBreaker avoids a remote whose circuit is open:
+// the backoff doubles, with jitter, each time the remote fails again,
+// up to the maximum.
+const (
+	DefaultBackoffInterval = time.Second
+	DefaultBackoffMax      = time.Minute
+)
+
+// DefaultRetryRatio and DefaultRetryMax are a suitable retry budget to
+// give WithRetryBudget: each call adds DefaultRetryRatio to the budget,
+// up to DefaultRetryMax, and each retry spends one from it. Retries
+// then add at most a fifth to the load on the remotes once they are
+// failing.
+const (
+	DefaultRetryRatio = 0.2
+	DefaultRetryMax   = 10
+)
+
+// latencyWeight is the weight of the newest sample in the moving
+// average of a remote's latency.
+const latencyWeight = 0.3
+
+// health tracks whether a remote is answering, as a circuit breaker:
+// after threshold consecutive failures the circuit opens, and the
+// remote is avoided until its backoff elapses. It then gets another
+// try, which either closes the circuit or opens it for longer. With a
+// threshold of zero the circuit never opens, and only the remote's
+// latency is tracked.
+type health struct {
+	lock      sync.Mutex
+	threshold int
+	backoff   *core.Backoff
+	failures  int
+	openUntil time.Time
+	latency   time.Duration
+}
+
+func newHealth(threshold int, interval, max time.Duration) *health {
+	return &health{
+		threshold: threshold,
+		backoff:   core.New(max, interval),
+	}
+}
+
+// available reports whether requests may be sent to the remote.
+func (h *health) available(now time.Time) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.threshold <= 0 || h.failures < h.threshold || !now.Before(h.openUntil)
+}
+
+// success records an answer from the remote, which took latency.
+func (h *health) success(latency time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.failures = 0
+	h.openUntil = time.Time{}
+	h.backoff.Reset()
+	if h.latency == 0 {
+		h.latency = latency
+	} else {
+		h.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(h.latency))
+	}
+}
+
+// failure records the remote failing to answer, and reports whether
+// that opened its circuit.
+func (h *health) failure(now time.Time) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.threshold <= 0 {
+		return false
+	}
+	h.failures++
+	if h.failures < h.threshold {
+		return false
+	}
+	h.openUntil = now.Add(h.backoff.Duration())
+	return true
+}
+
+// averageLatency returns the moving average of the remote's latency,
+// or zero if it has never answered.
+func (h *health) averageLatency() time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.latency
+}
+
+// An attempt records whether a request failed because of the remote,
+// rather than because of the request; only the former are retried,
+// except by ordered lists.
+type attempt struct {
+	failed bool
+}
+
+type attemptKey struct{}
+
+// observe records the outcome of a request in the remote's health, and
+// in the attempt carried by ctx, if any. Requests cancelled by the
+// caller don't count against the remote, but those that outlast their
+// deadline do.
+func (srv *server) observe(ctx context.Context, ok bool, latency time.Duration) {
+	if a, _ := ctx.Value(attemptKey{}).(*attempt); a != nil {
+		a.failed = !ok
+	}
+	if !ok && ctx.Err() == context.Canceled {
+		return
+	}
+	if ok {
+		srv.health.success(latency)
+	} else if srv.health.failure(time.Now()) {
+		log.Warningf("remote %s is failing; backing off", srv.URL)
+	}
+}
+
+// checkHealth sends an info request to the remote, recording whether it
+// answered. Any answer, even an error, shows that the remote is up.
+func (srv *server) checkHealth(ctx context.Context, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	srv.InfoContext(ctx, []byte("{}"))
+}
+
+// A retryBudget limits retries to a proportion of calls, so that
+// retrying doesn't overload remotes that are already failing. A nil
+// budget allows every retry.
+type retryBudget struct {
+	lock   sync.Mutex
+	ratio  float64
+	max    float64
+	tokens float64
+}
+
+func newRetryBudget(ratio float64, max int) *retryBudget {
+	return &retryBudget{ratio: ratio, max: float64(max), tokens: float64(max)}
+}
+
+// deposit credits the budget for a call.
+func (b *retryBudget) deposit() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+// withdraw reports whether a retry may be made, spending from the
+// budget if so.
+func (b *retryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -5,10 +5,10 @@ import (
 	"crypto"
 	"crypto/x509"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/certdb"
 	"github.com/cloudflare/cfssl/crl"
 	"github.com/cloudflare/cfssl/errors"
@@ -42,14 +42,8 @@ func NewHandler(dbAccessor certdb.Accessor, caPath string, caKeyPath string) (ht
 		return nil, err
 	}
 
-	strPassword := os.Getenv("CFSSL_CA_PK_PASSWORD")
-	password := []byte(strPassword)
-	if strPassword == "" {
-		password = nil
-	}
-
 	// Parse the key given
-	key, err := helpers.ParsePrivateKeyPEMWithPassword(caKey, password)
+	key, err := helpers.ParsePrivateKeyPEMFromEnv(caKey)
 	if err != nil {
 		log.Debug("malformed private key %v", err)
 		return nil, err
@@ -62,6 +56,7 @@ func NewHandler(dbAccessor certdb.Accessor, caPath string, caKeyPath string) (ht
 			key:        key,
 		},
 		Methods: []string{"GET"},
+		Query:   openapi.CRLQuery,
 	}, nil
 }
 
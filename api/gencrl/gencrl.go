@@ -6,6 +6,7 @@ import (
 	"crypto/x509/pkix"
 	"encoding/json"
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/log"
@@ -98,5 +99,6 @@ func NewHandler() http.Handler {
 	return api.HTTPHandler{
 		Handler: api.HandlerFunc(gencrlHandler),
 		Methods: []string{"POST"},
+		Body:    openapi.GenCRLRequest,
 	}
 }
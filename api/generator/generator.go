@@ -12,6 +12,7 @@ import (
 	"net/http"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/bundler"
 	"github.com/cloudflare/cfssl/config"
 	"github.com/cloudflare/cfssl/csr"
@@ -64,6 +65,7 @@ func NewHandler(validator Validator) (http.Handler, error) {
 			generator: &csr.Generator{Validator: validator},
 		},
 		Methods: []string{"POST"},
+		Body:    openapi.CertificateRequest,
 	}, nil
 }
 
@@ -191,7 +193,7 @@ func NewCertGeneratorHandler(validator Validator, caFile, caKeyFile string, poli
 
 	cg.generator = &csr.Generator{Validator: validator}
 
-	return api.HTTPHandler{Handler: cg, Methods: []string{"POST"}}, nil
+	return api.HTTPHandler{Handler: cg, Methods: []string{"POST"}, Body: openapi.NewCertRequest}, nil
 }
 
 // NewCertGeneratorHandlerFromSigner returns a handler directly from
@@ -203,6 +205,7 @@ func NewCertGeneratorHandlerFromSigner(validator Validator, signer signer.Signer
 			signer:    signer,
 		},
 		Methods: []string{"POST"},
+		Body:    openapi.NewCertRequest,
 	}
 }
 
@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/info"
 	"github.com/cloudflare/cfssl/log"
@@ -27,6 +28,7 @@ func NewHandler(s signer.Signer) (http.Handler, error) {
 			sign: s,
 		},
 		Methods: []string{"POST"},
+		Body:    openapi.InfoRequest,
 	}, nil
 }
 
@@ -76,6 +78,7 @@ func NewMultiHandler(signers map[string]signer.Signer, defaultLabel string) (htt
 			defaultLabel: defaultLabel,
 		},
 		Methods: []string{"POST"},
+		Body:    openapi.InfoRequest,
 	}, nil
 }
 
@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/csr"
 	"github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/initca"
@@ -57,5 +58,9 @@ func initialCAHandler(w http.ResponseWriter, r *http.Request) error {
 // NewHandler returns a new http.Handler that handles request to
 // initialize a CA.
 func NewHandler() http.Handler {
-	return api.HTTPHandler{Handler: api.HandlerFunc(initialCAHandler), Methods: []string{"POST"}}
+	return api.HTTPHandler{
+		Handler: api.HandlerFunc(initialCAHandler),
+		Methods: []string{"POST"},
+		Body:    openapi.CertificateRequest,
+	}
 }
@@ -0,0 +1,73 @@
+// Package lint implements the HTTP handler for the lint command.
+package lint
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
+	"github.com/cloudflare/cfssl/errors"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/lint"
+	"github.com/cloudflare/cfssl/log"
+)
+
+// Handler lints uploaded certificates. A GET request lists the lint
+// rules instead.
+type Handler struct{}
+
+// NewHandler returns a new http.Handler that handles lint requests.
+func NewHandler() http.Handler {
+	return api.HTTPHandler{
+		Handler: new(Handler),
+		Methods: []string{"GET", "POST"},
+		Body:    openapi.LintRequest,
+		Query:   openapi.NoQuery,
+	}
+}
+
+// This type is meant to be unmarshalled from JSON
+type jsonLintRequest struct {
+	Certificate  string   `json:"certificate"`
+	IgnoredLints []string `json:"ignored_lints"`
+}
+
+// Handle returns the lint rules, or a lint report for each of the
+// certificates in a request.
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		return api.SendResponse(w, lint.Rules())
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	var req jsonLintRequest
+	if err = json.Unmarshal(body, &req); err != nil {
+		return errors.NewBadRequestString("Unable to parse lint request")
+	}
+	if req.Certificate == "" {
+		return errors.NewBadRequestMissingParameter("certificate")
+	}
+	for _, name := range req.IgnoredLints {
+		if _, ok := lint.Lookup(name); !ok {
+			return errors.NewBadRequestString("unknown lint " + name)
+		}
+	}
+
+	certs, err := helpers.ParseCertificatesPEM([]byte(req.Certificate))
+	if err != nil {
+		log.Warningf("bad PEM certificate: %v", err)
+		return err
+	}
+	if len(certs) == 0 {
+		return errors.NewBadRequestString("no certificates given")
+	}
+
+	return api.SendResponse(w, lint.Reports(certs, req.IgnoredLints))
+}
@@ -0,0 +1,122 @@
+package lint
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/lint"
+)
+
+// testCertificate returns a TLS server certificate whose common name
+// is not one of its SANs.
+func testCertificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "www.cloudflare.com"},
+		DNSNames:     []string{"cloudflare.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{"http://ocsp.cloudflare.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func post(t *testing.T, ts *httptest.Server, obj map[string]interface{}) (*http.Response, *api.Response, []lint.Report) {
+	blob, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var reports []lint.Report
+	message := &api.Response{Result: &reports}
+	if err = json.Unmarshal(body, message); err != nil {
+		t.Fatalf("failed to parse %s: %v", body, err)
+	}
+	return resp, message, reports
+}
+
+func TestLintHandler(t *testing.T) {
+	ts := httptest.NewServer(NewHandler())
+	defer ts.Close()
+
+	certPEM := testCertificate(t)
+
+	resp, _, reports := post(t, ts, map[string]interface{}{"certificate": string(certPEM)})
+	if resp.StatusCode != http.StatusOK || len(reports) != 1 {
+		t.Fatalf("unexpected response %s %+v", resp.Status, reports)
+	}
+	findings := reports[0].Findings
+	if len(findings) != 1 || findings[0].Rule != "e_sub_cert_cn_not_in_san" || findings[0].Level != lint.Error {
+		t.Fatalf("unexpected findings %s", findings)
+	}
+
+	resp, _, reports = post(t, ts, map[string]interface{}{
+		"certificate":   string(certPEM),
+		"ignored_lints": []string{"e_sub_cert_cn_not_in_san"},
+	})
+	if resp.StatusCode != http.StatusOK || len(reports[0].Findings) != 0 {
+		t.Fatalf("ignored lint was reported: %+v", reports)
+	}
+
+	for _, obj := range []map[string]interface{}{
+		{},
+		{"certificate": "not a certificate"},
+		{"certificate": string(certPEM), "ignored_lints": []string{"e_no_such_lint"}},
+	} {
+		if resp, _, _ = post(t, ts, obj); resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%v: expected a bad request, got %s", obj, resp.Status)
+		}
+	}
+}
+
+func TestLintRules(t *testing.T) {
+	ts := httptest.NewServer(NewHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var rules []lint.Rule
+	message := &api.Response{Result: &rules}
+	if err = json.NewDecoder(resp.Body).Decode(message); err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != len(lint.Rules()) || rules[0].Name == "" || rules[0].Source == "" {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+}
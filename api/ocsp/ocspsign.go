@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/log"
@@ -31,6 +32,7 @@ func NewHandler(s ocsp.Signer) http.Handler {
 			signer: s,
 		},
 		Methods: []string{"POST"},
+		Body:    openapi.OCSPSignRequest,
 	}
 }
 
@@ -240,3 +240,39 @@ func TestSign(t *testing.T) {
 		}
 	}
 }
+
+func TestSignUnknownField(t *testing.T) {
+	ts := newSignServer(t)
+	defer ts.Close()
+
+	c, err := ioutil.ReadFile(testCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := json.Marshal(map[string]interface{}{"certificate": string(c), "statuz": "revoked"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a bad request, have %s %s", resp.Status, body)
+	}
+
+	message := new(api.Response)
+	if err := json.Unmarshal(body, message); err != nil {
+		t.Fatal(err)
+	}
+	// The code is that of a request the handler couldn't parse.
+	if message.Success || len(message.Errors) != 1 ||
+		message.Errors[0].Code != http.StatusBadRequest || message.Errors[0].Field != "statuz" {
+		t.Fatalf("unexpected response %s", body)
+	}
+}
@@ -0,0 +1,381 @@
+// Package openapi describes the CFSSL API as an OpenAPI 3 document, and
+// holds the schemas its handlers validate requests with.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/cloudflare/cfssl/api"
+)
+
+// Prefix is the path the endpoints of the API are found under.
+const Prefix = "/api/v1/cfssl/"
+
+// A Document is an OpenAPI 3 document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Components holds the schemas and responses the rest of a document
+// refers to.
+type Components struct {
+	Schemas   map[string]*api.Schema `json:"schemas"`
+	Responses map[string]*Response   `json:"responses"`
+}
+
+// Info describes the API.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// A PathItem holds the operations of an endpoint.
+type PathItem struct {
+	Get  *Operation `json:"get,omitempty"`
+	Post *Operation `json:"post,omitempty"`
+}
+
+// An Operation describes a method of an endpoint.
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// A Parameter describes a query parameter of an operation.
+type Parameter struct {
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Schema      *api.Schema `json:"schema"`
+}
+
+// A RequestBody describes the body of requests to an operation.
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// A Response describes a response of an operation, or refers to one of
+// the document's components.
+type Response struct {
+	Ref         string                `json:"$ref,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// A MediaType gives the schema of content of a type.
+type MediaType struct {
+	Schema *api.Schema `json:"schema"`
+}
+
+// ref returns a reference to the schema component with the name.
+func ref(name string) *api.Schema {
+	return &api.Schema{Ref: "#/components/schemas/" + name}
+}
+
+// messages is the schema of the errors and messages of a response.
+var messages = array(object(map[string]*api.Schema{
+	"code":    integer("CFSSL error code, or HTTP status"),
+	"message": str("Description of the error or message"),
+	"field":   str("Field of the request the error is about"),
+}, "code", "message"), "")
+
+// envelope returns the schema of a response with the result.
+func envelope(result *api.Schema) *api.Schema {
+	return object(map[string]*api.Schema{
+		"success":  boolean("Whether the request succeeded"),
+		"result":   result,
+		"errors":   ref("Messages"),
+		"messages": ref("Messages"),
+	}, "success", "result", "errors", "messages")
+}
+
+func jsonContent(schema *api.Schema) map[string]*MediaType {
+	return map[string]*MediaType{"application/json": {Schema: schema}}
+}
+
+// responses returns the responses of an operation with the result.
+func responses(result *api.Schema) map[string]*Response {
+	return map[string]*Response{
+		"200": {
+			Description: "The request succeeded",
+			Content:     jsonContent(envelope(result)),
+		},
+		"default": {Ref: "#/components/responses/Error"},
+	}
+}
+
+// post returns a POST operation taking a JSON body, described by the
+// schema component with the name.
+func post(id, summary, body string, result *api.Schema) *Operation {
+	return &Operation{
+		OperationID: id,
+		Summary:     summary,
+		RequestBody: &RequestBody{Required: true, Content: jsonContent(ref(body))},
+		Responses:   responses(result),
+	}
+}
+
+// get returns a GET operation taking the query parameters described by
+// query.
+func get(id, summary string, query, result *api.Schema) *Operation {
+	op := &Operation{
+		OperationID: id,
+		Summary:     summary,
+		Responses:   responses(result),
+	}
+	for _, name := range sortedKeys(query.Properties) {
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:     name,
+			In:       "query",
+			Required: contains(query.Required, name),
+			Schema:   query.Properties[name],
+		})
+	}
+	return op
+}
+
+func sortedKeys(m map[string]*api.Schema) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// scep returns a SCEP operation; its messages are DER-encoded, rather
+// than JSON.
+func scep(id, summary string, body bool) *Operation {
+	op := &Operation{
+		OperationID: id,
+		Summary:     summary,
+		Parameters: []*Parameter{{
+			Name:     "operation",
+			In:       "query",
+			Required: true,
+			Schema:   enum("SCEP operation", "GetCACaps", "GetCACert", "PKIOperation"),
+		}},
+		Responses: map[string]*Response{
+			"200": {
+				Description: "The capabilities, one per line, the DER-encoded certificates, or a CertRep pkiMessage",
+				Content: map[string]*MediaType{
+					"text/plain":                    {Schema: str("")},
+					"application/x-x509-ca-cert":    {Schema: format("binary", "")},
+					"application/x-x509-ca-ra-cert": {Schema: format("binary", "")},
+					"application/x-pki-message":     {Schema: format("binary", "")},
+				},
+			},
+		},
+	}
+	if body {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content: map[string]*MediaType{
+				"application/x-pki-message": {Schema: format("binary", "A PKCSReq or RenewalReq pkiMessage")},
+			},
+		}
+	} else {
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:        "message",
+			In:          "query",
+			Description: "The base64-encoded pkiMessage of a PKIOperation",
+			Schema:      format("byte", ""),
+		})
+	}
+	return op
+}
+
+var sums = open("Digests of the certificate request, by name", nil)
+
+var bundle = open("A certificate with its chain to a trusted root", map[string]*api.Schema{
+	"bundle":       str("PEM-encoded chain"),
+	"root":         str("PEM-encoded root"),
+	"crt":          str("PEM-encoded certificate"),
+	"key":          str("PEM-encoded private key, if one was given"),
+	"key_type":     str("Type of the certificate's key"),
+	"key_size":     integer("Size of the certificate's key"),
+	"issuer":       str("Issuer of the certificate"),
+	"subject":      str("Subject of the certificate"),
+	"expires":      format("date-time", "When the first certificate of the chain expires"),
+	"leaf_expires": format("date-time", "When the certificate expires"),
+	"hostnames":    array(str(""), "Hosts the certificate is for"),
+	"ocsp_support": boolean("Whether the certificate has an OCSP responder"),
+	"crl_support":  boolean("Whether the certificate has a CRL distribution point"),
+	"ocsp":         array(str(""), "OCSP responders of the certificate"),
+	"signature":    str("Signature algorithm of the certificate"),
+	"status":       open("How the chain was built", nil),
+	"pkcs7":        format("byte", "The chain as PKCS #7, if asked for"),
+	"pkcs12":       format("byte", "The chain as PKCS #12, if asked for"),
+})
+
+var signResult = open("The signed certificate", map[string]*api.Schema{
+	"certificate": str("PEM-encoded certificate"),
+	"bundle":      ref("Bundle"),
+	"pkcs7":       format("byte", "The certificate and chain as PKCS #7, if asked for"),
+	"pkcs12":      format("byte", "The certificate and chain as PKCS #12, if asked for"),
+})
+
+var infoResult = open("The signer's certificate", map[string]*api.Schema{
+	"certificate": str("PEM-encoded certificate"),
+	"usages":      array(str(""), "Key usages of the profile"),
+	"expiry":      str("Lifetime of certificates of the profile"),
+})
+
+var keyResult = open("The key and certificate request", map[string]*api.Schema{
+	"private_key":         str("PEM-encoded private key"),
+	"certificate_request": str("PEM-encoded certificate request"),
+	"sums":                sums,
+})
+
+var certResult = open("The key, certificate request and certificate", map[string]*api.Schema{
+	"private_key":         str("PEM-encoded private key"),
+	"certificate_request": str("PEM-encoded certificate request"),
+	"certificate":         str("PEM-encoded certificate"),
+	"sums":                sums,
+	"bundle":              ref("Bundle"),
+})
+
+var initCAResult = open("The CA's key and certificate", map[string]*api.Schema{
+	"private_key": str("PEM-encoded private key"),
+	"certificate": str("PEM-encoded certificate"),
+})
+
+var whitelistResult = open("The whitelists, or their history", map[string]*api.Schema{
+	"whitelists": open("Entries of each whitelist", nil),
+	"history":    array(open("A change to a whitelist", nil), "Changes to the whitelists"),
+})
+
+// Spec returns the OpenAPI document describing the endpoints served by
+// 'cfssl serve'.
+func Spec() *Document {
+	paths := map[string]*PathItem{
+		"sign": {Post: post("sign", "Sign a certificate request",
+			"SignRequest", signResult)},
+		"authsign": {Post: post("authsign", "Sign an authenticated certificate request",
+			"AuthenticatedRequest", signResult)},
+		"info": {Post: post("info", "Get the certificate of a signer",
+			"InfoRequest", infoResult)},
+		"crl": {Get: get("crl", "Generate a CRL of the certificates revoked in the database",
+			CRLQuery, format("byte", "DER-encoded CRL"))},
+		"gencrl": {Post: post("gencrl", "Generate a CRL signed by the given key",
+			"GenCRLRequest", format("byte", "DER-encoded CRL"))},
+		"newkey": {Post: post("newkey", "Generate a key and certificate request",
+			"CertificateRequest", keyResult)},
+		"newcert": {Post: post("newcert", "Generate a key and signed certificate",
+			"NewCertRequest", certResult)},
+		"init_ca": {Post: post("init_ca", "Generate a CA key and self-signed certificate",
+			"CertificateRequest", initCAResult)},
+		"bundle": {Post: post("bundle", "Build the chain of a certificate",
+			"BundleRequest", bundle)},
+		"scan": {Get: get("scan", "Scan a host's TLS set up",
+			ScanQuery, open("Results of each scanner, by family", nil))},
+		"scandiff": {Get: get("scandiff", "Compare the two most recent scans of a host",
+			ScanDiffQuery, open("Changes between the scans", nil))},
+		"scaninfo": {Get: get("scaninfo", "List the scanners, by family",
+			NoQuery, open("Scanners of each family", nil))},
+		"certinfo": {Post: post("certinfo", "Describe a certificate",
+			"CertInfoRequest", open("The certificate's fields", nil))},
+		"lint": {
+			Get: get("lintRules", "List the lint rules",
+				NoQuery, array(open("A lint rule", nil), "")),
+			Post: post("lint", "Lint certificates",
+				"LintRequest", array(open("The lint report of a certificate", nil), "")),
+		},
+		"ocspsign": {Post: post("ocspsign", "Sign an OCSP response",
+			"OCSPSignRequest", object(map[string]*api.Schema{
+				"ocspResponse": format("byte", "DER-encoded OCSP response"),
+			}))},
+		"revoke": {Post: post("revoke", "Revoke a certificate in the database",
+			"RevokeRequest", object(nil))},
+		"scep": {
+			Get:  scep("scepGet", "Get the SCEP capabilities or CA certificates, or send a SCEP message", false),
+			Post: scep("scepPost", "Send a SCEP message", true),
+		},
+		"whitelist": {Post: post("whitelist", "List, change or show the history of the whitelists",
+			"AuthenticatedRequest", whitelistResult)},
+		"openapi": {Get: &Operation{
+			OperationID: "openapi",
+			Summary:     "Get this OpenAPI document",
+			Responses: map[string]*Response{
+				"200": {
+					Description: "The OpenAPI document",
+					Content:     jsonContent(open("", nil)),
+				},
+			},
+		}},
+	}
+
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info: Info{
+			Title: "CFSSL API",
+			Description: "The HTTP API served by 'cfssl serve'. Requests are validated against " +
+				"these schemas: unknown properties are ignored, and each invalid field is " +
+				"reported as an error naming it.",
+			Version: "1",
+		},
+		Paths: make(map[string]*PathItem, len(paths)),
+		Components: Components{
+			Schemas: map[string]*api.Schema{
+				"Messages":             messages,
+				"Bundle":               bundle,
+				"SignRequest":          SignRequest,
+				"AuthenticatedRequest": AuthenticatedRequest,
+				"InfoRequest":          InfoRequest,
+				"GenCRLRequest":        GenCRLRequest,
+				"CertificateRequest":   CertificateRequest,
+				"NewCertRequest":       NewCertRequest,
+				"BundleRequest":        BundleRequest,
+				"CertInfoRequest":      CertInfoRequest,
+				"LintRequest":          LintRequest,
+				"OCSPSignRequest":      OCSPSignRequest,
+				"RevokeRequest":        RevokeRequest,
+			},
+			Responses: map[string]*Response{
+				"Error": {
+					Description: "The request failed, as described by the errors",
+					Content:     jsonContent(envelope(&api.Schema{Description: "Always null"})),
+				},
+			},
+		},
+	}
+	for name, item := range paths {
+		doc.Paths[Prefix+name] = item
+	}
+	return doc
+}
+
+// NewHandler returns a handler serving the OpenAPI document.
+func NewHandler() http.Handler {
+	spec, err := json.Marshal(Spec())
+	return api.HTTPHandler{
+		Handler: api.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			if err != nil {
+				return err
+			}
+			_, err := w.Write(spec)
+			return err
+		}),
+		Methods: []string{"GET"},
+	}
+}
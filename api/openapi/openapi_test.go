@@ -0,0 +1,188 @@
+package openapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/client"
+	"github.com/cloudflare/cfssl/api/openapi"
+	"github.com/cloudflare/cfssl/auth"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/info"
+	"github.com/cloudflare/cfssl/signer"
+)
+
+const specFile = "../../doc/api/openapi.json"
+
+var update = flag.Bool("update", false, "rewrite "+specFile)
+
+// TestSpecFile checks that the published document matches the one
+// served; run the test with -update to rewrite it.
+func TestSpecFile(t *testing.T) {
+	spec, err := json.MarshalIndent(openapi.Spec(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec = append(spec, '\n')
+
+	if *update {
+		if err := ioutil.WriteFile(specFile, spec, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	published, err := ioutil.ReadFile(specFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(spec, published) {
+		t.Fatalf("%s is out of date; run 'go test ./api/openapi -update'", specFile)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ts := httptest.NewServer(openapi.NewHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %s", resp.Status)
+	}
+	var doc openapi.Document
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" || doc.Paths[openapi.Prefix+"sign"] == nil {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+
+	resp, err = http.Post(ts.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status %s", resp.Status)
+	}
+}
+
+// TestClientRequests checks that the requests sent by the clients in
+// this repository are valid.
+func TestClientRequests(t *testing.T) {
+	kr := csr.NewBasicKeyRequest()
+	cr := &csr.CertificateRequest{
+		CN:         "example.com",
+		Names:      []csr.Name{{C: "US", O: "Example"}},
+		Hosts:      []string{"example.com"},
+		KeyRequest: kr,
+		CA:         &csr.CAConfig{PathLength: 1, Expiry: "8760h"},
+	}
+
+	var tests = []struct {
+		name   string
+		schema *api.Schema
+		req    interface{}
+	}{
+		{"signer.SignRequest", openapi.SignRequest, &signer.SignRequest{
+			Request: "csr",
+			Subject: &signer.Subject{CN: "example.com", Names: []csr.Name{{C: "US"}}},
+			Serial:  big.NewInt(1),
+			Extensions: []signer.Extension{{
+				ID:    []int{1, 2, 3},
+				Value: "00",
+			}},
+		}},
+		{"client.SignRequest", openapi.SignRequest, &client.SignRequest{
+			Hosts:   []string{"example.com"},
+			Request: "csr",
+			Bundle:  true,
+			Format:  "pkcs12",
+		}},
+		{"auth.AuthenticatedRequest", openapi.AuthenticatedRequest, &auth.AuthenticatedRequest{
+			Timestamp: 1,
+			Token:     []byte("token"),
+			Request:   []byte("{}"),
+		}},
+		{"info.Req", openapi.InfoRequest, &info.Req{Label: "primary"}},
+		{"csr.CertificateRequest", openapi.CertificateRequest, cr},
+		{"client.NewCertRequest", openapi.NewCertRequest, &client.NewCertRequest{Request: cr, Profile: "server"}},
+		{"client.BundleRequest", openapi.BundleRequest, &client.BundleRequest{Domain: "example.com", Flavor: "optimal"}},
+		{"client.CertInfoRequest", openapi.CertInfoRequest, &client.CertInfoRequest{Certificate: "cert"}},
+		{"client.LintRequest", openapi.LintRequest, &client.LintRequest{Certificate: "cert"}},
+		{"client.OCSPSignRequest", openapi.OCSPSignRequest, &client.OCSPSignRequest{Certificate: "cert", Status: "revoked", Reason: 1}},
+		{"client.RevokeRequest", openapi.RevokeRequest, &client.RevokeRequest{Serial: "1", AKI: "aa", Reason: "keyCompromise"}},
+		{"client.GenCRLRequest", openapi.GenCRLRequest, &client.GenCRLRequest{Certificate: "cert", SerialNumbers: []string{"1"}, PrivateKey: "key"}},
+	}
+
+	for _, test := range tests {
+		data, err := json.Marshal(test.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs := test.schema.ValidateJSON(data); errs != nil {
+			t.Errorf("%s: %s is invalid: %v", test.name, data, errs)
+		}
+	}
+}
+
+func TestInvalidRequests(t *testing.T) {
+	var tests = []struct {
+		schema *api.Schema
+		json   string
+		field  string
+	}{
+		{openapi.SignRequest, `{"hosts": ["example.com"]}`, "certificate_request"},
+		{openapi.SignRequest, `{"certificate_request": "csr", "serial": "1"}`, "serial"},
+		{openapi.SignRequest, `{"certificate_request": "csr", "format": "der"}`, "format"},
+		{openapi.SignRequest, `{"certificate_request": "csr", "subject": {"names": [{"C": 1}]}}`, "subject.names[0].C"},
+		{openapi.SignRequest, `{"certificate_request": "csr", "NotAfter": "tomorrow"}`, "NotAfter"},
+		{openapi.AuthenticatedRequest, `{"token": "!", "request": "e30="}`, "token"},
+		{openapi.CertificateRequest, `{"CN": "example.com", "key": {"algo": "rsa", "size": "2048"}}`, "key.size"},
+		{openapi.NewCertRequest, `{"profile": "server"}`, "request"},
+		{openapi.BundleRequest, `{"flavor": "optimal"}`, ""},
+		{openapi.CertInfoRequest, `{"certificate": 1}`, "certificate"},
+		{openapi.OCSPSignRequest, `{"certificate": "cert", "status": "bad"}`, "status"},
+		{openapi.RevokeRequest, `{"authority_key_id": "aa"}`, "serial"},
+	}
+
+	for _, test := range tests {
+		errs := test.schema.ValidateJSON([]byte(test.json))
+		if len(errs) != 1 || errs[0].Field != test.field {
+			t.Errorf("%s: expected an error for %q, have %v", test.json, test.field, errs)
+		}
+	}
+
+	// Properties that aren't listed are rejected, rather than ignored
+	// as the handlers would.
+	for _, schema := range []*api.Schema{openapi.SignRequest, openapi.BundleRequest, openapi.CertInfoRequest} {
+		errs := schema.ValidateJSON([]byte(`{"certificate": "cert", "certificate_request": "csr", "host": "example.com"}`))
+		if len(errs) == 0 || errs[len(errs)-1].Field != "host" {
+			t.Errorf("unexpected errors for an unlisted property: %v", errs)
+		}
+	}
+	if errs := openapi.NoQuery.ValidateQuery(map[string][]string{"host": {"example.com"}}); len(errs) != 1 || errs[0].Field != "host" {
+		t.Errorf("unexpected errors for an unlisted parameter: %v", errs)
+	}
+
+	// Errors have the codes the handlers would give them.
+	errs := openapi.OCSPSignRequest.ValidateJSON([]byte(`{"certificate": "cert", "status": "bad"}`))
+	if len(errs) != 1 || errs[0].Code != 8200 {
+		t.Errorf("unexpected OCSP sign request errors %v", errs)
+	}
+
+	errs = openapi.ScanQuery.ValidateQuery(map[string][]string{"timeout": {"forever"}})
+	if len(errs) != 2 || errs[0].Field != "host" || errs[1].Field != "timeout" {
+		t.Errorf("unexpected scan query errors %v", errs)
+	}
+}
@@ -0,0 +1,217 @@
+package openapi
+
+import (
+	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/errors"
+)
+
+func str(description string) *api.Schema {
+	return &api.Schema{Type: "string", Description: description}
+}
+
+func format(format, description string) *api.Schema {
+	return &api.Schema{Type: "string", Format: format, Description: description}
+}
+
+func enum(description string, values ...string) *api.Schema {
+	return &api.Schema{Type: "string", Description: description, Enum: values}
+}
+
+func integer(description string) *api.Schema {
+	return &api.Schema{Type: "integer", Description: description}
+}
+
+func boolean(description string) *api.Schema {
+	return &api.Schema{Type: "boolean", Description: description}
+}
+
+func array(items *api.Schema, description string) *api.Schema {
+	return &api.Schema{Type: "array", Items: items, Description: description}
+}
+
+// object returns the schema of an object with only the properties
+// listed, so that misspelt fields are reported rather than ignored.
+func object(properties map[string]*api.Schema, required ...string) *api.Schema {
+	return &api.Schema{Type: "object", Properties: properties, Required: required, Strict: true}
+}
+
+// coded returns the schema, reporting values that don't match it with
+// the error code the handler would have given them.
+func coded(category errors.Category, reason errors.Reason, s *api.Schema) *api.Schema {
+	s.Code = int(category) + int(reason)
+	return s
+}
+
+// open returns the schema of an object that may have properties other
+// than those listed.
+func open(description string, properties map[string]*api.Schema) *api.Schema {
+	return &api.Schema{
+		Type:                 "object",
+		Description:          description,
+		Properties:           properties,
+		AdditionalProperties: &api.Schema{},
+	}
+}
+
+// Name is the schema of a name of a certificate's subject.
+var Name = object(map[string]*api.Schema{
+	"C":            str("Country"),
+	"ST":           str("State or province"),
+	"L":            str("Locality"),
+	"O":            str("Organisation"),
+	"OU":           str("Organisational unit"),
+	"SerialNumber": str("Serial number"),
+})
+
+// CertificateRequest is the schema of a request for a new key and
+// certificate request, as taken by the newkey and init_ca endpoints.
+var CertificateRequest = object(map[string]*api.Schema{
+	"CN":    str("Common name"),
+	"names": array(Name, "Names of the subject"),
+	"hosts": array(str(""), "Domain names, IP addresses and email addresses the certificate is for"),
+	"key": object(map[string]*api.Schema{
+		"algo": str("Key algorithm, rsa or ecdsa"),
+		"size": integer("Key size in bits"),
+	}),
+	"ca": object(map[string]*api.Schema{
+		"pathlen":     integer("Maximum number of intermediate CAs below the CA"),
+		"pathlenzero": boolean("Whether a pathlen of 0 is meant, rather than none"),
+		"expiry":      str("Lifetime of the CA certificate, such as 8760h"),
+		"backdate":    str("How far to backdate the CA certificate, such as 1h"),
+	}),
+	"serialnumber": str("Serial number of the subject"),
+})
+
+// SignRequest is the schema of a request to the sign endpoint, and of
+// the request authenticated by an authsign request.
+var SignRequest = object(map[string]*api.Schema{
+	"hostname":            str("Comma-separated hosts, overriding those of the CSR"),
+	"hosts":               array(str(""), "Hosts, overriding those of the CSR"),
+	"certificate_request": str("PEM-encoded certificate signing request"),
+	"subject": object(map[string]*api.Schema{
+		"CN":           str("Common name"),
+		"names":        array(Name, "Names of the subject"),
+		"SerialNumber": str("Serial number of the subject"),
+	}),
+	"profile":      str("Signing profile"),
+	"crl_override": str("CRL distribution point, overriding that of the profile"),
+	"label":        str("Signer of a multi-root CA"),
+	"serial":       integer("Serial number of the certificate"),
+	"extensions": array(object(map[string]*api.Schema{
+		"id":       str("Object identifier, such as 1.2.3.4"),
+		"critical": boolean("Whether the extension is critical"),
+		"value":    str("Hex-encoded value"),
+	}), "Extensions to include, if the profile allows them"),
+	"NotBefore":     format("date-time", "Start of the certificate's validity"),
+	"NotAfter":      format("date-time", "End of the certificate's validity"),
+	"ReturnPrecert": boolean("Whether to return a precertificate"),
+	"bundle":        boolean("Whether to return the certificate's chain"),
+	"format":        enum("Format to also return the certificate in", "", "pem", "pkcs7", "pkcs12"),
+	"password":      str("Password of a PKCS #12 archive"),
+}, "certificate_request")
+
+// AuthenticatedRequest is the schema of a request to the authsign and
+// whitelist endpoints: a request authenticated by a token.
+var AuthenticatedRequest = object(map[string]*api.Schema{
+	"timestamp":      integer("Unix time of the request"),
+	"remote_address": format("byte", "Address of the requester"),
+	"token":          format("byte", "Token authenticating the request"),
+	"request":        format("byte", "JSON-encoded request"),
+}, "token", "request")
+
+// InfoRequest is the schema of a request to the info endpoint.
+var InfoRequest = object(map[string]*api.Schema{
+	"label":   str("Signer of a multi-root CA"),
+	"profile": str("Signing profile"),
+})
+
+// NewCertRequest is the schema of a request to the newcert endpoint.
+var NewCertRequest = object(map[string]*api.Schema{
+	"request": CertificateRequest,
+	"profile": str("Signing profile"),
+	"label":   str("Signer of a multi-root CA"),
+	"bundle":  boolean("Whether to return the certificate's chain"),
+}, "request")
+
+// BundleRequest is the schema of a request to the bundle endpoint.
+var BundleRequest = &api.Schema{
+	Type:   "object",
+	Strict: true,
+	Properties: map[string]*api.Schema{
+		"certificate": str("PEM-encoded certificate to bundle"),
+		"private_key": str("PEM-encoded private key of the certificate"),
+		"domain":      str("Domain whose certificate to bundle, or to check the certificate against"),
+		"ip":          str("IP address to connect to the domain at"),
+		"starttls":    str("Protocol to negotiate TLS with, such as smtp or imap"),
+		"flavor":      str("Bundle flavor, such as ubiquitous, optimal or force"),
+		"format":      enum("Format to also return the bundle in", "", "pem", "pkcs7", "pkcs12"),
+		"password":    str("Password of a PKCS #12 archive"),
+	},
+	AnyOf: []*api.Schema{{Required: []string{"certificate"}}, {Required: []string{"domain"}}},
+}
+
+// CertInfoRequest is the schema of a request to the certinfo endpoint.
+var CertInfoRequest = &api.Schema{
+	Type:   "object",
+	Strict: true,
+	Properties: map[string]*api.Schema{
+		"certificate": str("PEM-encoded certificate"),
+		"domain":      str("Domain whose certificate to describe"),
+	},
+	AnyOf: []*api.Schema{{Required: []string{"certificate"}}, {Required: []string{"domain"}}},
+}
+
+// LintRequest is the schema of a request to the lint endpoint.
+var LintRequest = object(map[string]*api.Schema{
+	"certificate":   str("PEM-encoded certificates"),
+	"ignored_lints": array(str(""), "Names of the lints to skip"),
+}, "certificate")
+
+// OCSPSignRequest is the schema of a request to the ocspsign endpoint.
+var OCSPSignRequest = object(map[string]*api.Schema{
+	"certificate": str("PEM-encoded certificate"),
+	"status": coded(errors.OCSPError, errors.InvalidStatus,
+		enum("Status of the certificate", "good", "revoked", "unknown")),
+	"reason":      integer("Revocation reason code"),
+	"revoked_at":  str("Revocation date, as 2006-01-02, or now"),
+	"issuer_hash": enum("Hash of the issuer's name and key, SHA1 if empty", "", "MD5", "SHA1", "SHA256", "SHA384", "SHA512"),
+}, "certificate")
+
+// RevokeRequest is the schema of a request to the revoke endpoint.
+var RevokeRequest = object(map[string]*api.Schema{
+	"serial":           str("Serial number of the certificate"),
+	"authority_key_id": str("Hex-encoded authority key identifier of the certificate"),
+	"reason":           str("Revocation reason, such as keyCompromise"),
+}, "serial")
+
+// GenCRLRequest is the schema of a request to the gencrl endpoint.
+var GenCRLRequest = object(map[string]*api.Schema{
+	"certificate":  str("PEM-encoded certificate of the CRL's issuer"),
+	"serialNumber": array(str(""), "Decimal serial numbers of the revoked certificates"),
+	"issuingKey":   str("PEM-encoded private key of the issuer"),
+	"expireTime":   str("Lifetime of the CRL in seconds, a week by default"),
+}, "certificate", "issuingKey")
+
+// CRLQuery is the schema of the query parameters of the crl endpoint.
+var CRLQuery = object(map[string]*api.Schema{
+	"expiry": format("duration", "Lifetime of the CRL, a week by default"),
+})
+
+// ScanQuery is the schema of the query parameters of the scan endpoint.
+var ScanQuery = object(map[string]*api.Schema{
+	"host":     str("Host to scan, with an optional port"),
+	"ip":       str("IP address to connect to the host at"),
+	"starttls": str("Protocol to negotiate TLS with, such as smtp or imap"),
+	"family":   str("Regular expression selecting the families of scanners to run"),
+	"scanner":  str("Regular expression selecting the scanners to run"),
+	"timeout":  format("duration", "Time limit of each scan, a minute by default"),
+}, "host")
+
+// ScanDiffQuery is the schema of the query parameters of the scandiff
+// endpoint.
+var ScanDiffQuery = object(map[string]*api.Schema{
+	"host": str("Host whose scans to compare"),
+}, "host")
+
+// NoQuery is the schema of the query of an endpoint without parameters.
+var NoQuery = object(nil)
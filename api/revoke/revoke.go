@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/certdb"
 	"github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/helpers"
@@ -30,6 +31,7 @@ func NewHandler(dbAccessor certdb.Accessor) http.Handler {
 			dbAccessor: dbAccessor,
 		},
 		Methods: []string{"POST"},
+		Body:    openapi.RevokeRequest,
 	}
 }
 
@@ -42,6 +44,7 @@ func NewOCSPHandler(dbAccessor certdb.Accessor, signer ocsp.Signer) http.Handler
 			Signer:     signer,
 		},
 		Methods: []string{"POST"},
+		Body:    openapi.RevokeRequest,
 	}
 }
 
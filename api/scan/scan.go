@@ -6,14 +6,23 @@ import (
 	"time"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
+	"github.com/cloudflare/cfssl/certdb"
 	"github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/scan"
+	"github.com/cloudflare/cfssl/starttls"
 )
 
-// scanHandler is an HTTP handler that accepts GET parameters for host (required)
-// family and scanner, and uses these to perform scans, returning a JSON blob result.
-func scanHandler(w http.ResponseWriter, r *http.Request) error {
+// Handler accepts GET parameters for host (required), starttls, family and scanner,
+// and uses these to perform scans, returning a JSON blob result. If it
+// has a certificate database, each scan is recorded there.
+type Handler struct {
+	dbAccessor certdb.Accessor
+}
+
+// Handle performs a scan.
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 	if err := r.ParseForm(); err != nil {
 		log.Warningf("failed to parse body: %v", err)
 		return errors.NewBadRequest(err)
@@ -41,23 +50,83 @@ func scanHandler(w http.ResponseWriter, r *http.Request) error {
 		log.Warningf("no host given")
 		return errors.NewBadRequestString("no host given")
 	}
+	host = starttls.Join(r.Form.Get("starttls"), host)
 
+	scannedAt := time.Now()
 	results, err := scan.Default.RunScans(host, ip, family, scanner, timeout)
 	if err != nil {
 		return errors.NewBadRequest(err)
 	}
 
+	if h.dbAccessor != nil {
+		if err = scan.SaveRun(h.dbAccessor, host, scannedAt, results); err != nil {
+			log.Warningf("failed to record scan of %s: %v", host, err)
+		}
+	}
+
 	return json.NewEncoder(w).Encode(api.NewSuccessResponse(results))
 }
 
 // NewHandler returns a new http.Handler that handles a scan request.
 func NewHandler(caBundleFile string) (http.Handler, error) {
+	return NewHandlerWithDB(caBundleFile, nil)
+}
+
+// NewHandlerWithDB returns a new http.Handler that handles a scan
+// request and records its results in dbAccessor.
+func NewHandlerWithDB(caBundleFile string, dbAccessor certdb.Accessor) (http.Handler, error) {
 	return api.HTTPHandler{
-		Handler: api.HandlerFunc(scanHandler),
+		Handler: &Handler{dbAccessor: dbAccessor},
 		Methods: []string{"GET"},
+		Query:   openapi.ScanQuery,
 	}, scan.LoadRootCAs(caBundleFile)
 }
 
+// DiffHandler returns the changes between the two most recent recorded
+// scans of the host given as a GET parameter.
+type DiffHandler struct {
+	dbAccessor certdb.Accessor
+}
+
+// Handle responds with a scan diff.
+func (h *DiffHandler) Handle(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		log.Warningf("failed to parse body: %v", err)
+		return errors.NewBadRequest(err)
+	}
+
+	host := r.Form.Get("host")
+	if host == "" {
+		log.Warningf("no host given")
+		return errors.NewBadRequestString("no host given")
+	}
+
+	runs, err := scan.History(h.dbAccessor, host)
+	if err != nil {
+		return err
+	}
+	if len(runs) < 2 {
+		return errors.NewBadRequestString("two recorded scans are needed for a diff")
+	}
+
+	diff, err := scan.DiffRuns(runs[len(runs)-2], runs[len(runs)-1])
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(api.NewSuccessResponse(diff))
+}
+
+// NewDiffHandler returns a new http.Handler that handles a request
+// for the changes between recorded scans.
+func NewDiffHandler(dbAccessor certdb.Accessor) http.Handler {
+	return api.HTTPHandler{
+		Handler: &DiffHandler{dbAccessor: dbAccessor},
+		Methods: []string{"GET"},
+		Query:   openapi.ScanDiffQuery,
+	}
+}
+
 // scanInfoHandler is an HTTP handler that returns a JSON blob result describing
 // the possible families and scans to be run.
 func scanInfoHandler(w http.ResponseWriter, r *http.Request) error {
@@ -72,5 +141,6 @@ func NewInfoHandler() http.Handler {
 	return api.HTTPHandler{
 		Handler: api.HandlerFunc(scanInfoHandler),
 		Methods: []string{"GET"},
+		Query:   openapi.NoQuery,
 	}
 }
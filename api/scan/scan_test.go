@@ -1,11 +1,22 @@
 package scan
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/certdb/sql"
+	"github.com/cloudflare/cfssl/certdb/testdb"
+	"github.com/cloudflare/cfssl/scan"
 )
 
+const sqliteMigrations = "../../certdb/sqlite/migrations"
+
 var (
 	handler, _ = NewHandler("")
 	ts         = httptest.NewServer(handler)
@@ -61,3 +72,60 @@ func TestNewInfoHandler(t *testing.T) {
 		t.Fatal("Handler error")
 	}
 }
+
+func TestDiffHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfssl_scan_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sqlDB := testdb.MigratedSQLiteDB(filepath.Join(dir, "certdb.db"), sqliteMigrations)
+	defer sqlDB.Close()
+	db := sql.NewAccessor(sqlDB)
+	ts := httptest.NewServer(NewDiffHandler(db))
+	defer ts.Close()
+
+	get := func(host string) *http.Response {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		data := req.URL.Query()
+		data.Add("host", host)
+		req.URL.RawQuery = data.Encode()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Nothing has been recorded yet.
+	if resp := get("example.com"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatal(resp.Status)
+	}
+
+	now := time.Now()
+	runs := []map[string]scan.FamilyResult{
+		{"PKI": {"ChainExpiration": {Grade: "Good"}}},
+		{"PKI": {"ChainExpiration": {Grade: "Warning"}}},
+	}
+	for i, results := range runs {
+		if err := scan.SaveRun(db, "example.com", now.Add(time.Duration(i)*time.Hour), results); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := get("example.com")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.Status)
+	}
+	var body struct {
+		Result scan.Diff `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	changes := body.Result.GradeChanges
+	if len(changes) != 1 || changes[0].Scanner != "ChainExpiration" || changes[0].From != "Good" || changes[0].To != "Warning" {
+		t.Fatalf("unexpected diff %+v", body.Result)
+	}
+}
@@ -0,0 +1,360 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schema describes a JSON value, as an OpenAPI 3 schema object. Only
+// the keywords the CFSSL API needs are supported.
+//
+// As with encoding/json, which the handlers decode requests with,
+// properties that aren't listed are ignored unless the schema is
+// strict, property names match case-insensitively, and null is
+// accepted for any property that isn't required.
+type Schema struct {
+	// Ref refers to a schema of the document the schema is in, such
+	// as "#/components/schemas/Name". It isn't followed when
+	// validating, so only documents should use it.
+	Ref string `json:"$ref,omitempty"`
+
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+
+	// AnyOf lists alternative sets of properties an object must
+	// have, each given by the Required of a schema.
+	AnyOf []*Schema `json:"anyOf,omitempty"`
+
+	// AdditionalProperties describes the values of properties
+	// that aren't listed. If it is nil, their values aren't
+	// checked.
+	AdditionalProperties *Schema `json:"-"`
+
+	// Strict rejects properties that aren't listed, unless
+	// AdditionalProperties describes them.
+	Strict bool `json:"-"`
+
+	// Code is the CFSSL error code of values that don't match the
+	// schema, such as that of errors.New(errors.OCSPError,
+	// errors.InvalidStatus), so that they are reported as the
+	// handler would report them. If it is zero, they are reported
+	// as bad requests, as errors.NewBadRequest does.
+	Code int `json:"-"`
+}
+
+// validationCode is the error code of values that don't match a schema
+// without a code of its own.
+const validationCode = http.StatusBadRequest
+
+func (s *Schema) code() int {
+	if s.Code != 0 {
+		return s.Code
+	}
+	return validationCode
+}
+
+// MarshalJSON encodes the schema, stating whether the properties of a
+// strict object are restricted to those listed.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type schema Schema
+	out := struct {
+		*schema
+		AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+	}{schema: (*schema)(s)}
+	if s.AdditionalProperties != nil {
+		out.AdditionalProperties = s.AdditionalProperties
+	} else if s.Type == "object" && s.Strict {
+		out.AdditionalProperties = false
+	}
+	return json.Marshal(out)
+}
+
+// A FieldError reports why a field of a request doesn't match its
+// schema. Field is the path to the field, such as "names[0].C", and is
+// empty for the request as a whole. Code is the CFSSL error code of
+// the schema the field didn't match.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+// A ValidationError lists the fields of a request that don't match its
+// schema.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Code returns the CFSSL error code of the first invalid field.
+func (ve *ValidationError) Code() int {
+	if len(ve.Fields) == 0 {
+		return validationCode
+	}
+	return ve.Fields[0].Code
+}
+
+func (ve *ValidationError) Error() string {
+	msgs := make([]string, len(ve.Fields))
+	for i, fe := range ve.Fields {
+		msgs[i] = fieldMessage(fe)
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
+
+func fieldMessage(fe FieldError) string {
+	if fe.Field == "" {
+		return fe.Message
+	}
+	return fe.Field + " " + fe.Message
+}
+
+// Validate checks a value decoded from JSON, with numbers decoded as
+// json.Number, against the schema, returning an error for each field
+// that doesn't match it.
+func (s *Schema) Validate(v interface{}) []FieldError {
+	var errs []FieldError
+	s.validate("", v, &errs)
+	return errs
+}
+
+// ValidateJSON checks a JSON document against the schema.
+func (s *Schema) ValidateJSON(data []byte) []FieldError {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return []FieldError{{Message: "is not valid JSON: " + err.Error(), Code: s.code()}}
+	}
+	if dec.More() {
+		return []FieldError{{Message: "is not valid JSON: unexpected data after the value", Code: s.code()}}
+	}
+	return s.Validate(v)
+}
+
+// ValidateQuery checks the parameters of a URL query against the
+// schema, which describes them as the properties of an object. Each
+// parameter may only be given once.
+func (s *Schema) ValidateQuery(query map[string][]string) []FieldError {
+	var errs []FieldError
+	params := make(map[string]interface{}, len(query))
+	for name, values := range query {
+		if len(values) > 1 {
+			errs = append(errs, FieldError{name, "must only be given once", s.code()})
+			continue
+		}
+		params[name] = values[0]
+	}
+	return append(errs, s.Validate(params)...)
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func (s *Schema) validate(path string, v interface{}, errs *[]FieldError) {
+	fail := func(msg string) {
+		*errs = append(*errs, FieldError{path, msg, s.code()})
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		s.validateObject(path, obj, errs)
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		if s.Items != nil {
+			for i, item := range arr {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			values := make([]string, len(s.Enum))
+			for i, value := range s.Enum {
+				values[i] = strconv.Quote(value)
+			}
+			fail("must be one of " + strings.Join(values, ", "))
+			return
+		}
+		if msg := checkFormat(s.Format, str); msg != "" {
+			fail(msg)
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok || strings.ContainsAny(string(n), ".eE") {
+			fail("must be an integer")
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			fail("must be a number")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("must be a boolean")
+		}
+	}
+}
+
+func (s *Schema) validateObject(path string, obj map[string]interface{}, errs *[]FieldError) {
+	// Match the properties given to those of the schema as
+	// encoding/json does: exactly if possible, or else ignoring
+	// case.
+	given := map[string]interface{}{}
+	var names []string
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop, ok := s.property(name)
+		if !ok {
+			if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(join(path, name), obj[name], errs)
+			} else if s.Strict {
+				*errs = append(*errs, FieldError{join(path, name), "is not allowed", s.code()})
+			}
+			continue
+		}
+		given[prop] = obj[name]
+	}
+
+	for _, name := range s.Required {
+		if given[name] == nil {
+			*errs = append(*errs, FieldError{join(path, name), "is required", s.code()})
+		}
+	}
+	if len(s.AnyOf) > 0 && !s.anyOf(given) {
+		*errs = append(*errs, FieldError{path, "must have " + s.describeAnyOf(), s.code()})
+	}
+
+	var props []string
+	for name := range given {
+		props = append(props, name)
+	}
+	sort.Strings(props)
+	for _, name := range props {
+		if v := given[name]; v != nil {
+			s.Properties[name].validate(join(path, name), v, errs)
+		}
+	}
+}
+
+// property returns the name of the schema's property matching name.
+func (s *Schema) property(name string) (string, bool) {
+	if _, ok := s.Properties[name]; ok {
+		return name, true
+	}
+	for prop := range s.Properties {
+		if strings.EqualFold(prop, name) {
+			return prop, true
+		}
+	}
+	return "", false
+}
+
+// anyOf reports whether the properties given have all those required by
+// one of the schema's alternatives.
+func (s *Schema) anyOf(given map[string]interface{}) bool {
+	for _, alt := range s.AnyOf {
+		ok := true
+		for _, name := range alt.Required {
+			if given[name] == nil {
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// describeAnyOf describes the alternatives of the schema by the
+// properties each requires, such as "certificate or domain".
+func (s *Schema) describeAnyOf() string {
+	alts := make([]string, len(s.AnyOf))
+	for i, alt := range s.AnyOf {
+		alts[i] = strings.Join(alt.Required, " and ")
+	}
+	return strings.Join(alts, " or ")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFormat returns why str doesn't have the format, if it doesn't.
+func checkFormat(format, str string) string {
+	switch format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			return "must be an RFC 3339 date and time"
+		}
+	case "duration":
+		if _, err := time.ParseDuration(str); err != nil {
+			return "must be a duration, such as 1h30m"
+		}
+	case "byte":
+		if _, err := base64.StdEncoding.DecodeString(str); err != nil {
+			return "must be base64 encoded"
+		}
+	}
+	return ""
+}
+
+// validateRequest checks the query and body of a request against the
+// handler's schemas. The body is replaced, so that the handler can
+// read it.
+func (h HTTPHandler) validateRequest(r *http.Request) error {
+	var fields []FieldError
+	if h.Query != nil {
+		fields = append(fields, h.Query.ValidateQuery(r.URL.Query())...)
+	}
+	if h.Body != nil && r.Method != "GET" && r.Method != "HEAD" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		fields = append(fields, h.Body.ValidateJSON(body)...)
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var testSchema = &Schema{
+	Type: "object",
+	Properties: map[string]*Schema{
+		"name":    {Type: "string"},
+		"count":   {Type: "integer"},
+		"ratio":   {Type: "number"},
+		"enabled": {Type: "boolean"},
+		"kind":    {Type: "string", Enum: []string{"a", "b"}},
+		"expiry":  {Type: "string", Format: "duration"},
+		"since":   {Type: "string", Format: "date-time"},
+		"blob":    {Type: "string", Format: "byte"},
+		"hosts":   {Type: "array", Items: &Schema{Type: "string"}},
+		"names": {Type: "array", Items: &Schema{
+			Type:       "object",
+			Properties: map[string]*Schema{"C": {Type: "string"}},
+			Strict:     true,
+		}},
+		"labels": {Type: "object", AdditionalProperties: &Schema{Type: "string"}},
+		"nested": {Type: "object", Properties: map[string]*Schema{"n": {Type: "integer", Code: 8200}}},
+	},
+	Required: []string{"name"},
+	Strict:   true,
+}
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		json   string
+		errors []FieldError
+	}{
+		{`{"name": "x"}`, nil},
+		{`{"NAME": "x", "count": 3, "ratio": 0.5, "enabled": true, "kind": "b"}`, nil},
+		{`{"name": "x", "expiry": "1h30m", "since": "2020-01-02T03:04:05Z", "blob": "AQID"}`, nil},
+		{`{"name": "x", "hosts": ["a", "b"], "names": [{"C": "US"}], "labels": {"k": "v"}}`, nil},
+		{`{"name": "x", "count": null, "hosts": null}`, nil},
+		{`{}`, []FieldError{{"name", "is required", 400}}},
+		{`{"name": null}`, []FieldError{{"name", "is required", 400}}},
+		{`[]`, []FieldError{{"", "must be an object", 400}}},
+		{`{"name": "x"} {}`, []FieldError{{"", "is not valid JSON: unexpected data after the value", 400}}},
+		{`{"name": "x", "other": 1}`, []FieldError{{"other", "is not allowed", 400}}},
+		{`{"name": 1}`, []FieldError{{"name", "must be a string", 400}}},
+		{`{"name": "x", "count": 1.5}`, []FieldError{{"count", "must be an integer", 400}}},
+		{`{"name": "x", "count": "1"}`, []FieldError{{"count", "must be an integer", 400}}},
+		{`{"name": "x", "ratio": true}`, []FieldError{{"ratio", "must be a number", 400}}},
+		{`{"name": "x", "enabled": "yes"}`, []FieldError{{"enabled", "must be a boolean", 400}}},
+		{`{"name": "x", "kind": "c"}`, []FieldError{{"kind", `must be one of "a", "b"`, 400}}},
+		{`{"name": "x", "expiry": "soon"}`, []FieldError{{"expiry", "must be a duration, such as 1h30m", 400}}},
+		{`{"name": "x", "since": "2020-01-02"}`, []FieldError{{"since", "must be an RFC 3339 date and time", 400}}},
+		{`{"name": "x", "blob": "!"}`, []FieldError{{"blob", "must be base64 encoded", 400}}},
+		{`{"name": "x", "hosts": "a"}`, []FieldError{{"hosts", "must be an array", 400}}},
+		{`{"name": "x", "hosts": ["a", 2]}`, []FieldError{{"hosts[1]", "must be a string", 400}}},
+		{`{"name": "x", "names": [{"C": "US"}, {"C": "UK", "X": "?"}]}`, []FieldError{{"names[1].X", "is not allowed", 400}}},
+		{`{"name": "x", "labels": {"k": 1}}`, []FieldError{{"labels.k", "must be a string", 400}}},
+		{`{"name": "x", "nested": {"n": 1, "m": 2}}`, nil},
+		{`{"name": "x", "nested": {"n": "1"}}`, []FieldError{{"nested.n", "must be an integer", 8200}}},
+		{`{"count": "1", "other": 1}`, []FieldError{
+			{"other", "is not allowed", 400},
+			{"name", "is required", 400},
+			{"count", "must be an integer", 400},
+		}},
+	}
+
+	for _, test := range tests {
+		errs := testSchema.ValidateJSON([]byte(test.json))
+		if !reflect.DeepEqual(errs, test.errors) {
+			t.Errorf("%s: have %v, want %v", test.json, errs, test.errors)
+		}
+	}
+}
+
+func TestValidateAnyOf(t *testing.T) {
+	s := &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"certificate": {Type: "string"},
+			"domain":      {Type: "string"},
+		},
+		AnyOf: []*Schema{{Required: []string{"certificate"}}, {Required: []string{"domain"}}},
+	}
+	for _, valid := range []string{`{"certificate": "x"}`, `{"domain": "x"}`, `{"certificate": "x", "domain": "y"}`} {
+		if errs := s.ValidateJSON([]byte(valid)); errs != nil {
+			t.Errorf("%s: unexpected errors %v", valid, errs)
+		}
+	}
+	errs := s.ValidateJSON([]byte(`{"domain": null}`))
+	want := []FieldError{{"", "must have certificate or domain", 400}}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("have %v, want %v", errs, want)
+	}
+}
+
+func TestValidateQuery(t *testing.T) {
+	s := &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"host":    {Type: "string"},
+			"timeout": {Type: "string", Format: "duration"},
+		},
+		Required: []string{"host"},
+		Strict:   true,
+	}
+	if errs := s.ValidateQuery(map[string][]string{"host": {"example.com"}, "timeout": {"1m"}}); errs != nil {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	errs := s.ValidateQuery(map[string][]string{"host": {"a", "b"}, "timeout": {"x"}, "ip": {"1.2.3.4"}})
+	want := []FieldError{
+		{"host", "must only be given once", 400},
+		{"ip", "is not allowed", 400},
+		{"host", "is required", 400},
+		{"timeout", "must be a duration, such as 1h30m", 400},
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("have %v, want %v", errs, want)
+	}
+}
+
+func TestSchemaMarshalJSON(t *testing.T) {
+	s := &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"open":  {Type: "object", AdditionalProperties: &Schema{}},
+			"plain": {Type: "object", Code: 8200},
+		},
+		Strict: true,
+	}
+	out, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"object","properties":{"open":{"type":"object","additionalProperties":{}},"plain":{"type":"object"}},"additionalProperties":false}`
+	if string(out) != want {
+		t.Fatalf("have %s, want %s", out, want)
+	}
+}
+
+func TestHTTPHandlerValidation(t *testing.T) {
+	ts := httptest.NewServer(HTTPHandler{
+		Handler: HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			var req map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				return err
+			}
+			return SendResponse(w, req["name"])
+		}),
+		Methods: []string{"POST"},
+		Body:    testSchema,
+	})
+	defer ts.Close()
+
+	resp, body := post(t, map[string]interface{}{"name": "x"}, ts)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("valid request: %s %s", resp.Status, body)
+	}
+	var response Response
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Result != "x" {
+		t.Fatalf("the handler didn't read the body: %s", body)
+	}
+
+	resp, body = post(t, map[string]interface{}{"count": 1.5, "extra": true}, ts)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid request: %s %s", resp.Status, body)
+	}
+	response = Response{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatal(err)
+	}
+	want := []ResponseMessage{
+		{Code: http.StatusBadRequest, Message: "extra is not allowed", Field: "extra"},
+		{Code: http.StatusBadRequest, Message: "name is required", Field: "name"},
+		{Code: http.StatusBadRequest, Message: "count must be an integer", Field: "count"},
+	}
+	if response.Success || !reflect.DeepEqual(response.Errors, want) {
+		t.Fatalf("have %s, want errors %v", body, want)
+	}
+
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader([]byte("{")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("malformed request: %s", resp.Status)
+	}
+}
@@ -2,15 +2,21 @@
 package signhandler
 
 import (
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
 	"math/big"
 	"net/http"
 
 	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/auth"
 	"github.com/cloudflare/cfssl/bundler"
+	"github.com/cloudflare/cfssl/crypto/pkcs12"
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
 	"github.com/cloudflare/cfssl/errors"
+	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/signer"
 )
@@ -53,6 +59,7 @@ func NewHandlerFromSigner(signer signer.Signer) (h *api.HTTPHandler, err error)
 			signer: signer,
 		},
 		Methods: []string{"POST"},
+		Body:    openapi.SignRequest,
 	}, nil
 }
 
@@ -74,6 +81,8 @@ type jsonSignRequest struct {
 	Label    string          `json:"label"`
 	Serial   *big.Int        `json:"serial,omitempty"`
 	Bundle   bool            `json:"bundle"`
+	Format   string          `json:"format"`
+	Password string          `json:"password"`
 }
 
 func jsonReqToTrue(js jsonSignRequest) signer.SignRequest {
@@ -106,6 +115,55 @@ func jsonReqToTrue(js jsonSignRequest) signer.SignRequest {
 	}
 }
 
+// checkFormat validates the requested output format, and the password
+// a PKCS #12 archive requires, before anything is signed.
+func checkFormat(format, password string) error {
+	switch format {
+	case "", "pem", "pkcs7":
+		return nil
+	case "pkcs12":
+		if password == "" {
+			return errors.NewBadRequestString("a password is required for a PKCS #12 archive")
+		}
+		return nil
+	}
+	return errors.NewBadRequestString("unsupported format " + format)
+}
+
+// addFormat adds the signed certificate, followed by the bundle chain if
+// one was built, to result as the base64 DER encoding of the requested
+// format.  As the server never sees the private key, PKCS #12 archives
+// hold certificates only.
+func addFormat(result map[string]interface{}, cert []byte, bundle *bundler.Bundle, format, password string) error {
+	if format == "" || format == "pem" {
+		return nil
+	}
+
+	var chain []*x509.Certificate
+	if bundle != nil {
+		chain = bundle.Chain
+	} else {
+		parsed, err := helpers.ParseCertificatePEM(cert)
+		if err != nil {
+			return err
+		}
+		chain = []*x509.Certificate{parsed}
+	}
+
+	var der []byte
+	var err error
+	if format == "pkcs7" {
+		der, err = pkcs7.DegenerateCertificates(chain)
+	} else {
+		der, err = pkcs12.Encode(nil, chain, password)
+	}
+	if err != nil {
+		return errors.Wrap(errors.CertificateError, errors.Unknown, err)
+	}
+	result[format] = base64.StdEncoding.EncodeToString(der)
+	return nil
+}
+
 // Handle responds to requests for the CA to sign the certificate request
 // present in the "certificate_request" parameter for the host named
 // in the "hostname" parameter. The certificate should be PEM-encoded. If
@@ -133,6 +191,10 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 		return errors.NewBadRequestString("missing parameter 'certificate_request'")
 	}
 
+	if err = checkFormat(req.Format, req.Password); err != nil {
+		return err
+	}
+
 	var cert []byte
 	profile, err := signer.Profile(h.signer, req.Profile)
 	if err != nil {
@@ -151,19 +213,23 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	result := map[string]interface{}{"certificate": string(cert)}
+	var bundle *bundler.Bundle
 	if req.Bundle {
 		if h.bundler == nil {
 			return api.SendResponseWithMessage(w, result, NoBundlerMessage,
 				errors.New(errors.PolicyError, errors.InvalidRequest).ErrorCode)
 		}
 
-		bundle, err := h.bundler.BundleFromPEMorDER(cert, nil, bundler.Optimal, "")
+		bundle, err = h.bundler.BundleFromPEMorDER(cert, nil, bundler.Optimal, "")
 		if err != nil {
 			return err
 		}
 
 		result["bundle"] = bundle
 	}
+	if err = addFormat(result, cert, bundle, req.Format, req.Password); err != nil {
+		return err
+	}
 	log.Info("wrote response")
 	return api.SendResponse(w, result)
 }
@@ -206,6 +272,7 @@ func NewAuthHandlerFromSigner(signer signer.Signer) (http.Handler, error) {
 			signer: signer,
 		},
 		Methods: []string{"POST"},
+		Body:    openapi.AuthenticatedRequest,
 	}, nil
 }
 
@@ -269,6 +336,10 @@ func (h *AuthHandler) Handle(w http.ResponseWriter, r *http.Request) error {
 		return errors.NewBadRequestString("missing parameter 'certificate_request'")
 	}
 
+	if err = checkFormat(req.Format, req.Password); err != nil {
+		return err
+	}
+
 	cert, err := h.signer.Sign(signReq)
 	if err != nil {
 		log.Errorf("signature failed: %v", err)
@@ -276,19 +347,23 @@ func (h *AuthHandler) Handle(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	result := map[string]interface{}{"certificate": string(cert)}
+	var bundle *bundler.Bundle
 	if req.Bundle {
 		if h.bundler == nil {
 			return api.SendResponseWithMessage(w, result, NoBundlerMessage,
 				errors.New(errors.PolicyError, errors.InvalidRequest).ErrorCode)
 		}
 
-		bundle, err := h.bundler.BundleFromPEMorDER(cert, nil, bundler.Optimal, "")
+		bundle, err = h.bundler.BundleFromPEMorDER(cert, nil, bundler.Optimal, "")
 		if err != nil {
 			return err
 		}
 
 		result["bundle"] = bundle
 	}
+	if err = addFormat(result, cert, bundle, req.Format, req.Password); err != nil {
+		return err
+	}
 	log.Info("wrote response")
 	return api.SendResponse(w, result)
 }
@@ -2,6 +2,7 @@ package signhandler
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -13,6 +14,8 @@ import (
 	"github.com/cloudflare/cfssl/certdb/sql"
 	"github.com/cloudflare/cfssl/certdb/testdb"
 	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
+	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/signer"
 	"github.com/cloudflare/cfssl/signer/local"
 )
@@ -111,3 +114,93 @@ func TestSignerDBPersistence(t *testing.T) {
 		t.Fatal("Expected 1 unexpired certificate in the database after signing 1: len(crs)=", len(crs))
 	}
 }
+
+func TestSignFormats(t *testing.T) {
+	conf, err := config.LoadConfig([]byte(validLocalConfigLongerExpiry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := local.NewSignerFromFile(testCaFile, testCaKeyFile, conf.Signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewHandlerFromSigner(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	csrPEM, err := ioutil.ReadFile(testCSRFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"pkcs7", "pkcs12", "bogus"} {
+		blob, err := json.Marshal(map[string]string{
+			"certificate_request": string(csrPEM),
+			"format":              format,
+			"password":            "password",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if format == "bogus" {
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("expected %d for an unsupported format, got %d", http.StatusBadRequest, resp.StatusCode)
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("format %s: unexpected status %d: %s", format, resp.StatusCode, body)
+		}
+
+		var message struct {
+			Result map[string]string `json:"result"`
+		}
+		if err = json.Unmarshal(body, &message); err != nil {
+			t.Fatal(err)
+		}
+		der, err := base64.StdEncoding.DecodeString(message.Result[format])
+		if err != nil || len(der) == 0 {
+			t.Fatalf("format %s: missing or malformed result: %v", format, err)
+		}
+		cert, err := helpers.ParseCertificatePEM([]byte(message.Result["certificate"]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if format == "pkcs7" {
+			p7, err := pkcs7.ParsePKCS7(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if certs := p7.Content.SignedData.Certificates; len(certs) != 1 || !certs[0].Equal(cert) {
+				t.Fatal("PKCS #7 result does not hold the signed certificate")
+			}
+		}
+	}
+
+	blob, err := json.Marshal(map[string]string{
+		"certificate_request": string(csrPEM),
+		"format":              "pkcs12",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected %d for a PKCS #12 archive without a password, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
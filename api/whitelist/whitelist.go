@@ -0,0 +1,287 @@
+// Package whitelist implements the HTTP handler for administering the
+// server's network whitelists.
+package whitelist
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/api/openapi"
+	"github.com/cloudflare/cfssl/auth"
+	"github.com/cloudflare/cfssl/errors"
+	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/whitelist/admin"
+)
+
+// MaxRequestAge is the age beyond which requests are rejected. Within
+// it, each request is only accepted once, so that captured requests
+// can't be replayed.
+const MaxRequestAge = 5 * time.Minute
+
+// maxRequestLength bounds the body of requests.
+const maxRequestLength = 1 << 16
+
+// maxSeen bounds the number of accepted requests remembered until they
+// expire. Once it is reached, requests are refused until some expire.
+const maxSeen = 1 << 14
+
+// The actions a request may ask for.
+const (
+	ActionAdd     = admin.ActionAdd
+	ActionRemove  = admin.ActionRemove
+	ActionList    = "list"
+	ActionHistory = "history"
+)
+
+// A Request is the request authenticated by the token of the
+// auth.AuthenticatedRequest sent to the endpoint.
+type Request struct {
+	// Whitelist names the whitelist; it may only be omitted to
+	// list all of them.
+	Whitelist string `json:"whitelist,omitempty"`
+
+	// Action is one of add, remove, list and history.
+	Action string `json:"action"`
+
+	// Entries are the IP addresses or networks to add or remove.
+	Entries []string `json:"entries,omitempty"`
+
+	// Actor names whoever is making the change, for the audit
+	// trail. It is overridden by the common name of the client's
+	// certificate, if it has one.
+	Actor string `json:"actor,omitempty"`
+
+	// Timestamp is the time the request was made, in seconds since
+	// the epoch.
+	Timestamp int64 `json:"timestamp"`
+
+	// Nonce distinguishes requests that would otherwise be the
+	// same, such as two made within a second, as a request is only
+	// accepted once.
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// A Response holds the entries of the listed, or changed, whitelists,
+// or the history of one.
+type Response struct {
+	Whitelists map[string][]string `json:"whitelists,omitempty"`
+	History    []admin.Change      `json:"history,omitempty"`
+}
+
+// A Handler administers the whitelists of a manager.
+type Handler struct {
+	manager  *admin.Manager
+	provider auth.Provider
+
+	// seen holds the digests of the requests accepted until they
+	// expire, and expiries orders them by expiry.
+	lock     sync.Mutex
+	seen     map[[sha256.Size]byte]bool
+	expiries seenQueue
+}
+
+// A seenRequest is the digest of an accepted request and the time it
+// expires.
+type seenRequest struct {
+	digest  [sha256.Size]byte
+	expires time.Time
+}
+
+// A seenQueue is a heap of accepted requests, the first to expire
+// first.
+type seenQueue []seenRequest
+
+func (q seenQueue) Len() int            { return len(q) }
+func (q seenQueue) Less(i, j int) bool  { return q[i].expires.Before(q[j].expires) }
+func (q seenQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *seenQueue) Push(x interface{}) { *q = append(*q, x.(seenRequest)) }
+
+func (q *seenQueue) Pop() interface{} {
+	old := *q
+	r := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return r
+}
+
+// NewHandler returns a new http.Handler that administers the
+// whitelists of m, for requests authenticated by provider.
+func NewHandler(m *admin.Manager, provider auth.Provider) http.Handler {
+	h := &api.HTTPHandler{
+		Handler: &Handler{
+			manager:  m,
+			provider: provider,
+			seen:     map[[sha256.Size]byte]bool{},
+		},
+		Methods: []string{"POST"},
+		Body:    openapi.AuthenticatedRequest,
+	}
+	// The body is limited before it is read to be validated.
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = &limitedBody{ReadCloser: r.Body, remaining: maxRequestLength}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// A limitedBody fails reads beyond its remaining length with a 413
+// error.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if b.remaining -= int64(n); b.remaining < 0 {
+		return 0, errors.NewRequestEntityTooLarge()
+	}
+	return n, err
+}
+
+// firstUse records a request until it expires, and returns an error if
+// it has been recorded already or too many requests are recorded.
+// Expired requests are forgotten.
+func (h *Handler) firstUse(request []byte, expires time.Time) error {
+	digest := sha256.Sum256(request)
+	now := time.Now()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for len(h.expiries) > 0 && now.After(h.expiries[0].expires) {
+		delete(h.seen, heap.Pop(&h.expiries).(seenRequest).digest)
+	}
+	if h.seen[digest] {
+		log.Warning("received replayed whitelist request")
+		return errors.NewBadRequestString("request has already been made")
+	}
+	if len(h.seen) >= maxSeen {
+		log.Warning("refused whitelist request: too many recent requests")
+		return errors.NewBadRequestString("too many recent requests")
+	}
+	h.seen[digest] = true
+	heap.Push(&h.expiries, seenRequest{digest: digest, expires: expires})
+	return nil
+}
+
+// actor names the client making the request, from its verified
+// certificate or the request, and its address.
+func actor(r *http.Request, req *Request) string {
+	name := req.Actor
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && r.TLS.VerifiedChains[0][0].Subject.CommonName != "" {
+		name = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	if name == "" {
+		name = "unknown"
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return name + " (" + host + ")"
+}
+
+// Handle verifies the request, then lists, changes or shows the history
+// of whitelists.
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestLength+1))
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if len(body) > maxRequestLength {
+		return errors.NewRequestEntityTooLarge()
+	}
+
+	var aReq auth.AuthenticatedRequest
+	if err = json.Unmarshal(body, &aReq); err != nil {
+		return errors.NewBadRequest(err)
+	}
+	if !h.provider.Verify(&aReq) {
+		log.Warning("received whitelist request with invalid token")
+		return errors.NewBadRequestString("invalid token")
+	}
+
+	var req Request
+	if err = json.Unmarshal(aReq.Request, &req); err != nil {
+		return errors.NewBadRequestString("Unable to parse whitelist request")
+	}
+	made := time.Unix(req.Timestamp, 0)
+	if age := time.Since(made); age > MaxRequestAge || age < -MaxRequestAge {
+		return errors.NewBadRequestString("request has expired")
+	}
+	if err = h.firstUse(aReq.Request, made.Add(MaxRequestAge)); err != nil {
+		return err
+	}
+
+	names := []string{req.Whitelist}
+	switch req.Action {
+	case ActionList:
+		if req.Whitelist == "" {
+			names = h.manager.Names()
+		}
+	case ActionAdd, ActionRemove:
+		if len(req.Entries) == 0 {
+			return errors.NewBadRequestMissingParameter("entries")
+		}
+		for _, entry := range req.Entries {
+			if _, err = admin.ParseEntry(entry); err != nil {
+				return errors.NewBadRequest(err)
+			}
+		}
+	case ActionHistory:
+	default:
+		return errors.NewBadRequestString("unknown action " + req.Action)
+	}
+	if len(names) == 1 && names[0] == "" {
+		return errors.NewBadRequestMissingParameter("whitelist")
+	}
+
+	switch req.Action {
+	case ActionHistory:
+		history, err := h.manager.History(req.Whitelist)
+		if err != nil {
+			return managerError(err)
+		}
+		return api.SendResponse(w, Response{History: history})
+	case ActionAdd, ActionRemove:
+		change := h.manager.Add
+		if req.Action == ActionRemove {
+			change = h.manager.Remove
+		}
+		who := actor(r, &req)
+		for _, entry := range req.Entries {
+			if err = change(req.Whitelist, entry, who); err != nil {
+				return managerError(err)
+			}
+		}
+	}
+
+	resp := Response{Whitelists: map[string][]string{}}
+	for _, name := range names {
+		entries, err := h.manager.Entries(name)
+		if err != nil {
+			return managerError(err)
+		}
+		resp.Whitelists[name] = entries
+	}
+	return api.SendResponse(w, resp)
+}
+
+// managerError reports unknown whitelists as bad requests.
+func managerError(err error) error {
+	if err == admin.ErrUnknownWhitelist {
+		return errors.NewBadRequest(err)
+	}
+	return err
+}
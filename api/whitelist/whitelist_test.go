@@ -0,0 +1,199 @@
+package whitelist
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/api"
+	"github.com/cloudflare/cfssl/auth"
+	"github.com/cloudflare/cfssl/whitelist/admin"
+)
+
+const testKey = "0123456789ABCDEF0123456789ABCDEF"
+
+// newTestServer starts a server administering a whitelist kept in a
+// new directory, and returns it with its provider and a function
+// stopping it and removing the directory.
+func newTestServer(t *testing.T) (*httptest.Server, *auth.Standard, func()) {
+	dir, err := ioutil.TempDir("", "whitelist")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := admin.NewFileStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	m := admin.NewManager(store)
+	if _, err = m.Register("serve", []string{"127.0.0.1"}); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	provider, err := auth.New(testKey, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewHandler(m, provider))
+	return ts, provider, func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func post(t *testing.T, ts *httptest.Server, provider auth.Provider, req Request) (int, Response) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aReq := auth.AuthenticatedRequest{Request: body}
+	if provider != nil {
+		if aReq.Token, err = provider.Token(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blob, err := json.Marshal(aReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var response Response
+	apiResp := api.Response{Result: &response}
+	if err = json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, response
+}
+
+func TestWhitelistHandler(t *testing.T) {
+	ts, provider, stop := newTestServer(t)
+	defer stop()
+	now := time.Now().Unix()
+
+	status, resp := post(t, ts, provider, Request{Action: ActionAdd, Whitelist: "serve", Entries: []string{"10.0.0.0/8"}, Actor: "alice", Timestamp: now})
+	if status != http.StatusOK {
+		t.Fatalf("add failed with status %d", status)
+	}
+	if entries := resp.Whitelists["serve"]; len(entries) != 2 || entries[0] != "10.0.0.0/8" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+
+	status, resp = post(t, ts, provider, Request{Action: ActionList, Timestamp: now})
+	if status != http.StatusOK || len(resp.Whitelists) != 1 {
+		t.Fatalf("list failed with status %d: %v", status, resp.Whitelists)
+	}
+
+	status, resp = post(t, ts, provider, Request{Action: ActionHistory, Whitelist: "serve", Timestamp: now})
+	if status != http.StatusOK || len(resp.History) != 2 {
+		t.Fatalf("history failed with status %d: %v", status, resp.History)
+	}
+	if actor := resp.History[1].Actor; actor != "alice (127.0.0.1)" {
+		t.Fatalf("unexpected actor %q", actor)
+	}
+}
+
+func TestWhitelistHandlerFail(t *testing.T) {
+	ts, provider, stop := newTestServer(t)
+	defer stop()
+	now := time.Now().Unix()
+
+	other, err := auth.New("00000000000000000000000000000000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		provider auth.Provider
+		req      Request
+	}{
+		{nil, Request{Action: ActionList, Timestamp: now}},
+		{other, Request{Action: ActionList, Timestamp: now}},
+		{provider, Request{Action: ActionList, Timestamp: now - 3600}},
+		{provider, Request{Action: "replace", Whitelist: "serve", Timestamp: now}},
+		{provider, Request{Action: ActionAdd, Whitelist: "serve", Timestamp: now}},
+		{provider, Request{Action: ActionAdd, Whitelist: "serve", Entries: []string{"example.com"}, Timestamp: now}},
+		{provider, Request{Action: ActionAdd, Whitelist: "web", Entries: []string{"10.0.0.1"}, Timestamp: now}},
+		{provider, Request{Action: ActionHistory, Timestamp: now}},
+	} {
+		if status, _ := post(t, ts, test.provider, test.req); status != http.StatusBadRequest {
+			t.Fatalf("%+v: expected status 400, but got %d", test.req, status)
+		}
+	}
+}
+
+func TestWhitelistHandlerReplay(t *testing.T) {
+	ts, provider, stop := newTestServer(t)
+	defer stop()
+	now := time.Now().Unix()
+
+	add := Request{Action: ActionAdd, Whitelist: "serve", Entries: []string{"10.0.0.0/8"}, Timestamp: now}
+	if status, _ := post(t, ts, provider, add); status != http.StatusOK {
+		t.Fatalf("add failed with status %d", status)
+	}
+	if status, _ := post(t, ts, provider, add); status != http.StatusBadRequest {
+		t.Fatalf("replayed request: expected status 400, but got %d", status)
+	}
+
+	// The same change, made again, is a different request.
+	add.Nonce = "again"
+	if status, _ := post(t, ts, provider, add); status != http.StatusOK {
+		t.Fatalf("repeated add failed with status %d", status)
+	}
+}
+
+func TestWhitelistHandlerTooLarge(t *testing.T) {
+	ts, _, stop := newTestServer(t)
+	defer stop()
+
+	body := append([]byte(`{"token":"","request":"`), bytes.Repeat([]byte("A"), maxRequestLength)...)
+	body = append(body, `"}`...)
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413, but got %d", resp.StatusCode)
+	}
+}
+
+func TestFirstUseExpiry(t *testing.T) {
+	h := &Handler{seen: map[[sha256.Size]byte]bool{}}
+	now := time.Now()
+
+	if err := h.firstUse([]byte("old"), now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.firstUse([]byte("new"), now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.firstUse([]byte("new"), now.Add(time.Minute)); err == nil {
+		t.Fatal("replayed request was accepted")
+	}
+	// The expired request was forgotten; the other is kept.
+	if len(h.seen) != 1 || len(h.expiries) != 1 || h.expiries[0].expires != now.Add(time.Minute) {
+		t.Fatalf("unexpected requests remembered: %+v", h.expiries)
+	}
+
+	for i := len(h.seen); i < maxSeen; i++ {
+		h.seen[sha256.Sum256([]byte{byte(i), byte(i >> 8)})] = true
+	}
+	if err := h.firstUse([]byte("full"), now.Add(time.Minute)); err == nil {
+		t.Fatal("request was accepted beyond the limit")
+	}
+}
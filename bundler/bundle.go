@@ -12,6 +12,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/cloudflare/cfssl/crypto/pkcs12"
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
 	"github.com/cloudflare/cfssl/helpers"
 )
 
@@ -158,6 +160,31 @@ func (b *Bundle) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// PKCS7 returns the bundle chain, leaf first, as a DER-encoded degenerate
+// PKCS #7 SignedData (a .p7b file).
+func (b *Bundle) PKCS7() ([]byte, error) {
+	if b == nil || len(b.Chain) == 0 {
+		return nil, errors.New("no certificate in bundle")
+	}
+	return pkcs7.DegenerateCertificates(b.Chain)
+}
+
+// PKCS12 returns the bundle chain, and the private key if the bundle
+// holds one, as a DER-encoded PKCS #12 archive protected by password.
+// Keys that cannot be exported, such as those held by Red October, are
+// left out of the archive.
+func (b *Bundle) PKCS12(password string) ([]byte, error) {
+	if b == nil || len(b.Chain) == 0 {
+		return nil, errors.New("no certificate in bundle")
+	}
+	var key interface{}
+	switch b.Key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		key = b.Key
+	}
+	return pkcs12.Encode(key, b.Chain, password)
+}
+
 // buildHostnames sets bundle.Hostnames by the x509 cert's subject CN and DNS names
 // Since the subject CN may overlap with one of the DNS names, it needs to handle
 // the duplication by a set.
@@ -2,9 +2,20 @@ package bundler
 
 // This test file contains tests on checking the correctness of BundleFromRemote
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"flag"
+	"math/big"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cloudflare/cfssl/starttls/starttlstest"
 	"github.com/cloudflare/cfssl/ubiquity"
 )
 
@@ -186,3 +197,88 @@ func TestBundleFromRemoteFlavor(t *testing.T) {
 	}
 
 }
+
+// newSTARTTLSChain returns a root certificate and a leaf certificate for
+// mail.example.com, with the leaf's key, all PEM-encoded.
+func newSTARTTLSChain(t *testing.T) (rootPEM, leafPEM, keyPEM []byte) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "STARTTLS Test Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, root, root, rootKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root, err = x509.ParseCertificate(rootDER); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "mail.example.com"},
+		DNSNames:     []string{"mail.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, root, key.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestBundleFromRemoteSTARTTLS(t *testing.T) {
+	rootPEM, leafPEM, keyPEM := newSTARTTLSChain(t)
+	b, err := NewBundlerFromPEM(rootPEM, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, proto := range []string{"smtp", "postgres"} {
+		server, err := starttlstest.NewServer(proto, leafPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, port, _ := net.SplitHostPort(server.Addr)
+
+		bundle, err := b.BundleFromRemote(proto+"://mail.example.com:"+port, "127.0.0.1", Optimal)
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", proto, err)
+		}
+		if bundle.Cert.Subject.CommonName != "mail.example.com" {
+			t.Fatalf("%s: unexpected certificate %s", proto, bundle.Cert.Subject.CommonName)
+		}
+		for _, msg := range bundle.Status.Messages {
+			if strings.HasPrefix(msg, "Failed rigid TLS handshake") {
+				t.Fatalf("%s: %s", proto, msg)
+			}
+		}
+	}
+
+	if _, err = b.BundleFromRemote("gopher://mail.example.com", "", Optimal); err == nil {
+		t.Fatal("expected an unknown STARTTLS protocol to be rejected")
+	}
+}
@@ -14,16 +14,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
-	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/cloudflare/cfssl/aia"
 	"github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/starttls"
 	"github.com/cloudflare/cfssl/ubiquity"
 )
 
@@ -67,7 +69,12 @@ type Bundler struct {
 }
 
 type options struct {
-	keyUsages []x509.ExtKeyUsage
+	keyUsages      []x509.ExtKeyUsage
+	policies       map[BundleFlavor]ChainPolicy
+	prefer         []string
+	avoid          []string
+	maxChainLength int
+	cache          *aia.Cache
 }
 
 var defaultOptions = options{
@@ -87,6 +94,51 @@ func WithKeyUsages(usages ...x509.ExtKeyUsage) Option {
 	}
 }
 
+// WithChainPolicy sets the chain policy of a flavor. It may replace
+// the policy of Optimal or Ubiquitous, or add a flavor.
+func WithChainPolicy(flavor BundleFlavor, policy ChainPolicy) Option {
+	return func(o *options) {
+		policies := make(map[BundleFlavor]ChainPolicy, len(o.policies)+1)
+		for f, p := range o.policies {
+			policies[f] = p
+		}
+		policies[flavor] = policy
+		o.policies = policies
+	}
+}
+
+// WithPreferredIntermediates adds intermediates, by hex SHA-256
+// fingerprint, to prefer in the chains of every flavor.
+func WithPreferredIntermediates(fingerprints ...string) Option {
+	return func(o *options) {
+		o.prefer = append(append([]string{}, o.prefer...), fingerprints...)
+	}
+}
+
+// WithAvoidedIntermediates adds intermediates, by hex SHA-256
+// fingerprint, to avoid in the chains of every flavor.
+func WithAvoidedIntermediates(fingerprints ...string) Option {
+	return func(o *options) {
+		o.avoid = append(append([]string{}, o.avoid...), fingerprints...)
+	}
+}
+
+// WithMaxChainLength limits the chains of every flavor to n
+// certificates, counting the leaf and the root.
+func WithMaxChainLength(n int) Option {
+	return func(o *options) {
+		o.maxChainLength = n
+	}
+}
+
+// WithIntermediateCache sets the cache used for the intermediates
+// fetched through AIA URLs. By default, aia.Default is shared.
+func WithIntermediateCache(cache *aia.Cache) Option {
+	return func(o *options) {
+		o.cache = cache
+	}
+}
+
 // NewBundler creates a new Bundler from the files passed in; these
 // files should contain a list of valid root certificates and a list
 // of valid intermediate certificates, respectively.
@@ -137,6 +189,18 @@ func NewBundlerFromPEM(caBundlePEM, intBundlePEM []byte, opt ...Option) (*Bundle
 	for _, o := range opt {
 		o(&opts)
 	}
+	for flavor, p := range opts.policies {
+		if err := p.merge(opts).validate(flavor); err != nil {
+			return nil, err
+		}
+	}
+	if opts.maxChainLength < 0 {
+		return nil, errors.Wrap(errors.PolicyError, errors.InvalidPolicy,
+			goerr.New("negative maximum chain length"))
+	}
+	if err := (ChainPolicy{Prefer: opts.prefer, Avoid: opts.avoid}).validate(Optimal); err != nil {
+		return nil, err
+	}
 
 	log.Debug("parsing root certificates from PEM")
 	roots, err := helpers.ParseCertificatesPEM(caBundlePEM)
@@ -191,6 +255,42 @@ func (b *Bundler) VerifyOptions() x509.VerifyOptions {
 	}
 }
 
+// Flavors returns the flavors the bundler can bundle with, sorted.
+func (b *Bundler) Flavors() []BundleFlavor {
+	flavors := []BundleFlavor{Force, Optimal, Ubiquitous}
+	for flavor := range b.opts.policies {
+		if flavor != Optimal && flavor != Ubiquitous {
+			flavors = append(flavors, flavor)
+		}
+	}
+	sort.Slice(flavors, func(i, j int) bool { return flavors[i] < flavors[j] })
+	return flavors
+}
+
+// HasFlavor returns true if the bundler can bundle with flavor.
+func (b *Bundler) HasFlavor(flavor BundleFlavor) bool {
+	for _, f := range b.Flavors() {
+		if f == flavor {
+			return true
+		}
+	}
+	return false
+}
+
+// policy returns the chain policy of flavor. Unknown flavors use the
+// Ubiquitous policy.
+func (b *Bundler) policy(flavor BundleFlavor) ChainPolicy {
+	p, ok := b.opts.policies[flavor]
+	if !ok {
+		if flavor == Optimal {
+			p = OptimalPolicy
+		} else {
+			p = UbiquitousPolicy
+		}
+	}
+	return p.merge(b.opts)
+}
+
 // BundleFromFile takes a set of files containing the PEM-encoded leaf certificate
 // (optionally along with some intermediate certs), the PEM-encoded private key
 // and returns the bundle built from that key and the certificate(s).
@@ -264,7 +364,22 @@ func (b *Bundler) BundleFromPEMorDER(certsRaw, keyPEM []byte, flavor BundleFlavo
 // is expected that the method will be able to make a connection at
 // port 443. The certificate used by the server in this connection is
 // used to build the bundle, which will necessarily be keyless.
+//
+// If serverName has a STARTTLS protocol as its scheme, such as
+// smtp://mail.example.com:587, the protocol is negotiated before the
+// TLS handshake, and the port defaults to the protocol's port.
 func (b *Bundler) BundleFromRemote(serverName, ip string, flavor BundleFlavor) (*Bundle, error) {
+	// A server name may carry a STARTTLS protocol as its scheme, as
+	// in smtp://mail.example.com, with an optional port.
+	proto, hostport, err := starttls.Split(serverName)
+	if err != nil {
+		return nil, errors.Wrap(errors.DialError, errors.Unknown, err)
+	}
+	port := "443"
+	if proto != "" {
+		serverName, port, _ = net.SplitHostPort(hostport)
+	}
+
 	config := &tls.Config{
 		RootCAs:    b.RootPool,
 		ServerName: serverName,
@@ -273,15 +388,17 @@ func (b *Bundler) BundleFromRemote(serverName, ip string, flavor BundleFlavor) (
 	// Dial by IP if present
 	var dialName string
 	if ip != "" {
-		dialName = ip + ":443"
+		dialName = net.JoinHostPort(ip, port)
+	} else if proto != "" {
+		dialName = hostport
 	} else {
-		dialName = serverName + ":443"
+		dialName = serverName + ":" + port
 	}
 
-	log.Debugf("bundling from remote %s", dialName)
+	log.Debugf("bundling from remote %s", starttls.Join(proto, dialName))
 
 	dialer := &net.Dialer{Timeout: time.Duration(5) * time.Second}
-	conn, err := tls.DialWithDialer(dialer, "tcp", dialName, config)
+	conn, err := dialRemote(dialer, dialName, proto, config)
 	var dialError string
 	// If there's an error in tls.Dial, try again with
 	// InsecureSkipVerify to fetch the remote bundle to (re-)bundle
@@ -295,12 +412,13 @@ func (b *Bundler) BundleFromRemote(serverName, ip string, flavor BundleFlavor) (
 		// dial again with InsecureSkipVerify
 		log.Debugf("try again with InsecureSkipVerify.")
 		config.InsecureSkipVerify = true
-		conn, err = tls.DialWithDialer(dialer, "tcp", dialName, config)
+		conn, err = dialRemote(dialer, dialName, proto, config)
 		if err != nil {
 			log.Debugf("dial with InsecureSkipVerify failed: %v", err)
 			return nil, errors.Wrap(errors.DialError, errors.Unknown, err)
 		}
 	}
+	defer conn.Close()
 
 	connState := conn.ConnectionState()
 
@@ -322,47 +440,56 @@ func (b *Bundler) BundleFromRemote(serverName, ip string, flavor BundleFlavor) (
 	return bundle, err
 }
 
+// dialRemote completes a TLS handshake with addr, first negotiating the
+// STARTTLS protocol proto if it is not empty.
+func dialRemote(dialer *net.Dialer, addr, proto string, config *tls.Config) (*tls.Conn, error) {
+	if proto == "" {
+		return tls.DialWithDialer(dialer, "tcp", addr, config)
+	}
+
+	conn, err := starttls.Dial(dialer, "tcp", addr, proto, config.ServerName)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(dialer.Timeout))
+	tlsConn := tls.Client(conn, config)
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
 type fetchedIntermediate struct {
 	Cert *x509.Certificate
 	Name string
 }
 
-// fetchRemoteCertificate retrieves a single URL pointing to a certificate
-// and attempts to first parse it as a DER-encoded certificate; if
-// this fails, it attempts to decode it as a PEM-encoded certificate.
-func fetchRemoteCertificate(certURL string) (fi *fetchedIntermediate, err error) {
+// fetchRemoteCertificate retrieves a single URL pointing to a
+// certificate through the bundler's intermediate cache, so that busy
+// bundlers do not fetch the same intermediate again and again.
+func (b *Bundler) fetchRemoteCertificate(certURL string) (fi *fetchedIntermediate, err error) {
 	log.Debugf("fetching remote certificate: %s", certURL)
-	var resp *http.Response
-	resp, err = http.Get(certURL)
-	if err != nil {
-		log.Debugf("failed HTTP get: %v", err)
-		return
-	}
-
-	defer resp.Body.Close()
-	var certData []byte
-	certData, err = ioutil.ReadAll(resp.Body)
+	crt, err := b.intermediateCache().Fetch(certURL)
 	if err != nil {
-		log.Debugf("failed to read response body: %v", err)
+		log.Debugf("failed to fetch certificate: %v", err)
 		return
 	}
 
-	log.Debugf("attempting to parse certificate as DER")
-	crt, err := x509.ParseCertificate(certData)
-	if err != nil {
-		log.Debugf("attempting to parse certificate as PEM")
-		crt, err = helpers.ParseCertificatePEM(certData)
-		if err != nil {
-			log.Debugf("failed to parse certificate: %v", err)
-			return
-		}
-	}
-
 	log.Debugf("certificate fetch succeeds")
 	fi = &fetchedIntermediate{Cert: crt, Name: constructCertFileName(crt)}
 	return
 }
 
+// intermediateCache returns the cache used for AIA fetches.
+func (b *Bundler) intermediateCache() *aia.Cache {
+	if b.opts.cache != nil {
+		return b.opts.cache
+	}
+	return aia.Default
+}
+
 func reverse(certs []*x509.Certificate) []*x509.Certificate {
 	n := len(certs)
 	if n == 0 {
@@ -439,7 +566,7 @@ func (b *Bundler) verifyChain(chain []*fetchedIntermediate) bool {
 
 			log.Debugf("write intermediate to stash directory: %s", fileName)
 			// If the write fails, verification should not fail.
-			err = ioutil.WriteFile(fileName, pem.EncodeToMemory(&block), 0644)
+			err = writeStash(fileName, pem.EncodeToMemory(&block))
 			if err != nil {
 				log.Errorf("failed to write new intermediate: %v", err)
 			} else {
@@ -450,6 +577,29 @@ func (b *Bundler) verifyChain(chain []*fetchedIntermediate) bool {
 	return true
 }
 
+// writeStash writes an intermediate to the stash through a temporary
+// file, so that concurrent bundles never leave a partial file.
+func writeStash(fileName string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fileName)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
 // constructCertFileName returns a uniquely identifying file name for a certificate
 func constructCertFileName(cert *x509.Certificate) string {
 	// construct the filename as the CN with no period and space
@@ -529,7 +679,7 @@ func (b *Bundler) fetchIntermediates(certs []*x509.Certificate) (err error) {
 				log.Debugf("url %s has been seen", url)
 				continue
 			}
-			crt, err := fetchRemoteCertificate(url)
+			crt, err := b.fetchRemoteCertificate(url)
 			if err != nil {
 				continue
 			} else if seen[string(crt.Cert.Signature)] {
@@ -649,20 +799,14 @@ func (b *Bundler) Bundle(certs []*x509.Certificate, key crypto.Signer, flavor Bu
 			}
 			log.Debugf("verify ok")
 		}
-		var matchingChains [][]*x509.Certificate
-		switch flavor {
-		case Optimal:
-			matchingChains = optimalChains(chains)
-		case Ubiquitous:
-			if len(ubiquity.Platforms) == 0 {
-				log.Warning("No metadata, Ubiquitous falls back to Optimal.")
-			}
-			matchingChains = ubiquitousChains(chains)
-		default:
-			matchingChains = ubiquitousChains(chains)
+		if flavor == Ubiquitous && len(ubiquity.Platforms) == 0 {
+			log.Warning("No metadata, Ubiquitous falls back to Optimal.")
 		}
 
-		bundle.Chain = matchingChains[0]
+		bundle.Chain, err = b.policy(flavor).choose(chains)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	statusCode := int(errors.Success)
@@ -792,36 +936,6 @@ func untrustedPlatformsWarning(platforms []string) string {
 	return msg
 }
 
-// Optimal chains are the shortest chains, with newest intermediates and most advanced crypto suite being the tie breaker.
-func optimalChains(chains [][]*x509.Certificate) [][]*x509.Certificate {
-	// Find shortest chains
-	chains = ubiquity.Filter(chains, ubiquity.CompareChainLength)
-	// Find the chains with longest expiry.
-	chains = ubiquity.Filter(chains, ubiquity.CompareChainExpiry)
-	// Find the chains with more advanced crypto suite
-	chains = ubiquity.Filter(chains, ubiquity.CompareChainCryptoSuite)
-
-	return chains
-}
-
-// Ubiquitous chains are the chains with highest platform coverage and break ties with the optimal strategy.
-func ubiquitousChains(chains [][]*x509.Certificate) [][]*x509.Certificate {
-	// Filter out chains with highest cross platform ubiquity.
-	chains = ubiquity.Filter(chains, ubiquity.ComparePlatformUbiquity)
-	// Prefer that all intermediates are SHA-2 certs if the leaf is a SHA-2 cert, in order to improve ubiquity.
-	chains = ubiquity.Filter(chains, ubiquity.CompareSHA2Homogeneity)
-	// Filter shortest chains
-	chains = ubiquity.Filter(chains, ubiquity.CompareChainLength)
-	// Filter chains with highest signature hash ubiquity.
-	chains = ubiquity.Filter(chains, ubiquity.CompareChainHashUbiquity)
-	// Filter chains with highest keyAlgo ubiquity.
-	chains = ubiquity.Filter(chains, ubiquity.CompareChainKeyAlgoUbiquity)
-	// Filter chains with intermediates that last longer.
-	chains = ubiquity.Filter(chains, ubiquity.CompareExpiryUbiquity)
-	// Use the optimal strategy as final tie breaker.
-	return optimalChains(chains)
-}
-
 // diff checkes if two input cert chains are not identical
 func diff(chain1, chain2 []*x509.Certificate) bool {
 	// Check if bundled one is different from the input.
@@ -9,9 +9,11 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
 	"github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/ubiquity"
+	"golang.org/x/crypto/pkcs12"
 )
 
 const (
@@ -922,3 +924,45 @@ func TestBundlerClientAuth(t *testing.T) {
 		}
 	}
 }
+
+func TestBundlePKCS7AndPKCS12(t *testing.T) {
+	b := newCustomizedBundlerFromFile(t, testCFSSLRootBundle, testCFSSLIntBundle, "")
+	bundle, err := b.BundleFromFile(leafECDSA256, leafKeyECDSA256, Force, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := bundle.PKCS7()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := pkcs7.ParsePKCS7(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := p7.Content.SignedData.Certificates
+	if len(certs) != len(bundle.Chain) || !certs[0].Equal(bundle.Cert) {
+		t.Fatal("PKCS #7 chain does not match the bundle")
+	}
+
+	der, err = bundle.PKCS12("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := pkcs12.ToPEM(der, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nCerts, nKeys int
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			nCerts++
+		case "PRIVATE KEY", "EC PRIVATE KEY":
+			nKeys++
+		}
+	}
+	if nCerts != len(bundle.Chain) || nKeys != 1 {
+		t.Fatalf("PKCS #12 archive holds %d certificates and %d keys", nCerts, nKeys)
+	}
+}
@@ -0,0 +1,64 @@
+package bundler
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/aia"
+)
+
+func TestBundleAIACache(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	rootKey, intKey, leafKey := newKey(t), newKey(t), newKey(t)
+	root := issue(t, 1, caTemplateExpiring("AIA Root", now.AddDate(10, 0, 0)), nil, &rootKey.PublicKey, rootKey)
+	intermediate := issue(t, 2, caTemplateExpiring("AIA Intermediate", now.AddDate(1, 0, 0)), root, &intKey.PublicKey, rootKey)
+
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write(intermediate.Raw)
+	}))
+	defer ts.Close()
+
+	leaf := issue(t, 3, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "aia.example.com"},
+		DNSNames:              []string{"aia.example.com"},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IssuingCertificateURL: []string{ts.URL + "/intermediate.crt"},
+		NotAfter:              now.AddDate(0, 0, 90),
+	}, intermediate, &leafKey.PublicKey, intKey)
+
+	dir, err := ioutil.TempDir("", "aia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := aia.New(aia.Config{Dir: dir})
+	// Each bundler starts without the intermediate, as a new
+	// bundle endpoint would.
+	for i := 0; i < 3; i++ {
+		b, err := NewBundlerFromPEM(encodePEM([]*x509.Certificate{root}), nil, WithIntermediateCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle, err := b.Bundle([]*x509.Certificate{leaf}, nil, Optimal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bundle.Chain) != 2 || !bundle.Chain[1].Equal(intermediate) {
+			t.Fatal("expected the fetched intermediate in the chain")
+		}
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("expected the intermediate to be fetched once, got %d fetches", n)
+	}
+}
@@ -0,0 +1,262 @@
+package bundler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cfssl/errors"
+	"github.com/cloudflare/cfssl/ubiquity"
+)
+
+// A ChainPolicy selects the bundle from the chains that verify a
+// certificate. The chains longer than MaxChainLength are discarded;
+// of the rest, those holding a preferred intermediate, then those
+// holding no avoided intermediate, are kept, and the ranking functions
+// are applied in order, each keeping the chains it ranks highest. Any
+// remaining tie is broken by the chains' fingerprints, so that the
+// same chain is always chosen.
+type ChainPolicy struct {
+	// Ranking names the ranking functions to apply, as registered
+	// with RegisterRanking.
+	Ranking []string `json:"ranking"`
+	// Prefer lists the hex SHA-256 fingerprints of intermediates to
+	// prefer. Cross-signed intermediates share their subject and
+	// key, so the fingerprint is what tells them apart.
+	Prefer []string `json:"prefer,omitempty"`
+	// Avoid lists the hex SHA-256 fingerprints of intermediates to
+	// avoid when another chain is available.
+	Avoid []string `json:"avoid,omitempty"`
+	// MaxChainLength is the greatest number of certificates,
+	// counting the leaf and the root, a chain may have. Zero means
+	// no limit.
+	MaxChainLength int `json:"max_chain_length,omitempty"`
+}
+
+var (
+	rankingLock sync.RWMutex
+	rankings    = map[string]ubiquity.RankingFunc{
+		"platform_ubiquity": ubiquity.ComparePlatformUbiquity,
+		"sha2_homogeneity":  ubiquity.CompareSHA2Homogeneity,
+		"chain_length":      ubiquity.CompareChainLength,
+		"chain_expiry":      ubiquity.CompareChainExpiry,
+		"crypto_suite":      ubiquity.CompareChainCryptoSuite,
+		"hash_priority":     ubiquity.CompareChainHashPriority,
+		"key_algo_priority": ubiquity.CompareChainKeyAlgoPriority,
+		"hash_ubiquity":     ubiquity.CompareChainHashUbiquity,
+		"key_algo_ubiquity": ubiquity.CompareChainKeyAlgoUbiquity,
+		"expiry_ubiquity":   ubiquity.CompareExpiryUbiquity,
+	}
+)
+
+// RegisterRanking makes a ranking function available to chain
+// policies under name, replacing any function registered before.
+func RegisterRanking(name string, f ubiquity.RankingFunc) {
+	rankingLock.Lock()
+	defer rankingLock.Unlock()
+	rankings[name] = f
+}
+
+// Rankings returns the names of the registered ranking functions,
+// sorted.
+func Rankings() []string {
+	rankingLock.RLock()
+	defer rankingLock.RUnlock()
+	var names []string
+	for name := range rankings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupRanking(name string) (ubiquity.RankingFunc, bool) {
+	rankingLock.RLock()
+	defer rankingLock.RUnlock()
+	f, ok := rankings[name]
+	return f, ok
+}
+
+// OptimalPolicy is the policy of the Optimal flavor: the shortest
+// chains, with the newest intermediates and the most advanced crypto
+// suite breaking ties.
+var OptimalPolicy = ChainPolicy{
+	Ranking: []string{"chain_length", "chain_expiry", "crypto_suite"},
+}
+
+// UbiquitousPolicy is the policy of the Ubiquitous flavor: the chains
+// trusted by the most platforms, with the Optimal policy breaking
+// ties.
+var UbiquitousPolicy = ChainPolicy{
+	Ranking: []string{
+		"platform_ubiquity",
+		// Prefer that all intermediates are SHA-2 certs if the
+		// leaf is a SHA-2 cert, in order to improve ubiquity.
+		"sha2_homogeneity",
+		"chain_length",
+		"hash_ubiquity",
+		"key_algo_ubiquity",
+		"expiry_ubiquity",
+		"chain_length", "chain_expiry", "crypto_suite",
+	},
+}
+
+// LoadChainPolicies reads a JSON file mapping flavor names to chain
+// policies.
+func LoadChainPolicies(path string) (map[BundleFlavor]ChainPolicy, error) {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(errors.PolicyError, errors.InvalidPolicy, err)
+	}
+
+	var policies map[BundleFlavor]ChainPolicy
+	if err = json.Unmarshal(in, &policies); err != nil {
+		return nil, errors.Wrap(errors.PolicyError, errors.InvalidPolicy, err)
+	}
+	for flavor, p := range policies {
+		if err = p.validate(flavor); err != nil {
+			return nil, err
+		}
+	}
+	return policies, nil
+}
+
+// validate checks that a policy may be used for flavor.
+func (p ChainPolicy) validate(flavor BundleFlavor) error {
+	if flavor == "" || flavor == Force {
+		return errors.Wrap(errors.PolicyError, errors.InvalidPolicy,
+			fmt.Errorf("chain policy cannot be set for flavor %q", flavor))
+	}
+	for _, name := range p.Ranking {
+		if _, ok := lookupRanking(name); !ok {
+			return errors.Wrap(errors.PolicyError, errors.InvalidPolicy,
+				fmt.Errorf("flavor %s: unknown ranking function %q", flavor, name))
+		}
+	}
+	for _, fp := range append(append([]string{}, p.Prefer...), p.Avoid...) {
+		if b, err := hex.DecodeString(normalizeFingerprint(fp)); err != nil || len(b) != sha256.Size {
+			return errors.Wrap(errors.PolicyError, errors.InvalidPolicy,
+				fmt.Errorf("flavor %s: %q is not a SHA-256 fingerprint", flavor, fp))
+		}
+	}
+	if p.MaxChainLength < 0 {
+		return errors.Wrap(errors.PolicyError, errors.InvalidPolicy,
+			fmt.Errorf("flavor %s: negative maximum chain length", flavor))
+	}
+	return nil
+}
+
+// normalizeFingerprint accepts fingerprints in either case, with or
+// without colons.
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.Replace(fp, ":", "", -1))
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// merge returns p with the bundler-wide settings of o added.
+func (p ChainPolicy) merge(o options) ChainPolicy {
+	p.Prefer = append(append([]string{}, p.Prefer...), o.prefer...)
+	p.Avoid = append(append([]string{}, p.Avoid...), o.avoid...)
+	if o.maxChainLength > 0 && (p.MaxChainLength == 0 || o.maxChainLength < p.MaxChainLength) {
+		p.MaxChainLength = o.maxChainLength
+	}
+	return p
+}
+
+// containsAny returns true if an intermediate of chain, that is a
+// certificate other than the leaf and the root, has one of the
+// fingerprints.
+func containsAny(chain []*x509.Certificate, fingerprints map[string]bool) bool {
+	for i := 1; i < len(chain)-1; i++ {
+		if fingerprints[fingerprint(chain[i])] {
+			return true
+		}
+	}
+	return false
+}
+
+func fingerprintSet(fps []string) map[string]bool {
+	set := make(map[string]bool, len(fps))
+	for _, fp := range fps {
+		set[normalizeFingerprint(fp)] = true
+	}
+	return set
+}
+
+// choose selects a chain from chains by the policy.
+func (p ChainPolicy) choose(chains [][]*x509.Certificate) ([]*x509.Certificate, error) {
+	if p.MaxChainLength > 0 {
+		var short [][]*x509.Certificate
+		for _, chain := range chains {
+			if len(chain) <= p.MaxChainLength {
+				short = append(short, chain)
+			}
+		}
+		if len(short) == 0 {
+			return nil, errors.Wrap(errors.CertificateError, errors.VerifyFailed,
+				fmt.Errorf("no chain of at most %d certificates", p.MaxChainLength))
+		}
+		chains = short
+	}
+
+	if len(p.Prefer) > 0 {
+		prefer := fingerprintSet(p.Prefer)
+		chains = ubiquity.Filter(chains, func(chain1, chain2 []*x509.Certificate) int {
+			return boolRank(containsAny(chain1, prefer)) - boolRank(containsAny(chain2, prefer))
+		})
+	}
+	if len(p.Avoid) > 0 {
+		avoid := fingerprintSet(p.Avoid)
+		chains = ubiquity.Filter(chains, func(chain1, chain2 []*x509.Certificate) int {
+			return boolRank(containsAny(chain2, avoid)) - boolRank(containsAny(chain1, avoid))
+		})
+	}
+
+	for _, name := range p.Ranking {
+		f, ok := lookupRanking(name)
+		if !ok {
+			return nil, errors.Wrap(errors.PolicyError, errors.InvalidPolicy,
+				fmt.Errorf("unknown ranking function %q", name))
+		}
+		chains = ubiquity.Filter(chains, f)
+	}
+
+	// Break ties by fingerprint: x509.Verify does not return the
+	// chains in a stable order.
+	sort.SliceStable(chains, func(i, j int) bool {
+		return compareChains(chains[i], chains[j]) < 0
+	})
+	return chains[0], nil
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// compareChains orders chains by length, then by the fingerprints of
+// their certificates.
+func compareChains(chain1, chain2 []*x509.Certificate) int {
+	if len(chain1) != len(chain2) {
+		return len(chain1) - len(chain2)
+	}
+	for i := range chain1 {
+		if c := bytes.Compare([]byte(fingerprint(chain1[i])), []byte(fingerprint(chain2[i]))); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
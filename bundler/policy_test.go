@@ -0,0 +1,217 @@
+package bundler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type crossSigned struct {
+	roots, intermediates []*x509.Certificate
+	// direct is issued by the first root, cross by the second; both
+	// certify the same intermediate name and key.
+	direct, cross *x509.Certificate
+	leaf          *x509.Certificate
+}
+
+func issue(t *testing.T, serial int64, tmpl *x509.Certificate, parent *x509.Certificate, pub interface{}, priv *ecdsa.PrivateKey) *x509.Certificate {
+	tmpl.SerialNumber = big.NewInt(serial)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	if tmpl.NotAfter.IsZero() {
+		tmpl.NotAfter = time.Now().Add(365 * 24 * time.Hour)
+	}
+	if parent == nil {
+		parent = tmpl
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func caTemplate(cn string) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:               pkix.Name{CommonName: cn},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func caTemplateExpiring(cn string, notAfter time.Time) *x509.Certificate {
+	tmpl := caTemplate(cn)
+	tmpl.NotAfter = notAfter
+	return tmpl
+}
+
+func newCrossSigned(t *testing.T) *crossSigned {
+	now := time.Now().Truncate(time.Second)
+	root1Key, root2Key, intKey, leafKey := newKey(t), newKey(t), newKey(t), newKey(t)
+	root1 := issue(t, 1, caTemplateExpiring("Policy Root 1", now.AddDate(10, 0, 0)), nil, &root1Key.PublicKey, root1Key)
+	root2 := issue(t, 2, caTemplateExpiring("Policy Root 2", now.AddDate(10, 0, 0)), nil, &root2Key.PublicKey, root2Key)
+	// The cross-signed intermediate expires later, so that the
+	// ubiquitous flavor prefers it.
+	direct := issue(t, 3, caTemplateExpiring("Policy Intermediate", now.AddDate(1, 0, 0)), root1, &intKey.PublicKey, root1Key)
+	cross := issue(t, 4, caTemplateExpiring("Policy Intermediate", now.AddDate(2, 0, 0)), root2, &intKey.PublicKey, root2Key)
+	leaf := issue(t, 5, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "policy.example.com"},
+		DNSNames:    []string{"policy.example.com"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		NotAfter:    now.AddDate(0, 0, 90),
+	}, direct, &leafKey.PublicKey, intKey)
+
+	return &crossSigned{
+		roots:         []*x509.Certificate{root1, root2},
+		intermediates: []*x509.Certificate{direct, cross},
+		direct:        direct,
+		cross:         cross,
+		leaf:          leaf,
+	}
+}
+
+func encodePEM(certs []*x509.Certificate) []byte {
+	var out []byte
+	for _, c := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return out
+}
+
+func (cs *crossSigned) bundler(t *testing.T, opts ...Option) *Bundler {
+	b, err := NewBundlerFromPEM(encodePEM(cs.roots), encodePEM(cs.intermediates), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func (cs *crossSigned) bundle(t *testing.T, b *Bundler, flavor BundleFlavor) *x509.Certificate {
+	bundle, err := b.Bundle([]*x509.Certificate{cs.leaf}, nil, flavor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle.Chain) != 2 {
+		t.Fatalf("expected a chain of the leaf and an intermediate, got %d certificates", len(bundle.Chain))
+	}
+	return bundle.Chain[1]
+}
+
+func TestChainPolicyCrossSigned(t *testing.T) {
+	cs := newCrossSigned(t)
+
+	if !cs.bundle(t, cs.bundler(t), Ubiquitous).Equal(cs.cross) {
+		t.Fatal("expected the ubiquitous flavor to choose the intermediate expiring last")
+	}
+
+	b := cs.bundler(t, WithPreferredIntermediates(fingerprint(cs.direct)))
+	if !cs.bundle(t, b, Ubiquitous).Equal(cs.direct) {
+		t.Fatal("expected the preferred intermediate")
+	}
+
+	b = cs.bundler(t, WithAvoidedIntermediates(fingerprint(cs.cross)))
+	if !cs.bundle(t, b, Ubiquitous).Equal(cs.direct) {
+		t.Fatal("expected the avoided intermediate to be passed over")
+	}
+
+	// Avoiding every intermediate still yields a bundle.
+	b = cs.bundler(t, WithAvoidedIntermediates(fingerprint(cs.cross), fingerprint(cs.direct)))
+	cs.bundle(t, b, Optimal)
+
+	b = cs.bundler(t, WithMaxChainLength(2))
+	if _, err := b.Bundle([]*x509.Certificate{cs.leaf}, nil, Optimal); err == nil {
+		t.Fatal("expected no chain within the maximum length")
+	}
+}
+
+func TestChainPolicyCustomFlavor(t *testing.T) {
+	cs := newCrossSigned(t)
+
+	// Rank chains through the first root highest.
+	RegisterRanking("test_first_root", func(chain1, chain2 []*x509.Certificate) int {
+		return boolRank(chain1[len(chain1)-1].Equal(cs.roots[0])) - boolRank(chain2[len(chain2)-1].Equal(cs.roots[0]))
+	})
+
+	b := cs.bundler(t, WithChainPolicy("first-root", ChainPolicy{Ranking: []string{"test_first_root"}}))
+	if !b.HasFlavor("first-root") || b.HasFlavor("last-root") {
+		t.Fatalf("unexpected flavors %v", b.Flavors())
+	}
+	if !cs.bundle(t, b, "first-root").Equal(cs.direct) {
+		t.Fatal("expected the custom ranking to choose the intermediate of the first root")
+	}
+
+	// Without a ranking, the tie is broken the same way every time.
+	b = cs.bundler(t, WithChainPolicy("any", ChainPolicy{}))
+	first := cs.bundle(t, b, "any")
+	for i := 0; i < 10; i++ {
+		if !cs.bundle(t, b, "any").Equal(first) {
+			t.Fatal("expected ties to be broken deterministically")
+		}
+	}
+
+	if _, err := NewBundlerFromPEM(encodePEM(cs.roots), nil, WithChainPolicy("bad", ChainPolicy{Ranking: []string{"no_such_ranking"}})); err == nil {
+		t.Fatal("expected an unknown ranking function to be rejected")
+	}
+	if _, err := NewBundlerFromPEM(encodePEM(cs.roots), nil, WithChainPolicy(Force, ChainPolicy{})); err == nil {
+		t.Fatal("expected a policy for the force flavor to be rejected")
+	}
+	if _, err := NewBundlerFromPEM(encodePEM(cs.roots), nil, WithPreferredIntermediates("abcd")); err == nil {
+		t.Fatal("expected a malformed fingerprint to be rejected")
+	}
+}
+
+func TestLoadChainPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "policies.json")
+	err = ioutil.WriteFile(path, []byte(`{"short": {"ranking": ["chain_length", "crypto_suite"], "max_chain_length": 3}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := LoadChainPolicies(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := policies["short"]; len(p.Ranking) != 2 || p.MaxChainLength != 3 {
+		t.Fatalf("unexpected policies %+v", policies)
+	}
+
+	if err = ioutil.WriteFile(path, []byte(`{"short": {"ranking": ["fastest"]}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadChainPolicies(path); err == nil {
+		t.Fatal("expected an unknown ranking function to be rejected")
+	}
+
+	for _, name := range UbiquitousPolicy.Ranking {
+		if _, ok := lookupRanking(name); !ok {
+			t.Fatalf("built-in ranking %s is not registered", name)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+// Package ceremony implements signing ceremonies for an offline CA.
+//
+// A ceremony has three steps. Online, Export collects pending sign
+// requests and the revoked certificates in the certificate database
+// into a request file signed by an operator key. The file is carried
+// to the offline CA, where Offline.Sign checks it against the
+// operator's key, issues the certificates, and produces OCSP responses
+// and a CRL, all in a result file signed by the CA key. Back online,
+// Import checks the result file against the CA certificate and records
+// the certificates and OCSP responses in the certificate database.
+//
+// Each step writes what it checked and produced to a Transcript, to be
+// printed and kept as the record of the ceremony.
+package ceremony
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/crl"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/ocsp"
+	"github.com/cloudflare/cfssl/signer"
+
+	stdocsp "golang.org/x/crypto/ocsp"
+)
+
+// A Transcript records the steps of a ceremony, one timestamped line
+// per event. A nil Transcript records nothing.
+type Transcript struct {
+	w io.Writer
+}
+
+// NewTranscript returns a Transcript writing to w.
+func NewTranscript(w io.Writer) *Transcript {
+	return &Transcript{w: w}
+}
+
+// Printf adds a line to the transcript.
+func (t *Transcript) Printf(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	fmt.Fprintf(t.w, "%s  %s\n", time.Now().UTC().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
+
+// A Revocation is a revoked certificate that the offline CA lists in
+// its CRL and gives a revoked OCSP response.
+type Revocation struct {
+	Serial    string    `json:"serial_number"`
+	AKI       string    `json:"authority_key_identifier"`
+	Reason    int       `json:"reason"`
+	RevokedAt time.Time `json:"revoked_at"`
+	PEM       string    `json:"pem"`
+}
+
+// A RequestBatch is the payload of a request file.
+type RequestBatch struct {
+	ID        string               `json:"id"`
+	CreatedAt time.Time            `json:"created_at"`
+	Requests  []signer.SignRequest `json:"requests"`
+	Revoked   []Revocation         `json:"revoked"`
+}
+
+// An Issued is the outcome of one request in a batch: a certificate,
+// or the reason the CA refused the request.
+type Issued struct {
+	Request     int    `json:"request"`
+	Serial      string `json:"serial_number,omitempty"`
+	AKI         string `json:"authority_key_identifier,omitempty"`
+	Label       string `json:"label,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// An OCSPUpdate is a DER-encoded OCSP response for a certificate.
+type OCSPUpdate struct {
+	Serial   string `json:"serial_number"`
+	AKI      string `json:"authority_key_identifier"`
+	Response []byte `json:"response"`
+}
+
+// A ResultBatch is the payload of a result file. It names the request
+// file it answers by its ID and payload digest.
+type ResultBatch struct {
+	RequestID     string       `json:"request_id"`
+	RequestDigest string       `json:"request_digest"`
+	SignedAt      time.Time    `json:"signed_at"`
+	Issued        []Issued     `json:"issued"`
+	OCSP          []OCSPUpdate `json:"ocsp"`
+	CRL           []byte       `json:"crl"`
+}
+
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// attributeTypeNames gives the RFC 4514 short names of the attribute
+// types in pkix.Name.
+var attributeTypeNames = map[string]string{
+	"2.5.4.3":  "CN",
+	"2.5.4.5":  "SERIALNUMBER",
+	"2.5.4.6":  "C",
+	"2.5.4.7":  "L",
+	"2.5.4.8":  "ST",
+	"2.5.4.9":  "STREET",
+	"2.5.4.10": "O",
+	"2.5.4.11": "OU",
+	"2.5.4.17": "POSTALCODE",
+}
+
+// nameString formats name for the transcript as an RFC 4514
+// distinguished name, as the lint package does for its reports.
+func nameString(name pkix.Name) string {
+	var rdns pkix.RDNSequence
+	if len(name.Names) > 0 {
+		for _, atv := range name.Names {
+			rdns = append(rdns, pkix.RelativeDistinguishedNameSET{atv})
+		}
+	} else {
+		rdns = name.ToRDNSequence()
+	}
+
+	parts := make([]string, 0, len(rdns))
+	for i := len(rdns) - 1; i >= 0; i-- {
+		atvs := make([]string, len(rdns[i]))
+		for j, atv := range rdns[i] {
+			typ, ok := attributeTypeNames[atv.Type.String()]
+			if !ok {
+				typ = atv.Type.String()
+			}
+			atvs[j] = fmt.Sprintf("%s=%v", typ, atv.Value)
+		}
+		parts = append(parts, strings.Join(atvs, "+"))
+	}
+	return strings.Join(parts, ",")
+}
+
+// NewRequestBatch returns a batch, with a new random ID, of the sign
+// requests and revoked certificate records.
+func NewRequestBatch(reqs []signer.SignRequest, revoked []certdb.CertificateRecord) (*RequestBatch, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	batch := &RequestBatch{
+		ID:        hex.EncodeToString(id),
+		CreatedAt: time.Now().UTC(),
+		Requests:  reqs,
+	}
+	for _, r := range revoked {
+		// The serial is taken from the certificate itself: some
+		// databases store serial numbers in numeric columns that
+		// cannot hold them exactly.
+		cert, err := helpers.ParseCertificatePEM([]byte(r.PEM))
+		if err != nil {
+			return nil, fmt.Errorf("revoked serial %s: %v", r.Serial, err)
+		}
+		batch.Revoked = append(batch.Revoked, Revocation{
+			Serial:    cert.SerialNumber.String(),
+			AKI:       r.AKI,
+			Reason:    r.Reason,
+			RevokedAt: r.RevokedAt,
+			PEM:       r.PEM,
+		})
+	}
+	return batch, nil
+}
+
+// Export checks the CSRs in batch and signs it with the operator's key
+// as a request file.
+func Export(batch *RequestBatch, key crypto.Signer, t *Transcript) (*Envelope, error) {
+	t.Printf("export: batch %s created %s with %d requests and %d revoked certificates",
+		batch.ID, batch.CreatedAt.Format(time.RFC3339), len(batch.Requests), len(batch.Revoked))
+
+	for i, req := range batch.Requests {
+		csr, err := helpers.ParseCSRPEM([]byte(req.Request))
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %v", i, err)
+		}
+		t.Printf("export: request %d: subject %q, hosts %v, profile %q, CSR sha256 %s",
+			i, nameString(csr.Subject), req.Hosts, req.Profile, fingerprint(csr.Raw))
+	}
+	for _, r := range batch.Revoked {
+		t.Printf("export: revoked serial %s, authority key %s, reason %d, at %s",
+			r.Serial, r.AKI, r.Reason, r.RevokedAt.Format(time.RFC3339))
+	}
+
+	env, err := Seal(KindRequests, batch, key)
+	if err != nil {
+		return nil, err
+	}
+	t.Printf("export: request file signed by operator key %s, payload sha256 %s", env.KeyID, env.Digest())
+	return env, nil
+}
+
+// Offline is an offline CA that answers request files.
+type Offline struct {
+	// Signer issues the certificates.
+	Signer signer.Signer
+	// OCSPSigner signs OCSP responses for the issued and revoked
+	// certificates.
+	OCSPSigner ocsp.Signer
+	// CA is the CA certificate.
+	CA *x509.Certificate
+	// Key is the CA key. It signs the CRL and the result file.
+	Key crypto.Signer
+	// CRLExpiry is how long the CRL is valid.
+	CRLExpiry time.Duration
+}
+
+// Sign checks that a request file was signed by the operator's key and
+// answers it with a result file. A request the signer refuses is
+// recorded in the results; any other failure stops the ceremony.
+func (o *Offline) Sign(env *Envelope, operator crypto.PublicKey, t *Transcript) (*Envelope, error) {
+	var batch RequestBatch
+	if err := env.Open(KindRequests, operator, &batch); err != nil {
+		return nil, err
+	}
+	t.Printf("sign: request file for batch %s verified against operator key %s, payload sha256 %s",
+		batch.ID, env.KeyID, env.Digest())
+
+	results := &ResultBatch{
+		RequestID:     batch.ID,
+		RequestDigest: env.Digest(),
+		SignedAt:      time.Now().UTC(),
+	}
+
+	for i, req := range batch.Requests {
+		certPEM, err := o.Signer.Sign(req)
+		if err != nil {
+			t.Printf("sign: request %d refused: %v", i, err)
+			results.Issued = append(results.Issued, Issued{Request: i, Error: err.Error()})
+			continue
+		}
+
+		cert, err := helpers.ParseCertificatePEM(certPEM)
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %v", i, err)
+		}
+		if err = cert.CheckSignatureFrom(o.CA); err != nil {
+			return nil, fmt.Errorf("request %d: certificate is not signed by the CA: %v", i, err)
+		}
+		issued := Issued{
+			Request:     i,
+			Serial:      cert.SerialNumber.String(),
+			AKI:         hex.EncodeToString(cert.AuthorityKeyId),
+			Label:       req.Label,
+			Certificate: string(certPEM),
+		}
+		results.Issued = append(results.Issued, issued)
+		t.Printf("sign: request %d issued serial %s, subject %q, not after %s, sha256 %s",
+			i, issued.Serial, nameString(cert.Subject), cert.NotAfter.Format(time.RFC3339), fingerprint(cert.Raw))
+
+		resp, err := o.OCSPSigner.Sign(ocsp.SignRequest{Certificate: cert, Status: "good"})
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %v", i, err)
+		}
+		results.OCSP = append(results.OCSP, OCSPUpdate{Serial: issued.Serial, AKI: issued.AKI, Response: resp})
+	}
+
+	var revoked []certdb.CertificateRecord
+	for _, r := range batch.Revoked {
+		cert, err := helpers.ParseCertificatePEM([]byte(r.PEM))
+		if err != nil {
+			return nil, fmt.Errorf("revoked serial %s: %v", r.Serial, err)
+		}
+		if cert.SerialNumber.String() != r.Serial {
+			return nil, fmt.Errorf("revoked serial %s: certificate has serial %s", r.Serial, cert.SerialNumber)
+		}
+
+		resp, err := o.OCSPSigner.Sign(ocsp.SignRequest{
+			Certificate: cert,
+			Status:      "revoked",
+			Reason:      r.Reason,
+			RevokedAt:   r.RevokedAt,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("revoked serial %s: %v", r.Serial, err)
+		}
+		results.OCSP = append(results.OCSP, OCSPUpdate{Serial: r.Serial, AKI: r.AKI, Response: resp})
+		revoked = append(revoked, certdb.CertificateRecord{Serial: r.Serial, RevokedAt: r.RevokedAt})
+	}
+	t.Printf("sign: %d OCSP responses signed", len(results.OCSP))
+
+	crlDER, err := crl.NewCRLFromDB(revoked, o.CA, o.Key, o.CRLExpiry)
+	if err != nil {
+		return nil, err
+	}
+	results.CRL = crlDER
+	t.Printf("sign: CRL with %d entries, valid for %v, sha256 %s", len(revoked), o.CRLExpiry, fingerprint(crlDER))
+
+	out, err := Seal(KindResults, results, o.Key)
+	if err != nil {
+		return nil, err
+	}
+	t.Printf("sign: result file signed by CA key %s, payload sha256 %s", out.KeyID, out.Digest())
+	return out, nil
+}
+
+// Import checks that a result file was signed by the CA, and that the
+// certificates, OCSP responses and CRL in it are, before recording the
+// certificates and OCSP responses in db. If requests is not nil, the
+// result file must answer it. The results are returned so that the
+// caller can publish the CRL.
+func Import(env *Envelope, ca *x509.Certificate, requests *Envelope, db certdb.Accessor, t *Transcript) (*ResultBatch, error) {
+	var results ResultBatch
+	if err := env.Open(KindResults, ca.PublicKey, &results); err != nil {
+		return nil, err
+	}
+	t.Printf("import: result file for batch %s verified against CA key %s, payload sha256 %s",
+		results.RequestID, env.KeyID, env.Digest())
+
+	if requests != nil {
+		if requests.Digest() != results.RequestDigest {
+			return nil, fmt.Errorf("result file answers request payload %s, not %s", results.RequestDigest, requests.Digest())
+		}
+		t.Printf("import: result file answers request file with payload sha256 %s", results.RequestDigest)
+	}
+
+	// Check everything before anything is recorded.
+	var records []certdb.CertificateRecord
+	for _, issued := range results.Issued {
+		if issued.Error != "" {
+			t.Printf("import: request %d was refused: %s", issued.Request, issued.Error)
+			continue
+		}
+		cert, err := helpers.ParseCertificatePEM([]byte(issued.Certificate))
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %v", issued.Request, err)
+		}
+		if err = cert.CheckSignatureFrom(ca); err != nil {
+			return nil, fmt.Errorf("request %d: certificate is not signed by the CA: %v", issued.Request, err)
+		}
+		if cert.SerialNumber.String() != issued.Serial {
+			return nil, fmt.Errorf("request %d: certificate has serial %s, not %s", issued.Request, cert.SerialNumber, issued.Serial)
+		}
+		records = append(records, certdb.CertificateRecord{
+			Serial:  issued.Serial,
+			AKI:     issued.AKI,
+			CALabel: issued.Label,
+			Status:  "good",
+			Expiry:  cert.NotAfter,
+			PEM:     issued.Certificate,
+		})
+	}
+
+	expiries := make([]time.Time, len(results.OCSP))
+	for i, u := range results.OCSP {
+		resp, err := stdocsp.ParseResponse(u.Response, ca)
+		if err != nil {
+			return nil, fmt.Errorf("OCSP response for serial %s: %v", u.Serial, err)
+		}
+		if resp.SerialNumber.String() != u.Serial {
+			return nil, fmt.Errorf("OCSP response for serial %s is for serial %s", u.Serial, resp.SerialNumber)
+		}
+		expiries[i] = resp.NextUpdate
+	}
+
+	crlList, err := x509.ParseCRL(results.CRL)
+	if err != nil {
+		return nil, fmt.Errorf("CRL: %v", err)
+	}
+	if err = ca.CheckCRLSignature(crlList); err != nil {
+		return nil, fmt.Errorf("CRL is not signed by the CA: %v", err)
+	}
+
+	for _, record := range records {
+		if err = db.InsertCertificate(record); err != nil {
+			return nil, err
+		}
+		t.Printf("import: recorded certificate serial %s", record.Serial)
+	}
+	for i, u := range results.OCSP {
+		if err = db.UpsertOCSP(u.Serial, u.AKI, string(u.Response), expiries[i]); err != nil {
+			return nil, err
+		}
+	}
+	t.Printf("import: recorded %d OCSP responses", len(results.OCSP))
+	t.Printf("import: CRL with %d entries, next update %s, sha256 %s",
+		len(crlList.TBSCertList.RevokedCertificates), crlList.TBSCertList.NextUpdate.Format(time.RFC3339), fingerprint(results.CRL))
+
+	return &results, nil
+}
@@ -0,0 +1,241 @@
+package ceremony
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/certdb/sql"
+	"github.com/cloudflare/cfssl/certdb/testdb"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/ocsp"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+
+	"golang.org/x/crypto/ed25519"
+	stdocsp "golang.org/x/crypto/ocsp"
+)
+
+const (
+	sqliteDBFile = "../certdb/testdb/certstore_development.db"
+	caFile       = "../signer/local/testdata/ca.pem"
+	caKeyFile    = "../signer/local/testdata/ca_key.pem"
+	csrFile      = "../signer/local/testdata/ecdsa256.csr"
+)
+
+type fixture struct {
+	ca       *x509.Certificate
+	offline  *Offline
+	operator ed25519.PrivateKey
+	request  signer.SignRequest
+}
+
+func newFixture(t *testing.T) *fixture {
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := helpers.ParseCertificatePEM(caPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := ioutil.ReadFile(caKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := local.NewSignerFromFile(caFile, caKeyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ocspSigner, err := ocsp.NewSigner(ca, ca, key, 96*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, operator, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrPEM, err := ioutil.ReadFile(csrFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fixture{
+		ca: ca,
+		offline: &Offline{
+			Signer:     s,
+			OCSPSigner: ocspSigner,
+			CA:         ca,
+			Key:        key,
+			CRLExpiry:  24 * time.Hour,
+		},
+		operator: operator,
+		request:  signer.SignRequest{Hosts: []string{"ceremony.example.com"}, Request: string(csrPEM)},
+	}
+}
+
+func TestCeremony(t *testing.T) {
+	f := newFixture(t)
+	db := sql.NewAccessor(testdb.SQLiteDB(sqliteDBFile))
+
+	// A certificate revoked online is carried to the offline CA.
+	revokedPEM, err := f.offline.Signer.Sign(f.request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedCert, err := helpers.ParseCertificatePEM(revokedPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertCertificate(certdb.CertificateRecord{
+		Serial:    revokedCert.SerialNumber.String(),
+		AKI:       hex.EncodeToString(revokedCert.AuthorityKeyId),
+		Status:    "revoked",
+		Reason:    1,
+		Expiry:    revokedCert.NotAfter,
+		RevokedAt: time.Now().UTC().Truncate(time.Second),
+		PEM:       string(revokedPEM),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := db.GetRevokedAndUnexpiredCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch, err := NewRequestBatch([]signer.SignRequest{f.request, {Request: "not a CSR"}}, revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transcript bytes.Buffer
+	tr := NewTranscript(&transcript)
+	if _, err = Export(batch, f.operator, tr); err == nil {
+		t.Fatal("expected export to reject a malformed CSR")
+	}
+
+	batch.Requests = batch.Requests[:1]
+	requests, err := Export(batch, f.operator, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := f.offline.Sign(requests, f.operator.Public(), tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := Import(results, f.ca, requests, db, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Issued) != 1 || imported.Issued[0].Error != "" {
+		t.Fatalf("unexpected issued certificates: %+v", imported.Issued)
+	}
+	serial := imported.Issued[0].Serial
+
+	record, err := db.GetCertificate(serial, imported.Issued[0].AKI)
+	if err != nil || len(record) != 1 || record[0].Status != "good" {
+		t.Fatalf("issued certificate not recorded: %v %+v", err, record)
+	}
+
+	ocspRecords, err := db.GetOCSP(revokedCert.SerialNumber.String(), hex.EncodeToString(revokedCert.AuthorityKeyId))
+	if err != nil || len(ocspRecords) != 1 {
+		t.Fatalf("revoked OCSP response not recorded: %v %+v", err, ocspRecords)
+	}
+	resp, err := stdocsp.ParseResponse([]byte(ocspRecords[0].Body), f.ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != stdocsp.Revoked {
+		t.Fatalf("expected revoked OCSP response, got status %d", resp.Status)
+	}
+
+	crl, err := x509.ParseCRL(imported.CRL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedList := crl.TBSCertList.RevokedCertificates
+	if len(revokedList) != 1 || revokedList[0].SerialNumber.Cmp(revokedCert.SerialNumber) != 0 {
+		t.Fatalf("CRL does not list the revoked certificate: %+v", revokedList)
+	}
+
+	for _, want := range []string{
+		`export: request 0: subject "CN=cloudflare.com,ST=California,L=San Francisco,OU=Systems Engineering,O=CloudFlare,C=US"`,
+		"sign: request 0 issued serial " + serial,
+		"import: recorded certificate serial " + serial,
+	} {
+		if !strings.Contains(transcript.String(), want) {
+			t.Errorf("transcript is missing %q:\n%s", want, transcript.String())
+		}
+	}
+
+	// The result file must answer the request file given.
+	other, err := Export(batch, f.operator, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Import(results, f.ca, other, db, nil); err == nil {
+		t.Fatal("expected import to reject results for another request file")
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	f := newFixture(t)
+
+	batch, err := NewRequestBatch([]signer.SignRequest{f.request}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, err := Export(batch, f.operator, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, impostor, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.offline.Sign(requests, impostor.Public(), nil); err == nil {
+		t.Fatal("expected sign to reject a request file from another operator")
+	}
+
+	tampered := *requests
+	tampered.Payload = bytes.Replace(requests.Payload, []byte("ceremony.example.com"), []byte("attacker.example.com"), 1)
+	if _, err = f.offline.Sign(&tampered, f.operator.Public(), nil); err == nil {
+		t.Fatal("expected sign to reject a tampered request file")
+	}
+
+	// Results sealed by a key other than the CA's are rejected.
+	rogue, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := Seal(KindResults, &ResultBatch{RequestID: batch.ID}, rogue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Import(forged, f.ca, nil, nil, nil); err == nil {
+		t.Fatal("expected import to reject results not signed by the CA")
+	}
+
+	// A request file is not a result file, even with the right key.
+	if _, err = Import(requests, f.ca, nil, nil, nil); err == nil {
+		t.Fatal("expected import to reject a request file")
+	}
+}
@@ -0,0 +1,151 @@
+package ceremony
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Kinds of envelope.
+const (
+	KindRequests = "requests"
+	KindResults  = "results"
+)
+
+// An Envelope is a signed ceremony file. Its payload is a JSON
+// RequestBatch or ResultBatch, signed together with its kind so that
+// one kind of file cannot be passed off as the other.
+type Envelope struct {
+	Kind      string `json:"kind"`
+	Payload   []byte `json:"payload"`
+	KeyID     string `json:"key_id"`
+	Signature []byte `json:"signature"`
+}
+
+// KeyID identifies a public key by the SHA-256 digest of its
+// SubjectPublicKeyInfo.
+func KeyID(pub crypto.PublicKey) (string, error) {
+	der, err := marshalPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// oidEd25519 identifies Ed25519 keys, as in RFC 8410.
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// marshalPublicKey encodes pub as a SubjectPublicKeyInfo. crypto/x509
+// doesn't know about Ed25519 keys, so they are encoded here.
+func marshalPublicKey(pub crypto.PublicKey) ([]byte, error) {
+	edPub, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return x509.MarshalPKIXPublicKey(pub)
+	}
+	return asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+		PublicKey: asn1.BitString{Bytes: edPub, BitLength: 8 * len(edPub)},
+	})
+}
+
+// signedMessage is what an envelope's signature covers.
+func signedMessage(kind string, payload []byte) []byte {
+	msg := []byte("cfssl ceremony\x00" + kind + "\x00")
+	return append(msg, payload...)
+}
+
+// Seal encodes v as JSON and signs it with key as an envelope of the
+// given kind.
+func Seal(kind string, v interface{}, key crypto.Signer) (*Envelope, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := KeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	msg := signedMessage(kind, payload)
+	var sig []byte
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		sig, err = key.Sign(rand.Reader, msg, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(msg)
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{Kind: kind, Payload: payload, KeyID: keyID, Signature: sig}, nil
+}
+
+// Open checks that the envelope is of the given kind and was signed by
+// pub, and decodes its payload into v.
+func (e *Envelope) Open(kind string, pub crypto.PublicKey, v interface{}) error {
+	if e.Kind != kind {
+		return fmt.Errorf("expected a %s file, got %q", kind, e.Kind)
+	}
+	keyID, err := KeyID(pub)
+	if err != nil {
+		return err
+	}
+	if e.KeyID != keyID {
+		return fmt.Errorf("file was signed by key %s, not the trusted key %s", e.KeyID, keyID)
+	}
+
+	msg := signedMessage(e.Kind, e.Payload)
+	digest := sha256.Sum256(msg)
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], e.Signature)
+	case *ecdsa.PublicKey:
+		if !verifyECDSA(pub, digest[:], e.Signature) {
+			err = errors.New("ECDSA verification failure")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, msg, e.Signature) {
+			err = errors.New("Ed25519 verification failure")
+		}
+	default:
+		err = errors.New("unsupported public key type")
+	}
+	if err != nil {
+		return fmt.Errorf("bad %s file signature: %v", kind, err)
+	}
+
+	return json.Unmarshal(e.Payload, v)
+}
+
+// verifyECDSA checks an ASN.1 encoded ECDSA signature.
+func verifyECDSA(pub *ecdsa.PublicKey, digest, sig []byte) bool {
+	var rs struct{ R, S *big.Int }
+	rest, err := asn1.Unmarshal(sig, &rs)
+	if err != nil || len(rest) != 0 || rs.R.Sign() <= 0 || rs.S.Sign() <= 0 {
+		return false
+	}
+	return ecdsa.Verify(pub, digest, rs.R, rs.S)
+}
+
+// Digest returns the hex SHA-256 digest of the envelope's payload.
+func (e *Envelope) Digest() string {
+	sum := sha256.Sum256(e.Payload)
+	return hex.EncodeToString(sum[:])
+}
@@ -26,6 +26,42 @@ type OCSPRecord struct {
 	Expiry time.Time `db:"expiry"`
 }
 
+// ExpiryNotificationRecord records that an expiry notification for a
+// certificate crossing a threshold was sent along a route, so that it
+// is only sent once.
+type ExpiryNotificationRecord struct {
+	Serial     string    `db:"serial_number"`
+	AKI        string    `db:"authority_key_identifier"`
+	Threshold  int64     `db:"threshold"`
+	Route      string    `db:"route"`
+	NotifiedAt time.Time `db:"notified_at"`
+}
+
+// ScanRecord encodes the results of one scan of a host, as JSON, and
+// when the scan was run.
+type ScanRecord struct {
+	Host      string    `db:"host"`
+	ScannedAt time.Time `db:"scanned_at"`
+	Results   string    `db:"results"`
+}
+
+// WhitelistRecord encodes an entry, such as a network, of a named
+// whitelist.
+type WhitelistRecord struct {
+	Name  string `db:"name"`
+	Entry string `db:"entry"`
+}
+
+// WhitelistChangeRecord records who added an entry to, or removed one
+// from, a named whitelist and when, for its audit trail.
+type WhitelistChangeRecord struct {
+	Name      string    `db:"name"`
+	Action    string    `db:"action"`
+	Entry     string    `db:"entry"`
+	Actor     string    `db:"actor"`
+	ChangedAt time.Time `db:"changed_at"`
+}
+
 // Accessor abstracts the CRUD of certdb objects from a DB.
 type Accessor interface {
 	InsertCertificate(cr CertificateRecord) error
@@ -40,3 +76,27 @@ type Accessor interface {
 	UpdateOCSP(serial, aki, body string, expiry time.Time) error
 	UpsertOCSP(serial, aki, body string, expiry time.Time) error
 }
+
+// ExpiryNotificationAccessor is implemented by an Accessor that also
+// records the expiry notifications sent for certificates.
+type ExpiryNotificationAccessor interface {
+	InsertExpiryNotification(er ExpiryNotificationRecord) error
+	GetExpiryNotifications(serial, aki string) ([]ExpiryNotificationRecord, error)
+}
+
+// ScanAccessor is implemented by an Accessor that also records the
+// results of scans.
+type ScanAccessor interface {
+	InsertScanResult(sr ScanRecord) error
+	GetScanResults(host string) ([]ScanRecord, error)
+}
+
+// WhitelistAccessor is implemented by an Accessor that also keeps
+// administered whitelists and the changes made to them.
+type WhitelistAccessor interface {
+	InsertWhitelistEntry(wr WhitelistRecord) error
+	DeleteWhitelistEntry(wr WhitelistRecord) error
+	GetWhitelistEntries(name string) ([]WhitelistRecord, error)
+	InsertWhitelistChange(cr WhitelistChangeRecord) error
+	GetWhitelistChanges(name string) ([]WhitelistChangeRecord, error)
+}
@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE expiry_notifications (
+  serial_number            varbinary(128) NOT NULL,
+  authority_key_identifier varbinary(128) NOT NULL,
+  threshold                bigint NOT NULL,
+  route                    varbinary(128) NOT NULL,
+  notified_at              timestamp DEFAULT '0000-00-00 00:00:00',
+  PRIMARY KEY(serial_number, authority_key_identifier, threshold, route)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE expiry_notifications;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE scan_results (
+  host                     varbinary(255) NOT NULL,
+  scanned_at               timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  results                  mediumblob NOT NULL,
+  PRIMARY KEY(host, scanned_at)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE scan_results;
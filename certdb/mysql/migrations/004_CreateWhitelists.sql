@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE whitelist_entries (
+  name                     varbinary(255) NOT NULL,
+  entry                    varbinary(255) NOT NULL,
+  PRIMARY KEY(name, entry)
+);
+
+CREATE TABLE whitelist_changes (
+  name                     varbinary(255) NOT NULL,
+  action                   varbinary(255) NOT NULL,
+  entry                    varbinary(255) NOT NULL,
+  actor                    varbinary(255) NOT NULL,
+  changed_at               timestamp(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE whitelist_changes;
+DROP TABLE whitelist_entries;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE expiry_notifications (
+  serial_number            bytea NOT NULL,
+  authority_key_identifier bytea NOT NULL,
+  threshold                bigint NOT NULL,
+  route                    bytea NOT NULL,
+  notified_at              timestamptz,
+  PRIMARY KEY(serial_number, authority_key_identifier, threshold, route)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE expiry_notifications;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE scan_results (
+  host                     bytea NOT NULL,
+  scanned_at               timestamptz NOT NULL,
+  results                  bytea NOT NULL,
+  PRIMARY KEY(host, scanned_at)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE scan_results;
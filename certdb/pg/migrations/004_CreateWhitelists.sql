@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE whitelist_entries (
+  name                     bytea NOT NULL,
+  entry                    bytea NOT NULL,
+  PRIMARY KEY(name, entry)
+);
+
+CREATE TABLE whitelist_changes (
+  name                     bytea NOT NULL,
+  action                   bytea NOT NULL,
+  entry                    bytea NOT NULL,
+  actor                    bytea NOT NULL,
+  changed_at               timestamptz NOT NULL
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE whitelist_changes;
+DROP TABLE whitelist_entries;
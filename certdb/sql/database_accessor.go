@@ -59,6 +59,45 @@ SELECT %s FROM ocsp_responses
 	selectOCSPSQL = `
 SELECT %s FROM ocsp_responses
   WHERE (serial_number = ? AND authority_key_identifier = ?);`
+
+	insertExpiryNotificationSQL = `
+INSERT INTO expiry_notifications (serial_number, authority_key_identifier, threshold, route, notified_at)
+  VALUES (:serial_number, :authority_key_identifier, :threshold, :route, :notified_at);`
+
+	selectExpiryNotificationsSQL = `
+SELECT %s FROM expiry_notifications
+  WHERE (serial_number = ? AND authority_key_identifier = ?);`
+
+	insertScanResultSQL = `
+INSERT INTO scan_results (host, scanned_at, results)
+  VALUES (:host, :scanned_at, :results);`
+
+	selectScanResultsSQL = `
+SELECT %s FROM scan_results
+  WHERE host = ?
+  ORDER BY scanned_at;`
+
+	insertWhitelistEntrySQL = `
+INSERT INTO whitelist_entries (name, entry)
+  VALUES (:name, :entry);`
+
+	deleteWhitelistEntrySQL = `
+DELETE FROM whitelist_entries
+  WHERE (name = :name AND entry = :entry);`
+
+	selectWhitelistEntriesSQL = `
+SELECT %s FROM whitelist_entries
+  WHERE name = ?
+  ORDER BY entry;`
+
+	insertWhitelistChangeSQL = `
+INSERT INTO whitelist_changes (name, action, entry, actor, changed_at)
+  VALUES (:name, :action, :entry, :actor, :changed_at);`
+
+	selectWhitelistChangesSQL = `
+SELECT %s FROM whitelist_changes
+  WHERE name = ?
+  ORDER BY changed_at;`
 )
 
 // Accessor implements certdb.Accessor interface.
@@ -350,3 +389,187 @@ func (d *Accessor) UpsertOCSP(serial, aki, body string, expiry time.Time) error
 
 	return err
 }
+
+// InsertExpiryNotification records that an expiry notification was sent.
+func (d *Accessor) InsertExpiryNotification(er certdb.ExpiryNotificationRecord) error {
+	err := d.checkDB()
+	if err != nil {
+		return err
+	}
+
+	result, err := d.db.NamedExec(insertExpiryNotificationSQL, &certdb.ExpiryNotificationRecord{
+		Serial:     er.Serial,
+		AKI:        er.AKI,
+		Threshold:  er.Threshold,
+		Route:      er.Route,
+		NotifiedAt: er.NotifiedAt.UTC(),
+	})
+	if err != nil {
+		return wrapSQLError(err)
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+
+	if numRowsAffected == 0 {
+		return cferr.Wrap(cferr.CertStoreError, cferr.InsertionFailed, fmt.Errorf("failed to insert the expiry notification record"))
+	}
+
+	if numRowsAffected != 1 {
+		return wrapSQLError(fmt.Errorf("%d rows are affected, should be 1 row", numRowsAffected))
+	}
+
+	return err
+}
+
+// GetExpiryNotifications retrieves the expiry notifications sent for a certificate.
+func (d *Accessor) GetExpiryNotifications(serial, aki string) (ers []certdb.ExpiryNotificationRecord, err error) {
+	err = d.checkDB()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.db.Select(&ers, fmt.Sprintf(d.db.Rebind(selectExpiryNotificationsSQL), sqlstruct.Columns(certdb.ExpiryNotificationRecord{})), serial, aki)
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+
+	return ers, nil
+}
+
+// InsertScanResult records the results of a scan.
+func (d *Accessor) InsertScanResult(sr certdb.ScanRecord) error {
+	err := d.checkDB()
+	if err != nil {
+		return err
+	}
+
+	result, err := d.db.NamedExec(insertScanResultSQL, &certdb.ScanRecord{
+		Host:      sr.Host,
+		ScannedAt: sr.ScannedAt.UTC(),
+		Results:   sr.Results,
+	})
+	if err != nil {
+		return wrapSQLError(err)
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+
+	if numRowsAffected == 0 {
+		return cferr.Wrap(cferr.CertStoreError, cferr.InsertionFailed, fmt.Errorf("failed to insert the scan result record"))
+	}
+
+	if numRowsAffected != 1 {
+		return wrapSQLError(fmt.Errorf("%d rows are affected, should be 1 row", numRowsAffected))
+	}
+
+	return err
+}
+
+// GetScanResults retrieves every recorded scan of a host, oldest first.
+func (d *Accessor) GetScanResults(host string) (srs []certdb.ScanRecord, err error) {
+	err = d.checkDB()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.db.Select(&srs, fmt.Sprintf(d.db.Rebind(selectScanResultsSQL), sqlstruct.Columns(certdb.ScanRecord{})), host)
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+
+	return srs, nil
+}
+
+// InsertWhitelistEntry adds an entry to a whitelist.
+func (d *Accessor) InsertWhitelistEntry(wr certdb.WhitelistRecord) error {
+	err := d.checkDB()
+	if err != nil {
+		return err
+	}
+
+	result, err := d.db.NamedExec(insertWhitelistEntrySQL, &wr)
+	if err != nil {
+		return wrapSQLError(err)
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+
+	if numRowsAffected == 0 {
+		return cferr.Wrap(cferr.CertStoreError, cferr.InsertionFailed, fmt.Errorf("failed to insert the whitelist entry"))
+	}
+
+	if numRowsAffected != 1 {
+		return wrapSQLError(fmt.Errorf("%d rows are affected, should be 1 row", numRowsAffected))
+	}
+
+	return err
+}
+
+// DeleteWhitelistEntry removes an entry from a whitelist. Removing an
+// entry the whitelist doesn't have is not an error.
+func (d *Accessor) DeleteWhitelistEntry(wr certdb.WhitelistRecord) error {
+	err := d.checkDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.NamedExec(deleteWhitelistEntrySQL, &wr)
+	return wrapSQLError(err)
+}
+
+// GetWhitelistEntries retrieves the entries of a whitelist.
+func (d *Accessor) GetWhitelistEntries(name string) (wrs []certdb.WhitelistRecord, err error) {
+	err = d.checkDB()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.db.Select(&wrs, fmt.Sprintf(d.db.Rebind(selectWhitelistEntriesSQL), sqlstruct.Columns(certdb.WhitelistRecord{})), name)
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+
+	return wrs, nil
+}
+
+// InsertWhitelistChange records a change made to a whitelist.
+func (d *Accessor) InsertWhitelistChange(cr certdb.WhitelistChangeRecord) error {
+	err := d.checkDB()
+	if err != nil {
+		return err
+	}
+
+	cr.ChangedAt = cr.ChangedAt.UTC()
+	result, err := d.db.NamedExec(insertWhitelistChangeSQL, &cr)
+	if err != nil {
+		return wrapSQLError(err)
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+
+	if numRowsAffected == 0 {
+		return cferr.Wrap(cferr.CertStoreError, cferr.InsertionFailed, fmt.Errorf("failed to insert the whitelist change record"))
+	}
+
+	if numRowsAffected != 1 {
+		return wrapSQLError(fmt.Errorf("%d rows are affected, should be 1 row", numRowsAffected))
+	}
+
+	return err
+}
+
+// GetWhitelistChanges retrieves the changes made to a whitelist,
+// oldest first.
+func (d *Accessor) GetWhitelistChanges(name string) (crs []certdb.WhitelistChangeRecord, err error) {
+	err = d.checkDB()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.db.Select(&crs, fmt.Sprintf(d.db.Rebind(selectWhitelistChangesSQL), sqlstruct.Columns(certdb.WhitelistChangeRecord{})), name)
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+
+	return crs, nil
+}
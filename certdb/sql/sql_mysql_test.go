@@ -15,4 +15,7 @@ func TestMySQL(t *testing.T) {
 		DB:       db,
 	}
 	testEverything(ta, t)
+	testInsertExpiryNotificationAndGetExpiryNotifications(ta, t)
+	testInsertScanResultAndGetScanResults(ta, t)
+	testWhitelistEntriesAndChanges(ta, t)
 }
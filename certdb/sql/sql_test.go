@@ -1,7 +1,10 @@
 package sql
 
 import (
+	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -12,8 +15,9 @@ import (
 )
 
 const (
-	sqliteDBFile = "../testdb/certstore_development.db"
-	fakeAKI      = "fake_aki"
+	sqliteDBFile     = "../testdb/certstore_development.db"
+	sqliteMigrations = "../sqlite/migrations"
+	fakeAKI          = "fake_aki"
 )
 
 func TestNoDB(t *testing.T) {
@@ -42,6 +46,27 @@ func TestSQLite(t *testing.T) {
 	testEverything(ta, t)
 }
 
+// TestSQLiteMigrated tests the expiry notification, scan result and
+// whitelist tables in a new database, as the checked-in one doesn't have
+// them.
+func TestSQLiteMigrated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfssl_certdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := testdb.MigratedSQLiteDB(filepath.Join(dir, "certdb.db"), sqliteMigrations)
+	defer db.Close()
+	ta := TestAccessor{
+		Accessor: NewAccessor(db),
+		DB:       db,
+	}
+	testInsertExpiryNotificationAndGetExpiryNotifications(ta, t)
+	testInsertScanResultAndGetScanResults(ta, t)
+	testWhitelistEntriesAndChanges(ta, t)
+}
+
 // roughlySameTime decides if t1 and t2 are close enough.
 func roughlySameTime(t1, t2 time.Time) bool {
 	// return true if the difference is smaller than 1 sec.
@@ -402,6 +427,126 @@ func testUpsertOCSPAndGetOCSP(ta TestAccessor, t *testing.T) {
 	}
 }
 
+func testInsertExpiryNotificationAndGetExpiryNotifications(ta TestAccessor, t *testing.T) {
+	ta.Truncate()
+	accessor := ta.Accessor.(certdb.ExpiryNotificationAccessor)
+
+	want := certdb.ExpiryNotificationRecord{
+		Serial:     "fake serial",
+		AKI:        fakeAKI,
+		Threshold:  int64((24 * time.Hour).Seconds()),
+		Route:      "ops",
+		NotifiedAt: time.Now(),
+	}
+	if err := accessor.InsertExpiryNotification(want); err != nil {
+		t.Fatal(err)
+	}
+
+	// A notification can only be recorded once.
+	if err := accessor.InsertExpiryNotification(want); err == nil {
+		t.Fatal("expected a duplicate notification to be rejected")
+	}
+
+	rets, err := accessor.GetExpiryNotifications(want.Serial, want.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 1 {
+		t.Fatal("should return exactly one record")
+	}
+
+	got := rets[0]
+	if want.Serial != got.Serial || want.Threshold != got.Threshold ||
+		want.Route != got.Route || !roughlySameTime(want.NotifiedAt, got.NotifiedAt) {
+		t.Errorf("want expiry notification %+v, got %+v", want, got)
+	}
+}
+
+func testInsertScanResultAndGetScanResults(ta TestAccessor, t *testing.T) {
+	ta.Truncate()
+	accessor := ta.Accessor.(certdb.ScanAccessor)
+
+	now := time.Now()
+	for i, results := range []string{`{"second":{}}`, `{"first":{}}`} {
+		err := accessor.InsertScanResult(certdb.ScanRecord{
+			Host:      "example.com",
+			ScannedAt: now.Add(-time.Duration(i) * time.Hour),
+			Results:   results,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := accessor.InsertScanResult(certdb.ScanRecord{Host: "example.org", ScannedAt: now, Results: "{}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rets, err := accessor.GetScanResults("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 2 {
+		t.Fatal("should return exactly two records")
+	}
+	if rets[0].Results != `{"first":{}}` || rets[1].Results != `{"second":{}}` ||
+		!roughlySameTime(rets[1].ScannedAt, now) {
+		t.Errorf("expected the scans oldest first, got %+v", rets)
+	}
+}
+
+func testWhitelistEntriesAndChanges(ta TestAccessor, t *testing.T) {
+	ta.Truncate()
+	accessor := ta.Accessor.(certdb.WhitelistAccessor)
+
+	for _, wr := range []certdb.WhitelistRecord{
+		{Name: "serve", Entry: "10.0.0.0/8"},
+		{Name: "serve", Entry: "127.0.0.1/32"},
+		{Name: "other", Entry: "192.0.2.0/24"},
+	} {
+		if err := accessor.InsertWhitelistEntry(wr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := accessor.InsertWhitelistEntry(certdb.WhitelistRecord{Name: "serve", Entry: "10.0.0.0/8"}); err == nil {
+		t.Fatal("duplicate whitelist entry was inserted")
+	}
+	if err := accessor.DeleteWhitelistEntry(certdb.WhitelistRecord{Name: "serve", Entry: "10.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	wrs, err := accessor.GetWhitelistEntries("serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wrs) != 1 || wrs[0].Entry != "127.0.0.1/32" {
+		t.Fatalf("unexpected whitelist entries %+v", wrs)
+	}
+
+	now := time.Now()
+	for i, action := range []string{"remove", "add"} {
+		err = accessor.InsertWhitelistChange(certdb.WhitelistChangeRecord{
+			Name:      "serve",
+			Action:    action,
+			Entry:     "10.0.0.0/8",
+			Actor:     "admin",
+			ChangedAt: now.Add(-time.Duration(i) * time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	crs, err := accessor.GetWhitelistChanges("serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crs) != 2 || crs[0].Action != "add" || crs[1].Action != "remove" ||
+		crs[1].Actor != "admin" || !roughlySameTime(crs[1].ChangedAt, now) {
+		t.Errorf("expected the changes oldest first, got %+v", crs)
+	}
+}
+
 func setupGoodCert(ta TestAccessor, t *testing.T, r certdb.OCSPRecord) {
 	certWant := certdb.CertificateRecord{
 		AKI:     r.AKI,
@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE expiry_notifications (
+  serial_number            blob NOT NULL,
+  authority_key_identifier blob NOT NULL,
+  threshold                int NOT NULL,
+  route                    blob NOT NULL,
+  notified_at              timestamp,
+  PRIMARY KEY(serial_number, authority_key_identifier, threshold, route)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE expiry_notifications;
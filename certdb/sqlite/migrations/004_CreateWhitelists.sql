@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE whitelist_entries (
+  name                     blob NOT NULL,
+  entry                    blob NOT NULL,
+  PRIMARY KEY(name, entry)
+);
+
+CREATE TABLE whitelist_changes (
+  name                     blob NOT NULL,
+  action                   blob NOT NULL,
+  entry                    blob NOT NULL,
+  actor                    blob NOT NULL,
+  changed_at               timestamp NOT NULL
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE whitelist_changes;
+DROP TABLE whitelist_entries;
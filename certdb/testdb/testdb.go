@@ -1,7 +1,10 @@
 package testdb
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	_ "github.com/go-sql-driver/mysql" // register mysql driver
@@ -14,6 +17,10 @@ const (
 	mysqlTruncateTables = `
 TRUNCATE certificates;
 TRUNCATE ocsp_responses;
+TRUNCATE expiry_notifications;
+TRUNCATE scan_results;
+TRUNCATE whitelist_entries;
+TRUNCATE whitelist_changes;
 `
 
 	pgTruncateTables = `
@@ -88,6 +95,37 @@ func SQLiteDB(dbpath string) *sqlx.DB {
 	return db
 }
 
+// MigratedSQLiteDB returns a new SQLite db instance at dbpath, created
+// by applying the Up section of each goose migration in migrations, for
+// testing tables that the checked-in databases don't have.
+func MigratedSQLiteDB(dbpath, migrations string) *sqlx.DB {
+	db, err := sqlx.Open("sqlite3", dbpath)
+	if err != nil {
+		panic(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(migrations, "*.sql"))
+	if err != nil {
+		panic(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		in, err := ioutil.ReadFile(file)
+		if err != nil {
+			panic(err)
+		}
+		up := string(in)
+		if i := strings.Index(up, "-- +goose Down"); i >= 0 {
+			up = up[:i]
+		}
+		if _, err = db.Exec(up); err != nil {
+			panic(err)
+		}
+	}
+
+	return db
+}
+
 // Truncate truncates the DB
 func Truncate(db *sqlx.DB) {
 	var sql []string
@@ -7,6 +7,7 @@ import (
 
 	"github.com/cloudflare/cfssl/bundler"
 	"github.com/cloudflare/cfssl/cli"
+	"github.com/cloudflare/cfssl/starttls"
 	"github.com/cloudflare/cfssl/ubiquity"
 )
 
@@ -15,19 +16,20 @@ var bundlerUsageText = `cfssl bundle -- create a certificate bundle that contain
 
 Usage of bundle:
 	- Bundle local certificate files
-        cfssl bundle -cert file [-ca-bundle file] [-int-bundle file] [-int-dir dir] [-metadata file] [-key keyfile] [-flavor optimal|ubiquitous|force] [-password password]
+        cfssl bundle -cert file [-ca-bundle file] [-int-bundle file] [-int-dir dir] [-aia-cache dir] [-metadata file] [-key keyfile] [-flavor optimal|ubiquitous|force|flavor] [-chain-policy file] [-password password]
 	- Bundle certificate from remote server.
-        cfssl bundle -domain domain_name [-ip ip_address] [-ca-bundle file] [-int-bundle file] [-int-dir dir] [-metadata file]
+        cfssl bundle -domain domain_name [-ip ip_address] [-starttls protocol] [-ca-bundle file] [-int-bundle file] [-int-dir dir] [-aia-cache dir] [-metadata file]
 
 Flags:
 `
 
 // flags used by 'cfssl bundle'
-var bundlerFlags = []string{"cert", "key", "ca-bundle", "int-bundle", "flavor", "int-dir", "metadata", "domain", "ip", "password"}
+var bundlerFlags = []string{"cert", "key", "ca-bundle", "int-bundle", "flavor", "chain-policy", "int-dir", "aia-cache", "aia-cache-ttl", "metadata", "domain", "ip", "starttls", "password"}
 
 // bundlerMain is the main CLI of bundler functionality.
 func bundlerMain(args []string, c cli.Config) (err error) {
 	bundler.IntermediateStash = c.IntDir
+	cli.SetAIACache(&c)
 	ubiquity.LoadPlatforms(c.Metadata)
 	flavor := bundler.BundleFlavor(c.Flavor)
 	var b *bundler.Bundler
@@ -36,7 +38,12 @@ func bundlerMain(args []string, c cli.Config) (err error) {
 	if flavor == bundler.Force {
 		b = &bundler.Bundler{}
 	} else {
-		b, err = bundler.NewBundler(c.CABundleFile, c.IntBundleFile)
+		var opts []bundler.Option
+		opts, err = cli.BundlerOptionsFromConfig(&c)
+		if err != nil {
+			return
+		}
+		b, err = bundler.NewBundler(c.CABundleFile, c.IntBundleFile, opts...)
 		if err != nil {
 			return
 		}
@@ -68,7 +75,7 @@ func bundlerMain(args []string, c cli.Config) (err error) {
 			}
 		}
 	} else if c.Domain != "" {
-		bundle, err = b.BundleFromRemote(c.Domain, c.IP, flavor)
+		bundle, err = b.BundleFromRemote(starttls.Join(c.StartTLS, c.Domain), c.IP, flavor)
 		if err != nil {
 			return
 		}
@@ -0,0 +1,303 @@
+// Package ceremony implements the ceremony command.
+package ceremony
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cloudflare/cfssl/ceremony"
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/certdb/dbconf"
+	"github.com/cloudflare/cfssl/certdb/sql"
+	"github.com/cloudflare/cfssl/cli"
+	"github.com/cloudflare/cfssl/cli/sign"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/keysource"
+	"github.com/cloudflare/cfssl/ocsp"
+	"github.com/cloudflare/cfssl/signer"
+)
+
+// Usage text of 'cfssl ceremony'
+var ceremonyUsageText = `cfssl ceremony -- signs certificates with an offline CA
+
+Usage of ceremony:
+        cfssl ceremony export -key operator-key [-profile profile] [-label label] [-hostname hostname] [-db-config db-config] [-transcript file] REQUEST...
+        cfssl ceremony sign -ca cert -ca-key key -cert operator-cert [-config config] [-responder cert -responder-key key] [-interval 96h] [-expiry 168h] [-transcript file] REQUESTS
+        cfssl ceremony import -ca cert -db-config db-config [-transcript file] RESULTS [REQUESTS]
+
+export writes a request file, signed by the operator's key, holding the
+sign requests and the revoked certificates in the certificate database.
+sign checks the request file against the operator's certificate, signs
+the requests with the offline CA, and writes a result file, signed by
+the CA key, holding the certificates, their OCSP responses, and a CRL.
+import checks the result file against the CA certificate, records the
+certificates and OCSP responses in the certificate database, and prints
+the CRL. Each step writes a transcript of what it checked and produced.
+
+Arguments:
+        REQUEST:    PEM file for a certificate request, or a JSON sign request
+        REQUESTS:   request file written by 'cfssl ceremony export'
+        RESULTS:    result file written by 'cfssl ceremony sign'
+
+Flags:
+`
+
+// Flags of 'cfssl ceremony'
+var ceremonyFlags = []string{"key", "cert", "ca", "ca-key", "config", "profile", "label", "hostname",
+	"db-config", "responder", "responder-key", "interval", "expiry", "transcript"}
+
+// ceremonyMain is the main CLI of the offline CA ceremony.
+func ceremonyMain(args []string, c cli.Config) error {
+	step, args, err := cli.PopFirstArgument(args)
+	if err != nil {
+		return errors.New("need a ceremony step: export, sign or import")
+	}
+
+	t, closer, err := openTranscript(c.Transcript)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	switch step {
+	case "export":
+		return exportMain(args, c, t)
+	case "sign":
+		return signMain(args, c, t)
+	case "import":
+		return importMain(args, c, t)
+	default:
+		return fmt.Errorf("unknown ceremony step %q", step)
+	}
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func openTranscript(name string) (*ceremony.Transcript, io.Closer, error) {
+	if name == "" || name == "-" {
+		return ceremony.NewTranscript(os.Stderr), nopCloser{}, nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ceremony.NewTranscript(f), f, nil
+}
+
+// loadKey loads a private key from a file or a key source.
+func loadKey(spec string) (crypto.Signer, error) {
+	if keysource.IsSpec(spec) {
+		return keysource.Load(spec, nil)
+	}
+	in, err := helpers.ReadBytes(spec)
+	if err != nil {
+		return nil, err
+	}
+	return keysource.ParsePrivateKey(in)
+}
+
+func readEnvelope(name string) (*ceremony.Envelope, error) {
+	in, err := cli.ReadStdin(name)
+	if err != nil {
+		return nil, err
+	}
+	var env ceremony.Envelope
+	if err = json.Unmarshal(in, &env); err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
+
+func printEnvelope(env *ceremony.Envelope) error {
+	out, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", out)
+	return nil
+}
+
+func exportMain(args []string, c cli.Config, t *ceremony.Transcript) error {
+	if c.KeyFile == "" {
+		return errors.New("need the operator's key (provide with -key)")
+	}
+	if len(args) == 0 {
+		return errors.New("need at least one certificate request")
+	}
+
+	var reqs []signer.SignRequest
+	for _, name := range args {
+		in, err := cli.ReadStdin(name)
+		if err != nil {
+			return err
+		}
+
+		var req signer.SignRequest
+		if strings.Contains(string(in), "-----BEGIN") {
+			req = signer.SignRequest{
+				Hosts:   signer.SplitHosts(c.Hostname),
+				Request: string(in),
+				Profile: c.Profile,
+				Label:   c.Label,
+			}
+		} else if err = json.Unmarshal(in, &req); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		reqs = append(reqs, req)
+	}
+
+	var revoked []certdb.CertificateRecord
+	if c.DBConfigFile != "" {
+		db, err := dbconf.DBFromConfig(c.DBConfigFile)
+		if err != nil {
+			return err
+		}
+		revoked, err = sql.NewAccessor(db).GetRevokedAndUnexpiredCertificates()
+		if err != nil {
+			return err
+		}
+	}
+
+	key, err := loadKey(c.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	batch, err := ceremony.NewRequestBatch(reqs, revoked)
+	if err != nil {
+		return err
+	}
+
+	env, err := ceremony.Export(batch, key, t)
+	if err != nil {
+		return err
+	}
+	return printEnvelope(env)
+}
+
+func signMain(args []string, c cli.Config, t *ceremony.Transcript) error {
+	if c.CertFile == "" {
+		return errors.New("need the operator's certificate (provide with -cert)")
+	}
+
+	requestsFile, args, err := cli.PopFirstArgument(args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return errors.New("only one request file can be signed")
+	}
+
+	env, err := readEnvelope(requestsFile)
+	if err != nil {
+		return err
+	}
+
+	operatorPEM, err := helpers.ReadBytes(c.CertFile)
+	if err != nil {
+		return err
+	}
+	operator, err := helpers.ParseCertificatePEM(operatorPEM)
+	if err != nil {
+		return err
+	}
+
+	caPEM, err := helpers.ReadBytes(c.CAFile)
+	if err != nil {
+		return err
+	}
+	ca, err := helpers.ParseCertificatePEM(caPEM)
+	if err != nil {
+		return err
+	}
+
+	key, err := loadKey(c.CAKeyFile)
+	if err != nil {
+		return err
+	}
+
+	s, err := sign.SignerFromConfig(c)
+	if err != nil {
+		return err
+	}
+
+	// The CA answers OCSP requests itself unless a responder is given.
+	var ocspSigner ocsp.Signer
+	if c.ResponderFile == "" {
+		ocspSigner, err = ocsp.NewSigner(ca, ca, key, c.Interval)
+	} else {
+		ocspSigner, err = ocsp.NewSignerFromFile(c.CAFile, c.ResponderFile, c.ResponderKeyFile, c.Interval)
+	}
+	if err != nil {
+		return err
+	}
+
+	offline := &ceremony.Offline{
+		Signer:     s,
+		OCSPSigner: ocspSigner,
+		CA:         ca,
+		Key:        key,
+		CRLExpiry:  c.CRLExpiration,
+	}
+	out, err := offline.Sign(env, operator.PublicKey, t)
+	if err != nil {
+		return err
+	}
+	return printEnvelope(out)
+}
+
+func importMain(args []string, c cli.Config, t *ceremony.Transcript) error {
+	if c.DBConfigFile == "" {
+		return errors.New("need DB config file (provide with -db-config)")
+	}
+
+	resultsFile, args, err := cli.PopFirstArgument(args)
+	if err != nil {
+		return err
+	}
+
+	env, err := readEnvelope(resultsFile)
+	if err != nil {
+		return err
+	}
+
+	var requests *ceremony.Envelope
+	if len(args) > 0 {
+		requests, err = readEnvelope(args[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	caPEM, err := helpers.ReadBytes(c.CAFile)
+	if err != nil {
+		return err
+	}
+	ca, err := helpers.ParseCertificatePEM(caPEM)
+	if err != nil {
+		return err
+	}
+
+	db, err := dbconf.DBFromConfig(c.DBConfigFile)
+	if err != nil {
+		return err
+	}
+
+	results, err := ceremony.Import(env, ca, requests, sql.NewAccessor(db), t)
+	if err != nil {
+		return err
+	}
+
+	cli.PrintCRL(results.CRL)
+	return nil
+}
+
+// Command assembles the definition of Command 'ceremony'
+var Command = &cli.Command{UsageText: ceremonyUsageText, Flags: ceremonyFlags, Main: ceremonyMain}
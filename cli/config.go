@@ -4,6 +4,8 @@ import (
 	"flag"
 	"time"
 
+	"github.com/cloudflare/cfssl/aia"
+	"github.com/cloudflare/cfssl/bundler"
 	"github.com/cloudflare/cfssl/config"
 	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/log"
@@ -37,10 +39,14 @@ type Config struct {
 	IsCA              bool
 	RenewCA           bool
 	IntDir            string
+	AIACacheDir       string
+	AIACacheTTL       time.Duration
 	Flavor            string
+	ChainPolicyFile   string
 	Metadata          string
 	Domain            string
 	IP                string
+	StartTLS          string
 	Remote            string
 	Label             string
 	AuthKey           string
@@ -50,13 +56,18 @@ type Config struct {
 	Reason            string
 	RevokedAt         string
 	Interval          time.Duration
+	Nonce             bool
+	StaleIfError      time.Duration
 	List              bool
+	IgnoredLints      string
 	Family            string
 	Timeout           time.Duration
 	Scanner           string
 	CSVFile           string
 	NumWorkers        int
 	MaxHosts          int
+	Rescan            time.Duration
+	Diff              bool
 	Responses         string
 	Path              string
 	CRL               string
@@ -67,7 +78,12 @@ type Config struct {
 	CNOverride        string
 	AKI               string
 	DBConfigFile      string
+	Whitelist         string
 	CRLExpiration     time.Duration
+	Transcript        string
+	SCEPChallenge     string
+	KeyPassword       string
+	KeyCipher         string
 }
 
 // registerFlags defines all cfssl command flags and associates their values with variables.
@@ -95,10 +111,14 @@ func registerFlags(c *Config, f *flag.FlagSet) {
 	f.BoolVar(&c.IsCA, "initca", false, "initialise new CA")
 	f.BoolVar(&c.RenewCA, "renewca", false, "re-generate a CA certificate from existing CA certificate/key")
 	f.StringVar(&c.IntDir, "int-dir", "", "specify intermediates directory")
-	f.StringVar(&c.Flavor, "flavor", "ubiquitous", "Bundle Flavor: ubiquitous, optimal and force.")
+	f.StringVar(&c.AIACacheDir, "aia-cache", "", "directory persisting the intermediates fetched through AIA URLs")
+	f.DurationVar(&c.AIACacheTTL, "aia-cache-ttl", aia.DefaultTTL, "time for which intermediates fetched through AIA URLs are cached")
+	f.StringVar(&c.Flavor, "flavor", "ubiquitous", "Bundle Flavor: ubiquitous, optimal, force, or a flavor from the chain policy file.")
+	f.StringVar(&c.ChainPolicyFile, "chain-policy", "", "JSON file of chain policies for bundle flavors")
 	f.StringVar(&c.Metadata, "metadata", "", "Metadata file for root certificate presence. The content of the file is a json dictionary (k,v): each key k is SHA-1 digest of a root certificate while value v is a list of key store filenames.")
 	f.StringVar(&c.Domain, "domain", "", "remote server domain name")
 	f.StringVar(&c.IP, "ip", "", "remote server ip")
+	f.StringVar(&c.StartTLS, "starttls", "", "negotiate TLS with the remote server using STARTTLS for protocol: smtp, imap, pop3, ldap or postgres")
 	f.StringVar(&c.Remote, "remote", "", "remote CFSSL server")
 	f.StringVar(&c.Label, "label", "", "key label to use in remote CFSSL server")
 	f.StringVar(&c.AuthKey, "authkey", "", "key to authenticate requests to remote CFSSL server")
@@ -108,13 +128,18 @@ func registerFlags(c *Config, f *flag.FlagSet) {
 	f.StringVar(&c.Reason, "reason", "0", "Reason code for revocation")
 	f.StringVar(&c.RevokedAt, "revoked-at", "now", "Date of revocation (YYYY-MM-DD)")
 	f.DurationVar(&c.Interval, "interval", 4*helpers.OneDay, "Interval between OCSP updates (default: 96h)")
-	f.BoolVar(&c.List, "list", false, "list possible scanners")
+	f.BoolVar(&c.Nonce, "nonce", false, "echo request nonces in live-signed OCSP responses")
+	f.DurationVar(&c.StaleIfError, "stale-if-error", 0, "how long HTTP caches may serve an expired OCSP response while the responder fails")
+	f.BoolVar(&c.List, "list", false, "list possible scanners or lint rules")
+	f.StringVar(&c.IgnoredLints, "ignored-lints", "", "comma-separated names of lint rules to skip")
 	f.StringVar(&c.Family, "family", "", "scanner family regular expression")
 	f.StringVar(&c.Scanner, "scanner", "", "scanner regular expression")
 	f.DurationVar(&c.Timeout, "timeout", 5*time.Minute, "duration (ns, us, ms, s, m, h) to scan each host before timing out")
 	f.StringVar(&c.CSVFile, "csv", "", "file containing CSV of hosts")
 	f.IntVar(&c.NumWorkers, "num-workers", 10, "number of workers to use for scan")
 	f.IntVar(&c.MaxHosts, "max-hosts", 100, "maximum number of hosts to scan")
+	f.DurationVar(&c.Rescan, "rescan", 0, "interval between scheduled re-scans of the hosts (default: scan once)")
+	f.BoolVar(&c.Diff, "diff", false, "show changes between the two most recent recorded scans of each host")
 	f.StringVar(&c.Responses, "responses", "", "file to load OCSP responses from")
 	f.StringVar(&c.Path, "path", "/", "Path on which the server will listen")
 	f.StringVar(&c.CRL, "crl", "", "CRL URL Override")
@@ -126,7 +151,12 @@ func registerFlags(c *Config, f *flag.FlagSet) {
 	f.StringVar(&c.CNOverride, "cn", "", "certificate common name (CN)")
 	f.StringVar(&c.AKI, "aki", "", "certificate issuer (authority) key identifier")
 	f.StringVar(&c.DBConfigFile, "db-config", "", "certificate db configuration file")
+	f.StringVar(&c.Whitelist, "whitelist", "", "keep administered IP whitelists in this directory, or 'db' for the certificate db, or 'db:' and a db configuration file")
 	f.DurationVar(&c.CRLExpiration, "expiry", 7*helpers.OneDay, "time from now after which the CRL will expire (default: one week)")
+	f.StringVar(&c.Transcript, "transcript", "", "file to append the ceremony transcript to (default: stderr)")
+	f.StringVar(&c.SCEPChallenge, "scep-challenge", "", "SCEP challenge password -- accepts '[file:]fname' or 'env:varname'")
+	f.StringVar(&c.KeyPassword, "key-password", "", "encrypt generated private keys with this passphrase -- accepts '[file:]fname', 'env:varname' or 'prompt'")
+	f.StringVar(&c.KeyCipher, "key-cipher", "aes256-cbc", "cipher encrypting generated private keys: aes256-cbc or aes256-gcm")
 	f.IntVar(&log.Level, "loglevel", log.LevelInfo, "Log level (0 = DEBUG, 5 = FATAL)")
 }
 
@@ -141,3 +171,26 @@ func RootFromConfig(c *Config) universal.Root {
 		ForceRemote: c.Remote != "",
 	}
 }
+
+// BundlerOptionsFromConfig returns the bundler options that add the
+// flavors of the chain policy file, if there is one.
+func BundlerOptionsFromConfig(c *Config) ([]bundler.Option, error) {
+	if c.ChainPolicyFile == "" {
+		return nil, nil
+	}
+	policies, err := bundler.LoadChainPolicies(c.ChainPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	var opts []bundler.Option
+	for flavor, p := range policies {
+		opts = append(opts, bundler.WithChainPolicy(flavor, p))
+	}
+	return opts, nil
+}
+
+// SetAIACache configures the intermediate cache shared by the bundler,
+// the revocation checker and the scanners.
+func SetAIACache(c *Config) {
+	aia.Default = aia.New(aia.Config{Dir: c.AIACacheDir, TTL: c.AIACacheTTL})
+}
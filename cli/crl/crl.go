@@ -2,8 +2,6 @@
 package crl
 
 import (
-	"os"
-
 	"github.com/cloudflare/cfssl/certdb/dbconf"
 	certsql "github.com/cloudflare/cfssl/certdb/sql"
 	"github.com/cloudflare/cfssl/cli"
@@ -65,14 +63,8 @@ func generateCRL(c cli.Config) (crlBytes []byte, err error) {
 		return nil, err
 	}
 
-	strPassword := os.Getenv("CFSSL_CA_PK_PASSWORD")
-	password := []byte(strPassword)
-	if strPassword == "" {
-		password = nil
-	}
-
 	// Parse the key given
-	key, err := helpers.ParsePrivateKeyPEMWithPassword(cakey, password)
+	key, err := helpers.ParsePrivateKeyPEMFromEnv(cakey)
 	if err != nil {
 		log.Debug("malformed private key %v", err)
 		return nil, err
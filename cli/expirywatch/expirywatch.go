@@ -0,0 +1,68 @@
+// Package expirywatch implements the expiry-watch command.
+package expirywatch
+
+import (
+	"errors"
+
+	"github.com/cloudflare/cfssl/certdb/dbconf"
+	"github.com/cloudflare/cfssl/certdb/sql"
+	"github.com/cloudflare/cfssl/cli"
+	"github.com/cloudflare/cfssl/expiry"
+)
+
+// Usage text of 'cfssl expiry-watch'
+var expirywatchUsageText = `cfssl expiry-watch -- sends notifications for certificates approaching expiry
+
+Usage of expiry-watch:
+        cfssl expiry-watch -db-config db-config WATCHCONFIG
+
+Certificates are read from the certificate database and from the
+directories and endpoints listed in WATCHCONFIG, which also configures
+the notification thresholds, notifiers and per-label routes. If
+WATCHCONFIG sets an interval, expiry-watch runs until it is killed;
+otherwise it checks certificates once and exits.
+
+Arguments:
+        WATCHCONFIG: JSON file with the watcher configuration
+
+Flags:
+`
+
+// Flags of 'cfssl expiry-watch'
+var expirywatchFlags = []string{"db-config"}
+
+// expirywatchMain is the main CLI of the expiry watcher.
+func expirywatchMain(args []string, c cli.Config) error {
+	if c.DBConfigFile == "" {
+		return errors.New("need DB config file (provide with -db-config)")
+	}
+
+	watchFile, args, err := cli.PopFirstArgument(args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		return errors.New("only one watch configuration can be given")
+	}
+
+	cfg, err := expiry.LoadConfig(watchFile)
+	if err != nil {
+		return err
+	}
+
+	db, err := dbconf.DBFromConfig(c.DBConfigFile)
+	if err != nil {
+		return err
+	}
+
+	w, err := expiry.NewWatcher(cfg, sql.NewAccessor(db))
+	if err != nil {
+		return err
+	}
+
+	return w.Run()
+}
+
+// Command assembles the definition of Command 'expiry-watch'
+var Command = &cli.Command{UsageText: expirywatchUsageText, Flags: expirywatchFlags, Main: expirywatchMain}
@@ -29,13 +29,18 @@ Usage of gencert:
     Re-generate a CA cert with the CA key and certificate:
         cfssl gencert -renewca -ca cert -ca-key key
 
+    Encrypted CA keys are decrypted with the password in the
+    CFSSL_CA_PK_PASSWORD environment variable, or in the file named by
+    CFSSL_CA_PK_PASSWORD_FILE. Generated keys are encrypted if
+    -key-password is given.
+
 Arguments:
         CSRJSON:    JSON file containing the request, use '-' for reading JSON from stdin
 
 Flags:
 `
 
-var gencertFlags = []string{"initca", "remote", "ca", "ca-key", "config", "cn", "hostname", "profile", "label"}
+var gencertFlags = []string{"initca", "remote", "ca", "ca-key", "config", "cn", "hostname", "profile", "label", "key-password", "key-cipher"}
 
 func gencertMain(args []string, c cli.Config) error {
 	if c.RenewCA {
@@ -91,6 +96,9 @@ func gencertMain(args []string, c cli.Config) error {
 			}
 
 		}
+		if key, err = cli.EncryptKey(key, c); err != nil {
+			return err
+		}
 		cli.PrintCert(key, csrPEM, cert)
 
 	default:
@@ -156,6 +164,9 @@ func gencertMain(args []string, c cli.Config) error {
 			log.Warning(generator.CSRNoHostMessage)
 		}
 
+		if key, err = cli.EncryptKey(key, c); err != nil {
+			return err
+		}
 		cli.PrintCert(key, csrBytes, cert)
 	}
 	return nil
@@ -35,7 +35,7 @@ func gencsrMain(args []string, c cli.Config) (err error) {
 		return err
 	}
 
-	key, err := helpers.ParsePrivateKeyPEM(keyBytes)
+	key, err := helpers.ParsePrivateKeyPEMFromEnv(keyBytes)
 	if err != nil {
 		return err
 	}
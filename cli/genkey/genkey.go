@@ -14,6 +14,7 @@ var genkeyUsageText = `cfssl genkey -- generate a new key and CSR
 
 Usage of genkey:
         cfssl genkey CSRJSON
+        cfssl genkey -key-password prompt CSRJSON
 
 Arguments:
         CSRJSON:    JSON file containing the request, use '-' for reading JSON from stdin
@@ -21,7 +22,7 @@ Arguments:
 Flags:
 `
 
-var genkeyFlags = []string{"initca", "config"}
+var genkeyFlags = []string{"initca", "config", "key-password", "key-cipher"}
 
 func genkeyMain(args []string, c cli.Config) (err error) {
 	csrFile, args, err := cli.PopFirstArgument(args)
@@ -52,6 +53,9 @@ func genkeyMain(args []string, c cli.Config) (err error) {
 			return
 		}
 
+		if key, err = cli.EncryptKey(key, c); err != nil {
+			return
+		}
 		cli.PrintCert(key, csrPEM, cert)
 	} else {
 		if req.CA != nil {
@@ -67,6 +71,9 @@ func genkeyMain(args []string, c cli.Config) (err error) {
 			return
 		}
 
+		if key, err = cli.EncryptKey(key, c); err != nil {
+			return
+		}
 		cli.PrintCert(key, csrPEM, nil)
 	}
 	return nil
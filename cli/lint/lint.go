@@ -0,0 +1,96 @@
+// Package lint implements the lint command.
+package lint
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cloudflare/cfssl/cli"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/lint"
+)
+
+// Usage text of 'cfssl lint'
+var lintUsageText = `cfssl lint -- check certificates against RFC 5280 and the CA/B Forum Baseline Requirements
+
+Usage of lint:
+        cfssl lint -cert file [-ignored-lints names]
+        cfssl lint -list
+
+The certificate file may hold several PEM or DER certificates; each is
+checked. The command fails if any certificate has lint errors.
+
+Flags:
+`
+
+// flags used by 'cfssl lint'
+var lintFlags = []string{"cert", "ignored-lints", "list"}
+
+// ignoredLints returns the lint rule names in the comma-separated list.
+func ignoredLints(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	names := strings.Split(list, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+		if _, ok := lint.Lookup(names[i]); !ok {
+			return nil, fmt.Errorf("unknown lint %q", names[i])
+		}
+	}
+	return names, nil
+}
+
+func lintMain(args []string, c cli.Config) (err error) {
+	var out interface{}
+	var failed bool
+
+	if c.List {
+		out = lint.Rules()
+	} else if c.CertFile != "" {
+		var ignored []string
+		if ignored, err = ignoredLints(c.IgnoredLints); err != nil {
+			return
+		}
+
+		var certData []byte
+		if certData, err = cli.ReadStdin(c.CertFile); err != nil {
+			return
+		}
+		var certs []*x509.Certificate
+		if bytes.Contains(certData, []byte("-----BEGIN")) {
+			certs, err = helpers.ParseCertificatesPEM(certData)
+		} else {
+			certs, _, err = helpers.ParseCertificatesDER(certData, "")
+		}
+		if err != nil {
+			return
+		}
+
+		reports := lint.Reports(certs, ignored)
+		for _, report := range reports {
+			failed = failed || len(report.Findings.Errors()) > 0
+		}
+		out = reports
+	} else {
+		return errors.New("Must specify lint target through -cert, or use -list")
+	}
+
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return
+	}
+	fmt.Println(string(b))
+
+	if failed {
+		return errors.New("certificate has lint errors")
+	}
+	return
+}
+
+// Command assembles the definition of Command 'lint'
+var Command = &cli.Command{UsageText: lintUsageText, Flags: lintFlags, Main: lintMain}
@@ -5,8 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/cloudflare/cfssl/certdb/dbconf"
+	"github.com/cloudflare/cfssl/certdb/sql"
 	"github.com/cloudflare/cfssl/cli"
+	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/ocsp"
 )
@@ -15,13 +19,18 @@ import (
 var ocspServerUsageText = `cfssl ocspserve -- set up an HTTP server that handles OCSP requests from either a file or directly from a database (see RFC 5019)
 
   Usage of ocspserve:
-          cfssl ocspserve [-address address] [-port port] [-responses file] [-db-config db-config]
+          cfssl ocspserve [-address address] [-port port] [-responses file] [-db-config db-config] [-stale-if-error duration]
+          cfssl ocspserve [-address address] [-port port] -db-config db-config -ca cert -responder cert -responder-key key [-interval duration] [-nonce]
+
+  With -ca, responses are signed on demand from the certificates in the
+  database. -ca, -responder and -responder-key may be comma-separated
+  lists, one entry per issuer served.
 
   Flags:
   `
 
 // Flags used by 'cfssl serve'
-var ocspServerFlags = []string{"address", "port", "responses", "db-config"}
+var ocspServerFlags = []string{"address", "port", "responses", "db-config", "ca", "responder", "responder-key", "interval", "nonce", "stale-if-error"}
 
 // ocspServerMain is the command line entry point to the OCSP responder.
 // It sets up a new HTTP server that responds to OCSP requests.
@@ -32,7 +41,13 @@ func ocspServerMain(args []string, c cli.Config) error {
 		return errors.New("argument is provided but not defined; please refer to the usage by flag -h")
 	}
 
-	if c.Responses != "" {
+	if c.CAFile != "" {
+		s, err := liveSource(c)
+		if err != nil {
+			return err
+		}
+		src = s
+	} else if c.Responses != "" {
 		s, err := ocsp.NewSourceFromFile(c.Responses)
 		if err != nil {
 			return errors.New("unable to read response file")
@@ -51,12 +66,58 @@ func ocspServerMain(args []string, c cli.Config) error {
 	}
 
 	log.Info("Registering OCSP responder handler")
-	http.Handle(c.Path, ocsp.NewResponder(src))
+	responder := ocsp.NewResponder(src)
+	responder.StaleIfError = c.StaleIfError
+	http.Handle(c.Path, responder)
 
 	addr := fmt.Sprintf("%s:%d", c.Address, c.Port)
 	log.Info("Now listening on ", addr)
 	return http.ListenAndServe(addr, nil)
 }
 
+// liveSource returns a source signing responses on demand for each
+// issuer listed in -ca, with the responder at the same position in
+// -responder and -responder-key.
+func liveSource(c cli.Config) (ocsp.Source, error) {
+	cas := strings.Split(c.CAFile, ",")
+	responders := strings.Split(c.ResponderFile, ",")
+	keys := strings.Split(c.ResponderKeyFile, ",")
+	if c.DBConfigFile == "" {
+		return nil, errors.New("live signing requires -db-config")
+	}
+	if len(responders) != len(cas) || len(keys) != len(cas) {
+		return nil, errors.New("-ca, -responder and -responder-key must list as many files each")
+	}
+
+	db, err := dbconf.DBFromConfig(c.DBConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	accessor := sql.NewAccessor(db)
+
+	src := ocsp.NewMultiSource()
+	for i := range cas {
+		signer, err := ocsp.NewSignerFromFile(cas[i], responders[i], keys[i], c.Interval)
+		if err != nil {
+			return nil, err
+		}
+		caPEM, err := helpers.ReadBytes(cas[i])
+		if err != nil {
+			return nil, err
+		}
+		issuer, err := helpers.ParseCertificatePEM(caPEM)
+		if err != nil {
+			return nil, err
+		}
+		live := ocsp.NewLiveSource(issuer, signer, accessor)
+		live.EchoNonces = c.Nonce
+		if err = src.Add(issuer, live); err != nil {
+			return nil, err
+		}
+		log.Infof("Signing OCSP responses for %s", issuer.Subject.CommonName)
+	}
+	return src, nil
+}
+
 // Command assembles the definition of Command 'ocspserve'
 var Command = &cli.Command{UsageText: ocspServerUsageText, Flags: ocspServerFlags, Main: ocspServerMain}
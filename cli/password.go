@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+// ReadPassword reads a passphrase as specified on the command line: the
+// literal "prompt" asks for it, twice, on the controlling terminal;
+// anything else is read with helpers.ReadBytes, so 'env:varname' and
+// '[file:]fname' are accepted. Trailing newlines are removed.
+func ReadPassword(spec string) ([]byte, error) {
+	if spec == "prompt" {
+		return promptPassword()
+	}
+	password, err := helpers.ReadBytes(spec)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(password, "\r\n"), nil
+}
+
+// promptPassword reads a passphrase from /dev/tty with echo turned off,
+// as standard input usually carries the CSR JSON.
+func promptPassword() ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot prompt for a passphrase: %v", err)
+	}
+	defer tty.Close()
+
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = tty
+		return cmd.Run()
+	}
+	if err = stty("-echo"); err != nil {
+		return nil, fmt.Errorf("cannot turn off terminal echo: %v", err)
+	}
+	defer stty("echo")
+
+	r := bufio.NewReader(tty)
+	var passwords [2][]byte
+	for i, prompt := range []string{"Enter passphrase: ", "Confirm passphrase: "} {
+		fmt.Fprint(tty, prompt)
+		line, err := r.ReadBytes('\n')
+		fmt.Fprintln(tty)
+		if err != nil {
+			return nil, err
+		}
+		passwords[i] = bytes.TrimRight(line, "\r\n")
+	}
+	if !bytes.Equal(passwords[0], passwords[1]) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return passwords[0], nil
+}
+
+// EncryptKey encrypts a generated PEM-encoded private key with the
+// passphrase given by the -key-password flag, using the -key-cipher
+// cipher. The key is returned unchanged if no passphrase was requested.
+func EncryptKey(key []byte, c Config) ([]byte, error) {
+	if key == nil || c.KeyPassword == "" {
+		return key, nil
+	}
+	password, err := ReadPassword(c.KeyPassword)
+	if err != nil {
+		return nil, err
+	}
+	return helpers.EncryptPrivateKeyPEM(key, password, c.KeyCipher)
+}
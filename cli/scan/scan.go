@@ -3,26 +3,34 @@ package scan
 import (
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sync"
+	"time"
 
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/certdb/dbconf"
+	certsql "github.com/cloudflare/cfssl/certdb/sql"
 	"github.com/cloudflare/cfssl/cli"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/scan"
+	"github.com/cloudflare/cfssl/starttls"
 )
 
 var scanUsageText = `cfssl scan -- scan a host for issues
 Usage of scan:
-        cfssl scan [-family regexp] [-scanner regexp] [-timeout duration] [-ip IPAddr] [-num-workers num] [-max-hosts num] [-csv hosts.csv] HOST+
+        cfssl scan [-family regexp] [-scanner regexp] [-timeout duration] [-ip IPAddr] [-starttls protocol] [-num-workers num] [-max-hosts num] [-csv hosts.csv] [-aia-cache dir] [-db-config db-config [-rescan interval]] HOST+
+        cfssl scan -diff -db-config db-config [-csv hosts.csv] HOST+
         cfssl scan -list
 
 Arguments:
-        HOST:    Host(s) to scan (including port)
+        HOST:    Host(s) to scan (including port), optionally with a STARTTLS
+                 protocol as the scheme, e.g. smtp://mail.example.com:587
 Flags:
 `
-var scanFlags = []string{"list", "family", "scanner", "timeout", "ip", "ca-bundle", "num-workers", "csv", "max-hosts"}
+var scanFlags = []string{"list", "family", "scanner", "timeout", "ip", "starttls", "ca-bundle", "num-workers", "csv", "max-hosts", "db-config", "rescan", "diff", "aia-cache", "aia-cache-ttl"}
 
 func printJSON(v interface{}) {
 	b, err := json.MarshalIndent(v, "", "  ")
@@ -35,12 +43,14 @@ func printJSON(v interface{}) {
 type context struct {
 	sync.WaitGroup
 	c     cli.Config
+	db    certdb.Accessor
 	hosts chan string
 }
 
-func newContext(c cli.Config, numWorkers int) *context {
+func newContext(c cli.Config, db certdb.Accessor, numWorkers int) *context {
 	ctx := &context{
 		c:     c,
+		db:    db,
 		hosts: make(chan string, numWorkers),
 	}
 	ctx.Add(numWorkers)
@@ -53,12 +63,19 @@ func newContext(c cli.Config, numWorkers int) *context {
 func (ctx *context) runWorker() {
 	for host := range ctx.hosts {
 		fmt.Printf("Scanning %s...\n", host)
+		scannedAt := time.Now()
 		results, err := scan.Default.RunScans(host, ctx.c.IP, ctx.c.Family, ctx.c.Scanner, ctx.c.Timeout)
 		fmt.Printf("=== %s ===\n", host)
 		if err != nil {
 			log.Error(err)
-		} else {
-			printJSON(results)
+			continue
+		}
+		printJSON(results)
+
+		if ctx.db != nil {
+			if err = scan.SaveRun(ctx.db, host, scannedAt, results); err != nil {
+				log.Errorf("failed to record scan of %s: %v", host, err)
+			}
 		}
 	}
 	ctx.Done()
@@ -75,7 +92,9 @@ func parseCSV(hosts []string, csvFile string, maxHosts int) ([]string, error) {
 	for err == nil && len(hosts) < maxHosts {
 		var record []string
 		record, err = r.Read()
-		hosts = append(hosts, record[len(record)-1])
+		if err == nil && len(record) > 0 {
+			hosts = append(hosts, record[len(record)-1])
+		}
 	}
 	if err == io.EOF {
 		err = nil
@@ -84,39 +103,107 @@ func parseCSV(hosts []string, csvFile string, maxHosts int) ([]string, error) {
 	return hosts, err
 }
 
+// hostList returns the hosts given as arguments or, if there is room
+// left, read from the CSV file. Hosts without a scheme are given the
+// -starttls protocol, if any.
+func hostList(args []string, c cli.Config) (hosts []string, err error) {
+	if len(args) >= c.MaxHosts {
+		log.Warningf("Only scanning max-hosts=%d out of %d args given", c.MaxHosts, len(args))
+		hosts = args[:c.MaxHosts]
+	} else if c.CSVFile != "" {
+		if hosts, err = parseCSV(args, c.CSVFile, c.MaxHosts); err != nil {
+			return nil, err
+		}
+	} else {
+		hosts = args
+	}
+
+	if c.StartTLS != "" {
+		joined := make([]string, len(hosts))
+		for i, host := range hosts {
+			joined[i] = starttls.Join(c.StartTLS, host)
+		}
+		hosts = joined
+	}
+	return hosts, nil
+}
+
+// scanHosts scans each host once.
+func scanHosts(hosts []string, c cli.Config, db certdb.Accessor) (err error) {
+	ctx := newContext(c, db, c.NumWorkers)
+	// Execute for each HOST argument given
+	for len(hosts) > 0 {
+		var host string
+		host, hosts, err = cli.PopFirstArgument(hosts)
+		if err != nil {
+			break
+		}
+
+		ctx.hosts <- host
+	}
+	close(ctx.hosts)
+	ctx.Wait()
+	return
+}
+
 func scanMain(args []string, c cli.Config) (err error) {
 	if c.List {
 		printJSON(scan.Default)
-	} else {
-		if err = scan.LoadRootCAs(c.CABundleFile); err != nil {
-			return
+		return
+	}
+
+	var db certdb.Accessor
+	if c.DBConfigFile != "" {
+		sqlDB, err := dbconf.DBFromConfig(c.DBConfigFile)
+		if err != nil {
+			return err
 		}
+		db = certsql.NewAccessor(sqlDB)
+	}
 
-		if len(args) >= c.MaxHosts {
-			log.Warningf("Only scanning max-hosts=%d out of %d args given", c.MaxHosts, len(args))
-			args = args[:c.MaxHosts]
-		} else if c.CSVFile != "" {
-			args, err = parseCSV(args, c.CSVFile, c.MaxHosts)
+	if c.Diff {
+		if db == nil {
+			return errors.New("need a certificate database to diff scans; use -db-config")
+		}
+		hosts, err := hostList(args, c)
+		if err != nil {
+			return err
+		}
+		for _, host := range hosts {
+			diff, err := scan.LatestDiff(db, host)
 			if err != nil {
-				return
+				log.Error(err)
+				continue
 			}
+			fmt.Printf("=== %s ===\n", host)
+			printJSON(diff)
 		}
+		return nil
+	}
 
-		ctx := newContext(c, c.NumWorkers)
-		// Execute for each HOST argument given
-		for len(args) > 0 {
-			var host string
-			host, args, err = cli.PopFirstArgument(args)
-			if err != nil {
-				return
-			}
+	if c.Rescan > 0 && db == nil {
+		return errors.New("need a certificate database to record scheduled re-scans; use -db-config")
+	}
 
-			ctx.hosts <- host
+	if err = scan.LoadRootCAs(c.CABundleFile); err != nil {
+		return
+	}
+	cli.SetAIACache(&c)
+
+	for {
+		// The host list is read again before each re-scan, so
+		// hosts can be added to or removed from the CSV file.
+		var hosts []string
+		if hosts, err = hostList(args, c); err != nil {
+			return
 		}
-		close(ctx.hosts)
-		ctx.Wait()
+		if err = scanHosts(hosts, c, db); err != nil || c.Rescan <= 0 {
+			return
+		}
+
+		log.Infof("next scan in %v", c.Rescan)
+		time.Sleep(c.Rescan)
 	}
-	return
 }
 
 // Command assembles the definition of Command 'scan'
@@ -2,6 +2,7 @@
 package serve
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -22,10 +23,14 @@ import (
 	"github.com/cloudflare/cfssl/api/generator"
 	"github.com/cloudflare/cfssl/api/info"
 	"github.com/cloudflare/cfssl/api/initca"
+	apilint "github.com/cloudflare/cfssl/api/lint"
 	apiocsp "github.com/cloudflare/cfssl/api/ocsp"
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/api/revoke"
 	"github.com/cloudflare/cfssl/api/scan"
 	"github.com/cloudflare/cfssl/api/signhandler"
+	apiwhitelist "github.com/cloudflare/cfssl/api/whitelist"
+	"github.com/cloudflare/cfssl/auth"
 	"github.com/cloudflare/cfssl/bundler"
 	"github.com/cloudflare/cfssl/certdb/dbconf"
 	certsql "github.com/cloudflare/cfssl/certdb/sql"
@@ -35,8 +40,11 @@ import (
 	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/ocsp"
+	"github.com/cloudflare/cfssl/scep"
 	"github.com/cloudflare/cfssl/signer"
 	"github.com/cloudflare/cfssl/ubiquity"
+	"github.com/cloudflare/cfssl/whitelist"
+	"github.com/cloudflare/cfssl/whitelist/admin"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -51,7 +59,8 @@ Usage of serve:
                     [-responder cert] [-responder-key key] [-tls-cert cert] [-tls-key key] \
                     [-mutual-tls-ca ca] [-mutual-tls-cn regex] \
                     [-tls-remote-ca ca] [-mutual-tls-client-cert cert] [-mutual-tls-client-key key] \
-                    [-db-config db-config]
+                    [-db-config db-config] [-scep-challenge challenge] [-chain-policy file] \
+                    [-aia-cache dir] [-aia-cache-ttl duration] [-whitelist store] [-authkey key]
 
 Flags:
 `
@@ -59,15 +68,29 @@ Flags:
 // Flags used by 'cfssl serve'
 var serverFlags = []string{"address", "port", "ca", "ca-key", "ca-bundle", "int-bundle", "int-dir", "metadata",
 	"remote", "config", "responder", "responder-key", "tls-key", "tls-cert", "mutual-tls-ca", "mutual-tls-cn",
-	"tls-remote-ca", "mutual-tls-client-cert", "mutual-tls-client-key", "db-config"}
+	"tls-remote-ca", "mutual-tls-client-cert", "mutual-tls-client-key", "db-config", "scep-challenge", "chain-policy", "aia-cache", "aia-cache-ttl",
+	"whitelist", "authkey"}
 
 var (
 	conf       cli.Config
 	s          signer.Signer
 	ocspSigner ocsp.Signer
 	db         *sqlx.DB
+	whitelists *admin.Manager
+	acl        whitelist.NetACL
 )
 
+// serveWhitelist names the whitelist of the clients permitted by
+// 'cfssl serve' when -whitelist is given, and adminEndpoint the endpoint
+// administering it.
+const (
+	serveWhitelist = "serve"
+	adminEndpoint  = "whitelist"
+)
+
+// serveWhitelistSeed is the whitelist before it is first changed.
+var serveWhitelistSeed = []string{"127.0.0.1", "::1"}
+
 // V1APIPrefix is the prefix of all CFSSL V1 API Endpoints.
 var V1APIPrefix = "/api/v1/cfssl/"
 
@@ -114,6 +137,9 @@ var staticBox = &httpBox{
 
 var errBadSigner = errors.New("signer not initialized")
 var errNoCertDBConfigured = errors.New("cert db not configured (missing -db-config)")
+var errNoSCEPChallenge = errors.New("SCEP challenge not configured (missing -scep-challenge)")
+var errNoWhitelist = errors.New("whitelist not configured (missing -whitelist)")
+var errNoAuthKey = errors.New("authentication key not configured (missing -authkey)")
 
 var endpoints = map[string]func() (http.Handler, error){
 	"sign": func() (http.Handler, error) {
@@ -197,7 +223,11 @@ var endpoints = map[string]func() (http.Handler, error){
 	},
 
 	"bundle": func() (http.Handler, error) {
-		return bundle.NewHandler(conf.CABundleFile, conf.IntBundleFile)
+		opts, err := cli.BundlerOptionsFromConfig(&conf)
+		if err != nil {
+			return nil, err
+		}
+		return bundle.NewHandler(conf.CABundleFile, conf.IntBundleFile, opts...)
 	},
 
 	"newkey": func() (http.Handler, error) {
@@ -209,17 +239,35 @@ var endpoints = map[string]func() (http.Handler, error){
 	},
 
 	"scan": func() (http.Handler, error) {
-		return scan.NewHandler(conf.CABundleFile)
+		if db == nil {
+			return scan.NewHandler(conf.CABundleFile)
+		}
+		return scan.NewHandlerWithDB(conf.CABundleFile, certsql.NewAccessor(db))
+	},
+
+	"scandiff": func() (http.Handler, error) {
+		if db == nil {
+			return nil, errNoCertDBConfigured
+		}
+		return scan.NewDiffHandler(certsql.NewAccessor(db)), nil
 	},
 
 	"scaninfo": func() (http.Handler, error) {
 		return scan.NewInfoHandler(), nil
 	},
 
+	"openapi": func() (http.Handler, error) {
+		return openapi.NewHandler(), nil
+	},
+
 	"certinfo": func() (http.Handler, error) {
 		return certinfo.NewHandler(), nil
 	},
 
+	"lint": func() (http.Handler, error) {
+		return apilint.NewHandler(), nil
+	},
+
 	"ocspsign": func() (http.Handler, error) {
 		if ocspSigner == nil {
 			return nil, errBadSigner
@@ -234,6 +282,34 @@ var endpoints = map[string]func() (http.Handler, error){
 		return revoke.NewHandler(certsql.NewAccessor(db)), nil
 	},
 
+	"scep": func() (http.Handler, error) {
+		if s == nil {
+			return nil, errBadSigner
+		}
+
+		if conf.SCEPChallenge == "" {
+			return nil, errNoSCEPChallenge
+		}
+
+		return scep.NewResponderFromFile(s, conf.CAFile, conf.CAKeyFile, conf.SCEPChallenge)
+	},
+
+	adminEndpoint: func() (http.Handler, error) {
+		if whitelists == nil {
+			return nil, errNoWhitelist
+		}
+
+		if conf.AuthKey == "" {
+			return nil, errNoAuthKey
+		}
+
+		provider, err := auth.New(conf.AuthKey, nil)
+		if err != nil {
+			return nil, err
+		}
+		return apiwhitelist.NewHandler(whitelists, provider), nil
+	},
+
 	"/": func() (http.Handler, error) {
 		if err := staticBox.findStaticBox(); err != nil {
 			return nil, err
@@ -247,7 +323,14 @@ var endpoints = map[string]func() (http.Handler, error){
 func registerHandlers() {
 	for path, getHandler := range endpoints {
 		log.Debugf("getHandler for %s", path)
-		if handler, err := getHandler(); err != nil {
+		handler, err := getHandler()
+		if err == nil && acl != nil && path != adminEndpoint {
+			// The administration endpoint is authenticated
+			// instead, so that clients can't lock themselves
+			// out.
+			handler, err = whitelist.NewHandler(handler, nil, acl)
+		}
+		if err != nil {
 			log.Warningf("endpoint '%s' is disabled: %v", path, err)
 		} else {
 			if path, handler, err = wrapHandler(path, handler, err); err != nil {
@@ -271,6 +354,7 @@ func serverMain(args []string, c cli.Config) error {
 	}
 
 	bundler.IntermediateStash = conf.IntDir
+	cli.SetAIACache(&conf)
 	var err error
 
 	if err = ubiquity.LoadPlatforms(conf.Metadata); err != nil {
@@ -284,6 +368,18 @@ func serverMain(args []string, c cli.Config) error {
 		}
 	}
 
+	if c.Whitelist != "" {
+		store, err := admin.OpenStore(c.Whitelist, db)
+		if err != nil {
+			return err
+		}
+		whitelists = admin.NewManager(store)
+		if acl, err = whitelists.Register(serveWhitelist, serveWhitelistSeed); err != nil {
+			return err
+		}
+		go whitelists.AutoReload(context.Background(), admin.DefaultReloadInterval)
+	}
+
 	log.Info("Initializing signer")
 
 	if s, err = sign.SignerFromConfigAndDB(c, db); err != nil {
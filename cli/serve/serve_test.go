@@ -4,8 +4,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/cloudflare/cfssl/api/openapi"
 	"github.com/cloudflare/cfssl/cli"
 )
 
@@ -41,6 +43,9 @@ func TestServe(t *testing.T) {
 	expected[v1APIPath("crl")] = http.StatusNotFound
 	expected[v1APIPath("gencrl")] = http.StatusNotFound
 	expected[v1APIPath("revoke")] = http.StatusNotFound
+	expected[v1APIPath("scep")] = http.StatusNotFound
+	expected[v1APIPath("scandiff")] = http.StatusNotFound
+	expected[v1APIPath("whitelist")] = http.StatusNotFound
 
 	// Enabled endpoints should return '405 Method Not Allowed'
 	expected[v1APIPath("init_ca")] = http.StatusMethodNotAllowed
@@ -48,6 +53,9 @@ func TestServe(t *testing.T) {
 	expected[v1APIPath("bundle")] = http.StatusMethodNotAllowed
 	expected[v1APIPath("certinfo")] = http.StatusMethodNotAllowed
 
+	// Endpoints answering GET requests should return '200 OK'
+	expected[v1APIPath("lint")] = http.StatusOK
+
 	// POST-only endpoints should return '400 Bad Request'
 	expected[v1APIPath("scan")] = http.StatusBadRequest
 
@@ -74,3 +82,22 @@ func TestServe(t *testing.T) {
 		t.Fatalf("There should be an error for argument")
 	}
 }
+
+// TestSpecCoversEndpoints checks that the OpenAPI document describes
+// every endpoint served, and no others.
+func TestSpecCoversEndpoints(t *testing.T) {
+	paths := openapi.Spec().Paths
+	for endpoint := range endpoints {
+		if endpoint == "/" {
+			continue
+		}
+		if paths[v1APIPath(endpoint)] == nil {
+			t.Errorf("endpoint %s isn't described", endpoint)
+		}
+	}
+	for path := range paths {
+		if _, ok := endpoints[strings.TrimPrefix(path, openapi.Prefix)]; !ok {
+			t.Errorf("%s isn't served", path)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package whitelist implements the whitelist command.
+package whitelist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/cloudflare/cfssl/api/client"
+	apiwhitelist "github.com/cloudflare/cfssl/api/whitelist"
+	"github.com/cloudflare/cfssl/auth"
+	"github.com/cloudflare/cfssl/cli"
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+var whitelistUsageText = `cfssl whitelist -- administer the IP whitelists of a remote server
+
+Usage:
+
+Add or remove IP addresses or networks in a whitelist:
+cfssl whitelist -remote remote_host -authkey key add|remove whitelist entry...
+
+List the entries of a whitelist, or of all of them:
+cfssl whitelist -remote remote_host -authkey key list [whitelist]
+
+Show who changed a whitelist, and when:
+cfssl whitelist -remote remote_host -authkey key history whitelist
+
+The whitelist of 'cfssl serve' is named serve, and those of multirootca
+are named after the labels of its roots.
+
+Flags:
+`
+
+var whitelistFlags = []string{"remote", "authkey", "tls-remote-ca", "mutual-tls-client-cert", "mutual-tls-client-key"}
+
+// parseArgs returns the request the arguments describe.
+func parseArgs(args []string) (*apiwhitelist.Request, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no action given; please refer to the usage by flag -h")
+	}
+
+	req := &apiwhitelist.Request{Action: args[0]}
+	args = args[1:]
+	switch req.Action {
+	case apiwhitelist.ActionAdd, apiwhitelist.ActionRemove:
+		if len(args) < 2 {
+			return nil, errors.New("a whitelist and at least one entry are required")
+		}
+		req.Whitelist, req.Entries = args[0], args[1:]
+	case apiwhitelist.ActionList:
+		if len(args) > 1 {
+			return nil, errors.New("at most one whitelist may be listed")
+		}
+		if len(args) == 1 {
+			req.Whitelist = args[0]
+		}
+	case apiwhitelist.ActionHistory:
+		if len(args) != 1 {
+			return nil, errors.New("exactly one whitelist is required")
+		}
+		req.Whitelist = args[0]
+	default:
+		return nil, fmt.Errorf("unknown action %s", req.Action)
+	}
+	return req, nil
+}
+
+func whitelistMain(args []string, c cli.Config) error {
+	if c.Remote == "" {
+		return errors.New("no remote server given (missing -remote)")
+	}
+	if c.AuthKey == "" {
+		return errors.New("no authentication key given (missing -authkey)")
+	}
+
+	req, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+
+	provider, err := auth.New(c.AuthKey, nil)
+	if err != nil {
+		return err
+	}
+	cert, err := helpers.LoadClientCertificate(c.MutualTLSCertFile, c.MutualTLSKeyFile)
+	if err != nil {
+		return err
+	}
+	remoteCAs, err := helpers.LoadPEMCertPool(c.TLSRemoteCAs)
+	if err != nil {
+		return err
+	}
+	remote := client.NewServerTLS(c.Remote, helpers.CreateTLSConfig(remoteCAs, cert))
+	if remote == nil {
+		return errors.New("invalid remote server " + c.Remote)
+	}
+	apiClient, err := client.NewClient(remote, provider)
+	if err != nil {
+		return err
+	}
+
+	result, err := apiClient.Whitelist(context.Background(), req)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// Command assembles the definition of Command 'whitelist'
+var Command = &cli.Command{
+	UsageText: whitelistUsageText,
+	Flags:     whitelistFlags,
+	Main:      whitelistMain,
+}
@@ -0,0 +1,76 @@
+package whitelist
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	apiwhitelist "github.com/cloudflare/cfssl/api/whitelist"
+	"github.com/cloudflare/cfssl/auth"
+	"github.com/cloudflare/cfssl/cli"
+	"github.com/cloudflare/cfssl/whitelist/admin"
+)
+
+const testKey = "0123456789ABCDEF0123456789ABCDEF"
+
+func TestParseArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"replace", "serve"},
+		{"add", "serve"},
+		{"list", "serve", "api"},
+		{"history"},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Fatalf("%v should be rejected", args)
+		}
+	}
+
+	req, err := parseArgs([]string{"remove", "serve", "10.0.0.1", "10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Whitelist != "serve" || len(req.Entries) != 2 {
+		t.Fatalf("unexpected request %+v", req)
+	}
+}
+
+func TestWhitelistMain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "whitelist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := admin.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := admin.NewManager(store)
+	if _, err = m.Register("serve", nil); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := auth.New(testKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(apiwhitelist.NewHandler(m, provider))
+	defer ts.Close()
+
+	c := cli.Config{Remote: ts.URL, AuthKey: testKey}
+	if err = whitelistMain([]string{"add", "serve", "10.0.0.1"}, c); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := m.Entries("serve"); len(entries) != 1 || entries[0] != "10.0.0.1/32" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+
+	if err = whitelistMain([]string{"add", "web", "10.0.0.1"}, c); err == nil {
+		t.Fatal("expected failure for an unknown whitelist")
+	}
+	c.AuthKey = "00000000000000000000000000000000"
+	if err = whitelistMain([]string{"list"}, c); err == nil {
+		t.Fatal("expected failure with the wrong key")
+	}
+}
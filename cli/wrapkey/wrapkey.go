@@ -0,0 +1,81 @@
+// Package wrapkey implements the wrapkey command.
+package wrapkey
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cloudflare/cfssl/cli"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/keysource"
+)
+
+// Usage text of 'cfssl wrapkey'
+var wrapkeyUsageText = `cfssl wrapkey -- encrypts a private key for storage at rest with a key source
+
+Usage of wrapkey:
+        cfssl wrapkey SCHEME KEYFILE
+
+SCHEME is the key source that will unwrap the key, such as wrapfile or
+kmsfile. The key source is configured from the environment: wrapfile
+reads the wrapping key (or its public key) named by CFSSL_WRAP_KEY,
+and kmsfile uses CFSSL_KMS_URL, CFSSL_KMS_KEY_ID, CFSSL_KMS_TOKEN and
+CFSSL_KMS_CA. The wrapped key is written to standard output.
+
+Arguments:
+        SCHEME:      the key source scheme
+        KEYFILE:     PEM file for the private key, use '-' for reading PEM from stdin.
+
+Flags:
+`
+
+// Flags of 'cfssl wrapkey'
+var wrapkeyFlags = []string{}
+
+// wrapkeyMain is the main CLI of key wrapping functionality.
+func wrapkeyMain(args []string, c cli.Config) error {
+	scheme, args, err := cli.PopFirstArgument(args)
+	if err != nil {
+		return err
+	}
+
+	keyFile, args, err := cli.PopFirstArgument(args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		return errors.New("only one key file can be wrapped at a time")
+	}
+
+	key, err := helpers.ReadBytes(keyFile)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the key can be used once it is unwrapped.
+	if _, err = keysource.ParsePrivateKey(key); err != nil {
+		return err
+	}
+
+	src, err := keysource.Get(scheme, nil)
+	if err != nil {
+		return err
+	}
+
+	wrapper, ok := src.(keysource.Wrapper)
+	if !ok {
+		return fmt.Errorf("the %s key source cannot wrap keys", scheme)
+	}
+
+	wrapped, err := wrapper.Wrap(key)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(wrapped))
+	return nil
+}
+
+// Command assembles the definition of Command 'wrapkey'
+var Command = &cli.Command{UsageText: wrapkeyUsageText, Flags: wrapkeyFlags, Main: wrapkeyMain}
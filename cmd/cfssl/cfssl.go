@@ -16,6 +16,10 @@ Usage:
 	gencert  generates a key and a signed certificate
 	gencsr   generates a certificate request
 	selfsign generates a self-signed certificate
+	wrapkey  encrypts a private key for a key source
+	expiry-watch sends notifications for expiring certificates
+	ceremony signs certificates with an offline CA
+	whitelist administers the IP whitelists of a remote server
 
 Use "cfssl [command] -help" to find out more about a command.
 */
@@ -27,13 +31,16 @@ import (
 
 	"github.com/cloudflare/cfssl/cli"
 	"github.com/cloudflare/cfssl/cli/bundle"
+	"github.com/cloudflare/cfssl/cli/ceremony"
 	"github.com/cloudflare/cfssl/cli/certinfo"
 	"github.com/cloudflare/cfssl/cli/crl"
+	"github.com/cloudflare/cfssl/cli/expirywatch"
 	"github.com/cloudflare/cfssl/cli/gencert"
 	"github.com/cloudflare/cfssl/cli/gencrl"
 	"github.com/cloudflare/cfssl/cli/gencsr"
 	"github.com/cloudflare/cfssl/cli/genkey"
 	"github.com/cloudflare/cfssl/cli/info"
+	"github.com/cloudflare/cfssl/cli/lint"
 	"github.com/cloudflare/cfssl/cli/ocspdump"
 	"github.com/cloudflare/cfssl/cli/ocsprefresh"
 	"github.com/cloudflare/cfssl/cli/ocspserve"
@@ -45,6 +52,8 @@ import (
 	"github.com/cloudflare/cfssl/cli/serve"
 	"github.com/cloudflare/cfssl/cli/sign"
 	"github.com/cloudflare/cfssl/cli/version"
+	"github.com/cloudflare/cfssl/cli/whitelist"
+	"github.com/cloudflare/cfssl/cli/wrapkey"
 
 	_ "github.com/go-sql-driver/mysql" // import to support MySQL
 	_ "github.com/lib/pq"              // import to support Postgres
@@ -58,8 +67,10 @@ func main() {
 	// Register commands.
 	cmds := map[string]*cli.Command{
 		"bundle":         bundle.Command,
+		"ceremony":       ceremony.Command,
 		"certinfo":       certinfo.Command,
 		"crl":            crl.Command,
+		"expiry-watch":   expirywatch.Command,
 		"sign":           sign.Command,
 		"serve":          serve.Command,
 		"version":        version.Command,
@@ -74,8 +85,11 @@ func main() {
 		"selfsign":       selfsign.Command,
 		"scan":           scan.Command,
 		"info":           info.Command,
+		"lint":           lint.Command,
 		"print-defaults": printdefaults.Command,
 		"revoke":         revoke.Command,
+		"whitelist":      whitelist.Command,
+		"wrapkey":        wrapkey.Command,
 	}
 
 	// If the CLI returns an error, exit with an appropriate status
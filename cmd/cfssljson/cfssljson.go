@@ -3,6 +3,8 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
 	"encoding/base64"
 	"encoding/json"
 	"flag"
@@ -11,6 +13,9 @@ import (
 	"os"
 
 	"github.com/cloudflare/cfssl/cli/version"
+	"github.com/cloudflare/cfssl/crypto/pkcs12"
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
+	"github.com/cloudflare/cfssl/helpers"
 )
 
 func readFile(filespec string) ([]byte, error) {
@@ -20,6 +25,14 @@ func readFile(filespec string) ([]byte, error) {
 	return ioutil.ReadFile(filespec)
 }
 
+func readPassword(spec string) ([]byte, error) {
+	password, err := helpers.ReadBytes(spec)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(password, "\r\n"), nil
+}
+
 func writeFile(filespec, contents string, perms os.FileMode) {
 	err := ioutil.WriteFile(filespec, []byte(contents), perms)
 	if err != nil {
@@ -42,6 +55,41 @@ type Response struct {
 	Messages []ResponseMessage      `json:"messages"`
 }
 
+// encodeChain returns the certificate, followed by the rest of the chain,
+// as a degenerate PKCS #7 SignedData or, with the private key if one is
+// given, as a PKCS #12 archive protected by password.  The chain may
+// repeat the certificate, as the bundle endpoint's does.  An encrypted
+// private key is decrypted with keyPassword.
+func encodeChain(format, certPEM, chainPEM, keyPEM, password string, keyPassword []byte) ([]byte, error) {
+	certs, err := helpers.ParseCertificatesPEM([]byte(certPEM))
+	if err != nil {
+		return nil, err
+	}
+	if chainPEM != "" {
+		chain, err := helpers.ParseCertificatesPEM([]byte(chainPEM))
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range chain {
+			if !cert.Equal(certs[0]) {
+				certs = append(certs, cert)
+			}
+		}
+	}
+
+	if format == "p7b" {
+		return pkcs7.DegenerateCertificates(certs)
+	}
+
+	var key crypto.Signer
+	if keyPEM != "" {
+		if key, err = helpers.ParsePrivateKeyPEMWithPassword([]byte(keyPEM), keyPassword); err != nil {
+			return nil, err
+		}
+	}
+	return pkcs12.Encode(key, certs, password)
+}
+
 type outputFile struct {
 	Filename string
 	Contents string
@@ -54,6 +102,10 @@ func main() {
 	inFile := flag.String("f", "-", "JSON input")
 	output := flag.Bool("stdout", false, "output the response instead of saving to a file")
 	printVersion := flag.Bool("version", false, "print version and exit")
+	p7b := flag.Bool("p7b", false, "also write the certificate and its bundle as a PKCS #7 file")
+	p12 := flag.Bool("p12", false, "also write the certificate, its bundle and its key as a PKCS #12 file")
+	p12Password := flag.String("p12-password", "", "password protecting the PKCS #12 file -- accepts '[file:]fname' or 'env:varname'")
+	keyPassword := flag.String("key-password", "", "password decrypting an encrypted key for the PKCS #12 file -- accepts '[file:]fname' or 'env:varname'")
 	flag.Parse()
 
 	if *printVersion {
@@ -183,6 +235,80 @@ func main() {
 		}
 	}
 
+	// The bundle and sign endpoints return the chain already encoded
+	// when asked for the pkcs7 or pkcs12 format.
+	for _, encoded := range []struct{ field, ext string }{{"pkcs7", ".p7b"}, {"pkcs12", ".p12"}} {
+		contents, ok := input[encoded.field].(string)
+		if !ok {
+			continue
+		}
+		der, err := base64.StdEncoding.DecodeString(contents)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse %s: %v\n", encoded.field, err)
+			os.Exit(1)
+		}
+		outs = append(outs, outputFile{
+			Filename: baseName + encoded.ext,
+			Contents: string(der),
+			IsBinary: true,
+			Perms:    0600,
+		})
+	}
+
+	if *p7b || *p12 {
+		if cert == "" {
+			fmt.Fprintf(os.Stderr, "No certificate to encode\n")
+			os.Exit(1)
+		}
+
+		var chain string
+		if contents, ok := input["bundle"].(string); ok {
+			chain = contents
+		} else if result, ok := input["result"].(map[string]interface{}); ok {
+			if bundle, ok := result["bundle"].(map[string]interface{}); ok {
+				chain, _ = bundle["bundle"].(string)
+			}
+		}
+
+		var password string
+		if *p12Password != "" {
+			pw, err := readPassword(*p12Password)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read PKCS #12 password: %v\n", err)
+				os.Exit(1)
+			}
+			password = string(pw)
+		}
+		var keyPW []byte
+		if *keyPassword != "" {
+			if keyPW, err = readPassword(*keyPassword); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read key password: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		var formats []string
+		if *p7b {
+			formats = append(formats, "p7b")
+		}
+		if *p12 {
+			formats = append(formats, "p12")
+		}
+		for _, format := range formats {
+			der, err := encodeChain(format, cert, chain, key, password, keyPW)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to encode %s: %v\n", format, err)
+				os.Exit(1)
+			}
+			outs = append(outs, outputFile{
+				Filename: baseName + "." + format,
+				Contents: string(der),
+				IsBinary: true,
+				Perms:    0600,
+			})
+		}
+	}
+
 	if contents, ok := input["ocspResponse"]; ok {
 		//ocspResponse is base64 encoded
 		resp, err := base64.StdEncoding.DecodeString(contents.(string))
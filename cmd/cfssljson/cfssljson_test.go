@@ -2,6 +2,8 @@ package main
 
 import (
 	"testing"
+
+	"github.com/cloudflare/cfssl/helpers"
 )
 
 func TestReadFile(t *testing.T) {
@@ -18,3 +20,68 @@ func TestReadFile(t *testing.T) {
 		t.Fatal("File not read correctly")
 	}
 }
+
+func TestEncodeChain(t *testing.T) {
+	certPEM, err := readFile("../../testdata/server.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := readFile("../../testdata/server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundlePEM, err := readFile("../../testdata/gd_bundle.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := encodeChain("p7b", string(certPEM), string(certPEM)+string(bundlePEM), "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, _, err := helpers.ParseCertificatesDER(der, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 4 {
+		t.Fatalf("expected the certificate and the 3 CA certificates, got %d", len(certs))
+	}
+
+	der, err = encodeChain("p12", string(certPEM), "", string(keyPEM), "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, key, err := helpers.ParseCertificatesDER(der, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == nil || len(certs) != 1 {
+		t.Fatal("PKCS #12 archive does not hold the key and certificate")
+	}
+}
+
+func TestEncodeChainEncryptedKey(t *testing.T) {
+	certPEM, err := readFile("../../testdata/server.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := readFile("../../testdata/server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encPEM, err := helpers.EncryptPrivateKeyPEM(keyPEM, []byte("key password"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = encodeChain("p12", string(certPEM), "", string(encPEM), "password", nil); err == nil {
+		t.Fatal("expected an encrypted key without its password to be rejected")
+	}
+	der, err := encodeChain("p12", string(certPEM), "", string(encPEM), "password", []byte("key password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, key, err := helpers.ParseCertificatesDER(der, "password"); err != nil || key == nil {
+		t.Fatalf("PKCS #12 archive does not hold the decrypted key: %v", err)
+	}
+}
@@ -1,20 +1,25 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"errors"
 	"flag"
+	"fmt"
 	"net"
 	"net/http"
 
 	"github.com/cloudflare/cfssl/api/info"
+	apiwhitelist "github.com/cloudflare/cfssl/api/whitelist"
+	"github.com/cloudflare/cfssl/auth"
 	"github.com/cloudflare/cfssl/certdb/sql"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/multiroot/config"
 	"github.com/cloudflare/cfssl/signer"
 	"github.com/cloudflare/cfssl/signer/local"
 	"github.com/cloudflare/cfssl/whitelist"
+	"github.com/cloudflare/cfssl/whitelist/admin"
 
 	_ "github.com/go-sql-driver/mysql" // import to support MySQL
 	_ "github.com/lib/pq"              // import to support Postgres
@@ -45,12 +50,42 @@ var (
 	whitelists   = map[string]whitelist.NetACL{}
 )
 
+// manageWhitelists puts the network whitelists of the roots under the
+// management of m, seeding them with the networks in the roots file.
+// Roots without a whitelist permit every network, and are left alone;
+// only the *whitelist.BasicNet whitelists the roots file describes can
+// be managed, and any other is an error.
+func manageWhitelists(m *admin.Manager, roots map[string]*config.Root) error {
+	for label, root := range roots {
+		if root.ACL == nil {
+			continue
+		}
+		nets, ok := root.ACL.(*whitelist.BasicNet)
+		if !ok {
+			return fmt.Errorf("root %s: a whitelist of type %T can't be administered", label, root.ACL)
+		}
+
+		var seed []string
+		for _, n := range nets.Networks() {
+			seed = append(seed, n.String())
+		}
+		acl, err := m.Register(label, seed)
+		if err != nil {
+			return err
+		}
+		whitelists[label] = acl
+	}
+	return nil
+}
+
 func main() {
 	flagAddr := flag.String("a", ":8888", "listening address")
 	flagRootFile := flag.String("roots", "", "configuration file specifying root keys")
 	flagDefaultLabel := flag.String("l", "", "specify a default label")
 	flagEndpointCert := flag.String("tls-cert", "", "server certificate")
 	flagEndpointKey := flag.String("tls-key", "", "server private key")
+	flagWhitelist := flag.String("whitelist", "", "keep administered whitelists in this directory, or 'db:' and a db configuration file")
+	flagAuthKey := flag.String("authkey", "", "key authenticating requests to administer the whitelists")
 	flag.Parse()
 
 	if *flagRootFile == "" {
@@ -74,6 +109,19 @@ func main() {
 		log.Info("loaded signer ", label)
 	}
 
+	var manager *admin.Manager
+	if *flagWhitelist != "" {
+		store, err := admin.OpenStore(*flagWhitelist, nil)
+		if err != nil {
+			log.Fatalf("failed to open the whitelist store: %v", err)
+		}
+		manager = admin.NewManager(store)
+		if err = manageWhitelists(manager, roots); err != nil {
+			log.Fatalf("%v", err)
+		}
+		go manager.AutoReload(context.Background(), admin.DefaultReloadInterval)
+	}
+
 	defaultLabel = *flagDefaultLabel
 	initStats()
 
@@ -94,6 +142,14 @@ func main() {
 	http.Handle("/api/v1/cfssl/info", infoHandler)
 	http.Handle("/api/v1/cfssl/metrics", metrics)
 
+	if manager != nil && *flagAuthKey != "" {
+		provider, err := auth.New(*flagAuthKey, nil)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		http.Handle("/api/v1/cfssl/whitelist", apiwhitelist.NewHandler(manager, provider))
+	}
+
 	if *flagEndpointCert == "" && *flagEndpointKey == "" {
 		log.Info("Now listening on ", *flagAddr)
 		log.Fatal(http.ListenAndServe(*flagAddr, nil))
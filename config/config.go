@@ -17,6 +17,7 @@ import (
 	"github.com/cloudflare/cfssl/auth"
 	cferr "github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/lint"
 	"github.com/cloudflare/cfssl/log"
 	ocspConfig "github.com/cloudflare/cfssl/ocsp/config"
 )
@@ -82,6 +83,8 @@ type SigningProfile struct {
 	BackdateString      string       `json:"backdate"`
 	AuthKeyName         string       `json:"auth_key"`
 	RemoteName          string       `json:"remote"`
+	RemoteStrategy      string       `json:"remote_strategy"`
+	RemoteHealthString  string       `json:"remote_health_check"`
 	NotBefore           time.Time    `json:"not_before"`
 	NotAfter            time.Time    `json:"not_after"`
 	NameWhitelistString string       `json:"name_whitelist"`
@@ -89,6 +92,8 @@ type SigningProfile struct {
 	CTLogServers        []string     `json:"ct_log_servers"`
 	AllowedExtensions   []OID        `json:"allowed_extensions"`
 	CertStore           string       `json:"cert_store"`
+	Lint                string       `json:"lint"`
+	IgnoredLints        []string     `json:"ignored_lints"`
 
 	Policies                    []CertificatePolicy
 	Expiry                      time.Duration
@@ -96,6 +101,7 @@ type SigningProfile struct {
 	Provider                    auth.Provider
 	RemoteProvider              auth.Provider
 	RemoteServer                string
+	RemoteHealthInterval        time.Duration
 	RemoteCAs                   *x509.CertPool
 	ClientCert                  *tls.Certificate
 	CSRWhitelist                *CSRWhitelist
@@ -191,6 +197,19 @@ func (p *SigningProfile) populate(cfg *Config) error {
 			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, err)
 		}
 
+		switch p.Lint {
+		case "", LintWarn, LintBlock:
+		default:
+			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+				fmt.Errorf("invalid lint setting %q", p.Lint))
+		}
+		for _, name := range p.IgnoredLints {
+			if _, ok := lint.Lookup(name); !ok {
+				return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+					fmt.Errorf("unknown lint %q", name))
+			}
+		}
+
 		if len(p.Policies) > 0 {
 			for _, policy := range p.Policies {
 				for _, qualifier := range policy.Qualifiers {
@@ -227,6 +246,20 @@ func (p *SigningProfile) populate(cfg *Config) error {
 		}
 	}
 
+	// These are the strategies of api/client.StrategyFromString.
+	switch p.RemoteStrategy {
+	case "", "ordered_list", "round_robin", "least_latency", "consistent_hash":
+	default:
+		return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+			fmt.Errorf("invalid remote strategy %q", p.RemoteStrategy))
+	}
+	if p.RemoteHealthString != "" {
+		p.RemoteHealthInterval, err = time.ParseDuration(p.RemoteHealthString)
+		if err != nil {
+			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, err)
+		}
+	}
+
 	if p.AuthKeyName != "" {
 		log.Debug("match auth key in profile to auth_keys section")
 		if key, ok := cfg.AuthKeys[p.AuthKeyName]; ok == true {
@@ -502,6 +535,14 @@ func (p *Signing) warnSkippedSettings() {
 	}
 }
 
+// Settings for SigningProfile.Lint. With LintWarn, lint findings on
+// the certificate to be signed are logged; with LintBlock, errors
+// also stop it being signed.
+const (
+	LintWarn  = "warn"
+	LintBlock = "block"
+)
+
 // Signing codifies the signature configuration policy for a CA.
 type Signing struct {
 	Profiles map[string]*SigningProfile `json:"profiles"`
@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -535,3 +536,66 @@ func TestValidCAConstraint(t *testing.T) {
 		}
 	}
 }
+
+func TestLintConfig(t *testing.T) {
+	cfg, err := LoadConfig([]byte(`{
+		"signing": {
+			"default": {
+				"usages": ["digital signature", "server auth"],
+				"expiry": "2160h",
+				"lint": "block",
+				"ignored_lints": ["w_sub_cert_aia_ocsp_missing"]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Signing.Default.Lint != LintBlock || len(cfg.Signing.Default.IgnoredLints) != 1 {
+		t.Fatalf("unexpected lint settings %+v", cfg.Signing.Default)
+	}
+
+	for _, profile := range []string{
+		`"lint": "sometimes"`,
+		`"lint": "warn", "ignored_lints": ["e_no_such_lint"]`,
+	} {
+		_, err = LoadConfig([]byte(`{"signing": {"default": {"usages": ["digital signature"], "expiry": "8000h", ` + profile + `}}}`))
+		if err == nil {
+			t.Fatalf("expected %s to be rejected", profile)
+		}
+	}
+}
+
+func TestRemoteStrategy(t *testing.T) {
+	strategyConfig := strings.Replace(validMixedConfig, `"remote": "localhost"`,
+		`"remote": "localhost", "remote_strategy": "%s"`, 1)
+
+	c, err := LoadConfig([]byte(fmt.Sprintf(strategyConfig, "round_robin")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Signing.Profiles["CA"].RemoteStrategy != "round_robin" {
+		t.Fatal("remote strategy wasn't loaded")
+	}
+
+	if _, err = LoadConfig([]byte(fmt.Sprintf(strategyConfig, "random"))); err == nil {
+		t.Fatal("invalid remote strategy was accepted")
+	}
+}
+
+func TestRemoteHealthCheck(t *testing.T) {
+	healthConfig := strings.Replace(validMixedConfig, `"remote": "localhost"`,
+		`"remote": "localhost", "remote_health_check": "%s"`, 1)
+
+	c, err := LoadConfig([]byte(fmt.Sprintf(healthConfig, "30s")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Signing.Profiles["CA"].RemoteHealthInterval != 30*time.Second {
+		t.Fatal("remote health check interval wasn't loaded")
+	}
+
+	if _, err = LoadConfig([]byte(fmt.Sprintf(healthConfig, "often"))); err == nil {
+		t.Fatal("invalid remote health check interval was accepted")
+	}
+}
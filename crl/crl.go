@@ -7,7 +7,6 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"math/big"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -58,14 +57,8 @@ func NewCRLFromFile(serialList, issuerFile, keyFile []byte, expiryTime string) (
 		revokedCerts = append(revokedCerts, tempCert)
 	}
 
-	strPassword := os.Getenv("CFSSL_CA_PK_PASSWORD")
-	password := []byte(strPassword)
-	if strPassword == "" {
-		password = nil
-	}
-
 	// Parse the key given
-	key, err := helpers.ParsePrivateKeyPEMWithPassword(keyFile, password)
+	key, err := helpers.ParsePrivateKeyPEMFromEnv(keyFile)
 	if err != nil {
 		log.Debug("Malformed private key %v", err)
 		return nil, err
@@ -0,0 +1,353 @@
+// Package pkcs12 encodes private keys and certificates as password
+// protected PKCS #12 archives (RFC 7292), the .p12/.pfx files expected by
+// Windows and Java key stores.  Decoding is left to
+// golang.org/x/crypto/pkcs12, which helpers.ParseCertificatesDER uses.
+//
+// Archives are produced in the most widely supported form: the private
+// key is stored in a pkcs8ShroudedKeyBag and the certificates in an
+// encryptedData content, both encrypted with
+// pbeWithSHAAnd3-KeyTripleDES-CBC, and the whole archive is integrity
+// protected with an HMAC-SHA1 MAC keyed from the same password.
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"unicode/utf16"
+
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
+	cferr "github.com/cloudflare/cfssl/errors"
+	"github.com/cloudflare/cfssl/helpers/derhelpers"
+)
+
+// Iterations is the iteration count used for key derivation, both for
+// encryption and for the MAC.
+const Iterations = 2048
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []attribute `asn1:"set,optional,omitempty"`
+}
+
+type attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// Encode returns the DER encoding of a PKCS #12 archive holding key and
+// certs, protected by password.  When key is not nil, it must be the
+// private key of certs[0], and the two are tied together with a
+// localKeyId attribute; the remaining certificates form its chain.  A nil
+// key produces a certificates-only archive, suitable as a trust store.
+func Encode(key crypto.PrivateKey, certs []*x509.Certificate, password string) ([]byte, error) {
+	bmpPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var localKeyID []byte
+	var keyBag *safeBag
+	if key != nil {
+		if len(certs) == 0 {
+			return nil, cferr.New(cferr.CertificateError, cferr.ReadFailed)
+		}
+		id := sha1.Sum(certs[0].Raw)
+		localKeyID = id[:]
+		if keyBag, err = newKeyBag(key, bmpPassword, localKeyID); err != nil {
+			return nil, err
+		}
+	}
+
+	var certBags []safeBag
+	for i, cert := range certs {
+		var id []byte
+		if i == 0 {
+			id = localKeyID
+		}
+		bag, err := newCertBag(cert, id)
+		if err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, *bag)
+	}
+
+	var authSafe []contentInfo
+	if len(certBags) != 0 {
+		ci, err := encryptedContent(certBags, bmpPassword)
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, *ci)
+	}
+	if keyBag != nil {
+		ci, err := dataContent([]safeBag{*keyBag})
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, *ci)
+	}
+
+	authSafeDER, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	pfx := pfxPdu{Version: 3}
+	if pfx.AuthSafe, err = octetContent(oidDataContentType, authSafeDER); err != nil {
+		return nil, err
+	}
+
+	pfx.MacData.MacSalt = make([]byte, 8)
+	if _, err = io.ReadFull(rand.Reader, pfx.MacData.MacSalt); err != nil {
+		return nil, err
+	}
+	pfx.MacData.Iterations = Iterations
+	pfx.MacData.Mac.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.RawValue{Tag: asn1.TagNull}}
+	macKey := pbkdf(bmpPassword, pfx.MacData.MacSalt, 3, Iterations, sha1.Size)
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(authSafeDER)
+	pfx.MacData.Mac.Digest = mac.Sum(nil)
+
+	return asn1.Marshal(pfx)
+}
+
+func newKeyBag(key crypto.PrivateKey, password, localKeyID []byte) (*safeBag, error) {
+	pkcs8, err := derhelpers.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.NotRSAOrECC, err)
+	}
+	alg, encrypted, err := pbeEncrypt(pkcs8, password)
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: alg, EncryptedData: encrypted})
+	if err != nil {
+		return nil, err
+	}
+	return newSafeBag(oidPKCS8ShroudedKeyBag, der, localKeyID)
+}
+
+func newCertBag(cert *x509.Certificate, localKeyID []byte) (*safeBag, error) {
+	der, err := asn1.Marshal(certBag{ID: oidCertTypeX509, Data: cert.Raw})
+	if err != nil {
+		return nil, err
+	}
+	return newSafeBag(oidCertBag, der, localKeyID)
+}
+
+func newSafeBag(id asn1.ObjectIdentifier, value, localKeyID []byte) (*safeBag, error) {
+	bag := &safeBag{ID: id, Value: explicit(value)}
+	if localKeyID != nil {
+		octets, err := asn1.Marshal(localKeyID)
+		if err != nil {
+			return nil, err
+		}
+		bag.Attributes = []attribute{{
+			ID:    oidLocalKeyID,
+			Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: octets},
+		}}
+	}
+	return bag, nil
+}
+
+// dataContent returns bags as a SafeContents carried in a data
+// ContentInfo.
+func dataContent(bags []safeBag) (*contentInfo, error) {
+	der, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+	ci, err := octetContent(oidDataContentType, der)
+	return &ci, err
+}
+
+// encryptedContent returns bags as a SafeContents carried, encrypted, in
+// an encryptedData ContentInfo.
+func encryptedContent(bags []safeBag, password []byte) (*contentInfo, error) {
+	der, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+	alg, encrypted, err := pbeEncrypt(der, password)
+	if err != nil {
+		return nil, err
+	}
+	ed, err := asn1.Marshal(pkcs7.EncryptedData{
+		EncryptedContentInfo: pkcs7.EncryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: alg,
+			EncryptedContent:           encrypted,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &contentInfo{ContentType: oidEncryptedDataContentType, Content: explicit(ed)}, nil
+}
+
+func octetContent(contentType asn1.ObjectIdentifier, content []byte) (contentInfo, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: contentType, Content: explicit(octets)}, nil
+}
+
+// explicit wraps der in a [0] EXPLICIT tag; encoding/asn1 ignores tags
+// when marshaling a RawValue.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// pbeEncrypt encrypts plaintext with pbeWithSHAAnd3-KeyTripleDES-CBC
+// under a fresh salt.
+func pbeEncrypt(plaintext, password []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	params := pbeParams{Salt: make([]byte, 8), Iterations: Iterations}
+	if _, err := io.ReadFull(rand.Reader, params.Salt); err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	paramsDER, err := asn1.Marshal(params)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	key := pbkdf(password, params.Salt, 1, Iterations, 24)
+	iv := pbkdf(password, params.Salt, 2, Iterations, des.BlockSize)
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	padding := des.BlockSize - len(plaintext)%des.BlockSize
+	ciphertext := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	alg := pkix.AlgorithmIdentifier{
+		Algorithm:  oidPBEWithSHAAnd3KeyTripleDESCBC,
+		Parameters: asn1.RawValue{FullBytes: paramsDER},
+	}
+	return alg, ciphertext, nil
+}
+
+// bmpString returns s as a null-terminated big-endian UTF-16 string, the
+// form PKCS #12 passwords take before key derivation.
+func bmpString(s string) ([]byte, error) {
+	ret := make([]byte, 0, 2*len(s)+2)
+	for _, r := range s {
+		if r > 0xffff {
+			return nil, errors.New("pkcs12: password contains characters outside the Basic Multilingual Plane")
+		}
+		for _, c := range utf16.Encode([]rune{r}) {
+			ret = append(ret, byte(c>>8), byte(c))
+		}
+	}
+	return append(ret, 0, 0), nil
+}
+
+// pbkdf implements the PKCS #12 key derivation function (RFC 7292,
+// appendix B.2) with SHA-1.  The id selects the purpose of the derived
+// material: 1 for keys, 2 for IVs and 3 for MAC keys.
+func pbkdf(password, salt []byte, id byte, iterations, size int) []byte {
+	const u, v = sha1.Size, 64
+
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fill(salt, v), fill(password, v)...)
+
+	var a []byte
+	for len(a) < size {
+		h := sha1.New()
+		h.Write(d)
+		h.Write(i)
+		ai := h.Sum(nil)
+		for j := 1; j < iterations; j++ {
+			sum := sha1.Sum(ai)
+			ai = sum[:]
+		}
+		a = append(a, ai...)
+
+		// Each v-byte block of I becomes (I_j + B + 1) mod 2^(8v),
+		// where B is Ai repeated to v bytes.
+		b := fill(ai, v)
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return a[:size]
+}
+
+// fill repeats b up to the next multiple of v bytes.
+func fill(b []byte, v int) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	out := make([]byte, v*((len(b)+v-1)/v))
+	for i := range out {
+		out[i] = b[i%len(b)]
+	}
+	return out
+}
@@ -0,0 +1,182 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
+	"golang.org/x/crypto/pkcs12"
+)
+
+func newTestCert(t *testing.T, key crypto.Signer, cn string) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestEncodeDecode(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []crypto.Signer{rsaKey, ecKey} {
+		for _, password := range []string{"", "password", "pässwörd"} {
+			cert := newTestCert(t, key, "leaf")
+			der, err := Encode(key, []*x509.Certificate{cert}, password)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decodedKey, decodedCert, err := pkcs12.Decode(der, password)
+			if err != nil {
+				t.Fatalf("failed to decode archive with password %q: %v", password, err)
+			}
+			if !decodedCert.Equal(cert) {
+				t.Fatal("certificate does not round trip")
+			}
+			if !reflect.DeepEqual(decodedKey.(crypto.Signer).Public(), key.Public()) {
+				t.Fatal("private key does not round trip")
+			}
+
+			if _, _, err = pkcs12.Decode(der, password+"wrong"); err == nil {
+				t.Fatal("expected decoding with the wrong password to fail")
+			}
+		}
+	}
+}
+
+func TestEncodeChain(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := []*x509.Certificate{newTestCert(t, key, "leaf"), newTestCert(t, key, "intermediate")}
+
+	der, err := Encode(key, certs, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := pkcs12.ToPEM(der, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nCerts, nKeys int
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			nCerts++
+		case "PRIVATE KEY", "EC PRIVATE KEY":
+			nKeys++
+		}
+	}
+	if nCerts != 2 || nKeys != 1 {
+		t.Fatalf("expected 2 certificates and 1 key, got %d and %d", nCerts, nKeys)
+	}
+}
+
+func TestEncodeCertificatesOnly(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := newTestCert(t, key, "root")
+	der, err := Encode(nil, []*x509.Certificate{cert}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// golang.org/x/crypto/pkcs12 insists on a key, so the archive is
+	// checked by hand.
+	var pfx pfxPdu
+	if _, err = asn1.Unmarshal(der, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	password, _ := bmpString("password")
+	var authSafeDER []byte
+	if _, err = asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeDER); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha1.New, pbkdf(password, pfx.MacData.MacSalt, 3, pfx.MacData.Iterations, sha1.Size))
+	mac.Write(authSafeDER)
+	if !hmac.Equal(mac.Sum(nil), pfx.MacData.Mac.Digest) {
+		t.Fatal("MAC does not verify")
+	}
+
+	var authSafe []contentInfo
+	if _, err = asn1.Unmarshal(authSafeDER, &authSafe); err != nil {
+		t.Fatal(err)
+	}
+	if len(authSafe) != 1 || !authSafe[0].ContentType.Equal(oidEncryptedDataContentType) {
+		t.Fatalf("expected a single encryptedData, got %d contents", len(authSafe))
+	}
+	var ed pkcs7.EncryptedData
+	if _, err = asn1.Unmarshal(authSafe[0].Content.Bytes, &ed); err != nil {
+		t.Fatal(err)
+	}
+	var params pbeParams
+	if _, err = asn1.Unmarshal(ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatal(err)
+	}
+	block, err := des.NewTripleDESCipher(pbkdf(password, params.Salt, 1, params.Iterations, 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := make([]byte, len(ed.EncryptedContentInfo.EncryptedContent))
+	cipher.NewCBCDecrypter(block, pbkdf(password, params.Salt, 2, params.Iterations, 8)).
+		CryptBlocks(plaintext, ed.EncryptedContentInfo.EncryptedContent)
+
+	var bags []safeBag
+	if _, err = asn1.Unmarshal(plaintext[:len(plaintext)-int(plaintext[len(plaintext)-1])], &bags); err != nil {
+		t.Fatal(err)
+	}
+	var cb certBag
+	if _, err = asn1.Unmarshal(bags[0].Value.Bytes, &cb); err != nil {
+		t.Fatal(err)
+	}
+	if len(bags) != 1 || !bytes.Equal(cb.Data, cert.Raw) {
+		t.Fatal("certificate does not round trip")
+	}
+}
+
+func TestEncodeKeyWithoutCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Encode(key, nil, "password"); err == nil {
+		t.Fatal("expected an error encoding a key without its certificate")
+	}
+}
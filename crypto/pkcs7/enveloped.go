@@ -0,0 +1,218 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+
+	cferr "github.com/cloudflare/cfssl/errors"
+)
+
+var (
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidEncryptionDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidEncryptionAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidEncryptionAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// EncryptionAlgorithm is a content encryption algorithm usable with
+// EnvelopedData.
+type EncryptionAlgorithm int
+
+// The content encryption algorithms supported by Encrypt and Decrypt.
+const (
+	DESEDE3CBC EncryptionAlgorithm = iota
+	AES128CBC
+	AES256CBC
+)
+
+func (alg EncryptionAlgorithm) oid() asn1.ObjectIdentifier {
+	switch alg {
+	case DESEDE3CBC:
+		return oidEncryptionDESEDE3CBC
+	case AES128CBC:
+		return oidEncryptionAES128CBC
+	default:
+		return oidEncryptionAES256CBC
+	}
+}
+
+func (alg EncryptionAlgorithm) keySize() int {
+	switch alg {
+	case DESEDE3CBC:
+		return 24
+	case AES128CBC:
+		return 16
+	default:
+		return 32
+	}
+}
+
+func (alg EncryptionAlgorithm) newCipher(key []byte) (cipher.Block, error) {
+	if alg == DESEDE3CBC {
+		return des.NewTripleDESCipher(key)
+	}
+	return aes.NewCipher(key)
+}
+
+// EnvelopedData contains content encrypted with a symmetric key, which is
+// in turn encrypted to each recipient's public key.
+type EnvelopedData struct {
+	Raw                  asn1.RawContent
+	Version              int
+	RecipientInfos       []RecipientInfo `asn1:"set"`
+	EncryptedContentInfo EncryptedContentInfo
+}
+
+// RecipientInfo holds the content encryption key, encrypted for the
+// recipient identified by IssuerAndSerialNumber.
+type RecipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  IssuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// EncryptionAlgorithm returns the algorithm the content of ed was
+// encrypted with.
+func (ed *EnvelopedData) EncryptionAlgorithm() (EncryptionAlgorithm, error) {
+	oid := ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm
+	for _, alg := range []EncryptionAlgorithm{DESEDE3CBC, AES128CBC, AES256CBC} {
+		if oid.Equal(alg.oid()) {
+			return alg, nil
+		}
+	}
+	return 0, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed,
+		errors.New("pkcs7: unsupported content encryption algorithm "+oid.String()))
+}
+
+// Decrypt recovers the content of ed using the private key of the
+// recipient cert.  Only RSA key transport is supported.
+func (ed *EnvelopedData) Decrypt(cert *x509.Certificate, key crypto.Decrypter) ([]byte, error) {
+	alg, err := ed.EncryptionAlgorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	var recipient *RecipientInfo
+	for i, ri := range ed.RecipientInfos {
+		if bytes.Equal(ri.IssuerAndSerialNumber.IssuerName.FullBytes, cert.RawIssuer) &&
+			ri.IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			recipient = &ed.RecipientInfos[i]
+			break
+		}
+	}
+	if recipient == nil {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.KeyMismatch, errors.New("pkcs7: certificate is not a recipient"))
+	}
+
+	contentKey, err := key.Decrypt(rand.Reader, recipient.EncryptedKey, nil)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.KeyMismatch, err)
+	}
+
+	content, err := decryptCBC(alg, contentKey, ed.EncryptedContentInfo)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.DecodeFailed, err)
+	}
+	return content, nil
+}
+
+func decryptCBC(alg EncryptionAlgorithm, key []byte, eci EncryptedContentInfo) ([]byte, error) {
+	if len(key) != alg.keySize() {
+		return nil, errors.New("pkcs7: invalid content encryption key size")
+	}
+	block, err := alg.newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv []byte
+	if _, err = asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	ciphertext := eci.EncryptedContent
+	if len(iv) != block.BlockSize() || len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, errors.New("pkcs7: malformed encrypted content")
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > block.BlockSize() {
+		return nil, errors.New("pkcs7: invalid padding")
+	}
+	for _, b := range plaintext[len(plaintext)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("pkcs7: invalid padding")
+		}
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// Encrypt returns the DER encoding of an EnvelopedData carrying content
+// encrypted with alg to each of the recipients, which must hold RSA keys.
+func Encrypt(content []byte, recipients []*x509.Certificate, alg EncryptionAlgorithm) ([]byte, error) {
+	key := make([]byte, alg.keySize())
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	block, err := alg.newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+
+	padding := block.BlockSize() - len(content)%block.BlockSize()
+	ciphertext := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	params, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	ed := EnvelopedData{
+		EncryptedContentInfo: EncryptedContentInfo{
+			ContentType: oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  alg.oid(),
+				Parameters: asn1.RawValue{FullBytes: params},
+			},
+			EncryptedContent: ciphertext,
+		},
+	}
+	for _, cert := range recipients {
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, cferr.New(cferr.CertificateError, cferr.BadRequest)
+		}
+		encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+		if err != nil {
+			return nil, err
+		}
+		ed.RecipientInfos = append(ed.RecipientInfos, RecipientInfo{
+			IssuerAndSerialNumber: IssuerAndSerialNumber{
+				IssuerName:   asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidEncryptionRSA, Parameters: asn1.RawValue{Tag: asn1.TagNull}},
+			EncryptedKey:           encryptedKey,
+		})
+	}
+	return marshalContentInfo(oidEnvelopedData, ed)
+}
@@ -16,10 +16,10 @@
 //			}
 //
 // There are 6 possible ContentTypes, data, signedData, envelopedData,
-// signedAndEnvelopedData, digestedData, and encryptedData.  Here signedData, Data, and encrypted
-// Data are implemented, as the degenerate case of signedData without a signature is the typical
-// format for transferring certificates and CRLS, and Data and encryptedData are used in PKCS #12
-// formats.
+// signedAndEnvelopedData, digestedData, and encryptedData.  Here signedData, Data, envelopedData
+// and encrypted Data are implemented, as the degenerate case of signedData without a signature is
+// the typical format for transferring certificates and CRLS, Data and encryptedData are used in
+// PKCS #12 formats, and signed envelopedData is the message format used by SCEP.
 // The ContentType signedData has the form:
 //
 //
@@ -32,10 +32,9 @@
 //				signerInfos SignerInfos
 //			}
 //
-// As of yet signerInfos and digestAlgorithms are not parsed, as they are not relevant to
-// this system's use of PKCS #7 data.  Version is an integer type, note that PKCS #7 is
-// recursive, this second layer of ContentInfo is similar ignored for our degenerate
-// usage.  The ExtendedCertificatesAndCertificates type consists of a sequence of choices
+// The digestAlgorithms field is not parsed, as every signerInfo repeats its own digest
+// algorithm.  Version is an integer type, note that PKCS #7 is recursive, and the content
+// carried by the second layer of ContentInfo is exposed as raw bytes for the caller to parse.  The ExtendedCertificatesAndCertificates type consists of a sequence of choices
 // between PKCS #6 extended certificates and x509 certificates.  Any sequence consisting
 // of any number of extended certificates is not yet supported in this implementation.
 //
@@ -44,6 +43,9 @@
 // The ContentType encryptedData is the most complicated and its form can be gathered by
 // the go type below.  It essentially contains a raw octet string of encrypted data and an
 // algorithm identifier for use in decrypting this data.
+//
+// Besides parsing, this package can produce degenerate (certificates only) and signed
+// signedData, and envelopedData encrypted to RSA recipients; see signed.go and enveloped.go.
 package pkcs7
 
 import (
@@ -61,8 +63,8 @@ type signedData struct {
 	Version          int
 	DigestAlgorithms asn1.RawValue
 	ContentInfo      asn1.RawValue
-	Certificates     asn1.RawValue `asn1:"optional" asn1:"tag:0"`
-	Crls             asn1.RawValue `asn1:"optional"`
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	Crls             asn1.RawValue `asn1:"optional,tag:1"`
 	SignerInfos      asn1.RawValue
 }
 
@@ -72,16 +74,17 @@ type initPKCS7 struct {
 	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
 }
 
-// Object identifier strings of the three implemented PKCS7 types.
+// Object identifier strings of the four implemented PKCS7 types.
 const (
 	ObjIDData          = "1.2.840.113549.1.7.1"
 	ObjIDSignedData    = "1.2.840.113549.1.7.2"
+	ObjIDEnvelopedData = "1.2.840.113549.1.7.3"
 	ObjIDEncryptedData = "1.2.840.113549.1.7.6"
 )
 
-// PKCS7 represents the ASN1 PKCS #7 Content type.  It contains one of three
+// PKCS7 represents the ASN1 PKCS #7 Content type.  It contains one of four
 // possible types of Content objects, as denoted by the object identifier in
-// the ContentInfo field, the other two being nil.  SignedData
+// the ContentInfo field, the others being nil.  SignedData
 // is the degenerate SignedData Content info without signature used
 // to hold certificates and crls.  Data is raw bytes, and EncryptedData
 // is as defined in PKCS #7 standard.
@@ -91,19 +94,25 @@ type PKCS7 struct {
 	Content     Content
 }
 
-// Content implements three of the six possible PKCS7 data types.  Only one is non-nil.
+// Content implements four of the six possible PKCS7 data types.  Only one is non-nil.
 type Content struct {
 	Data          []byte
 	SignedData    SignedData
+	EnvelopedData EnvelopedData
 	EncryptedData EncryptedData
 }
 
-// SignedData defines the typical carrier of certificates and crls.
+// SignedData defines the typical carrier of certificates and crls.  When
+// the SignedData is actually signed, ContentType and Content hold the
+// encapsulated content and SignerInfos its signatures.
 type SignedData struct {
 	Raw          asn1.RawContent
 	Version      int
 	Certificates []*x509.Certificate
 	Crl          *pkix.CertificateList
+	ContentType  asn1.ObjectIdentifier
+	Content      []byte
+	SignerInfos  []SignerInfo
 }
 
 // Data contains raw bytes.  Used as a subtype in PKCS12.
@@ -165,8 +174,19 @@ func ParsePKCS7(raw []byte) (msg *PKCS7, err error) {
 				return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
 			}
 		}
+		if err = parseSignedContent(&msg.Content.SignedData, &signedData); err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+		}
 		msg.Content.SignedData.Version = signedData.Version
 		msg.Content.SignedData.Raw = pkcs7.Content.Bytes
+	case msg.ContentInfo == ObjIDEnvelopedData:
+		msg.ContentInfo = "EnvelopedData"
+		var envelopedData EnvelopedData
+		_, err = asn1.Unmarshal(pkcs7.Content.Bytes, &envelopedData)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+		}
+		msg.Content.EnvelopedData = envelopedData
 	case msg.ContentInfo == ObjIDEncryptedData:
 		msg.ContentInfo = "EncryptedData"
 		var encryptedData EncryptedData
@@ -180,7 +200,7 @@ func ParsePKCS7(raw []byte) (msg *PKCS7, err error) {
 		msg.Content.EncryptedData = encryptedData
 
 	default:
-		return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, errors.New("Attempt to parse PKCS# 7 Content not of type data, signed data or encrypted data"))
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, errors.New("Attempt to parse PKCS# 7 Content not of type data, signed data, enveloped data or encrypted data"))
 	}
 
 	return msg, nil
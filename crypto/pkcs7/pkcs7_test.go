@@ -0,0 +1,186 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestCert(t *testing.T, key crypto.Signer, cn string) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestDegenerateCertificates(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := []*x509.Certificate{newTestCert(t, key, "one"), newTestCert(t, key, "two")}
+
+	der, err := DegenerateCertificates(certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := ParsePKCS7(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p7.ContentInfo != "SignedData" {
+		t.Fatalf("expected SignedData, got %s", p7.ContentInfo)
+	}
+	parsed := p7.Content.SignedData.Certificates
+	if len(parsed) != len(certs) {
+		t.Fatalf("expected %d certificates, got %d", len(certs), len(parsed))
+	}
+	for i := range certs {
+		if !parsed[i].Equal(certs[i]) {
+			t.Fatalf("certificate %d does not round trip", i)
+		}
+	}
+	if len(p7.Content.SignedData.SignerInfos) != 0 {
+		t.Fatal("degenerate SignedData should have no signers")
+	}
+}
+
+func TestSignData(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attrType := asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+	content := []byte("signed content")
+	for _, key := range []crypto.Signer{rsaKey, ecKey} {
+		cert := newTestCert(t, key, "signer")
+		der, err := SignData(content, cert, key, Attribute{Type: attrType, Value: "transaction"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p7, err := ParsePKCS7(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd := p7.Content.SignedData
+		if !bytes.Equal(sd.Content, content) {
+			t.Fatalf("content does not round trip: %q", sd.Content)
+		}
+		if err = sd.Verify(); err != nil {
+			t.Fatalf("failed to verify signature: %v", err)
+		}
+
+		signer, err := sd.SignerCertificate(&sd.SignerInfos[0])
+		if err != nil || !signer.Equal(cert) {
+			t.Fatalf("wrong signer certificate: %v", err)
+		}
+
+		var value string
+		if err = sd.SignerInfos[0].UnmarshalAttribute(attrType, &value); err != nil || value != "transaction" {
+			t.Fatalf("bad attribute %q: %v", value, err)
+		}
+		if err = sd.SignerInfos[0].UnmarshalAttribute(asn1.ObjectIdentifier{1, 2, 3}, &value); err != ErrNoAttribute {
+			t.Fatalf("expected ErrNoAttribute, got %v", err)
+		}
+
+		sd.Content = []byte("tampered content")
+		if err = sd.Verify(); err == nil {
+			t.Fatal("expected verification of tampered content to fail")
+		}
+	}
+}
+
+func TestMarshalAttributes(t *testing.T) {
+	set, err := marshalAttributes([]Attribute{
+		{Type: asn1.ObjectIdentifier{1, 2, 3}, Value: "a much longer value"},
+		{Type: asn1.ObjectIdentifier{1, 2, 3}, Value: "short"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Class != asn1.ClassUniversal || set.Tag != asn1.TagSet || !set.IsCompound {
+		t.Fatalf("attributes are not a SET: %x", set.FullBytes)
+	}
+
+	// The elements of a DER SET OF are in the order of their encodings.
+	var attrs []attribute
+	if _, err = asn1.UnmarshalWithParams(set.FullBytes, &attrs, "set"); err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, attr := range attrs {
+		var value string
+		if _, err = asn1.Unmarshal(attr.Values[0].FullBytes, &value); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	if len(values) != 2 || values[0] != "short" {
+		t.Fatalf("attributes are out of order: %q", values)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := newTestCert(t, key, "recipient")
+	other := newTestCert(t, key, "other")
+
+	for _, alg := range []EncryptionAlgorithm{DESEDE3CBC, AES128CBC, AES256CBC} {
+		for _, content := range [][]byte{[]byte("secret"), bytes.Repeat([]byte{'x'}, 32)} {
+			der, err := Encrypt(content, []*x509.Certificate{cert}, alg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p7, err := ParsePKCS7(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p7.ContentInfo != "EnvelopedData" {
+				t.Fatalf("expected EnvelopedData, got %s", p7.ContentInfo)
+			}
+			ed := p7.Content.EnvelopedData
+			if got, err := ed.EncryptionAlgorithm(); err != nil || got != alg {
+				t.Fatalf("expected algorithm %d, got %d (%v)", alg, got, err)
+			}
+			plaintext, err := ed.Decrypt(cert, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(plaintext, content) {
+				t.Fatalf("content does not round trip: %q", plaintext)
+			}
+			if _, err = ed.Decrypt(other, key); err == nil {
+				t.Fatal("expected decryption for a non-recipient to fail")
+			}
+		}
+	}
+}
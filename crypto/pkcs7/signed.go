@@ -0,0 +1,394 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+
+	// Register the digests that signerInfos may name.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	cferr "github.com/cloudflare/cfssl/errors"
+)
+
+var (
+	oidData                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidDigestSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidEncryptionRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSignatureECDSASHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// ErrNoAttribute is returned by SignerInfo.UnmarshalAttribute when the
+// requested authenticated attribute is not present.
+var ErrNoAttribute = errors.New("pkcs7: attribute not found")
+
+// IssuerAndSerialNumber identifies a certificate by its issuer's
+// distinguished name and its serial number.
+type IssuerAndSerialNumber struct {
+	IssuerName   asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// SignerInfo holds the signature of a single signer over the content
+// of a SignedData.  The authenticated and unauthenticated attributes are
+// kept in their raw form, as the signature covers their exact encoding.
+type SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     IssuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// Attribute is an authenticated attribute to be added to a SignerInfo.
+// Value is marshaled with encoding/asn1.
+type Attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value interface{}
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type marshalSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []SignerInfo  `asn1:"set"`
+}
+
+// explicit wraps der in a [0] EXPLICIT tag, as used for the content of a
+// ContentInfo.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// parseSignedContent fills in the encapsulated content and signerInfos of
+// a parsed signedData.
+func parseSignedContent(sd *SignedData, raw *signedData) error {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(raw.ContentInfo.FullBytes, &ci); err != nil {
+		return err
+	}
+	sd.ContentType = ci.ContentType
+	if len(ci.Content.Bytes) != 0 {
+		if ci.ContentType.Equal(oidData) {
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd.Content); err != nil {
+				return err
+			}
+		} else {
+			sd.Content = ci.Content.Bytes
+		}
+	}
+
+	if len(raw.SignerInfos.FullBytes) != 0 {
+		if _, err := asn1.UnmarshalWithParams(raw.SignerInfos.FullBytes, &sd.SignerInfos, "set"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attributes returns the decoded authenticated attributes of si.
+func (si *SignerInfo) attributes() ([]attribute, error) {
+	if len(si.AuthenticatedAttributes.Bytes) == 0 {
+		return nil, nil
+	}
+	var attrs []attribute
+	if _, err := asn1.UnmarshalWithParams(si.attributesSet(), &attrs, "set"); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// attributesSet returns the authenticated attributes re-tagged as the
+// SET OF Attribute that the signature is computed over.
+func (si *SignerInfo) attributesSet() []byte {
+	set := make([]byte, len(si.AuthenticatedAttributes.FullBytes))
+	copy(set, si.AuthenticatedAttributes.FullBytes)
+	set[0] = 0x31 // SET, constructed
+	return set
+}
+
+// UnmarshalAttribute decodes the first value of the authenticated
+// attribute of the given type into out.  It returns ErrNoAttribute if the
+// SignerInfo does not carry such an attribute.
+func (si *SignerInfo) UnmarshalAttribute(attrType asn1.ObjectIdentifier, out interface{}) error {
+	err := si.unmarshalAttribute(attrType, out)
+	if err != nil && err != ErrNoAttribute {
+		return cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+	}
+	return err
+}
+
+func (si *SignerInfo) unmarshalAttribute(attrType asn1.ObjectIdentifier, out interface{}) error {
+	attrs, err := si.attributes()
+	if err != nil {
+		return err
+	}
+	for _, a := range attrs {
+		if a.Type.Equal(attrType) && len(a.Values) != 0 {
+			_, err = asn1.Unmarshal(a.Values[0].FullBytes, out)
+			return err
+		}
+	}
+	return ErrNoAttribute
+}
+
+// SignerCertificate returns the certificate, among those carried by the
+// SignedData, that is identified by si.
+func (sd *SignedData) SignerCertificate(si *SignerInfo) (*x509.Certificate, error) {
+	cert, err := sd.signerCertificate(si)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed, err)
+	}
+	return cert, nil
+}
+
+func (sd *SignedData) signerCertificate(si *SignerInfo) (*x509.Certificate, error) {
+	for _, cert := range sd.Certificates {
+		if bytes.Equal(cert.RawIssuer, si.IssuerAndSerialNumber.IssuerName.FullBytes) &&
+			cert.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 {
+			return cert, nil
+		}
+	}
+	return nil, errors.New("pkcs7: no certificate for signer")
+}
+
+// Verify checks the signature of every SignerInfo against the content
+// and the certificates carried by the SignedData.  It does not verify
+// the signer certificates themselves.
+func (sd *SignedData) Verify() error {
+	if len(sd.SignerInfos) == 0 {
+		return cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed, errors.New("pkcs7: no signers"))
+	}
+	for i := range sd.SignerInfos {
+		if err := sd.verifySigner(&sd.SignerInfos[i]); err != nil {
+			return cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed, err)
+		}
+	}
+	return nil
+}
+
+func (sd *SignedData) verifySigner(si *SignerInfo) error {
+	cert, err := sd.signerCertificate(si)
+	if err != nil {
+		return err
+	}
+
+	hash, err := digestHash(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	signed := sd.Content
+	if len(si.AuthenticatedAttributes.FullBytes) != 0 {
+		var digest []byte
+		if err = si.unmarshalAttribute(oidAttributeMessageDigest, &digest); err != nil {
+			return err
+		}
+		h := hash.New()
+		h.Write(sd.Content)
+		if !bytes.Equal(digest, h.Sum(nil)) {
+			return errors.New("pkcs7: message digest mismatch")
+		}
+		signed = si.attributesSet()
+	}
+
+	sigAlg, err := signatureAlgorithm(hash, cert.PublicKeyAlgorithm)
+	if err != nil {
+		return err
+	}
+	return cert.CheckSignature(sigAlg, signed, si.EncryptedDigest)
+}
+
+func digestHash(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidDigestSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidDigestSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidDigestSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidDigestSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, errors.New("pkcs7: unsupported digest algorithm " + oid.String())
+}
+
+func signatureAlgorithm(hash crypto.Hash, pub x509.PublicKeyAlgorithm) (x509.SignatureAlgorithm, error) {
+	algs := map[x509.PublicKeyAlgorithm]map[crypto.Hash]x509.SignatureAlgorithm{
+		x509.RSA: {
+			crypto.SHA1:   x509.SHA1WithRSA,
+			crypto.SHA256: x509.SHA256WithRSA,
+			crypto.SHA384: x509.SHA384WithRSA,
+			crypto.SHA512: x509.SHA512WithRSA,
+		},
+		x509.ECDSA: {
+			crypto.SHA1:   x509.ECDSAWithSHA1,
+			crypto.SHA256: x509.ECDSAWithSHA256,
+			crypto.SHA384: x509.ECDSAWithSHA384,
+			crypto.SHA512: x509.ECDSAWithSHA512,
+		},
+	}
+	if alg, ok := algs[pub][hash]; ok {
+		return alg, nil
+	}
+	return x509.UnknownSignatureAlgorithm, errors.New("pkcs7: unsupported signature algorithm")
+}
+
+// DegenerateCertificates returns the DER encoding of a certificates-only
+// SignedData, the format produced by "openssl crl2pkcs7 -nocrl".
+func DegenerateCertificates(certs []*x509.Certificate) ([]byte, error) {
+	sd := marshalSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     rawCertificates(certs),
+		SignerInfos:      []SignerInfo{},
+	}
+	return marshalContentInfo(oidSignedData, sd)
+}
+
+// SignData returns the DER encoding of a SignedData carrying content, and
+// signed with SHA-256 by key on behalf of cert.  The contentType,
+// messageDigest and signingTime attributes are always authenticated,
+// followed by attrs.  A nil content produces a SignedData without
+// encapsulated content.
+func SignData(content []byte, cert *x509.Certificate, key crypto.Signer, attrs ...Attribute) ([]byte, error) {
+	var sigAlg pkix.AlgorithmIdentifier
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidEncryptionRSA, Parameters: asn1.RawValue{Tag: asn1.TagNull}}
+	case *ecdsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidSignatureECDSASHA256}
+	default:
+		return nil, cferr.New(cferr.PrivateKeyError, cferr.NotRSAOrECC)
+	}
+
+	digest := crypto.SHA256.New()
+	digest.Write(content)
+	attrs = append([]Attribute{
+		{Type: oidAttributeContentType, Value: oidData},
+		{Type: oidAttributeMessageDigest, Value: digest.Sum(nil)},
+		{Type: oidAttributeSigningTime, Value: time.Now().UTC()},
+	}, attrs...)
+
+	authAttrs, err := marshalAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	h := crypto.SHA256.New()
+	h.Write(authAttrs.FullBytes)
+	signature, err := key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.Unknown, err)
+	}
+
+	// The signature covers the attributes as a SET; they are carried
+	// in the SignerInfo as [0] IMPLICIT.
+	authAttrs.FullBytes = nil
+	authAttrs.Class = asn1.ClassContextSpecific
+	authAttrs.Tag = 0
+
+	digestAlg := pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256, Parameters: asn1.RawValue{Tag: asn1.TagNull}}
+	sd := marshalSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     rawCertificates([]*x509.Certificate{cert}),
+		SignerInfos: []SignerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: IssuerAndSerialNumber{
+				IssuerName:   asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:           digestAlg,
+			AuthenticatedAttributes:   authAttrs,
+			DigestEncryptionAlgorithm: sigAlg,
+			EncryptedDigest:           signature,
+		}},
+	}
+	if content != nil {
+		octets, err := asn1.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		sd.ContentInfo.Content = explicit(octets)
+	}
+	return marshalContentInfo(oidSignedData, sd)
+}
+
+// marshalAttributes encodes attrs as a DER SET OF Attribute.
+func marshalAttributes(attrs []Attribute) (asn1.RawValue, error) {
+	// DER orders the elements of a SET OF by their encodings.
+	encoded := make([][]byte, len(attrs))
+	for i, a := range attrs {
+		value, err := asn1.Marshal(a.Value)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		encoded[i], err = asn1.Marshal(attribute{Type: a.Type, Values: []asn1.RawValue{{FullBytes: value}}})
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+
+	der, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(encoded, nil)})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	var set asn1.RawValue
+	if _, err = asn1.Unmarshal(der, &set); err != nil {
+		return asn1.RawValue{}, err
+	}
+	return set, nil
+}
+
+func rawCertificates(certs []*x509.Certificate) asn1.RawValue {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		buf.Write(cert.Raw)
+	}
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: buf.Bytes()}
+}
+
+func marshalContentInfo(contentType asn1.ObjectIdentifier, content interface{}) ([]byte, error) {
+	der, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{ContentType: contentType, Content: explicit(der)})
+}
@@ -0,0 +1,357 @@
+// Package pkcs8 encrypts and decrypts PKCS #8 EncryptedPrivateKeyInfo
+// structures (RFC 5958), the "ENCRYPTED PRIVATE KEY" PEM blocks written
+// by openssl pkcs8 -topk8.
+//
+// Keys are encrypted with PBES2 (RFC 8018), deriving the key encryption
+// key from the password with scrypt (RFC 7914), and encrypting either
+// with AES-256-CBC, which OpenSSL 1.1 and later can read, or with
+// AES-256-GCM (RFC 5084), which also authenticates the key.  Decryption
+// additionally accepts PBKDF2 with HMAC-SHA1 or HMAC-SHA256, and
+// AES-CBC with any key size, covering OpenSSL's defaults.
+package pkcs8
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/cloudflare/cfssl/helpers/derhelpers"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PEMType is the type of the PEM blocks holding encrypted private keys.
+const PEMType = "ENCRYPTED PRIVATE KEY"
+
+// Cipher selects how an encrypted private key's content is encrypted.
+type Cipher int
+
+// The supported content encryption ciphers.
+const (
+	AES256CBC Cipher = iota
+	AES256GCM
+)
+
+// ParseCipher returns the Cipher named by s: "aes256-cbc", which is also
+// the default when s is empty, or "aes256-gcm".
+func ParseCipher(s string) (Cipher, error) {
+	switch s {
+	case "", "aes256-cbc":
+		return AES256CBC, nil
+	case "aes256-gcm":
+		return AES256GCM, nil
+	}
+	return 0, fmt.Errorf("pkcs8: unsupported cipher %q", s)
+}
+
+// The scrypt parameters used for encryption. N = 2^14 and r = 8 need
+// 16 MiB, within OpenSSL's default limit of 32 MiB.
+const (
+	scryptN = 1 << 14
+	scryptR = 8
+	scryptP = 1
+)
+
+// maxScryptMemory bounds the memory scrypt may use when decrypting, so
+// that a crafted key cannot exhaust it.
+const maxScryptMemory = 1 << 30
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidAES256GCM      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type gcmParams struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
+
+// EncryptPrivateKey returns the DER encoding of key as a PKCS #8
+// EncryptedPrivateKeyInfo, encrypted under password with c.
+func EncryptPrivateKey(key crypto.Signer, password []byte, c Cipher) ([]byte, error) {
+	plaintext, err := derhelpers.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+	kek, err := scrypt.Key(password, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	kdfParams, err := asn1.Marshal(scryptParams{
+		Salt:                     salt,
+		CostParameter:            scryptN,
+		BlockSize:                scryptR,
+		ParallelizationParameter: scryptP,
+		KeyLength:                32,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	var scheme pkix.AlgorithmIdentifier
+	var ciphertext []byte
+	switch c {
+	case AES256CBC:
+		iv := make([]byte, aes.BlockSize)
+		if _, err = rand.Read(iv); err != nil {
+			return nil, err
+		}
+		if scheme, err = algorithm(oidAES256CBC, iv); err != nil {
+			return nil, err
+		}
+		padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+		for i := 0; i < padding; i++ {
+			plaintext = append(plaintext, byte(padding))
+		}
+		ciphertext = make([]byte, len(plaintext))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+	case AES256GCM:
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		if scheme, err = algorithm(oidAES256GCM, gcmParams{Nonce: nonce, ICVLen: aead.Overhead()}); err != nil {
+			return nil, err
+		}
+		ciphertext = aead.Seal(nil, nonce, plaintext, nil)
+	default:
+		return nil, errors.New("pkcs8: unsupported cipher")
+	}
+
+	params, err := algorithm(oidPBES2, pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  scheme,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: params, EncryptedData: ciphertext})
+}
+
+// EncryptPrivateKeyPEM is like EncryptPrivateKey, but returns an
+// "ENCRYPTED PRIVATE KEY" PEM block.
+func EncryptPrivateKeyPEM(key crypto.Signer, password []byte, c Cipher) ([]byte, error) {
+	der, err := EncryptPrivateKey(key, password, c)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PEMType, Bytes: der}), nil
+}
+
+// DecryptPrivateKey decrypts a DER-encoded EncryptedPrivateKeyInfo with
+// password, returning the DER encoding of the PKCS #8 PrivateKeyInfo.
+func DecryptPrivateKey(der, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if err := unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("pkcs8: unsupported encryption algorithm %s", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if err := unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+
+	scheme := params.EncryptionScheme
+	var keyLen int
+	switch {
+	case scheme.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case scheme.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case scheme.Algorithm.Equal(oidAES256CBC), scheme.Algorithm.Equal(oidAES256GCM):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("pkcs8: unsupported encryption scheme %s", scheme.Algorithm)
+	}
+
+	kek, err := deriveKey(params.KeyDerivationFunc, password, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	if scheme.Algorithm.Equal(oidAES256GCM) {
+		var gcm gcmParams
+		if err = unmarshal(scheme.Parameters.FullBytes, &gcm); err != nil {
+			return nil, err
+		}
+		// Only the full 16-byte tag is supported: shorter tags
+		// need cipher.NewGCMWithTagSize, which Go 1.10 lacks.
+		if gcm.ICVLen != 16 {
+			return nil, errors.New("pkcs8: unsupported GCM parameters")
+		}
+		var aead cipher.AEAD
+		if len(gcm.Nonce) == 12 {
+			aead, err = cipher.NewGCM(block)
+		} else {
+			aead, err = cipher.NewGCMWithNonceSize(block, len(gcm.Nonce))
+		}
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := aead.Open(nil, gcm.Nonce, info.EncryptedData, nil)
+		if err != nil {
+			return nil, errors.New("pkcs8: decryption failed")
+		}
+		return plaintext, nil
+	}
+
+	var iv []byte
+	if err = unmarshal(scheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("pkcs8: invalid IV")
+	}
+	ciphertext := info.EncryptedData
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("pkcs8: invalid ciphertext length")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	return unpad(plaintext)
+}
+
+// deriveKey derives the key encryption key from password with the
+// scrypt or PBKDF2 function described by kdf.
+func deriveKey(kdf pkix.AlgorithmIdentifier, password []byte, keyLen int) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidScrypt):
+		var params scryptParams
+		if err := unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		if params.KeyLength != 0 && params.KeyLength != keyLen {
+			return nil, errors.New("pkcs8: scrypt key length does not match the cipher")
+		}
+		n, r, p := params.CostParameter, params.BlockSize, params.ParallelizationParameter
+		if n <= 1 || r <= 0 || p <= 0 || n > maxScryptMemory/128/r || p > maxScryptMemory/128/r {
+			return nil, errors.New("pkcs8: scrypt parameters out of range")
+		}
+		return scrypt.Key(password, params.Salt, n, r, p, keyLen)
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var params pbkdf2Params
+		if err := unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		if params.KeyLength != 0 && params.KeyLength != keyLen {
+			return nil, errors.New("pkcs8: PBKDF2 key length does not match the cipher")
+		}
+		if params.IterationCount <= 0 {
+			return nil, errors.New("pkcs8: invalid PBKDF2 iteration count")
+		}
+		var h func() hash.Hash
+		switch {
+		case params.PRF.Algorithm == nil, params.PRF.Algorithm.Equal(oidHMACWithSHA1):
+			h = sha1.New
+		case params.PRF.Algorithm.Equal(oidHMACWithSHA256):
+			h = sha256.New
+		default:
+			return nil, fmt.Errorf("pkcs8: unsupported PBKDF2 PRF %s", params.PRF.Algorithm)
+		}
+		return pbkdf2.Key(password, params.Salt, params.IterationCount, keyLen, h), nil
+	}
+	return nil, fmt.Errorf("pkcs8: unsupported key derivation function %s", kdf.Algorithm)
+}
+
+// unpad strips PKCS #7 padding, checking every byte of the last block
+// so that the time taken does not depend on where the padding is wrong.
+func unpad(plaintext []byte) ([]byte, error) {
+	padding := int(plaintext[len(plaintext)-1])
+	good := 1
+	if padding == 0 || padding > aes.BlockSize {
+		good = 0
+		padding = 1
+	}
+	for i := 0; i < aes.BlockSize; i++ {
+		b := plaintext[len(plaintext)-1-i]
+		if i < padding && int(b) != padding {
+			good = 0
+		}
+	}
+	if good != 1 {
+		return nil, errors.New("pkcs8: decryption failed")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// algorithm returns an AlgorithmIdentifier with the DER encoding of
+// params as its parameters.
+func algorithm(oid asn1.ObjectIdentifier, params interface{}) (pkix.AlgorithmIdentifier, error) {
+	der, err := asn1.Marshal(params)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.RawValue{FullBytes: der}}, nil
+}
+
+// unmarshal parses der into out, rejecting trailing data.
+func unmarshal(der []byte, out interface{}) error {
+	rest, err := asn1.Unmarshal(der, out)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errors.New("pkcs8: trailing data")
+	}
+	return nil
+}
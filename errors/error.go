@@ -108,9 +108,8 @@ const (
 // The following represent private-key non-parsing errors, and must be
 // specified with PrivateKeyError.
 const (
-	// Encrypted indicates that the private key is a PKCS #8 encrypted
-	// private key. At this time, CFSSL does not support decrypting
-	// these keys.
+	// Encrypted indicates that the private key is encrypted, and
+	// no password was supplied to decrypt it.
 	Encrypted Reason = 100 * (iota + 1) //21XX
 
 	// NotRSAOrECC indicates that they key is not an RSA or ECC
@@ -151,6 +150,10 @@ const (
 	UnknownProfile // 54XX
 
 	UnmatchedWhitelist // 55xx
+
+	// LintFailed indicates that the certificate to be signed
+	// failed a lint check that the profile blocks on.
+	LintFailed // 56XX
 )
 
 // The following are API client related errors, and should be
@@ -327,6 +330,8 @@ func New(category Category, reason Reason) *Error {
 			msg = "Unknown policy profile"
 		case UnmatchedWhitelist:
 			msg = "Request does not match policy whitelist"
+		case LintFailed:
+			msg = "Certificate failed lint checks"
 		default:
 			panic(fmt.Sprintf("Unsupported CFSSL error reason %d under category PolicyError.",
 				reason))
@@ -40,6 +40,12 @@ func NewBadRequestMissingParameter(s string) *HTTPError {
 	return NewBadRequestString(`Missing parameter "` + s + `"`)
 }
 
+// NewRequestEntityTooLarge returns a 413 HttpError as the body of the
+// HTTP request is longer than the endpoint accepts.
+func NewRequestEntityTooLarge() *HTTPError {
+	return &HTTPError{http.StatusRequestEntityTooLarge, errors.New("Request body is too large")}
+}
+
 // NewBadRequestUnwantedParameter returns a 400 HttpError as a unnecessary
 // parameter is present in the HTTP request.
 func NewBadRequestUnwantedParameter(s string) *HTTPError {
@@ -0,0 +1,159 @@
+package expiry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// DefaultRoute is the route used for certificates whose label has no
+// route of its own.
+const DefaultRoute = "default"
+
+// DefaultThresholds are the thresholds used when none are configured:
+// thirty, seven and one day before expiry.
+var DefaultThresholds = []time.Duration{720 * time.Hour, 168 * time.Hour, 24 * time.Hour}
+
+// A Directory is a directory of PEM-encoded certificates to watch.
+type Directory struct {
+	Path  string `json:"path"`
+	Label string `json:"label,omitempty"`
+}
+
+// An Endpoint is a TLS server, listening on port 443, whose
+// certificate chain should be watched.
+type Endpoint struct {
+	Domain string `json:"domain"`
+	IP     string `json:"ip,omitempty"`
+	Label  string `json:"label,omitempty"`
+}
+
+// NotifierConfig describes a notification target.
+type NotifierConfig struct {
+	// Type is "webhook" or "smtp".
+	Type string `json:"type"`
+
+	// URL and Headers configure a webhook.
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Server, From, To, Username and Password configure SMTP
+	// delivery. Server is a host:port; authentication is only
+	// attempted if Username is set.
+	Server   string   `json:"server,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// Config configures the expiry watcher.
+type Config struct {
+	// IntervalString is how often certificates are checked. If
+	// it is empty, the watcher runs once.
+	IntervalString string `json:"interval,omitempty"`
+	// ThresholdStrings are the times before expiry at which
+	// notifications are sent.
+	ThresholdStrings []string `json:"thresholds,omitempty"`
+
+	Directories []Directory `json:"directories,omitempty"`
+	Endpoints   []Endpoint  `json:"endpoints,omitempty"`
+
+	Notifiers map[string]*NotifierConfig `json:"notifiers"`
+	// Routes maps certificate labels (the certdb CA label, or
+	// the label given to a directory or endpoint) to the names
+	// of the notifiers that should hear about them. Labels
+	// without a route use the "default" route, which is required.
+	Routes map[string][]string `json:"routes"`
+
+	Interval   time.Duration   `json:"-"`
+	Thresholds []time.Duration `json:"-"`
+}
+
+// LoadConfig reads and validates the watcher configuration in path.
+func LoadConfig(path string) (*Config, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err = json.Unmarshal(body, &cfg); err != nil {
+		return nil, errors.New("failed to unmarshal expiry watch configuration: " + err.Error())
+	}
+
+	if err = cfg.populate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// populate parses the durations in the configuration and checks that
+// every route refers to a known notifier, and that there is a default
+// route.
+func (c *Config) populate() error {
+	var err error
+	if c.IntervalString != "" {
+		c.Interval, err = time.ParseDuration(c.IntervalString)
+		if err != nil {
+			return fmt.Errorf("invalid interval %q: %v", c.IntervalString, err)
+		}
+	}
+
+	c.Thresholds = nil
+	for _, s := range c.ThresholdStrings {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid threshold %q: %v", s, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("invalid threshold %q: must be positive", s)
+		}
+		c.Thresholds = append(c.Thresholds, d)
+	}
+	if len(c.Thresholds) == 0 {
+		c.Thresholds = append(c.Thresholds, DefaultThresholds...)
+	}
+	sort.Slice(c.Thresholds, func(i, j int) bool { return c.Thresholds[i] < c.Thresholds[j] })
+
+	for name, n := range c.Notifiers {
+		switch n.Type {
+		case "webhook":
+			if n.URL == "" {
+				return fmt.Errorf("notifier %s: webhook needs a url", name)
+			}
+		case "smtp":
+			if n.Server == "" || n.From == "" || len(n.To) == 0 {
+				return fmt.Errorf("notifier %s: smtp needs a server, from and to", name)
+			}
+		default:
+			return fmt.Errorf("notifier %s: unknown type %q", name, n.Type)
+		}
+	}
+
+	// Certificates whose label has no route would otherwise expire
+	// without anyone hearing about it.
+	if len(c.Routes[DefaultRoute]) == 0 {
+		return errors.New("no default route is configured")
+	}
+	for label, names := range c.Routes {
+		for _, name := range names {
+			if _, ok := c.Notifiers[name]; !ok {
+				return fmt.Errorf("route %s: unknown notifier %s", label, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// route returns the notifiers for a certificate label.
+func (c *Config) route(label string) []string {
+	if names, ok := c.Routes[label]; ok {
+		return names
+	}
+	return c.Routes[DefaultRoute]
+}
@@ -0,0 +1,140 @@
+package expiry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// A Notice reports a certificate that has crossed an expiry
+// threshold.
+type Notice struct {
+	Serial   string    `json:"serial_number"`
+	AKI      string    `json:"authority_key_identifier"`
+	Label    string    `json:"label,omitempty"`
+	Source   string    `json:"source"`
+	Subject  string    `json:"subject"`
+	DNSNames []string  `json:"dns_names,omitempty"`
+	NotAfter time.Time `json:"not_after"`
+	Expired  bool      `json:"expired"`
+	// Threshold is the smallest threshold the certificate has
+	// crossed; it is zero for expired certificates.
+	Threshold       time.Duration `json:"-"`
+	ThresholdString string        `json:"threshold"`
+
+	// revoked certificates are never reported.
+	revoked bool
+}
+
+func (n Notice) String() string {
+	name := n.Subject
+	if len(n.DNSNames) > 0 {
+		name = strings.Join(n.DNSNames, ", ")
+	}
+
+	when := "expires"
+	if n.Expired {
+		when = "expired"
+	}
+	s := fmt.Sprintf("%s (serial %s from %s", name, n.Serial, n.Source)
+	if n.Label != "" {
+		s += ", label " + n.Label
+	}
+	return s + fmt.Sprintf(") %s %s", when, n.NotAfter.UTC().Format(time.RFC1123))
+}
+
+// A Notifier delivers notices.
+type Notifier interface {
+	Notify(notices []Notice) error
+}
+
+// NewNotifier returns the Notifier described by cfg.
+func NewNotifier(cfg *NotifierConfig) (Notifier, error) {
+	switch cfg.Type {
+	case "webhook":
+		return &webhook{
+			url:     cfg.URL,
+			headers: cfg.Headers,
+			client:  &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	case "smtp":
+		return &mailer{cfg: cfg}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", cfg.Type)
+	}
+}
+
+// webhook POSTs {"notices": [...]} as JSON to a URL.
+type webhook struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (wh *webhook) Notify(notices []Notice) error {
+	body, err := json.Marshal(map[string][]Notice{"notices": notices})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", wh.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range wh.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %s", wh.url, resp.Status)
+	}
+	return nil
+}
+
+// sendMail is replaced in tests.
+var sendMail = smtp.SendMail
+
+// mailer sends a plain-text email listing the notices.
+type mailer struct {
+	cfg *NotifierConfig
+}
+
+func (m *mailer) Notify(notices []Notice) error {
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(m.cfg.Server)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, host)
+	}
+
+	subject := "1 certificate is expiring"
+	if len(notices) != 1 {
+		subject = fmt.Sprintf("%d certificates are expiring", len(notices))
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [cfssl] %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, n := range notices {
+		fmt.Fprintf(&msg, "%s\r\n", n)
+	}
+
+	return sendMail(m.cfg.Server, auth, m.cfg.From, m.cfg.To, msg.Bytes())
+}
@@ -0,0 +1,265 @@
+// Package expiry watches issued certificates and sends notifications
+// as they approach expiry.
+//
+// Certificates are gathered from the certificate database, from
+// directories of PEM files and from the chains served by TLS
+// endpoints. When a certificate crosses one of the configured
+// thresholds, a notice is sent to each notifier on the route for its
+// label. Sent notices are recorded in the certificate database so
+// that each is only delivered once per threshold and notifier.
+package expiry
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cloudflare/cfssl/bundler"
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/log"
+)
+
+// A Watcher checks certificates for impending expiry.
+type Watcher struct {
+	cfg       *Config
+	db        certdb.Accessor
+	sent      certdb.ExpiryNotificationAccessor
+	notifiers map[string]Notifier
+	now       func() time.Time
+}
+
+// NewWatcher returns a Watcher for the configuration, keeping its
+// state in db, which must also be a certdb.ExpiryNotificationAccessor.
+func NewWatcher(cfg *Config, db certdb.Accessor) (*Watcher, error) {
+	if db == nil {
+		return nil, errors.New("the expiry watcher needs a certificate database")
+	}
+	sent, ok := db.(certdb.ExpiryNotificationAccessor)
+	if !ok {
+		return nil, errors.New("the certificate database does not record expiry notifications")
+	}
+
+	w := &Watcher{
+		cfg:       cfg,
+		db:        db,
+		sent:      sent,
+		notifiers: map[string]Notifier{},
+		now:       time.Now,
+	}
+	for name, nc := range cfg.Notifiers {
+		n, err := NewNotifier(nc)
+		if err != nil {
+			return nil, err
+		}
+		w.notifiers[name] = n
+	}
+	return w, nil
+}
+
+// fetchChain returns the certificate chain served by an endpoint; it
+// is replaced in tests.
+var fetchChain = func(e Endpoint) ([]*x509.Certificate, error) {
+	// The chain only needs to be fetched, not verified.
+	b := &bundler.Bundler{}
+	bundle, err := b.BundleFromRemote(e.Domain, e.IP, bundler.Force)
+	if err != nil {
+		return nil, err
+	}
+	return bundle.Chain, nil
+}
+
+func certNotice(cert *x509.Certificate, label, source string) Notice {
+	return Notice{
+		Serial:   cert.SerialNumber.String(),
+		AKI:      hex.EncodeToString(cert.AuthorityKeyId),
+		Label:    label,
+		Source:   source,
+		Subject:  cert.Subject.CommonName,
+		DNSNames: cert.DNSNames,
+		NotAfter: cert.NotAfter,
+	}
+}
+
+// collect gathers every watched certificate. Sources that cannot be
+// read are logged and skipped so that one unreachable endpoint does
+// not stop notifications for the rest.
+func (w *Watcher) collect() ([]Notice, error) {
+	var notices []Notice
+
+	records, err := w.db.GetUnexpiredCertificates()
+	if err != nil {
+		return nil, err
+	}
+	for _, cr := range records {
+		n := Notice{
+			Serial:   cr.Serial,
+			AKI:      cr.AKI,
+			Label:    cr.CALabel,
+			Source:   "certdb",
+			NotAfter: cr.Expiry,
+			revoked:  cr.Status == "revoked",
+		}
+		if cert, err := helpers.ParseCertificatePEM([]byte(cr.PEM)); err == nil {
+			n.Subject = cert.Subject.CommonName
+			n.DNSNames = cert.DNSNames
+			n.NotAfter = cert.NotAfter
+		}
+		notices = append(notices, n)
+	}
+
+	for _, dir := range w.cfg.Directories {
+		files, err := ioutil.ReadDir(dir.Path)
+		if err != nil {
+			log.Warningf("failed to read certificate directory %s: %v", dir.Path, err)
+			continue
+		}
+		for _, fi := range files {
+			if fi.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir.Path, fi.Name())
+			in, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Warningf("failed to read %s: %v", path, err)
+				continue
+			}
+			certs, err := helpers.ParseCertificatesPEM(in)
+			if err != nil || len(certs) == 0 {
+				log.Debugf("skipping %s: no certificates found", path)
+				continue
+			}
+			for _, cert := range certs {
+				notices = append(notices, certNotice(cert, dir.Label, path))
+			}
+		}
+	}
+
+	for _, e := range w.cfg.Endpoints {
+		chain, err := fetchChain(e)
+		if err != nil {
+			log.Warningf("failed to fetch certificates from %s: %v", e.Domain, err)
+			continue
+		}
+		for _, cert := range chain {
+			notices = append(notices, certNotice(cert, e.Label, e.Domain))
+		}
+	}
+
+	return notices, nil
+}
+
+// threshold returns the smallest threshold that remaining has
+// crossed, and whether any has been crossed.
+func (w *Watcher) threshold(remaining time.Duration) (time.Duration, bool) {
+	if remaining <= 0 {
+		return 0, true
+	}
+	for _, t := range w.cfg.Thresholds {
+		if remaining <= t {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// Check runs a single pass over all watched certificates, sending any
+// notices that are due.
+func (w *Watcher) Check() error {
+	all, err := w.collect()
+	if err != nil {
+		return err
+	}
+
+	now := w.now()
+	type certKey struct{ serial, aki string }
+	seen := map[certKey]bool{}
+	pending := map[string][]Notice{}
+	for _, n := range all {
+		key := certKey{n.Serial, n.AKI}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if n.revoked {
+			continue
+		}
+
+		t, ok := w.threshold(n.NotAfter.Sub(now))
+		if !ok {
+			continue
+		}
+		n.Threshold = t
+		n.ThresholdString = t.String()
+		n.Expired = !n.NotAfter.After(now)
+
+		sent, err := w.sent.GetExpiryNotifications(n.Serial, n.AKI)
+		if err != nil {
+			return err
+		}
+		for _, name := range w.cfg.route(n.Label) {
+			if !notified(sent, t, name) {
+				pending[name] = append(pending[name], n)
+			}
+		}
+	}
+
+	var failed []string
+	for name, notices := range pending {
+		sort.Slice(notices, func(i, j int) bool { return notices[i].NotAfter.Before(notices[j].NotAfter) })
+		log.Infof("sending %d expiry notices to %s", len(notices), name)
+		if err := w.notifiers[name].Notify(notices); err != nil {
+			log.Errorf("failed to notify %s: %v", name, err)
+			failed = append(failed, name)
+			continue
+		}
+
+		for _, n := range notices {
+			err := w.sent.InsertExpiryNotification(certdb.ExpiryNotificationRecord{
+				Serial:     n.Serial,
+				AKI:        n.AKI,
+				Threshold:  int64(n.Threshold / time.Second),
+				Route:      name,
+				NotifiedAt: now,
+			})
+			if err != nil {
+				log.Errorf("failed to record expiry notice for %s: %v", n.Serial, err)
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("failed to notify %v", failed)
+	}
+	return nil
+}
+
+func notified(sent []certdb.ExpiryNotificationRecord, t time.Duration, route string) bool {
+	for _, er := range sent {
+		if er.Route == route && er.Threshold == int64(t/time.Second) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run checks certificates every configured interval, forever. If no
+// interval is configured, it checks them once.
+func (w *Watcher) Run() error {
+	if w.cfg.Interval <= 0 {
+		return w.Check()
+	}
+
+	for {
+		if err := w.Check(); err != nil {
+			log.Errorf("expiry check failed: %v", err)
+		}
+		time.Sleep(w.cfg.Interval)
+	}
+}
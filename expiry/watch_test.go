@@ -0,0 +1,328 @@
+package expiry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/certdb/sql"
+	"github.com/cloudflare/cfssl/certdb/testdb"
+)
+
+const (
+	sqliteDBFile     = "../certdb/testdb/certstore_development.db"
+	sqliteMigrations = "../certdb/sqlite/migrations"
+)
+
+// newTestDB returns an Accessor for a new, migrated SQLite database, as
+// the checked-in one doesn't record expiry notifications, and a function
+// removing it.
+func newTestDB(t *testing.T) (*sql.Accessor, func()) {
+	dir, err := ioutil.TempDir("", "cfssl_expiry_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := testdb.MigratedSQLiteDB(filepath.Join(dir, "certdb.db"), sqliteMigrations)
+	return sql.NewAccessor(db), func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+var start = time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func makeCert(t *testing.T, serial int64, name string, notAfter time.Time) (*x509.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    start.Add(-time.Hour),
+		NotAfter:     notAfter,
+		// The test database has numeric column affinity, so
+		// use an AKI that does not look like a number.
+		AuthorityKeyId: []byte{0xab, 0xcd, 0xef, 0x01},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func insertCert(t *testing.T, db certdb.Accessor, cert *x509.Certificate, certPEM []byte, label, status string) {
+	err := db.InsertCertificate(certdb.CertificateRecord{
+		Serial:  cert.SerialNumber.String(),
+		AKI:     hex.EncodeToString(cert.AuthorityKeyId),
+		CALabel: label,
+		Status:  status,
+		// GetUnexpiredCertificates compares against the
+		// database's clock, not the watcher's; the watcher
+		// uses the certificate's own expiry.
+		Expiry: time.Now().Add(24 * time.Hour),
+		PEM:    string(certPEM),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// hook collects the notices POSTed to a webhook.
+type hook struct {
+	received [][]Notice
+}
+
+func (h *hook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Notices []Notice `json:"notices"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.received = append(h.received, body.Notices)
+}
+
+func TestWatcher(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+
+	webCert, webPEM := makeCert(t, 100, "web.example.com", start.Add(100*time.Hour))
+	insertCert(t, db, webCert, webPEM, "web", "good")
+	revokedCert, revokedPEM := makeCert(t, 101, "revoked.example.com", start.Add(time.Hour))
+	insertCert(t, db, revokedCert, revokedPEM, "web", "revoked")
+	_, laterPEM := makeCert(t, 102, "later.example.com", start.Add(2000*time.Hour))
+
+	dir, err := ioutil.TempDir("", "expiry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, mailPEM := makeCert(t, 200, "mail.example.com", start.Add(-time.Hour))
+	if err := ioutil.WriteFile(filepath.Join(dir, "mail.pem"), mailPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "later.pem"), laterPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The revoked certificate must not be reported through
+	// another source either.
+	if err := ioutil.WriteFile(filepath.Join(dir, "revoked.pem"), revokedPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	apiCert, _ := makeCert(t, 300, "api.example.com", start.Add(20*time.Hour))
+	defer func(f func(Endpoint) ([]*x509.Certificate, error)) { fetchChain = f }(fetchChain)
+	fetchChain = func(e Endpoint) ([]*x509.Certificate, error) {
+		if e.Domain == "api.example.com" {
+			return []*x509.Certificate{apiCert}, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+
+	var mails []string
+	defer func(f func(string, smtp.Auth, string, []string, []byte) error) { sendMail = f }(sendMail)
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mails = append(mails, string(msg))
+		return nil
+	}
+
+	h := &hook{}
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	cfg := &Config{
+		ThresholdStrings: []string{"24h", "168h"},
+		Directories:      []Directory{{Path: dir, Label: "mail"}},
+		Endpoints: []Endpoint{
+			{Domain: "api.example.com", Label: "web"},
+			{Domain: "down.example.com"},
+		},
+		Notifiers: map[string]*NotifierConfig{
+			"hook": {Type: "webhook", URL: server.URL},
+			"mail": {Type: "smtp", Server: "localhost:25", From: "cfssl@example.com", To: []string{"ops@example.com"}},
+		},
+		Routes: map[string][]string{
+			"web":        {"hook"},
+			DefaultRoute: {"mail"},
+		},
+	}
+	if err := cfg.populate(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWatcher(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = func() time.Time { return start }
+
+	if err = w.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.received) != 1 || len(h.received[0]) != 2 {
+		t.Fatalf("expected two notices on the web route, got %+v", h.received)
+	}
+	api, web := h.received[0][0], h.received[0][1]
+	if api.Subject != "api.example.com" || api.ThresholdString != "24h0m0s" {
+		t.Fatalf("unexpected notice %+v", api)
+	}
+	if web.Subject != "web.example.com" || web.Source != "certdb" || web.ThresholdString != "168h0m0s" {
+		t.Fatalf("unexpected notice %+v", web)
+	}
+
+	if len(mails) != 1 || !strings.Contains(mails[0], "mail.example.com") || !strings.Contains(mails[0], "expired") {
+		t.Fatalf("expected a mail about the expired certificate, got %q", mails)
+	}
+	for _, name := range []string{"revoked", "later"} {
+		if strings.Contains(mails[0], name) {
+			t.Fatalf("did not expect a notice for %s", name)
+		}
+	}
+
+	// Nothing new has crossed a threshold.
+	if err = w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.received) != 1 || len(mails) != 1 {
+		t.Fatal("expected notices to be sent only once")
+	}
+
+	// Later on, the web certificate crosses the next threshold
+	// and the API certificate expires.
+	w.now = func() time.Time { return start.Add(80 * time.Hour) }
+	if err = w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.received) != 2 || len(h.received[1]) != 2 {
+		t.Fatalf("expected the web route to hear about crossed thresholds, got %+v", h.received)
+	}
+	api, web = h.received[1][0], h.received[1][1]
+	if !api.Expired || api.ThresholdString != "0s" {
+		t.Fatalf("unexpected notice %+v", api)
+	}
+	if web.Expired || web.ThresholdString != "24h0m0s" {
+		t.Fatalf("unexpected notice %+v", web)
+	}
+}
+
+func TestWatcherNotifyFailure(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+
+	cert, certPEM := makeCert(t, 400, "web.example.com", start.Add(time.Hour))
+	insertCert(t, db, cert, certPEM, "", "good")
+
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &Config{
+		Notifiers: map[string]*NotifierConfig{"hook": {Type: "webhook", URL: server.URL}},
+		Routes:    map[string][]string{DefaultRoute: {"hook"}},
+	}
+	if err := cfg.populate(); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWatcher(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = func() time.Time { return start }
+
+	if err = w.Check(); err == nil {
+		t.Fatal("expected a failed webhook to be reported")
+	}
+
+	// The notice is retried once the webhook recovers.
+	fail = false
+	if err = w.Check(); err != nil {
+		t.Fatal(err)
+	}
+	sent, err := db.GetExpiryNotifications(cert.SerialNumber.String(), hex.EncodeToString(cert.AuthorityKeyId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0].Threshold != int64((24*time.Hour)/time.Second) {
+		t.Fatalf("expected the notice to be recorded, got %+v", sent)
+	}
+}
+
+func TestWatcherDatabase(t *testing.T) {
+	cfg := &Config{Thresholds: []time.Duration{24 * time.Hour}}
+	if _, err := NewWatcher(cfg, nil); err == nil {
+		t.Fatal("expected a watcher without a database to fail")
+	}
+
+	// An Accessor that doesn't record notifications can't be used.
+	db := struct{ certdb.Accessor }{sql.NewAccessor(testdb.SQLiteDB(sqliteDBFile))}
+	if _, err := NewWatcher(cfg, db); err == nil {
+		t.Fatal("expected a watcher without expiry notifications to fail")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	bad := []*Config{
+		{ThresholdStrings: []string{"a week"}, Routes: map[string][]string{DefaultRoute: {}}},
+		{ThresholdStrings: []string{"-1h"}, Routes: map[string][]string{DefaultRoute: {}}},
+		{Routes: map[string][]string{DefaultRoute: {"missing"}}},
+		{Notifiers: map[string]*NotifierConfig{"x": {Type: "pager"}}, Routes: map[string][]string{DefaultRoute: {"x"}}},
+		{Notifiers: map[string]*NotifierConfig{"x": {Type: "smtp"}}, Routes: map[string][]string{DefaultRoute: {"x"}}},
+		{},
+		// Certificates labelled other than "web" would go unreported.
+		{Notifiers: map[string]*NotifierConfig{"x": {Type: "webhook", URL: "http://localhost"}}, Routes: map[string][]string{"web": {"x"}}},
+		{Notifiers: map[string]*NotifierConfig{"x": {Type: "webhook", URL: "http://localhost"}}, Routes: map[string][]string{"web": {"x"}, DefaultRoute: {}}},
+	}
+	for i, cfg := range bad {
+		if err := cfg.populate(); err == nil {
+			t.Fatalf("expected configuration %d to be rejected", i)
+		}
+	}
+
+	cfg := &Config{
+		IntervalString: "1h",
+		Notifiers:      map[string]*NotifierConfig{"x": {Type: "webhook", URL: "http://localhost"}},
+		Routes:         map[string][]string{"web": {}, DefaultRoute: {"x"}},
+	}
+	if err := cfg.populate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Interval != time.Hour || len(cfg.Thresholds) != 3 || cfg.Thresholds[0] != 24*time.Hour {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+	if names := cfg.route("mail"); len(names) != 1 || names[0] != "x" {
+		t.Fatalf("unrouted label went to %v rather than the default route", names)
+	}
+}
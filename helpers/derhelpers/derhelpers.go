@@ -5,8 +5,12 @@ package derhelpers
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
 
 	cferr "github.com/cloudflare/cfssl/errors"
 )
@@ -40,3 +44,48 @@ func ParsePrivateKeyDER(keyDER []byte) (key crypto.Signer, err error) {
 	// should never reach here
 	return nil, cferr.New(cferr.PrivateKeyError, cferr.ParseFailed)
 }
+
+var (
+	oidPublicKeyRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+	oidNamedCurves = map[elliptic.Curve]asn1.ObjectIdentifier{
+		elliptic.P224(): {1, 3, 132, 0, 33},
+		elliptic.P256(): {1, 2, 840, 10045, 3, 1, 7},
+		elliptic.P384(): {1, 3, 132, 0, 34},
+		elliptic.P521(): {1, 3, 132, 0, 35},
+	}
+)
+
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// MarshalPKCS8PrivateKey returns the DER encoding of an RSA or ECDSA
+// private key as an unencrypted PKCS #8 PrivateKeyInfo.
+func MarshalPKCS8PrivateKey(key crypto.PrivateKey) ([]byte, error) {
+	var info pkcs8
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		info.Algo = pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyRSA, Parameters: asn1.RawValue{Tag: asn1.TagNull}}
+		info.PrivateKey = x509.MarshalPKCS1PrivateKey(k)
+	case *ecdsa.PrivateKey:
+		oid, ok := oidNamedCurves[k.Curve]
+		if !ok {
+			return nil, errors.New("derhelpers: unsupported elliptic curve")
+		}
+		params, err := asn1.Marshal(oid)
+		if err != nil {
+			return nil, err
+		}
+		info.Algo = pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}}
+		if info.PrivateKey, err = x509.MarshalECPrivateKey(k); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("derhelpers: only RSA and ECDSA private keys are supported")
+	}
+	return asn1.Marshal(info)
+}
@@ -16,6 +16,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 
 	"github.com/google/certificate-transparency-go"
@@ -27,6 +28,7 @@ import (
 	"time"
 
 	"github.com/cloudflare/cfssl/crypto/pkcs7"
+	"github.com/cloudflare/cfssl/crypto/pkcs8"
 	cferr "github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/helpers/derhelpers"
 	"github.com/cloudflare/cfssl/log"
@@ -353,7 +355,8 @@ func ParsePrivateKeyPEM(keyPEM []byte) (key crypto.Signer, err error) {
 
 // ParsePrivateKeyPEMWithPassword parses and returns a PEM-encoded private
 // key. The private key may be a potentially encrypted PKCS#8, PKCS#1,
-// or elliptic private key.
+// or elliptic private key; encrypted PKCS#8 keys may use PBES2 with
+// scrypt or PBKDF2, as written by EncryptPrivateKeyPEM.
 func ParsePrivateKeyPEMWithPassword(keyPEM []byte, password []byte) (key crypto.Signer, err error) {
 	keyDER, err := GetKeyDERFromPEM(keyPEM, password)
 	if err != nil {
@@ -367,6 +370,17 @@ func ParsePrivateKeyPEMWithPassword(keyPEM []byte, password []byte) (key crypto.
 func GetKeyDERFromPEM(in []byte, password []byte) ([]byte, error) {
 	keyDER, _ := pem.Decode(in)
 	if keyDER != nil {
+		if keyDER.Type == pkcs8.PEMType {
+			if password == nil {
+				return nil, cferr.New(cferr.PrivateKeyError, cferr.Encrypted)
+			}
+			der, err := pkcs8.DecryptPrivateKey(keyDER.Bytes, password)
+			if err != nil {
+				// As in derhelpers, the reason is withheld.
+				return nil, cferr.New(cferr.PrivateKeyError, cferr.DecodeFailed)
+			}
+			return der, nil
+		}
 		if procType, ok := keyDER.Headers["Proc-Type"]; ok {
 			if strings.Contains(procType, "ENCRYPTED") {
 				if password != nil {
@@ -381,6 +395,59 @@ func GetKeyDERFromPEM(in []byte, password []byte) ([]byte, error) {
 	return nil, cferr.New(cferr.PrivateKeyError, cferr.DecodeFailed)
 }
 
+// EncryptPrivateKeyPEM encrypts a PEM-encoded private key with password,
+// returning it as an "ENCRYPTED PRIVATE KEY" PEM block: a PKCS#8
+// EncryptedPrivateKeyInfo using PBES2 with scrypt and the named cipher,
+// "aes256-cbc" (the default) or "aes256-gcm".
+func EncryptPrivateKeyPEM(keyPEM, password []byte, cipher string) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.Unknown, errors.New("empty private key password"))
+	}
+	c, err := pkcs8.ParseCipher(cipher)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.Unknown, err)
+	}
+	key, err := ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := pkcs8.EncryptPrivateKeyPEM(key, password, c)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.Unknown, err)
+	}
+	return encrypted, nil
+}
+
+// PrivateKeyPassword returns the password decrypting CA and responder
+// private keys, read from the CFSSL_CA_PK_PASSWORD environment variable
+// or, if that is unset, from the file named by CFSSL_CA_PK_PASSWORD_FILE
+// with trailing newlines removed. It returns nil if neither is set.
+func PrivateKeyPassword() ([]byte, error) {
+	if password := os.Getenv("CFSSL_CA_PK_PASSWORD"); password != "" {
+		return []byte(password), nil
+	}
+	passwordFile := os.Getenv("CFSSL_CA_PK_PASSWORD_FILE")
+	if passwordFile == "" {
+		return nil, nil
+	}
+	password, err := ioutil.ReadFile(passwordFile)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.ReadFailed, err)
+	}
+	return bytes.TrimRight(password, "\r\n"), nil
+}
+
+// ParsePrivateKeyPEMFromEnv is like ParsePrivateKeyPEMWithPassword, with
+// the password given by PrivateKeyPassword. Every loader of CA and
+// responder keys uses it, so encrypted keys are accepted consistently.
+func ParsePrivateKeyPEMFromEnv(keyPEM []byte) (crypto.Signer, error) {
+	password, err := PrivateKeyPassword()
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKeyPEMWithPassword(keyPEM, password)
+}
+
 // ParseCSR parses a PEM- or DER-encoded PKCS #10 certificate signing request.
 func ParseCSR(in []byte) (csr *x509.CertificateRequest, rest []byte, err error) {
 	in = bytes.TrimSpace(in)
@@ -552,6 +619,47 @@ func SCTListFromOCSPResponse(response *ocsp.Response) ([]ct.SignedCertificateTim
 	return sctList, err
 }
 
+// CertificateURIs returns the URIs among the subject alternative names
+// of cert, which x509.Certificate only parses from Go 1.10.
+func CertificateURIs(cert *x509.Certificate) ([]*url.URL, error) {
+	// sanOid is the ObjectIdentifier of the subject alternative name
+	// extension.
+	sanOid := asn1.ObjectIdentifier{2, 5, 29, 17}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(sanOid) {
+			continue
+		}
+
+		var seq asn1.RawValue
+		rest, err := asn1.Unmarshal(ext.Value, &seq)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+		}
+		if len(rest) != 0 || !seq.IsCompound || seq.Class != asn1.ClassUniversal || seq.Tag != asn1.TagSequence {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, errors.New("malformed subject alternative names"))
+		}
+
+		var uris []*url.URL
+		for rest = seq.Bytes; len(rest) > 0; {
+			var name asn1.RawValue
+			if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+				return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+			}
+			// A uniformResourceIdentifier is [6] IA5String.
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 6 {
+				continue
+			}
+			uri, err := url.Parse(string(name.Bytes))
+			if err != nil {
+				return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+			}
+			uris = append(uris, uri)
+		}
+		return uris, nil
+	}
+	return nil, nil
+}
+
 // ReadBytes reads a []byte either from a file or an environment variable.
 // If valFile has a prefix of 'env:', the []byte is read from the environment
 // using the subsequent name. If the prefix is 'file:' the []byte is read from
@@ -12,11 +12,15 @@ import (
 	"encoding/pem"
 	"io/ioutil"
 	"math"
+	"math/big"
+	"os"
+	"reflect"
 	"testing"
 	"time"
 
 	"golang.org/x/crypto/ocsp"
 
+	cferr "github.com/cloudflare/cfssl/errors"
 	"github.com/google/certificate-transparency-go"
 )
 
@@ -627,3 +631,126 @@ func TestSCTListFromOCSPResponse(t *testing.T) {
 		t.Fatal("SCTs don't match")
 	}
 }
+
+func TestParseEncryptedPKCS8PrivateKey(t *testing.T) {
+	keyPEM, err := ioutil.ReadFile(testPrivateECDSAKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cipher := range []string{"aes256-cbc", "aes256-gcm"} {
+		encPEM, err := EncryptPrivateKeyPEM(keyPEM, []byte("password"), cipher)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := ParsePrivateKeyPEMWithPassword(encPEM, []byte("password"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decrypted.Public(), key.Public()) {
+			t.Fatal("encrypted key does not round trip")
+		}
+
+		_, err = ParsePrivateKeyPEM(encPEM)
+		if cfErr, ok := err.(*cferr.Error); !ok || cfErr.ErrorCode != cferr.New(cferr.PrivateKeyError, cferr.Encrypted).ErrorCode {
+			t.Fatalf("expected an encrypted key error, got %v", err)
+		}
+		if cipher == "aes256-gcm" {
+			if _, err = ParsePrivateKeyPEMWithPassword(encPEM, []byte("wrong")); err == nil {
+				t.Fatal("expected decryption with the wrong password to fail")
+			}
+		}
+	}
+
+	if _, err = EncryptPrivateKeyPEM(keyPEM, nil, ""); err == nil {
+		t.Fatal("expected an empty password to be rejected")
+	}
+	if _, err = EncryptPrivateKeyPEM(keyPEM, []byte("password"), "rot13"); err == nil {
+		t.Fatal("expected an unsupported cipher to be rejected")
+	}
+}
+
+func TestPrivateKeyPassword(t *testing.T) {
+	defer os.Unsetenv("CFSSL_CA_PK_PASSWORD")
+	defer os.Unsetenv("CFSSL_CA_PK_PASSWORD_FILE")
+
+	os.Unsetenv("CFSSL_CA_PK_PASSWORD")
+	os.Unsetenv("CFSSL_CA_PK_PASSWORD_FILE")
+	if password, err := PrivateKeyPassword(); err != nil || password != nil {
+		t.Fatalf("expected no password, got %q, %v", password, err)
+	}
+
+	f, err := ioutil.TempFile("", "cfssl-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("from file\n")
+	f.Close()
+
+	os.Setenv("CFSSL_CA_PK_PASSWORD_FILE", f.Name())
+	if password, err := PrivateKeyPassword(); err != nil || string(password) != "from file" {
+		t.Fatalf("expected the password from the file, got %q, %v", password, err)
+	}
+	os.Setenv("CFSSL_CA_PK_PASSWORD", "from env")
+	if password, err := PrivateKeyPassword(); err != nil || string(password) != "from env" {
+		t.Fatalf("expected the password from the environment, got %q, %v", password, err)
+	}
+}
+
+func TestCertificateURIs(t *testing.T) {
+	san, err := asn1.Marshal([]asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("api.example.com")},
+		{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte("spiffe://example.com/api")},
+		{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte("https://example.com/")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 17}, Value: san}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uris, err := CertificateURIs(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uris) != 2 || uris[0].String() != "spiffe://example.com/api" || uris[1].String() != "https://example.com/" {
+		t.Fatalf("unexpected URIs %v", uris)
+	}
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "api.example.com" {
+		t.Fatalf("unexpected DNS names %v", cert.DNSNames)
+	}
+
+	// A certificate without subject alternative names has no URIs.
+	template.ExtraExtensions = nil
+	if der, err = x509.CreateCertificate(rand.Reader, template, template, key.Public(), key); err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	if uris, err = CertificateURIs(cert); err != nil || len(uris) != 0 {
+		t.Fatalf("unexpected URIs %v (%v)", uris, err)
+	}
+}
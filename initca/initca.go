@@ -103,7 +103,7 @@ func NewFromPEM(req *csr.CertificateRequest, keyFile string) (cert, csrPEM []byt
 		return nil, nil, err
 	}
 
-	priv, err := helpers.ParsePrivateKeyPEM(privData)
+	priv, err := helpers.ParsePrivateKeyPEMFromEnv(privData)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -132,7 +132,7 @@ func RenewFromPEM(caFile, keyFile string) ([]byte, error) {
 		return nil, err
 	}
 
-	key, err := helpers.ParsePrivateKeyPEM(keyBytes)
+	key, err := helpers.ParsePrivateKeyPEMFromEnv(keyBytes)
 	if err != nil {
 		return nil, err
 	}
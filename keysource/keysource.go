@@ -0,0 +1,178 @@
+// Package keysource loads CA private keys that are stored encrypted
+// at rest and unwrapped at startup by a key-encryption service.
+//
+// A key is named by a URL-style spec such as
+// "kmsfile://ca/ca-key.wrapped". The scheme selects the Source used
+// to unwrap the key, and the remainder of the URL names the file on
+// disk holding the wrapped key. Relative paths are supported: the
+// first path element is parsed as the URL's host and is joined back
+// onto the path.
+//
+// Sources are configured with a string map; this is the per-root
+// section of a multirootca configuration, or the Config of a
+// universal.Root. Any setting missing from the map is looked up in
+// the environment as CFSSL_ followed by the upper-cased setting name,
+// so kms_url may also be given as CFSSL_KMS_URL.
+package keysource
+
+import (
+	"crypto"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/helpers/derhelpers"
+	"github.com/cloudflare/cfssl/log"
+)
+
+// A Source unwraps private keys that were encrypted at rest.
+type Source interface {
+	// Unwrap returns the plaintext private key, in PEM or DER
+	// form, contained in wrapped.
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// A Wrapper is a Source that can also produce wrapped keys.
+type Wrapper interface {
+	Source
+
+	// Wrap encrypts the plaintext private key in key.
+	Wrap(key []byte) ([]byte, error)
+}
+
+// A Factory builds a Source from its configuration.
+type Factory func(cfg map[string]string) (Source, error)
+
+var (
+	// ErrUnsupportedScheme indicates a private key scheme that
+	// has no registered Source.
+	ErrUnsupportedScheme = errors.New("keysource: unsupported private key scheme")
+
+	lock      sync.RWMutex
+	factories = map[string]Factory{}
+)
+
+// Register makes a Source available under the given URL scheme,
+// replacing any Source previously registered for it.
+func Register(scheme string, factory Factory) {
+	lock.Lock()
+	defer lock.Unlock()
+	factories[strings.ToLower(scheme)] = factory
+}
+
+func lookup(scheme string) (Factory, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	factory, ok := factories[strings.ToLower(scheme)]
+	return factory, ok
+}
+
+// IsSpec returns true if spec is a URL whose scheme has a registered
+// Source. Plain file names and "env:" specs are not key source specs.
+func IsSpec(spec string) bool {
+	if !strings.Contains(spec, "://") {
+		return false
+	}
+	specURL, err := url.Parse(spec)
+	if err != nil {
+		return false
+	}
+	_, ok := lookup(specURL.Scheme)
+	return ok
+}
+
+// Get returns the Source registered for scheme, configured from cfg.
+func Get(scheme string, cfg map[string]string) (Source, error) {
+	factory, ok := lookup(scheme)
+	if !ok {
+		return nil, ErrUnsupportedScheme
+	}
+	return factory(cfg)
+}
+
+// Setting returns the named setting from cfg, falling back to the
+// CFSSL_<NAME> environment variable.
+func Setting(cfg map[string]string, name string) string {
+	if v := cfg[name]; v != "" {
+		return v
+	}
+	return os.Getenv("CFSSL_" + strings.ToUpper(name))
+}
+
+// Read loads the file named by spec and returns the unwrapped key.
+func Read(spec string, cfg map[string]string) ([]byte, error) {
+	specURL, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := Get(specURL.Scheme, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// A relative path will be parsed such that its first
+	// directory is stored as the hostname, and the remainder of
+	// the path is stored in the Path field.
+	path := filepath.Join(specURL.Host, specURL.Path)
+	log.Debugf("loading %s private key file %s", specURL.Scheme, path)
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return src.Unwrap(in)
+}
+
+// Load loads the file named by spec, unwraps it, and parses the
+// resulting PEM- or DER-encoded private key.
+func Load(spec string, cfg map[string]string) (crypto.Signer, error) {
+	in, err := Read(spec, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePrivateKey(in)
+}
+
+// ParsePrivateKey parses a PEM-encoded private key, falling back to
+// DER if in is not PEM.
+func ParsePrivateKey(in []byte) (crypto.Signer, error) {
+	log.Debug("attempting to load PEM-encoded private key")
+	priv, err := helpers.ParsePrivateKeyPEMFromEnv(in)
+	if err != nil {
+		log.Debug("file is not a PEM-encoded private key")
+		log.Debug("attempting to load DER-encoded private key")
+		priv, err = derhelpers.ParsePrivateKeyDER(in)
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Debug("loaded private key")
+	return priv, nil
+}
+
+// fileSource is the identity Source: keys are stored in the clear.
+type fileSource struct{}
+
+func (fileSource) Unwrap(wrapped []byte) ([]byte, error) {
+	return wrapped, nil
+}
+
+func (fileSource) Wrap(key []byte) ([]byte, error) {
+	return key, nil
+}
+
+func init() {
+	Register("file", func(map[string]string) (Source, error) {
+		return fileSource{}, nil
+	})
+	Register("rofile", newRedOctober)
+	Register("wrapfile", newWrapFile)
+	Register("kmsfile", newKMS)
+}
@@ -0,0 +1,177 @@
+package keysource
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKeyFile = "../signer/local/testdata/ca_key.pem"
+
+// tempDir returns a new temporary directory and a function removing it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "keysource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func writeTemp(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIsSpec(t *testing.T) {
+	for spec, expected := range map[string]bool{
+		"file://testdata/server.key": true,
+		"rofile:///ca/ca-key.ro":     true,
+		"wrapfile://ca/ca-key.pem":   true,
+		"kmsfile://ca/ca-key.pem":    true,
+		"ksm://test-signer":          false,
+		"testdata/server.key":        false,
+		"env:CFSSL_CA_KEY":           false,
+		"://nothing":                 false,
+	} {
+		if IsSpec(spec) != expected {
+			t.Fatalf("IsSpec(%q) should be %v", spec, expected)
+		}
+	}
+
+	if _, err := Load("ksm://test-signer", nil); err != ErrUnsupportedScheme {
+		t.Fatalf("expected ErrUnsupportedScheme, got %v", err)
+	}
+}
+
+func TestSetting(t *testing.T) {
+	os.Setenv("CFSSL_KMS_TOKEN", "from-env")
+	defer os.Unsetenv("CFSSL_KMS_TOKEN")
+
+	if v := Setting(map[string]string{"kms_token": "from-config"}, "kms_token"); v != "from-config" {
+		t.Fatalf("expected the configuration to take precedence, got %q", v)
+	}
+	if v := Setting(nil, "kms_token"); v != "from-env" {
+		t.Fatalf("expected the environment to be used, got %q", v)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	priv, err := Load("file://"+testKeyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := priv.(*rsa.PrivateKey); !ok {
+		t.Fatal("expected an RSA private key")
+	}
+}
+
+func TestWrapFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	keyPEM, err := ioutil.ReadFile(testKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapPriv, wrapPub, err := GenerateWrappingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys can be wrapped knowing only the public half.
+	wrapper, err := NewWrapFile(wrapPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := wrapper.Wrap(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(wrapped, keyPEM[100:140]) {
+		t.Fatal("wrapped key contains the plaintext")
+	}
+	if _, err = wrapper.Unwrap(wrapped); err == nil {
+		t.Fatal("expected unwrapping with the public key to fail")
+	}
+
+	cfg := map[string]string{"wrap_key": writeTemp(t, dir, "wrap.pem", wrapPriv)}
+	priv, err := Load("wrapfile://"+writeTemp(t, dir, "ca-key.wrapped", wrapped), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := priv.(*rsa.PrivateKey); !ok {
+		t.Fatal("expected an RSA private key")
+	}
+
+	otherPriv, _, err := GenerateWrappingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewWrapFile(otherPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Unwrap(wrapped); err == nil {
+		t.Fatal("expected unwrapping with another wrapping key to fail")
+	}
+
+	if _, err = Get("wrapfile", nil); err == nil {
+		t.Fatal("expected a missing wrap_key to fail")
+	}
+}
+
+func TestKMS(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	keyPEM, err := ioutil.ReadFile(testKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub, err := NewKMSStub("secret", "ca-kek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	wrapper := NewKMS(server.URL, "ca-kek", "secret", nil)
+	wrapped, err := wrapper.Wrap(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the key ID is needed to unwrap, and it is stored in
+	// the wrapped key.
+	cfg := map[string]string{"kms_url": server.URL, "kms_token": "secret"}
+	spec := "kmsfile://" + writeTemp(t, dir, "ca-key.wrapped", wrapped)
+	priv, err := Load(spec, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := priv.(*rsa.PrivateKey); !ok {
+		t.Fatal("expected an RSA private key")
+	}
+
+	cfg["kms_token"] = "wrong"
+	if _, err = Load(spec, cfg); err == nil {
+		t.Fatal("expected a bad KMS token to fail")
+	}
+
+	if _, err = NewKMS(server.URL, "unknown", "secret", nil).Wrap(keyPEM); err == nil {
+		t.Fatal("expected wrapping with an unknown KMS key to fail")
+	}
+
+	if _, err = Get("kmsfile", nil); err == nil {
+		t.Fatal("expected a missing kms_url to fail")
+	}
+}
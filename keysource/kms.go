@@ -0,0 +1,196 @@
+package keysource
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/log"
+)
+
+const algorithmKMS = "KMS-AES-256-GCM"
+
+// kmsMessage is the body of both requests and responses in the HTTP
+// KMS protocol.
+//
+// A key is wrapped by POSTing {"plaintext": ...} to
+// <kms_url>/v1/keys/<key id>/wrap, which returns {"ciphertext": ...};
+// it is unwrapped by POSTing {"ciphertext": ...} to
+// <kms_url>/v1/keys/<key id>/unwrap, which returns {"plaintext": ...}.
+// Binary values are base64-encoded. Failed requests return a non-200
+// status and {"error": ...}.
+type kmsMessage struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// kms envelope-encrypts keys: each key is encrypted locally with a
+// random AES-256-GCM data key, and the data key is wrapped by the
+// KMS. Only the wrapped data key is ever sent to the KMS.
+type kms struct {
+	url    string
+	keyID  string
+	token  string
+	client *http.Client
+}
+
+// newKMS configures a kmsfile source from the kms_url, kms_key_id,
+// kms_token and kms_ca settings. Only kms_url is required to unwrap
+// keys, as the key ID is stored alongside the wrapped key.
+func newKMS(cfg map[string]string) (Source, error) {
+	kmsURL := Setting(cfg, "kms_url")
+	if kmsURL == "" {
+		return nil, errors.New("keysource: no KMS server available")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if caFile := Setting(cfg, "kms_ca"); caFile != "" {
+		pool, err := helpers.LoadPEMCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+	}
+
+	return NewKMS(kmsURL, Setting(cfg, "kms_key_id"), Setting(cfg, "kms_token"), client), nil
+}
+
+// NewKMS returns a Wrapper that envelope-encrypts keys using the KMS
+// at kmsURL. New keys are wrapped with keyID; token, if not empty, is
+// sent as a bearer token. If client is nil, http.DefaultClient is
+// used.
+func NewKMS(kmsURL, keyID, token string, client *http.Client) Wrapper {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &kms{
+		url:    strings.TrimSuffix(kmsURL, "/"),
+		keyID:  keyID,
+		token:  token,
+		client: client,
+	}
+}
+
+func (k *kms) call(keyID, op string, req kmsMessage) (*kmsMessage, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/keys/%s/%s", k.url, url.PathEscape(keyID), op)
+	httpReq, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if k.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+k.token)
+	}
+
+	log.Debugf("requesting %s of data key from %s", op, endpoint)
+	resp, err := k.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var msg kmsMessage
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&msg); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if msg.Error == "" {
+			msg.Error = resp.Status
+		}
+		return nil, fmt.Errorf("keysource: KMS %s failed: %s", op, msg.Error)
+	}
+	return &msg, nil
+}
+
+func (k *kms) Wrap(key []byte) ([]byte, error) {
+	if k.keyID == "" {
+		return nil, errors.New("keysource: no KMS key ID available")
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	resp, err := k.call(k.keyID, "wrap", kmsMessage{Plaintext: dek})
+	if err != nil {
+		return nil, err
+	}
+
+	block := &pem.Block{
+		Type: PEMType,
+		Headers: map[string]string{
+			"Wrap-Algorithm": algorithmKMS,
+			"Key-Id":         k.keyID,
+			"Wrapped-Dek":    base64.StdEncoding.EncodeToString(resp.Ciphertext),
+		},
+		Bytes: aead.Seal(nonce, nonce, key, []byte(k.keyID)),
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func (k *kms) Unwrap(wrapped []byte) ([]byte, error) {
+	block, err := decodeWrapped(wrapped, algorithmKMS)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := block.Headers["Key-Id"]
+	if keyID == "" {
+		return nil, errors.New("keysource: wrapped key has no KMS key ID")
+	}
+
+	wrappedDEK, err := base64.StdEncoding.DecodeString(block.Headers["Wrapped-Dek"])
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := k.call(keyID, "unwrap", kmsMessage{Ciphertext: wrappedDEK})
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(resp.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if len(block.Bytes) < aead.NonceSize() {
+		return nil, errors.New("keysource: wrapped key is truncated")
+	}
+
+	nonce, ciphertext := block.Bytes[:aead.NonceSize()], block.Bytes[aead.NonceSize():]
+	key, err := aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, errors.New("keysource: failed to unwrap key")
+	}
+	return key, nil
+}
@@ -0,0 +1,109 @@
+package keysource
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// KMSStub is an in-memory server for the HTTP KMS protocol used by
+// the kmsfile source. Its key-encryption keys never leave memory, so
+// it is only suitable for tests and local development.
+type KMSStub struct {
+	// Token, if not empty, is the bearer token clients must
+	// present.
+	Token string
+
+	lock sync.Mutex
+	keys map[string][]byte
+}
+
+// NewKMSStub returns a KMSStub holding a fresh key-encryption key for
+// each of keyIDs.
+func NewKMSStub(token string, keyIDs ...string) (*KMSStub, error) {
+	stub := &KMSStub{Token: token, keys: map[string][]byte{}}
+	for _, id := range keyIDs {
+		kek := make([]byte, 32)
+		if _, err := rand.Read(kek); err != nil {
+			return nil, err
+		}
+		stub.keys[id] = kek
+	}
+	return stub, nil
+}
+
+func stubError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(kmsMessage{Error: msg})
+}
+
+// ServeHTTP implements the wrap and unwrap endpoints.
+func (s *KMSStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		stubError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+		stubError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/keys/")
+	i := strings.LastIndex(path, "/")
+	if path == r.URL.Path || i < 0 {
+		stubError(w, http.StatusNotFound, "not found")
+		return
+	}
+	keyID, op := path[:i], path[i+1:]
+
+	s.lock.Lock()
+	kek, ok := s.keys[keyID]
+	s.lock.Unlock()
+	if !ok {
+		stubError(w, http.StatusNotFound, "unknown key "+keyID)
+		return
+	}
+
+	var req kmsMessage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		stubError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	aead, err := newGCM(kek)
+	if err != nil {
+		stubError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var resp kmsMessage
+	switch op {
+	case "wrap":
+		nonce := make([]byte, aead.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			stubError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp.Ciphertext = aead.Seal(nonce, nonce, req.Plaintext, []byte(keyID))
+	case "unwrap":
+		if len(req.Ciphertext) < aead.NonceSize() {
+			stubError(w, http.StatusBadRequest, "ciphertext is truncated")
+			return
+		}
+		nonce, ciphertext := req.Ciphertext[:aead.NonceSize()], req.Ciphertext[aead.NonceSize():]
+		resp.Plaintext, err = aead.Open(nil, nonce, ciphertext, []byte(keyID))
+		if err != nil {
+			stubError(w, http.StatusBadRequest, "unwrap failed")
+			return
+		}
+	default:
+		stubError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,58 @@
+package keysource
+
+import (
+	"errors"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/redoctober/client"
+	"github.com/cloudflare/redoctober/core"
+)
+
+// redOctober unwraps keys that were encrypted with Red October.
+type redOctober struct {
+	client *client.RemoteServer
+	user   string
+	pass   string
+}
+
+// newRedOctober configures a Red October source from the ro_server,
+// ro_ca, ro_user and ro_pass settings. Unlike those of the other
+// sources, they are only taken from cfg, never from the environment.
+func newRedOctober(cfg map[string]string) (Source, error) {
+	log.Warning("Red October support is currently experimental")
+	roServer := cfg["ro_server"]
+	if roServer == "" {
+		return nil, errors.New("keysource: no RedOctober server available")
+	}
+
+	// roCAPath can be empty; if it is, the client uses
+	// the system default CA roots.
+	roCAPath := cfg["ro_ca"]
+
+	roUser := cfg["ro_user"]
+	if roUser == "" {
+		return nil, errors.New("keysource: no RedOctober user available")
+	}
+
+	roPass := cfg["ro_pass"]
+	if roPass == "" {
+		return nil, errors.New("keysource: no RedOctober passphrase available")
+	}
+
+	roClient, err := client.NewRemoteServer(roServer, roCAPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redOctober{client: roClient, user: roUser, pass: roPass}, nil
+}
+
+func (ro *redOctober) Unwrap(wrapped []byte) ([]byte, error) {
+	log.Debug("decrypting key via RedOctober Server")
+	req := core.DecryptRequest{
+		Name:     ro.user,
+		Password: ro.pass,
+		Data:     wrapped,
+	}
+	return ro.client.DecryptIntoData(req)
+}
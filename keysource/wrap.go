@@ -0,0 +1,278 @@
+package keysource
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// PEMType is the PEM block type used for wrapped keys.
+	PEMType = "CFSSL WRAPPED KEY"
+
+	algorithmX25519 = "X25519-HKDF-SHA256-AES-256-GCM"
+	wrapInfo        = "cfssl wrapped key"
+)
+
+// decodeWrapped returns the wrapped key block in wrapped, checking
+// that it was produced with algorithm.
+func decodeWrapped(wrapped []byte, algorithm string) (*pem.Block, error) {
+	block, _ := pem.Decode(wrapped)
+	if block == nil || block.Type != PEMType {
+		return nil, errors.New("keysource: no wrapped key found")
+	}
+	if block.Headers["Wrap-Algorithm"] != algorithm {
+		return nil, errors.New("keysource: key was not wrapped with " + algorithm)
+	}
+	return block, nil
+}
+
+// newGCM returns an AES-256-GCM AEAD keyed with key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapFile wraps keys to a local X25519 wrapping key, in the manner
+// of age: each wrapped key carries an ephemeral public key, and the
+// X25519 shared secret is run through HKDF-SHA256 to derive a
+// single-use AES-256-GCM key.
+type wrapFile struct {
+	priv *[32]byte
+	pub  *[32]byte
+}
+
+// newWrapFile configures a wrapfile source from the wrap_key
+// setting, which names the wrapping key file.
+func newWrapFile(cfg map[string]string) (Source, error) {
+	path := Setting(cfg, "wrap_key")
+	if path == "" {
+		return nil, errors.New("keysource: no wrapping key available")
+	}
+
+	in, err := helpers.ReadBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewWrapFile(in)
+}
+
+// oidX25519 identifies X25519 keys (RFC 8410).
+var oidX25519 = asn1.ObjectIdentifier{1, 3, 101, 110}
+
+// pkcs8 is a PKCS #8 private key, as produced by openssl genpkey.
+type pkcs8 struct {
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// pkixPublicKey is a PKIX SubjectPublicKeyInfo.
+type pkixPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// NewWrapFile returns a Wrapper for a PEM-encoded X25519 wrapping
+// key. A PKCS #8 private key can both wrap and unwrap; a PKIX public
+// key can only be used to wrap keys.
+func NewWrapFile(keyPEM []byte) (Wrapper, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("keysource: no wrapping key found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		var key pkcs8
+		if _, err := asn1.Unmarshal(block.Bytes, &key); err != nil {
+			return nil, err
+		}
+		if !key.Algorithm.Algorithm.Equal(oidX25519) {
+			return nil, errors.New("keysource: wrapping key is not an X25519 key")
+		}
+		var raw []byte
+		if _, err := asn1.Unmarshal(key.PrivateKey, &raw); err != nil {
+			return nil, err
+		}
+		if len(raw) != 32 {
+			return nil, errors.New("keysource: invalid X25519 private key")
+		}
+		priv, pub := new([32]byte), new([32]byte)
+		copy(priv[:], raw)
+		curve25519.ScalarBaseMult(pub, priv)
+		return &wrapFile{priv: priv, pub: pub}, nil
+	case "PUBLIC KEY":
+		var key pkixPublicKey
+		if _, err := asn1.Unmarshal(block.Bytes, &key); err != nil {
+			return nil, err
+		}
+		if !key.Algorithm.Algorithm.Equal(oidX25519) {
+			return nil, errors.New("keysource: wrapping key is not an X25519 key")
+		}
+		if len(key.PublicKey.Bytes) != 32 {
+			return nil, errors.New("keysource: invalid X25519 public key")
+		}
+		pub := new([32]byte)
+		copy(pub[:], key.PublicKey.Bytes)
+		return &wrapFile{pub: pub}, nil
+	default:
+		return nil, errors.New("keysource: unsupported wrapping key type " + block.Type)
+	}
+}
+
+// generateX25519 returns a new X25519 key pair.
+func generateX25519() (priv, pub *[32]byte, err error) {
+	priv, pub = new([32]byte), new([32]byte)
+	if _, err = io.ReadFull(rand.Reader, priv[:]); err != nil {
+		return nil, nil, err
+	}
+	curve25519.ScalarBaseMult(pub, priv)
+	return priv, pub, nil
+}
+
+// GenerateWrappingKey returns a new PEM-encoded X25519 wrapping key
+// and its public half.
+func GenerateWrappingKey() (keyPEM, pubPEM []byte, err error) {
+	priv, pub, err := generateX25519()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, err := asn1.Marshal(priv[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := asn1.Marshal(pkcs8{
+		Algorithm:  pkix.AlgorithmIdentifier{Algorithm: oidX25519},
+		PrivateKey: raw,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	der, err = asn1.Marshal(pkixPublicKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidX25519},
+		PublicKey: asn1.BitString{Bytes: pub[:], BitLength: 256},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	pubPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	return keyPEM, pubPEM, nil
+}
+
+func fingerprint(pub *[32]byte) string {
+	sum := sha256.Sum256(pub[:])
+	return hex.EncodeToString(sum[:8])
+}
+
+// x25519 returns the shared secret of a private and public key,
+// rejecting the all-zero secret produced by low-order points.
+func x25519(priv, pub *[32]byte) ([]byte, error) {
+	var shared, zero [32]byte
+	curve25519.ScalarMult(&shared, priv, pub)
+	if subtle.ConstantTimeCompare(shared[:], zero[:]) == 1 {
+		return nil, errors.New("keysource: invalid X25519 public key")
+	}
+	return shared[:], nil
+}
+
+// wrapKey derives the AEAD for an ephemeral and recipient key pair.
+func wrapKey(shared []byte, ephemeral, recipient *[32]byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeral[:]...), recipient[:]...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(wrapInfo)), key); err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+func (wf *wrapFile) Wrap(key []byte) ([]byte, error) {
+	ephemeralPriv, ephemeral, err := generateX25519()
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := x25519(ephemeralPriv, wf.pub)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := wrapKey(shared, ephemeral, wf.pub)
+	if err != nil {
+		return nil, err
+	}
+
+	// The AEAD key is never reused, so a fixed nonce is safe.
+	nonce := make([]byte, aead.NonceSize())
+	block := &pem.Block{
+		Type: PEMType,
+		Headers: map[string]string{
+			"Wrap-Algorithm": algorithmX25519,
+			"Ephemeral-Key":  base64.StdEncoding.EncodeToString(ephemeral[:]),
+			"Recipient":      fingerprint(wf.pub),
+		},
+		Bytes: aead.Seal(nil, nonce, key, nil),
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func (wf *wrapFile) Unwrap(wrapped []byte) ([]byte, error) {
+	if wf.priv == nil {
+		return nil, errors.New("keysource: unwrapping requires the private wrapping key")
+	}
+
+	block, err := decodeWrapped(wrapped, algorithmX25519)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Headers["Recipient"] != fingerprint(wf.pub) {
+		return nil, errors.New("keysource: key was wrapped for a different wrapping key")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(block.Headers["Ephemeral-Key"])
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 32 {
+		return nil, errors.New("keysource: invalid ephemeral key")
+	}
+	ephemeral := new([32]byte)
+	copy(ephemeral[:], raw)
+
+	shared, err := x25519(wf.priv, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := wrapKey(shared, ephemeral, wf.pub)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	key, err := aead.Open(nil, nonce, block.Bytes, nil)
+	if err != nil {
+		return nil, errors.New("keysource: failed to unwrap key")
+	}
+	return key, nil
+}
@@ -0,0 +1,241 @@
+// Package lint checks certificates against the profile requirements of
+// RFC 5280 and the CA/Browser Forum Baseline Requirements.
+//
+// Checks are registered as Rules. The rules in this package are
+// registered when it is loaded; others may be added with Register.
+package lint
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Sources of the requirements checked by the rules in this package.
+const (
+	RFC5280 = "RFC 5280"
+	BR      = "CA/B Forum BR"
+)
+
+// A Level is the severity of a rule violation.
+type Level int
+
+// Rule violations are either warnings, for practices that are
+// discouraged, or errors, for requirements that are not met.
+const (
+	Warning Level = iota + 1
+	Error
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+}
+
+// MarshalJSON encodes the level as its name.
+func (l Level) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.String())
+}
+
+// UnmarshalJSON decodes a level from its name.
+func (l *Level) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	switch s {
+	case "warning":
+		*l = Warning
+	case "error":
+		*l = Error
+	default:
+		return fmt.Errorf("unknown lint level %q", s)
+	}
+	return nil
+}
+
+// A Rule is a single check on a certificate.
+type Rule struct {
+	// Name identifies the rule. Names start with "e_" for rules
+	// reporting errors and "w_" for rules reporting warnings.
+	Name string `json:"name"`
+	// Description states the requirement the rule checks.
+	Description string `json:"description"`
+	// Source cites where the requirement comes from.
+	Source string `json:"source"`
+	// Level is the severity of a violation of the rule.
+	Level Level `json:"level"`
+	// Applies reports whether the rule applies to the certificate.
+	// A nil Applies applies the rule to every certificate.
+	Applies func(cert *x509.Certificate) bool `json:"-"`
+	// Check returns an error describing how the certificate
+	// violates the rule, or nil if it does not.
+	Check func(cert *x509.Certificate) error `json:"-"`
+}
+
+var (
+	lock  sync.RWMutex
+	rules = map[string]*Rule{}
+)
+
+// Register adds a rule to the registry, replacing any rule of the
+// same name.
+func Register(r *Rule) {
+	lock.Lock()
+	defer lock.Unlock()
+	rules[r.Name] = r
+}
+
+// Lookup returns the rule registered under name.
+func Lookup(name string) (*Rule, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	r, ok := rules[name]
+	return r, ok
+}
+
+// Rules returns the registered rules, sorted by name.
+func Rules() []*Rule {
+	lock.RLock()
+	defer lock.RUnlock()
+	list := make([]*Rule, 0, len(rules))
+	for _, r := range rules {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// A Finding is a violation of a rule.
+type Finding struct {
+	Rule    string `json:"rule"`
+	Source  string `json:"source"`
+	Level   Level  `json:"level"`
+	Message string `json:"message"`
+}
+
+// String returns the finding as a single line.
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s (%s)", f.Rule, f.Message, f.Source)
+}
+
+// Findings is the result of linting a certificate.
+type Findings []Finding
+
+// Errors returns the findings at the Error level.
+func (fs Findings) Errors() Findings {
+	var errs Findings
+	for _, f := range fs {
+		if f.Level >= Error {
+			errs = append(errs, f)
+		}
+	}
+	return errs
+}
+
+// String returns the findings separated by semicolons.
+func (fs Findings) String() string {
+	s := make([]string, len(fs))
+	for i, f := range fs {
+		s[i] = f.String()
+	}
+	return strings.Join(s, "; ")
+}
+
+// Run checks cert against every registered rule that applies to it,
+// except those named in ignored, and returns the violations found in
+// the order of the rule names.
+func Run(cert *x509.Certificate, ignored []string) Findings {
+	skip := map[string]bool{}
+	for _, name := range ignored {
+		skip[name] = true
+	}
+
+	findings := Findings{}
+	for _, r := range Rules() {
+		if skip[r.Name] || (r.Applies != nil && !r.Applies(cert)) {
+			continue
+		}
+		if err := r.Check(cert); err != nil {
+			findings = append(findings, Finding{
+				Rule:    r.Name,
+				Source:  r.Source,
+				Level:   r.Level,
+				Message: err.Error(),
+			})
+		}
+	}
+	return findings
+}
+
+// A Report identifies a certificate and gives its lint findings.
+type Report struct {
+	Subject  string   `json:"subject"`
+	Serial   string   `json:"serial_number"`
+	Findings Findings `json:"findings"`
+}
+
+// Reports lints each of certs, as Run does.
+func Reports(certs []*x509.Certificate, ignored []string) []Report {
+	reports := make([]Report, len(certs))
+	for i, cert := range certs {
+		reports[i] = Report{
+			Subject:  nameString(cert.Subject),
+			Serial:   cert.SerialNumber.String(),
+			Findings: Run(cert, ignored),
+		}
+	}
+	return reports
+}
+
+// attributeTypeNames gives the RFC 4514 short names of the attribute
+// types in pkix.Name.
+var attributeTypeNames = map[string]string{
+	"2.5.4.3":  "CN",
+	"2.5.4.5":  "SERIALNUMBER",
+	"2.5.4.6":  "C",
+	"2.5.4.7":  "L",
+	"2.5.4.8":  "ST",
+	"2.5.4.9":  "STREET",
+	"2.5.4.10": "O",
+	"2.5.4.11": "OU",
+	"2.5.4.17": "POSTALCODE",
+}
+
+// nameString formats name as an RFC 4514 distinguished name, most
+// specific attribute first. (pkix.Name.String needs Go 1.10.)
+func nameString(name pkix.Name) string {
+	var rdns pkix.RDNSequence
+	if len(name.Names) > 0 {
+		for _, atv := range name.Names {
+			rdns = append(rdns, pkix.RelativeDistinguishedNameSET{atv})
+		}
+	} else {
+		rdns = name.ToRDNSequence()
+	}
+
+	parts := make([]string, 0, len(rdns))
+	for i := len(rdns) - 1; i >= 0; i-- {
+		atvs := make([]string, len(rdns[i]))
+		for j, atv := range rdns[i] {
+			typ, ok := attributeTypeNames[atv.Type.String()]
+			if !ok {
+				typ = atv.Type.String()
+			}
+			atvs[j] = fmt.Sprintf("%s=%v", typ, atv.Value)
+		}
+		parts = append(parts, strings.Join(atvs, "+"))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,194 @@
+package lint
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func serverTemplate() *x509.Certificate {
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(1234),
+		Subject:               pkix.Name{CommonName: "www.cloudflare.com"},
+		DNSNames:              []string{"www.cloudflare.com", "*.cloudflare.com"},
+		NotBefore:             now,
+		NotAfter:              now.Add(90 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		OCSPServer:            []string{"http://ocsp.cloudflare.com"},
+		BasicConstraintsValid: true,
+	}
+}
+
+func issue(t *testing.T, template *x509.Certificate, curve elliptic.Curve) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func ruleNames(findings Findings) map[string]bool {
+	names := map[string]bool{}
+	for _, f := range findings {
+		names[f.Rule] = true
+	}
+	return names
+}
+
+func TestRunClean(t *testing.T) {
+	findings := Run(issue(t, serverTemplate(), elliptic.P256()), nil)
+	if len(findings) != 0 {
+		t.Fatalf("unexpected findings: %s", findings)
+	}
+}
+
+func TestRunFindings(t *testing.T) {
+	template := serverTemplate()
+	template.Subject.CommonName = "mail.cloudflare.com"
+	template.DNSNames = []string{"www.cloudflare.com", "bad_name.cloudflare.com", "intranet"}
+	template.NotAfter = template.NotBefore.Add(2 * 365 * 24 * time.Hour)
+	template.KeyUsage = x509.KeyUsageKeyEncipherment
+	template.OCSPServer = nil
+
+	findings := Run(issue(t, template, elliptic.P256()), nil)
+	names := ruleNames(findings)
+	for _, name := range []string{
+		"e_sub_cert_cn_not_in_san",
+		"e_san_dns_name_malformed",
+		"e_sub_cert_internal_name",
+		"e_sub_cert_validity_too_long",
+		"e_key_usage_missing_for_eku",
+		"e_ecdsa_key_encipherment",
+		"w_sub_cert_aia_ocsp_missing",
+	} {
+		if !names[name] {
+			t.Errorf("expected a %s finding in %s", name, findings)
+		}
+	}
+	if len(findings) != 7 {
+		t.Fatalf("unexpected findings: %s", findings)
+	}
+	if errs := findings.Errors(); len(errs) != 6 {
+		t.Fatalf("expected 6 errors, got %s", errs)
+	}
+
+	findings = Run(issue(t, template, elliptic.P256()), []string{"e_sub_cert_internal_name", "w_sub_cert_aia_ocsp_missing"})
+	if names = ruleNames(findings); len(findings) != 5 || names["e_sub_cert_internal_name"] {
+		t.Fatalf("ignored rules were run: %s", findings)
+	}
+}
+
+func TestRunCA(t *testing.T) {
+	template := serverTemplate()
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.DNSNames = nil
+
+	// The subscriber rules do not apply to CA certificates.
+	findings := Run(issue(t, template, elliptic.P224()), nil)
+	names := ruleNames(findings)
+	if len(findings) != 2 || !names["e_ca_key_cert_sign_missing"] || !names["e_ecdsa_curve_unsupported"] {
+		t.Fatalf("unexpected findings: %s", findings)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register(&Rule{
+		Name:   "w_test_organization_missing",
+		Source: "test",
+		Level:  Warning,
+		Check: func(cert *x509.Certificate) error {
+			if len(cert.Subject.Organization) == 0 {
+				return errors.New("no organization")
+			}
+			return nil
+		},
+	})
+	defer func() {
+		lock.Lock()
+		delete(rules, "w_test_organization_missing")
+		lock.Unlock()
+	}()
+
+	if _, ok := Lookup("w_test_organization_missing"); !ok {
+		t.Fatal("registered rule not found")
+	}
+	findings := Run(issue(t, serverTemplate(), elliptic.P256()), nil)
+	if len(findings) != 1 || findings[0].Rule != "w_test_organization_missing" || len(findings.Errors()) != 0 {
+		t.Fatalf("unexpected findings: %s", findings)
+	}
+
+	out, err := json.Marshal(findings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Findings
+	if err = json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded[0] != findings[0] {
+		t.Fatalf("findings did not round-trip: %s", out)
+	}
+}
+
+func TestReservedIP(t *testing.T) {
+	for ip, reserved := range map[string]bool{
+		"10.1.2.3":      true,
+		"172.16.0.1":    true,
+		"172.31.255.1":  true,
+		"192.168.1.1":   true,
+		"127.0.0.1":     true,
+		"169.254.1.1":   true,
+		"0.0.0.0":       true,
+		"fd00::1":       true,
+		"fe80::1":       true,
+		"::1":           true,
+		"172.32.0.1":    false,
+		"192.169.1.1":   false,
+		"1.1.1.1":       false,
+		"2606:4700::11": false,
+	} {
+		if reservedIP(net.ParseIP(ip)) != reserved {
+			t.Errorf("reservedIP(%s) should be %v", ip, reserved)
+		}
+	}
+}
+
+func TestNameString(t *testing.T) {
+	name := pkix.Name{
+		Country:      []string{"US"},
+		Organization: []string{"CloudFlare"},
+		CommonName:   "www.cloudflare.com",
+	}
+	if s := nameString(name); s != "CN=www.cloudflare.com,O=CloudFlare,C=US" {
+		t.Fatalf("unexpected name %q", s)
+	}
+
+	// A parsed name keeps the attributes in the order they were encoded.
+	var parsed pkix.Name
+	parsed.FillFromRDNSequence(&pkix.RDNSequence{
+		{{Type: []int{2, 5, 4, 10}, Value: "CloudFlare"}},
+		{{Type: []int{1, 2, 840, 113549, 1, 9, 1}, Value: "ca@cloudflare.com"}},
+	})
+	if s := nameString(parsed); s != "1.2.840.113549.1.9.1=ca@cloudflare.com,O=CloudFlare" {
+		t.Fatalf("unexpected name %q", s)
+	}
+}
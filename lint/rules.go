@@ -0,0 +1,482 @@
+package lint
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// maxSubscriberValidity is the longest validity period allowed for a
+// TLS server certificate issued on or after 2020-09-01, by BR 6.3.2.
+const maxSubscriberValidity = 398 * 24 * time.Hour
+
+func init() {
+	for _, r := range []*Rule{
+		{
+			Name:        "e_serial_number_too_long",
+			Description: "serial numbers must not be longer than 20 octets",
+			Source:      RFC5280 + " 4.1.2.2",
+			Level:       Error,
+			Check:       checkSerialLength,
+		},
+		{
+			Name:        "e_serial_number_not_positive",
+			Description: "serial numbers must be positive integers",
+			Source:      RFC5280 + " 4.1.2.2",
+			Level:       Error,
+			Check:       checkSerialPositive,
+		},
+		{
+			Name:        "e_validity_inverted",
+			Description: "notAfter must not be before notBefore",
+			Source:      RFC5280 + " 4.1.2.5",
+			Level:       Error,
+			Check:       checkValidityOrder,
+		},
+		{
+			Name:        "e_san_dns_name_malformed",
+			Description: "DNS names in the SAN must use the preferred name syntax",
+			Source:      RFC5280 + " 4.2.1.6",
+			Level:       Error,
+			Check:       checkDNSNames,
+		},
+		{
+			Name:        "e_san_email_malformed",
+			Description: "email addresses in the SAN must be bare addr-specs",
+			Source:      RFC5280 + " 4.2.1.6",
+			Level:       Error,
+			Check:       checkEmailAddresses,
+		},
+		{
+			Name:        "e_ca_key_cert_sign_missing",
+			Description: "CA certificates must assert keyCertSign",
+			Source:      RFC5280 + " 4.2.1.3",
+			Level:       Error,
+			Applies:     isCA,
+			Check:       checkCAKeyUsage,
+		},
+		{
+			Name:        "e_sub_cert_key_cert_sign",
+			Description: "keyCertSign must only be asserted in CA certificates",
+			Source:      RFC5280 + " 4.2.1.3",
+			Level:       Error,
+			Applies:     isSubscriber,
+			Check:       checkSubscriberCertSign,
+		},
+		{
+			Name:        "e_key_usage_missing_for_eku",
+			Description: "the key usages must permit the purposes in the extended key usage",
+			Source:      RFC5280 + " 4.2.1.12",
+			Level:       Error,
+			Applies:     isSubscriber,
+			Check:       checkKeyUsageForEKU,
+		},
+		{
+			Name:        "e_ecdsa_key_encipherment",
+			Description: "ECDSA keys must not assert keyEncipherment or dataEncipherment",
+			Source:      "RFC 5480 3",
+			Level:       Error,
+			Applies:     hasECDSAKey,
+			Check:       checkECDSAKeyUsage,
+		},
+		{
+			Name:        "e_rsa_key_too_small",
+			Description: "RSA moduli must be at least 2048 bits",
+			Source:      BR + " 6.1.5",
+			Level:       Error,
+			Applies:     hasRSAKey,
+			Check:       checkRSAKeySize,
+		},
+		{
+			Name:        "e_ecdsa_curve_unsupported",
+			Description: "ECDSA keys must be on P-256, P-384 or P-521",
+			Source:      BR + " 6.1.5",
+			Level:       Error,
+			Applies:     hasECDSAKey,
+			Check:       checkECDSACurve,
+		},
+		{
+			Name:        "e_weak_signature_algorithm",
+			Description: "certificates must not be signed with MD5 or SHA-1",
+			Source:      BR + " 7.1.3",
+			Level:       Error,
+			Check:       checkSignatureAlgorithm,
+		},
+		{
+			Name:        "e_sub_cert_san_missing",
+			Description: "TLS server certificates must contain a SAN with a DNS name or IP address",
+			Source:      BR + " 7.1.2.3",
+			Level:       Error,
+			Applies:     isServerAuth,
+			Check:       checkSANPresent,
+		},
+		{
+			Name:        "e_sub_cert_cn_not_in_san",
+			Description: "the common name of a TLS server certificate must be one of its SANs",
+			Source:      BR + " 7.1.4.2.2",
+			Level:       Error,
+			Applies:     isServerAuth,
+			Check:       checkCommonNameInSAN,
+		},
+		{
+			Name:        "e_sub_cert_validity_too_long",
+			Description: "TLS server certificates must not be valid for more than 398 days",
+			Source:      BR + " 6.3.2",
+			Level:       Error,
+			Applies:     isServerAuth,
+			Check:       checkValidityPeriod,
+		},
+		{
+			Name:        "e_sub_cert_internal_name",
+			Description: "TLS server certificates must not contain internal names or reserved IP addresses",
+			Source:      BR + " 7.1.4.2.1",
+			Level:       Error,
+			Applies:     isServerAuth,
+			Check:       checkInternalNames,
+		},
+		{
+			Name:        "e_sub_cert_eku_any",
+			Description: "subscriber certificates must not assert anyExtendedKeyUsage",
+			Source:      BR + " 7.1.2.3",
+			Level:       Error,
+			Applies:     isSubscriber,
+			Check:       checkEKUAny,
+		},
+		{
+			Name:        "w_sub_cert_eku_missing",
+			Description: "subscriber certificates should contain an extended key usage",
+			Source:      BR + " 7.1.2.3",
+			Level:       Warning,
+			Applies:     isSubscriber,
+			Check:       checkEKUPresent,
+		},
+		{
+			Name:        "w_sub_cert_aia_ocsp_missing",
+			Description: "TLS server certificates should give an OCSP responder",
+			Source:      BR + " 7.1.2.3",
+			Level:       Warning,
+			Applies:     isServerAuth,
+			Check:       checkOCSPServer,
+		},
+	} {
+		Register(r)
+	}
+}
+
+func isCA(cert *x509.Certificate) bool {
+	return cert.IsCA
+}
+
+func isSubscriber(cert *x509.Certificate) bool {
+	return !cert.IsCA
+}
+
+func isServerAuth(cert *x509.Certificate) bool {
+	return !cert.IsCA && hasEKU(cert, x509.ExtKeyUsageServerAuth)
+}
+
+func hasEKU(cert *x509.Certificate, eku x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == eku {
+			return true
+		}
+	}
+	return false
+}
+
+func hasRSAKey(cert *x509.Certificate) bool {
+	_, ok := cert.PublicKey.(*rsa.PublicKey)
+	return ok
+}
+
+func hasECDSAKey(cert *x509.Certificate) bool {
+	_, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	return ok
+}
+
+func checkSerialLength(cert *x509.Certificate) error {
+	if cert.SerialNumber == nil {
+		return errors.New("no serial number")
+	}
+	// The DER encoding of a positive integer has a leading zero
+	// octet if its high bit is set.
+	b := cert.SerialNumber.Bytes()
+	n := len(b)
+	if n > 0 && b[0]&0x80 != 0 {
+		n++
+	}
+	if n > 20 {
+		return fmt.Errorf("serial number is %d octets long", n)
+	}
+	return nil
+}
+
+func checkSerialPositive(cert *x509.Certificate) error {
+	if cert.SerialNumber == nil || cert.SerialNumber.Sign() <= 0 {
+		return errors.New("serial number is not positive")
+	}
+	return nil
+}
+
+func checkValidityOrder(cert *x509.Certificate) error {
+	if cert.NotAfter.Before(cert.NotBefore) {
+		return fmt.Errorf("notAfter %s is before notBefore %s", cert.NotAfter, cert.NotBefore)
+	}
+	return nil
+}
+
+// validDNSName checks that name is a host name in the preferred name
+// syntax of RFC 1034, optionally with a wildcard as its first label.
+func validDNSName(name string) error {
+	if name == "" {
+		return errors.New("empty name")
+	}
+	if len(name) > 253 {
+		return errors.New("name is longer than 253 characters")
+	}
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if i == 0 && label == "*" && len(labels) > 2 {
+			continue
+		}
+		if label == "" {
+			return errors.New("empty label")
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("label %q is longer than 63 characters", label)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("label %q starts or ends with a hyphen", label)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("label %q contains %q", label, c)
+			}
+		}
+	}
+	return nil
+}
+
+func checkDNSNames(cert *x509.Certificate) error {
+	for _, name := range cert.DNSNames {
+		if err := validDNSName(name); err != nil {
+			return fmt.Errorf("DNS name %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func checkEmailAddresses(cert *x509.Certificate) error {
+	for _, email := range cert.EmailAddresses {
+		addr, err := mail.ParseAddress(email)
+		if err != nil || addr.Name != "" || addr.Address != email {
+			return fmt.Errorf("email address %q is not an addr-spec", email)
+		}
+	}
+	return nil
+}
+
+func checkCAKeyUsage(cert *x509.Certificate) error {
+	if cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return errors.New("CA certificate does not assert keyCertSign")
+	}
+	return nil
+}
+
+func checkSubscriberCertSign(cert *x509.Certificate) error {
+	if cert.KeyUsage&x509.KeyUsageCertSign != 0 {
+		return errors.New("keyCertSign is asserted in a certificate that is not a CA")
+	}
+	return nil
+}
+
+// ekuNames names the extended key usages checked against key usages.
+var ekuNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+func checkKeyUsageForEKU(cert *x509.Certificate) error {
+	// Without a key usage extension, the key is not restricted.
+	if cert.KeyUsage == 0 {
+		return nil
+	}
+	for _, eku := range cert.ExtKeyUsage {
+		name, ok := ekuNames[eku]
+		if !ok {
+			continue
+		}
+		allowed := x509.KeyUsageDigitalSignature
+		switch eku {
+		case x509.ExtKeyUsageServerAuth:
+			// RSA key transport in TLS 1.2 and earlier.
+			if hasRSAKey(cert) {
+				allowed |= x509.KeyUsageKeyEncipherment
+			}
+		case x509.ExtKeyUsageClientAuth:
+			allowed |= x509.KeyUsageKeyAgreement
+		case x509.ExtKeyUsageEmailProtection:
+			allowed |= x509.KeyUsageContentCommitment | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement
+		case x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageTimeStamping:
+			allowed |= x509.KeyUsageContentCommitment
+		}
+		if cert.KeyUsage&allowed == 0 {
+			return fmt.Errorf("no key usage permits the %s extended key usage", name)
+		}
+	}
+	return nil
+}
+
+func checkECDSAKeyUsage(cert *x509.Certificate) error {
+	if cert.KeyUsage&(x509.KeyUsageKeyEncipherment|x509.KeyUsageDataEncipherment) != 0 {
+		return errors.New("ECDSA key asserts keyEncipherment or dataEncipherment")
+	}
+	return nil
+}
+
+func checkRSAKeySize(cert *x509.Certificate) error {
+	key := cert.PublicKey.(*rsa.PublicKey)
+	if bits := key.N.BitLen(); bits < 2048 {
+		return fmt.Errorf("RSA key is %d bits", bits)
+	}
+	return nil
+}
+
+func checkECDSACurve(cert *x509.Certificate) error {
+	key := cert.PublicKey.(*ecdsa.PublicKey)
+	switch key.Curve {
+	case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		return nil
+	}
+	return fmt.Errorf("ECDSA key is on curve %s", key.Curve.Params().Name)
+}
+
+func checkSignatureAlgorithm(cert *x509.Certificate) error {
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return fmt.Errorf("signed with %s", cert.SignatureAlgorithm)
+	}
+	return nil
+}
+
+func checkSANPresent(cert *x509.Certificate) error {
+	if len(cert.DNSNames) == 0 && len(cert.IPAddresses) == 0 {
+		return errors.New("no DNS name or IP address in the SAN")
+	}
+	return nil
+}
+
+func checkCommonNameInSAN(cert *x509.Certificate) error {
+	cn := cert.Subject.CommonName
+	if cn == "" {
+		return nil
+	}
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, cn) {
+			return nil
+		}
+	}
+	if ip := net.ParseIP(cn); ip != nil {
+		for _, sanIP := range cert.IPAddresses {
+			if sanIP.Equal(ip) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("common name %q is not in the SAN", cn)
+}
+
+func checkValidityPeriod(cert *x509.Certificate) error {
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity > maxSubscriberValidity {
+		return fmt.Errorf("certificate is valid for %d days", int(validity.Hours()/24))
+	}
+	return nil
+}
+
+// internalSuffixes are domains reserved for private use.
+var internalSuffixes = []string{".local", ".localhost", ".internal", ".lan", ".home.arpa", ".test", ".example", ".invalid"}
+
+func internalName(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if !strings.Contains(name, ".") {
+		return true
+	}
+	for _, suffix := range internalSuffixes {
+		if strings.HasSuffix("."+name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// privateNets are the private address ranges of RFC 1918 and the
+// unique local addresses of RFC 4193.
+var privateNets = parseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = ipnet
+	}
+	return nets
+}
+
+func reservedIP(ip net.IP) bool {
+	for _, ipnet := range privateNets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsUnspecified() || ip.IsMulticast()
+}
+
+func checkInternalNames(cert *x509.Certificate) error {
+	for _, name := range cert.DNSNames {
+		if internalName(name) {
+			return fmt.Errorf("DNS name %q is an internal name", name)
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if reservedIP(ip) {
+			return fmt.Errorf("IP address %s is reserved", ip)
+		}
+	}
+	return nil
+}
+
+func checkEKUAny(cert *x509.Certificate) error {
+	if hasEKU(cert, x509.ExtKeyUsageAny) {
+		return errors.New("anyExtendedKeyUsage is asserted")
+	}
+	return nil
+}
+
+func checkEKUPresent(cert *x509.Certificate) error {
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return errors.New("no extended key usage")
+	}
+	return nil
+}
+
+func checkOCSPServer(cert *x509.Certificate) error {
+	if len(cert.OCSPServer) == 0 {
+		return errors.New("no OCSP responder in the authority information access")
+	}
+	return nil
+}
@@ -9,21 +9,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
-	"net/url"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 
 	"github.com/cloudflare/cfssl/certdb/dbconf"
 	"github.com/cloudflare/cfssl/config"
 	"github.com/cloudflare/cfssl/helpers"
-	"github.com/cloudflare/cfssl/helpers/derhelpers"
-	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/keysource"
 	"github.com/cloudflare/cfssl/whitelist"
 
-	"github.com/cloudflare/redoctober/client"
-	"github.com/cloudflare/redoctober/core"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -167,98 +162,11 @@ func LoadRoot(cfg map[string]string) (*Root, error) {
 	return &root, nil
 }
 
+// parsePrivateKeySpec loads the private key named by spec through
+// the key source registered for its scheme; cfg supplies the source's
+// settings, such as the Red October server or KMS URL.
 func parsePrivateKeySpec(spec string, cfg map[string]string) (crypto.Signer, error) {
-	specURL, err := url.Parse(spec)
-	if err != nil {
-		return nil, err
-	}
-
-	var priv crypto.Signer
-	switch specURL.Scheme {
-	case "file":
-		// A file spec will be parsed such that the root
-		// directory of a relative path will be stored as the
-		// hostname, and the remainder of the file's path is
-		// stored in the Path field.
-		log.Debug("loading private key file", specURL.Path)
-		path := filepath.Join(specURL.Host, specURL.Path)
-		in, err := ioutil.ReadFile(path)
-		if err != nil {
-			return nil, err
-		}
-
-		log.Debug("attempting to load PEM-encoded private key")
-		priv, err = helpers.ParsePrivateKeyPEM(in)
-		if err != nil {
-			log.Debug("file is not a PEM-encoded private key")
-			log.Debug("attempting to load DER-encoded private key")
-			priv, err = derhelpers.ParsePrivateKeyDER(in)
-			if err != nil {
-				return nil, err
-			}
-		}
-		log.Debug("loaded private key")
-		return priv, nil
-	case "rofile":
-		log.Warning("Red October support is currently experimental")
-		path := filepath.Join(specURL.Host, specURL.Path)
-		in, err := ioutil.ReadFile(path)
-		if err != nil {
-			return nil, err
-		}
-
-		roServer := cfg["ro_server"]
-		if roServer == "" {
-			return nil, errors.New("config: no RedOctober server available")
-		}
-
-		// roCAPath can be empty; if it is, the client uses
-		// the system default CA roots.
-		roCAPath := cfg["ro_ca"]
-
-		roUser := cfg["ro_user"]
-		if roUser == "" {
-			return nil, errors.New("config: no RedOctober user available")
-		}
-
-		roPass := cfg["ro_pass"]
-		if roPass == "" {
-			return nil, errors.New("config: no RedOctober passphrase available")
-		}
-
-		log.Debug("decrypting key via RedOctober Server")
-		roClient, err := client.NewRemoteServer(roServer, roCAPath)
-		if err != nil {
-			return nil, err
-		}
-
-		req := core.DecryptRequest{
-			Name:     roUser,
-			Password: roPass,
-			Data:     in,
-		}
-		in, err = roClient.DecryptIntoData(req)
-		if err != nil {
-			return nil, err
-		}
-
-		log.Debug("attempting to load PEM-encoded private key")
-		priv, err = helpers.ParsePrivateKeyPEM(in)
-		if err != nil {
-			log.Debug("file is not a PEM-encoded private key")
-			log.Debug("attempting to load DER-encoded private key")
-			priv, err = derhelpers.ParsePrivateKeyDER(in)
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		log.Debug("loaded private key")
-
-		return priv, nil
-	default:
-		return nil, ErrUnsupportedScheme
-	}
+	return keysource.Load(spec, cfg)
 }
 
 func parseACL(nets string) (whitelist.NetACL, error) {
@@ -298,7 +206,7 @@ var (
 	ErrInvalidConfig = errors.New("config: invalid configuration")
 
 	// ErrUnsupportedScheme indicates a private key scheme that is not currently supported.
-	ErrUnsupportedScheme = errors.New("config: unsupported private key scheme")
+	ErrUnsupportedScheme = keysource.ErrUnsupportedScheme
 )
 
 // Parse loads a RootList from a file.
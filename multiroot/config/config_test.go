@@ -2,9 +2,13 @@ package config
 
 import (
 	"crypto/rsa"
+	"io/ioutil"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/cloudflare/cfssl/keysource"
 	"github.com/cloudflare/cfssl/log"
 
 	_ "github.com/mattn/go-sqlite3" // import just to initialize SQLite for testing
@@ -136,6 +140,46 @@ func TestLoadKSMRoot(t *testing.T) {
 	}
 }
 
+func TestLoadWrappedRoot(t *testing.T) {
+	keyPEM, err := ioutil.ReadFile("testdata/server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub, err := keysource.NewKMSStub("", "ca-kek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	wrapped, err := keysource.NewKMS(server.URL, "ca-kek", "", nil).Wrap(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "multiroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wrappedFile := filepath.Join(dir, "server.key.wrapped")
+	if err = ioutil.WriteFile(wrappedFile, wrapped, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	priv, err := parsePrivateKeySpec("kmsfile://"+wrappedFile, map[string]string{"kms_url": server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := priv.(*rsa.PrivateKey); !ok {
+		t.Fatal("expected an RSA private key")
+	}
+
+	if _, err = parsePrivateKeySpec("kmsfile://"+wrappedFile, nil); err == nil {
+		t.Fatal("expected a missing KMS URL to fail")
+	}
+}
+
 func TestLoadBadRootConfs(t *testing.T) {
 	confs := []string{
 		"testdata/roots_bad_db.conf",
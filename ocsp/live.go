@@ -0,0 +1,346 @@
+package ocsp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/log"
+	"github.com/jmhodges/clock"
+	"golang.org/x/crypto/ocsp"
+)
+
+// oidNonce is the id-pkix-ocsp-nonce extension (RFC 8954).
+var oidNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+// maxNonceLength is the longest nonce accepted, as RFC 8954 requires.
+const maxNonceLength = 32
+
+// ErrBadNonce is returned by a NonceSource for a request nonce it
+// can't accept; the Responder answers the request as malformed.
+var ErrBadNonce = errors.New("OCSP request nonce is malformed")
+
+// A NonceSource is a Source that answers requests carrying a nonce.
+// The Responder calls NonceResponse instead of Response for such
+// requests when its Source is a NonceSource. The nonce is the value of
+// the request's nonce extension, DER as received, so that it may be
+// echoed unchanged; it is up to the source to check it, with
+// CheckNonce, if it uses it.
+type NonceSource interface {
+	Source
+	NonceResponse(req *ocsp.Request, nonce []byte) ([]byte, http.Header, error)
+}
+
+// ocspRequestExtensions is an OCSP request, as far as needed to reach
+// its extensions, which ocsp.ParseRequest drops.
+type ocspRequestExtensions struct {
+	TBSRequest struct {
+		Version       int           `asn1:"explicit,tag:0,default:0,optional"`
+		RequestorName asn1.RawValue `asn1:"explicit,tag:1,optional"`
+		RequestList   []asn1.RawValue
+		Extensions    []pkix.Extension `asn1:"explicit,tag:2,optional"`
+	}
+}
+
+// requestNonce returns the value of the nonce extension of the DER
+// request, or nil if it has none.
+func requestNonce(der []byte) ([]byte, error) {
+	var req ocspRequestExtensions
+	if _, err := asn1.Unmarshal(der, &req); err != nil {
+		return nil, err
+	}
+	for _, ext := range req.TBSRequest.Extensions {
+		if ext.Id.Equal(oidNonce) {
+			return ext.Value, nil
+		}
+	}
+	return nil, nil
+}
+
+// CheckNonce returns ErrBadNonce unless the value of a nonce extension
+// holds a nonce of 1 to 32 bytes, as RFC 8954 requires.
+func CheckNonce(value []byte) error {
+	// The nonce is an OCTET STRING, though some clients send it
+	// bare.
+	nonce := value
+	var octets []byte
+	if rest, err := asn1.Unmarshal(value, &octets); err == nil && len(rest) == 0 {
+		nonce = octets
+	}
+	if len(nonce) == 0 || len(nonce) > maxNonceLength {
+		return ErrBadNonce
+	}
+	return nil
+}
+
+// certIDHashes are the hash algorithms a CertID may use.
+var certIDHashes = []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+// issuerHashes returns the issuer name and key hashes identifying
+// issuer in a CertID using hash.
+func issuerHashes(issuer *x509.Certificate, hash crypto.Hash) (nameHash, keyHash []byte, err error) {
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err = asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &publicKeyInfo); err != nil {
+		return nil, nil, err
+	}
+	if !hash.Available() {
+		return nil, nil, x509.ErrUnsupportedAlgorithm
+	}
+	h := hash.New()
+	h.Write(issuer.RawSubject)
+	nameHash = h.Sum(nil)
+	h.Reset()
+	h.Write(publicKeyInfo.PublicKey.RightAlign())
+	keyHash = h.Sum(nil)
+	return nameHash, keyHash, nil
+}
+
+func certIDKey(hash crypto.Hash, nameHash, keyHash []byte) string {
+	return string([]byte{byte(hash)}) + string(nameHash) + string(keyHash)
+}
+
+// MultiSource is a Source serving several issuers: each request is
+// routed to the Source of the issuer named by its CertID, whichever
+// hash algorithm it uses. Requests for other issuers are not found.
+type MultiSource struct {
+	sources map[string]Source
+}
+
+// NewMultiSource returns a MultiSource serving no issuers.
+func NewMultiSource() *MultiSource {
+	return &MultiSource{sources: map[string]Source{}}
+}
+
+// Add routes requests for the certificates issued by issuer to src.
+// It is not safe to call once the MultiSource is serving.
+func (m *MultiSource) Add(issuer *x509.Certificate, src Source) error {
+	for _, hash := range certIDHashes {
+		nameHash, keyHash, err := issuerHashes(issuer, hash)
+		if err != nil {
+			return err
+		}
+		m.sources[certIDKey(hash, nameHash, keyHash)] = src
+	}
+	return nil
+}
+
+func (m *MultiSource) route(req *ocsp.Request) (Source, error) {
+	if req == nil {
+		return nil, errors.New("called with nil request")
+	}
+	src, ok := m.sources[certIDKey(req.HashAlgorithm, req.IssuerNameHash, req.IssuerKeyHash)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return src, nil
+}
+
+// Response returns the response of the Source of the request's issuer.
+func (m *MultiSource) Response(req *ocsp.Request) ([]byte, http.Header, error) {
+	src, err := m.route(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return src.Response(req)
+}
+
+// NonceResponse returns the nonce response of the Source of the
+// request's issuer, if it is a NonceSource, or else its response.
+func (m *MultiSource) NonceResponse(req *ocsp.Request, nonce []byte) ([]byte, http.Header, error) {
+	src, err := m.route(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ns, ok := src.(NonceSource); ok {
+		return ns.NonceResponse(req, nonce)
+	}
+	return src.Response(req)
+}
+
+// DefaultLiveCacheSize is the number of signed responses a LiveSource
+// keeps.
+const DefaultLiveCacheSize = 4096
+
+// liveEntry is a signed response, valid while the certificate record
+// it was signed from is unchanged.
+type liveEntry struct {
+	response  []byte
+	status    string
+	reason    int
+	revokedAt time.Time
+	refresh   time.Time
+}
+
+// LiveSource is a Source signing responses on demand for the
+// certificates of one issuer, from their records in a certdb.Accessor.
+// The signer may be the issuer or a responder it delegated to. Signed
+// responses are reused until halfway to their next update, or until
+// the record of the certificate changes.
+type LiveSource struct {
+	// EchoNonces makes requests carrying a nonce be answered with a
+	// freshly signed response echoing it. Otherwise nonces are
+	// ignored.
+	EchoNonces bool
+
+	issuer   *x509.Certificate
+	aki      string
+	signer   Signer
+	accessor certdb.Accessor
+	clk      clock.Clock
+
+	lock    sync.Mutex
+	cache   map[string]*liveEntry
+	maxSize int
+}
+
+// NewLiveSource returns a LiveSource signing with signer the responses
+// for the certificates issued by issuer and recorded in accessor.
+func NewLiveSource(issuer *x509.Certificate, signer Signer, accessor certdb.Accessor) *LiveSource {
+	// Certificates are recorded under their authority key
+	// identifier: the issuer's subject key identifier, which is
+	// usually the SHA-1 hash of its key.
+	aki := issuer.SubjectKeyId
+	if len(aki) == 0 {
+		_, aki, _ = issuerHashes(issuer, crypto.SHA1)
+	}
+	return &LiveSource{
+		issuer:   issuer,
+		aki:      hex.EncodeToString(aki),
+		signer:   signer,
+		accessor: accessor,
+		clk:      clock.New(),
+		cache:    map[string]*liveEntry{},
+		maxSize:  DefaultLiveCacheSize,
+	}
+}
+
+// Response implements Source, signing a response for the requested
+// certificate or reusing one signed earlier.
+func (src *LiveSource) Response(req *ocsp.Request) ([]byte, http.Header, error) {
+	return src.respond(req, nil)
+}
+
+// NonceResponse implements NonceSource. If EchoNonces is set, it signs a
+// response echoing the nonce, which is not cached, by the Responder or
+// by HTTP caches. Otherwise the nonce is ignored.
+func (src *LiveSource) NonceResponse(req *ocsp.Request, nonce []byte) ([]byte, http.Header, error) {
+	if !src.EchoNonces {
+		return src.respond(req, nil)
+	}
+	if err := CheckNonce(nonce); err != nil {
+		return nil, nil, err
+	}
+	return src.respond(req, nonce)
+}
+
+func (src *LiveSource) respond(req *ocsp.Request, nonce []byte) ([]byte, http.Header, error) {
+	if req == nil || req.SerialNumber == nil {
+		return nil, nil, errors.New("request contains no serial")
+	}
+	nameHash, keyHash, err := issuerHashes(src.issuer, req.HashAlgorithm)
+	if err != nil || !bytes.Equal(nameHash, req.IssuerNameHash) || !bytes.Equal(keyHash, req.IssuerKeyHash) {
+		return nil, nil, ErrNotFound
+	}
+
+	serial := req.SerialNumber.String()
+	records, err := src.accessor.GetCertificate(serial, src.aki)
+	if err != nil {
+		log.Errorf("Error obtaining certificate record: %s", err)
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, ErrNotFound
+	}
+	record := records[0]
+
+	key := string([]byte{byte(req.HashAlgorithm)}) + serial
+	now := src.clk.Now()
+	if nonce == nil {
+		if resp, ok := src.cached(key, record, now); ok {
+			return resp, nil, nil
+		}
+	}
+
+	cert, err := helpers.ParseCertificatePEM([]byte(record.PEM))
+	if err != nil {
+		log.Errorf("Error parsing certificate record for serial %s: %s", serial, err)
+		return nil, nil, err
+	}
+	thisUpdate := now.Truncate(time.Hour)
+	signReq := SignRequest{
+		Certificate: cert,
+		Status:      record.Status,
+		Reason:      record.Reason,
+		RevokedAt:   record.RevokedAt,
+		IssuerHash:  req.HashAlgorithm,
+		ThisUpdate:  &thisUpdate,
+	}
+	if nonce != nil {
+		signReq.ResponseExtensions = []pkix.Extension{{Id: oidNonce, Value: nonce}}
+	}
+	resp, err := src.signer.Sign(signReq)
+	if err != nil {
+		log.Errorf("Error signing OCSP response for serial %s: %s", serial, err)
+		return nil, nil, err
+	}
+
+	if nonce != nil {
+		return resp, http.Header{"Cache-Control": {"max-age=0, no-cache"}}, nil
+	}
+	parsed, err := ocsp.ParseResponse(resp, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	src.store(key, &liveEntry{
+		response:  resp,
+		status:    record.Status,
+		reason:    record.Reason,
+		revokedAt: record.RevokedAt,
+		// ThisUpdate is truncated to the hour, so the response is
+		// refreshed halfway between now and its NextUpdate.
+		refresh: now.Add(parsed.NextUpdate.Sub(now) / 2),
+	})
+	return resp, nil, nil
+}
+
+func (src *LiveSource) cached(key string, record certdb.CertificateRecord, now time.Time) ([]byte, bool) {
+	src.lock.Lock()
+	defer src.lock.Unlock()
+	e, ok := src.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(e.refresh) || e.status != record.Status || e.reason != record.Reason ||
+		!e.revokedAt.Equal(record.RevokedAt) {
+		delete(src.cache, key)
+		return nil, false
+	}
+	return e.response, true
+}
+
+func (src *LiveSource) store(key string, e *liveEntry) {
+	src.lock.Lock()
+	defer src.lock.Unlock()
+	src.cache[key] = e
+	for len(src.cache) > src.maxSize {
+		var oldest string
+		for k, v := range src.cache {
+			if k != key && (oldest == "" || v.refresh.Before(src.cache[oldest].refresh)) {
+				oldest = k
+			}
+		}
+		delete(src.cache, oldest)
+	}
+}
@@ -0,0 +1,336 @@
+package ocsp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/jmhodges/clock"
+	goocsp "golang.org/x/crypto/ocsp"
+)
+
+// recordAccessor serves certificate records from a map by serial.
+type recordAccessor struct {
+	certdb.Accessor
+	records map[string]certdb.CertificateRecord
+}
+
+func (ra *recordAccessor) GetCertificate(serial, aki string) ([]certdb.CertificateRecord, error) {
+	rec, ok := ra.records[serial]
+	if !ok || rec.AKI != aki {
+		return nil, nil
+	}
+	return []certdb.CertificateRecord{rec}, nil
+}
+
+// countingSigner counts the responses it signs.
+type countingSigner struct {
+	Signer
+	count int
+}
+
+func (cs *countingSigner) Sign(req SignRequest) ([]byte, error) {
+	cs.count++
+	return cs.Signer.Sign(req)
+}
+
+type testIssuer struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestIssuer(t *testing.T, name string) *testIssuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testIssuer{cert: cert, key: key}
+}
+
+// issue returns a certificate and its record.
+func (ti *testIssuer) issue(t *testing.T, serial int64, status string) (*x509.Certificate, certdb.CertificateRecord) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ti.cert, key.Public(), ti.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := certdb.CertificateRecord{
+		Serial: cert.SerialNumber.String(),
+		AKI:    hex.EncodeToString(cert.AuthorityKeyId),
+		Status: status,
+		PEM:    string(helpers.EncodeCertificatePEM(cert)),
+	}
+	if status == "revoked" {
+		rec.RevokedAt = time.Now().Add(-time.Minute).Truncate(time.Second)
+		rec.Reason = goocsp.KeyCompromise
+	}
+	return cert, rec
+}
+
+// withNonce adds a nonce extension to the DER request.
+func withNonce(t *testing.T, req []byte, nonce []byte) []byte {
+	var r ocspRequestExtensions
+	if _, err := asn1.Unmarshal(req, &r); err != nil {
+		t.Fatal(err)
+	}
+	value, err := asn1.Marshal(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.TBSRequest.Extensions = []pkix.Extension{{Id: oidNonce, Value: value}}
+	der, err := asn1.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// responseNonce returns the nonce among the response extensions of the
+// DER response.
+func responseNonce(t *testing.T, der []byte) []byte {
+	var resp responseASN1
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		t.Fatal(err)
+	}
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		t.Fatal(err)
+	}
+	var tbs responseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &tbs); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range tbs.ResponseExtensions {
+		if ext.Id.Equal(oidNonce) {
+			var nonce []byte
+			if _, err := asn1.Unmarshal(ext.Value, &nonce); err != nil {
+				t.Fatal(err)
+			}
+			return nonce
+		}
+	}
+	return nil
+}
+
+func post(t *testing.T, server *httptest.Server, req []byte) (*http.Response, []byte) {
+	resp, err := http.Post(server.URL, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	return resp, body.Bytes()
+}
+
+func newLiveServer(src Source) *httptest.Server {
+	return httptest.NewServer(&Responder{Source: src, clk: clock.New()})
+}
+
+func TestLiveSource(t *testing.T) {
+	ca := newTestIssuer(t, "live CA")
+	good, goodRec := ca.issue(t, 10, "good")
+	revoked, revokedRec := ca.issue(t, 11, "revoked")
+	accessor := &recordAccessor{records: map[string]certdb.CertificateRecord{
+		goodRec.Serial:    goodRec,
+		revokedRec.Serial: revokedRec,
+	}}
+	standard, _ := NewSigner(ca.cert, ca.cert, ca.key, time.Hour)
+	signer := &countingSigner{Signer: standard}
+	server := newLiveServer(NewLiveSource(ca.cert, signer, accessor))
+	defer server.Close()
+
+	req, err := goocsp.CreateRequest(good, ca.cert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, first := post(t, server, req)
+	parsed, err := goocsp.ParseResponseForCert(first, good, ca.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Status != goocsp.Good {
+		t.Fatalf("status %d, want good", parsed.Status)
+	}
+	_, second := post(t, server, req)
+	if !bytes.Equal(first, second) || signer.count != 1 {
+		t.Fatalf("response signed %d times, want it cached", signer.count)
+	}
+
+	// Revoking the certificate invalidates the cached response.
+	goodRec.Status = "revoked"
+	goodRec.RevokedAt = time.Now().Truncate(time.Second)
+	accessor.records[goodRec.Serial] = goodRec
+	_, third := post(t, server, req)
+	if parsed, err = goocsp.ParseResponseForCert(third, good, ca.cert); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Status != goocsp.Revoked {
+		t.Fatalf("status %d after revocation, want revoked", parsed.Status)
+	}
+
+	// SHA-256 CertIDs are answered in kind.
+	req, err = goocsp.CreateRequest(revoked, ca.cert, &goocsp.RequestOptions{Hash: crypto.SHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body := post(t, server, req)
+	if parsed, err = goocsp.ParseResponseForCert(body, revoked, ca.cert); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Status != goocsp.Revoked || parsed.IssuerHash != crypto.SHA256 {
+		t.Fatalf("status %d with %v CertID, want revoked with SHA-256", parsed.Status, parsed.IssuerHash)
+	}
+
+	// Unknown certificates are unauthorized.
+	unknown, _ := ca.issue(t, 12, "good")
+	req, _ = goocsp.CreateRequest(unknown, ca.cert, nil)
+	if _, body = post(t, server, req); !bytes.Equal(body, unauthorizedErrorResponse) {
+		t.Fatal("unknown certificate was not unauthorized")
+	}
+}
+
+func TestLiveSourceNonce(t *testing.T) {
+	ca := newTestIssuer(t, "nonce CA")
+	cert, rec := ca.issue(t, 10, "good")
+	accessor := &recordAccessor{records: map[string]certdb.CertificateRecord{rec.Serial: rec}}
+	signer, _ := NewSigner(ca.cert, ca.cert, ca.key, time.Hour)
+	src := NewLiveSource(ca.cert, signer, accessor)
+	server := newLiveServer(src)
+	defer server.Close()
+
+	plain, err := goocsp.CreateRequest(cert, ca.cert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := []byte("0123456789abcdef")
+	req := withNonce(t, plain, nonce)
+
+	// Nonces are ignored unless configured, even malformed ones.
+	if _, body := post(t, server, req); responseNonce(t, body) != nil {
+		t.Fatal("nonce echoed although not configured")
+	}
+	overlong := withNonce(t, plain, make([]byte, maxNonceLength+1))
+	if resp, _ := post(t, server, overlong); resp.StatusCode != http.StatusOK {
+		t.Fatalf("overlong nonce not ignored: %s", resp.Status)
+	}
+
+	src.EchoNonces = true
+	resp, body := post(t, server, req)
+	if _, err = goocsp.ParseResponseForCert(body, cert, ca.cert); err != nil {
+		t.Fatalf("response echoing the nonce does not verify: %v", err)
+	}
+	if got := responseNonce(t, body); !bytes.Equal(got, nonce) {
+		t.Fatalf("nonce %x echoed, want %x", got, nonce)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "max-age=0, no-cache" {
+		t.Fatalf("nonce response has Cache-Control %q", cc)
+	}
+
+	// The client can't already have a response echoing its nonce.
+	hreq, err := http.NewRequest("POST", server.URL, bytes.NewReader(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hreq.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if resp, err = http.DefaultClient.Do(hreq); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("conditional nonce request answered %s", resp.Status)
+	}
+
+	if resp, body = post(t, server, overlong); resp.StatusCode != http.StatusBadRequest ||
+		!bytes.Equal(body, malformedRequestErrorResponse) {
+		t.Fatal("overlong nonce was not rejected as malformed")
+	}
+}
+
+func TestMultiSource(t *testing.T) {
+	src := NewMultiSource()
+	var certs []*x509.Certificate
+	var issuers []*testIssuer
+	for _, name := range []string{"first CA", "second CA"} {
+		ca := newTestIssuer(t, name)
+		cert, rec := ca.issue(t, 10, "good")
+		accessor := &recordAccessor{records: map[string]certdb.CertificateRecord{rec.Serial: rec}}
+		// Each issuer delegates to its own responder.
+		responder := newTestIssuer(t, name+" responder")
+		signer, _ := NewSigner(ca.cert, responder.cert, responder.key, time.Hour)
+		if err := src.Add(ca.cert, NewLiveSource(ca.cert, signer, accessor)); err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+		issuers = append(issuers, ca)
+	}
+	server := newLiveServer(src)
+	defer server.Close()
+
+	for i, cert := range certs {
+		for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256} {
+			req, err := goocsp.CreateRequest(cert, issuers[i].cert, &goocsp.RequestOptions{Hash: hash})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, body := post(t, server, req)
+			parsed, err := goocsp.ParseResponse(body, nil)
+			if err != nil {
+				t.Fatalf("issuer %d, %v CertID: %v", i, hash, err)
+			}
+			if parsed.Certificate == nil || parsed.Certificate.Subject.CommonName != issuers[i].cert.Subject.CommonName+" responder" {
+				t.Fatalf("issuer %d answered by the wrong responder", i)
+			}
+		}
+	}
+
+	other := newTestIssuer(t, "other CA")
+	cert, _ := other.issue(t, 10, "good")
+	req, _ := goocsp.CreateRequest(cert, other.cert, nil)
+	if _, body := post(t, server, req); !bytes.Equal(body, unauthorizedErrorResponse) {
+		t.Fatal("request for an unknown issuer was not unauthorized")
+	}
+}
@@ -2,7 +2,8 @@
 
 Package ocsp exposes OCSP signing functionality, much like the signer
 package does for certificate signing.  It also provies a basic OCSP
-responder stack for serving pre-signed OCSP responses.
+responder stack for serving pre-signed OCSP responses, or responses
+signed on demand for one or more issuers.
 
 */
 package ocsp
@@ -10,8 +11,10 @@ package ocsp
 import (
 	"bytes"
 	"crypto"
+	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"io/ioutil"
 	"strconv"
 	"strings"
@@ -19,6 +22,7 @@ import (
 
 	cferr "github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/keysource"
 	"github.com/cloudflare/cfssl/log"
 	"golang.org/x/crypto/ocsp"
 )
@@ -62,6 +66,10 @@ type SignRequest struct {
 	ThisUpdate *time.Time
 	// If provided NextUpdate will override the default usage of ThisUpdate.Add(signerInterval)
 	NextUpdate *time.Time
+	// ResponseExtensions are added to the response as a whole, as
+	// the nonce extension must be, while Extensions are added to the
+	// response for the certificate.
+	ResponseExtensions []pkix.Extension
 }
 
 // Signer represents a general signer of OCSP responses.  It is
@@ -118,7 +126,13 @@ func NewSignerFromFile(issuerFile, responderFile, keyFile string, interval time.
 		return nil, err
 	}
 	log.Debug("Loading responder key: ", keyFile)
-	keyBytes, err := ioutil.ReadFile(keyFile)
+	var keyBytes []byte
+	if keysource.IsSpec(keyFile) {
+		// The key source is configured from the environment.
+		keyBytes, err = keysource.Read(keyFile, nil)
+	} else {
+		keyBytes, err = ioutil.ReadFile(keyFile)
+	}
 	if err != nil {
 		return nil, cferr.Wrap(cferr.CertificateError, cferr.ReadFailed, err)
 	}
@@ -133,7 +147,7 @@ func NewSignerFromFile(issuerFile, responderFile, keyFile string, interval time.
 		return nil, err
 	}
 
-	key, err := helpers.ParsePrivateKeyPEM(keyBytes)
+	key, err := helpers.ParsePrivateKeyPEMFromEnv(keyBytes)
 	if err != nil {
 		log.Debug("Malformed private key %v", err)
 		return nil, err
@@ -211,5 +225,89 @@ func (s StandardSigner) Sign(req SignRequest) ([]byte, error) {
 		template.RevocationReason = req.Reason
 	}
 
-	return ocsp.CreateResponse(s.issuer, s.responder, template, s.key)
+	resp, err := ocsp.CreateResponse(s.issuer, s.responder, template, s.key)
+	if err != nil || len(req.ResponseExtensions) == 0 {
+		return resp, err
+	}
+	return addResponseExtensions(resp, req.ResponseExtensions, s.key)
+}
+
+// The structures of an OCSP response (RFC 6960), as far as needed to
+// add response extensions, which ocsp.CreateResponse cannot.
+type responseASN1 struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type basicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Version            int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID     asn1.RawValue
+	ProducedAt         time.Time `asn1:"generalized"`
+	Responses          []asn1.RawValue
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// signatureHashes are the hashes of the signature algorithms
+// ocsp.CreateResponse signs with.
+var signatureHashes = map[string]crypto.Hash{
+	"1.2.840.113549.1.1.5":  crypto.SHA1,
+	"1.2.840.113549.1.1.11": crypto.SHA256,
+	"1.2.840.113549.1.1.12": crypto.SHA384,
+	"1.2.840.113549.1.1.13": crypto.SHA512,
+	"1.2.840.10045.4.1":     crypto.SHA1,
+	"1.2.840.10045.4.3.2":   crypto.SHA256,
+	"1.2.840.10045.4.3.3":   crypto.SHA384,
+	"1.2.840.10045.4.3.4":   crypto.SHA512,
+}
+
+// addResponseExtensions adds exts to the response extensions of the
+// DER response, signing it again with key.
+func addResponseExtensions(der []byte, exts []pkix.Extension, key crypto.Signer) ([]byte, error) {
+	var resp responseASN1
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	}
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, err
+	}
+	var tbs responseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &tbs); err != nil {
+		return nil, err
+	}
+	tbs.ResponseExtensions = append(tbs.ResponseExtensions, exts...)
+	tbsDER, err := asn1.Marshal(tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, ok := signatureHashes[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, x509.ErrUnsupportedAlgorithm
+	}
+	h := hash.New()
+	h.Write(tbsDER)
+	signature, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	basic.TBSResponseData = asn1.RawValue{FullBytes: tbsDER}
+	basic.Signature = asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)}
+	if resp.Response.Response, err = asn1.Marshal(basic); err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(resp)
 }
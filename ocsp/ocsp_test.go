@@ -2,6 +2,7 @@ package ocsp
 
 import (
 	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 
@@ -208,3 +209,32 @@ func TestSignCustomInterval(t *testing.T) {
 		t.Fatalf("Unexpected NextUpdate: wanted %s, got %s", next, resp.NextUpdate)
 	}
 }
+
+func TestNewSignerFromFileEncryptedKey(t *testing.T) {
+	keyPEM, err := ioutil.ReadFile(serverKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encPEM, err := helpers.EncryptPrivateKeyPEM(keyPEM, []byte("password"), "aes256-gcm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "cfssl-responder-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(encPEM)
+	f.Close()
+
+	defer os.Unsetenv("CFSSL_CA_PK_PASSWORD")
+	os.Unsetenv("CFSSL_CA_PK_PASSWORD")
+	if _, err = NewSignerFromFile(serverCertFile, serverCertFile, f.Name(), time.Hour); err == nil {
+		t.Fatal("expected an encrypted key without a password to be rejected")
+	}
+
+	os.Setenv("CFSSL_CA_PK_PASSWORD", "password")
+	if _, err = NewSignerFromFile(serverCertFile, serverCertFile, f.Name(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
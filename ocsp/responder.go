@@ -13,10 +13,12 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/cloudflare/cfssl/certdb"
@@ -176,11 +178,24 @@ func NewSourceFromDB(DBConfigFile string) (Source, error) {
 	return src, nil
 }
 
+// MaxGETRequestLength is the longest base64 request a Responder accepts
+// by GET. RFC 5019 has clients POST requests longer than 255 bytes, but
+// other clients, such as the revoke package, GET requests of up to 256
+// bytes before encoding, so some room is left.
+const MaxGETRequestLength = 512
+
+// maxPOSTRequestLength bounds the body of POST requests.
+const maxPOSTRequestLength = 1 << 16
+
 // A Responder object provides the HTTP logic to expose a
 // Source of OCSP responses.
 type Responder struct {
 	Source Source
-	clk    clock.Clock
+	// StaleIfError, if positive, lets HTTP caches serve a response
+	// for that long past its expiry when the responder fails, with
+	// the stale-if-error directive of RFC 5861.
+	StaleIfError time.Duration
+	clk          clock.Clock
 }
 
 // NewResponder instantiates a Responder with the give Source.
@@ -204,32 +219,52 @@ func overrideHeaders(response http.ResponseWriter, headers http.Header) {
 	}
 }
 
-// A Responder can process both GET and POST requests.  The mapping
-// from an OCSP request to an OCSP response is done by the Source;
-// the Responder simply decodes the request, and passes back whatever
-// response is provided by the source.
-// Note: The caller must use http.StripPrefix to strip any path components
-// (including '/') on GET requests.
-// Do not use this responder in conjunction with http.NewServeMux, because the
-// default handler will try to canonicalize path components by changing any
-// strings of repeated '/' into a single '/', which will break the base64
-// encoding.
-func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Request) {
-	// By default we set a 'max-age=0, no-cache' Cache-Control header, this
-	// is only returned to the client if a valid authorized OCSP response
-	// is not found or an error is returned. If a response if found the header
-	// will be altered to contain the proper max-age and modifiers.
-	response.Header().Add("Cache-Control", "max-age=0, no-cache")
-	// Read response from request
-	var requestBody []byte
-	var err error
+// writeError replies with an OCSP error response, or no body if it is
+// nil, which no cache may keep.
+func writeError(response http.ResponseWriter, status int, body []byte) {
+	response.Header().Set("Cache-Control", "max-age=0, no-cache")
+	if body != nil {
+		response.Header().Set("Content-Type", "application/ocsp-response")
+	}
+	response.WriteHeader(status)
+	response.Write(body)
+}
+
+// etagMatch reports whether the If-None-Match header value matches
+// etag, comparing weakly as RFC 7232 requires.
+func etagMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified reports whether the conditional headers of request hold
+// for a response with the etag, last modified at lastModified.
+func notModified(request *http.Request, etag string, lastModified time.Time) bool {
+	// If-Modified-Since is only considered without If-None-Match.
+	if header := request.Header.Get("If-None-Match"); header != "" {
+		return etagMatch(header, etag)
+	}
+	if header := request.Header.Get("If-Modified-Since"); header != "" {
+		since, err := http.ParseTime(header)
+		return err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// readRequest returns the DER request from the URL of a GET or the
+// body of a POST, or the HTTP status to fail with.
+func readRequest(request *http.Request) ([]byte, int) {
 	switch request.Method {
 	case "GET":
 		base64Request, err := url.QueryUnescape(request.URL.Path)
 		if err != nil {
 			log.Debugf("Error decoding URL: %s", request.URL.Path)
-			response.WriteHeader(http.StatusBadRequest)
-			return
+			return nil, http.StatusBadRequest
 		}
 		// url.QueryUnescape not only unescapes %2B escaping, but it additionally
 		// turns the resulting '+' into a space, which makes base64 decoding fail.
@@ -248,21 +283,52 @@ func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Reques
 		if len(base64RequestBytes) > 0 && base64RequestBytes[0] == '/' {
 			base64RequestBytes = base64RequestBytes[1:]
 		}
-		requestBody, err = base64.StdEncoding.DecodeString(string(base64RequestBytes))
+		if len(base64RequestBytes) > MaxGETRequestLength {
+			log.Debugf("GET request of %d bytes is too long", len(base64RequestBytes))
+			return nil, http.StatusRequestURITooLong
+		}
+		requestBody, err := base64.StdEncoding.DecodeString(string(base64RequestBytes))
 		if err != nil {
 			log.Debugf("Error decoding base64 from URL: %s", string(base64RequestBytes))
-			response.WriteHeader(http.StatusBadRequest)
-			return
+			return nil, http.StatusBadRequest
 		}
+		return requestBody, http.StatusOK
 	case "POST":
-		requestBody, err = ioutil.ReadAll(request.Body)
+		requestBody, err := ioutil.ReadAll(io.LimitReader(request.Body, maxPOSTRequestLength+1))
 		if err != nil {
 			log.Errorf("Problem reading body of POST: %s", err)
-			response.WriteHeader(http.StatusBadRequest)
-			return
+			return nil, http.StatusBadRequest
+		}
+		if len(requestBody) > maxPOSTRequestLength {
+			log.Debugf("POST request is too long")
+			return nil, http.StatusRequestEntityTooLarge
 		}
+		return requestBody, http.StatusOK
 	default:
-		response.WriteHeader(http.StatusMethodNotAllowed)
+		return nil, http.StatusMethodNotAllowed
+	}
+}
+
+// A Responder can process both GET and POST requests.  The mapping
+// from an OCSP request to an OCSP response is done by the Source;
+// the Responder simply decodes the request, and passes back whatever
+// response is provided by the source.
+// Responses carry the caching headers of RFC 5019 and an ETag, and
+// conditional requests are answered with 304 Not Modified. Errors and
+// requests the Source has no response for are never cached.
+// Note: The caller must use http.StripPrefix to strip any path components
+// (including '/') on GET requests.
+// Do not use this responder in conjunction with http.NewServeMux, because the
+// default handler will try to canonicalize path components by changing any
+// strings of repeated '/' into a single '/', which will break the base64
+// encoding.
+func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	requestBody, status := readRequest(request)
+	if status != http.StatusOK {
+		if status == http.StatusMethodNotAllowed {
+			response.Header().Set("Allow", "GET, POST")
+		}
+		writeError(response, status, nil)
 		return
 	}
 	b64Body := base64.StdEncoding.EncodeToString(requestBody)
@@ -271,33 +337,45 @@ func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Reques
 	// All responses after this point will be OCSP.
 	// We could check for the content type of the request, but that
 	// seems unnecessariliy restrictive.
-	response.Header().Add("Content-Type", "application/ocsp-response")
 
 	// Parse response as an OCSP request
-	// XXX: This fails if the request contains the nonce extension.
-	//      We don't intend to support nonces anyway, but maybe we
-	//      should return unauthorizedRequest instead of malformed.
 	ocspRequest, err := ocsp.ParseRequest(requestBody)
 	if err != nil {
 		log.Debugf("Error decoding request body: %s", b64Body)
-		response.WriteHeader(http.StatusBadRequest)
-		response.Write(malformedRequestErrorResponse)
+		writeError(response, http.StatusBadRequest, malformedRequestErrorResponse)
 		return
 	}
 
-	// Look up OCSP response from source
-	ocspResponse, headers, err := rs.Source.Response(ocspRequest)
+	// Look up OCSP response from source. Sources that can answer
+	// nonces are given them; the others ignore them.
+	var ocspResponse []byte
+	var headers http.Header
+	var nonce []byte
+	if ns, ok := rs.Source.(NonceSource); ok {
+		if nonce, err = requestNonce(requestBody); err == nil && nonce != nil {
+			ocspResponse, headers, err = ns.NonceResponse(ocspRequest, nonce)
+		}
+	}
+	if nonce == nil {
+		ocspResponse, headers, err = rs.Source.Response(ocspRequest)
+	}
 	if err != nil {
+		if err == ErrBadNonce {
+			log.Debugf("Error decoding request nonce: %s", b64Body)
+			writeError(response, http.StatusBadRequest, malformedRequestErrorResponse)
+			return
+		}
 		if err == ErrNotFound {
+			// An OCSP error, not an HTTP one: RFC 5019 answers
+			// requests for unknown certificates "unauthorized".
 			log.Infof("No response found for request: serial %x, request body %s",
 				ocspRequest.SerialNumber, b64Body)
-			response.Write(unauthorizedErrorResponse)
+			writeError(response, http.StatusOK, unauthorizedErrorResponse)
 			return
 		}
 		log.Infof("Error retrieving response for request: serial %x, request body %s, error: %s",
 			ocspRequest.SerialNumber, b64Body, err)
-		response.WriteHeader(http.StatusInternalServerError)
-		response.Write(internalErrorErrorResponse)
+		writeError(response, http.StatusInternalServerError, internalErrorErrorResponse)
 		return
 	}
 
@@ -305,13 +383,15 @@ func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Reques
 	if err != nil {
 		log.Errorf("Error parsing response for serial %x: %s",
 			ocspRequest.SerialNumber, err)
-		response.Write(unauthorizedErrorResponse)
+		writeError(response, http.StatusInternalServerError, internalErrorErrorResponse)
 		return
 	}
 
 	// Write OCSP response to response
-	response.Header().Add("Last-Modified", parsedResponse.ThisUpdate.Format(time.RFC1123))
-	response.Header().Add("Expires", parsedResponse.NextUpdate.Format(time.RFC1123))
+	header := response.Header()
+	header.Set("Content-Type", "application/ocsp-response")
+	header.Set("Last-Modified", parsedResponse.ThisUpdate.UTC().Format(http.TimeFormat))
+	header.Set("Expires", parsedResponse.NextUpdate.UTC().Format(http.TimeFormat))
 	now := rs.clk.Now()
 	maxAge := 0
 	if now.Before(parsedResponse.NextUpdate) {
@@ -321,15 +401,14 @@ func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Reques
 		//             (despite being stale) and 5019 forbids attaching no-cache
 		maxAge = 0
 	}
-	response.Header().Set(
-		"Cache-Control",
-		fmt.Sprintf(
-			"max-age=%d, public, no-transform, must-revalidate",
-			maxAge,
-		),
-	)
+	cacheControl := fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", maxAge)
+	if rs.StaleIfError > 0 {
+		cacheControl += fmt.Sprintf(", stale-if-error=%d", int(rs.StaleIfError/time.Second))
+	}
+	header.Set("Cache-Control", cacheControl)
 	responseHash := sha256.Sum256(ocspResponse)
-	response.Header().Add("ETag", fmt.Sprintf("\"%X\"", responseHash))
+	etag := fmt.Sprintf("\"%X\"", responseHash)
+	header.Set("ETag", etag)
 
 	if headers != nil {
 		overrideHeaders(response, headers)
@@ -337,12 +416,12 @@ func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Reques
 
 	// RFC 7232 says that a 304 response must contain the above
 	// headers if they would also be sent for a 200 for the same
-	// request, so we have to wait until here to do this
-	if etag := request.Header.Get("If-None-Match"); etag != "" {
-		if etag == fmt.Sprintf("\"%X\"", responseHash) {
-			response.WriteHeader(http.StatusNotModified)
-			return
-		}
+	// request, so we have to wait until here to do this. A
+	// response to a request carrying a nonce is never the one the
+	// client has.
+	if nonce == nil && notModified(request, etag, parsedResponse.ThisUpdate) {
+		response.WriteHeader(http.StatusNotModified)
+		return
 	}
 	response.WriteHeader(http.StatusOK)
 	response.Write(ocspResponse)
@@ -1,12 +1,15 @@
 package ocsp
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -29,7 +32,8 @@ const (
 type testSource struct{}
 
 func (ts testSource) Response(r *goocsp.Request) ([]byte, http.Header, error) {
-	return []byte("hi"), nil, nil
+	resp, _ := hex.DecodeString(testResp)
+	return resp, nil, nil
 }
 
 type testCase struct {
@@ -138,8 +142,8 @@ func TestCacheHeaders(t *testing.T) {
 		header string
 		value  string
 	}{
-		{"Last-Modified", "Tue, 20 Oct 2015 00:00:00 UTC"},
-		{"Expires", "Sun, 20 Oct 2030 00:00:00 UTC"},
+		{"Last-Modified", "Tue, 20 Oct 2015 00:00:00 GMT"},
+		{"Expires", "Sun, 20 Oct 2030 00:00:00 GMT"},
 		{"Cache-Control", "max-age=471398400, public, no-transform, must-revalidate"},
 		{"Etag", "\"8169FB0843B081A76E9F6F13FD70C8411597BEACF8B182136FFDD19FBD26140A\""},
 	}
@@ -361,3 +365,135 @@ func TestNewSqliteSource(t *testing.T) {
 		t.Errorf("Error connecting to Sqlite DB: %v", err)
 	}
 }
+
+type errorSource struct {
+	err  error
+	resp []byte
+}
+
+func (es errorSource) Response(r *goocsp.Request) ([]byte, http.Header, error) {
+	return es.resp, nil, es.err
+}
+
+const testRequestPath = "MFQwUjBQME4wTDAJBgUrDgMCGgUABBQ55F6w46hhx%2Fo6OXOHa%2BYfe32YhgQU%2B3hPEvlgFYMsnxd%2FNBmzLjbqQYkCEwD6Wh0MaVKu9gJ3By9DI%2F%2Fxsd4%3D"
+
+func serveGET(responder Responder, header http.Header) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	if header == nil {
+		header = http.Header{}
+	}
+	responder.ServeHTTP(rw, &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: testRequestPath},
+		Header: header,
+	})
+	return rw
+}
+
+func TestConditionalRequests(t *testing.T) {
+	der, _ := hex.DecodeString(testResp)
+	parsed, err := goocsp.ParseResponse(der, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := clock.NewFake()
+	fc.Set(parsed.ThisUpdate.Add(time.Hour))
+	responder := Responder{Source: testSource{}, clk: fc}
+	etag := serveGET(responder, nil).Header().Get("ETag")
+
+	lastModified := parsed.ThisUpdate.UTC().Format(http.TimeFormat)
+	earlier := parsed.ThisUpdate.Add(-time.Second).UTC().Format(http.TimeFormat)
+	cases := []struct {
+		header   http.Header
+		expected int
+	}{
+		{http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": {`"other", W/` + etag}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": {`"other"`}}, http.StatusOK},
+		{http.Header{"If-Modified-Since": {lastModified}}, http.StatusNotModified},
+		{http.Header{"If-Modified-Since": {earlier}}, http.StatusOK},
+		{http.Header{"If-Modified-Since": {"yesterday"}}, http.StatusOK},
+		// If-None-Match takes precedence over If-Modified-Since.
+		{http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {lastModified}}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		rw := serveGET(responder, tc.header)
+		if rw.Code != tc.expected {
+			t.Errorf("%v: got status %d, wanted %d", tc.header, rw.Code, tc.expected)
+		}
+		if rw.Code == http.StatusNotModified {
+			if rw.Body.Len() != 0 {
+				t.Errorf("%v: 304 response has a body", tc.header)
+			}
+			if rw.Header().Get("ETag") != etag || rw.Header().Get("Expires") == "" {
+				t.Errorf("%v: 304 response lacks the caching headers", tc.header)
+			}
+		}
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	der, _ := hex.DecodeString(testResp)
+	parsed, err := goocsp.ParseResponse(der, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := clock.NewFake()
+	fc.Set(parsed.NextUpdate.Add(-time.Hour))
+	responder := Responder{Source: testSource{}, clk: fc}
+
+	if cc := serveGET(responder, nil).Header().Get("Cache-Control"); cc != "max-age=3600, public, no-transform, must-revalidate" {
+		t.Errorf("fresh response has Cache-Control %q", cc)
+	}
+
+	responder.StaleIfError = 24 * time.Hour
+	if cc := serveGET(responder, nil).Header().Get("Cache-Control"); cc != "max-age=3600, public, no-transform, must-revalidate, stale-if-error=86400" {
+		t.Errorf("Cache-Control %q with stale-if-error", cc)
+	}
+
+	// Past its next update, the response may not be cached.
+	fc.Add(2 * time.Hour)
+	responder.StaleIfError = 0
+	if cc := serveGET(responder, nil).Header().Get("Cache-Control"); cc != "max-age=0, public, no-transform, must-revalidate" {
+		t.Errorf("stale response has Cache-Control %q", cc)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	der, _ := hex.DecodeString(testResp)
+	cases := []struct {
+		name     string
+		source   Source
+		method   string
+		path     string
+		body     []byte
+		expected int
+		response []byte
+	}{
+		{"not found", errorSource{err: ErrNotFound}, "GET", testRequestPath, nil, http.StatusOK, unauthorizedErrorResponse},
+		{"source error", errorSource{err: errors.New("down")}, "GET", testRequestPath, nil, http.StatusInternalServerError, internalErrorErrorResponse},
+		{"bad response", errorSource{resp: []byte("hi")}, "GET", testRequestPath, nil, http.StatusInternalServerError, internalErrorErrorResponse},
+		{"method", errorSource{resp: der}, "PUT", "", nil, http.StatusMethodNotAllowed, nil},
+		{"long GET", errorSource{resp: der}, "GET", strings.Repeat("A", MaxGETRequestLength+1), nil, http.StatusRequestURITooLong, nil},
+		{"large POST", errorSource{resp: der}, "POST", "", make([]byte, maxPOSTRequestLength+1), http.StatusRequestEntityTooLarge, nil},
+	}
+	for _, tc := range cases {
+		responder := Responder{Source: tc.source, clk: clock.NewFake()}
+		rw := httptest.NewRecorder()
+		responder.ServeHTTP(rw, &http.Request{
+			Method: tc.method,
+			URL:    &url.URL{Path: tc.path},
+			Body:   ioutil.NopCloser(bytes.NewReader(tc.body)),
+		})
+		if rw.Code != tc.expected {
+			t.Errorf("%s: got status %d, wanted %d", tc.name, rw.Code, tc.expected)
+		}
+		if !bytes.Equal(rw.Body.Bytes(), tc.response) {
+			t.Errorf("%s: got body %x, wanted %x", tc.name, rw.Body.Bytes(), tc.response)
+		}
+		if cc := rw.Header().Get("Cache-Control"); cc != "max-age=0, no-cache" {
+			t.Errorf("%s: error has Cache-Control %q", tc.name, cc)
+		}
+	}
+}
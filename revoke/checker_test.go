@@ -0,0 +1,352 @@
+package revoke
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/cloudflare/cfssl/aia"
+)
+
+var (
+	goodSerial    = big.NewInt(10)
+	revokedSerial = big.NewInt(11)
+	testStart     = time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// testPKI is a CA serving its certificate, a CRL and OCSP responses,
+// counting the requests for each.
+type testPKI struct {
+	t      *testing.T
+	ca     *x509.Certificate
+	key    crypto.Signer
+	server *httptest.Server
+
+	lock  sync.Mutex
+	hits  map[string]int
+	crl   []byte
+	clock time.Time
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "revoke test CA"},
+		NotBefore:             testStart.Add(-time.Hour),
+		NotAfter:              testStart.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testPKI{t: t, ca: ca, key: key, hits: map[string]int{}, clock: testStart}
+	p.crl = p.signCRL(testStart.Add(time.Hour))
+	p.server = httptest.NewServer(http.HandlerFunc(p.serve))
+	return p
+}
+
+func (p *testPKI) signCRL(nextUpdate time.Time) []byte {
+	revoked := []pkix.RevokedCertificate{
+		{SerialNumber: revokedSerial, RevocationTime: testStart},
+	}
+	crl, err := p.ca.CreateCRL(rand.Reader, p.key, revoked, testStart, nextUpdate)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return crl
+}
+
+func (p *testPKI) ocspResponse(serial *big.Int, thisUpdate time.Time) []byte {
+	status := ocsp.Good
+	if serial.Cmp(revokedSerial) == 0 {
+		status = ocsp.Revoked
+	}
+	resp, err := ocsp.CreateResponse(p.ca, p.ca, ocsp.Response{
+		Status:       status,
+		SerialNumber: serial,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   thisUpdate.Add(time.Hour),
+		RevokedAt:    testStart,
+	}, p.key)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return resp
+}
+
+func (p *testPKI) serve(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	switch {
+	case r.URL.Path == "/ca.crt":
+		p.hits["ca"]++
+		w.Write(p.ca.Raw)
+	case r.URL.Path == "/ca.crl":
+		p.hits["crl"]++
+		w.Write(p.crl)
+	case strings.HasPrefix(r.URL.Path, "/ocsp/"):
+		p.hits["ocsp"]++
+		der, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/ocsp/"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := ocsp.ParseRequest(der)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(p.ocspResponse(req.SerialNumber, p.clock))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *testPKI) count(what string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.hits[what]
+}
+
+// leaf issues a certificate with the given serial, checked through the
+// given CRL and OCSP URLs.
+func (p *testPKI) leaf(serial *big.Int, crlURL, ocspURL string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "leaf"},
+		NotBefore:             testStart.Add(-time.Hour),
+		NotAfter:              testStart.Add(30 * 24 * time.Hour),
+		IssuingCertificateURL: []string{p.server.URL + "/ca.crt"},
+	}
+	if crlURL != "" {
+		tmpl.CRLDistributionPoints = []string{crlURL}
+	}
+	if ocspURL != "" {
+		tmpl.OCSPServer = []string{ocspURL}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, key.Public(), p.key)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return cert
+}
+
+func (p *testPKI) checker(cfg Config) (*Checker, func(time.Time)) {
+	if cfg.Issuers == nil {
+		cfg.Issuers = aia.New(aia.Config{})
+	}
+	c := NewChecker(cfg)
+	now := testStart
+	var lock sync.Mutex
+	c.now = func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		return now
+	}
+	return c, func(t time.Time) {
+		lock.Lock()
+		now = t
+		lock.Unlock()
+		p.lock.Lock()
+		p.clock = t
+		p.lock.Unlock()
+	}
+}
+
+func TestCheckerCRLFirst(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.server.Close()
+	crlURL, ocspURL := p.server.URL+"/ca.crl", p.server.URL+"/ocsp"
+	c, setNow := p.checker(Config{})
+
+	good := p.leaf(goodSerial, crlURL, ocspURL)
+	for i := 0; i < 2; i++ {
+		if revoked, ok := c.Check(good); revoked || !ok {
+			t.Fatalf("good certificate: revoked=%v ok=%v", revoked, ok)
+		}
+	}
+	if n := p.count("crl"); n != 1 {
+		t.Fatalf("CRL fetched %d times, want 1 until its next update", n)
+	}
+	if n := p.count("ocsp"); n != 1 {
+		t.Fatalf("OCSP queried %d times, want 1 until its next update", n)
+	}
+
+	revoked := p.leaf(revokedSerial, crlURL, ocspURL)
+	if revoked, ok := c.Check(revoked); !revoked || !ok {
+		t.Fatalf("revoked certificate: revoked=%v ok=%v", revoked, ok)
+	}
+	if n := p.count("ocsp"); n != 1 {
+		t.Fatalf("OCSP queried after the CRL found the certificate revoked")
+	}
+
+	// Past the CRL's next update, it is fetched again.
+	setNow(testStart.Add(2 * time.Hour))
+	if revoked, ok := c.Check(good); revoked || !ok {
+		t.Fatalf("good certificate: revoked=%v ok=%v", revoked, ok)
+	}
+	if n := p.count("crl"); n != 2 {
+		t.Fatalf("CRL fetched %d times, want 2 after its next update", n)
+	}
+	if n := p.count("ocsp"); n != 2 {
+		t.Fatalf("OCSP queried %d times, want 2 after its next update", n)
+	}
+}
+
+func TestCheckerOCSPFirst(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.server.Close()
+	crlURL, ocspURL := p.server.URL+"/ca.crl", p.server.URL+"/ocsp"
+	c, _ := p.checker(Config{Order: OCSPFirst})
+
+	if revoked, ok := c.Check(p.leaf(goodSerial, crlURL, ocspURL)); revoked || !ok {
+		t.Fatalf("good certificate: revoked=%v ok=%v", revoked, ok)
+	}
+	if revoked, ok := c.Check(p.leaf(revokedSerial, crlURL, ocspURL)); !revoked || !ok {
+		t.Fatalf("revoked certificate: revoked=%v ok=%v", revoked, ok)
+	}
+	if n := p.count("crl"); n != 0 {
+		t.Fatalf("CRL fetched although OCSP answered")
+	}
+
+	// When the responder fails, the CRL answers.
+	c, _ = p.checker(Config{Order: OCSPFirst})
+	down := p.server.URL + "/down"
+	if revoked, ok := c.Check(p.leaf(revokedSerial, crlURL, down)); !revoked || !ok {
+		t.Fatalf("revoked certificate via CRL: revoked=%v ok=%v", revoked, ok)
+	}
+	if n := p.count("crl"); n != 1 {
+		t.Fatalf("CRL fetched %d times, want 1", n)
+	}
+
+	// With neither answering, the check fails.
+	if revoked, ok := c.Check(p.leaf(goodSerial, "", down)); revoked || ok {
+		t.Fatalf("unreachable responder: revoked=%v ok=%v", revoked, ok)
+	}
+}
+
+func TestCheckerHardFail(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.server.Close()
+	cert := p.leaf(goodSerial, p.server.URL+"/missing.crl", "")
+
+	soft, _ := p.checker(Config{})
+	if revoked, ok := soft.Check(cert); revoked || ok {
+		t.Fatalf("soft fail: revoked=%v ok=%v", revoked, ok)
+	}
+	hard, _ := p.checker(Config{HardFail: true})
+	if revoked, ok := hard.Check(cert); !revoked || ok {
+		t.Fatalf("hard fail: revoked=%v ok=%v", revoked, ok)
+	}
+}
+
+func TestCheckerStapled(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.server.Close()
+	ocspURL := p.server.URL + "/ocsp"
+	c, _ := p.checker(Config{Order: OCSPFirst})
+
+	good := p.leaf(goodSerial, "", ocspURL)
+	if revoked, ok := c.CheckStapled(good, p.ca, p.ocspResponse(goodSerial, testStart)); revoked || !ok {
+		t.Fatalf("good staple: revoked=%v ok=%v", revoked, ok)
+	}
+	revoked := p.leaf(revokedSerial, "", ocspURL)
+	if revoked, ok := c.CheckStapled(revoked, p.ca, p.ocspResponse(revokedSerial, testStart)); !revoked || !ok {
+		t.Fatalf("revoked staple: revoked=%v ok=%v", revoked, ok)
+	}
+	if n := p.count("ocsp"); n != 0 {
+		t.Fatalf("responder queried although the responses were stapled")
+	}
+
+	// A stale staple, or one for another certificate, is ignored.
+	c, _ = p.checker(Config{Order: OCSPFirst})
+	stale := p.ocspResponse(revokedSerial, testStart.Add(-2*time.Hour))
+	if revoked, ok := c.CheckStapled(good, p.ca, stale); revoked || !ok {
+		t.Fatalf("invalid staple: revoked=%v ok=%v", revoked, ok)
+	}
+	if n := p.count("ocsp"); n != 1 {
+		t.Fatalf("responder queried %d times, want 1 for an invalid staple", n)
+	}
+}
+
+type countingTransport struct {
+	lock  sync.Mutex
+	count int
+}
+
+func (ct *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ct.lock.Lock()
+	ct.count++
+	ct.lock.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestCheckerClient(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.server.Close()
+	ct := new(countingTransport)
+	c, _ := p.checker(Config{Client: &http.Client{Transport: ct}})
+
+	cert := p.leaf(goodSerial, p.server.URL+"/ca.crl", p.server.URL+"/ocsp")
+	if revoked, ok := c.Check(cert); revoked || !ok {
+		t.Fatalf("good certificate: revoked=%v ok=%v", revoked, ok)
+	}
+	// The CRL and the OCSP response; the issuer comes from the cache.
+	if ct.count != 2 {
+		t.Fatalf("client made %d requests, want 2", ct.count)
+	}
+}
+
+func TestCheckerCacheBounds(t *testing.T) {
+	c := NewChecker(Config{MaxCRLs: 2})
+	for i, next := range []time.Duration{3, 1, 2} {
+		url := string(rune('a' + i))
+		crl := &pkix.CertificateList{}
+		crl.TBSCertList.NextUpdate = testStart.Add(next * time.Hour)
+		c.crls.put(url, crl)
+	}
+	if _, ok := c.crls.get("b", testStart); ok {
+		t.Fatal("the CRL due for update first was not evicted")
+	}
+	for _, url := range []string{"a", "c"} {
+		if _, ok := c.crls.get(url, testStart); !ok {
+			t.Fatalf("CRL %s evicted", url)
+		}
+	}
+	if _, ok := c.crls.get("a", testStart.Add(4*time.Hour)); ok {
+		t.Fatal("CRL returned past its next update")
+	}
+}
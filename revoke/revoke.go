@@ -1,15 +1,19 @@
 // Package revoke provides functionality for checking the validity of
 // a cert. Specifically, the temporal validity of the certificate is
 // checked first, then any CRL and OCSP url in the cert is checked.
+//
+// A Checker holds the policy, caches and HTTP client used for the
+// checks. The package-level functions use a default Checker configured
+// by the package variables.
 package revoke
 
 import (
 	"bytes"
 	"crypto"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
-	"encoding/pem"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -20,7 +24,7 @@ import (
 
 	"golang.org/x/crypto/ocsp"
 
-	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/aia"
 	"github.com/cloudflare/cfssl/log"
 )
 
@@ -30,10 +34,216 @@ import (
 var HardFail = false
 
 // CRLSet associates a PKIX certificate list with the URL the CRL is
-// fetched from.
+// fetched from. It is the CRL cache of the package-level functions.
 var CRLSet = map[string]*pkix.CertificateList{}
 var crlLock = new(sync.Mutex)
 
+// Order is the order in which a Checker consults CRLs and OCSP.
+type Order int
+
+const (
+	// CRLFirst checks the CRLs, then OCSP. Both must succeed.
+	CRLFirst Order = iota
+	// OCSPFirst checks OCSP, and the CRLs only when OCSP gives no
+	// answer, because the certificate names no responder or none
+	// could be reached.
+	OCSPFirst
+)
+
+const (
+	// DefaultMaxCRLs is the number of CRLs a Checker caches.
+	DefaultMaxCRLs = 64
+	// DefaultMaxOCSPResponses is the number of OCSP responses a
+	// Checker caches.
+	DefaultMaxOCSPResponses = 4096
+)
+
+// Config configures a Checker. Zero fields take the defaults.
+type Config struct {
+	// HardFail makes a failure to check the revocation status of
+	// a certificate fail its verification.
+	HardFail bool
+	// Order is the order in which CRLs and OCSP are consulted.
+	Order Order
+	// Client fetches CRLs and OCSP responses. It defaults to
+	// http.DefaultClient.
+	Client *http.Client
+	// Issuers fetches the issuers of certificates, to verify CRLs
+	// and OCSP responses. It defaults to aia.Default.
+	Issuers *aia.Cache
+	// OCSPHash is the hash used in OCSP requests. It defaults to
+	// SHA-1, which all responders support.
+	OCSPHash crypto.Hash
+	// MaxCRLs is the number of CRLs cached.
+	MaxCRLs int
+	// MaxOCSPResponses is the number of OCSP responses cached.
+	MaxOCSPResponses int
+}
+
+// A Checker checks the revocation status of certificates. CRLs are
+// cached until their next update, as are OCSP responses. It is safe
+// for concurrent use.
+type Checker struct {
+	cfg       Config
+	crls      *crlCache
+	responses *ocspCache
+	crlRead   func(io.Reader) ([]byte, error)
+	ocspRead  func(io.Reader) ([]byte, error)
+	now       func() time.Time
+}
+
+// NewChecker returns a Checker configured by cfg.
+func NewChecker(cfg Config) *Checker {
+	if cfg.MaxCRLs <= 0 {
+		cfg.MaxCRLs = DefaultMaxCRLs
+	}
+	if cfg.MaxOCSPResponses <= 0 {
+		cfg.MaxOCSPResponses = DefaultMaxOCSPResponses
+	}
+	crls := map[string]*pkix.CertificateList{}
+	return &Checker{
+		cfg:       cfg,
+		crls:      &crlCache{lock: new(sync.Mutex), set: &crls, max: cfg.MaxCRLs},
+		responses: &ocspCache{entries: map[[sha256.Size]byte]*ocsp.Response{}, max: cfg.MaxOCSPResponses},
+		crlRead:   ioutil.ReadAll,
+		ocspRead:  ioutil.ReadAll,
+		now:       time.Now,
+	}
+}
+
+var (
+	defaultCRLs      = &crlCache{lock: crlLock, set: &CRLSet, max: DefaultMaxCRLs}
+	defaultResponses = &ocspCache{entries: map[[sha256.Size]byte]*ocsp.Response{}, max: DefaultMaxOCSPResponses}
+)
+
+// defaultChecker returns the Checker of the package-level functions,
+// configured by the package variables as they are now.
+func defaultChecker() *Checker {
+	return &Checker{
+		cfg:       Config{HardFail: HardFail},
+		crls:      defaultCRLs,
+		responses: defaultResponses,
+		crlRead:   func(r io.Reader) ([]byte, error) { return crlRead(r) },
+		ocspRead:  func(r io.Reader) ([]byte, error) { return ocspRead(r) },
+		now:       time.Now,
+	}
+}
+
+func (c *Checker) client() *http.Client {
+	if c.cfg.Client != nil {
+		return c.cfg.Client
+	}
+	return http.DefaultClient
+}
+
+// fetchIssuer fetches an issuer certificate through the checker's
+// cache, or else through aia.Default, read by the function given to
+// SetRemoteFetcher.
+func (c *Checker) fetchIssuer(url string) (*x509.Certificate, error) {
+	if c.cfg.Issuers != nil {
+		return c.cfg.Issuers.Fetch(url)
+	}
+	return fetchRemote(url)
+}
+
+// crlCache holds CRLs by URL, evicting those due for update first.
+type crlCache struct {
+	lock *sync.Mutex
+	set  *map[string]*pkix.CertificateList
+	max  int
+}
+
+// get returns the CRL cached for url, if it has not passed its next
+// update.
+func (cc *crlCache) get(url string, now time.Time) (*pkix.CertificateList, bool) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	crl, ok := (*cc.set)[url]
+	if ok && crl == nil {
+		delete(*cc.set, url)
+		return nil, false
+	}
+	if !ok || crl.HasExpired(now) {
+		return nil, false
+	}
+	return crl, true
+}
+
+func (cc *crlCache) put(url string, crl *pkix.CertificateList) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	set := *cc.set
+	set[url] = crl
+	for len(set) > cc.max {
+		var oldest string
+		for u, l := range set {
+			if u != url && (oldest == "" || l == nil || l.TBSCertList.NextUpdate.Before(set[oldest].TBSCertList.NextUpdate)) {
+				oldest = u
+				if l == nil {
+					break
+				}
+			}
+		}
+		delete(set, oldest)
+	}
+}
+
+// ocspCache holds OCSP responses by issuer and serial number, evicting
+// those due for update first.
+type ocspCache struct {
+	lock    sync.Mutex
+	entries map[[sha256.Size]byte]*ocsp.Response
+	max     int
+}
+
+func ocspKey(cert, issuer *x509.Certificate) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(issuer.RawSubjectPublicKeyInfo)
+	h.Write(cert.SerialNumber.Bytes())
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// get returns the response cached for cert, if it has not passed its
+// next update.
+func (oc *ocspCache) get(cert, issuer *x509.Certificate, now time.Time) (*ocsp.Response, bool) {
+	oc.lock.Lock()
+	defer oc.lock.Unlock()
+	key := ocspKey(cert, issuer)
+	resp, ok := oc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(resp.NextUpdate) {
+		delete(oc.entries, key)
+		return nil, false
+	}
+	return resp, true
+}
+
+// put caches resp. Responses without a next update promise nothing
+// about the future and are not cached.
+func (oc *ocspCache) put(cert, issuer *x509.Certificate, resp *ocsp.Response) {
+	if resp.NextUpdate.IsZero() {
+		return
+	}
+	oc.lock.Lock()
+	defer oc.lock.Unlock()
+	key := ocspKey(cert, issuer)
+	oc.entries[key] = resp
+	for len(oc.entries) > oc.max {
+		var oldest [sha256.Size]byte
+		var oldestResp *ocsp.Response
+		for k, r := range oc.entries {
+			if k != key && (oldestResp == nil || r.NextUpdate.Before(oldestResp.NextUpdate)) {
+				oldest, oldestResp = k, r
+			}
+		}
+		delete(oc.entries, oldest)
+	}
+}
+
 // We can't handle LDAP certificates, so this checks to see if the
 // URL string points to an LDAP resource so that we can ignore it.
 func ldapURL(url string) bool {
@@ -48,6 +258,24 @@ func ldapURL(url string) bool {
 	return false
 }
 
+// status is the outcome of checking a certificate with one method.
+type status int
+
+const (
+	// unchecked means the certificate names no URL for the method.
+	unchecked status = iota
+	good
+	revokedStatus
+	failed
+)
+
+func (c *Checker) fail() (revoked, ok bool) {
+	if c.cfg.HardFail {
+		return true, false
+	}
+	return false, false
+}
+
 // revCheck should check the certificate for any revocations. It
 // returns a pair of booleans: the first indicates whether the certificate
 // is revoked, the second indicates whether the revocations were
@@ -64,111 +292,154 @@ func ldapURL(url string) bool {
 //  true, false:  failure to check revocation status causes
 //                  verification to fail
 func revCheck(cert *x509.Certificate) (revoked, ok bool) {
-	for _, url := range cert.CRLDistributionPoints {
-		if ldapURL(url) {
-			log.Infof("skipping LDAP CRL: %s", url)
-			continue
-		}
+	return defaultChecker().revCheck(cert, nil, nil)
+}
 
-		if revoked, ok := certIsRevokedCRL(cert, url); !ok {
-			log.Warning("error checking revocation via CRL")
-			if HardFail {
-				return true, false
-			}
-			return false, false
-		} else if revoked {
-			log.Info("certificate is revoked via CRL")
-			return true, true
-		}
+func (c *Checker) revCheck(cert, issuer *x509.Certificate, staple []byte) (revoked, ok bool) {
+	getIssuer := c.issuerOf(cert, issuer)
+	type method struct {
+		name  string
+		check func() status
+	}
+	methods := []method{
+		{"CRL", func() status { return c.checkCRLs(cert, getIssuer) }},
+		{"OCSP", func() status { return c.checkOCSP(cert, getIssuer, staple) }},
+	}
+	if c.cfg.Order == OCSPFirst {
+		methods[0], methods[1] = methods[1], methods[0]
 	}
 
-	if revoked, ok := certIsRevokedOCSP(cert, HardFail); !ok {
-		log.Warning("error checking revocation via OCSP")
-		if HardFail {
-			return true, false
-		}
-		return false, false
-	} else if revoked {
-		log.Info("certificate is revoked via OCSP")
+	first := methods[0].check()
+	switch first {
+	case revokedStatus:
+		log.Infof("certificate is revoked via %s", methods[0].name)
 		return true, true
+	case failed:
+		log.Warningf("error checking revocation via %s", methods[0].name)
+		if c.cfg.Order == CRLFirst {
+			return c.fail()
+		}
+	case good:
+		if c.cfg.Order == OCSPFirst {
+			return false, true
+		}
 	}
 
+	switch methods[1].check() {
+	case revokedStatus:
+		log.Infof("certificate is revoked via %s", methods[1].name)
+		return true, true
+	case failed:
+		log.Warningf("error checking revocation via %s", methods[1].name)
+		return c.fail()
+	case unchecked:
+		if first == failed {
+			return c.fail()
+		}
+	}
 	return false, true
 }
 
+// issuerOf returns a function returning the issuer of cert: issuer, if
+// it is not nil, or else the certificate fetched from the AIA URLs of
+// cert, at most once.
+func (c *Checker) issuerOf(cert, issuer *x509.Certificate) func() *x509.Certificate {
+	var once sync.Once
+	return func() *x509.Certificate {
+		once.Do(func() {
+			if issuer == nil {
+				issuer = c.getIssuer(cert)
+			}
+		})
+		return issuer
+	}
+}
+
 // fetchCRL fetches and parses a CRL.
 func fetchCRL(url string) (*pkix.CertificateList, error) {
-	resp, err := http.Get(url)
+	return defaultChecker().fetchCRL(url)
+}
+
+func (c *Checker) fetchCRL(url string) (*pkix.CertificateList, error) {
+	resp, err := c.client().Get(url)
 	if err != nil {
 		return nil, err
-	} else if resp.StatusCode >= 300 {
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
 		return nil, errors.New("failed to retrieve CRL")
 	}
 
-	body, err := crlRead(resp.Body)
+	body, err := c.crlRead(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	resp.Body.Close()
 
 	return x509.ParseCRL(body)
 }
 
 func getIssuer(cert *x509.Certificate) *x509.Certificate {
-	var issuer *x509.Certificate
-	var err error
+	return defaultChecker().getIssuer(cert)
+}
+
+func (c *Checker) getIssuer(cert *x509.Certificate) *x509.Certificate {
 	for _, issuingCert := range cert.IssuingCertificateURL {
-		issuer, err = fetchRemote(issuingCert)
+		issuer, err := c.fetchIssuer(issuingCert)
 		if err != nil {
 			continue
 		}
-		break
+		return issuer
 	}
+	return nil
+}
 
-	return issuer
+// checkCRLs checks cert against each of its CRLs.
+func (c *Checker) checkCRLs(cert *x509.Certificate, issuer func() *x509.Certificate) status {
+	st := unchecked
+	for _, url := range cert.CRLDistributionPoints {
+		if ldapURL(url) {
+			log.Infof("skipping LDAP CRL: %s", url)
+			continue
+		}
 
+		revoked, ok := c.certIsRevokedCRL(cert, url, issuer)
+		if !ok {
+			return failed
+		} else if revoked {
+			return revokedStatus
+		}
+		st = good
+	}
+	return st
 }
 
 // check a cert against a specific CRL. Returns the same bool pair
 // as revCheck.
 func certIsRevokedCRL(cert *x509.Certificate, url string) (revoked, ok bool) {
-	crl, ok := CRLSet[url]
-	if ok && crl == nil {
-		ok = false
-		crlLock.Lock()
-		delete(CRLSet, url)
-		crlLock.Unlock()
-	}
-
-	var shouldFetchCRL = true
-	if ok {
-		if !crl.HasExpired(time.Now()) {
-			shouldFetchCRL = false
-		}
-	}
-
-	issuer := getIssuer(cert)
+	c := defaultChecker()
+	return c.certIsRevokedCRL(cert, url, c.issuerOf(cert, nil))
+}
 
-	if shouldFetchCRL {
+func (c *Checker) certIsRevokedCRL(cert *x509.Certificate, url string, issuer func() *x509.Certificate) (revoked, ok bool) {
+	crl, ok := c.crls.get(url, c.now())
+	if !ok {
 		var err error
-		crl, err = fetchCRL(url)
+		crl, err = c.fetchCRL(url)
 		if err != nil {
 			log.Warningf("failed to fetch CRL: %v", err)
 			return false, false
 		}
 
 		// check CRL signature
-		if issuer != nil {
-			err = issuer.CheckCRLSignature(crl)
+		if iss := issuer(); iss != nil {
+			err = iss.CheckCRLSignature(crl)
 			if err != nil {
 				log.Warningf("failed to verify CRL: %v", err)
 				return false, false
 			}
 		}
 
-		crlLock.Lock()
-		CRLSet[url] = crl
-		crlLock.Unlock()
+		c.crls.put(url, crl)
 	}
 
 	for _, revoked := range crl.TBSCertList.RevokedCertificates {
@@ -184,110 +455,144 @@ func certIsRevokedCRL(cert *x509.Certificate, url string) (revoked, ok bool) {
 // VerifyCertificate ensures that the certificate passed in hasn't
 // expired and checks the CRL for the server.
 func VerifyCertificate(cert *x509.Certificate) (revoked, ok bool) {
-	if !time.Now().Before(cert.NotAfter) {
+	return defaultChecker().Check(cert)
+}
+
+// Check ensures that the certificate passed in hasn't expired and
+// checks its revocation status. It returns the same bool pair as
+// VerifyCertificate.
+func (c *Checker) Check(cert *x509.Certificate) (revoked, ok bool) {
+	return c.CheckStapled(cert, nil, nil)
+}
+
+// CheckStapled is Check for a certificate presented with an OCSP
+// response, as stapled to a TLS handshake. A valid staple stands in
+// for asking the OCSP responder. The issuer verifies the staple; if
+// it is nil, it is fetched from the certificate's AIA URLs.
+func (c *Checker) CheckStapled(cert, issuer *x509.Certificate, staple []byte) (revoked, ok bool) {
+	now := c.now()
+	if !now.Before(cert.NotAfter) {
 		log.Infof("Certificate expired %s\n", cert.NotAfter)
 		return true, true
-	} else if !time.Now().After(cert.NotBefore) {
+	} else if !now.After(cert.NotBefore) {
 		log.Infof("Certificate isn't valid until %s\n", cert.NotBefore)
 		return true, true
 	}
 
-	return revCheck(cert)
+	return c.revCheck(cert, issuer, staple)
 }
 
+// fetchRemote fetches an issuer certificate through the intermediate
+// cache shared with the bundler.
 func fetchRemote(url string) (*x509.Certificate, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-
-	in, err := remoteRead(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	resp.Body.Close()
+	return aia.Default.FetchWith(url, remoteRead)
+}
 
-	p, _ := pem.Decode(in)
-	if p != nil {
-		return helpers.ParseCertificatePEM(in)
+func certIsRevokedOCSP(leaf *x509.Certificate, strict bool) (revoked, ok bool) {
+	c := defaultChecker()
+	c.cfg.HardFail = strict
+	switch c.checkOCSP(leaf, c.issuerOf(leaf, nil), nil) {
+	case revokedStatus:
+		return true, true
+	case failed:
+		return false, false
+	default:
+		return false, true
 	}
-
-	return x509.ParseCertificate(in)
 }
 
-var ocspOpts = ocsp.RequestOptions{
-	Hash: crypto.SHA1,
+func ocspStatus(resp *ocsp.Response) status {
+	if resp.Status != ocsp.Good {
+		// The certificate was revoked.
+		return revokedStatus
+	}
+	return good
 }
 
-func certIsRevokedOCSP(leaf *x509.Certificate, strict bool) (revoked, ok bool) {
-	var err error
+// checkOCSP checks leaf with the staple, a cached response, or else
+// its OCSP responders.
+func (c *Checker) checkOCSP(leaf *x509.Certificate, getIssuer func() *x509.Certificate, staple []byte) status {
+	now := c.now()
+	if len(staple) > 0 {
+		if issuer := getIssuer(); issuer != nil {
+			resp, err := ocsp.ParseResponseForCert(staple, leaf, issuer)
+			if err == nil && now.Before(resp.NextUpdate) {
+				log.Debug("using stapled OCSP response")
+				c.responses.put(leaf, issuer, resp)
+				return ocspStatus(resp)
+			}
+			log.Warningf("ignoring invalid or stale stapled OCSP response: %v", err)
+		}
+	}
 
 	ocspURLs := leaf.OCSPServer
 	if len(ocspURLs) == 0 {
 		// OCSP not enabled for this certificate.
-		return false, true
+		return unchecked
 	}
 
-	issuer := getIssuer(leaf)
-
+	issuer := getIssuer()
 	if issuer == nil {
-		return false, false
+		return failed
 	}
 
-	ocspRequest, err := ocsp.CreateRequest(leaf, issuer, &ocspOpts)
+	if resp, ok := c.responses.get(leaf, issuer, now); ok {
+		log.Debug("using cached OCSP response")
+		return ocspStatus(resp)
+	}
+
+	hash := c.cfg.OCSPHash
+	if hash == 0 {
+		hash = crypto.SHA1
+	}
+	ocspRequest, err := ocsp.CreateRequest(leaf, issuer, &ocsp.RequestOptions{Hash: hash})
 	if err != nil {
-		return
+		return failed
 	}
 
 	for _, server := range ocspURLs {
-		resp, err := sendOCSPRequest(server, ocspRequest, leaf, issuer)
+		resp, err := c.sendOCSPRequest(server, ocspRequest, leaf, issuer)
 		if err != nil {
-			if strict {
-				return
+			if c.cfg.HardFail {
+				return failed
 			}
 			continue
 		}
 
 		// There wasn't an error fetching the OCSP status.
-		ok = true
-
-		if resp.Status != ocsp.Good {
-			// The certificate was revoked.
-			revoked = true
-		}
-
-		return
+		c.responses.put(leaf, issuer, resp)
+		return ocspStatus(resp)
 	}
-	return
+	return failed
 }
 
 // sendOCSPRequest attempts to request an OCSP response from the
 // server. The error only indicates a failure to *fetch* the
 // certificate, and *does not* mean the certificate is valid.
-func sendOCSPRequest(server string, req []byte, leaf, issuer *x509.Certificate) (*ocsp.Response, error) {
+func (c *Checker) sendOCSPRequest(server string, req []byte, leaf, issuer *x509.Certificate) (*ocsp.Response, error) {
 	var resp *http.Response
 	var err error
 	if len(req) > 256 {
 		buf := bytes.NewBuffer(req)
-		resp, err = http.Post(server, "application/ocsp-request", buf)
+		resp, err = c.client().Post(server, "application/ocsp-request", buf)
 	} else {
 		reqURL := server + "/" + base64.StdEncoding.EncodeToString(req)
-		resp, err = http.Get(reqURL)
+		resp, err = c.client().Get(reqURL)
 	}
 
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.New("failed to retrieve OSCP")
 	}
 
-	body, err := ocspRead(resp.Body)
+	body, err := c.ocspRead(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	resp.Body.Close()
 
 	switch {
 	case bytes.Equal(body, ocsp.UnauthorizedErrorResponse):
@@ -314,7 +619,9 @@ func SetCRLFetcher(fn func(io.Reader) ([]byte, error)) {
 
 var remoteRead = ioutil.ReadAll
 
-// SetRemoteFetcher sets the function to use to read from the http response body
+// SetRemoteFetcher sets the function to use to read from the http
+// response body when fetching issuers. It applies only to the issuers
+// fetched here, not to the other users of the intermediate cache.
 func SetRemoteFetcher(fn func(io.Reader) ([]byte, error)) {
 	remoteRead = fn
 }
@@ -7,7 +7,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/cloudflare/cfssl/aia"
 	"github.com/cloudflare/cfssl/bundler"
+	"github.com/cloudflare/cfssl/starttls"
 )
 
 // Broad contains scanners for large swaths of TLS hosts on the internet.
@@ -37,13 +39,17 @@ var (
 )
 
 // intermediateCAScan scans for new intermediate CAs not in the trust store.
+// The bundler caches the intermediates it fetches through AIA URLs in
+// aia.Default, shared by every scan, so that a CA server is asked for
+// each intermediate once rather than once per host.
 func intermediateCAScan(addr, hostname string) (grade Grade, output Output, err error) {
-	cidr, port, _ := net.SplitHostPort(addr)
+	proto, hostport := splitAddr(addr)
+	cidr, port, _ := net.SplitHostPort(hostport)
 	_, ipnet, err := net.ParseCIDR(cidr)
 	if err != nil {
 		return Skipped, nil, nil
 	}
-	b, err := bundler.NewBundler(caBundleFile, intBundleFile)
+	b, err := bundler.NewBundler(caBundleFile, intBundleFile, bundler.WithIntermediateCache(aia.Default))
 	if err != nil {
 		return
 	}
@@ -61,7 +67,7 @@ func intermediateCAScan(addr, hostname string) (grade Grade, output Output, err
 	for i := 0; i < numWorkers; i++ {
 		go func() {
 			for addr := range addrs {
-				conn, err := tls.DialWithDialer(dialer, Network, addr, config)
+				conn, err := dialTLS(dialer, addr, config)
 				if err != nil {
 					continue
 				}
@@ -74,7 +80,7 @@ func intermediateCAScan(addr, hostname string) (grade Grade, output Output, err
 		}()
 	}
 	for ip := ipnet.IP.To16(); ipnet.Contains(ip); incrementBytes(ip) {
-		addrs <- net.JoinHostPort(ip.String(), port)
+		addrs <- starttls.Join(proto, net.JoinHostPort(ip.String(), port))
 	}
 	close(addrs)
 	wg.Wait()
@@ -129,7 +129,8 @@ func onCloudFlareScan(addr, hostname string) (grade Grade, output Output, err er
 
 // tcpDialScan tests that the host can be connected to through TCP.
 func tcpDialScan(addr, hostname string) (grade Grade, output Output, err error) {
-	conn, err := Dialer.Dial(Network, addr)
+	_, hostport := splitAddr(addr)
+	conn, err := Dialer.Dial(Network, hostport)
 	if err != nil {
 		return
 	}
@@ -144,13 +145,13 @@ func tlsDialScan(addr, hostname string) (grade Grade, output Output, err error)
 	var conn *tls.Conn
 	config := defaultTLSConfig(hostname)
 
-	if conn, err = tls.DialWithDialer(Dialer, Network, addr, config); err != nil {
+	if conn, err = dialTLS(Dialer, addr, config); err != nil {
 		return
 	}
 	conn.Close()
 
 	config.InsecureSkipVerify = false
-	if conn, err = tls.DialWithDialer(Dialer, Network, addr, config); err != nil {
+	if conn, err = dialTLS(Dialer, addr, config); err != nil {
 		grade = Warning
 		return
 	}
@@ -0,0 +1,247 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/certdb"
+)
+
+// A Run is one recorded scan of a host.
+type Run struct {
+	Host      string                  `json:"host"`
+	ScannedAt time.Time               `json:"scanned_at"`
+	Results   map[string]FamilyResult `json:"results"`
+}
+
+// errNoScanResults is returned for a database that doesn't record
+// scans.
+var errNoScanResults = errors.New("the certificate database does not record scan results")
+
+// SaveRun records the results of scanning host at scannedAt in db,
+// which must also be a certdb.ScanAccessor.
+func SaveRun(db certdb.Accessor, host string, scannedAt time.Time, results map[string]FamilyResult) error {
+	sa, ok := db.(certdb.ScanAccessor)
+	if !ok {
+		return errNoScanResults
+	}
+	body, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	return sa.InsertScanResult(certdb.ScanRecord{
+		Host:      host,
+		ScannedAt: scannedAt,
+		Results:   string(body),
+	})
+}
+
+// History returns every recorded scan of host, oldest first.
+func History(db certdb.Accessor, host string) ([]Run, error) {
+	sa, ok := db.(certdb.ScanAccessor)
+	if !ok {
+		return nil, errNoScanResults
+	}
+	records, err := sa.GetScanResults(host)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]Run, 0, len(records))
+	for _, sr := range records {
+		run := Run{Host: sr.Host, ScannedAt: sr.ScannedAt}
+		if err = json.Unmarshal([]byte(sr.Results), &run.Results); err != nil {
+			return nil, fmt.Errorf("failed to parse scan of %s at %v: %v", host, sr.ScannedAt, err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// A GradeChange is a scanner whose grade differs between two runs. A
+// grade is empty if the scanner did not run.
+type GradeChange struct {
+	Family  string `json:"family"`
+	Scanner string `json:"scanner"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// A CertificateChange is a field of the host's leaf certificate that
+// differs between two runs.
+type CertificateChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// A Diff describes what changed between two scans of a host.
+type Diff struct {
+	Host               string              `json:"host"`
+	From               time.Time           `json:"from"`
+	To                 time.Time           `json:"to"`
+	GradeChanges       []GradeChange       `json:"grade_changes"`
+	NewWeakCiphers     []string            `json:"new_weak_ciphers"`
+	CertificateChanges []CertificateChange `json:"certificate_changes"`
+}
+
+// Changed reports whether anything differs between the two runs.
+func (d *Diff) Changed() bool {
+	return len(d.GradeChanges) > 0 || len(d.NewWeakCiphers) > 0 || len(d.CertificateChanges) > 0
+}
+
+// weakCipherMarkers appear in the names of cipher suites that should
+// no longer be accepted.
+var weakCipherMarkers = []string{"NULL", "EXP", "anon", "ADH", "AECDH", "RC4", "DES", "MD5", "CCM_8"}
+
+func weakCipher(name string) bool {
+	for _, marker := range weakCipherMarkers {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize gives results the shape they have after being stored, so
+// that fresh results can be compared with recorded ones.
+func normalize(results map[string]FamilyResult) (map[string]FamilyResult, error) {
+	body, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	var normalized map[string]FamilyResult
+	err = json.Unmarshal(body, &normalized)
+	return normalized, err
+}
+
+// acceptedCiphers returns the names of the cipher suites found by the
+// TLSHandshake and TLS13 cipher suite scanners.
+func acceptedCiphers(results map[string]FamilyResult) map[string]bool {
+	ciphers := make(map[string]bool)
+
+	// TLSHandshake/CipherSuite is a list of {suite: [versions]}.
+	if list, ok := results["TLSHandshake"]["CipherSuite"].Output.([]interface{}); ok {
+		for _, entry := range list {
+			if m, ok := entry.(map[string]interface{}); ok {
+				for name := range m {
+					ciphers[name] = true
+				}
+			}
+		}
+	}
+
+	// TLS13/CipherSuites is a list of suite names.
+	if list, ok := results["TLS13"]["CipherSuites"].Output.([]interface{}); ok {
+		for _, entry := range list {
+			if name, ok := entry.(string); ok {
+				ciphers[name] = true
+			}
+		}
+	}
+	return ciphers
+}
+
+func leafInfo(results map[string]FamilyResult) map[string]interface{} {
+	info, _ := results["PKI"]["Certificate"].Output.(map[string]interface{})
+	return info
+}
+
+// DiffRuns returns the changes from one run to a later one.
+func DiffRuns(from, to Run) (*Diff, error) {
+	before, err := normalize(from.Results)
+	if err != nil {
+		return nil, err
+	}
+	after, err := normalize(to.Results)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Diff{
+		Host:               to.Host,
+		From:               from.ScannedAt,
+		To:                 to.ScannedAt,
+		GradeChanges:       []GradeChange{},
+		NewWeakCiphers:     []string{},
+		CertificateChanges: []CertificateChange{},
+	}
+
+	families := make(map[string]bool)
+	for family := range before {
+		families[family] = true
+	}
+	for family := range after {
+		families[family] = true
+	}
+	for family := range families {
+		scanners := make(map[string]bool)
+		for scanner := range before[family] {
+			scanners[scanner] = true
+		}
+		for scanner := range after[family] {
+			scanners[scanner] = true
+		}
+		for scanner := range scanners {
+			was, now := before[family][scanner].Grade, after[family][scanner].Grade
+			if was != now {
+				d.GradeChanges = append(d.GradeChanges, GradeChange{family, scanner, was, now})
+			}
+		}
+	}
+	sort.Slice(d.GradeChanges, func(i, j int) bool {
+		a, b := d.GradeChanges[i], d.GradeChanges[j]
+		if a.Family != b.Family {
+			return a.Family < b.Family
+		}
+		return a.Scanner < b.Scanner
+	})
+
+	old := acceptedCiphers(before)
+	for name := range acceptedCiphers(after) {
+		if !old[name] && weakCipher(name) {
+			d.NewWeakCiphers = append(d.NewWeakCiphers, name)
+		}
+	}
+	sort.Strings(d.NewWeakCiphers)
+
+	// Only compare certificates if both runs saw one.
+	oldLeaf, newLeaf := leafInfo(before), leafInfo(after)
+	if oldLeaf != nil && newLeaf != nil {
+		var fields []string
+		for field := range newLeaf {
+			fields = append(fields, field)
+		}
+		for field := range oldLeaf {
+			if _, ok := newLeaf[field]; !ok {
+				fields = append(fields, field)
+			}
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			if !reflect.DeepEqual(oldLeaf[field], newLeaf[field]) {
+				d.CertificateChanges = append(d.CertificateChanges, CertificateChange{field, oldLeaf[field], newLeaf[field]})
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// LatestDiff returns the changes between the two most recent scans of
+// host recorded in db.
+func LatestDiff(db certdb.Accessor, host string) (*Diff, error) {
+	runs, err := History(db, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) < 2 {
+		return nil, fmt.Errorf("%s has been scanned %d times; two scans are needed for a diff", host, len(runs))
+	}
+	return DiffRuns(runs[len(runs)-2], runs[len(runs)-1])
+}
@@ -0,0 +1,147 @@
+package scan
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/certdb/sql"
+	"github.com/cloudflare/cfssl/certdb/testdb"
+)
+
+const (
+	sqliteDBFile     = "../certdb/testdb/certstore_development.db"
+	sqliteMigrations = "../certdb/sqlite/migrations"
+)
+
+func firstRun() map[string]FamilyResult {
+	return map[string]FamilyResult{
+		"TLSHandshake": {
+			"CipherSuite": {Grade: "Good", Output: []interface{}{
+				map[string]interface{}{"ECDHE-RSA-AES128-GCM-SHA256": []interface{}{"TLS 1.2"}},
+			}},
+		},
+		"PKI": {
+			"Certificate": {Grade: "Good", Output: certificateInfo{
+				Subject:     "example.com",
+				Serial:      "1",
+				NotAfter:    time.Date(2026, time.December, 1, 0, 0, 0, 0, time.UTC),
+				Fingerprint: "aa",
+			}},
+		},
+	}
+}
+
+func secondRun() map[string]FamilyResult {
+	return map[string]FamilyResult{
+		"TLSHandshake": {
+			"CipherSuite": {Grade: "Warning", Output: []interface{}{
+				map[string]interface{}{"ECDHE-RSA-AES128-GCM-SHA256": []interface{}{"TLS 1.2"}},
+				map[string]interface{}{"DES-CBC3-SHA": []interface{}{"TLS 1.2"}},
+				map[string]interface{}{"RC4-SHA": []interface{}{"SSL 3.0"}},
+			}},
+		},
+		"TLS13": {
+			"CipherSuites": {Grade: "Warning", Output: []string{"TLS_AES_128_GCM_SHA256", "TLS_AES_128_CCM_8_SHA256"}},
+		},
+		"PKI": {
+			"Certificate": {Grade: "Good", Output: certificateInfo{
+				Subject:     "example.com",
+				Serial:      "2",
+				NotAfter:    time.Date(2027, time.March, 1, 0, 0, 0, 0, time.UTC),
+				Fingerprint: "bb",
+			}},
+		},
+	}
+}
+
+func TestDiffRuns(t *testing.T) {
+	d, err := DiffRuns(Run{Host: "example.com", Results: firstRun()}, Run{Host: "example.com", Results: secondRun()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.GradeChanges) != 2 ||
+		d.GradeChanges[0] != (GradeChange{"TLS13", "CipherSuites", "", "Warning"}) ||
+		d.GradeChanges[1] != (GradeChange{"TLSHandshake", "CipherSuite", "Good", "Warning"}) {
+		t.Fatalf("unexpected grade changes %+v", d.GradeChanges)
+	}
+
+	if len(d.NewWeakCiphers) != 3 || d.NewWeakCiphers[0] != "DES-CBC3-SHA" ||
+		d.NewWeakCiphers[1] != "RC4-SHA" || d.NewWeakCiphers[2] != "TLS_AES_128_CCM_8_SHA256" {
+		t.Fatalf("unexpected weak ciphers %v", d.NewWeakCiphers)
+	}
+
+	var fields []string
+	for _, c := range d.CertificateChanges {
+		fields = append(fields, c.Field)
+	}
+	if len(fields) != 3 || fields[0] != "not_after" || fields[1] != "serial_number" || fields[2] != "sha256_fingerprint" {
+		t.Fatalf("unexpected certificate changes %+v", d.CertificateChanges)
+	}
+	if !d.Changed() {
+		t.Fatal("expected the diff to report changes")
+	}
+
+	d, err = DiffRuns(Run{Results: secondRun()}, Run{Results: secondRun()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Changed() {
+		t.Fatalf("expected no changes, got %+v", d)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfssl_scan_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sqlDB := testdb.MigratedSQLiteDB(filepath.Join(dir, "certdb.db"), sqliteMigrations)
+	defer sqlDB.Close()
+	db := sql.NewAccessor(sqlDB)
+
+	if _, err := LatestDiff(db, "example.com"); err == nil {
+		t.Fatal("expected a diff without recorded scans to fail")
+	}
+
+	now := time.Now()
+	if err = SaveRun(db, "example.com", now.Add(-time.Hour), firstRun()); err != nil {
+		t.Fatal(err)
+	}
+	if err = SaveRun(db, "example.com", now, secondRun()); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := History(db, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Results["TLSHandshake"]["CipherSuite"].Grade != "Good" {
+		t.Fatalf("unexpected history %+v", runs)
+	}
+
+	d, err := LatestDiff(db, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.GradeChanges) != 2 || len(d.NewWeakCiphers) != 3 || len(d.CertificateChanges) != 3 {
+		t.Fatalf("unexpected diff %+v", d)
+	}
+}
+
+func TestHistoryUnsupported(t *testing.T) {
+	// An Accessor that doesn't record scans can't be used.
+	db := struct{ certdb.Accessor }{sql.NewAccessor(testdb.SQLiteDB(sqliteDBFile))}
+	if err := SaveRun(db, "example.com", time.Now(), firstRun()); err != errNoScanResults {
+		t.Fatalf("expected errNoScanResults, got %v", err)
+	}
+	if _, err := History(db, "example.com"); err != errNoScanResults {
+		t.Fatalf("expected errNoScanResults, got %v", err)
+	}
+}
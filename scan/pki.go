@@ -2,6 +2,7 @@ package scan
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -27,13 +28,17 @@ var PKI = &Family{
 			"Host serves same certificate chain across all IPs",
 			multipleCerts,
 		},
+		"Certificate": {
+			"Describes host's leaf certificate",
+			leafCertificate,
+		},
 	},
 }
 
 // getChain is a helper function that retreives the host's certificate chain.
 func getChain(addr string, config *tls.Config) (chain []*x509.Certificate, err error) {
 	var conn *tls.Conn
-	conn, err = tls.DialWithDialer(Dialer, Network, addr, config)
+	conn, err = dialTLS(Dialer, addr, config)
 	if err != nil {
 		return
 	}
@@ -159,3 +164,37 @@ func multipleCerts(addr, hostname string) (grade Grade, output Output, err error
 	})
 	return
 }
+
+// certificateInfo identifies a host's leaf certificate, so that
+// changes to it show up between scans.
+type certificateInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial_number"`
+	SANs        []string  `json:"sans,omitempty"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"sha256_fingerprint"`
+}
+
+func leafCertificate(addr, hostname string) (grade Grade, output Output, err error) {
+	chain, err := getChain(addr, defaultTLSConfig(hostname))
+	if err != nil {
+		return
+	}
+
+	leaf := chain[0]
+	sans := append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	output = certificateInfo{
+		Subject:     leaf.Subject.CommonName,
+		Issuer:      leaf.Issuer.CommonName,
+		Serial:      leaf.SerialNumber.String(),
+		SANs:        sans,
+		NotAfter:    leaf.NotAfter,
+		Fingerprint: fmt.Sprintf("%x", sha256.Sum256(leaf.Raw)),
+	}
+	grade = Good
+	return
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/starttls"
 )
 
 var (
@@ -60,7 +61,8 @@ type Output interface{}
 // multiscan scans all DNS addresses returned for the host, returning the lowest grade
 // and the concatenation of all the output.
 func multiscan(host string, scan func(string) (Grade, Output, error)) (grade Grade, output Output, err error) {
-	domain, port, _ := net.SplitHostPort(host)
+	proto, hostport := splitAddr(host)
+	domain, port, _ := net.SplitHostPort(hostport)
 	var addrs []string
 	addrs, err = net.LookupHost(domain)
 	if err != nil {
@@ -74,7 +76,7 @@ func multiscan(host string, scan func(string) (Grade, Output, error)) (grade Gra
 		var g Grade
 		var o Output
 
-		g, o, err = scan(net.JoinHostPort(addr, port))
+		g, o, err = scan(starttls.Join(proto, net.JoinHostPort(addr, port)))
 		if err != nil {
 			grade = Bad
 			return
@@ -126,6 +128,7 @@ var Default = FamilySet{
 	"TLSSession":   TLSSession,
 	"PKI":          PKI,
 	"Broad":        Broad,
+	"TLS13":        TLS13,
 }
 
 // ScannerResult contains the result for a single scan.
@@ -229,6 +232,11 @@ func (familyCtx *familyContext) runScanner(familyName, scannerName string, scann
 // RunScans iterates over AllScans, running each scan that matches the family
 // and scanner regular expressions concurrently.
 func (fs FamilySet) RunScans(host, ip, family, scanner string, timeout time.Duration) (map[string]FamilyResult, error) {
+	proto, host, err := starttls.Split(host)
+	if err != nil {
+		return nil, err
+	}
+
 	hostname, port, err := net.SplitHostPort(host)
 	if err != nil {
 		hostname = host
@@ -241,6 +249,7 @@ func (fs FamilySet) RunScans(host, ip, family, scanner string, timeout time.Dura
 	} else {
 		addr = net.JoinHostPort(hostname, port)
 	}
+	addr = starttls.Join(proto, addr)
 
 	familyRegexp, err := regexp.Compile(family)
 	if err != nil {
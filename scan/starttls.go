@@ -0,0 +1,50 @@
+package scan
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/starttls"
+)
+
+// Scanners are given addresses of the form host:port or, for hosts
+// that negotiate TLS with STARTTLS, protocol://host:port. Connections
+// are made with dial and dialTLS, which negotiate the protocol.
+
+// splitAddr separates the STARTTLS protocol, if any, from addr.
+func splitAddr(addr string) (proto, hostport string) {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		return addr[:i], addr[i+3:]
+	}
+	return "", addr
+}
+
+// dial connects to addr, negotiating its STARTTLS protocol, and
+// returns a connection that is ready for a TLS handshake.
+func dial(addr, hostname string) (net.Conn, error) {
+	proto, hostport := splitAddr(addr)
+	return starttls.Dial(Dialer, Network, hostport, proto, hostname)
+}
+
+// dialTLS connects to addr and completes a TLS handshake.
+func dialTLS(dialer *net.Dialer, addr string, config *tls.Config) (*tls.Conn, error) {
+	proto, hostport := splitAddr(addr)
+	if proto == "" {
+		return tls.DialWithDialer(dialer, Network, hostport, config)
+	}
+
+	conn, err := starttls.Dial(dialer, Network, hostport, proto, config.ServerName)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(starttls.Timeout))
+	tlsConn := tls.Client(conn, config)
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
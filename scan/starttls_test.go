@@ -0,0 +1,77 @@
+package scan
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/starttls/starttlstest"
+)
+
+func newSTARTTLSServer(t *testing.T, protocol string) *starttlstest.Server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mail.example.com"},
+		DNSNames:     []string{"mail.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := starttlstest.NewServer(protocol,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestSTARTTLSScans(t *testing.T) {
+	for _, protocol := range []string{"smtp", "imap", "ldap"} {
+		server := newSTARTTLSServer(t, protocol)
+
+		results, err := Default.RunScans(server.URL(), "", "^(Connectivity|PKI|TLS13)$", "^(TCPDial|TLSDial|Certificate|Versions)$", 10*time.Second)
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", protocol, err)
+		}
+
+		for family, scanner := range map[string]string{"Connectivity": "TCPDial", "PKI": "Certificate", "TLS13": "Versions"} {
+			if result := results[family][scanner]; result.Grade != Good.String() || result.Error != "" {
+				t.Fatalf("%s: unexpected %s %s result %+v", protocol, family, scanner, result)
+			}
+		}
+		// The certificate is self-signed, so it can be fetched but
+		// not verified.
+		if result := results["Connectivity"]["TLSDial"]; result.Grade != Warning.String() {
+			t.Fatalf("%s: unexpected TLSDial result %+v", protocol, result)
+		}
+		if info := results["PKI"]["Certificate"].Output.(certificateInfo); info.Subject != "mail.example.com" {
+			t.Fatalf("%s: unexpected certificate %+v", protocol, info)
+		}
+	}
+}
+
+func TestSTARTTLSScanUnknownProtocol(t *testing.T) {
+	if _, err := Default.RunScans("gopher://example.com", "", "", "", time.Second); err == nil {
+		t.Fatal("expected an unknown STARTTLS protocol to be rejected")
+	}
+}
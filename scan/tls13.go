@@ -0,0 +1,318 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TLS13 contains scanners testing a host's TLS 1.3 support
+var TLS13 = &Family{
+	Description: "Scans for host's TLS 1.3 support and negotiation",
+	Scanners: map[string]*Scanner{
+		"Versions": {
+			"Determines the SSL/TLS protocol versions the host accepts",
+			versionsScan,
+		},
+		"CipherSuites": {
+			"Determines host's TLS 1.3 cipher suites and preferred order",
+			tls13CipherSuiteScan,
+		},
+		"Groups": {
+			"Determines host's supported key exchange groups for TLS 1.3",
+			groupsScan,
+		},
+		"HelloRetryRequest": {
+			"Checks that host asks for a key share it supports with a HelloRetryRequest",
+			helloRetryScan,
+		},
+		"ALPN": {
+			"Determines the application protocols host negotiates with TLS 1.3",
+			alpnScan,
+		},
+	},
+}
+
+// legacyGroups are offered in hellos for versions before TLS 1.3.
+var legacyGroups = []uint16{groupX25519, groupP256, groupP384, groupP521}
+
+// alpnProtocols are the application protocols probed for.
+var alpnProtocols = []string{"h2", "http/1.1"}
+
+// legacyVersionSupported reports whether the host negotiates vers
+// when offered it without the supported_versions extension.
+func legacyVersionSupported(addr, hostname string, vers uint16) (bool, error) {
+	conn, err := dialHello(addr, hostname)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	_, sh, err := conn.sayHello(&clientHello{
+		legacyVersion: vers,
+		serverName:    hostname,
+		suites:        allCiphersIDs(),
+		groups:        legacyGroups,
+	})
+	if err == errHelloFailed {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return sh.version == vers, nil
+}
+
+// tls13Supported reports whether the host negotiates TLS 1.3.
+func tls13Supported(addr, hostname string) (bool, error) {
+	_, err := probeTLS13(addr, hostname, tls13CipherSuiteIDs, groupIDs, []uint16{groupX25519})
+	if err == errHelloFailed {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// versionsScan returns the protocol versions accepted by the host
+func versionsScan(addr, hostname string) (grade Grade, output Output, err error) {
+	supported := make(map[uint16]bool)
+	var versions []string
+
+	ok, err := tls13Supported(addr, hostname)
+	if err != nil {
+		return Bad, nil, err
+	}
+	if ok {
+		supported[versionTLS13] = true
+		versions = append(versions, tls13Versions[versionTLS13])
+	}
+
+	for vers := versionTLS12; vers >= 0x0300; vers-- {
+		ok, err = legacyVersionSupported(addr, hostname, vers)
+		if err != nil {
+			return Bad, nil, err
+		}
+		if ok {
+			supported[vers] = true
+			versions = append(versions, tls13Versions[vers])
+		}
+	}
+
+	output = versions
+	switch {
+	case supported[0x0300]:
+		grade = Bad
+	case !supported[versionTLS12] && !supported[versionTLS13]:
+		grade = Bad
+	case !supported[versionTLS13] || supported[versionTLS10] || supported[versionTLS11]:
+		grade = Warning
+	default:
+		grade = Good
+	}
+	if len(versions) == 0 {
+		err = errors.New("couldn't negotiate any protocol version")
+	}
+	return
+}
+
+// tls13CipherSuiteScan returns the TLS 1.3 cipher suites supported by
+// the host, in its order of preference
+func tls13CipherSuiteScan(addr, hostname string) (grade Grade, output Output, err error) {
+	ok, err := tls13Supported(addr, hostname)
+	if err != nil || !ok {
+		return Skipped, nil, err
+	}
+
+	grade = Good
+	var names []string
+	suites := make([]uint16, len(tls13CipherSuiteIDs))
+	copy(suites, tls13CipherSuiteIDs)
+	for len(suites) > 0 {
+		var sh *serverHello
+		sh, err = probeTLS13(addr, hostname, suites, groupIDs, []uint16{groupX25519})
+		if err != nil {
+			// This case is expected, because eventually we offer only suites the server doesn't support
+			if err == errHelloFailed {
+				err = nil
+				break
+			}
+			return Bad, nil, err
+		}
+
+		i := indexOf(suites, sh.suite)
+		if i < 0 {
+			return Bad, nil, fmt.Errorf("server negotiated cipher suite we didn't send: %#04x", sh.suite)
+		}
+		if sh.suite == 0x1305 {
+			// CCM_8 has a truncated authentication tag.
+			grade = Warning
+		}
+		names = append(names, TLS13CipherSuites[sh.suite])
+		suites = append(suites[:i], suites[i+1:]...)
+	}
+
+	if len(names) == 0 {
+		return Bad, nil, errors.New("couldn't negotiate any TLS 1.3 cipher suites")
+	}
+	output = names
+	return
+}
+
+// groupsScan returns the key exchange groups the host supports for
+// TLS 1.3. Each group is offered alone, with no key share, so that a
+// supporting server must ask for it with a HelloRetryRequest.
+func groupsScan(addr, hostname string) (grade Grade, output Output, err error) {
+	ok, err := tls13Supported(addr, hostname)
+	if err != nil || !ok {
+		return Skipped, nil, err
+	}
+
+	var names []string
+	var modern, nist bool
+	for _, group := range groupIDs {
+		sh, e := probeTLS13(addr, hostname, tls13CipherSuiteIDs, []uint16{group}, nil)
+		if e != nil {
+			if e == errHelloFailed {
+				continue
+			}
+			return Bad, nil, e
+		}
+		if !sh.retry || sh.group != group {
+			continue
+		}
+
+		names = append(names, Groups[group])
+		switch {
+		case group == groupX25519 || hybridGroups[group]:
+			modern = true
+		case group == groupP256 || group == groupP384 || group == groupP521:
+			nist = true
+		}
+	}
+
+	output = names
+	switch {
+	case modern:
+		grade = Good
+	case nist:
+		grade = Warning
+	default:
+		grade = Bad
+		err = errors.New("couldn't negotiate any groups")
+	}
+	return
+}
+
+// helloRetryResult describes a HelloRetryRequest exchange.
+type helloRetryResult struct {
+	Group  string `json:"group"`
+	Cookie bool   `json:"cookie"`
+}
+
+// helloRetryScan sends a ClientHello without key shares and checks
+// that the host asks for one with a HelloRetryRequest, then completes
+// the key exchange with the requested group
+func helloRetryScan(addr, hostname string) (grade Grade, output Output, err error) {
+	ok, err := tls13Supported(addr, hostname)
+	if err != nil || !ok {
+		return Skipped, nil, err
+	}
+
+	groups := []uint16{groupX25519, groupP256, groupP384, groupP521}
+	if mlkemSupported {
+		groups = append([]uint16{groupX25519MLKEM768}, groups...)
+	}
+	ch, err := newTLS13Hello(hostname, []uint16{0x1301, 0x1302}, groups, nil)
+	if err != nil {
+		return Bad, nil, err
+	}
+
+	hs, err := startTLS13(addr, ch)
+	if err == errHelloFailed {
+		return Bad, nil, errors.New("server aborted the handshake instead of sending a HelloRetryRequest")
+	}
+	if err != nil {
+		return Bad, nil, err
+	}
+	defer hs.conn.Close()
+
+	if !hs.retried {
+		return Bad, nil, errors.New("server did not send a HelloRetryRequest")
+	}
+	if _, err = hs.encryptedExtensions(); err != nil {
+		return Bad, nil, fmt.Errorf("handshake failed after HelloRetryRequest: %v", err)
+	}
+
+	return Good, helloRetryResult{Group: Groups[hs.retryGroup], Cookie: ch.cookie != nil}, nil
+}
+
+// alpnScan returns the application protocols the host negotiates
+// over TLS 1.3
+func alpnScan(addr, hostname string) (grade Grade, output Output, err error) {
+	ok, err := tls13Supported(addr, hostname)
+	if err != nil || !ok {
+		return Skipped, nil, err
+	}
+
+	var protocols []string
+	for _, proto := range alpnProtocols {
+		var negotiated string
+		negotiated, err = negotiateALPN(addr, hostname, proto)
+		if err == errHelloFailed {
+			// no_application_protocol
+			err = nil
+			continue
+		}
+		if err != nil {
+			return Bad, nil, err
+		}
+		if negotiated == proto {
+			protocols = append(protocols, proto)
+		}
+	}
+
+	output = protocols
+	grade = Warning
+	if indexOfString(protocols, "h2") >= 0 {
+		grade = Good
+	}
+	return
+}
+
+// negotiateALPN offers a single protocol and returns the one selected
+// in the server's EncryptedExtensions.
+func negotiateALPN(addr, hostname, proto string) (string, error) {
+	ch, err := newTLS13Hello(hostname, []uint16{0x1301, 0x1302}, []uint16{groupX25519, groupP256}, []uint16{groupX25519, groupP256})
+	if err != nil {
+		return "", err
+	}
+	ch.alpn = []string{proto}
+
+	hs, err := startTLS13(addr, ch)
+	if err != nil {
+		return "", err
+	}
+	defer hs.conn.Close()
+
+	ee, err := hs.encryptedExtensions()
+	if err != nil {
+		return "", err
+	}
+	return negotiatedALPN(ee)
+}
+
+func indexOf(list []uint16, v uint16) int {
+	for i, x := range list {
+		if x == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOfString(list []string, v string) int {
+	for i, x := range list {
+		if x == v {
+			return i
+		}
+	}
+	return -1
+}
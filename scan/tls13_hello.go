@@ -0,0 +1,831 @@
+package scan
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/curve25519"
+)
+
+// The vendored crypto/tls predates TLS 1.3, so TLS 1.3 probes build
+// their own ClientHello messages and parse the server's replies. This
+// also lets the probes send hellos a real client never would, such as
+// one with no key shares at all.
+
+const (
+	versionTLS10 uint16 = 0x0301
+	versionTLS11 uint16 = 0x0302
+	versionTLS12 uint16 = 0x0303
+	versionTLS13 uint16 = 0x0304
+
+	recordTypeChangeCipherSpec = 20
+	recordTypeAlert            = 21
+	recordTypeHandshake        = 22
+	recordTypeApplicationData  = 23
+
+	typeClientHello         = 1
+	typeServerHello         = 2
+	typeEncryptedExtensions = 8
+	typeMessageHash         = 254
+
+	extensionServerName          = 0
+	extensionSupportedGroups     = 10
+	extensionSupportedPoints     = 11
+	extensionSignatureAlgorithms = 13
+	extensionALPN                = 16
+	extensionSupportedVersions   = 43
+	extensionCookie              = 44
+	extensionPSKModes            = 45
+	extensionKeyShare            = 51
+)
+
+// tls13Versions names the protocol versions probed for.
+var tls13Versions = map[uint16]string{
+	0x0300:       "SSL 3.0",
+	versionTLS10: "TLS 1.0",
+	versionTLS11: "TLS 1.1",
+	versionTLS12: "TLS 1.2",
+	versionTLS13: "TLS 1.3",
+}
+
+// TLS13CipherSuites names the TLS 1.3 cipher suites.
+var TLS13CipherSuites = map[uint16]string{
+	0x1301: "TLS_AES_128_GCM_SHA256",
+	0x1302: "TLS_AES_256_GCM_SHA384",
+	0x1303: "TLS_CHACHA20_POLY1305_SHA256",
+	0x1304: "TLS_AES_128_CCM_SHA256",
+	0x1305: "TLS_AES_128_CCM_8_SHA256",
+}
+
+// tls13CipherSuiteIDs lists the TLS 1.3 cipher suites in the order a
+// modern client prefers them.
+var tls13CipherSuiteIDs = []uint16{0x1301, 0x1302, 0x1303, 0x1304, 0x1305}
+
+// Groups names the key exchange groups usable with TLS 1.3.
+var Groups = map[uint16]string{
+	23:     "secp256r1",
+	24:     "secp384r1",
+	25:     "secp521r1",
+	29:     "x25519",
+	30:     "x448",
+	256:    "ffdhe2048",
+	257:    "ffdhe3072",
+	258:    "ffdhe4096",
+	259:    "ffdhe6144",
+	260:    "ffdhe8192",
+	0x11EB: "SecP256r1MLKEM768",
+	0x11EC: "X25519MLKEM768",
+	0x11ED: "SecP384r1MLKEM1024",
+	0x6399: "X25519Kyber768Draft00",
+}
+
+const (
+	groupP256           uint16 = 23
+	groupP384           uint16 = 24
+	groupP521           uint16 = 25
+	groupX25519         uint16 = 29
+	groupX25519MLKEM768 uint16 = 0x11EC
+)
+
+// groupIDs lists the groups in the order a modern client prefers them.
+var groupIDs = []uint16{0x11EC, 0x11EB, 0x11ED, 0x6399, 29, 30, 23, 24, 25, 256, 257, 258, 259, 260}
+
+// hybridGroups are the post-quantum hybrid key exchange groups.
+var hybridGroups = map[uint16]bool{0x11EB: true, 0x11EC: true, 0x11ED: true, 0x6399: true}
+
+var tls13SignatureSchemes = []uint16{
+	0x0403, 0x0503, 0x0603, // ECDSA
+	0x0804, 0x0805, 0x0806, // RSA-PSS with rsaEncryption keys
+	0x0807,                 // Ed25519
+	0x0809, 0x080a, 0x080b, // RSA-PSS with RSASSA-PSS keys
+	0x0401, 0x0501, 0x0601, // RSA PKCS #1 v1.5
+	0x0201, 0x0203, // SHA-1, for old TLS 1.2 servers
+}
+
+// helloRetryRequestRandom is the ServerHello.random value that marks
+// a HelloRetryRequest.
+var helloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11,
+	0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e,
+	0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// An alertError is the alert a server sent in reply to a handshake
+// message.
+type alertError uint8
+
+func (e alertError) Error() string {
+	return fmt.Sprintf("server sent alert %d", uint8(e))
+}
+
+// mlkemCiphertextSize768 is the size of an ML-KEM-768 ciphertext.
+const mlkemCiphertextSize768 = 1088
+
+// A keyShare is a client key share and the private key behind it.
+type keyShare struct {
+	group uint16
+	data  []byte
+
+	// x25519 is the private key of an X25519 share; otherwise,
+	// priv is the private key of a share on curve.
+	x25519 *[32]byte
+	curve  elliptic.Curve
+	priv   []byte
+
+	// decapsulate completes the ML-KEM half of a hybrid share.
+	decapsulate func(ciphertext []byte) ([]byte, error)
+}
+
+// newKeyShare generates a key share for group, if the group is one we
+// can complete a key exchange with.
+func newKeyShare(group uint16) (*keyShare, error) {
+	ks := &keyShare{group: group}
+	switch group {
+	case groupX25519, groupX25519MLKEM768:
+	case groupP256:
+		ks.curve = elliptic.P256()
+	case groupP384:
+		ks.curve = elliptic.P384()
+	case groupP521:
+		ks.curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("no key share support for group %d", group)
+	}
+
+	if ks.curve == nil {
+		ks.x25519 = new([32]byte)
+		if _, err := io.ReadFull(rand.Reader, ks.x25519[:]); err != nil {
+			return nil, err
+		}
+		var pub [32]byte
+		curve25519.ScalarBaseMult(&pub, ks.x25519)
+		ks.data = pub[:]
+	} else {
+		priv, x, y, err := elliptic.GenerateKey(ks.curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		ks.priv = priv
+		ks.data = elliptic.Marshal(ks.curve, x, y)
+	}
+
+	if group == groupX25519MLKEM768 {
+		decapsulate, encapsulationKey, err := generateMLKEM768()
+		if err != nil {
+			return nil, err
+		}
+		ks.decapsulate = decapsulate
+		ks.data = append(encapsulationKey, ks.data...)
+	}
+	return ks, nil
+}
+
+// sharedSecret completes the key exchange with the server's share.
+func (ks *keyShare) sharedSecret(serverShare []byte) ([]byte, error) {
+	var mlkemSecret []byte
+	if ks.decapsulate != nil {
+		if len(serverShare) != mlkemCiphertextSize768+32 {
+			return nil, errors.New("bad hybrid key share from server")
+		}
+		var err error
+		mlkemSecret, err = ks.decapsulate(serverShare[:mlkemCiphertextSize768])
+		if err != nil {
+			return nil, err
+		}
+		serverShare = serverShare[mlkemCiphertextSize768:]
+	}
+
+	if ks.x25519 != nil {
+		if len(serverShare) != 32 {
+			return nil, errors.New("bad X25519 key share from server")
+		}
+		var pub, secret, zero [32]byte
+		copy(pub[:], serverShare)
+		curve25519.ScalarMult(&secret, ks.x25519, &pub)
+		if subtle.ConstantTimeCompare(secret[:], zero[:]) == 1 {
+			return nil, errors.New("bad X25519 key share from server")
+		}
+		return append(mlkemSecret, secret[:]...), nil
+	}
+
+	x, y := elliptic.Unmarshal(ks.curve, serverShare)
+	if x == nil || !ks.curve.IsOnCurve(x, y) {
+		return nil, errors.New("bad ECDHE key share from server")
+	}
+	x, _ = ks.curve.ScalarMult(x, y, ks.priv)
+	secret := make([]byte, (ks.curve.Params().BitSize+7)/8)
+	xBytes := x.Bytes()
+	copy(secret[len(secret)-len(xBytes):], xBytes)
+	return append(mlkemSecret, secret...), nil
+}
+
+// A clientHello describes a ClientHello to send. If versions is
+// empty, a pre-TLS 1.3 hello for legacyVersion is sent.
+type clientHello struct {
+	legacyVersion uint16
+	versions      []uint16
+	serverName    string
+	random        []byte
+	sessionID     []byte
+	suites        []uint16
+	groups        []uint16
+	keyShares     []*keyShare
+	alpn          []string
+	cookie        []byte
+}
+
+func addUint16List(b *cryptobyte.Builder, list []uint16) {
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, v := range list {
+			b.AddUint16(v)
+		}
+	})
+}
+
+// marshal returns the ClientHello handshake message. The random is
+// kept so that a hello sent again after a HelloRetryRequest matches.
+func (ch *clientHello) marshal() ([]byte, error) {
+	if ch.random == nil {
+		ch.random = make([]byte, 32)
+		if _, err := rand.Read(ch.random); err != nil {
+			return nil, err
+		}
+	}
+
+	var b cryptobyte.Builder
+	b.AddUint8(typeClientHello)
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(ch.legacyVersion)
+		b.AddBytes(ch.random)
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(ch.sessionID)
+		})
+		addUint16List(b, ch.suites)
+		b.AddUint8(1) // compression methods
+		b.AddUint8(0)
+
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			if ch.serverName != "" && net.ParseIP(ch.serverName) == nil {
+				b.AddUint16(extensionServerName)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+						b.AddUint8(0) // host_name
+						b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+							b.AddBytes([]byte(ch.serverName))
+						})
+					})
+				})
+			}
+
+			b.AddUint16(extensionSupportedGroups)
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				addUint16List(b, ch.groups)
+			})
+
+			b.AddUint16(extensionSupportedPoints)
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint8(0) // uncompressed
+				})
+			})
+
+			b.AddUint16(extensionSignatureAlgorithms)
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				addUint16List(b, tls13SignatureSchemes)
+			})
+
+			if len(ch.alpn) > 0 {
+				b.AddUint16(extensionALPN)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+						for _, proto := range ch.alpn {
+							b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+								b.AddBytes([]byte(proto))
+							})
+						}
+					})
+				})
+			}
+
+			if len(ch.versions) == 0 {
+				return
+			}
+
+			b.AddUint16(extensionSupportedVersions)
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+					for _, v := range ch.versions {
+						b.AddUint16(v)
+					}
+				})
+			})
+
+			if ch.cookie != nil {
+				b.AddUint16(extensionCookie)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+						b.AddBytes(ch.cookie)
+					})
+				})
+			}
+
+			b.AddUint16(extensionPSKModes)
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddUint8(1) // psk_dhe_ke
+				})
+			})
+
+			b.AddUint16(extensionKeyShare)
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					for _, ks := range ch.keyShares {
+						b.AddUint16(ks.group)
+						b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+							b.AddBytes(ks.data)
+						})
+					}
+				})
+			})
+		})
+	})
+	return b.Bytes()
+}
+
+// A serverHello is a parsed ServerHello or HelloRetryRequest.
+type serverHello struct {
+	raw      []byte
+	version  uint16
+	suite    uint16
+	retry    bool
+	group    uint16
+	keyShare []byte
+	cookie   []byte
+	alpn     string
+}
+
+func parseServerHello(msg []byte) (*serverHello, error) {
+	sh := &serverHello{raw: msg}
+	s := cryptobyte.String(msg[4:])
+
+	var random, sessionID []byte
+	var compression uint8
+	var extensions cryptobyte.String
+	if !s.ReadUint16(&sh.version) || !s.ReadBytes(&random, 32) ||
+		!s.ReadUint8LengthPrefixed((*cryptobyte.String)(&sessionID)) ||
+		!s.ReadUint16(&sh.suite) || !s.ReadUint8(&compression) {
+		return nil, errors.New("malformed ServerHello")
+	}
+	sh.retry = bytes.Equal(random, helloRetryRequestRandom)
+
+	if s.Empty() {
+		return sh, nil
+	}
+	if !s.ReadUint16LengthPrefixed(&extensions) {
+		return nil, errors.New("malformed ServerHello extensions")
+	}
+
+	for !extensions.Empty() {
+		var ext uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&ext) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return nil, errors.New("malformed ServerHello extensions")
+		}
+
+		var ok bool
+		switch ext {
+		case extensionSupportedVersions:
+			ok = data.ReadUint16(&sh.version)
+		case extensionKeyShare:
+			ok = data.ReadUint16(&sh.group)
+			if ok && !sh.retry {
+				ok = data.ReadUint16LengthPrefixed((*cryptobyte.String)(&sh.keyShare))
+			}
+		case extensionCookie:
+			ok = data.ReadUint16LengthPrefixed((*cryptobyte.String)(&sh.cookie))
+		case extensionALPN:
+			sh.alpn, ok = parseALPN(data)
+		default:
+			ok = true
+		}
+		if !ok {
+			return nil, fmt.Errorf("malformed ServerHello extension %d", ext)
+		}
+	}
+	return sh, nil
+}
+
+func parseALPN(data cryptobyte.String) (string, bool) {
+	var list, proto cryptobyte.String
+	if !data.ReadUint16LengthPrefixed(&list) || !list.ReadUint8LengthPrefixed(&proto) {
+		return "", false
+	}
+	return string(proto), true
+}
+
+// A helloConn is a TCP connection carrying a raw TLS handshake.
+type helloConn struct {
+	net.Conn
+	buf  []byte
+	sent bool
+}
+
+func dialHello(addr, hostname string) (*helloConn, error) {
+	conn, err := dial(addr, hostname)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &helloConn{Conn: conn}, nil
+}
+
+func (c *helloConn) writeRecord(typ uint8, version uint16, data []byte) error {
+	hdr := []byte{typ, byte(version >> 8), byte(version), byte(len(data) >> 8), byte(len(data))}
+	_, err := c.Write(append(hdr, data...))
+	return err
+}
+
+// readRecord returns the next record, skipping the ChangeCipherSpec
+// sent for middlebox compatibility. An alert is returned as an
+// alertError.
+func (c *helloConn) readRecord() (typ uint8, hdr, data []byte, err error) {
+	for {
+		hdr = make([]byte, 5)
+		if _, err = io.ReadFull(c, hdr); err != nil {
+			return 0, nil, nil, errHelloFailed
+		}
+		data = make([]byte, binary.BigEndian.Uint16(hdr[3:]))
+		if _, err = io.ReadFull(c, data); err != nil {
+			return 0, nil, nil, errHelloFailed
+		}
+
+		switch hdr[0] {
+		case recordTypeChangeCipherSpec:
+			continue
+		case recordTypeAlert:
+			if len(data) == 2 {
+				return 0, nil, nil, alertError(data[1])
+			}
+			return 0, nil, nil, errHelloFailed
+		}
+		return hdr[0], hdr, data, nil
+	}
+}
+
+// readHandshake returns the next plaintext handshake message.
+func (c *helloConn) readHandshake() ([]byte, error) {
+	for len(c.buf) < 4 || len(c.buf) < 4+(int(c.buf[1])<<16|int(c.buf[2])<<8|int(c.buf[3])) {
+		typ, _, data, err := c.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		if typ != recordTypeHandshake {
+			return nil, fmt.Errorf("unexpected record type %d", typ)
+		}
+		c.buf = append(c.buf, data...)
+	}
+
+	n := 4 + (int(c.buf[1])<<16 | int(c.buf[2])<<8 | int(c.buf[3]))
+	msg := c.buf[:n]
+	c.buf = c.buf[n:]
+	return msg, nil
+}
+
+// sayHello sends ch and returns the server's reply. A rejected hello
+// returns errHelloFailed.
+func (c *helloConn) sayHello(ch *clientHello) (msg []byte, sh *serverHello, err error) {
+	msg, err = ch.marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Only the first record may carry the TLS 1.0 record version.
+	recordVersion := versionTLS10
+	if c.sent {
+		recordVersion = versionTLS12
+	}
+	c.sent = true
+	if err = c.writeRecord(recordTypeHandshake, recordVersion, msg); err != nil {
+		return nil, nil, err
+	}
+
+	reply, err := c.readHandshake()
+	if err != nil {
+		if _, ok := err.(alertError); ok {
+			err = errHelloFailed
+		}
+		return nil, nil, err
+	}
+	if reply[0] != typeServerHello {
+		return nil, nil, fmt.Errorf("unexpected handshake message %d", reply[0])
+	}
+
+	sh, err = parseServerHello(reply)
+	return msg, sh, err
+}
+
+// newTLS13Hello returns a TLS 1.3 ClientHello with key shares for
+// shareGroups.
+func newTLS13Hello(hostname string, suites, groups, shareGroups []uint16) (*clientHello, error) {
+	sessionID := make([]byte, 32)
+	if _, err := rand.Read(sessionID); err != nil {
+		return nil, err
+	}
+
+	ch := &clientHello{
+		legacyVersion: versionTLS12,
+		versions:      []uint16{versionTLS13},
+		serverName:    hostname,
+		sessionID:     sessionID,
+		suites:        suites,
+		groups:        groups,
+	}
+	for _, group := range shareGroups {
+		ks, err := newKeyShare(group)
+		if err != nil {
+			return nil, err
+		}
+		ch.keyShares = append(ch.keyShares, ks)
+	}
+	return ch, nil
+}
+
+// probeTLS13 sends a single TLS 1.3 ClientHello and returns the
+// server's ServerHello or HelloRetryRequest.
+func probeTLS13(addr, hostname string, suites, groups, shareGroups []uint16) (*serverHello, error) {
+	ch, err := newTLS13Hello(hostname, suites, groups, shareGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialHello(addr, hostname)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, sh, err := conn.sayHello(ch)
+	if err != nil {
+		return nil, err
+	}
+	if sh.version != versionTLS13 {
+		return nil, errHelloFailed
+	}
+	return sh, nil
+}
+
+// A handshake13 is a TLS 1.3 handshake carried as far as the
+// server's first encrypted flight.
+type handshake13 struct {
+	conn       *helloConn
+	transcript hash.Hash
+	suite      uint16
+	retried    bool
+	// retryGroup is the group asked for by a HelloRetryRequest.
+	retryGroup uint16
+	sh         *serverHello
+	secret     []byte
+}
+
+func suiteHash(suite uint16) (func() hash.Hash, int, error) {
+	switch suite {
+	case 0x1301:
+		return sha256.New, 16, nil
+	case 0x1302:
+		return sha512.New384, 32, nil
+	default:
+		return nil, 0, fmt.Errorf("cannot decrypt cipher suite %#04x", suite)
+	}
+}
+
+// startTLS13 sends ch, answers a HelloRetryRequest once, and returns
+// the handshake once a ServerHello has been received. Only AES-GCM
+// suites and groups newKeyShare supports can be completed.
+func startTLS13(addr string, ch *clientHello) (*handshake13, error) {
+	conn, err := dialHello(addr, ch.serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	hs := &handshake13{conn: conn}
+	msg, sh, err := conn.sayHello(ch)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if sh.version != versionTLS13 {
+		conn.Close()
+		return nil, errHelloFailed
+	}
+
+	newHash, _, err := suiteHash(sh.suite)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	hs.transcript = newHash()
+	hs.transcript.Write(msg)
+
+	if sh.retry {
+		hs.retried = true
+		hs.retryGroup = sh.group
+
+		// The transcript restarts with a hash of the first
+		// ClientHello.
+		ch1 := hs.transcript.Sum(nil)
+		hs.transcript.Reset()
+		hs.transcript.Write([]byte{typeMessageHash, 0, 0, byte(len(ch1))})
+		hs.transcript.Write(ch1)
+		hs.transcript.Write(sh.raw)
+
+		ks, err := newKeyShare(sh.group)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		ch.keyShares = []*keyShare{ks}
+		ch.cookie = sh.cookie
+
+		retrySuite := sh.suite
+		msg, sh, err = conn.sayHello(ch)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if sh.retry || sh.suite != retrySuite || sh.group != hs.retryGroup {
+			conn.Close()
+			return nil, errors.New("server changed its parameters after HelloRetryRequest")
+		}
+		hs.transcript.Write(msg)
+	}
+	hs.transcript.Write(sh.raw)
+	hs.sh = sh
+	hs.suite = sh.suite
+
+	for _, ks := range ch.keyShares {
+		if ks.group == sh.group {
+			hs.secret, err = ks.sharedSecret(sh.keyShare)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	}
+	if hs.secret == nil {
+		conn.Close()
+		return nil, errors.New("server selected a group we sent no key share for")
+	}
+	return hs, nil
+}
+
+func expandLabel(h func() hash.Hash, secret []byte, label string, context []byte, length int) ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(length))
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte("tls13 " + label))
+	})
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(context)
+	})
+	info, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return hkdfExpand(h, secret, info, length), nil
+}
+
+// hkdfExtract and hkdfExpand are the HKDF functions of RFC 5869.
+func hkdfExtract(h func() hash.Hash, secret, salt []byte) []byte {
+	if salt == nil {
+		salt = make([]byte, h().Size())
+	}
+	mac := hmac.New(h, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+func hkdfExpand(h func() hash.Hash, prk, info []byte, length int) []byte {
+	mac := hmac.New(h, prk)
+	var out, prev []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		mac.Reset()
+		mac.Write(prev)
+		mac.Write(info)
+		mac.Write([]byte{counter})
+		prev = mac.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
+
+// encryptedExtensions decrypts the server's first encrypted record
+// and returns its EncryptedExtensions message.
+func (hs *handshake13) encryptedExtensions() ([]byte, error) {
+	newHash, keyLen, err := suiteHash(hs.suite)
+	if err != nil {
+		return nil, err
+	}
+	hashLen := newHash().Size()
+
+	early := hkdfExtract(newHash, make([]byte, hashLen), nil)
+	empty := newHash().Sum(nil)
+	derived, err := expandLabel(newHash, early, "derived", empty, hashLen)
+	if err != nil {
+		return nil, err
+	}
+	handshakeSecret := hkdfExtract(newHash, hs.secret, derived)
+	trafficSecret, err := expandLabel(newHash, handshakeSecret, "s hs traffic", hs.transcript.Sum(nil), hashLen)
+	if err != nil {
+		return nil, err
+	}
+	key, err := expandLabel(newHash, trafficSecret, "key", nil, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := expandLabel(newHash, trafficSecret, "iv", nil, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	typ, hdr, data, err := hs.conn.readRecord()
+	if err != nil {
+		return nil, err
+	}
+	if typ != recordTypeApplicationData {
+		return nil, fmt.Errorf("unexpected record type %d", typ)
+	}
+
+	// The first record uses sequence number zero, so the nonce
+	// is the IV itself.
+	plaintext, err := aead.Open(nil, iv, data, hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Strip the padding and the inner content type.
+	i := len(plaintext) - 1
+	for i >= 0 && plaintext[i] == 0 {
+		i--
+	}
+	if i < 0 || plaintext[i] != recordTypeHandshake {
+		return nil, errors.New("unexpected encrypted content")
+	}
+	plaintext = plaintext[:i]
+
+	if len(plaintext) < 4 || plaintext[0] != typeEncryptedExtensions {
+		return nil, errors.New("expected EncryptedExtensions")
+	}
+	n := 4 + (int(plaintext[1])<<16 | int(plaintext[2])<<8 | int(plaintext[3]))
+	if len(plaintext) < n {
+		return nil, errors.New("EncryptedExtensions spans records")
+	}
+	return plaintext[:n], nil
+}
+
+// negotiatedALPN returns the protocol selected in EncryptedExtensions.
+func negotiatedALPN(ee []byte) (string, error) {
+	s := cryptobyte.String(ee[4:])
+	var extensions cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&extensions) {
+		return "", errors.New("malformed EncryptedExtensions")
+	}
+	for !extensions.Empty() {
+		var ext uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&ext) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return "", errors.New("malformed EncryptedExtensions")
+		}
+		if ext == extensionALPN {
+			proto, ok := parseALPN(data)
+			if !ok {
+				return "", errors.New("malformed ALPN extension")
+			}
+			return proto, nil
+		}
+	}
+	return "", nil
+}
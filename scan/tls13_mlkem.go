@@ -0,0 +1,19 @@
+// +build go1.24
+
+package scan
+
+import "crypto/mlkem"
+
+// mlkemSupported reports whether hybrid ML-KEM key shares can be
+// made; crypto/mlkem needs Go 1.24.
+const mlkemSupported = true
+
+// generateMLKEM768 returns a new ML-KEM-768 encapsulation key and the
+// function decapsulating ciphertexts sent in reply.
+func generateMLKEM768() (func([]byte) ([]byte, error), []byte, error) {
+	dk, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, nil, err
+	}
+	return dk.Decapsulate, dk.EncapsulationKey().Bytes(), nil
+}
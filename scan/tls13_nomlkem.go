@@ -0,0 +1,13 @@
+// +build !go1.24
+
+package scan
+
+import "errors"
+
+// mlkemSupported reports whether hybrid ML-KEM key shares can be
+// made; crypto/mlkem needs Go 1.24.
+const mlkemSupported = false
+
+func generateMLKEM768() (func([]byte) ([]byte, error), []byte, error) {
+	return nil, nil, errors.New("ML-KEM key shares need Go 1.24 or later")
+}
@@ -0,0 +1,137 @@
+package scan
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTLS13Server(t *testing.T) (*httptest.Server, string) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.StartTLS()
+	server.TLS.NextProtos = []string{"h2"}
+	return server, strings.TrimPrefix(server.URL, "https://")
+}
+
+func TestTLS13Versions(t *testing.T) {
+	server, addr := newTLS13Server(t)
+	defer server.Close()
+
+	grade, output, err := versionsScan(addr, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := output.([]string)
+	if grade != Good || len(versions) != 2 || versions[0] != "TLS 1.3" || versions[1] != "TLS 1.2" {
+		t.Fatalf("unexpected result %v %v", grade, versions)
+	}
+}
+
+func TestTLS13CipherSuites(t *testing.T) {
+	server, addr := newTLS13Server(t)
+	defer server.Close()
+
+	grade, output, err := tls13CipherSuiteScan(addr, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites := output.([]string)
+	if grade != Good || len(suites) != 3 || indexOfString(suites, "TLS_AES_128_GCM_SHA256") < 0 {
+		t.Fatalf("unexpected result %v %v", grade, suites)
+	}
+}
+
+func TestTLS13Groups(t *testing.T) {
+	server, addr := newTLS13Server(t)
+	defer server.Close()
+
+	grade, output, err := groupsScan(addr, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := output.([]string)
+	if grade != Good || indexOfString(groups, "x25519") < 0 || indexOfString(groups, "secp256r1") < 0 {
+		t.Fatalf("unexpected result %v %v", grade, groups)
+	}
+	if indexOfString(groups, "ffdhe2048") >= 0 {
+		t.Fatalf("unexpected group support %v", groups)
+	}
+}
+
+func TestTLS13HelloRetryRequest(t *testing.T) {
+	server, addr := newTLS13Server(t)
+	defer server.Close()
+
+	grade, output, err := helloRetryScan(addr, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good || output.(helloRetryResult).Group == "" {
+		t.Fatalf("unexpected result %v %+v", grade, output)
+	}
+
+	// Complete the key exchange with NIST curves. The vendored
+	// crypto/tls shadows the standard one, so the server's curves
+	// can only be given as untyped constants.
+	server.TLS.CurvePreferences = append(server.TLS.CurvePreferences[:0], 23)
+	if _, output, err = helloRetryScan(addr, "example.com"); err != nil || output.(helloRetryResult).Group != "secp256r1" {
+		t.Fatalf("unexpected result with secp256r1: %+v %v", output, err)
+	}
+	server.TLS.CurvePreferences = append(server.TLS.CurvePreferences[:0], 25)
+	if _, output, err = helloRetryScan(addr, "example.com"); err != nil || output.(helloRetryResult).Group != "secp521r1" {
+		t.Fatalf("unexpected result with secp521r1: %+v %v", output, err)
+	}
+}
+
+func TestTLS13ALPN(t *testing.T) {
+	server, addr := newTLS13Server(t)
+	defer server.Close()
+
+	grade, output, err := alpnScan(addr, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The test server only offers HTTP/1.1 when HTTP/2 is disabled.
+	protocols := output.([]string)
+	if grade != Good || len(protocols) != 1 || protocols[0] != "h2" {
+		t.Fatalf("unexpected result %v %v", grade, protocols)
+	}
+
+	server.TLS.NextProtos = []string{"http/1.1"}
+	grade, output, err = alpnScan(addr, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	protocols = output.([]string)
+	if grade != Warning || len(protocols) != 1 || protocols[0] != "http/1.1" {
+		t.Fatalf("unexpected result %v %v", grade, protocols)
+	}
+}
+
+func TestTLS13Skipped(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.StartTLS()
+	defer server.Close()
+	server.TLS.MaxVersion = 0x0303
+	addr := strings.TrimPrefix(server.URL, "https://")
+
+	for name, scan := range map[string]func(string, string) (Grade, Output, error){
+		"CipherSuites":      tls13CipherSuiteScan,
+		"Groups":            groupsScan,
+		"HelloRetryRequest": helloRetryScan,
+		"ALPN":              alpnScan,
+	} {
+		if grade, _, err := scan(addr, "example.com"); grade != Skipped || err != nil {
+			t.Fatalf("%s: expected the scan to be skipped, got %v %v", name, grade, err)
+		}
+	}
+
+	grade, output, err := versionsScan(addr, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Warning || len(output.([]string)) != 1 {
+		t.Fatalf("unexpected result %v %v", grade, output)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"net"
 	"strings"
 
 	"github.com/cloudflare/cfssl/helpers"
@@ -66,7 +65,7 @@ func getCurveIndex(curves []tls.CurveID, serverCurve tls.CurveID) (curveIndex in
 }
 
 func sayHello(addr, hostname string, ciphers []uint16, curves []tls.CurveID, vers uint16, sigAlgs []tls.SignatureAndHash) (cipherIndex, curveIndex int, certs [][]byte, err error) {
-	tcpConn, err := net.Dial(Network, addr)
+	tcpConn, err := dial(addr, hostname)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,258 @@
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
+	cferr "github.com/cloudflare/cfssl/errors"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/signer"
+)
+
+// caps lists the capabilities advertised in response to GetCACaps.
+var caps = []string{"POSTPKIOperation", "SHA-1", "SHA-256", "AES", "DES3", "SCEPStandard", "Renewal"}
+
+// maxMessageLength bounds the body of POSTed PKI messages.
+const maxMessageLength = 1 << 16
+
+// A ChallengeValidator decides whether the challenge password carried in
+// a PKCSReq authorizes the enclosed CSR.
+type ChallengeValidator interface {
+	Validate(challenge string, csr *x509.CertificateRequest) error
+}
+
+// StaticChallenge is a ChallengeValidator accepting requests whose
+// challenge password matches a single shared secret.
+type StaticChallenge string
+
+// Validate compares the challenge password with the shared secret in
+// constant time. An empty password, or secret, never matches.
+func (sc StaticChallenge) Validate(challenge string, csr *x509.CertificateRequest) error {
+	if sc == "" || challenge == "" || subtle.ConstantTimeCompare([]byte(sc), []byte(challenge)) != 1 {
+		return errors.New("scep: invalid challenge password")
+	}
+	return nil
+}
+
+// A Responder serves SCEP requests, issuing certificates through Signer.
+// The registration authority (RA) certificate and key are used to decrypt
+// requests and sign responses; they may be the CA's own, but the RA key
+// must be an RSA key.
+type Responder struct {
+	Signer signer.Signer
+	CA     *x509.Certificate
+	RACert *x509.Certificate
+	RAKey  crypto.Signer
+
+	// Challenge authorizes PKCSReq requests; if it is nil, every
+	// request with a valid signature is signed.  RenewalReq requests
+	// are authorized by the certificate they are signed with instead.
+	Challenge ChallengeValidator
+
+	// Profile is the signing profile requested from Signer.
+	Profile string
+}
+
+// NewResponder creates a Responder issuing certificates with s, using the
+// CA certificate and key as the RA. A StaticChallenge must not be empty,
+// as requests without a challenge password would then match it.
+func NewResponder(s signer.Signer, ca *x509.Certificate, key crypto.Signer, challenge ChallengeValidator) (*Responder, error) {
+	if sc, ok := challenge.(StaticChallenge); ok && sc == "" {
+		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, errors.New("scep: the challenge password is empty"))
+	}
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.Unavailable, errors.New("scep: the RA key must be an RSA key"))
+	}
+	if _, ok := key.(crypto.Decrypter); !ok {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.Unavailable, errors.New("scep: the RA key cannot decrypt"))
+	}
+	return &Responder{
+		Signer:    s,
+		CA:        ca,
+		RACert:    ca,
+		RAKey:     key,
+		Challenge: challenge,
+	}, nil
+}
+
+// NewResponderFromFile creates a Responder from the CA certificate and
+// key files, and the file holding the challenge password.  The file names
+// accept the prefixes understood by helpers.ReadBytes.
+func NewResponderFromFile(s signer.Signer, caFile, caKeyFile, challengeFile string) (*Responder, error) {
+	caPEM, err := helpers.ReadBytes(caFile)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := helpers.ParseCertificatePEM(caPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := helpers.ReadBytes(caKeyFile)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.ReadFailed, err)
+	}
+	key, err := helpers.ParsePrivateKeyPEMFromEnv(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := helpers.ReadBytes(challengeFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewResponder(s, ca, key, StaticChallenge(strings.TrimSpace(string(challenge))))
+}
+
+// ServeHTTP dispatches on the "operation" query parameter, as SCEP
+// clients append it to the configured URL.
+func (rs *Responder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch op := r.URL.Query().Get("operation"); op {
+	case "GetCACert":
+		rs.getCACert(w)
+	case "GetCACaps":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Join(caps, "\n")))
+	case "PKIOperation":
+		rs.pkiOperation(w, r)
+	default:
+		log.Debugf("unsupported SCEP operation %q", op)
+		http.Error(w, "unsupported operation", http.StatusBadRequest)
+	}
+}
+
+func (rs *Responder) getCACert(w http.ResponseWriter) {
+	if rs.RACert.Equal(rs.CA) {
+		w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		w.Write(rs.CA.Raw)
+		return
+	}
+
+	chain, err := pkcs7.DegenerateCertificates([]*x509.Certificate{rs.RACert, rs.CA})
+	if err != nil {
+		log.Errorf("failed to encode SCEP CA chain: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-x509-ca-ra-cert")
+	w.Write(chain)
+}
+
+func (rs *Responder) pkiOperation(w http.ResponseWriter, r *http.Request) {
+	var der []byte
+	var err error
+	switch r.Method {
+	case "GET":
+		// '+' in an unescaped base64 message decodes as a space.
+		message := strings.Replace(r.URL.Query().Get("message"), " ", "+", -1)
+		der, err = base64.StdEncoding.DecodeString(message)
+	case "POST":
+		der, err = ioutil.ReadAll(io.LimitReader(r.Body, maxMessageLength+1))
+		if err == nil && len(der) > maxMessageLength {
+			log.Infof("SCEP message is too long")
+			http.Error(w, "message too long", http.StatusRequestEntityTooLarge)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Infof("failed to read SCEP message: %v", err)
+		http.Error(w, "malformed message", http.StatusBadRequest)
+		return
+	}
+
+	raKey, ok := rs.RAKey.(crypto.Decrypter)
+	if !ok {
+		log.Error("SCEP RA key cannot decrypt")
+		http.Error(w, "RA key cannot decrypt", http.StatusInternalServerError)
+		return
+	}
+	msg, err := ParsePKIMessage(der, rs.RACert, raKey)
+	if err != nil {
+		log.Infof("invalid SCEP message: %v", err)
+		http.Error(w, "malformed message", http.StatusBadRequest)
+		return
+	}
+	log.Infof("SCEP message type %s, transaction %s", msg.MessageType, msg.TransactionID)
+
+	status, failInfo, cert := rs.handle(msg)
+	rep, err := newCertRep(msg, rs.RACert, rs.RAKey, status, failInfo, cert)
+	if err != nil {
+		log.Errorf("failed to build SCEP response: %v", err)
+		http.Error(w, "failed to build response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pki-message")
+	w.Write(rep)
+}
+
+// handle processes a verified request, returning the status of the
+// CertRep and, on success, the issued certificate.
+func (rs *Responder) handle(msg *PKIMessage) (PKIStatus, FailInfo, *x509.Certificate) {
+	switch msg.MessageType {
+	case PKCSReq:
+		if rs.Challenge != nil {
+			if err := rs.Challenge.Validate(msg.ChallengePassword, msg.CSR); err != nil {
+				log.Infof("SCEP transaction %s rejected: %v", msg.TransactionID, err)
+				return Failure, BadRequest, nil
+			}
+		}
+	case RenewalReq:
+		if err := rs.checkRenewal(msg); err != nil {
+			log.Infof("SCEP transaction %s rejected: %v", msg.TransactionID, err)
+			return Failure, BadRequest, nil
+		}
+	default:
+		log.Infof("unsupported SCEP message type %s", msg.MessageType)
+		return Failure, BadRequest, nil
+	}
+
+	req := signer.SignRequest{
+		Request: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: msg.CSR.Raw})),
+		Profile: rs.Profile,
+	}
+	certPEM, err := rs.Signer.Sign(req)
+	if err != nil {
+		log.Warningf("SCEP transaction %s: failed to sign request: %v", msg.TransactionID, err)
+		return Failure, BadRequest, nil
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		log.Errorf("SCEP transaction %s: failed to parse issued certificate: %v", msg.TransactionID, err)
+		return Failure, BadRequest, nil
+	}
+	return Success, "", cert
+}
+
+// checkRenewal authorizes a RenewalReq: it must be signed with a current
+// certificate issued by the CA, and request a certificate for the same
+// subject.
+func (rs *Responder) checkRenewal(msg *PKIMessage) error {
+	if err := msg.Signer.CheckSignatureFrom(rs.CA); err != nil {
+		return fmt.Errorf("scep: renewal not signed with a certificate issued by the CA: %v", err)
+	}
+	now := time.Now()
+	if now.Before(msg.Signer.NotBefore) || now.After(msg.Signer.NotAfter) {
+		return errors.New("scep: renewal signed with an expired or not yet valid certificate")
+	}
+	if !bytes.Equal(msg.Signer.RawSubject, msg.CSR.RawSubject) {
+		return errors.New("scep: renewal requests a different subject")
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/initca"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+)
+
+const testChallenge = "open sesame"
+
+func newTestResponder(t *testing.T) *Responder {
+	req := &csr.CertificateRequest{
+		CN:         "SCEP Test CA",
+		KeyRequest: &csr.BasicKeyRequest{A: "rsa", S: 2048},
+	}
+	certPEM, _, keyPEM, err := initca.New(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := local.NewSigner(key, ca, x509.SHA256WithRSA, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := NewResponder(s, ca, key, StaticChallenge(testChallenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+// newTestCSR builds a CSR carrying a challengePassword attribute, which
+// crypto/x509 cannot produce.
+func newTestCSR(t *testing.T, key *rsa.PrivateKey, challenge string) []byte {
+	subject, err := asn1.Marshal(pkix.Name{CommonName: "device.example.com"}.ToRDNSequence())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, err := asn1.Marshal(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type attribute struct {
+		Type   asn1.ObjectIdentifier
+		Values []asn1.RawValue `asn1:"set"`
+	}
+	tbs, err := asn1.Marshal(struct {
+		Version    int
+		Subject    asn1.RawValue
+		PublicKey  asn1.RawValue
+		Attributes []attribute `asn1:"tag:0"`
+	}{
+		Subject:    asn1.RawValue{FullBytes: subject},
+		PublicKey:  asn1.RawValue{FullBytes: spki},
+		Attributes: []attribute{{Type: oidChallengePassword, Values: []asn1.RawValue{{FullBytes: password}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(tbs)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(struct {
+		TBS       asn1.RawValue
+		Algorithm pkix.AlgorithmIdentifier
+		Signature asn1.BitString
+	}{
+		TBS:       asn1.RawValue{FullBytes: tbs},
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, Parameters: asn1.RawValue{Tag: asn1.TagNull}},
+		Signature: asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// testClient holds the transient key and self-signed certificate a SCEP
+// client signs its requests with.
+type testClient struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestClient(t *testing.T) *testClient {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "device.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{key: key, cert: cert}
+}
+
+func (c *testClient) pkcsReq(t *testing.T, ra *x509.Certificate, challenge string) []byte {
+	return c.request(t, PKCSReq, ra, challenge)
+}
+
+func (c *testClient) request(t *testing.T, messageType MessageType, ra *x509.Certificate, challenge string) []byte {
+	envelope, err := pkcs7.Encrypt(newTestCSR(t, c.key, challenge), []*x509.Certificate{ra}, pkcs7.AES128CBC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := pkcs7.SignData(envelope, c.cert, c.key,
+		pkcs7.Attribute{Type: oidMessageType, Value: string(messageType)},
+		pkcs7.Attribute{Type: oidTransactionID, Value: "transaction"},
+		pkcs7.Attribute{Type: oidSenderNonce, Value: []byte("client nonce")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// certRep verifies a CertRep and returns its status, failInfo and, on
+// success, the issued certificates.
+func (c *testClient) certRep(t *testing.T, body []byte) (PKIStatus, FailInfo, []*x509.Certificate) {
+	p7, err := pkcs7.ParsePKCS7(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd := p7.Content.SignedData
+	if err = sd.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	si := &sd.SignerInfos[0]
+	var messageType, transactionID, status, failInfo string
+	var recipientNonce []byte
+	attrs := []struct {
+		oid asn1.ObjectIdentifier
+		out interface{}
+	}{
+		{oidMessageType, &messageType},
+		{oidTransactionID, &transactionID},
+		{oidPKIStatus, &status},
+		{oidRecipientNonce, &recipientNonce},
+	}
+	for _, attr := range attrs {
+		if err = si.UnmarshalAttribute(attr.oid, attr.out); err != nil {
+			t.Fatalf("missing attribute %s: %v", attr.oid, err)
+		}
+	}
+	if MessageType(messageType) != CertRep || transactionID != "transaction" || !bytes.Equal(recipientNonce, []byte("client nonce")) {
+		t.Fatalf("bad CertRep attributes: %s %s %q", messageType, transactionID, recipientNonce)
+	}
+
+	if PKIStatus(status) != Success {
+		if err = si.UnmarshalAttribute(oidFailInfo, &failInfo); err != nil {
+			t.Fatal(err)
+		}
+		return PKIStatus(status), FailInfo(failInfo), nil
+	}
+
+	envelope, err := pkcs7.ParsePKCS7(sd.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	degenerate, err := envelope.Content.EnvelopedData.Decrypt(c.cert, c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := pkcs7.ParsePKCS7(degenerate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Success, "", certs.Content.SignedData.Certificates
+}
+
+func TestGetCACertAndCaps(t *testing.T) {
+	rs := newTestResponder(t)
+	server := httptest.NewServer(rs)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?operation=GetCACert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/x-x509-ca-cert" || !bytes.Equal(body, rs.CA.Raw) {
+		t.Fatalf("unexpected GetCACert response %s", resp.Header.Get("Content-Type"))
+	}
+
+	resp, err = http.Get(server.URL + "?operation=GetCACaps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Contains(body, []byte("POSTPKIOperation")) || !bytes.Contains(body, []byte("SHA-256")) {
+		t.Fatalf("unexpected GetCACaps response %q", body)
+	}
+
+	resp, err = http.Get(server.URL + "?operation=Nonsense")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected %d for an unknown operation, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestPKCSReq(t *testing.T) {
+	rs := newTestResponder(t)
+	server := httptest.NewServer(rs)
+	defer server.Close()
+	client := newTestClient(t)
+
+	resp, err := http.Post(server.URL+"?operation=PKIOperation", "application/x-pki-message",
+		bytes.NewReader(client.pkcsReq(t, rs.RACert, testChallenge)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+
+	status, _, certs := client.certRep(t, body)
+	if status != Success || len(certs) != 1 {
+		t.Fatalf("expected one issued certificate, got status %s and %d certificates", status, len(certs))
+	}
+	if certs[0].Subject.CommonName != "device.example.com" {
+		t.Fatalf("unexpected subject %v", certs[0].Subject)
+	}
+	if err = certs[0].CheckSignatureFrom(rs.CA); err != nil {
+		t.Fatalf("issued certificate not signed by the CA: %v", err)
+	}
+}
+
+func TestPKCSReqGET(t *testing.T) {
+	rs := newTestResponder(t)
+	server := httptest.NewServer(rs)
+	defer server.Close()
+	client := newTestClient(t)
+
+	message := base64.StdEncoding.EncodeToString(client.pkcsReq(t, rs.RACert, testChallenge))
+	resp, err := http.Get(server.URL + "?operation=PKIOperation&message=" + url.QueryEscape(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if status, _, certs := client.certRep(t, body); status != Success || len(certs) != 1 {
+		t.Fatalf("expected one issued certificate, got status %s", status)
+	}
+}
+
+func TestPKCSReqBadChallenge(t *testing.T) {
+	rs := newTestResponder(t)
+	server := httptest.NewServer(rs)
+	defer server.Close()
+	client := newTestClient(t)
+
+	resp, err := http.Post(server.URL+"?operation=PKIOperation", "application/x-pki-message",
+		bytes.NewReader(client.pkcsReq(t, rs.RACert, "wrong")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	status, failInfo, _ := client.certRep(t, body)
+	if status != Failure || failInfo != BadRequest {
+		t.Fatalf("expected failure with badRequest, got %s/%s", status, failInfo)
+	}
+}
+
+// renew sends a RenewalReq without a challenge password and returns the
+// status of the CertRep.
+func renew(t *testing.T, server *httptest.Server, rs *Responder, client *testClient) (PKIStatus, []*x509.Certificate) {
+	resp, err := http.Post(server.URL+"?operation=PKIOperation", "application/x-pki-message",
+		bytes.NewReader(client.request(t, RenewalReq, rs.RACert, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	status, _, certs := client.certRep(t, body)
+	return status, certs
+}
+
+func TestRenewalReq(t *testing.T) {
+	rs := newTestResponder(t)
+	server := httptest.NewServer(rs)
+	defer server.Close()
+	client := newTestClient(t)
+
+	// A self-signed certificate doesn't authorize a renewal.
+	if status, _ := renew(t, server, rs, client); status != Failure {
+		t.Fatalf("expected a renewal signed with a self-signed certificate to fail, got %s", status)
+	}
+
+	status, certs := renew(t, server, rs, &testClient{key: client.key, cert: enroll(t, server, rs, client)})
+	if status != Success || len(certs) != 1 {
+		t.Fatalf("expected one renewed certificate, got status %s", status)
+	}
+	if certs[0].Subject.CommonName != "device.example.com" {
+		t.Fatalf("unexpected subject %v", certs[0].Subject)
+	}
+}
+
+func TestRenewalReqOtherSubject(t *testing.T) {
+	rs := newTestResponder(t)
+	server := httptest.NewServer(rs)
+	defer server.Close()
+	client := newTestClient(t)
+	client.cert = enroll(t, server, rs, client)
+
+	// Renew with a certificate issued by the CA for another subject.
+	other, err := rs.Signer.Sign(signer.SignRequest{
+		Request: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: newTestCSROther(t, client.key)})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.cert, err = helpers.ParseCertificatePEM(other); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := renew(t, server, rs, client); status != Failure {
+		t.Fatalf("expected a renewal for another subject to fail, got %s", status)
+	}
+}
+
+// enroll obtains a certificate issued by the CA with a PKCSReq.
+func enroll(t *testing.T, server *httptest.Server, rs *Responder, client *testClient) *x509.Certificate {
+	resp, err := http.Post(server.URL+"?operation=PKIOperation", "application/x-pki-message",
+		bytes.NewReader(client.pkcsReq(t, rs.RACert, testChallenge)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	status, _, certs := client.certRep(t, body)
+	if status != Success || len(certs) != 1 {
+		t.Fatalf("enrollment failed with status %s", status)
+	}
+	return certs[0]
+}
+
+// newTestCSROther builds a CSR for a subject other than that of
+// newTestCSR.
+func newTestCSROther(t *testing.T, key *rsa.PrivateKey) []byte {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "other.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestPKIOperationMalformed(t *testing.T) {
+	rs := newTestResponder(t)
+	server := httptest.NewServer(rs)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"?operation=PKIOperation", "application/x-pki-message",
+		bytes.NewReader([]byte("not a pkiMessage")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestPKIOperationTooLong(t *testing.T) {
+	rs := newTestResponder(t)
+	server := httptest.NewServer(rs)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"?operation=PKIOperation", "application/x-pki-message",
+		bytes.NewReader(make([]byte, maxMessageLength+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+}
+
+func TestNewResponderFromFileEmptyChallenge(t *testing.T) {
+	req := &csr.CertificateRequest{
+		CN:         "SCEP Test CA",
+		KeyRequest: &csr.BasicKeyRequest{A: "rsa", S: 2048},
+	}
+	certPEM, _, keyPEM, err := initca.New(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "scep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	caKeyFile := filepath.Join(dir, "ca-key.pem")
+	challengeFile := filepath.Join(dir, "challenge")
+	for file, data := range map[string][]byte{caFile: certPEM, caKeyFile: keyPEM, challengeFile: []byte(" \n")} {
+		if err = ioutil.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = NewResponderFromFile(nil, caFile, caKeyFile, challengeFile); err == nil {
+		t.Fatal("expected an empty challenge password to be refused")
+	}
+
+	if err = StaticChallenge(testChallenge).Validate("", nil); err == nil {
+		t.Fatal("expected a missing challenge password to be rejected")
+	}
+}
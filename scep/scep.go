@@ -0,0 +1,218 @@
+// Package scep implements a Simple Certificate Enrollment Protocol
+// (SCEP, RFC 8894) responder that issues certificates through a
+// signer.Signer.  It supports the GetCACert, GetCACaps and PKIOperation
+// operations, with PKCSReq and RenewalReq messages.  PKCSReq requests
+// are authorized by the challenge password carried in the CSR, and
+// RenewalReq requests by the certificate issued by the CA they are
+// signed with.
+package scep
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
+)
+
+// MessageType is the value of the SCEP messageType attribute.
+type MessageType string
+
+// The SCEP message types.
+const (
+	CertRep        MessageType = "3"
+	RenewalReq     MessageType = "17"
+	PKCSReq        MessageType = "19"
+	GetCertInitial MessageType = "20"
+	GetCert        MessageType = "21"
+	GetCRL         MessageType = "22"
+)
+
+// PKIStatus is the value of the SCEP pkiStatus attribute of a CertRep.
+type PKIStatus string
+
+// The statuses a CertRep may carry.
+const (
+	Success PKIStatus = "0"
+	Failure PKIStatus = "2"
+	Pending PKIStatus = "3"
+)
+
+// FailInfo is the value of the SCEP failInfo attribute, explaining why a
+// request failed.
+type FailInfo string
+
+// The failure reasons a CertRep may carry.
+const (
+	BadAlg          FailInfo = "0"
+	BadMessageCheck FailInfo = "1"
+	BadRequest      FailInfo = "2"
+	BadTime         FailInfo = "3"
+	BadCertID       FailInfo = "4"
+)
+
+var (
+	oidMessageType       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidPKIStatus         = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidFailInfo          = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSenderNonce       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidRecipientNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidTransactionID     = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+	oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+)
+
+// A PKIMessage is a verified and decrypted SCEP request.
+type PKIMessage struct {
+	MessageType   MessageType
+	TransactionID string
+	SenderNonce   []byte
+
+	// Signer is the certificate the requester signed the message
+	// with; the response is encrypted to it.
+	Signer *x509.Certificate
+
+	// CSR and ChallengePassword are set for PKCSReq and RenewalReq
+	// messages.
+	CSR               *x509.CertificateRequest
+	ChallengePassword string
+
+	// encryption is the algorithm the request was encrypted with,
+	// and that the response will be encrypted with.
+	encryption pkcs7.EncryptionAlgorithm
+}
+
+// ParsePKIMessage verifies the signature on a DER-encoded SCEP
+// pkiMessage and decrypts its content with the RA certificate and key.
+func ParsePKIMessage(der []byte, raCert *x509.Certificate, raKey crypto.Decrypter) (*PKIMessage, error) {
+	p7, err := pkcs7.ParsePKCS7(der)
+	if err != nil {
+		return nil, err
+	}
+	if p7.ContentInfo != "SignedData" {
+		return nil, fmt.Errorf("scep: pkiMessage is %s, not SignedData", p7.ContentInfo)
+	}
+
+	sd := p7.Content.SignedData
+	if len(sd.SignerInfos) != 1 {
+		return nil, errors.New("scep: pkiMessage must have exactly one signer")
+	}
+	if err = sd.Verify(); err != nil {
+		return nil, err
+	}
+
+	si := &sd.SignerInfos[0]
+	msg := new(PKIMessage)
+	if msg.Signer, err = sd.SignerCertificate(si); err != nil {
+		return nil, err
+	}
+	var messageType string
+	if err = si.UnmarshalAttribute(oidMessageType, &messageType); err != nil {
+		return nil, fmt.Errorf("scep: missing messageType: %v", err)
+	}
+	msg.MessageType = MessageType(messageType)
+	if err = si.UnmarshalAttribute(oidTransactionID, &msg.TransactionID); err != nil {
+		return nil, fmt.Errorf("scep: missing transactionID: %v", err)
+	}
+	if err = si.UnmarshalAttribute(oidSenderNonce, &msg.SenderNonce); err != nil {
+		return nil, fmt.Errorf("scep: missing senderNonce: %v", err)
+	}
+
+	if msg.MessageType != PKCSReq && msg.MessageType != RenewalReq {
+		return msg, nil
+	}
+
+	envelope, err := pkcs7.ParsePKCS7(sd.Content)
+	if err != nil {
+		return nil, err
+	}
+	if envelope.ContentInfo != "EnvelopedData" {
+		return nil, fmt.Errorf("scep: messageData is %s, not EnvelopedData", envelope.ContentInfo)
+	}
+	ed := envelope.Content.EnvelopedData
+	if msg.encryption, err = ed.EncryptionAlgorithm(); err != nil {
+		return nil, err
+	}
+	csrDER, err := ed.Decrypt(raCert, raKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.CSR, err = x509.ParseCertificateRequest(csrDER); err != nil {
+		return nil, err
+	}
+	if err = msg.CSR.CheckSignature(); err != nil {
+		return nil, err
+	}
+	if msg.ChallengePassword, err = challengePassword(msg.CSR); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// challengePassword extracts the PKCS #9 challengePassword attribute from
+// a CSR.  crypto/x509 only decodes extension requests, so the attributes
+// are read from the raw certificationRequestInfo.
+func challengePassword(csr *x509.CertificateRequest) (string, error) {
+	var info struct {
+		Version    int
+		Subject    asn1.RawValue
+		PublicKey  asn1.RawValue
+		Attributes []struct {
+			Type   asn1.ObjectIdentifier
+			Values []asn1.RawValue `asn1:"set"`
+		} `asn1:"tag:0"`
+	}
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &info); err != nil {
+		return "", err
+	}
+
+	for _, attr := range info.Attributes {
+		if !attr.Type.Equal(oidChallengePassword) || len(attr.Values) == 0 {
+			continue
+		}
+		var password string
+		if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &password); err != nil {
+			return "", err
+		}
+		return password, nil
+	}
+	return "", nil
+}
+
+// newCertRep builds the signed CertRep answering msg.  On success, the
+// issued certificate is returned as a degenerate SignedData, encrypted to
+// the requester's signing certificate.
+func newCertRep(msg *PKIMessage, raCert *x509.Certificate, raKey crypto.Signer, status PKIStatus, failInfo FailInfo, cert *x509.Certificate) ([]byte, error) {
+	senderNonce := make([]byte, 16)
+	if _, err := rand.Read(senderNonce); err != nil {
+		return nil, err
+	}
+
+	attrs := []pkcs7.Attribute{
+		{Type: oidMessageType, Value: string(CertRep)},
+		{Type: oidTransactionID, Value: msg.TransactionID},
+		{Type: oidPKIStatus, Value: string(status)},
+		{Type: oidSenderNonce, Value: senderNonce},
+		{Type: oidRecipientNonce, Value: msg.SenderNonce},
+	}
+
+	var content []byte
+	switch status {
+	case Success:
+		degenerate, err := pkcs7.DegenerateCertificates([]*x509.Certificate{cert})
+		if err != nil {
+			return nil, err
+		}
+		content, err = pkcs7.Encrypt(degenerate, []*x509.Certificate{msg.Signer}, msg.encryption)
+		if err != nil {
+			return nil, err
+		}
+	case Failure:
+		attrs = append(attrs, pkcs7.Attribute{Type: oidFailInfo, Value: string(failInfo)})
+	}
+
+	return pkcs7.SignData(content, raCert, raKey, attrs...)
+}
@@ -4,7 +4,9 @@ package local
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -16,18 +18,20 @@ import (
 	"net"
 	"net/http"
 	"net/mail"
-	"os"
+	"sync"
 
 	"github.com/cloudflare/cfssl/certdb"
 	"github.com/cloudflare/cfssl/config"
 	cferr "github.com/cloudflare/cfssl/errors"
 	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/info"
+	"github.com/cloudflare/cfssl/lint"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/signer"
 	"github.com/google/certificate-transparency-go"
 	"github.com/google/certificate-transparency-go/client"
 	"github.com/google/certificate-transparency-go/jsonclient"
+	"golang.org/x/crypto/ed25519"
 	"golang.org/x/net/context"
 )
 
@@ -39,6 +43,9 @@ type Signer struct {
 	policy     *config.Signing
 	sigAlgo    x509.SignatureAlgorithm
 	dbAccessor certdb.Accessor
+
+	lintLock sync.Mutex
+	lintPriv crypto.Signer
 }
 
 // NewSigner creates a new Signer directly from a
@@ -81,13 +88,7 @@ func NewSignerFromFile(caFile, caKeyFile string, policy *config.Signing) (*Signe
 		return nil, err
 	}
 
-	strPassword := os.Getenv("CFSSL_CA_PK_PASSWORD")
-	password := []byte(strPassword)
-	if strPassword == "" {
-		password = nil
-	}
-
-	priv, err := helpers.ParsePrivateKeyPEMWithPassword(cakey, password)
+	priv, err := helpers.ParsePrivateKeyPEMFromEnv(cakey)
 	if err != nil {
 		log.Debug("Malformed private key %v", err)
 		return nil, err
@@ -125,6 +126,67 @@ func (s *Signer) sign(template *x509.Certificate) (cert []byte, err error) {
 	return
 }
 
+// lintKey returns a key of the same type as the CA key, generated on
+// first use. It signs certificates that are only linted, so that lint
+// checks do not use the CA key.
+func (s *Signer) lintKey() (crypto.Signer, error) {
+	s.lintLock.Lock()
+	defer s.lintLock.Unlock()
+	if s.lintPriv != nil {
+		return s.lintPriv, nil
+	}
+
+	var err error
+	switch pub := s.priv.Public().(type) {
+	case *rsa.PublicKey:
+		s.lintPriv, err = rsa.GenerateKey(rand.Reader, pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		s.lintPriv, err = ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	case ed25519.PublicKey:
+		_, s.lintPriv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = errors.New("unsupported CA key type")
+	}
+	return s.lintPriv, err
+}
+
+// lint checks the certificate template would produce against the
+// lint rules, as the profile directs. The findings are logged and, if
+// the profile blocks on lint errors, any errors are returned.
+func (s *Signer) lint(template x509.Certificate, profile *config.SigningProfile) error {
+	if profile.Lint == "" {
+		return nil
+	}
+
+	key, err := s.lintKey()
+	if err != nil {
+		return cferr.Wrap(cferr.PrivateKeyError, cferr.GenerationFailed, err)
+	}
+	parent := template
+	if s.ca != nil {
+		parent = *s.ca
+	}
+	parent.PublicKey = key.Public()
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &parent, template.PublicKey, key)
+	if err != nil {
+		return cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+	}
+
+	findings := lint.Run(cert, profile.IgnoredLints)
+	for _, f := range findings {
+		log.Warningf("lint %s: %s", f.Level, f)
+	}
+	if errs := findings.Errors(); profile.Lint == config.LintBlock && len(errs) > 0 {
+		return cferr.Wrap(cferr.PolicyError, cferr.LintFailed, errors.New(errs.String()))
+	}
+	return nil
+}
+
 // replaceSliceIfEmpty replaces the contents of replaced with newContents if
 // the slice referenced by replaced is empty
 func replaceSliceIfEmpty(replaced, newContents *[]string) {
@@ -335,6 +397,10 @@ func (s *Signer) Sign(req signer.SignRequest) (cert []byte, err error) {
 		safeTemplate.CRLDistributionPoints = distPoints
 	}
 
+	if err = s.lint(safeTemplate, profile); err != nil {
+		return nil, err
+	}
+
 	var certTBS = safeTemplate
 
 	if len(profile.CTLogServers) > 0 || req.ReturnPrecert {
@@ -14,6 +14,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"regexp"
 	"sort"
@@ -1421,3 +1422,84 @@ func TestSignFromPrecert(t *testing.T) {
 		t.Fatal("SignFromPrecert didn't fail with signature not from CA")
 	}
 }
+
+func TestNewSignerFromFileEncryptedKey(t *testing.T) {
+	keyPEM, err := ioutil.ReadFile(testCaKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encPEM, err := helpers.EncryptPrivateKeyPEM(keyPEM, []byte("password"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "cfssl-ca-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(encPEM)
+	f.Close()
+
+	defer os.Unsetenv("CFSSL_CA_PK_PASSWORD")
+	os.Unsetenv("CFSSL_CA_PK_PASSWORD")
+	if _, err = NewSignerFromFile(testCaFile, f.Name(), nil); err == nil {
+		t.Fatal("expected an encrypted key without a password to be rejected")
+	}
+
+	os.Setenv("CFSSL_CA_PK_PASSWORD", "password")
+	s, err := NewSignerFromFile(testCaFile, f.Name(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM, err := ioutil.ReadFile(testCSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Sign(signer.SignRequest{Request: string(csrPEM)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLintSign(t *testing.T) {
+	csrPEM, err := ioutil.ReadFile(testCSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newCustomSigner(t, testECDSACaFile, testECDSACaKeyFile)
+	profile := &config.SigningProfile{
+		// Key encipherment is not allowed for the ECDSA key in the CSR.
+		Usage:        []string{"signing", "key encipherment", "server auth"},
+		ExpiryString: "2160h",
+		Expiry:       2160 * time.Hour,
+		Lint:         config.LintBlock,
+	}
+	s.policy = &config.Signing{Default: profile}
+
+	request := signer.SignRequest{
+		Hosts:   []string{"www.cloudflare.com"},
+		Request: string(csrPEM),
+		Subject: &signer.Subject{CN: "www.cloudflare.com"},
+	}
+
+	_, err = s.Sign(request)
+	if err == nil {
+		t.Fatal("expected lint errors to block signing")
+	}
+	cfErr, ok := err.(*cferr.Error)
+	if !ok || cfErr.ErrorCode != int(cferr.PolicyError)+int(cferr.LintFailed) ||
+		!strings.Contains(cfErr.Message, "e_ecdsa_key_encipherment") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	profile.Lint = config.LintWarn
+	if _, err = s.Sign(request); err != nil {
+		t.Fatalf("lint warnings should not block signing: %v", err)
+	}
+
+	profile.Lint = config.LintBlock
+	profile.IgnoredLints = []string{"e_ecdsa_key_encipherment"}
+	if _, err = s.Sign(request); err != nil {
+		t.Fatalf("ignored lint blocked signing: %v", err)
+	}
+}
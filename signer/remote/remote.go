@@ -1,10 +1,14 @@
 package remote
 
 import (
+	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/cloudflare/cfssl/api/client"
 	"github.com/cloudflare/cfssl/certdb"
@@ -20,6 +24,26 @@ import (
 type Signer struct {
 	policy      *config.Signing
 	reqModifier func(*http.Request, []byte)
+
+	// remotes holds the remote of each profile, so that the health
+	// of its servers is tracked across requests; stopChecks ends
+	// their health checks.
+	lock       sync.Mutex
+	remotes    map[remoteKey]client.Remote
+	checkCtx   context.Context
+	stopChecks context.CancelFunc
+}
+
+// remoteKey identifies the remote of a profile; the profile's remote
+// server and TLS settings may be overridden after the remote is
+// created.
+type remoteKey struct {
+	profile  *config.SigningProfile
+	server   string
+	strategy string
+	health   time.Duration
+	cas      *x509.CertPool
+	cert     *tls.Certificate
 }
 
 // NewSigner creates a new remote Signer directly from a
@@ -77,14 +101,12 @@ func (s *Signer) remoteOp(req interface{}, profile, target string) (resp interfa
 		return
 	}
 
-	server := client.NewServerTLS(p.RemoteServer, helpers.CreateTLSConfig(p.RemoteCAs, p.ClientCert))
-	if server == nil {
+	server, err := s.remote(p)
+	if err != nil {
 		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest,
 			errors.New("failed to connect to remote"))
 	}
 
-	server.SetReqModifier(s.reqModifier)
-
 	// There's no auth provider for the "info" method
 	if target == "info" {
 		resp, err = server.Info(jsonData)
@@ -101,6 +123,38 @@ func (s *Signer) remoteOp(req interface{}, profile, target string) (resp interfa
 	return
 }
 
+// remote returns the remote of the profile, creating it the first time
+// it's needed.
+func (s *Signer) remote(p *config.SigningProfile) (client.Remote, error) {
+	key := remoteKey{
+		profile:  p,
+		server:   p.RemoteServer,
+		strategy: p.RemoteStrategy,
+		health:   p.RemoteHealthInterval,
+		cas:      p.RemoteCAs,
+		cert:     p.ClientCert,
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if server, ok := s.remotes[key]; ok {
+		return server, nil
+	}
+	if s.remotes == nil {
+		s.remotes = map[remoteKey]client.Remote{}
+		s.checkCtx, s.stopChecks = context.WithCancel(context.Background())
+	}
+
+	server, err := client.NewServerTLSWithStrategy(p.RemoteServer, helpers.CreateTLSConfig(p.RemoteCAs, p.ClientCert), p.RemoteStrategy,
+		client.SignerGroupOptions(s.checkCtx, p.RemoteHealthInterval)...)
+	if err != nil {
+		return nil, err
+	}
+	server.SetReqModifier(s.reqModifier)
+	s.remotes[key] = server
+	return server, nil
+}
+
 // SigAlgo returns the RSA signer's signature algorithm.
 func (s *Signer) SigAlgo() x509.SignatureAlgorithm {
 	// TODO: implement this as a remote info call
@@ -110,6 +164,13 @@ func (s *Signer) SigAlgo() x509.SignatureAlgorithm {
 // SetPolicy sets the signer's signature policy.
 func (s *Signer) SetPolicy(policy *config.Signing) {
 	s.policy = policy
+
+	s.lock.Lock()
+	if s.stopChecks != nil {
+		s.stopChecks()
+	}
+	s.remotes = nil
+	s.lock.Unlock()
 }
 
 // SetDBAccessor sets the signers' cert db accessor, currently noop.
@@ -124,7 +185,10 @@ func (s *Signer) GetDBAccessor() certdb.Accessor {
 
 // SetReqModifier sets the function to call to modify the HTTP request prior to sending it
 func (s *Signer) SetReqModifier(mod func(*http.Request, []byte)) {
+	s.lock.Lock()
 	s.reqModifier = mod
+	s.remotes = nil
+	s.lock.Unlock()
 }
 
 // Policy returns the signer's policy.
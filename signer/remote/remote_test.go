@@ -9,9 +9,11 @@ import (
 	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/cloudflare/cfssl/api/client"
 	apiinfo "github.com/cloudflare/cfssl/api/info"
 	apisign "github.com/cloudflare/cfssl/api/signhandler"
 	"github.com/cloudflare/cfssl/config"
@@ -249,6 +251,79 @@ func TestRemoteSignBadServerAndOverride(t *testing.T) {
 
 }
 
+func TestRemoteSignGroupFailover(t *testing.T) {
+	remoteServer := newTestSignServer(t, false, nil)
+	defer closeTestServer(t, remoteServer)
+
+	var failures int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failures, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	remoteConfig := testsuite.NewConfig(t, []byte(validMinimalRemoteConfig))
+	remoteConfig.Signing.OverrideRemotes(failing.URL + "," + remoteServer.URL)
+	remoteConfig.Signing.Default.RemoteStrategy = "ordered_list"
+	s := newRemoteSigner(t, remoteConfig.Signing)
+
+	csr, err := ioutil.ReadFile("../local/testdata/rsa2048.csr")
+	if err != nil {
+		t.Fatal("CSR loading error:", err)
+	}
+	const requests = client.DefaultFailureThreshold + 10
+	for i := 0; i < requests; i++ {
+		_, err = s.Sign(signer.SignRequest{Hosts: []string{"cloudflare.com"}, Request: string(csr), Serial: big.NewInt(1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Requests were tried on the failing server first until its
+	// circuit opened; its jittered backoff may let one more through.
+	if n := atomic.LoadInt32(&failures); n < client.DefaultFailureThreshold || n >= requests {
+		t.Fatalf("failing server received %d of %d requests, expected its circuit to open after %d",
+			n, requests, client.DefaultFailureThreshold)
+	}
+}
+
+func TestRemoteSignGroupHealthCheck(t *testing.T) {
+	remoteServer := newTestSignServer(t, false, nil)
+	defer closeTestServer(t, remoteServer)
+
+	var checks int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/cfssl/info" {
+			atomic.AddInt32(&checks, 1)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	remoteConfig := testsuite.NewConfig(t, []byte(validMinimalRemoteConfig))
+	remoteConfig.Signing.OverrideRemotes(failing.URL + "," + remoteServer.URL)
+	remoteConfig.Signing.Default.RemoteHealthInterval = 10 * time.Millisecond
+	s := newRemoteSigner(t, remoteConfig.Signing)
+	defer s.SetPolicy(remoteConfig.Signing)
+
+	csr, err := ioutil.ReadFile("../local/testdata/rsa2048.csr")
+	if err != nil {
+		t.Fatal("CSR loading error:", err)
+	}
+	_, err = s.Sign(signer.SignRequest{Hosts: []string{"cloudflare.com"}, Request: string(csr), Serial: big.NewInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&checks) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("failing server was never health checked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 // helper functions
 func newRemoteSigner(t *testing.T, policy *config.Signing) *Signer {
 	s, err := NewSigner(policy)
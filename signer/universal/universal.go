@@ -8,7 +8,9 @@ import (
 	"github.com/cloudflare/cfssl/certdb"
 	"github.com/cloudflare/cfssl/config"
 	cferr "github.com/cloudflare/cfssl/errors"
+	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/info"
+	"github.com/cloudflare/cfssl/keysource"
 	"github.com/cloudflare/cfssl/signer"
 	"github.com/cloudflare/cfssl/signer/local"
 	"github.com/cloudflare/cfssl/signer/remote"
@@ -46,7 +48,38 @@ func fileBackedSigner(root *Root, policy *config.Signing) (signer.Signer, bool,
 	return signer, true, err
 }
 
+// keySourceSigner determines whether the key file names a key source,
+// such as a KMS-wrapped key, that must be unwrapped before use. The
+// rest of the Config is passed to the key source as its settings.
+func keySourceSigner(root *Root, policy *config.Signing) (signer.Signer, bool, error) {
+	keyFile := root.Config["key-file"]
+	certFile := root.Config["cert-file"]
+
+	if !keysource.IsSpec(keyFile) {
+		return nil, false, nil
+	}
+
+	priv, err := keysource.Load(keyFile, root.Config)
+	if err != nil {
+		return nil, true, err
+	}
+
+	certPEM, err := helpers.ReadBytes(certFile)
+	if err != nil {
+		return nil, true, err
+	}
+
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, true, err
+	}
+
+	signer, err := local.NewSigner(priv, cert, signer.DefaultSigAlgo(priv), policy)
+	return signer, true, err
+}
+
 var localSignerList = []localSignerCheck{
+	keySourceSigner,
 	fileBackedSigner,
 }
 
@@ -6,6 +6,8 @@ import (
 	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -16,6 +18,7 @@ import (
 	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/helpers/testsuite"
 	"github.com/cloudflare/cfssl/info"
+	"github.com/cloudflare/cfssl/keysource"
 	"github.com/cloudflare/cfssl/signer"
 )
 
@@ -287,6 +290,56 @@ func TestNewSigner(t *testing.T) {
 	}
 }
 
+func TestNewSignerKeySource(t *testing.T) {
+	keyPEM, err := ioutil.ReadFile(testCaKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapPriv, wrapPub, err := keysource.GenerateWrappingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapper, err := keysource.NewWrapFile(wrapPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := wrapper.Wrap(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "universal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wrappedFile := filepath.Join(dir, "ca-key.wrapped")
+	wrapKeyFile := filepath.Join(dir, "wrap.pem")
+	if err = ioutil.WriteFile(wrappedFile, wrapped, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(wrapKeyFile, wrapPriv, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Root{
+		Config: map[string]string{
+			"key-file":  "wrapfile://" + wrappedFile,
+			"cert-file": testCaFile,
+			"wrap_key":  wrapKeyFile,
+		},
+	}
+	if _, err = NewSigner(r, validLocalConfig.Signing); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Config["wrap_key"] = testCaKeyFile
+	if _, err = NewSigner(r, validLocalConfig.Signing); err == nil {
+		t.Fatal("expected a bad wrapping key to fail")
+	}
+}
+
 func checkInfo(t *testing.T, s signer.Signer, name string, profile *config.SigningProfile) {
 	req := info.Req{
 		Profile: name,
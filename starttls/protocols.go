@@ -0,0 +1,321 @@
+package starttls
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// LocalName is the name the client gives in the SMTP EHLO command.
+var LocalName = "localhost"
+
+func init() {
+	Register(&Protocol{Name: "smtp", Port: "25", Client: smtpClient, Server: smtpServer})
+	Register(&Protocol{Name: "imap", Port: "143", Client: imapClient, Server: imapServer})
+	Register(&Protocol{Name: "pop3", Port: "110", Client: pop3Client, Server: pop3Server})
+	Register(&Protocol{Name: "ldap", Port: "389", Client: ldapClient, Server: ldapServer})
+	Register(&Protocol{Name: "postgres", Port: "5432", Client: postgresClient, Server: postgresServer})
+}
+
+// The text protocols are read a line at a time. Nothing is sent by
+// the peer after the final reply until the TLS handshake starts, so
+// no TLS bytes are left behind in a textproto buffer.
+
+// smtpClient implements RFC 3207.
+func smtpClient(conn net.Conn, hostname string) error {
+	tp := textproto.NewConn(conn)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return err
+	}
+	if err := tp.PrintfLine("EHLO %s", LocalName); err != nil {
+		return err
+	}
+	_, msg, err := tp.ReadResponse(250)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(strings.ToUpper(msg), "STARTTLS") {
+		return errors.New("server does not offer STARTTLS")
+	}
+	if err = tp.PrintfLine("STARTTLS"); err != nil {
+		return err
+	}
+	_, _, err = tp.ReadResponse(220)
+	return err
+}
+
+func smtpServer(conn net.Conn) error {
+	tp := textproto.NewConn(conn)
+	if err := tp.PrintfLine("220 %s ESMTP ready", LocalName); err != nil {
+		return err
+	}
+	line, err := tp.ReadLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(strings.ToUpper(line), "EHLO ") {
+		tp.PrintfLine("500 expected EHLO")
+		return fmt.Errorf("unexpected command %q", line)
+	}
+	if err = tp.PrintfLine("250-%s\r\n250 STARTTLS", LocalName); err != nil {
+		return err
+	}
+	if line, err = tp.ReadLine(); err != nil {
+		return err
+	}
+	if strings.ToUpper(line) != "STARTTLS" {
+		tp.PrintfLine("500 expected STARTTLS")
+		return fmt.Errorf("unexpected command %q", line)
+	}
+	return tp.PrintfLine("220 Ready to start TLS")
+}
+
+const imapTag = "a001"
+
+// imapClient implements RFC 3501, section 6.2.1.
+func imapClient(conn net.Conn, hostname string) error {
+	tp := textproto.NewConn(conn)
+	greeting, err := tp.ReadLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("unexpected greeting %q", greeting)
+	}
+	if err = tp.PrintfLine("%s STARTTLS", imapTag); err != nil {
+		return err
+	}
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return err
+		}
+		// Skip untagged responses.
+		if !strings.HasPrefix(line, imapTag+" ") {
+			continue
+		}
+		if !strings.HasPrefix(line, imapTag+" OK") {
+			return fmt.Errorf("STARTTLS refused: %q", line)
+		}
+		return nil
+	}
+}
+
+func imapServer(conn net.Conn) error {
+	tp := textproto.NewConn(conn)
+	if err := tp.PrintfLine("* OK [CAPABILITY IMAP4rev1 STARTTLS] ready"); err != nil {
+		return err
+	}
+	line, err := tp.ReadLine()
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 || strings.ToUpper(fields[1]) != "STARTTLS" {
+		tp.PrintfLine("* BAD expected STARTTLS")
+		return fmt.Errorf("unexpected command %q", line)
+	}
+	return tp.PrintfLine("%s OK Begin TLS negotiation now", fields[0])
+}
+
+// pop3Client implements RFC 2595, section 4.
+func pop3Client(conn net.Conn, hostname string) error {
+	tp := textproto.NewConn(conn)
+	greeting, err := tp.ReadLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "+OK") {
+		return fmt.Errorf("unexpected greeting %q", greeting)
+	}
+	if err = tp.PrintfLine("STLS"); err != nil {
+		return err
+	}
+	line, err := tp.ReadLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("STLS refused: %q", line)
+	}
+	return nil
+}
+
+func pop3Server(conn net.Conn) error {
+	tp := textproto.NewConn(conn)
+	if err := tp.PrintfLine("+OK POP3 ready"); err != nil {
+		return err
+	}
+	line, err := tp.ReadLine()
+	if err != nil {
+		return err
+	}
+	if strings.ToUpper(line) != "STLS" {
+		tp.PrintfLine("-ERR expected STLS")
+		return fmt.Errorf("unexpected command %q", line)
+	}
+	return tp.PrintfLine("+OK Begin TLS negotiation")
+}
+
+// ldapStartTLSOID names the LDAP StartTLS extended operation.
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// LDAP protocol operations are tagged in the application class.
+const (
+	ldapExtendedRequest  asn1.Tag = 0x40 | 0x20 | 23
+	ldapExtendedResponse asn1.Tag = 0x40 | 0x20 | 24
+	ldapRequestName      asn1.Tag = 0x80
+)
+
+// readBER reads a single BER element with a definite length.
+func readBER(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	length := int(hdr[1])
+	if hdr[1]&0x80 != 0 {
+		n := int(hdr[1] & 0x7f)
+		if n == 0 || n > 3 {
+			return nil, errors.New("unsupported BER length")
+		}
+		lengthBytes := make([]byte, n)
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return nil, err
+		}
+		hdr = append(hdr, lengthBytes...)
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return append(hdr, body...), nil
+}
+
+func ldapMessage(op asn1.Tag, f func(b *cryptobyte.Builder)) ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1Int64(1) // messageID
+		b.AddASN1(op, f)
+	})
+	return b.Bytes()
+}
+
+// parseLDAPMessage returns the protocol operation of an LDAP message.
+func parseLDAPMessage(msg []byte, op asn1.Tag) (cryptobyte.String, error) {
+	var seq, body cryptobyte.String
+	var id int64
+	s := cryptobyte.String(msg)
+	if !s.ReadASN1(&seq, asn1.SEQUENCE) || !seq.ReadASN1Integer(&id) || !seq.ReadASN1(&body, op) {
+		return nil, errors.New("malformed LDAP message")
+	}
+	return body, nil
+}
+
+// ldapClient implements RFC 4511, section 4.14.
+func ldapClient(conn net.Conn, hostname string) error {
+	req, err := ldapMessage(ldapExtendedRequest, func(b *cryptobyte.Builder) {
+		b.AddASN1(ldapRequestName, func(b *cryptobyte.Builder) {
+			b.AddBytes([]byte(ldapStartTLSOID))
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+
+	resp, err := readBER(conn)
+	if err != nil {
+		return err
+	}
+	body, err := parseLDAPMessage(resp, ldapExtendedResponse)
+	if err != nil {
+		return err
+	}
+	var resultCode int
+	if !body.ReadASN1Enum(&resultCode) {
+		return errors.New("malformed LDAP extended response")
+	}
+	if resultCode != 0 {
+		return fmt.Errorf("StartTLS refused with result code %d", resultCode)
+	}
+	return nil
+}
+
+func ldapServer(conn net.Conn) error {
+	req, err := readBER(conn)
+	if err != nil {
+		return err
+	}
+	body, err := parseLDAPMessage(req, ldapExtendedRequest)
+	if err != nil {
+		return err
+	}
+	var name []byte
+	if !body.ReadASN1Bytes(&name, ldapRequestName) || string(name) != ldapStartTLSOID {
+		return errors.New("expected a StartTLS request")
+	}
+
+	resp, err := ldapMessage(ldapExtendedResponse, func(b *cryptobyte.Builder) {
+		b.AddASN1Enum(0)          // success
+		b.AddASN1OctetString(nil) // matchedDN
+		b.AddASN1OctetString(nil) // diagnosticMessage
+	})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(resp)
+	return err
+}
+
+// postgresSSLRequest is the PostgreSQL SSLRequest message: its length
+// and the request code 80877103.
+var postgresSSLRequest = []byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}
+
+// postgresClient sends an SSLRequest, as described in the PostgreSQL
+// frontend/backend protocol.
+func postgresClient(conn net.Conn, hostname string) error {
+	if _, err := conn.Write(postgresSSLRequest); err != nil {
+		return err
+	}
+	resp := make([]byte, 1)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	switch resp[0] {
+	case 'S':
+		return nil
+	case 'N':
+		return errors.New("server does not support SSL")
+	default:
+		return fmt.Errorf("unexpected SSLRequest response %q", resp[0])
+	}
+}
+
+func postgresServer(conn net.Conn) error {
+	req := make([]byte, len(postgresSSLRequest))
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return err
+	}
+	if !bytes.Equal(req, postgresSSLRequest) {
+		conn.Write([]byte{'N'})
+		return fmt.Errorf("unexpected startup message %x", req)
+	}
+	_, err := conn.Write([]byte{'S'})
+	return err
+}
@@ -0,0 +1,145 @@
+// Package starttls negotiates TLS on connections to servers that start
+// out speaking a plaintext protocol, such as mail relays and databases.
+//
+// Hosts using such a protocol are named with the protocol as a URL
+// scheme, as in smtp://mail.example.com or postgres://db.example.com:5433.
+// Split parses these names, and Dial connects to them and returns a
+// connection that is ready for a TLS client handshake.
+package starttls
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Protocol describes how to switch a connection to TLS.
+type Protocol struct {
+	// Name is the protocol name, used as a URL scheme.
+	Name string
+	// Port is the port used when a host does not give one.
+	Port string
+	// Client speaks the protocol on a newly dialed connection
+	// until the server is ready for a TLS handshake.
+	Client func(conn net.Conn, hostname string) error
+	// Server is the server side of Client, for fake servers used
+	// in tests. It returns once the client is expected to start a
+	// TLS handshake.
+	Server func(conn net.Conn) error
+}
+
+var (
+	lock      sync.RWMutex
+	protocols = map[string]*Protocol{}
+)
+
+// Register makes a protocol available under its name.
+func Register(p *Protocol) {
+	lock.Lock()
+	defer lock.Unlock()
+	protocols[p.Name] = p
+}
+
+// Lookup returns the protocol registered as name.
+func Lookup(name string) (*Protocol, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	p, ok := protocols[name]
+	return p, ok
+}
+
+// Names returns the names of the registered protocols.
+func Names() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	var names []string
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Timeout bounds the plaintext negotiation on a connection.
+var Timeout = 10 * time.Second
+
+// Split separates a host of the form [protocol://]host[:port] into the
+// protocol name and host. If a protocol is given but the host has no
+// port, the protocol's default port is added. A host without a
+// protocol is returned unchanged, with an empty protocol name.
+func Split(host string) (name, hostport string, err error) {
+	i := strings.Index(host, "://")
+	if i < 0 {
+		return "", host, nil
+	}
+
+	name, hostport = strings.ToLower(host[:i]), strings.TrimSuffix(host[i+3:], "/")
+	p, ok := Lookup(name)
+	if !ok {
+		return "", "", fmt.Errorf("unknown STARTTLS protocol %q; known protocols are %s", name, strings.Join(Names(), ", "))
+	}
+	if hostport == "" {
+		return "", "", errors.New("no host given")
+	}
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		hostport = net.JoinHostPort(strings.Trim(hostport, "[]"), p.Port)
+	}
+	return name, hostport, nil
+}
+
+// Join returns host with name as its protocol scheme, unless name is
+// empty or host already has a scheme.
+func Join(name, host string) string {
+	if name == "" || strings.Contains(host, "://") {
+		return host
+	}
+	return name + "://" + host
+}
+
+// Negotiate runs the client side of the named protocol on conn. It
+// does nothing if name is empty.
+func Negotiate(conn net.Conn, name, hostname string) error {
+	if name == "" {
+		return nil
+	}
+	p, ok := Lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown STARTTLS protocol %q", name)
+	}
+
+	if Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(Timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	if err := p.Client(conn, hostname); err != nil {
+		return fmt.Errorf("%s STARTTLS negotiation failed: %v", name, err)
+	}
+	return nil
+}
+
+// Dial connects to addr and negotiates the named protocol, returning a
+// connection that is ready for a TLS client handshake.
+func Dial(dialer *net.Dialer, network, addr, name, hostname string) (net.Conn, error) {
+	conn, err := dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = Negotiate(conn, name, hostname); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Serve runs the server side of the named protocol on conn.
+func Serve(conn net.Conn, name string) error {
+	p, ok := Lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown STARTTLS protocol %q", name)
+	}
+	return p.Server(conn)
+}
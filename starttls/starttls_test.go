@@ -0,0 +1,141 @@
+package starttls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/starttls"
+	"github.com/cloudflare/cfssl/starttls/starttlstest"
+)
+
+func testKeyPair(t *testing.T) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mail.example.com"},
+		DNSNames:     []string{"mail.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		host, name, hostport string
+	}{
+		{"example.com:443", "", "example.com:443"},
+		{"smtp://mail.example.com", "smtp", "mail.example.com:25"},
+		{"SMTP://mail.example.com:587", "smtp", "mail.example.com:587"},
+		{"imap://[2001:db8::1]", "imap", "[2001:db8::1]:143"},
+		{"pop3://mail.example.com/", "pop3", "mail.example.com:110"},
+		{"ldap://ldap.example.com", "ldap", "ldap.example.com:389"},
+		{"postgres://db.example.com", "postgres", "db.example.com:5432"},
+	}
+	for _, test := range tests {
+		name, hostport, err := starttls.Split(test.host)
+		if err != nil {
+			t.Fatalf("%s: %v", test.host, err)
+		}
+		if name != test.name || hostport != test.hostport {
+			t.Fatalf("%s: got %q %q", test.host, name, hostport)
+		}
+	}
+
+	for _, host := range []string{"gopher://example.com", "smtp://"} {
+		if _, _, err := starttls.Split(host); err == nil {
+			t.Fatalf("expected %s to be rejected", host)
+		}
+	}
+
+	if starttls.Join("smtp", "mail.example.com") != "smtp://mail.example.com" ||
+		starttls.Join("smtp", "imap://mail.example.com") != "imap://mail.example.com" ||
+		starttls.Join("", "example.com") != "example.com" {
+		t.Fatal("unexpected Join result")
+	}
+}
+
+func TestDial(t *testing.T) {
+	certPEM, keyPEM := testKeyPair(t)
+	dialer := &net.Dialer{Timeout: time.Second}
+
+	for _, name := range starttls.Names() {
+		server, err := starttlstest.NewServer(name, certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		proto, addr, err := starttls.Split(server.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := starttls.Dial(dialer, "tcp", addr, proto, "mail.example.com")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: "mail.example.com", InsecureSkipVerify: true})
+		if err = tlsConn.Handshake(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cn := tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "mail.example.com" {
+			t.Fatalf("%s: unexpected certificate %s", name, cn)
+		}
+		tlsConn.Close()
+		server.Close()
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	// An SMTP server that does not offer STARTTLS.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 mail.example.com ESMTP")
+		tp.ReadLine()
+		tp.PrintfLine("250-mail.example.com\r\n250 8BITMIME")
+		tp.ReadLine()
+	}()
+
+	_, err = starttls.Dial(&net.Dialer{Timeout: time.Second}, "tcp", l.Addr().String(), "smtp", "mail.example.com")
+	if err == nil {
+		t.Fatal("expected a server without STARTTLS to be rejected")
+	}
+
+	// Without a protocol, Dial only connects.
+	conn, err := starttls.Dial(&net.Dialer{Timeout: time.Second}, "tcp", l.Addr().String(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
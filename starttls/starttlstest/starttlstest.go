@@ -0,0 +1,110 @@
+// Package starttlstest provides fake servers that negotiate TLS with
+// STARTTLS, for testing clients of the starttls package.
+package starttlstest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/cloudflare/cfssl/starttls"
+)
+
+// A Server is a local server that speaks a STARTTLS protocol and then
+// completes a TLS handshake with a fixed certificate. After the
+// handshake it discards whatever the client sends.
+type Server struct {
+	// Protocol is the name of the protocol the server speaks.
+	Protocol string
+	// Addr is the host:port the server listens on.
+	Addr string
+
+	listener net.Listener
+	config   *tls.Config
+	wg       sync.WaitGroup
+	lock     sync.Mutex
+	conns    map[net.Conn]bool
+}
+
+// NewServer starts a server speaking the named protocol and serving
+// the PEM-encoded certificate chain and key.
+func NewServer(protocol string, certPEM, keyPEM []byte) (*Server, error) {
+	if _, ok := starttls.Lookup(protocol); !ok {
+		return nil, fmt.Errorf("unknown STARTTLS protocol %q", protocol)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Protocol: protocol,
+		Addr:     l.Addr().String(),
+		listener: l,
+		config:   &tls.Config{Certificates: []tls.Certificate{cert}},
+		conns:    map[net.Conn]bool{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// URL returns the server's address with its protocol as the scheme.
+func (s *Server) URL() string {
+	return starttls.Join(s.Protocol, s.Addr)
+}
+
+// Close stops the server and closes any open connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.lock.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.conns[conn] = true
+		s.lock.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+	}()
+
+	if err := starttls.Serve(conn, s.Protocol); err != nil {
+		return
+	}
+	tlsConn := tls.Server(conn, s.config)
+	if err := tlsConn.Handshake(); err != nil {
+		return
+	}
+	io.Copy(ioutil.Discard, tlsConn)
+}
@@ -0,0 +1,717 @@
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/transport/core"
+)
+
+// This file contains an ACME (RFC 8555) client.
+
+const (
+	// DefaultACMEPollInterval is how long the ACME client waits
+	// between polls of a pending authorization or order, when the
+	// server does not say.
+	DefaultACMEPollInterval = time.Second
+
+	// DefaultACMETimeout bounds the issuance of a certificate.
+	DefaultACMETimeout = 5 * time.Minute
+)
+
+// maxACMEResponse bounds the size of an ACME response.
+const maxACMEResponse = 1 << 20
+
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	Status         string           `json:"status"`
+	Identifiers    []acmeIdentifier `json:"identifiers"`
+	Authorizations []string         `json:"authorizations"`
+	Finalize       string           `json:"finalize"`
+	Certificate    string           `json:"certificate,omitempty"`
+}
+
+type acmeChallenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+type acmeAuthorization struct {
+	Status     string          `json:"status"`
+	Identifier acmeIdentifier  `json:"identifier"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+// acmeProblem is an RFC 7807 problem document.
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (p *acmeProblem) Error() string {
+	return fmt.Sprintf("transport: ACME error %s: %s", p.Type, p.Detail)
+}
+
+// ACME obtains certificates from an ACME (RFC 8555) server. It
+// registers an account on first use, and proves control of the
+// identifiers in each CSR by answering http-01 challenges; identifiers
+// the server has already authorized, as internal ACME servers may, need
+// no challenge. It is safe for concurrent use, though issuances are
+// serialised.
+type ACME struct {
+	// DirectoryURL is the URL of the server's directory.
+	DirectoryURL string
+
+	// Contact lists the contact URLs of the account, such as
+	// "mailto:admin@example.com".
+	Contact []string
+
+	// HTTP01Addr is the address on which http-01 challenges are
+	// answered while a certificate is being issued. The server
+	// validates them on port 80, so it is usually ":80".
+	HTTP01Addr string
+
+	// EABKeyID and EABKey bind the account to an account known to
+	// the server by other means, if the server requires it.
+	EABKeyID string
+	EABKey   []byte
+
+	// CACertificatePEM is the certificate returned by CACertificate.
+	// If empty, the issuers of the last certificate issued are
+	// returned.
+	CACertificatePEM []byte
+
+	// Client makes the requests.
+	Client *http.Client
+
+	// PollInterval and Timeout default to DefaultACMEPollInterval
+	// and DefaultACMETimeout.
+	PollInterval time.Duration
+	Timeout      time.Duration
+
+	key crypto.Signer
+
+	lock    sync.Mutex
+	dir     *acmeDirectory
+	kid     string
+	nonces  []string
+	issuers []byte
+}
+
+// NewACME returns an ACME client for the server with the directory at
+// dirURL, with the account key key.
+func NewACME(dirURL string, key crypto.Signer) (*ACME, error) {
+	switch pub := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, errors.New("transport: ACME account keys must be P-256 or RSA")
+		}
+	case *rsa.PublicKey:
+	default:
+		return nil, errors.New("transport: ACME account keys must be P-256 or RSA")
+	}
+	return &ACME{
+		DirectoryURL: dirURL,
+		HTTP01Addr:   ":80",
+		Client:       http.DefaultClient,
+		PollInterval: DefaultACMEPollInterval,
+		Timeout:      DefaultACMETimeout,
+		key:          key,
+	}, nil
+}
+
+// NewACMEProvider builds an ACME client from the "acme" profile of an
+// identity. The profile holds the "directory" URL, and optionally a
+// comma-separated "contact" list, the "account-key" file (a PEM key,
+// created if missing; by default a new key is used by each process),
+// the "http-01-addr", an "eab-kid" and base64url "eab-hmac-key", a
+// "tls-remote-ca" file verifying the server, and a "ca-certificate"
+// file.
+func NewACMEProvider(id *core.Identity) (*ACME, error) {
+	if id == nil {
+		return nil, errors.New("transport: the identity hasn't been initialised. Has it been loaded from disk?")
+	}
+	profile := id.Profiles["acme"]
+	if profile["directory"] == "" {
+		return nil, errors.New("transport: ACME provider requires a directory URL")
+	}
+
+	key, err := loadAccountKey(profile["account-key"])
+	if err != nil {
+		return nil, err
+	}
+	a, err := NewACME(profile["directory"], key)
+	if err != nil {
+		return nil, err
+	}
+
+	if contact := profile["contact"]; contact != "" {
+		for _, c := range strings.Split(contact, ",") {
+			a.Contact = append(a.Contact, strings.TrimSpace(c))
+		}
+	}
+	if addr := profile["http-01-addr"]; addr != "" {
+		a.HTTP01Addr = addr
+	}
+	if a.EABKeyID = profile["eab-kid"]; a.EABKeyID != "" {
+		a.EABKey, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(profile["eab-hmac-key"], "="))
+		if err != nil || len(a.EABKey) == 0 {
+			return nil, errors.New("transport: invalid ACME external account binding key")
+		}
+	}
+	if file := profile["ca-certificate"]; file != "" {
+		if a.CACertificatePEM, err = ioutil.ReadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	remoteCAs, err := helpers.LoadPEMCertPool(profile["tls-remote-ca"])
+	if err != nil {
+		return nil, err
+	}
+	if remoteCAs != nil {
+		a.Client = &http.Client{Transport: &http.Transport{TLSClientConfig: helpers.CreateTLSConfig(remoteCAs, nil)}}
+	}
+	return a, nil
+}
+
+// loadAccountKey loads the account key in path, creating it if needed,
+// or returns a new key if path is empty.
+func loadAccountKey(path string) (crypto.Signer, error) {
+	if path != "" {
+		in, err := ioutil.ReadFile(path)
+		if err == nil {
+			return helpers.ParsePrivateKeyPEM(in)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err = ioutil.WriteFile(path, keyPEM, 0600); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// SignCSR obtains a certificate for the CSR from the ACME server, and
+// returns it without its issuers.
+func (a *ACME) SignCSR(csrPEM []byte) ([]byte, error) {
+	p, _ := pem.Decode(csrPEM)
+	if p == nil || p.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("transport: invalid PEM-encoded certificate signing request")
+	}
+	csr, err := x509.ParseCertificateRequest(p.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	identifiers := csrIdentifiers(csr)
+	if len(identifiers) == 0 {
+		return nil, errors.New("transport: ACME certificate requests must name a host")
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = DefaultACMETimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err = a.register(ctx); err != nil {
+		return nil, err
+	}
+
+	var order acmeOrder
+	resp, err := a.post(ctx, a.dir.NewOrder, map[string]interface{}{"identifiers": identifiers}, &order)
+	if err != nil {
+		return nil, err
+	}
+	orderURL := resp.Header.Get("Location")
+
+	solver := &http01Solver{addr: a.HTTP01Addr, responses: map[string]string{}}
+	defer solver.stop()
+	for _, authzURL := range order.Authorizations {
+		if err = a.authorize(ctx, authzURL, solver); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp, err = a.post(ctx, order.Finalize, map[string]string{"csr": base64.RawURLEncoding.EncodeToString(p.Bytes)}, &order); err != nil {
+		return nil, err
+	}
+	for order.Status != "valid" {
+		if order.Status == "invalid" {
+			return nil, errors.New("transport: ACME order is invalid")
+		}
+		if err = a.wait(ctx, resp); err != nil {
+			return nil, err
+		}
+		if resp, err = a.post(ctx, orderURL, nil, &order); err != nil {
+			return nil, err
+		}
+	}
+
+	var chain []byte
+	if _, err = a.post(ctx, order.Certificate, nil, &chain); err != nil {
+		return nil, err
+	}
+	block, rest := pem.Decode(chain)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("transport: ACME server returned an invalid certificate")
+	}
+	a.issuers = bytes.TrimSpace(rest)
+	return pem.EncodeToMemory(block), nil
+}
+
+// CACertificate returns the configured CA certificate, or else the
+// issuers of the last certificate issued.
+func (a *ACME) CACertificate() ([]byte, error) {
+	if len(a.CACertificatePEM) != 0 {
+		return a.CACertificatePEM, nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.issuers) == 0 {
+		return nil, errors.New("transport: the ACME CA certificate is unknown until a certificate is issued")
+	}
+	return a.issuers, nil
+}
+
+// csrIdentifiers returns the identifiers an ACME order for the CSR
+// names: its DNS names and IP addresses, and its common name if it is
+// neither.
+func csrIdentifiers(csr *x509.CertificateRequest) []acmeIdentifier {
+	var identifiers []acmeIdentifier
+	seen := map[string]bool{}
+	add := func(typ, value string) {
+		if value != "" && !seen[value] {
+			seen[value] = true
+			identifiers = append(identifiers, acmeIdentifier{Type: typ, Value: value})
+		}
+	}
+	if cn := csr.Subject.CommonName; cn != "" {
+		if ip := net.ParseIP(cn); ip != nil {
+			add("ip", ip.String())
+		} else if strings.Contains(cn, ".") && !strings.ContainsAny(cn, " @") {
+			add("dns", cn)
+		}
+	}
+	for _, name := range csr.DNSNames {
+		add("dns", name)
+	}
+	for _, ip := range csr.IPAddresses {
+		add("ip", ip.String())
+	}
+	return identifiers
+}
+
+// register fetches the directory, and registers the account or finds
+// it, unless that has been done. The lock must be held.
+func (a *ACME) register(ctx context.Context) error {
+	if a.dir == nil {
+		req, err := http.NewRequest(http.MethodGet, a.DirectoryURL, nil)
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		resp, err := a.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("transport: fetching ACME directory: %s", resp.Status)
+		}
+		var dir acmeDirectory
+		if err = json.NewDecoder(io.LimitReader(resp.Body, maxACMEResponse)).Decode(&dir); err != nil {
+			return err
+		}
+		a.dir = &dir
+	}
+	if a.kid != "" {
+		return nil
+	}
+
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if len(a.Contact) > 0 {
+		account["contact"] = a.Contact
+	}
+	if a.EABKeyID != "" {
+		eab, err := a.externalAccountBinding()
+		if err != nil {
+			return err
+		}
+		account["externalAccountBinding"] = eab
+	}
+	resp, err := a.post(ctx, a.dir.NewAccount, account, nil)
+	if err != nil {
+		return err
+	}
+	a.kid = resp.Header.Get("Location")
+	if a.kid == "" {
+		return errors.New("transport: ACME server did not return an account URL")
+	}
+	log.Debugf("transport: using ACME account %s", a.kid)
+	return nil
+}
+
+// externalAccountBinding returns the JWS binding the account key to
+// the external account (RFC 8555, section 7.3.4).
+func (a *ACME) externalAccountBinding() (json.RawMessage, error) {
+	jwk, err := json.Marshal(jsonWebKey(a.key.Public()))
+	if err != nil {
+		return nil, err
+	}
+	protected, err := json.Marshal(map[string]string{"alg": "HS256", "kid": a.EABKeyID, "url": a.dir.NewAccount})
+	if err != nil {
+		return nil, err
+	}
+	signingInput := b64(protected) + "." + b64(jwk)
+	mac := hmac.New(sha256.New, a.EABKey)
+	mac.Write([]byte(signingInput))
+	return json.Marshal(map[string]string{
+		"protected": b64(protected),
+		"payload":   b64(jwk),
+		"signature": b64(mac.Sum(nil)),
+	})
+}
+
+// authorize makes sure the authorization at url is valid, answering
+// its http-01 challenge if it is pending. The lock must be held.
+func (a *ACME) authorize(ctx context.Context, url string, solver *http01Solver) error {
+	var authz acmeAuthorization
+	resp, err := a.post(ctx, url, nil, &authz)
+	if err != nil {
+		return err
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	if authz.Status != "pending" {
+		return fmt.Errorf("transport: ACME authorization for %s is %s", authz.Identifier.Value, authz.Status)
+	}
+
+	var challenge *acmeChallenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "http-01" {
+			challenge = &authz.Challenges[i]
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("transport: no http-01 challenge offered for %s", authz.Identifier.Value)
+	}
+	thumbprint, err := jwkThumbprint(a.key.Public())
+	if err != nil {
+		return err
+	}
+	if err = solver.serve(challenge.Token, challenge.Token+"."+thumbprint); err != nil {
+		return err
+	}
+	if _, err = a.post(ctx, challenge.URL, struct{}{}, nil); err != nil {
+		return err
+	}
+
+	for {
+		if err = a.wait(ctx, resp); err != nil {
+			return err
+		}
+		if resp, err = a.post(ctx, url, nil, &authz); err != nil {
+			return err
+		}
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "pending":
+		default:
+			return fmt.Errorf("transport: ACME authorization for %s is %s", authz.Identifier.Value, authz.Status)
+		}
+	}
+}
+
+// wait sleeps for the Retry-After of resp, or the poll interval.
+func (a *ACME) wait(ctx context.Context, resp *http.Response) error {
+	d := a.PollInterval
+	if d <= 0 {
+		d = DefaultACMEPollInterval
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		d = time.Duration(secs) * time.Second
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// post sends payload in a JWS signed by the account key, or a
+// POST-as-GET if payload is nil, and decodes the response into out:
+// JSON, or, if out is a *[]byte, the raw body. A bad nonce is retried
+// once. The lock must be held.
+func (a *ACME) post(ctx context.Context, url string, payload interface{}, out interface{}) (*http.Response, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	for retried := false; ; retried = true {
+		nonce, err := a.nonce(ctx)
+		if err != nil {
+			return nil, err
+		}
+		jws, err := a.sign(url, nonce, body)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(jws))
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/jose+json")
+		resp, err := a.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxACMEResponse))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if n := resp.Header.Get("Replay-Nonce"); n != "" {
+			a.nonces = append(a.nonces, n)
+		}
+
+		if resp.StatusCode >= 400 {
+			problem := &acmeProblem{}
+			if json.Unmarshal(data, problem) != nil || problem.Type == "" {
+				problem.Type, problem.Detail = "unknown", resp.Status
+			}
+			if problem.Type == "urn:ietf:params:acme:error:badNonce" && !retried {
+				continue
+			}
+			return nil, problem
+		}
+
+		switch out := out.(type) {
+		case nil:
+		case *[]byte:
+			*out = data
+		default:
+			if err = json.Unmarshal(data, out); err != nil {
+				return nil, err
+			}
+		}
+		return resp, nil
+	}
+}
+
+// nonce returns an unused nonce. The lock must be held.
+func (a *ACME) nonce(ctx context.Context) (string, error) {
+	if n := len(a.nonces); n > 0 {
+		nonce := a.nonces[n-1]
+		a.nonces = a.nonces[:n-1]
+		return nonce, nil
+	}
+	req, err := http.NewRequest(http.MethodHead, a.dir.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("transport: ACME server did not provide a nonce")
+	}
+	return nonce, nil
+}
+
+// sign returns the flattened JWS of payload for url, identifying the
+// account by its URL once registered, or else by its key.
+func (a *ACME) sign(url, nonce string, payload []byte) ([]byte, error) {
+	alg := "RS256"
+	if _, ok := a.key.Public().(*ecdsa.PublicKey); ok {
+		alg = "ES256"
+	}
+	protected := map[string]interface{}{"alg": alg, "nonce": nonce, "url": url}
+	if a.kid != "" {
+		protected["kid"] = a.kid
+	} else {
+		protected["jwk"] = jsonWebKey(a.key.Public())
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	signingInput := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := a.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	if alg == "ES256" {
+		// JWS signatures are r and s, each 32 bytes.
+		var rs struct{ R, S *big.Int }
+		if _, err = asn1.Unmarshal(sig, &rs); err != nil {
+			return nil, err
+		}
+		sig = append(padBytes(rs.R, 32), padBytes(rs.S, 32)...)
+	}
+
+	return json.Marshal(map[string]string{
+		"protected": b64(header),
+		"payload":   b64(payload),
+		"signature": b64(sig),
+	})
+}
+
+// padBytes returns the big-endian bytes of n, left-padded with zeros to
+// size bytes.
+func padBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// jsonWebKey returns the JWK of a P-256 or RSA public key, with only
+// its required members, so that its JSON encoding, with the keys
+// sorted, is the input of its thumbprint.
+func jsonWebKey(pub crypto.PublicKey) map[string]string {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		x, y := padBytes(pub.X, 32), padBytes(pub.Y, 32)
+		return map[string]string{"crv": "P-256", "kty": "EC", "x": b64(x), "y": b64(y)}
+	case *rsa.PublicKey:
+		return map[string]string{"e": b64(big.NewInt(int64(pub.E)).Bytes()), "kty": "RSA", "n": b64(pub.N.Bytes())}
+	}
+	return nil
+}
+
+// jwkThumbprint returns the RFC 7638 thumbprint of a public key.
+func jwkThumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := json.Marshal(jsonWebKey(pub))
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(jwk)
+	return b64(digest[:]), nil
+}
+
+// http01Solver answers http-01 challenges while it runs.
+type http01Solver struct {
+	addr string
+
+	lock      sync.Mutex
+	responses map[string]string
+	server    *http.Server
+}
+
+const http01Path = "/.well-known/acme-challenge/"
+
+// serve answers the challenge with token, starting the server if
+// needed.
+func (s *http01Solver) serve(token, keyAuth string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.responses[token] = keyAuth
+	if s.server != nil {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("transport: cannot answer http-01 challenges: %v", err)
+	}
+	s.server = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		keyAuth, ok := s.responses[strings.TrimPrefix(r.URL.Path, http01Path)]
+		s.lock.Unlock()
+		if !ok || !strings.HasPrefix(r.URL.Path, http01Path) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.WriteString(w, keyAuth)
+	})}
+	go s.server.Serve(l)
+	return nil
+}
+
+func (s *http01Solver) stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.server != nil {
+		s.server.Close()
+	}
+}
@@ -0,0 +1,508 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/transport/core"
+	"github.com/cloudflare/cfssl/transport/kp"
+)
+
+// testCA issues certificates for CSRs.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(req *x509.CertificateRequest) (*x509.Certificate, error) {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      req.Subject,
+		DNSNames:     req.DNSNames,
+		IPAddresses:  req.IPAddresses,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, req.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// newKeyProvider returns a key provider and a CSR for 127.0.0.1 from it.
+func newKeyProvider(t *testing.T) (*kp.StandardProvider, []byte) {
+	sp := &kp.StandardProvider{}
+	if err := sp.Generate("ecdsa", 256); err != nil {
+		t.Fatal(err)
+	}
+	csrPEM, err := sp.CertificateRequest(&csr.CertificateRequest{CN: "127.0.0.1", Hosts: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp, csrPEM
+}
+
+// acmeServer is a minimal ACME server, validating http-01 challenges
+// on port http01Port of the identifiers.
+type acmeServer struct {
+	*httptest.Server
+	ca         *testCA
+	http01Port string
+	eabKey     []byte
+	preauthz   bool
+	badNonce   bool
+
+	lock        sync.Mutex
+	nonces      map[string]bool
+	accounts    map[string]*ecdsa.PublicKey
+	authzs      map[string]*acmeAuthorization
+	orders      map[string]*acmeOrder
+	certs       map[string][]byte
+	validations int
+	next        int
+}
+
+func newACMEServer(t *testing.T, ca *testCA) *acmeServer {
+	s := &acmeServer{
+		ca:       ca,
+		nonces:   map[string]bool{},
+		accounts: map[string]*ecdsa.PublicKey{},
+		authzs:   map[string]*acmeAuthorization{},
+		orders:   map[string]*acmeOrder{},
+		certs:    map[string][]byte{},
+	}
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+func (s *acmeServer) id(prefix string) string {
+	s.next++
+	return fmt.Sprintf("%s/%s/%d", s.URL, prefix, s.next)
+}
+
+func (s *acmeServer) problem(w http.ResponseWriter, status int, typ string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(acmeProblem{Type: "urn:ietf:params:acme:error:" + typ, Detail: typ})
+}
+
+func (s *acmeServer) reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *acmeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	s.nonces[b64(nonce)] = true
+	w.Header().Set("Replay-Nonce", b64(nonce))
+
+	switch {
+	case r.URL.Path == "/directory":
+		s.reply(w, http.StatusOK, acmeDirectory{
+			NewNonce:   s.URL + "/new-nonce",
+			NewAccount: s.URL + "/new-account",
+			NewOrder:   s.URL + "/new-order",
+		})
+		return
+	case r.URL.Path == "/new-nonce":
+		return
+	}
+
+	payload, kid, ok := s.verify(w, r)
+	if !ok {
+		return
+	}
+	url := s.URL + r.URL.Path
+	switch {
+	case r.URL.Path == "/new-account":
+		s.newAccount(w, payload)
+	case r.URL.Path == "/new-order":
+		s.newOrder(w, payload)
+	case strings.HasPrefix(r.URL.Path, "/authz/"):
+		s.reply(w, http.StatusOK, s.authzs[url])
+	case strings.HasPrefix(r.URL.Path, "/chal/"):
+		s.challenge(w, url, s.accounts[kid])
+	case strings.HasSuffix(r.URL.Path, "/finalize"):
+		s.finalize(w, strings.TrimSuffix(url, "/finalize"), payload)
+	case strings.HasPrefix(r.URL.Path, "/order/"):
+		order := s.orders[url]
+		if order.Status == "processing" {
+			order.Status = "valid"
+		}
+		s.reply(w, http.StatusOK, order)
+	case strings.HasPrefix(r.URL.Path, "/cert/"):
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(s.certs[url])
+	default:
+		s.problem(w, http.StatusNotFound, "malformed")
+	}
+}
+
+// verify checks the JWS of a POST, and returns its payload and the
+// account's URL.
+func (s *acmeServer) verify(w http.ResponseWriter, r *http.Request) ([]byte, string, bool) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		s.problem(w, http.StatusBadRequest, "malformed")
+		return nil, "", false
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  map[string]string
+	}
+	json.Unmarshal(header, &protected)
+
+	if !s.nonces[protected.Nonce] || s.badNonce {
+		s.badNonce = false
+		s.problem(w, http.StatusBadRequest, "badNonce")
+		return nil, "", false
+	}
+	delete(s.nonces, protected.Nonce)
+	if protected.URL != s.URL+r.URL.Path || protected.Alg != "ES256" {
+		s.problem(w, http.StatusBadRequest, "malformed")
+		return nil, "", false
+	}
+
+	var pub *ecdsa.PublicKey
+	if r.URL.Path == "/new-account" {
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK["y"])
+		pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else if pub = s.accounts[protected.Kid]; pub == nil {
+		s.problem(w, http.StatusUnauthorized, "accountDoesNotExist")
+		return nil, "", false
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(sig) != 64 || !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		s.problem(w, http.StatusUnauthorized, "unauthorized")
+		return nil, "", false
+	}
+
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if r.URL.Path == "/new-account" {
+		// Keep the key for newAccount to register.
+		s.accounts[""] = pub
+	}
+	return payload, protected.Kid, true
+}
+
+func (s *acmeServer) newAccount(w http.ResponseWriter, payload []byte) {
+	var account struct {
+		TermsOfServiceAgreed   bool
+		ExternalAccountBinding struct{ Protected, Payload, Signature string }
+	}
+	json.Unmarshal(payload, &account)
+	pub := s.accounts[""]
+	delete(s.accounts, "")
+
+	if s.eabKey != nil {
+		eab := account.ExternalAccountBinding
+		mac := hmac.New(sha256.New, s.eabKey)
+		mac.Write([]byte(eab.Protected + "." + eab.Payload))
+		jwk, _ := json.Marshal(jsonWebKey(pub))
+		if b64(mac.Sum(nil)) != eab.Signature || eab.Payload != b64(jwk) {
+			s.problem(w, http.StatusUnauthorized, "externalAccountRequired")
+			return
+		}
+	}
+
+	kid := s.id("acct")
+	s.accounts[kid] = pub
+	w.Header().Set("Location", kid)
+	s.reply(w, http.StatusCreated, map[string]string{"status": "valid"})
+}
+
+func (s *acmeServer) newOrder(w http.ResponseWriter, payload []byte) {
+	var req struct{ Identifiers []acmeIdentifier }
+	json.Unmarshal(payload, &req)
+
+	orderURL := s.id("order")
+	order := &acmeOrder{Status: "ready", Identifiers: req.Identifiers, Finalize: orderURL + "/finalize"}
+	for _, ident := range req.Identifiers {
+		authz := &acmeAuthorization{Status: "valid", Identifier: ident}
+		if !s.preauthz {
+			token := make([]byte, 16)
+			rand.Read(token)
+			authz.Status, order.Status = "pending", "pending"
+			authz.Challenges = []acmeChallenge{
+				{Type: "dns-01", URL: s.id("chal"), Token: b64(token), Status: "pending"},
+				{Type: "http-01", URL: s.id("chal"), Token: b64(token), Status: "pending"},
+			}
+		}
+		authzURL := s.id("authz")
+		s.authzs[authzURL] = authz
+		order.Authorizations = append(order.Authorizations, authzURL)
+	}
+	s.orders[orderURL] = order
+	w.Header().Set("Location", orderURL)
+	s.reply(w, http.StatusCreated, order)
+}
+
+func (s *acmeServer) challenge(w http.ResponseWriter, url string, account *ecdsa.PublicKey) {
+	for _, authz := range s.authzs {
+		for i := range authz.Challenges {
+			chal := &authz.Challenges[i]
+			if chal.URL != url {
+				continue
+			}
+			s.validations++
+			thumbprint, _ := jwkThumbprint(account)
+			resp, err := http.Get("http://" + net.JoinHostPort(authz.Identifier.Value, s.http01Port) + http01Path + chal.Token)
+			authz.Status, chal.Status = "invalid", "invalid"
+			if err == nil {
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if string(body) == chal.Token+"."+thumbprint {
+					authz.Status, chal.Status = "valid", "valid"
+				}
+			}
+			s.updateOrders()
+			s.reply(w, http.StatusOK, chal)
+			return
+		}
+	}
+	s.problem(w, http.StatusNotFound, "malformed")
+}
+
+func (s *acmeServer) updateOrders() {
+	for _, order := range s.orders {
+		if order.Status != "pending" {
+			continue
+		}
+		ready := true
+		for _, url := range order.Authorizations {
+			ready = ready && s.authzs[url].Status == "valid"
+		}
+		if ready {
+			order.Status = "ready"
+		}
+	}
+}
+
+func (s *acmeServer) finalize(w http.ResponseWriter, orderURL string, payload []byte) {
+	order := s.orders[orderURL]
+	if order == nil || order.Status != "ready" {
+		s.problem(w, http.StatusForbidden, "orderNotReady")
+		return
+	}
+	var req struct{ CSR string }
+	json.Unmarshal(payload, &req)
+	der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.problem(w, http.StatusBadRequest, "badCSR")
+		return
+	}
+	cert, err := s.ca.issue(csr)
+	if err != nil {
+		s.problem(w, http.StatusInternalServerError, "serverInternal")
+		return
+	}
+	certURL := s.id("cert")
+	s.certs[certURL] = append(helpers.EncodeCertificatePEM(cert), helpers.EncodeCertificatePEM(s.ca.cert)...)
+	order.Status, order.Certificate = "processing", certURL
+	s.reply(w, http.StatusOK, order)
+}
+
+func freePort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
+
+func newTestACME(t *testing.T, s *acmeServer) *ACME {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewACME(s.URL+"/directory", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Client = s.Client()
+	a.HTTP01Addr = "127.0.0.1:" + s.http01Port
+	a.PollInterval = 10 * time.Millisecond
+	return a
+}
+
+func TestACME(t *testing.T) {
+	ca := newTestCA(t)
+	s := newACMEServer(t, ca)
+	defer s.Close()
+	s.http01Port = freePort(t)
+	s.badNonce = true
+	a := newTestACME(t, s)
+
+	if _, err := a.CACertificate(); err == nil {
+		t.Fatal("CA certificate known before issuance")
+	}
+
+	sp, csrPEM := newKeyProvider(t)
+	certPEM, err := a.SignCSR(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The certificate feeds a key provider as a CFSSL one would.
+	if err = sp.SetCertificatePEM(certPEM); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := sp.X509KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pair.Leaf.CheckSignatureFrom(ca.cert); err != nil {
+		t.Fatal(err)
+	}
+	if s.validations != 1 {
+		t.Fatalf("%d challenges validated, want 1", s.validations)
+	}
+
+	caPEM, err := a.CACertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caCert, err := helpers.ParseCertificatePEM(caPEM); err != nil || !caCert.Equal(ca.cert) {
+		t.Fatal("wrong CA certificate")
+	}
+
+	// The account is reused.
+	_, csrPEM = newKeyProvider(t)
+	if _, err = a.SignCSR(csrPEM); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.accounts) != 1 {
+		t.Fatalf("%d accounts registered, want 1", len(s.accounts))
+	}
+}
+
+func TestACMEFailedChallenge(t *testing.T) {
+	s := newACMEServer(t, newTestCA(t))
+	defer s.Close()
+	s.http01Port = freePort(t)
+	a := newTestACME(t, s)
+	// The server validates on another port than the client answers.
+	a.HTTP01Addr = "127.0.0.1:" + freePort(t)
+
+	_, csrPEM := newKeyProvider(t)
+	if _, err := a.SignCSR(csrPEM); err == nil {
+		t.Fatal("certificate issued without a valid challenge")
+	}
+}
+
+func TestACMEExternalAccountBinding(t *testing.T) {
+	s := newACMEServer(t, newTestCA(t))
+	defer s.Close()
+	s.eabKey = []byte("0123456789abcdef")
+	s.preauthz = true
+
+	dir, err := ioutil.TempDir("", "cfssl_acme_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "account.pem")
+	id := &core.Identity{Profiles: map[string]map[string]string{
+		"ca": {"type": "acme"},
+		"acme": {
+			"directory":    s.URL + "/directory",
+			"account-key":  keyFile,
+			"eab-kid":      "kid-1",
+			"eab-hmac-key": b64([]byte("wrong key")),
+		},
+	}}
+	ca, err := New(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := ca.(*ACME)
+	a.Client = s.Client()
+	_, csrPEM := newKeyProvider(t)
+	if _, err = a.SignCSR(csrPEM); err == nil {
+		t.Fatal("account registered with the wrong external account key")
+	}
+
+	id.Profiles["acme"]["eab-hmac-key"] = b64(s.eabKey)
+	if ca, err = New(id); err != nil {
+		t.Fatal(err)
+	}
+	a = ca.(*ACME)
+	a.Client = s.Client()
+	// Pre-authorized identifiers need no challenge.
+	if _, err = a.SignCSR(csrPEM); err != nil {
+		t.Fatal(err)
+	}
+	if s.validations != 0 {
+		t.Fatal("pre-authorized identifier was challenged")
+	}
+
+	// The account key was saved, and is loaded again.
+	in, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(in); block == nil {
+		t.Fatal("account key was not saved")
+	}
+	key, err := loadAccountKey(keyFile)
+	if err != nil || !key.Public().(*ecdsa.PublicKey).Equal(a.key.Public()) {
+		t.Fatal("saved account key was not loaded")
+	}
+}
@@ -1,8 +1,20 @@
 // Package ca provides the CertificateAuthority interface for the
 // transport package, which provides an interface to get a CSR signed
 // by some certificate authority.
+//
+// The CFSSL backend has a remote CFSSL server sign CSRs. The ACME
+// backend obtains certificates from any ACME (RFC 8555) server, and the
+// EST backend from any EST (RFC 7030) server. New builds the backend
+// selected by an identity.
 package ca
 
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cloudflare/cfssl/transport/core"
+)
+
 // A CertificateAuthority is capable of signing certificates given
 // certificate signing requests.
 type CertificateAuthority interface {
@@ -14,3 +26,48 @@ type CertificateAuthority interface {
 	// certificate.
 	CACertificate() (cert []byte, err error)
 }
+
+// Providers maps the names of the certificate authority backends to
+// the functions building them from an identity.
+var Providers = map[string]func(*core.Identity) (CertificateAuthority, error){
+	"cfssl": func(id *core.Identity) (CertificateAuthority, error) {
+		c, err := NewCFSSLProvider(id, nil)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	},
+	"acme": func(id *core.Identity) (CertificateAuthority, error) {
+		a, err := NewACMEProvider(id)
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
+	},
+	"est": func(id *core.Identity) (CertificateAuthority, error) {
+		e, err := NewESTProvider(id)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	},
+}
+
+// New builds the certificate authority backend selected by the "type"
+// key of the identity's "ca" profile: "cfssl" (the default), "acme" or
+// "est". Each is configured by the profile of the same name.
+func New(id *core.Identity) (CertificateAuthority, error) {
+	if id == nil {
+		return nil, errors.New("transport: the identity hasn't been initialised. Has it been loaded from disk?")
+	}
+
+	typ := id.Profiles["ca"]["type"]
+	if typ == "" {
+		typ = "cfssl"
+	}
+	newCA, ok := Providers[typ]
+	if !ok {
+		return nil, fmt.Errorf("transport: unsupported certificate authority %q", typ)
+	}
+	return newCA(id)
+}
@@ -1,6 +1,7 @@
 package ca
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -8,6 +9,7 @@ import (
 	"fmt"
 	"net"
 	"path/filepath"
+	"time"
 
 	"github.com/cloudflare/cfssl/api/client"
 	"github.com/cloudflare/cfssl/auth"
@@ -165,9 +167,12 @@ func (cap *CFSSL) setRemoteAndAuth() error {
 		if ok {
 			remote, ok := getRemote(cfsslConfig, profile)
 			if ok {
-				cap.remote = client.NewServerTLS(remote, helpers.CreateTLSConfig(profile.RemoteCAs, profile.ClientCert))
+				var err error
+				cap.remote, err = client.NewServerTLSWithStrategy(remote,
+					helpers.CreateTLSConfig(profile.RemoteCAs, profile.ClientCert), profile.RemoteStrategy,
+					client.SignerGroupOptions(context.Background(), profile.RemoteHealthInterval)...)
 				cap.provider = profile.Provider
-				return nil
+				return err
 			}
 
 			// The profile may not have a remote set, but
@@ -278,7 +283,19 @@ func NewCFSSLProvider(id *core.Identity, defaultRemote client.Remote) (*CFSSL, e
 			if err != nil {
 				return nil, err
 			}
-			cap.DefaultRemote = client.NewServerTLS(cfssl["remote"], helpers.CreateTLSConfig(remoteCAs, cert))
+			var healthCheck time.Duration
+			if cfssl["remote-health-check"] != "" {
+				healthCheck, err = time.ParseDuration(cfssl["remote-health-check"])
+				if err != nil {
+					return nil, err
+				}
+			}
+			cap.DefaultRemote, err = client.NewServerTLSWithStrategy(cfssl["remote"],
+				helpers.CreateTLSConfig(remoteCAs, cert), cfssl["remote-strategy"],
+				client.SignerGroupOptions(context.Background(), healthCheck)...)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		cap.DefaultAuth.Type = cfssl["auth-type"]
@@ -0,0 +1,196 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/transport/core"
+)
+
+// This file contains an EST (RFC 7030) client.
+
+// DefaultESTRetries is the number of times an EST client asks again for
+// a certificate the server has accepted but not yet issued.
+const DefaultESTRetries = 10
+
+// maxESTResponse bounds the size of an EST response.
+const maxESTResponse = 1 << 20
+
+// EST obtains certificates from an EST (RFC 7030) server, with the
+// simpleenroll operation, authenticating with HTTP basic
+// authentication, a TLS client certificate, or both.
+type EST struct {
+	// URL is the base URL of the server's operations, such as
+	// "https://est.example.com/.well-known/est", followed by a CA
+	// label if the server has several.
+	URL string
+
+	// Username and Password authenticate the client, if set.
+	Username string
+	Password string
+
+	// Client makes the requests.
+	Client *http.Client
+
+	// Retries bounds the number of times a pending enrollment is
+	// polled; it defaults to DefaultESTRetries.
+	Retries int
+}
+
+// NewESTProvider builds an EST client from the "est" profile of an
+// identity. The profile holds the base "url", and optionally the
+// "username" and "password", a "tls-remote-ca" file verifying the
+// server, and the "mutual-tls-cert" and "mutual-tls-key" files of a
+// client certificate.
+func NewESTProvider(id *core.Identity) (*EST, error) {
+	if id == nil {
+		return nil, errors.New("transport: the identity hasn't been initialised. Has it been loaded from disk?")
+	}
+	profile := id.Profiles["est"]
+	if profile["url"] == "" {
+		return nil, errors.New("transport: EST provider requires a URL")
+	}
+
+	cert, err := helpers.LoadClientCertificate(profile["mutual-tls-cert"], profile["mutual-tls-key"])
+	if err != nil {
+		return nil, err
+	}
+	remoteCAs, err := helpers.LoadPEMCertPool(profile["tls-remote-ca"])
+	if err != nil {
+		return nil, err
+	}
+
+	return &EST{
+		URL:      strings.TrimSuffix(profile["url"], "/"),
+		Username: profile["username"],
+		Password: profile["password"],
+		Client: &http.Client{
+			Timeout:   time.Minute,
+			Transport: &http.Transport{TLSClientConfig: helpers.CreateTLSConfig(remoteCAs, cert)},
+		},
+	}, nil
+}
+
+// SignCSR enrolls the CSR with the EST server, and returns the
+// certificate issued for it.
+func (e *EST) SignCSR(csrPEM []byte) ([]byte, error) {
+	p, _ := pem.Decode(csrPEM)
+	if p == nil || p.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("transport: invalid PEM-encoded certificate signing request")
+	}
+	csr, err := x509.ParseCertificateRequest(p.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	retries := e.Retries
+	if retries <= 0 {
+		retries = DefaultESTRetries
+	}
+	body := base64.StdEncoding.EncodeToString(p.Bytes)
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, e.URL+"/simpleenroll", strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/pkcs10")
+		req.Header.Set("Content-Transfer-Encoding", "base64")
+		certs, retryAfter, err := e.do(req)
+		if err != nil {
+			return nil, err
+		}
+		if retryAfter == 0 {
+			for _, cert := range certs {
+				if bytes.Equal(cert.RawSubjectPublicKeyInfo, csr.RawSubjectPublicKeyInfo) {
+					return helpers.EncodeCertificatePEM(cert), nil
+				}
+			}
+			return nil, errors.New("transport: EST server returned no certificate for the request")
+		}
+		if attempt == retries {
+			return nil, errors.New("transport: EST enrollment is still pending")
+		}
+		time.Sleep(retryAfter)
+	}
+}
+
+// CACertificate returns the CA certificates of the EST server.
+func (e *EST) CACertificate() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, e.URL+"/cacerts", nil)
+	if err != nil {
+		return nil, err
+	}
+	certs, _, err := e.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("transport: EST server returned no CA certificates")
+	}
+
+	var out bytes.Buffer
+	for _, cert := range certs {
+		out.Write(helpers.EncodeCertificatePEM(cert))
+	}
+	return out.Bytes(), nil
+}
+
+// do makes an EST request, and returns the certificates in the
+// response, or how long to wait if the request is pending.
+func (e *EST) do(req *http.Request) ([]*x509.Certificate, time.Duration, error) {
+	if e.Username != "" {
+		req.SetBasicAuth(e.Username, e.Password)
+	}
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxESTResponse))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusAccepted:
+		retryAfter := time.Minute
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		return nil, retryAfter, nil
+	default:
+		return nil, 0, fmt.Errorf("transport: EST %s failed: %s: %s", req.URL.Path, resp.Status, bytes.TrimSpace(body))
+	}
+
+	// The certificates are a base64-encoded, degenerate PKCS #7
+	// SignedData.
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		return nil, 0, fmt.Errorf("transport: malformed EST response: %v", err)
+	}
+	p7, err := pkcs7.ParsePKCS7(der)
+	if err != nil {
+		return nil, 0, err
+	}
+	if p7.ContentInfo != "SignedData" {
+		return nil, 0, errors.New("transport: EST response holds no certificates")
+	}
+	return p7.Content.SignedData.Certificates, 0, nil
+}
@@ -0,0 +1,139 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/transport/core"
+)
+
+// newESTServer returns an EST server issuing certificates from ca to
+// the user "client", which makes the first enrollment wait.
+func newESTServer(t *testing.T, ca *testCA) *httptest.Server {
+	pending := true
+	writeCerts := func(w http.ResponseWriter, certs ...*x509.Certificate) {
+		der, err := pkcs7.DegenerateCertificates(certs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/pkcs7-mime; smime-type=certs-only")
+		w.Header().Set("Content-Transfer-Encoding", "base64")
+		w.Write([]byte(base64.StdEncoding.EncodeToString(der)))
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/est/cacerts":
+			writeCerts(w, ca.cert)
+		case "/.well-known/est/simpleenroll":
+			if user, pass, ok := r.BasicAuth(); !ok || user != "client" || pass != "secret" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="est"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if pending {
+				pending = false
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			der, err := base64.StdEncoding.DecodeString(string(body))
+			if err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			req, err := x509.ParseCertificateRequest(der)
+			if err != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			cert, err := ca.issue(req)
+			if err != nil {
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			writeCerts(w, cert, ca.cert)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return server
+}
+
+func TestEST(t *testing.T) {
+	ca := newTestCA(t)
+	server := newESTServer(t, ca)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "cfssl_est_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serverCA := filepath.Join(dir, "server.pem")
+	if err := ioutil.WriteFile(serverCA, helpers.EncodeCertificatePEM(server.Certificate()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	id := &core.Identity{Profiles: map[string]map[string]string{
+		"ca": {"type": "est"},
+		"est": {
+			"url":           server.URL + "/.well-known/est/",
+			"username":      "client",
+			"password":      "wrong",
+			"tls-remote-ca": serverCA,
+		},
+	}}
+	est, err := New(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPEM, err := est.CACertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caCert, err := helpers.ParseCertificatePEM(caPEM); err != nil || !caCert.Equal(ca.cert) {
+		t.Fatal("wrong CA certificate")
+	}
+
+	sp, csrPEM := newKeyProvider(t)
+	if _, err = est.SignCSR(csrPEM); err == nil {
+		t.Fatal("enrolled with the wrong password")
+	}
+
+	id.Profiles["est"]["password"] = "secret"
+	if est, err = New(id); err != nil {
+		t.Fatal(err)
+	}
+	// The first attempt is pending, and retried.
+	certPEM, err := est.SignCSR(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sp.SetCertificatePEM(certPEM); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := sp.X509KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pair.Leaf.CheckSignatureFrom(ca.cert); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewUnknownCA(t *testing.T) {
+	id := &core.Identity{Profiles: map[string]map[string]string{"ca": {"type": "scep"}}}
+	if _, err := New(id); err == nil {
+		t.Fatal("unknown certificate authority was accepted")
+	}
+}
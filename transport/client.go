@@ -1,9 +1,13 @@
 package transport
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"net"
+	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/cloudflare/backoff"
@@ -29,13 +33,13 @@ var (
 	// NewKeyProvider is the function used to build key providers
 	// from some identity.
 	NewKeyProvider = func(id *core.Identity) (kp.KeyProvider, error) {
-		return kp.NewStandardProvider(id)
+		return kp.New(id)
 	}
 
 	// NewCA is used to load a configuration for a certificate
 	// authority.
 	NewCA = func(id *core.Identity) (ca.CertificateAuthority, error) {
-		return ca.NewCFSSLProvider(id, nil)
+		return ca.New(id)
 	}
 )
 
@@ -77,62 +81,174 @@ type Transport struct {
 	// certificate cannot be checked) to not be treated as an
 	// error.
 	RevokeSoftFail bool
+
+	// refreshLock serialises key refreshes; certLock guards the
+	// current key pair, which handshakes read.
+	refreshLock sync.Mutex
+	certLock    sync.RWMutex
+	cert        *tls.Certificate
+
+	// checker checks the revocation status of peers.
+	checkerOnce sync.Once
+	checker     *revoke.Checker
 }
 
 // TLSClientAuthClientConfig returns a new client authentication TLS
 // configuration that can be used for a client using client auth
-// connecting to the named host.
+// connecting to the named host. The configuration presents the
+// transport's current certificate, and verifies the server against the
+// trust store as it is, at each handshake, so it need not be rebuilt
+// when the certificate is reissued or the trust store refreshed.
 func (tr *Transport) TLSClientAuthClientConfig(host string) (*tls.Config, error) {
-	cert, err := tr.getCertificate()
-	if err != nil {
+	if _, err := tr.getCertificate(); err != nil {
 		return nil, err
 	}
 
 	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      tr.TrustStore.Pool(),
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return tr.getCertificate()
+		},
+		// crypto/tls would verify the server against a pool fixed
+		// when the configuration is made, so verifyServer does it
+		// instead.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return tr.verifyServer(host, rawCerts)
+		},
 		ServerName:   host,
 		CipherSuites: core.CipherSuites,
 		MinVersion:   tls.VersionTLS12,
-		ClientAuth:   tls.RequireAndVerifyClientCert,
 	}, nil
 }
 
+// verifyServer verifies the chain presented by the named host against
+// the trust store as it is now, then checks its revocation status.
+func (tr *Transport) verifyServer(host string, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New(errors.CertificateError, errors.VerifyFailed)
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, der := range rawCerts {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return errors.Wrap(errors.CertificateError, errors.ParseFailed, err)
+		}
+		certs[i] = cert
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         tr.TrustStore.Pool(),
+		Intermediates: x509.NewCertPool(),
+		DNSName:       host,
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(opts)
+	if err != nil {
+		return errors.Wrap(errors.CertificateError, errors.VerifyFailed, err)
+	}
+	return tr.checkRevocation(rawCerts, chains)
+}
+
 // TLSClientAuthServerConfig returns a new client authentication TLS
 // configuration for servers expecting mutually authenticated
-// clients. The clientAuth parameter should contain the root pool used
-// to authenticate clients.
+// clients, who are verified against the transport's ClientTrustStore.
+// Like the other configurations, it presents the current certificate,
+// and verifies clients against the client trust store as it is, at
+// each handshake.
 func (tr *Transport) TLSClientAuthServerConfig() (*tls.Config, error) {
-	cert, err := tr.getCertificate()
-	if err != nil {
+	if _, err := tr.getCertificate(); err != nil {
 		return nil, err
 	}
 
+	config := tr.clientAuthServerConfig()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return tr.clientAuthServerConfig(), nil
+	}
+	return config, nil
+}
+
+// clientAuthServerConfig returns the configuration of a handshake with
+// a client, trusting the client trust store as it is now.
+func (tr *Transport) clientAuthServerConfig() *tls.Config {
+	var clientCAs *x509.CertPool
+	if tr.ClientTrustStore != nil {
+		clientCAs = tr.ClientTrustStore.Pool()
+	} else {
+		// Trust no client rather than the system roots.
+		clientCAs = x509.NewCertPool()
+	}
+
 	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      tr.TrustStore.Pool(),
-		ClientCAs:    tr.ClientTrustStore.Pool(),
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		CipherSuites: core.CipherSuites,
-		MinVersion:   tls.VersionTLS12,
-	}, nil
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return tr.getCertificate()
+		},
+		ClientCAs:             clientCAs,
+		ClientAuth:            tls.RequireAndVerifyClientCert,
+		VerifyPeerCertificate: tr.checkRevocation,
+		CipherSuites:          core.CipherSuites,
+		MinVersion:            tls.VersionTLS12,
+	}
 }
 
 // TLSServerConfig is a general server configuration that should be
-// used for non-client authentication purposes, such as HTTPS.
+// used for non-client authentication purposes, such as HTTPS. It
+// presents the transport's current certificate at each handshake.
 func (tr *Transport) TLSServerConfig() (*tls.Config, error) {
-	cert, err := tr.getCertificate()
-	if err != nil {
+	if _, err := tr.getCertificate(); err != nil {
 		return nil, err
 	}
 
 	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return tr.getCertificate()
+		},
 		CipherSuites: core.CipherSuites,
 		MinVersion:   tls.VersionTLS12,
 	}, nil
 }
 
+// RevocationTimeout bounds each request made to check the revocation
+// status of a peer's certificates.
+var RevocationTimeout = 10 * time.Second
+
+// revocationChecker returns the checker of the transport's peers. It
+// caches CRLs and OCSP responses until their next update, so that
+// handshakes don't each wait on the network.
+func (tr *Transport) revocationChecker() *revoke.Checker {
+	tr.checkerOnce.Do(func() {
+		tr.checker = revoke.NewChecker(revoke.Config{
+			Client: &http.Client{Timeout: RevocationTimeout},
+		})
+	})
+	return tr.checker
+}
+
+// checkRevocation is a VerifyPeerCertificate callback, called once
+// the peer's chain has been verified, that checks that no certificate
+// in the verified chains is revoked.
+func (tr *Transport) checkRevocation(_ [][]byte, chains [][]*x509.Certificate) error {
+	if len(chains) == 0 {
+		return errors.New(errors.CertificateError, errors.VerifyFailed)
+	}
+
+	checker := tr.revocationChecker()
+	for _, chain := range chains {
+		for i, cert := range chain {
+			issuer := cert
+			if i+1 < len(chain) {
+				issuer = chain[i+1]
+			}
+			revoked, ok := checker.CheckStapled(cert, issuer, nil)
+			if (!tr.RevokeSoftFail && !ok) || revoked {
+				return errors.New(errors.CertificateError, errors.VerifyFailed)
+			}
+		}
+	}
+	return nil
+}
+
 // New builds a new transport from an identity and a before time. The
 // before time tells the transport how long before the certificate
 // expires to start attempting to update when auto-updating. If before
@@ -200,6 +316,16 @@ func (tr *Transport) Lifespan() time.Duration {
 // certificate is valid (i.e. that its expiry date is within the
 // Before date), and handle certificate reissuance as needed.
 func (tr *Transport) RefreshKeys() (err error) {
+	tr.refreshLock.Lock()
+	defer tr.refreshLock.Unlock()
+
+	if err = tr.refreshKeys(); err != nil {
+		return err
+	}
+	return tr.updateCertificate()
+}
+
+func (tr *Transport) refreshKeys() (err error) {
 	if !tr.Provider.Ready() {
 		log.Debug("key and certificate aren't ready, loading")
 		err = tr.Provider.Load()
@@ -213,6 +339,9 @@ func (tr *Transport) RefreshKeys() (err error) {
 			err = tr.Provider.Generate(kr.Algo(), kr.Size())
 			if err != nil {
 				log.Debugf("failed to generate key: %v", err)
+				if tr.Provider.SignalFailure(err) {
+					return tr.refreshKeys()
+				}
 				return err
 			}
 		}
@@ -225,7 +354,7 @@ func (tr *Transport) RefreshKeys() (err error) {
 		if err != nil {
 			log.Debugf("couldn't get a CSR: %v", err)
 			if tr.Provider.SignalFailure(err) {
-				return tr.RefreshKeys()
+				return tr.refreshKeys()
 			}
 			return err
 		}
@@ -234,7 +363,7 @@ func (tr *Transport) RefreshKeys() (err error) {
 		cert, err := tr.CA.SignCSR(req)
 		if err != nil {
 			if tr.Provider.SignalFailure(err) {
-				return tr.RefreshKeys()
+				return tr.refreshKeys()
 			}
 			log.Debugf("failed to get the certificate signed: %v", err)
 			return err
@@ -245,7 +374,7 @@ func (tr *Transport) RefreshKeys() (err error) {
 		if err != nil {
 			log.Debugf("failed to set the provider's certificate: %v", err)
 			if tr.Provider.SignalFailure(err) {
-				return tr.RefreshKeys()
+				return tr.refreshKeys()
 			}
 			return err
 		}
@@ -257,7 +386,7 @@ func (tr *Transport) RefreshKeys() (err error) {
 			if err != nil {
 				log.Debugf("the provider failed to store the certificate: %v", err)
 				if tr.Provider.SignalFailure(err) {
-					return tr.RefreshKeys()
+					return tr.refreshKeys()
 				}
 				return err
 			}
@@ -266,22 +395,44 @@ func (tr *Transport) RefreshKeys() (err error) {
 	return nil
 }
 
-func (tr *Transport) getCertificate() (cert tls.Certificate, err error) {
+// updateCertificate makes the provider's key pair the one presented
+// in handshakes.
+func (tr *Transport) updateCertificate() error {
+	cert, err := tr.Provider.X509KeyPair()
+	if err != nil {
+		log.Debugf("couldn't generate an X.509 keypair: %v", err)
+		return err
+	}
+
+	tr.certLock.Lock()
+	tr.cert = &cert
+	tr.certLock.Unlock()
+	return nil
+}
+
+// getCertificate returns the current key pair, loading it first if
+// needed.
+func (tr *Transport) getCertificate() (*tls.Certificate, error) {
+	tr.certLock.RLock()
+	cert := tr.cert
+	tr.certLock.RUnlock()
+	if cert != nil {
+		return cert, nil
+	}
+
 	if !tr.Provider.Ready() {
 		log.Debug("transport isn't ready; attempting to refresh keypair")
-		err = tr.RefreshKeys()
-		if err != nil {
+		if err := tr.RefreshKeys(); err != nil {
 			log.Debugf("transport couldn't get a certificate: %v", err)
-			return
+			return nil, err
 		}
+	} else if err := tr.updateCertificate(); err != nil {
+		return nil, err
 	}
 
-	cert, err = tr.Provider.X509KeyPair()
-	if err != nil {
-		log.Debugf("couldn't generate an X.509 keypair: %v", err)
-	}
-
-	return
+	tr.certLock.RLock()
+	defer tr.certLock.RUnlock()
+	return tr.cert, nil
 }
 
 // Dial initiates a TLS connection to an outbound server. It returns a
@@ -300,33 +451,23 @@ func Dial(address string, tr *Transport) (*tls.Conn, error) {
 		return nil, err
 	}
 
-	conn, err := tls.Dial("tcp", address, cfg)
-	if err != nil {
-		return nil, err
-	}
-
-	state := conn.ConnectionState()
-	if len(state.VerifiedChains) == 0 {
-		return nil, errors.New(errors.CertificateError, errors.VerifyFailed)
-	}
-
-	for _, chain := range state.VerifiedChains {
-		for _, cert := range chain {
-			revoked, ok := revoke.VerifyCertificate(cert)
-			if (!tr.RevokeSoftFail && !ok) || revoked {
-				return nil, errors.New(errors.CertificateError, errors.VerifyFailed)
-			}
-		}
-	}
-
-	return conn, nil
+	// The server's chain and its revocation status are checked
+	// during the handshake.
+	return tls.Dial("tcp", address, cfg)
 }
 
-// AutoUpdate will automatically update the listener. If a non-nil
-// certUpdates chan is provided, it will receive timestamps for
-// reissued certificates. If errChan is non-nil, any errors that occur
-// in the updater will be passed along.
+// AutoUpdate will automatically update the transport's certificate,
+// until the program exits. If a non-nil certUpdates chan is provided,
+// it will receive timestamps for reissued certificates. If errChan is
+// non-nil, any errors that occur in the updater will be passed along.
 func (tr *Transport) AutoUpdate(certUpdates chan<- time.Time, errChan chan<- error) {
+	tr.AutoUpdateContext(context.Background(), certUpdates, errChan)
+}
+
+// AutoUpdateContext is AutoUpdate, returning the context's error when
+// it is done. The configurations returned by the transport present the
+// new certificate from the next handshake on.
+func (tr *Transport) AutoUpdateContext(ctx context.Context, certUpdates chan<- time.Time, errChan chan<- error) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Criticalf("AutoUpdate panicked: %v", r)
@@ -337,16 +478,19 @@ func (tr *Transport) AutoUpdate(certUpdates chan<- time.Time, errChan chan<- err
 		// Wait until it's time to update the certificate.
 		target := time.Now().Add(tr.Lifespan())
 		if PollInterval == 0 {
-			<-time.After(tr.Lifespan())
+			err = sleep(ctx, tr.Lifespan())
 		} else {
-			pollWait(target)
+			err = pollWait(ctx, target)
+		}
+		if err != nil {
+			return err
 		}
 
 		// Keep trying to update the certificate until it's
 		// ready.
 		for {
 			log.Debugf("attempting to refresh keypair")
-			err := tr.RefreshKeys()
+			err = tr.RefreshKeys()
 			if err == nil {
 				break
 			}
@@ -354,15 +498,25 @@ func (tr *Transport) AutoUpdate(certUpdates chan<- time.Time, errChan chan<- err
 			delay := tr.Backoff.Duration()
 			log.Debugf("failed to update certificate, will try again in %s", delay)
 			if errChan != nil {
-				errChan <- err
+				select {
+				case errChan <- err:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
 			}
 
-			<-time.After(delay)
+			if err = sleep(ctx, delay); err != nil {
+				return err
+			}
 		}
 
 		log.Debugf("certificate updated")
 		if certUpdates != nil {
-			certUpdates <- time.Now()
+			select {
+			case certUpdates <- time.Now():
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
 
 		tr.Backoff.Reset()
@@ -24,6 +24,27 @@
 //
 //
 //
+// The "key_provider" profile selects where the private key is kept:
+// its "type" is "standard" (PEM files, under the "paths" profile, or
+// memory), "ephemeral" (memory only, with a new key for each
+// certificate), "keyring" (a Linux kernel keyring, under the "keyring"
+// profile) or "pkcs11" (a PKCS #11 token, under the "pkcs11" profile,
+// when built with the pkcs11 tag). A comma-separated list of types,
+// such as "pkcs11,standard", fails over from one to the next.
+//
+// The "remote" of the "cfssl" profile may be a comma-separated list of
+// servers, tried in the order chosen by its "remote-strategy":
+// "ordered_list" (the default), "round_robin", "least_latency" or
+// "consistent_hash". A server that keeps failing is skipped for a
+// while, retries are limited to a fraction of requests, and if
+// "remote-health-check" is a duration such as "30s", servers are
+// checked at that interval.
+//
+// Similarly, the "type" of the "ca" profile selects the certificate
+// authority: "cfssl" (the default, under the "cfssl" profile), "acme"
+// (any RFC 8555 server, under the "acme" profile) or "est" (any RFC
+// 7030 server, under the "est" profile).
+//
 // The New function will return a transport built using the
 // NewKeyProvider and NewCA functions. These functions may be changed
 // by other packages to provide common key provider and CA
@@ -33,11 +54,23 @@
 // necessary connection support.
 //
 // The AutoUpdate function will handle automatic certificate
-// issuance. Servers and clients are not required to take any special
-// action when the certificate is updated: the key and certificate are
-// only used when establishing a connection, and therefore existing
-// connections are not affected---there is no need to reset or restart
-// any existing connections. Clients should run AutoUpdate if they
+// issuance; AutoUpdateContext stops when its context is done. Servers
+// and clients are not required to take any special action when the
+// certificate is updated: the TLS configurations fetch the current key
+// and certificate at each handshake, and servers verify clients
+// against the current client trust store. Existing connections are
+// not affected---there is no need to reset or restart any existing
+// connections or listeners. Clients should run AutoUpdate if they
 // plan on making multiple connections or will be reconnecting; for a
 // one-off connection, it isn't necessary.
+//
+// Peers are checked for revocation once their chains are verified.
+// Each transport caches the CRLs and OCSP responses it fetches, and
+// each request is limited by RevocationTimeout. Likewise, a trust store kept
+// up to date by its AutoRefresh method takes effect at the next
+// handshake.
+//
+// The grpccreds subpackage provides gRPC transport credentials backed
+// by a transport, and interceptors authorizing peers by the identity in
+// their certificates.
 package transport
@@ -0,0 +1,109 @@
+package grpccreds
+
+import (
+	"context"
+	"net"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/whitelist"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// An Authorizer decides which peers may make RPCs, from their address
+// and the identity in their certificate.
+type Authorizer struct {
+	// ACL, if not nil, must permit the peer's IP address.
+	ACL whitelist.ACL
+
+	// Peers, if not nil, must permit the peer's address and the
+	// certificates it authenticated with.
+	Peers whitelist.PeerACL
+
+	// Names, if not empty, lists patterns of which one must match
+	// one of the names of the peer's identity; see
+	// whitelist.MatchName.
+	Names []string
+}
+
+// permitted reports whether one of the patterns matches one of the
+// identity's names.
+func (a *Authorizer) permitted(id *Identity) bool {
+	for _, name := range id.Names() {
+		for _, pattern := range a.Names {
+			if whitelist.MatchName(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authorize returns nil if the peer of the RPC whose context is ctx may
+// make it, and otherwise an Unauthenticated or PermissionDenied status
+// error.
+func (a *Authorizer) Authorize(ctx context.Context) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "no peer")
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	ip := net.ParseIP(host)
+	if a.ACL != nil && (ip == nil || !a.ACL.Permitted(ip)) {
+		log.Infof("grpccreds: denied request from %s", p.Addr)
+		return status.Error(codes.PermissionDenied, "address not permitted")
+	}
+
+	if a.Peers != nil {
+		wp := &whitelist.Peer{IP: ip}
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			// As the whitelist lookups do, trust only a
+			// verified chain.
+			wp.Certificates = info.State.VerifiedChains[0]
+		}
+		if !a.Peers.PermittedPeer(wp) {
+			log.Infof("grpccreds: denied request from %s", p.Addr)
+			return status.Error(codes.PermissionDenied, "peer not permitted")
+		}
+	}
+
+	if len(a.Names) > 0 {
+		id, err := PeerIdentity(ctx)
+		if err != nil {
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+		if !a.permitted(id) {
+			log.Infof("grpccreds: denied request from %s (%s)", p.Addr, id.CommonName)
+			return status.Error(codes.PermissionDenied, "identity not permitted")
+		}
+	}
+	return nil
+}
+
+// UnaryServerInterceptor returns an interceptor authorizing unary RPCs.
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.Authorize(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor authorizing streaming
+// RPCs.
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.Authorize(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,143 @@
+// Package grpccreds connects the transport package to gRPC. It provides
+// server and client TransportCredentials backed by a
+// transport.Transport, the identity of the peer of an RPC, and an
+// Authorizer checking that identity against whitelist ACLs and name
+// patterns in server interceptors.
+//
+// A server would be set up with
+//
+//	tr, err := transport.New(before, id)
+//	...
+//	if err = grpccreds.AutoUpdate(ctx, tr, errChan); err != nil {
+//		...
+//	}
+//	auth := &grpccreds.Authorizer{Names: []string{"*.client.example.net"}}
+//	server := grpc.NewServer(
+//		grpc.Creds(grpccreds.NewServerCredentials(tr)),
+//		grpc.UnaryInterceptor(auth.UnaryServerInterceptor()),
+//		grpc.StreamInterceptor(auth.StreamServerInterceptor()),
+//	)
+//
+// and a client would dial with
+//
+//	grpc.Dial(address, grpc.WithTransportCredentials(grpccreds.NewClientCredentials(tr)))
+package grpccreds
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/cloudflare/cfssl/transport"
+	"google.golang.org/grpc/credentials"
+)
+
+// AutoUpdate makes sure the transport has a certificate, then keeps it
+// up to date in a goroutine until ctx is done, passing any errors
+// along to errChan if it isn't nil. Credentials built from the
+// transport present the current certificate, and verify peers against
+// the current trust stores, at each handshake.
+func AutoUpdate(ctx context.Context, tr *transport.Transport, errChan chan<- error) error {
+	if err := tr.RefreshKeys(); err != nil {
+		return err
+	}
+	go tr.AutoUpdateContext(ctx, nil, errChan)
+	return nil
+}
+
+// alpnProtos are the application protocols gRPC negotiates over TLS.
+var alpnProtos = []string{"h2"}
+
+// creds implements credentials.TransportCredentials with the TLS
+// configurations of a transport.
+type creds struct {
+	tr         *transport.Transport
+	server     bool
+	serverName string
+}
+
+// NewServerCredentials returns credentials for a gRPC server which
+// presents the transport's certificate, and requires clients to
+// present a certificate verified against its ClientTrustStore.
+func NewServerCredentials(tr *transport.Transport) credentials.TransportCredentials {
+	return &creds{tr: tr, server: true}
+}
+
+// NewClientCredentials returns credentials for a gRPC client which
+// presents the transport's certificate, and verifies the server against
+// its TrustStore and the host name of the authority it dials.
+func NewClientCredentials(tr *transport.Transport) credentials.TransportCredentials {
+	return &creds{tr: tr}
+}
+
+// ClientHandshake performs the client side of a TLS handshake.
+func (c *creds) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	host := c.serverName
+	if host == "" {
+		var err error
+		if host, _, err = net.SplitHostPort(authority); err != nil {
+			host = authority
+		}
+	}
+
+	cfg, err := c.tr.TLSClientAuthClientConfig(host)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.NextProtos = alpnProtos
+	conn := tls.Client(rawConn, cfg)
+	if deadline, ok := ctx.Deadline(); ok {
+		rawConn.SetDeadline(deadline)
+		defer rawConn.SetDeadline(time.Time{})
+	}
+	if err = conn.Handshake(); err != nil {
+		return nil, nil, err
+	}
+	return conn, credentials.TLSInfo{State: conn.ConnectionState()}, nil
+}
+
+// ServerHandshake performs the server side of a TLS handshake.
+func (c *creds) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	cfg, err := c.tr.TLSClientAuthServerConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.NextProtos = alpnProtos
+	if getConfig := cfg.GetConfigForClient; getConfig != nil {
+		cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			config, err := getConfig(hello)
+			if config != nil {
+				config.NextProtos = alpnProtos
+			}
+			return config, err
+		}
+	}
+	conn := tls.Server(rawConn, cfg)
+	if err = conn.Handshake(); err != nil {
+		return nil, nil, err
+	}
+	return conn, credentials.TLSInfo{State: conn.ConnectionState()}, nil
+}
+
+// Info describes the security protocol.
+func (c *creds) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		SecurityVersion:  "1.2",
+		ServerName:       c.serverName,
+	}
+}
+
+// Clone returns a copy of the credentials, sharing the transport.
+func (c *creds) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+// OverrideServerName sets the name the server's certificate is
+// verified against, instead of the host of the authority.
+func (c *creds) OverrideServerName(name string) error {
+	c.serverName = name
+	return nil
+}
@@ -0,0 +1,368 @@
+package grpccreds
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/backoff"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/transport"
+	"github.com/cloudflare/cfssl/transport/ca/localca"
+	"github.com/cloudflare/cfssl/transport/core"
+	"github.com/cloudflare/cfssl/transport/kp"
+	"github.com/cloudflare/cfssl/transport/roots"
+	"github.com/cloudflare/cfssl/whitelist"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// stringCodec carries *string messages.
+type stringCodec struct{}
+
+func (stringCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(*v.(*string)), nil
+}
+
+func (stringCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = string(data)
+	return nil
+}
+
+func (stringCodec) String() string { return "string" }
+
+// whoAmI replies with the common name of the caller.
+func whoAmI(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	var req string
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		id, err := PeerIdentity(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &id.CommonName, nil
+	}
+	if interceptor == nil {
+		return handler(ctx, &req)
+	}
+	return interceptor(ctx, &req, &grpc.UnaryServerInfo{FullMethod: "/test.Identity/WhoAmI"}, handler)
+}
+
+// watch streams the common name of the caller.
+func watch(srv interface{}, stream grpc.ServerStream) error {
+	id, err := PeerIdentity(stream.Context())
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(&id.CommonName)
+}
+
+var testService = grpc.ServiceDesc{
+	ServiceName: "test.Identity",
+	HandlerType: (*interface{})(nil),
+	Methods:     []grpc.MethodDesc{{MethodName: "WhoAmI", Handler: whoAmI}},
+	Streams:     []grpc.StreamDesc{{StreamName: "Watch", Handler: watch, ServerStreams: true}},
+}
+
+// newTransports returns a transport for a server and one for a client,
+// with certificates from the same local CA, and a function stopping
+// them.
+func newTransports(t *testing.T) (server, client *transport.Transport, stop func()) {
+	lca, err := localca.New(localca.ExampleRequest(), localca.ExampleSigningConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM, err := lca.CACertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "cfssl_grpccreds_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "ca.pem")
+	if err = ioutil.WriteFile(path, caPEM, 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	store, err := roots.New([]*core.Root{{Type: "file", Metadata: map[string]string{"source": path}}})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop = func() {
+		cancel()
+		os.RemoveAll(dir)
+	}
+
+	newTransport := func(cn string) *transport.Transport {
+		tr := &transport.Transport{
+			Before:           time.Minute,
+			Provider:         &kp.StandardProvider{},
+			CA:               lca,
+			TrustStore:       store,
+			ClientTrustStore: store,
+			Identity: &core.Identity{
+				Request: &csr.CertificateRequest{
+					CN:         cn,
+					Hosts:      []string{"127.0.0.1"},
+					KeyRequest: &csr.BasicKeyRequest{A: "ecdsa", S: 256},
+				},
+			},
+			Backoff:        &backoff.Backoff{},
+			RevokeSoftFail: true,
+		}
+		if err := AutoUpdate(ctx, tr, nil); err != nil {
+			stop()
+			t.Fatal(err)
+		}
+		return tr
+	}
+	return newTransport("server"), newTransport("client"), stop
+}
+
+// serve starts a server for the test service, authorizing RPCs with
+// auth, and returns a client connected to it and a function stopping
+// both.
+func serve(t *testing.T, auth *Authorizer) (*grpc.ClientConn, func()) {
+	serverTr, clientTr, stopTransports := newTransports(t)
+	server := grpc.NewServer(
+		grpc.Creds(NewServerCredentials(serverTr)),
+		grpc.CustomCodec(stringCodec{}),
+		grpc.UnaryInterceptor(auth.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(auth.StreamServerInterceptor()),
+	)
+	server.RegisterService(&testService, struct{}{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		stopTransports()
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	stop := func() {
+		server.Stop()
+		stopTransports()
+	}
+
+	conn, err := grpc.Dial(l.Addr().String(),
+		grpc.WithTransportCredentials(NewClientCredentials(clientTr)),
+		grpc.WithCodec(stringCodec{}),
+	)
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		stop()
+	}
+}
+
+func call(conn *grpc.ClientConn) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req, reply string
+	if err := conn.Invoke(ctx, "/test.Identity/WhoAmI", &req, &reply); err != nil {
+		return "", err
+	}
+
+	stream, err := conn.NewStream(ctx, &testService.Streams[0], "/test.Identity/Watch")
+	if err != nil {
+		return "", err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return "", err
+	}
+	var streamed string
+	if err = stream.RecvMsg(&streamed); err != nil {
+		return "", err
+	}
+	if streamed != reply {
+		return "", errors.New("streamed a different identity")
+	}
+	return reply, nil
+}
+
+func TestCredentials(t *testing.T) {
+	conn, stop := serve(t, &Authorizer{})
+	defer stop()
+	cn, err := call(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn != "client" {
+		t.Fatalf("server saw the client as %q", cn)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	serverTr, clientTr, stop := newTransports(t)
+	defer stop()
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := NewServerCredentials(serverTr).ServerHandshake(serverConn)
+		errs <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, info, err := NewClientCredentials(clientTr).ClientHandshake(ctx, "127.0.0.1:443", clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if proto := info.(credentials.TLSInfo).State.NegotiatedProtocol; proto != "h2" {
+		t.Fatalf("negotiated %q, want h2", proto)
+	}
+
+	// The handshake gives up at the context's deadline.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, _, err = NewClientCredentials(clientTr).ClientHandshake(ctx, "127.0.0.1:443", conn); err == nil {
+		t.Fatal("handshake with an unresponsive server succeeded")
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	localhost := whitelist.NewBasic()
+	localhost.Add(net.IPv4(127, 0, 0, 1))
+	clients := whitelist.NewBasicCommonName()
+	clients.Add("client")
+
+	for _, test := range []struct {
+		auth *Authorizer
+		code codes.Code
+	}{
+		{&Authorizer{Names: []string{"client"}}, codes.OK},
+		{&Authorizer{Names: []string{"server", "127.0.0.1"}}, codes.OK},
+		{&Authorizer{ACL: localhost, Names: []string{"*"}}, codes.OK},
+		{&Authorizer{Names: []string{"server"}}, codes.PermissionDenied},
+		{&Authorizer{ACL: whitelist.NewBasic()}, codes.PermissionDenied},
+		{&Authorizer{ACL: localhost, Names: []string{"*.client"}}, codes.PermissionDenied},
+		{&Authorizer{Peers: whitelist.And{whitelist.Address(localhost), clients}}, codes.OK},
+		{&Authorizer{Peers: whitelist.Or{clients}, Names: []string{"server"}}, codes.PermissionDenied},
+		{&Authorizer{Peers: whitelist.NewBasicSPKI()}, codes.PermissionDenied},
+	} {
+		conn, stop := serve(t, test.auth)
+		_, err := call(conn)
+		stop()
+		if code := status.Code(err); code != test.code {
+			t.Fatalf("%+v: got %v (%v), want %v", test.auth, code, err, test.code)
+		}
+	}
+}
+
+// newSANCertificate returns a self-signed certificate for api with
+// the given DNS and URI subject alternative names. The names are
+// encoded by hand, as x509.Certificate only has URIs from Go 1.10.
+func newSANCertificate(t *testing.T, dnsNames []string, uris ...string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []asn1.RawValue
+	for _, name := range dnsNames {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte(name)})
+	}
+	for _, uri := range uris {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(uri)})
+	}
+	san, err := asn1.Marshal(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "api"},
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 17}, Value: san}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestNewIdentity(t *testing.T) {
+	spiffeID := "spiffe://example.com/ns/prod/sa/api"
+	id, err := NewIdentity(newSANCertificate(t, []string{"api.example.com"}, spiffeID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.SPIFFEID != spiffeID {
+		t.Fatalf("wrong SPIFFE ID %q", id.SPIFFEID)
+	}
+	names := id.Names()
+	want := []string{"api", "api.example.com", spiffeID}
+	if len(names) != len(want) {
+		t.Fatalf("got names %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("got names %v, want %v", names, want)
+		}
+	}
+
+	// A certificate with several URIs isn't an SVID.
+	id, err = NewIdentity(newSANCertificate(t, nil, spiffeID, "spiffe://example.com/ns/dev/sa/api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.SPIFFEID != "" || len(id.URIs) != 2 {
+		t.Fatalf("SPIFFE ID %q from URIs %v", id.SPIFFEID, id.URIs)
+	}
+}
+
+func TestPeerIdentityUnverified(t *testing.T) {
+	cert := newSANCertificate(t, []string{"api.example.com"})
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+	if id, err := PeerIdentity(ctx); err == nil {
+		t.Fatalf("identity %+v from an unverified certificate", id)
+	}
+}
@@ -0,0 +1,93 @@
+package grpccreds
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Identity describes the certificate a peer authenticated with.
+type Identity struct {
+	// Certificate is the peer's leaf certificate.
+	Certificate *x509.Certificate
+
+	// CommonName is the common name of the certificate's subject.
+	CommonName string
+
+	// DNSNames, EmailAddresses, IPAddresses and URIs are the
+	// certificate's subject alternative names.
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+
+	// SPIFFEID is the SPIFFE ID of the certificate, if it is an
+	// X.509-SVID, or empty.
+	SPIFFEID string
+}
+
+// NewIdentity returns the identity asserted by a certificate.
+func NewIdentity(cert *x509.Certificate) (*Identity, error) {
+	uris, err := helpers.CertificateURIs(cert)
+	if err != nil {
+		return nil, err
+	}
+	id := &Identity{
+		Certificate:    cert,
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           uris,
+	}
+
+	// An X.509-SVID has exactly one URI SAN, which is its SPIFFE
+	// ID.
+	if len(uris) == 1 && uris[0].Scheme == "spiffe" && uris[0].Host != "" {
+		id.SPIFFEID = uris[0].String()
+	}
+	return id, nil
+}
+
+// Names returns every name of the identity: its common name, if any,
+// followed by its subject alternative names.
+func (id *Identity) Names() []string {
+	var names []string
+	if id.CommonName != "" {
+		names = append(names, id.CommonName)
+	}
+	names = append(names, id.DNSNames...)
+	names = append(names, id.EmailAddresses...)
+	for _, ip := range id.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range id.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// PeerIdentity returns the identity of the peer of the RPC whose
+// context is ctx, which must have been authenticated with a verified
+// TLS client certificate. As Authorizer does, it trusts only the
+// verified chain, so a peer without one has no identity.
+func PeerIdentity(ctx context.Context) (*Identity, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, errors.New("grpccreds: no peer in context")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, errors.New("grpccreds: peer did not authenticate with TLS")
+	}
+	if len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, errors.New("grpccreds: peer did not present a verified certificate")
+	}
+	return NewIdentity(info.State.VerifiedChains[0][0])
+}
@@ -0,0 +1,147 @@
+package kp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+// EphemeralProvider keeps its private key in memory only, and
+// generates a fresh key for each certificate request, so that no two
+// certificates share a key. The key in use is only replaced once the
+// certificate for its successor arrives.
+type EphemeralProvider struct {
+	priv    crypto.Signer
+	cert    *x509.Certificate
+	certPEM []byte
+
+	// pending is the key of the certificate being requested.
+	pending crypto.Signer
+}
+
+// NewEphemeralProvider returns an EphemeralProvider without a key.
+func NewEphemeralProvider() *EphemeralProvider {
+	return &EphemeralProvider{}
+}
+
+// Certificate returns the associated certificate, or nil if
+// one isn't ready.
+func (ep *EphemeralProvider) Certificate() *x509.Certificate {
+	return ep.cert
+}
+
+// CertificateRequest generates a new private key, and a certificate
+// signing request for it.
+func (ep *EphemeralProvider) CertificateRequest(req *csr.CertificateRequest) ([]byte, error) {
+	kr := req.KeyRequest
+	if kr == nil {
+		kr = csr.NewBasicKeyRequest()
+	}
+	priv, _, err := generateKey(kr.Algo(), kr.Size())
+	if err != nil {
+		return nil, err
+	}
+	ep.pending = priv
+	return csr.Generate(priv, req)
+}
+
+// Check is provided to implement the KeyProvider interface; an
+// EphemeralProvider needs no setup.
+func (ep *EphemeralProvider) Check() error {
+	return nil
+}
+
+// Generate generates a new private key, for the next certificate.
+func (ep *EphemeralProvider) Generate(algo string, size int) error {
+	priv, _, err := generateKey(algo, size)
+	if err != nil {
+		return err
+	}
+	ep.pending = priv
+	return nil
+}
+
+// Load is provided to implement the KeyProvider interface. There is
+// nothing to load: the provider starts without a key.
+func (ep *EphemeralProvider) Load() error {
+	return nil
+}
+
+// Persistent returns false.
+func (ep *EphemeralProvider) Persistent() bool {
+	return false
+}
+
+// Ready returns true if the provider has a key and certificate.
+func (ep *EphemeralProvider) Ready() bool {
+	return ep.priv != nil && ep.cert != nil
+}
+
+// SetCertificatePEM takes the certificate issued for the last
+// certificate request, which makes its key the one in use.
+func (ep *EphemeralProvider) SetCertificatePEM(certPEM []byte) error {
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return errors.New("transport: invalid certificate")
+	}
+	if ep.pending == nil {
+		return errors.New("transport: no certificate was requested")
+	}
+	if !publicKeysMatch(cert.PublicKey, ep.pending.Public()) {
+		return errors.New("transport: certificate does not match the requested key")
+	}
+
+	ep.priv, ep.pending = ep.pending, nil
+	ep.cert, ep.certPEM = cert, certPEM
+	return nil
+}
+
+// SignalFailure is provided to implement the KeyProvider interface,
+// and always returns false.
+func (ep *EphemeralProvider) SignalFailure(err error) bool {
+	return false
+}
+
+// SignCSR takes a template certificate request and signs it with the
+// key of the next certificate.
+func (ep *EphemeralProvider) SignCSR(tpl *x509.CertificateRequest) ([]byte, error) {
+	if ep.pending == nil {
+		return nil, errors.New("transport: no key has been generated")
+	}
+	return x509.CreateCertificateRequest(rand.Reader, tpl, ep.pending)
+}
+
+// Store is provided to implement the KeyProvider interface; nothing
+// is stored.
+func (ep *EphemeralProvider) Store() error {
+	return nil
+}
+
+// X509KeyPair returns a tls.Certificate for the provider.
+func (ep *EphemeralProvider) X509KeyPair() (tls.Certificate, error) {
+	if !ep.Ready() {
+		return tls.Certificate{}, errors.New("transport: provider does not have a key and certificate")
+	}
+	return signerKeyPair(ep.priv, ep.cert), nil
+}
+
+// signerKeyPair returns a tls.Certificate presenting cert, whose key is
+// held by priv.
+func signerKeyPair(priv crypto.Signer, cert *x509.Certificate) tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  priv,
+		Leaf:        cert,
+	}
+}
+
+// publicKeysMatch reports whether a and b are the same public key.
+func publicKeysMatch(a, b crypto.PublicKey) bool {
+	ka, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && ka.Equal(b)
+}
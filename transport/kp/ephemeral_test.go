@@ -0,0 +1,169 @@
+package kp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/transport/core"
+)
+
+var testRequest = &csr.CertificateRequest{
+	CN:         "localhost test certificate",
+	KeyRequest: &csr.BasicKeyRequest{A: "ecdsa", S: 256},
+}
+
+// issue returns a certificate for the public key of a PEM-encoded CSR.
+func issue(t *testing.T, csrPEM []byte) []byte {
+	req, err := helpers.ParseCSRPEM(csrPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: req.Subject.CommonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, req.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return helpers.EncodeCertificatePEM(cert)
+}
+
+func TestEphemeralProvider(t *testing.T) {
+	ep := NewEphemeralProvider()
+	if err := ep.Load(); err != nil || ep.Ready() || ep.Persistent() {
+		t.Fatal("new ephemeral provider should be empty and not persistent")
+	}
+
+	csrPEM, err := ep.CertificateRequest(testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ep.SetCertificatePEM(issue(t, csrPEM)); err != nil {
+		t.Fatal(err)
+	}
+	first, err := ep.X509KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each reissue gets a new key; the old one serves until the new
+	// certificate arrives.
+	csrPEM, err = ep.CertificateRequest(testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair, _ := ep.X509KeyPair(); pair.PrivateKey != first.PrivateKey {
+		t.Fatal("key replaced before its certificate arrived")
+	}
+	if err = ep.SetCertificatePEM(issue(t, csrPEM)); err != nil {
+		t.Fatal(err)
+	}
+	second, err := ep.X509KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKeysMatch(first.Leaf.PublicKey, second.Leaf.PublicKey) {
+		t.Fatal("reissued certificate reuses the key")
+	}
+
+	// A certificate for another key is refused.
+	other := NewEphemeralProvider()
+	csrPEM, _ = other.CertificateRequest(testRequest)
+	ep.CertificateRequest(testRequest)
+	if err = ep.SetCertificatePEM(issue(t, csrPEM)); err == nil {
+		t.Fatal("certificate for another key was accepted")
+	}
+}
+
+// failingProvider fails to generate keys.
+type failingProvider struct {
+	EphemeralProvider
+	failures int
+}
+
+func (fp *failingProvider) Generate(algo string, size int) error {
+	return errors.New("token unavailable")
+}
+
+func (fp *failingProvider) CertificateRequest(req *csr.CertificateRequest) ([]byte, error) {
+	return nil, errors.New("token unavailable")
+}
+
+func (fp *failingProvider) SignalFailure(err error) bool {
+	fp.failures++
+	return false
+}
+
+func TestFailoverProvider(t *testing.T) {
+	primary := &failingProvider{}
+	backup := NewEphemeralProvider()
+	fp := NewFailoverProvider(primary, backup)
+
+	if _, err := fp.CertificateRequest(testRequest); err == nil {
+		t.Fatal("primary provider should fail")
+	}
+	if !fp.SignalFailure(errors.New("token unavailable")) || primary.failures != 1 {
+		t.Fatal("failure did not fail over")
+	}
+	if fp.Current() != backup {
+		t.Fatal("backup provider is not in use")
+	}
+
+	csrPEM, err := fp.CertificateRequest(testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fp.SetCertificatePEM(issue(t, csrPEM)); err != nil || !fp.Ready() {
+		t.Fatalf("backup provider is not ready: %v", err)
+	}
+
+	// Once every provider has failed, the failure stands.
+	if fp.SignalFailure(errors.New("CA unavailable")) || fp.Current() != primary {
+		t.Fatal("failure of the last provider should stand and start over")
+	}
+}
+
+func TestNew(t *testing.T) {
+	id := &core.Identity{Request: testRequest}
+	kp, err := New(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kp.(*StandardProvider); !ok {
+		t.Fatalf("default provider is %T", kp)
+	}
+
+	id.Profiles = map[string]map[string]string{"key_provider": {"type": "ephemeral, standard"}}
+	if kp, err = New(id); err != nil {
+		t.Fatal(err)
+	}
+	fp, ok := kp.(*FailoverProvider)
+	if !ok {
+		t.Fatalf("failover provider is %T", kp)
+	}
+	if _, ok = fp.Current().(*EphemeralProvider); !ok {
+		t.Fatalf("first provider is %T", fp.Current())
+	}
+
+	id.Profiles["key_provider"]["type"] = "smartcard"
+	if _, err = New(id); err == nil {
+		t.Fatal("unknown provider was accepted")
+	}
+}
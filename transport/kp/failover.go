@@ -0,0 +1,125 @@
+package kp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/log"
+)
+
+// FailoverProvider uses the first of several key providers until it
+// fails: when a failure is signalled, it moves on to the next provider
+// and asks the caller to try again. Once the last provider has failed,
+// it starts over with the first, and the failure stands. It is safe
+// for concurrent use.
+type FailoverProvider struct {
+	providers []KeyProvider
+
+	lock    sync.Mutex
+	current int
+}
+
+// NewFailoverProvider returns a FailoverProvider using providers, in
+// order.
+func NewFailoverProvider(providers ...KeyProvider) *FailoverProvider {
+	return &FailoverProvider{providers: providers}
+}
+
+// Current returns the provider in use.
+func (fp *FailoverProvider) Current() KeyProvider {
+	kp, _ := fp.currentProvider()
+	return kp
+}
+
+// currentProvider returns the provider in use and its index.
+func (fp *FailoverProvider) currentProvider() (KeyProvider, int) {
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	return fp.providers[fp.current], fp.current
+}
+
+// Certificate returns the certificate of the provider in use.
+func (fp *FailoverProvider) Certificate() *x509.Certificate {
+	return fp.Current().Certificate()
+}
+
+// CertificateRequest returns a CSR from the provider in use.
+func (fp *FailoverProvider) CertificateRequest(req *csr.CertificateRequest) ([]byte, error) {
+	return fp.Current().CertificateRequest(req)
+}
+
+// Check checks the setup of every provider.
+func (fp *FailoverProvider) Check() error {
+	for _, kp := range fp.providers {
+		if err := kp.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generate generates a new private key in the provider in use.
+func (fp *FailoverProvider) Generate(algo string, size int) error {
+	return fp.Current().Generate(algo, size)
+}
+
+// Load loads the key and certificate of the provider in use.
+func (fp *FailoverProvider) Load() error {
+	return fp.Current().Load()
+}
+
+// Persistent returns true if the provider in use is persistent.
+func (fp *FailoverProvider) Persistent() bool {
+	return fp.Current().Persistent()
+}
+
+// Ready returns true if the provider in use is ready.
+func (fp *FailoverProvider) Ready() bool {
+	return fp.Current().Ready()
+}
+
+// SetCertificatePEM sets the certificate of the provider in use.
+func (fp *FailoverProvider) SetCertificatePEM(certPEM []byte) error {
+	return fp.Current().SetCertificatePEM(certPEM)
+}
+
+// SignalFailure gives the provider in use a chance to recover from
+// err. Failing that, it moves on to the next provider and returns
+// true, so that the caller tries again, unless all have failed.
+func (fp *FailoverProvider) SignalFailure(err error) bool {
+	kp, i := fp.currentProvider()
+	if kp.SignalFailure(err) {
+		return true
+	}
+
+	fp.lock.Lock()
+	defer fp.lock.Unlock()
+	if fp.current != i {
+		// Another caller has already failed over.
+		return true
+	}
+	fp.current = (fp.current + 1) % len(fp.providers)
+	if fp.current == 0 {
+		log.Warningf("transport: all key providers failed: %v", err)
+		return false
+	}
+	log.Warningf("transport: key provider failed, failing over: %v", err)
+	return true
+}
+
+// SignCSR signs a CSR with the provider in use.
+func (fp *FailoverProvider) SignCSR(tpl *x509.CertificateRequest) ([]byte, error) {
+	return fp.Current().SignCSR(tpl)
+}
+
+// Store stores the key and certificate of the provider in use.
+func (fp *FailoverProvider) Store() error {
+	return fp.Current().Store()
+}
+
+// X509KeyPair returns the key pair of the provider in use.
+func (fp *FailoverProvider) X509KeyPair() (tls.Certificate, error) {
+	return fp.Current().X509KeyPair()
+}
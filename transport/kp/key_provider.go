@@ -10,6 +10,14 @@
 // retrieve the certificate from a CA first, falling back to a
 // disk-backed pair. This is useful for test a CA while providing a
 // failover solution.
+//
+// The EphemeralProvider keeps its key in memory only, and generates a
+// new one for each certificate. The PKCS11Provider keeps its key, and
+// certificate, on a PKCS #11 token such as an HSM; it is only built
+// with the pkcs11 build tag. On Linux, the KeyringProvider keeps them
+// in a kernel keyring, out of reach of the file system. A
+// FailoverProvider combines several providers, moving on to the next
+// when one fails. New builds the providers selected by an identity.
 package kp
 
 import (
@@ -22,6 +30,7 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"strings"
 
@@ -91,6 +100,58 @@ type KeyProvider interface {
 	X509KeyPair() (tls.Certificate, error)
 }
 
+// Providers maps the names of the key providers to the functions
+// building them from an identity. The "pkcs11" provider is only
+// available when built with the pkcs11 build tag, and the "keyring"
+// provider only on Linux.
+var Providers = map[string]func(*core.Identity) (KeyProvider, error){
+	"standard": func(id *core.Identity) (KeyProvider, error) {
+		sp, err := NewStandardProvider(id)
+		if err != nil {
+			return nil, err
+		}
+		return sp, nil
+	},
+	"ephemeral": func(id *core.Identity) (KeyProvider, error) {
+		return NewEphemeralProvider(), nil
+	},
+}
+
+// New builds the key provider selected by the "type" key of the
+// identity's "key_provider" profile, which defaults to "standard". The
+// type may list several comma-separated providers, such as
+// "pkcs11,standard", which are failed over in that order; see
+// FailoverProvider.
+func New(id *core.Identity) (KeyProvider, error) {
+	if id == nil {
+		return nil, errors.New("transport: the identity hasn't been initialised. Has it been loaded from disk?")
+	}
+
+	types := "standard"
+	if t := id.Profiles["key_provider"]["type"]; t != "" {
+		types = t
+	}
+
+	var providers []KeyProvider
+	for _, name := range strings.Split(types, ",") {
+		name = strings.TrimSpace(name)
+		newProvider, ok := Providers[name]
+		if !ok {
+			return nil, fmt.Errorf("transport: unsupported key provider %q", name)
+		}
+		kp, err := newProvider(id)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, kp)
+	}
+
+	if len(providers) == 1 {
+		return providers[0], nil
+	}
+	return NewFailoverProvider(providers...), nil
+}
+
 // StandardPaths contains a path to a key file and certificate file.
 type StandardPaths struct {
 	KeyFile  string `json:"private_key"`
@@ -190,17 +251,22 @@ func (sp *StandardProvider) Generate(algo string, size int) (err error) {
 	sp.resetKey()
 	sp.resetCert()
 
+	sp.internal.priv, sp.internal.keyPEM, err = generateKey(algo, size)
+	return err
+}
+
+// generateKey returns a new private key, and its PEM encoding.
+func generateKey(algo string, size int) (crypto.Signer, []byte, error) {
 	algo = strings.ToLower(algo)
 	switch algo {
 	case "rsa":
-		var priv *rsa.PrivateKey
 		if size < 2048 {
-			return errors.New("transport: RSA keys must be at least 2048 bits")
+			return nil, nil, errors.New("transport: RSA keys must be at least 2048 bits")
 		}
 
-		priv, err = rsa.GenerateKey(rand.Reader, size)
+		priv, err := rsa.GenerateKey(rand.Reader, size)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 
 		keyPEM := x509.MarshalPKCS1PrivateKey(priv)
@@ -208,45 +274,44 @@ func (sp *StandardProvider) Generate(algo string, size int) (err error) {
 			Type:  "RSA PRIVATE KEY",
 			Bytes: keyPEM,
 		}
-		sp.internal.keyPEM = pem.EncodeToMemory(p)
-		sp.internal.priv = priv
+		return priv, pem.EncodeToMemory(p), nil
 	case "ecdsa":
-		var priv *ecdsa.PrivateKey
-		var curve elliptic.Curve
-		switch size {
-		case curveP256:
-			curve = elliptic.P256()
-		case curveP384:
-			curve = elliptic.P384()
-		case curveP521:
-			curve = elliptic.P521()
-		default:
-			return errors.New("transport: invalid elliptic curve key size; only 256-, 384-, and 521-bit keys are accepted")
+		curve, err := curveForSize(size)
+		if err != nil {
+			return nil, nil, err
 		}
 
-		priv, err = ecdsa.GenerateKey(curve, rand.Reader)
+		priv, err := ecdsa.GenerateKey(curve, rand.Reader)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 
-		var keyPEM []byte
-		keyPEM, err = x509.MarshalECPrivateKey(priv)
+		keyPEM, err := x509.MarshalECPrivateKey(priv)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 
 		p := &pem.Block{
 			Type:  "EC PRIVATE KEY",
 			Bytes: keyPEM,
 		}
-		sp.internal.keyPEM = pem.EncodeToMemory(p)
-
-		sp.internal.priv = priv
+		return priv, pem.EncodeToMemory(p), nil
 	default:
-		return errors.New("transport: invalid key algorithm; only RSA and ECDSA are supported")
+		return nil, nil, errors.New("transport: invalid key algorithm; only RSA and ECDSA are supported")
 	}
+}
 
-	return nil
+func curveForSize(size int) (elliptic.Curve, error) {
+	switch size {
+	case curveP256:
+		return elliptic.P256(), nil
+	case curveP384:
+		return elliptic.P384(), nil
+	case curveP521:
+		return elliptic.P521(), nil
+	default:
+		return nil, errors.New("transport: invalid elliptic curve key size; only 256-, 384-, and 521-bit keys are accepted")
+	}
 }
 
 // Certificate returns the associated certificate, or nil if
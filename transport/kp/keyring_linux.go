@@ -0,0 +1,205 @@
+package kp
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/transport/core"
+)
+
+func init() {
+	Providers["keyring"] = func(id *core.Identity) (KeyProvider, error) {
+		kp, err := NewKeyringProvider(id)
+		if err != nil {
+			return nil, err
+		}
+		return kp, nil
+	}
+}
+
+// The special keyring IDs, and the keyctl operations used.
+const (
+	keySpecProcessKeyring = -2
+	keySpecSessionKeyring = -3
+	keySpecUserKeyring    = -4
+
+	keyctlUnlink = 9
+	keyctlSearch = 10
+	keyctlRead   = 11
+)
+
+var keyrings = map[string]int{
+	"process": keySpecProcessKeyring,
+	"session": keySpecSessionKeyring,
+	"user":    keySpecUserKeyring,
+}
+
+// KeyringProvider keeps its PEM-encoded private key and certificate in
+// a Linux kernel keyring, as "user" keys, rather than on disk. They
+// are only readable by processes allowed to search the keyring, and,
+// in the default user keyring, last until the user's last session
+// ends.
+type KeyringProvider struct {
+	StandardProvider
+
+	// Description names the keys: the private key is stored as
+	// Description + ":key", and the certificate as Description +
+	// ":cert".
+	Description string
+
+	keyring int
+}
+
+// NewKeyringProvider sets up a KeyringProvider from the "keyring"
+// profile of an identity: its "description" key names the keys, and
+// defaults to "cfssl:" followed by the request's common name, and its
+// "keyring" key is one of "user" (the default), "session" or "process".
+func NewKeyringProvider(id *core.Identity) (*KeyringProvider, error) {
+	if id == nil {
+		return nil, errors.New("transport: the identity hasn't been initialised. Has it been loaded from disk?")
+	}
+
+	profile := id.Profiles["keyring"]
+	kp := &KeyringProvider{
+		Description: profile["description"],
+		keyring:     keySpecUserKeyring,
+	}
+	if kp.Description == "" && id.Request != nil {
+		kp.Description = "cfssl:" + id.Request.CN
+	}
+	if name, ok := profile["keyring"]; ok {
+		if kp.keyring, ok = keyrings[name]; !ok {
+			return nil, fmt.Errorf("transport: unknown keyring %q", name)
+		}
+	}
+
+	if err := kp.Check(); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// Check ensures that the keys are named.
+func (kp *KeyringProvider) Check() error {
+	if kp.Description == "" {
+		return errors.New("transport: keyring provider requires a description")
+	}
+	return nil
+}
+
+// Persistent returns true: the key and certificate are stored in the
+// keyring.
+func (kp *KeyringProvider) Persistent() bool {
+	return true
+}
+
+// Load reads the private key and certificate from the keyring.
+func (kp *KeyringProvider) Load() (err error) {
+	defer func() {
+		if err != nil && err != ErrCertificateUnavailable {
+			kp.resetKey()
+			kp.resetCert()
+		}
+	}()
+
+	kp.internal.keyPEM, err = keyringRead(kp.keyring, kp.Description+":key")
+	if err != nil {
+		return err
+	}
+	kp.internal.priv, err = helpers.ParsePrivateKeyPEM(kp.internal.keyPEM)
+	if err != nil {
+		return err
+	}
+
+	certPEM, err := keyringRead(kp.keyring, kp.Description+":cert")
+	if err != nil {
+		kp.resetCert()
+		return ErrCertificateUnavailable
+	}
+	if err = kp.SetCertificatePEM(certPEM); err != nil {
+		return err
+	}
+	if !publicKeysMatch(kp.internal.cert.PublicKey, kp.internal.priv.Public()) {
+		return errors.New("transport: certificate in keyring does not match the private key")
+	}
+	return nil
+}
+
+// Store writes the private key and certificate to the keyring,
+// replacing any stored before.
+func (kp *KeyringProvider) Store() error {
+	if !kp.Ready() {
+		return errors.New("transport: provider does not have a key and certificate")
+	}
+
+	if err := keyringAdd(kp.keyring, kp.Description+":key", kp.internal.keyPEM); err != nil {
+		return err
+	}
+	return keyringAdd(kp.keyring, kp.Description+":cert", kp.internal.certPEM)
+}
+
+// Remove unlinks the private key and certificate from the keyring.
+func (kp *KeyringProvider) Remove() error {
+	for _, suffix := range []string{":key", ":cert"} {
+		id, err := keyringSearch(kp.keyring, kp.Description+suffix)
+		if err != nil {
+			continue
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_KEYCTL, keyctlUnlink, uintptr(id), uintptr(kp.keyring)); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
+
+var userKeyType = []byte("user\x00")
+
+// keyringAdd adds a user key to the keyring, or updates it.
+func keyringAdd(keyring int, description string, payload []byte) error {
+	desc := append([]byte(description), 0)
+	_, _, errno := syscall.Syscall6(syscall.SYS_ADD_KEY,
+		uintptr(unsafe.Pointer(&userKeyType[0])), uintptr(unsafe.Pointer(&desc[0])),
+		uintptr(unsafe.Pointer(&payload[0])), uintptr(len(payload)), uintptr(keyring), 0)
+	if errno != 0 {
+		return fmt.Errorf("transport: adding %s to keyring: %v", description, errno)
+	}
+	return nil
+}
+
+// keyringSearch returns the ID of the user key in the keyring.
+func keyringSearch(keyring int, description string) (int, error) {
+	desc := append([]byte(description), 0)
+	id, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlSearch, uintptr(keyring),
+		uintptr(unsafe.Pointer(&userKeyType[0])), uintptr(unsafe.Pointer(&desc[0])), 0, 0)
+	if errno != 0 {
+		return 0, fmt.Errorf("transport: finding %s in keyring: %v", description, errno)
+	}
+	return int(id), nil
+}
+
+// keyringRead returns the payload of the user key in the keyring.
+func keyringRead(keyring int, description string) ([]byte, error) {
+	id, err := keyringSearch(keyring, description)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		size, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlRead, uintptr(id), 0, 0, 0, 0)
+		if errno != 0 {
+			return nil, fmt.Errorf("transport: reading %s from keyring: %v", description, errno)
+		}
+		buf := make([]byte, size)
+		n, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlRead, uintptr(id),
+			uintptr(unsafe.Pointer(&buf[0])), size, 0, 0)
+		if errno != 0 {
+			return nil, fmt.Errorf("transport: reading %s from keyring: %v", description, errno)
+		}
+		// The key may have grown in between.
+		if n <= size {
+			return buf[:n], nil
+		}
+	}
+}
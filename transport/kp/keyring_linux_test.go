@@ -0,0 +1,60 @@
+package kp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/transport/core"
+)
+
+func TestKeyringProvider(t *testing.T) {
+	id := &core.Identity{
+		Request: testRequest,
+		Profiles: map[string]map[string]string{
+			"keyring": {
+				"description": fmt.Sprintf("cfssl-test:%d", time.Now().UnixNano()),
+				"keyring":     "process",
+			},
+		},
+	}
+	kp, err := NewKeyringProvider(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = keyringAdd(kp.keyring, kp.Description+":probe", []byte("probe")); err != nil {
+		t.Skipf("kernel keyrings are unavailable: %v", err)
+	}
+	defer kp.Remove()
+
+	if err = kp.Load(); err == nil {
+		t.Fatal("empty keyring provided a key")
+	}
+	if err = kp.Generate("ecdsa", 256); err != nil {
+		t.Fatal(err)
+	}
+	csrPEM, err := kp.CertificateRequest(testRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kp.SetCertificatePEM(issue(t, csrPEM)); err != nil {
+		t.Fatal(err)
+	}
+	if err = kp.Store(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, _ := NewKeyringProvider(id)
+	if err = reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Ready() || !reloaded.Certificate().Equal(kp.Certificate()) {
+		t.Fatal("keyring did not provide the stored key and certificate")
+	}
+
+	if _, err = NewKeyringProvider(&core.Identity{Profiles: map[string]map[string]string{
+		"keyring": {"description": "x", "keyring": "thread"},
+	}}); err == nil {
+		t.Fatal("unknown keyring was accepted")
+	}
+}
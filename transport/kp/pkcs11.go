@@ -0,0 +1,643 @@
+// +build pkcs11
+
+package kp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/transport/core"
+	"github.com/miekg/pkcs11"
+)
+
+func init() {
+	Providers["pkcs11"] = func(id *core.Identity) (KeyProvider, error) {
+		kp, err := NewPKCS11Provider(id)
+		if err != nil {
+			return nil, err
+		}
+		return kp, nil
+	}
+}
+
+// PKCS11Config locates a key pair and certificate on a PKCS #11 token.
+type PKCS11Config struct {
+	// Module is the path to the PKCS #11 library.
+	Module string
+	// Token is the label of the token.
+	Token string
+	// PIN is the user PIN of the token.
+	PIN string
+	// Label is the label of the key pair and certificate objects.
+	Label string
+}
+
+// PKCS11Provider keeps its private key, which never leaves the token,
+// and its certificate on a PKCS #11 token, such as an HSM or SoftHSM.
+// Keys are generated on the token. It is safe for concurrent use, as
+// TLS handshakes sign with its key concurrently.
+type PKCS11Provider struct {
+	Config PKCS11Config
+
+	lock    sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	open    bool
+
+	id      []byte
+	priv    *pkcs11Signer
+	cert    *x509.Certificate
+	certPEM []byte
+}
+
+// NewPKCS11Provider sets up a PKCS11Provider from the "pkcs11" profile
+// of an identity, with the keys "module", "token", "pin" and "label";
+// the label defaults to "cfssl".
+func NewPKCS11Provider(id *core.Identity) (*PKCS11Provider, error) {
+	if id == nil {
+		return nil, errors.New("transport: the identity hasn't been initialised. Has it been loaded from disk?")
+	}
+
+	profile := id.Profiles["pkcs11"]
+	kp := &PKCS11Provider{
+		Config: PKCS11Config{
+			Module: profile["module"],
+			Token:  profile["token"],
+			PIN:    profile["pin"],
+			Label:  profile["label"],
+		},
+	}
+	if kp.Config.Label == "" {
+		kp.Config.Label = "cfssl"
+	}
+
+	if err := kp.Check(); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// Check ensures that the module and token are given.
+func (kp *PKCS11Provider) Check() error {
+	if kp.Config.Module == "" {
+		return errors.New("transport: PKCS #11 provider requires a module")
+	}
+	if kp.Config.Token == "" {
+		return errors.New("transport: PKCS #11 provider requires a token label")
+	}
+	return nil
+}
+
+// connect opens a logged in session on the token, unless one is open.
+// The lock must be held.
+func (kp *PKCS11Provider) connect() error {
+	if kp.open {
+		return nil
+	}
+
+	if kp.ctx == nil {
+		ctx := pkcs11.New(kp.Config.Module)
+		if ctx == nil {
+			return fmt.Errorf("transport: failed to load PKCS #11 module %s", kp.Config.Module)
+		}
+		if err := ctx.Initialize(); err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+			ctx.Destroy()
+			return err
+		}
+		kp.ctx = ctx
+	}
+
+	slots, err := kp.ctx.GetSlotList(true)
+	if err != nil {
+		return err
+	}
+	for _, slot := range slots {
+		info, err := kp.ctx.GetTokenInfo(slot)
+		if err != nil || strings.TrimRight(info.Label, " \x00") != kp.Config.Token {
+			continue
+		}
+
+		session, err := kp.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return err
+		}
+		err = kp.ctx.Login(session, pkcs11.CKU_USER, kp.Config.PIN)
+		if err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			kp.ctx.CloseSession(session)
+			return err
+		}
+		kp.session, kp.open = session, true
+		return nil
+	}
+	return fmt.Errorf("transport: PKCS #11 token %q not found", kp.Config.Token)
+}
+
+// disconnect closes the session, so that the next operation opens a
+// new one. The lock must be held.
+func (kp *PKCS11Provider) disconnect() {
+	if kp.open {
+		kp.ctx.CloseSession(kp.session)
+		kp.open = false
+	}
+}
+
+// find returns the objects of class with the provider's label, and, if
+// id is not nil, that ID. The lock must be held.
+func (kp *PKCS11Provider) find(class uint, id []byte) ([]pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, kp.Config.Label),
+	}
+	if id != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+	if err := kp.ctx.FindObjectsInit(kp.session, template); err != nil {
+		return nil, err
+	}
+	defer kp.ctx.FindObjectsFinal(kp.session)
+
+	var objects []pkcs11.ObjectHandle
+	for {
+		found, _, err := kp.ctx.FindObjects(kp.session, 16)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return objects, nil
+		}
+		objects = append(objects, found...)
+	}
+}
+
+// destroy removes the objects of class with the provider's label
+// whose ID differs from keep. The lock must be held.
+func (kp *PKCS11Provider) destroy(class uint, keep []byte) error {
+	objects, err := kp.find(class, nil)
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if bytes.Equal(kp.objectID(o), keep) {
+			continue
+		}
+		if err = kp.ctx.DestroyObject(kp.session, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectID returns the CKA_ID of an object. The lock must be held.
+func (kp *PKCS11Provider) objectID(o pkcs11.ObjectHandle) []byte {
+	attrs, err := kp.ctx.GetAttributeValue(kp.session, o, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_ID, nil)})
+	if err != nil {
+		return nil
+	}
+	return attrs[0].Value
+}
+
+var curveOIDs = map[int]asn1.ObjectIdentifier{
+	curveP256: {1, 2, 840, 10045, 3, 1, 7},
+	curveP384: {1, 3, 132, 0, 34},
+	curveP521: {1, 3, 132, 0, 35},
+}
+
+// Generate generates a new key pair on the token. The key pair stored
+// before, which may still be signing handshakes, is only removed once
+// a certificate for the new one is stored.
+func (kp *PKCS11Provider) Generate(algo string, size int) error {
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+
+	if err := kp.connect(); err != nil {
+		return err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	public := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, kp.Config.Label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+	private := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, kp.Config.Label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+
+	var mech uint
+	switch strings.ToLower(algo) {
+	case "rsa":
+		if size < 2048 {
+			return errors.New("transport: RSA keys must be at least 2048 bits")
+		}
+		mech = pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, size),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}))
+	case "ecdsa":
+		oid, ok := curveOIDs[size]
+		if !ok {
+			return errors.New("transport: invalid elliptic curve key size; only 256-, 384-, and 521-bit keys are accepted")
+		}
+		params, err := asn1.Marshal(oid)
+		if err != nil {
+			return err
+		}
+		mech = pkcs11.CKM_EC_KEY_PAIR_GEN
+		public = append(public, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params))
+	default:
+		return errors.New("transport: invalid key algorithm; only RSA and ECDSA are supported")
+	}
+
+	pubHandle, privHandle, err := kp.ctx.GenerateKeyPair(kp.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(mech, nil)}, public, private)
+	if err != nil {
+		return err
+	}
+	signer, err := kp.signer(pubHandle, privHandle)
+	if err != nil {
+		return err
+	}
+
+	kp.id, kp.priv = id, signer
+	kp.cert, kp.certPEM = nil, nil
+	return nil
+}
+
+// signer returns a signer using the private key object, whose public
+// key is read from the public key object. The lock must be held.
+func (kp *PKCS11Provider) signer(pubHandle, privHandle pkcs11.ObjectHandle) (*pkcs11Signer, error) {
+	attrs, err := kp.ctx.GetAttributeValue(kp.session, pubHandle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pub crypto.PublicKey
+	switch keyType := new(big.Int).SetBytes(reverse(attrs[0].Value)).Uint64(); keyType {
+	case pkcs11.CKK_RSA:
+		attrs, err = kp.ctx.GetAttributeValue(kp.session, pubHandle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		pub = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}
+	case pkcs11.CKK_EC:
+		attrs, err = kp.ctx.GetAttributeValue(kp.session, pubHandle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		pub, err = ecPublicKey(attrs[0].Value, attrs[1].Value)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("transport: unsupported PKCS #11 key type %d", keyType)
+	}
+
+	return &pkcs11Signer{kp: kp, handle: privHandle, pub: pub}, nil
+}
+
+// reverse returns the big-endian form of a little-endian CK_ULONG, as
+// PKCS #11 attributes are returned in host order.
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func ecPublicKey(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, err
+	}
+	var curve elliptic.Curve
+	for size, curveOID := range curveOIDs {
+		if oid.Equal(curveOID) {
+			curve, _ = curveForSize(size)
+		}
+	}
+	if curve == nil {
+		return nil, errors.New("transport: unsupported PKCS #11 elliptic curve")
+	}
+
+	// The point is usually wrapped in an OCTET STRING.
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err != nil || len(rest) != 0 {
+		raw = point
+	}
+	x, y := elliptic.Unmarshal(curve, raw)
+	if x == nil {
+		return nil, errors.New("transport: invalid PKCS #11 elliptic curve point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// Load finds the key pair and certificate with the provider's label on
+// the token, preferring a key pair that has a certificate.
+func (kp *PKCS11Provider) Load() error {
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+
+	kp.priv, kp.cert, kp.certPEM = nil, nil, nil
+	if err := kp.connect(); err != nil {
+		return err
+	}
+
+	privs, err := kp.find(pkcs11.CKO_PRIVATE_KEY, nil)
+	if err != nil {
+		return err
+	}
+	for _, priv := range privs {
+		id := kp.objectID(priv)
+		pubs, err := kp.find(pkcs11.CKO_PUBLIC_KEY, id)
+		if err != nil || len(pubs) == 0 {
+			continue
+		}
+		signer, err := kp.signer(pubs[0], priv)
+		if err != nil {
+			continue
+		}
+		if kp.priv == nil {
+			kp.id, kp.priv = id, signer
+		}
+
+		cert := kp.certificate(id)
+		if cert != nil && publicKeysMatch(cert.PublicKey, signer.pub) {
+			kp.id, kp.priv = id, signer
+			kp.cert, kp.certPEM = cert, helpers.EncodeCertificatePEM(cert)
+			return nil
+		}
+	}
+
+	if kp.priv == nil {
+		return errors.New("transport: no PKCS #11 key pair found")
+	}
+	return ErrCertificateUnavailable
+}
+
+// certificate returns the certificate with the ID on the token, or nil.
+// The lock must be held.
+func (kp *PKCS11Provider) certificate(id []byte) *x509.Certificate {
+	certs, err := kp.find(pkcs11.CKO_CERTIFICATE, id)
+	if err != nil || len(certs) == 0 {
+		return nil
+	}
+	attrs, err := kp.ctx.GetAttributeValue(kp.session, certs[0], []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)})
+	if err != nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(attrs[0].Value)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// Certificate returns the associated certificate, or nil if
+// one isn't ready.
+func (kp *PKCS11Provider) Certificate() *x509.Certificate {
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+	return kp.cert
+}
+
+// CertificateRequest returns a certificate signing request for the key
+// on the token, generating one first if there is none.
+func (kp *PKCS11Provider) CertificateRequest(req *csr.CertificateRequest) ([]byte, error) {
+	kp.lock.Lock()
+	priv := kp.priv
+	kp.lock.Unlock()
+	if priv == nil {
+		if req.KeyRequest == nil {
+			return nil, errors.New("transport: invalid key request in csr.CertificateRequest")
+		}
+		if err := kp.Generate(req.KeyRequest.Algo(), req.KeyRequest.Size()); err != nil {
+			return nil, err
+		}
+		kp.lock.Lock()
+		priv = kp.priv
+		kp.lock.Unlock()
+	}
+	return csr.Generate(priv, req)
+}
+
+// Persistent returns true: the key and certificate are kept on the
+// token.
+func (kp *PKCS11Provider) Persistent() bool {
+	return true
+}
+
+// Ready returns true if the provider has a key and certificate.
+func (kp *PKCS11Provider) Ready() bool {
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+	return kp.priv != nil && kp.cert != nil
+}
+
+// SetCertificatePEM associates a certificate for the token's key with
+// the provider.
+func (kp *PKCS11Provider) SetCertificatePEM(certPEM []byte) error {
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return errors.New("transport: invalid certificate")
+	}
+
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+	if kp.priv == nil || !publicKeysMatch(cert.PublicKey, kp.priv.pub) {
+		return errors.New("transport: certificate does not match the PKCS #11 key")
+	}
+	kp.cert, kp.certPEM = cert, certPEM
+	return nil
+}
+
+// SignalFailure closes the session, so that the token is reopened at
+// the next attempt, and returns false.
+func (kp *PKCS11Provider) SignalFailure(err error) bool {
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+	kp.disconnect()
+	return false
+}
+
+// SignCSR takes a template certificate request and signs it.
+func (kp *PKCS11Provider) SignCSR(tpl *x509.CertificateRequest) ([]byte, error) {
+	kp.lock.Lock()
+	priv := kp.priv
+	kp.lock.Unlock()
+	if priv == nil {
+		return nil, errors.New("transport: no PKCS #11 key is loaded")
+	}
+	return x509.CreateCertificateRequest(rand.Reader, tpl, priv)
+}
+
+// Store writes the certificate to the token, and removes the key pairs
+// and certificates stored before.
+func (kp *PKCS11Provider) Store() error {
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+
+	if kp.priv == nil || kp.cert == nil {
+		return errors.New("transport: provider does not have a key and certificate")
+	}
+	if err := kp.connect(); err != nil {
+		return err
+	}
+
+	certs, err := kp.find(pkcs11.CKO_CERTIFICATE, kp.id)
+	if err != nil {
+		return err
+	}
+	for _, o := range certs {
+		if err = kp.ctx.DestroyObject(kp.session, o); err != nil {
+			return err
+		}
+	}
+	_, err = kp.ctx.CreateObject(kp.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+		pkcs11.NewAttribute(pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKC_X_509),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, kp.Config.Label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, kp.id),
+		pkcs11.NewAttribute(pkcs11.CKA_SUBJECT, kp.cert.RawSubject),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, kp.cert.Raw),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Older key pairs, and their certificates, are of no further use.
+	for _, class := range []uint{pkcs11.CKO_CERTIFICATE, pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_PUBLIC_KEY} {
+		if err = kp.destroy(class, kp.id); err != nil {
+			log.Warningf("transport: failed to remove old PKCS #11 objects: %v", err)
+		}
+	}
+	return nil
+}
+
+// X509KeyPair returns a tls.Certificate for the provider, whose key
+// signs on the token.
+func (kp *PKCS11Provider) X509KeyPair() (tls.Certificate, error) {
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+	if kp.priv == nil || kp.cert == nil {
+		return tls.Certificate{}, errors.New("transport: provider does not have a key and certificate")
+	}
+	return signerKeyPair(kp.priv, kp.cert), nil
+}
+
+// pkcs11Signer is a crypto.Signer whose private key is on a token.
+type pkcs11Signer struct {
+	kp     *PKCS11Provider
+	handle pkcs11.ObjectHandle
+	pub    crypto.PublicKey
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// digestInfoPrefixes are the DER prefixes of the DigestInfo structures
+// signed by RSA PKCS #1 v1.5 signatures.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pssHashes are the PKCS #11 hash mechanisms and MGFs for RSA-PSS.
+var pssHashes = map[crypto.Hash][2]uint{
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+// Sign signs digest on the token.
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mech *pkcs11.Mechanism
+	msg := digest
+	switch s.pub.(type) {
+	case *ecdsa.PublicKey:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			hash, ok := pssHashes[pss.Hash]
+			if !ok {
+				return nil, errors.New("transport: unsupported RSA-PSS hash")
+			}
+			saltLength := pss.SaltLength
+			if saltLength == rsa.PSSSaltLengthEqualsHash || saltLength == rsa.PSSSaltLengthAuto {
+				saltLength = pss.Hash.Size()
+			}
+			mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(hash[0], hash[1], uint(saltLength)))
+			break
+		}
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, errors.New("transport: unsupported RSA signature hash")
+		}
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+		msg = append(append([]byte{}, prefix...), digest...)
+	}
+
+	s.kp.lock.Lock()
+	defer s.kp.lock.Unlock()
+	if err := s.kp.connect(); err != nil {
+		return nil, err
+	}
+	if err := s.kp.ctx.SignInit(s.kp.session, []*pkcs11.Mechanism{mech}, s.handle); err != nil {
+		return nil, err
+	}
+	sig, err := s.kp.ctx.Sign(s.kp.session, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := s.pub.(*ecdsa.PublicKey); ok {
+		// PKCS #11 returns r and s concatenated.
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sig[:half]),
+			new(big.Int).SetBytes(sig[half:]),
+		})
+	}
+	return sig, nil
+}
@@ -0,0 +1,94 @@
+// +build pkcs11
+
+package kp
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+
+	"github.com/cloudflare/cfssl/transport/core"
+)
+
+// TestPKCS11Provider runs against the token named by PKCS11_TOKEN in
+// the module at PKCS11_MODULE, such as a SoftHSM token set up with
+//
+//	softhsm2-util --init-token --free --label cfssl --pin 1234 --so-pin 1234
+func TestPKCS11Provider(t *testing.T) {
+	module := os.Getenv("PKCS11_MODULE")
+	if module == "" {
+		t.Skip("PKCS11_MODULE is not set")
+	}
+	id := &core.Identity{
+		Request: testRequest,
+		Profiles: map[string]map[string]string{
+			"pkcs11": {
+				"module": module,
+				"token":  os.Getenv("PKCS11_TOKEN"),
+				"pin":    os.Getenv("PKCS11_PIN"),
+				"label":  "cfssl-test",
+			},
+		},
+	}
+	kp, err := NewPKCS11Provider(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algo := range []struct {
+		name string
+		size int
+	}{{"ecdsa", 256}, {"rsa", 2048}} {
+		if err = kp.Generate(algo.name, algo.size); err != nil {
+			t.Fatal(err)
+		}
+		csrPEM, err := kp.CertificateRequest(testRequest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = kp.SetCertificatePEM(issue(t, csrPEM)); err != nil {
+			t.Fatal(err)
+		}
+		if err = kp.Store(); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded, _ := NewPKCS11Provider(id)
+		if err = reloaded.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if !reloaded.Certificate().Equal(kp.Certificate()) {
+			t.Fatalf("%s: token did not provide the stored certificate", algo.name)
+		}
+
+		// The key pair signs TLS handshakes from the token.
+		pair, err := reloaded.X509KeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := &tls.Config{Certificates: []tls.Certificate{pair}}
+		if err = handshake(cfg); err != nil {
+			t.Fatalf("%s: handshake failed: %v", algo.name, err)
+		}
+	}
+}
+
+func handshake(serverConfig *tls.Config) error {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
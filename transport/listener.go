@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"time"
@@ -41,76 +42,32 @@ func (tr *Transport) getConfig() (*tls.Config, error) {
 // been found.
 var PollInterval = 30 * time.Second
 
-func pollWait(target time.Time) {
-	for {
-		<-time.After(PollInterval)
-		if time.Now().After(target) {
-			break
-		}
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-// AutoUpdate will automatically update the listener. If a non-nil
-// certUpdates chan is provided, it will receive timestamps for
-// reissued certificates. If errChan is non-nil, any errors that occur
-// in the updater will be passed along.
-func (l *Listener) AutoUpdate(certUpdates chan<- time.Time, errChan chan<- error) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Criticalf("AutoUpdate panicked: %v", r)
-		}
-	}()
-
+func pollWait(ctx context.Context, target time.Time) error {
 	for {
-		// Wait until it's time to update the certificate.
-		target := time.Now().Add(l.Lifespan())
-		if PollInterval == 0 {
-			<-time.After(l.Lifespan())
-		} else {
-			pollWait(target)
-		}
-
-		// Keep trying to update the certificate until it's
-		// ready.
-		for {
-			log.Debug("refreshing certificate")
-			err := l.RefreshKeys()
-			if err == nil {
-				break
-			}
-
-			delay := l.Transport.Backoff.Duration()
-			log.Debugf("failed to update certificate, will try again in %s", delay)
-			if errChan != nil {
-				errChan <- err
-			}
-
-			<-time.After(delay)
+		if err := sleep(ctx, PollInterval); err != nil {
+			return err
 		}
-
-		if certUpdates != nil {
-			certUpdates <- time.Now()
-		}
-
-		config, err := l.getConfig()
-		if err != nil {
-			log.Debug("immediately after getting a new certificate, the Transport is reporting errors: %v", err)
-			if errChan != nil {
-				errChan <- err
-			}
-		}
-
-		address := l.Listener.Addr().String()
-		lnet := l.Listener.Addr().Network()
-		l.Listener, err = tls.Listen(lnet, address, config)
-		if err != nil {
-			log.Debug("immediately after getting a new certificate, the Transport is reporting errors: %v", err)
-			if errChan != nil {
-				errChan <- err
-			}
+		if time.Now().After(target) {
+			return nil
 		}
-
-		log.Debug("listener: auto update of certificate complete")
-		l.Transport.Backoff.Reset()
 	}
 }
+
+// AutoUpdate will automatically update the listener's certificate. As
+// the listener's configuration presents the transport's current
+// certificate, the listener itself is left as it is.
+func (l *Listener) AutoUpdate(certUpdates chan<- time.Time, errChan chan<- error) {
+	l.Transport.AutoUpdate(certUpdates, errChan)
+}
@@ -12,4 +12,34 @@
 // The "file" provider takes a source file (specified under the
 // "source" key) that contains one or more certificates and adds
 // them into the source tree.
+//
+// The "dir" provider takes a directory (under the "source" key), and
+// loads the certificates in all of its files. It is read again at each
+// refresh, so roots are added or removed by changing its files.
+//
+// The "url" provider fetches a PEM bundle from the "url" key over
+// HTTPS, and trusts it only if its signature, fetched from the
+// "signature-url" key (by default, the bundle's URL with ".sig"
+// appended), verifies with the PEM public key in the file under the
+// "signature-key" key, and the bundle was signed within the "max-age"
+// duration, a week by default. The signature covers the signing time,
+// so that an old bundle can't be replayed; see NewURL for its format.
+//
+// The "spiffe" provider fetches the X.509 authorities of a SPIFFE
+// trust domain from the bundle endpoint under the "url" key.
+//
+// The "cfssl", "url" and "spiffe" providers verify the remote server
+// against the CAs in the file under the "tls-remote-ca" key if there is
+// one, or else the system roots.
+//
+// Any root may list, under the "pins" key, the comma-separated pins
+// (see Pin) of the certificates it may provide: a source providing any
+// other certificate fails to load, which guards against a compromised
+// remote. A root may also give a "refresh" interval, such as "1h".
+//
+// A TrustStore may be reloaded from its sources by Refresh, or
+// periodically by AutoRefresh; a source that fails to reload keeps the
+// certificates it provided before. The transport's configurations
+// consult their trust stores at each handshake, so clients and servers
+// are always verified against the current roots.
 package roots
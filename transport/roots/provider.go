@@ -1,12 +1,21 @@
 package roots
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/transport/core"
 	"github.com/cloudflare/cfssl/transport/roots/system"
 )
@@ -17,17 +26,38 @@ var Providers = map[string]func(map[string]string) ([]*x509.Certificate, error){
 	"system": system.New,
 	"cfssl":  NewCFSSL,
 	"file":   TrustPEM,
+	"dir":    TrustDir,
+	"url":    NewURL,
+	"spiffe": NewSPIFFE,
 }
 
 // A TrustStore contains a pool of certificate that are trusted for a
-// given TLS configuration.
+// given TLS configuration. It is safe for concurrent use: the
+// transport's TLS configurations consult it at each handshake, so a
+// refreshed store takes effect on live connections' next handshake.
 type TrustStore struct {
+	lock  sync.RWMutex
 	roots map[string]*x509.Certificate
+
+	// refreshLock serialises refreshes of the sources.
+	refreshLock sync.Mutex
+	sources     []*source
+}
+
+// A source is one of the roots the store was built from, with the
+// certificates it last provided.
+type source struct {
+	root  *core.Root
+	every time.Duration
+	next  time.Time
+	certs []*x509.Certificate
 }
 
 // Pool returns a certificate pool containing the certificates
 // loaded into the provider.
 func (ts *TrustStore) Pool() *x509.CertPool {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
 	var pool = x509.NewCertPool()
 	for _, cert := range ts.roots {
 		pool.AddCert(cert)
@@ -37,6 +67,8 @@ func (ts *TrustStore) Pool() *x509.CertPool {
 
 // Certificates returns a slice of the loaded certificates.
 func (ts *TrustStore) Certificates() []*x509.Certificate {
+	ts.lock.RLock()
+	defer ts.lock.RUnlock()
 	var roots = make([]*x509.Certificate, 0, len(ts.roots))
 	for _, cert := range ts.roots {
 		roots = append(roots, cert)
@@ -55,6 +87,113 @@ func (ts *TrustStore) addCerts(certs []*x509.Certificate) {
 	}
 }
 
+// Refresh reloads every source of the store. A source that fails to
+// load keeps the certificates it provided before, and the first such
+// error is returned once the other sources have been reloaded.
+func (ts *TrustStore) Refresh() error {
+	return ts.refresh(time.Time{}, 0)
+}
+
+// refresh reloads the sources due at now, or all of them if now is
+// zero. Sources without a refresh interval of their own are next due
+// after interval.
+func (ts *TrustStore) refresh(now time.Time, interval time.Duration) error {
+	ts.refreshLock.Lock()
+	defer ts.refreshLock.Unlock()
+
+	var firstErr error
+	for _, src := range ts.sources {
+		if !now.IsZero() && now.Before(src.next) {
+			continue
+		}
+		every := src.every
+		if every == 0 {
+			every = interval
+		}
+		src.next = time.Now().Add(every)
+
+		certs, err := load(src.root)
+		if err != nil {
+			log.Warningf("transport: failed to refresh %s root: %v", src.root.Type, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		src.certs = certs
+	}
+
+	ts.lock.Lock()
+	old := ts.roots
+	ts.roots = nil
+	for _, src := range ts.sources {
+		ts.addCerts(src.certs)
+	}
+	changed := !sameRoots(old, ts.roots)
+	ts.lock.Unlock()
+
+	if changed {
+		log.Info("transport: trusted roots changed")
+	}
+	return firstErr
+}
+
+func sameRoots(a, b map[string]*x509.Certificate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for digest := range a {
+		if _, ok := b[digest]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// untilDue returns how long until the next source is due for a refresh.
+func (ts *TrustStore) untilDue(interval time.Duration) time.Duration {
+	ts.refreshLock.Lock()
+	defer ts.refreshLock.Unlock()
+	wait := interval
+	for _, src := range ts.sources {
+		if d := time.Until(src.next); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// AutoRefresh reloads the sources of the store periodically, until ctx
+// is done, when it returns ctx.Err(). Each source is reloaded at the
+// interval given by its "refresh" metadata key, a duration such as
+// "1h", or else at interval. Failures are logged and, if errChan is not
+// nil, sent on it; the store keeps the certificates last loaded.
+func (ts *TrustStore) AutoRefresh(ctx context.Context, interval time.Duration, errChan chan<- error) error {
+	if interval <= 0 {
+		return errors.New("transport: refresh interval must be positive")
+	}
+	for {
+		timer := time.NewTimer(ts.untilDue(interval))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if err := ts.refresh(time.Now(), interval); err != nil && errChan != nil {
+			select {
+			case errChan <- err:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
 // Trusted contains a store of trusted certificates.
 type Trusted interface {
 	// Certificates returns a slice containing the certificates
@@ -70,40 +209,73 @@ type Trusted interface {
 }
 
 // New produces a new trusted root provider from a collection of
-// roots. If there are no roots, the system roots will be used.
+// roots. If there are no roots, the system roots will be used. Roots
+// of unknown types are ignored, but at least one must be supported.
 func New(rootDefs []*core.Root) (*TrustStore, error) {
-	var err error
+	if len(rootDefs) == 0 {
+		rootDefs = []*core.Root{{Type: "system"}}
+	}
 
 	var store = &TrustStore{}
-	var roots []*x509.Certificate
+	for _, root := range rootDefs {
+		if _, ok := Providers[root.Type]; !ok {
+			continue
+		}
 
-	if len(rootDefs) == 0 {
-		roots, err = system.New(nil)
+		src := &source{root: root}
+		if every, ok := root.Metadata["refresh"]; ok {
+			d, err := time.ParseDuration(every)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("transport: invalid refresh interval %q for %s root", every, root.Type)
+			}
+			src.every = d
+		}
+
+		certs, err := load(root)
 		if err != nil {
 			return nil, err
 		}
+		src.certs = certs
+		store.sources = append(store.sources, src)
+		store.addCerts(certs)
+	}
 
-		store.addCerts(roots)
-		return store, nil
+	if len(store.sources) == 0 {
+		return nil, errors.New("transport: no supported root providers found")
 	}
+	return store, nil
+}
 
-	err = errors.New("transport: no supported root providers found")
-	for _, root := range rootDefs {
-		pfn, ok := Providers[root.Type]
-		if ok {
-			roots, err = pfn(root.Metadata)
-			if err != nil {
-				break
-			}
+// load returns the certificates provided by root, which must all be
+// pinned if the root lists pins.
+func load(root *core.Root) ([]*x509.Certificate, error) {
+	certs, err := Providers[root.Type](root.Metadata)
+	if err != nil {
+		return nil, err
+	}
 
-			store.addCerts(roots)
+	pins, ok := root.Metadata["pins"]
+	if !ok {
+		return certs, nil
+	}
+	pinned := map[string]bool{}
+	for _, pin := range strings.Split(pins, ",") {
+		pinned[strings.TrimSpace(pin)] = true
+	}
+	for _, cert := range certs {
+		if !pinned[Pin(cert)] {
+			return nil, fmt.Errorf("transport: %s root %q is not pinned", root.Type, cert.Subject.CommonName)
 		}
 	}
+	return certs, nil
+}
 
-	if err != nil {
-		store = nil
-	}
-	return store, err
+// Pin returns the pin of cert: the base64-encoded SHA-256 digest of
+// its subject public key info, as listed under the "pins" key of a
+// root's metadata.
+func Pin(cert *x509.Certificate) string {
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(digest[:])
 }
 
 // TrustPEM takes a source file containing one or more certificates
@@ -121,3 +293,47 @@ func TrustPEM(metadata map[string]string) ([]*x509.Certificate, error) {
 
 	return helpers.ParseCertificatesPEM(in)
 }
+
+// TrustDir loads the certificates in the files of a directory
+// (specified under the "source" key). Files that do not contain PEM
+// certificates are skipped, as are subdirectories and hidden files; the
+// directory is read again at each refresh, so that roots may be added
+// and removed by changing its files.
+func TrustDir(metadata map[string]string) ([]*x509.Certificate, error) {
+	dir, ok := metadata["source"]
+	if !ok {
+		return nil, errors.New("transport: directory source requires a source directory")
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []*x509.Certificate
+	for _, fi := range files {
+		path := filepath.Join(dir, fi.Name())
+		if strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		// Stat follows symlinks, such as those of mounted secrets.
+		if fi, err = os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		in, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := helpers.ParseCertificatesPEM(in)
+		if err != nil || len(certs) == 0 {
+			log.Debugf("transport: skipping %s in root directory", fi.Name())
+			continue
+		}
+		roots = append(roots, certs...)
+	}
+
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("transport: no certificates found in %s", dir)
+	}
+	return roots, nil
+}
@@ -0,0 +1,324 @@
+package roots
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/transport/core"
+	"golang.org/x/crypto/ed25519"
+)
+
+func newRoot(t *testing.T, cn string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "roots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func writeRoot(t *testing.T, path string, cert *x509.Certificate) {
+	if err := ioutil.WriteFile(path, helpers.EncodeCertificatePEM(cert), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func names(ts *TrustStore) map[string]bool {
+	names := map[string]bool{}
+	for _, cert := range ts.Certificates() {
+		names[cert.Subject.CommonName] = true
+	}
+	return names
+}
+
+func TestDirRefresh(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	writeRoot(t, filepath.Join(dir, "first.pem"), newRoot(t, "first"))
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0644)
+
+	store, err := New([]*core.Root{{Type: "dir", Metadata: map[string]string{"source": dir}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(store); !got["first"] || len(got) != 1 {
+		t.Fatalf("roots %v after an unchanged refresh", got)
+	}
+
+	writeRoot(t, filepath.Join(dir, "second.pem"), newRoot(t, "second"))
+	if err = store.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(store); !got["first"] || !got["second"] {
+		t.Fatalf("roots %v after adding a file", got)
+	}
+
+	os.Remove(filepath.Join(dir, "first.pem"))
+	if err = store.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(store); got["first"] {
+		t.Fatalf("roots %v after removing a file", got)
+	}
+
+	// A failing source keeps its roots.
+	os.RemoveAll(dir)
+	if err = store.Refresh(); err == nil {
+		t.Fatal("refresh of a missing directory succeeded")
+	}
+	if got := names(store); !got["second"] {
+		t.Fatalf("roots %v after a failed refresh", got)
+	}
+}
+
+func TestPins(t *testing.T) {
+	root := newRoot(t, "pinned")
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "root.pem")
+	writeRoot(t, path, root)
+
+	digest := sha256.Sum256(root.RawSubjectPublicKeyInfo)
+	if Pin(root) != base64.StdEncoding.EncodeToString(digest[:]) {
+		t.Fatal("pin is not the SPKI digest")
+	}
+
+	other := Pin(newRoot(t, "other"))
+	if _, err := New([]*core.Root{{Type: "file", Metadata: map[string]string{"source": path, "pins": other}}}); err == nil {
+		t.Fatal("unpinned root was trusted")
+	}
+	store, err := New([]*core.Root{{Type: "file", Metadata: map[string]string{"source": path, "pins": other + ", " + Pin(root)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !names(store)["pinned"] {
+		t.Fatal("pinned root was not trusted")
+	}
+}
+
+// newBundleServer serves files over HTTPS, and returns the file holding
+// its certificate, in dir.
+func newBundleServer(t *testing.T, dir string, files map[string][]byte) (*httptest.Server, string) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(body)
+	}))
+	ca := filepath.Join(dir, "server.pem")
+	writeRoot(t, ca, server.Certificate())
+	return server, ca
+}
+
+// signBundle returns the signature file of a bundle signed by key at
+// signedAt.
+func signBundle(t *testing.T, key *ecdsa.PrivateKey, bundle []byte, signedAt time.Time) []byte {
+	stamp := signedAt.UTC().Format(time.RFC3339)
+	digest := sha256.Sum256(append([]byte(stamp+"\n"), bundle...))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(stamp + "\n" + base64.StdEncoding.EncodeToString(sig) + "\n")
+}
+
+func TestURLProvider(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+
+	bundle := helpers.EncodeCertificatePEM(newRoot(t, "remote"))
+	files := map[string][]byte{
+		"/roots.pem":     bundle,
+		"/roots.pem.sig": signBundle(t, key, bundle, time.Now()),
+	}
+	server, ca := newBundleServer(t, dir, files)
+	defer server.Close()
+	metadata := map[string]string{
+		"url":           server.URL + "/roots.pem",
+		"signature-key": keyFile,
+		"tls-remote-ca": ca,
+	}
+
+	roots, err := NewURL(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || roots[0].Subject.CommonName != "remote" {
+		t.Fatal("wrong roots loaded from the bundle")
+	}
+
+	// A bundle signed too long ago is not trusted, however well
+	// signed.
+	files["/roots.pem.sig"] = signBundle(t, key, bundle, time.Now().Add(-DefaultBundleMaxAge-time.Hour))
+	if _, err = NewURL(metadata); err == nil {
+		t.Fatal("stale bundle was trusted")
+	}
+	metadata["max-age"] = "720h"
+	if _, err = NewURL(metadata); err != nil {
+		t.Fatalf("bundle within its max-age was not trusted: %v", err)
+	}
+	files["/roots.pem.sig"] = signBundle(t, key, bundle, time.Now().Add(time.Hour))
+	if _, err = NewURL(metadata); err == nil {
+		t.Fatal("bundle signed in the future was trusted")
+	}
+
+	// The signing time is signed.
+	sig := signBundle(t, key, bundle, time.Now().Add(-800*time.Hour))
+	stale := time.Now().Add(-800 * time.Hour).UTC().Format(time.RFC3339)
+	files["/roots.pem.sig"] = []byte(strings.Replace(string(sig), stale, time.Now().UTC().Format(time.RFC3339), 1))
+	if _, err = NewURL(metadata); err == nil {
+		t.Fatal("bundle with a forged signing time was trusted")
+	}
+
+	files["/roots.pem.sig"] = signBundle(t, key, bundle, time.Now())
+	files["/roots.pem"] = append(helpers.EncodeCertificatePEM(newRoot(t, "attacker")), bundle...)
+	if _, err = NewURL(metadata); err == nil {
+		t.Fatal("tampered bundle was trusted")
+	}
+}
+
+func TestEd25519Signature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+		PublicKey: asn1.BitString{Bytes: pub, BitLength: 8 * len(pub)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parsePublicKey(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte("bundle")
+	if err = verifyBundle(parsed, msg, ed25519.Sign(priv, msg)); err != nil {
+		t.Fatal(err)
+	}
+	if err = verifyBundle(parsed, []byte("other"), ed25519.Sign(priv, msg)); err == nil {
+		t.Fatal("Ed25519 signature of another message verified")
+	}
+}
+
+func TestSPIFFEProvider(t *testing.T) {
+	root := newRoot(t, "spiffe")
+	body, _ := json.Marshal(map[string]interface{}{
+		"spiffe_sequence": 1,
+		"keys": []map[string]interface{}{
+			{"use": "x509-svid", "kty": "EC", "x5c": []string{base64.StdEncoding.EncodeToString(root.Raw)}},
+			{"use": "jwt-svid", "kty": "EC", "kid": "ignored"},
+		},
+	})
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	server, ca := newBundleServer(t, dir, map[string][]byte{"/bundle": body})
+	defer server.Close()
+
+	roots, err := NewSPIFFE(map[string]string{"url": server.URL + "/bundle", "tls-remote-ca": ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || !roots[0].Equal(root) {
+		t.Fatal("wrong roots loaded from the SPIFFE bundle")
+	}
+
+	if _, err = NewSPIFFE(map[string]string{"url": server.URL + "/bundle"}); err == nil {
+		t.Fatal("bundle endpoint was trusted without verifying its certificate")
+	}
+}
+
+func TestAutoRefresh(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	writeRoot(t, filepath.Join(dir, "first.pem"), newRoot(t, "first"))
+	store, err := New([]*core.Root{{Type: "dir", Metadata: map[string]string{"source": dir, "refresh": "10ms"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- store.AutoRefresh(ctx, time.Hour, nil) }()
+
+	writeRoot(t, filepath.Join(dir, "second.pem"), newRoot(t, "second"))
+	deadline := time.Now().Add(10 * time.Second)
+	for !names(store)["second"] {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for refresh")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err = <-done; err != context.Canceled {
+		t.Fatalf("AutoRefresh returned %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,245 @@
+package roots
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"golang.org/x/crypto/ed25519"
+)
+
+// This file contains the providers fetching roots from remote bundles.
+
+// maxBundleSize bounds the size of a fetched bundle or signature.
+const maxBundleSize = 4 << 20
+
+// fetch returns the body of a GET of url, with the TLS server verified
+// against the CAs in the file under the "tls-remote-ca" key if there is
+// one, or else the system roots.
+func fetch(metadata map[string]string, url string) ([]byte, error) {
+	remoteCAs, err := helpers.LoadPEMCertPool(metadata["tls-remote-ca"])
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: helpers.CreateTLSConfig(remoteCAs, nil)},
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("transport: fetching %s: %s", url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBundleSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBundleSize {
+		return nil, fmt.Errorf("transport: %s is too large", url)
+	}
+	return body, nil
+}
+
+// DefaultBundleMaxAge is how long after it was signed a bundle fetched
+// by the "url" provider is trusted, unless the root gives a "max-age".
+const DefaultBundleMaxAge = 7 * 24 * time.Hour
+
+// maxClockSkew is how far in the future a bundle's signing time may be.
+const maxClockSkew = 5 * time.Minute
+
+// NewURL fetches a PEM bundle of roots from the "url" key, and verifies
+// its signature before trusting it. The signature is fetched from the
+// "signature-url" key, by default the bundle's URL with ".sig"
+// appended. Its first line is the time the bundle was signed, in RFC
+// 3339 format, and its second the base64-encoded signature, by the PEM
+// public key in the file under the "signature-key" key, of that line
+// followed by the bundle: an ECDSA or RSA PKCS #1 v1.5 signature of
+// their SHA-256 digest, or an Ed25519 signature. So that an old bundle
+// can't be replayed, it is only trusted for the "max-age" duration
+// after it was signed, a week by default.
+func NewURL(metadata map[string]string) ([]*x509.Certificate, error) {
+	url, ok := metadata["url"]
+	if !ok {
+		return nil, errors.New("transport: URL root provider requires a url")
+	}
+	keyFile, ok := metadata["signature-key"]
+	if !ok {
+		return nil, errors.New("transport: URL root provider requires a signature-key")
+	}
+	sigURL, ok := metadata["signature-url"]
+	if !ok {
+		sigURL = url + ".sig"
+	}
+	maxAge := DefaultBundleMaxAge
+	if age, ok := metadata["max-age"]; ok {
+		d, err := time.ParseDuration(age)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("transport: invalid max-age %q for URL root", age)
+		}
+		maxAge = d
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("transport: no PEM public key in %s", keyFile)
+	}
+	pub, err := parsePublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := fetch(metadata, url)
+	if err != nil {
+		return nil, err
+	}
+	sigFile, err := fetch(metadata, sigURL)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(sigFile)), "\n")
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("transport: malformed signature at %s: expected a signing time and a signature", sigURL)
+	}
+	stamp := strings.TrimSpace(lines[0])
+	signedAt, err := time.Parse(time.RFC3339, stamp)
+	if err != nil {
+		return nil, fmt.Errorf("transport: malformed signing time at %s: %v", sigURL, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return nil, fmt.Errorf("transport: malformed signature at %s: %v", sigURL, err)
+	}
+	if err = verifyBundle(pub, append([]byte(stamp+"\n"), bundle...), sig); err != nil {
+		return nil, fmt.Errorf("transport: bad signature on %s: %v", url, err)
+	}
+
+	now := time.Now()
+	if signedAt.After(now.Add(maxClockSkew)) {
+		return nil, fmt.Errorf("transport: %s was signed in the future, at %s", url, stamp)
+	}
+	if now.Sub(signedAt) > maxAge {
+		return nil, fmt.Errorf("transport: %s was signed at %s, more than %s ago", url, stamp, maxAge)
+	}
+
+	return helpers.ParseCertificatesPEM(bundle)
+}
+
+// oidEd25519 identifies Ed25519 keys, as in RFC 8410.
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// parsePublicKey parses a SubjectPublicKeyInfo, including Ed25519
+// keys, which crypto/x509 doesn't know about.
+func parsePublicKey(der []byte) (crypto.PublicKey, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if rest, err := asn1.Unmarshal(der, &spki); err == nil && len(rest) == 0 && spki.Algorithm.Algorithm.Equal(oidEd25519) {
+		if len(spki.PublicKey.Bytes) != ed25519.PublicKeySize {
+			return nil, errors.New("transport: malformed Ed25519 public key")
+		}
+		return ed25519.PublicKey(spki.PublicKey.Bytes), nil
+	}
+	return x509.ParsePKIXPublicKey(der)
+}
+
+func verifyBundle(pub crypto.PublicKey, msg, sig []byte) error {
+	digest := sha256.Sum256(msg)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		rest, err := asn1.Unmarshal(sig, &rs)
+		if err != nil || len(rest) != 0 || rs.R.Sign() <= 0 || rs.S.Sign() <= 0 ||
+			!ecdsa.Verify(pub, digest[:], rs.R, rs.S) {
+			return errors.New("ECDSA verification failed")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, msg, sig) {
+			return errors.New("Ed25519 verification failed")
+		}
+		return nil
+	default:
+		return errors.New("unsupported signature key")
+	}
+}
+
+// spiffeBundle is a SPIFFE trust bundle: a JWK set whose X.509 SVID
+// authorities carry their certificate.
+type spiffeBundle struct {
+	Keys []struct {
+		Use string   `json:"use"`
+		X5C []string `json:"x5c"`
+	} `json:"keys"`
+}
+
+// NewSPIFFE fetches the X.509 authorities of a SPIFFE trust domain
+// from the bundle endpoint under the "url" key, an HTTPS URL
+// authenticated with the Web PKI or the CAs in the file under the
+// "tls-remote-ca" key.
+func NewSPIFFE(metadata map[string]string) ([]*x509.Certificate, error) {
+	url, ok := metadata["url"]
+	if !ok {
+		return nil, errors.New("transport: SPIFFE root provider requires a url")
+	}
+
+	body, err := fetch(metadata, url)
+	if err != nil {
+		return nil, err
+	}
+	var bundle spiffeBundle
+	if err = json.Unmarshal(body, &bundle); err != nil {
+		return nil, fmt.Errorf("transport: malformed SPIFFE bundle: %v", err)
+	}
+
+	var roots []*x509.Certificate
+	for _, key := range bundle.Keys {
+		if key.Use != "x509-svid" {
+			continue
+		}
+		if len(key.X5C) != 1 {
+			return nil, errors.New("transport: SPIFFE X.509 authority must hold exactly one certificate")
+		}
+		der, err := base64.StdEncoding.DecodeString(key.X5C[0])
+		if err != nil {
+			return nil, fmt.Errorf("transport: malformed SPIFFE X.509 authority: %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, cert)
+	}
+
+	if len(roots) == 0 {
+		return nil, errors.New("transport: SPIFFE bundle holds no X.509 authorities")
+	}
+	return roots, nil
+}
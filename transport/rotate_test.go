@@ -0,0 +1,300 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/backoff"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/transport/ca/localca"
+	"github.com/cloudflare/cfssl/transport/core"
+	"github.com/cloudflare/cfssl/transport/kp"
+	"github.com/cloudflare/cfssl/transport/roots"
+)
+
+// newLocalCA returns a local CA and a trust store holding it.
+func newLocalCA(t *testing.T) (*localca.CA, *roots.TrustStore) {
+	lca, err := localca.New(localca.ExampleRequest(), localca.ExampleSigningConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM, err := lca.CACertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The store keeps the certificates it loads.
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ca.pem")
+	if err = ioutil.WriteFile(path, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := roots.New([]*core.Root{{Type: "file", Metadata: map[string]string{"source": path}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lca, store
+}
+
+// newLocalTransport returns a transport for 127.0.0.1 whose
+// certificates are issued by lca, trusting store.
+func newLocalTransport(t *testing.T, lca *localca.CA, store *roots.TrustStore, cn string) *Transport {
+	tr := &Transport{
+		Before:           time.Minute,
+		Provider:         &kp.StandardProvider{},
+		CA:               lca,
+		TrustStore:       store,
+		ClientTrustStore: store,
+		Identity: &core.Identity{
+			Request: &csr.CertificateRequest{
+				CN:         cn,
+				Hosts:      []string{"127.0.0.1"},
+				KeyRequest: &csr.BasicKeyRequest{A: "ecdsa", S: 256},
+			},
+		},
+		Backoff:        &backoff.Backoff{},
+		RevokeSoftFail: true,
+	}
+	if err := tr.RefreshKeys(); err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+// reissue makes tr get a new certificate.
+func reissue(t *testing.T, tr *Transport) {
+	before := tr.Before
+	tr.Before = time.Hour
+	defer func() { tr.Before = before }()
+	if err := tr.RefreshKeys(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serial(tr *Transport) *big.Int {
+	return tr.Provider.Certificate().SerialNumber
+}
+
+func TestCertificateRotation(t *testing.T) {
+	lca, store := newLocalCA(t)
+	server := newLocalTransport(t, lca, store, "server")
+	client := newLocalTransport(t, lca, store, "client")
+
+	l, err := Listen("127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The server reports the serial of each client's certificate.
+	clientSerials := make(chan *big.Int, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			if err = tlsConn.Handshake(); err != nil {
+				clientSerials <- nil
+			} else {
+				clientSerials <- tlsConn.ConnectionState().PeerCertificates[0].SerialNumber
+			}
+			conn.Close()
+		}
+	}()
+
+	dial := func() {
+		conn, err := Dial(l.Addr().String(), client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if got := conn.ConnectionState().PeerCertificates[0].SerialNumber; got.Cmp(serial(server)) != 0 {
+			t.Fatalf("server presented certificate %s, want %s", got, serial(server))
+		}
+		if got := <-clientSerials; got == nil || got.Cmp(serial(client)) != 0 {
+			t.Fatalf("client presented certificate %s, want %s", got, serial(client))
+		}
+	}
+
+	dial()
+	oldServer, oldClient := serial(server), serial(client)
+	reissue(t, server)
+	reissue(t, client)
+	if serial(server).Cmp(oldServer) == 0 || serial(client).Cmp(oldClient) == 0 {
+		t.Fatal("certificates were not reissued")
+	}
+	// The same listener serves the new certificate.
+	dial()
+}
+
+func TestClientTrustStoreSwap(t *testing.T) {
+	lca, store := newLocalCA(t)
+	_, otherStore := newLocalCA(t)
+	server := newLocalTransport(t, lca, store, "server")
+	server.ClientTrustStore = otherStore
+	client := newLocalTransport(t, lca, store, "client")
+
+	l, err := Listen("127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// Accepted clients are sent a byte.
+			if conn.(*tls.Conn).Handshake() == nil {
+				conn.Write([]byte{0})
+			}
+			conn.Close()
+		}
+	}()
+
+	if conn, err := Dial(l.Addr().String(), client); err == nil {
+		// The client learns of the rejection on its first read.
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+		if err == nil {
+			t.Fatal("client verified against the wrong trust store")
+		}
+	}
+
+	// The same listener verifies against the new store.
+	server.ClientTrustStore = store
+	conn, err := Dial(l.Addr().String(), client)
+	if err != nil {
+		t.Fatalf("client not verified after the trust store changed: %v", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("client not verified after the trust store changed: %v", err)
+	}
+}
+
+func TestTrustStoreRefresh(t *testing.T) {
+	lca, store := newLocalCA(t)
+	other, _ := newLocalCA(t)
+	server := newLocalTransport(t, lca, store, "server")
+	client := newLocalTransport(t, lca, store, "client")
+
+	// The client starts out trusting the wrong CA.
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ca.pem")
+	writeCA := func(ca *localca.CA) {
+		caPEM, err := ca.CACertificate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, caPEM, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCA(other)
+	client.TrustStore, err = roots.New([]*core.Root{{Type: "file", Metadata: map[string]string{"source": path}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Listen("127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	cfg, err := client.TLSClientAuthClientConfig("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := tls.Dial("tcp", l.Addr().String(), cfg); err == nil {
+		conn.Close()
+		t.Fatal("server verified against the wrong trust store")
+	}
+
+	// The same configuration verifies against the refreshed store.
+	writeCA(lca)
+	if err = client.TrustStore.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", l.Addr().String(), cfg)
+	if err != nil {
+		t.Fatalf("server not verified after the trust store was refreshed: %v", err)
+	}
+	conn.Close()
+
+	// The server's name is still checked.
+	cfg, err = client.TLSClientAuthClientConfig("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := tls.Dial("tcp", l.Addr().String(), cfg); err == nil {
+		conn.Close()
+		t.Fatal("server verified under the wrong name")
+	}
+}
+
+func TestAutoUpdateContext(t *testing.T) {
+	lca, store := newLocalCA(t)
+	tr := newLocalTransport(t, lca, store, "auto update")
+	old := serial(tr)
+
+	// Every check finds the certificate due for renewal.
+	tr.Before = time.Hour
+	PollInterval = 10 * time.Millisecond
+	defer func() { PollInterval = 30 * time.Second }()
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan time.Time)
+	done := make(chan error, 1)
+	go func() { done <- tr.AutoUpdateContext(ctx, updates, nil) }()
+
+	select {
+	case <-updates:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for update")
+	}
+	cert, err := tr.getCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf.SerialNumber.Cmp(old) == 0 {
+		t.Fatal("handshakes still present the old certificate")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("AutoUpdateContext returned %v, want context.Canceled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("AutoUpdateContext did not stop")
+	}
+}
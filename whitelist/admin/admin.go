@@ -0,0 +1,342 @@
+// Package admin administers network whitelists at runtime. A Manager
+// keeps named whitelists whose entries are persisted in a Store, such
+// as a directory or the certificate database, along with an audit
+// trail of who changed them and when. The whitelists it returns are
+// changed in place, so that servers checking them pick up changes
+// immediately, and Reload picks up changes made to the store by other
+// servers sharing it.
+package admin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cfssl/certdb/dbconf"
+	"github.com/cloudflare/cfssl/certdb/sql"
+	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/whitelist"
+	"github.com/jmoiron/sqlx"
+)
+
+// The actions a Change records.
+const (
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+)
+
+// ConfigActor is the actor recorded for the entries a whitelist is
+// seeded with from the configuration.
+const ConfigActor = "config"
+
+// DefaultReloadInterval is the interval at which servers reload their
+// whitelists from the store.
+const DefaultReloadInterval = time.Minute
+
+// ErrUnknownWhitelist is returned for operations on a whitelist that
+// hasn't been registered with the manager.
+var ErrUnknownWhitelist = errors.New("whitelist: unknown whitelist")
+
+// A Change records an entry being added to, or removed from, a
+// whitelist.
+type Change struct {
+	Whitelist string    `json:"whitelist"`
+	Action    string    `json:"action"`
+	Entry     string    `json:"entry"`
+	Actor     string    `json:"actor"`
+	Time      time.Time `json:"time"`
+}
+
+// A Store persists the entries of named whitelists and the changes
+// made to them.
+type Store interface {
+	// Entries returns the entries of the named whitelist.
+	Entries(name string) ([]string, error)
+
+	// Apply makes a change to a whitelist, and records it in the
+	// whitelist's audit trail.
+	Apply(c Change) error
+
+	// History returns the audit trail of the named whitelist,
+	// oldest first.
+	History(name string) ([]Change, error)
+}
+
+// OpenStore opens the store described by spec: "db" is the
+// certificate database db, "db:" followed by the path of a database
+// configuration file is the database it describes, and anything else
+// is a directory, which is created if needed.
+func OpenStore(spec string, db *sqlx.DB) (Store, error) {
+	switch {
+	case spec == "db":
+		if db == nil {
+			return nil, errors.New("whitelist: no database is configured")
+		}
+		return NewDBStore(sql.NewAccessor(db))
+	case strings.HasPrefix(spec, "db:"):
+		db, err := dbconf.DBFromConfig(strings.TrimPrefix(spec, "db:"))
+		if err != nil {
+			return nil, err
+		}
+		return NewDBStore(sql.NewAccessor(db))
+	}
+	return NewFileStore(spec)
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ParseEntry parses a whitelist entry, which is either a network in
+// CIDR notation or a single IP address.
+func ParseEntry(entry string) (*net.IPNet, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New("whitelist: invalid network " + entry)
+		}
+		return n, nil
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, errors.New("whitelist: invalid IP address " + entry)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// managed is a whitelist and its entries, by their canonical form.
+type managed struct {
+	acl     *whitelist.BasicNet
+	entries map[string]*net.IPNet
+}
+
+// set makes the whitelist hold exactly the given entries.
+func (m *managed) set(entries map[string]*net.IPNet) {
+	for entry, n := range m.entries {
+		if entries[entry] == nil {
+			m.acl.Remove(n)
+		}
+	}
+	for entry, n := range entries {
+		if m.entries[entry] == nil {
+			m.acl.Add(n)
+		}
+	}
+	m.entries = entries
+}
+
+// A Manager administers named network whitelists.
+type Manager struct {
+	store Store
+	lock  sync.Mutex
+	acls  map[string]*managed
+}
+
+// NewManager returns a manager keeping whitelists in store.
+func NewManager(store Store) *Manager {
+	return &Manager{
+		store: store,
+		acls:  map[string]*managed{},
+	}
+}
+
+// load reads the entries of a whitelist from the store.
+func (m *Manager) load(name string) (map[string]*net.IPNet, error) {
+	stored, err := m.store.Entries(name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]*net.IPNet{}
+	for _, entry := range stored {
+		n, err := ParseEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		entries[n.String()] = n
+	}
+	return entries, nil
+}
+
+// Register starts managing the named whitelist, and returns it. If
+// the whitelist has never been changed, it is first seeded with the
+// given entries; otherwise the store is authoritative, and the seed is
+// ignored, which is logged.
+func (m *Manager) Register(name string, seed []string) (whitelist.NetACL, error) {
+	if !validName.MatchString(name) {
+		return nil, errors.New("whitelist: invalid whitelist name " + name)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if wl, ok := m.acls[name]; ok {
+		return wl.acl, nil
+	}
+
+	entries, err := m.load(name)
+	if err != nil {
+		return nil, err
+	}
+	seeded := len(entries) > 0
+	if !seeded {
+		history, err := m.store.History(name)
+		if err != nil {
+			return nil, err
+		}
+		seeded = len(history) > 0
+	}
+	if seeded && len(seed) > 0 {
+		log.Infof("whitelist %s: ignoring the %d configured entries, as the store already keeps it", name, len(seed))
+	} else if !seeded {
+		for _, entry := range seed {
+			n, err := ParseEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+			if entries[n.String()] != nil {
+				continue
+			}
+			err = m.store.Apply(Change{Whitelist: name, Action: ActionAdd, Entry: n.String(), Actor: ConfigActor, Time: time.Now()})
+			if err != nil {
+				return nil, err
+			}
+			entries[n.String()] = n
+		}
+	}
+
+	wl := &managed{acl: whitelist.NewBasicNet()}
+	wl.set(entries)
+	m.acls[name] = wl
+	return wl.acl, nil
+}
+
+// Names returns the names of the managed whitelists.
+func (m *Manager) Names() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	names := make([]string, 0, len(m.acls))
+	for name := range m.acls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Entries returns the entries of a whitelist, in CIDR notation.
+func (m *Manager) Entries(name string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	wl, ok := m.acls[name]
+	if !ok {
+		return nil, ErrUnknownWhitelist
+	}
+
+	entries := make([]string, 0, len(wl.entries))
+	for entry := range wl.entries {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return entries, nil
+}
+
+// change adds an entry to, or removes one from, a whitelist, recording
+// the change in the store. Changes that make no difference are not
+// recorded.
+func (m *Manager) change(name, action, entry, actor string) error {
+	n, err := ParseEntry(entry)
+	if err != nil {
+		return err
+	}
+	entry = n.String()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	wl, ok := m.acls[name]
+	if !ok {
+		return ErrUnknownWhitelist
+	}
+	if (wl.entries[entry] != nil) == (action == ActionAdd) {
+		return nil
+	}
+
+	err = m.store.Apply(Change{Whitelist: name, Action: action, Entry: entry, Actor: actor, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	entries := map[string]*net.IPNet{}
+	for e, n := range wl.entries {
+		entries[e] = n
+	}
+	if action == ActionAdd {
+		entries[entry] = n
+	} else {
+		delete(entries, entry)
+	}
+	wl.set(entries)
+	log.Infof("whitelist %s: %s %s by %s", name, action, entry, actor)
+	return nil
+}
+
+// Add permits a network, or a single address, in a whitelist, on
+// behalf of actor.
+func (m *Manager) Add(name, entry, actor string) error {
+	return m.change(name, ActionAdd, entry, actor)
+}
+
+// Remove drops a network, or a single address, from a whitelist, on
+// behalf of actor.
+func (m *Manager) Remove(name, entry, actor string) error {
+	return m.change(name, ActionRemove, entry, actor)
+}
+
+// History returns the audit trail of a whitelist, oldest first.
+func (m *Manager) History(name string) ([]Change, error) {
+	m.lock.Lock()
+	_, ok := m.acls[name]
+	m.lock.Unlock()
+	if !ok {
+		return nil, ErrUnknownWhitelist
+	}
+	return m.store.History(name)
+}
+
+// Reload updates the whitelists with the entries in the store, which
+// other servers sharing it may have changed.
+func (m *Manager) Reload() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for name, wl := range m.acls {
+		entries, err := m.load(name)
+		if err != nil {
+			return err
+		}
+		wl.set(entries)
+	}
+	return nil
+}
+
+// AutoReload reloads the whitelists at every interval, logging any
+// errors, until the context is done.
+func (m *Manager) AutoReload(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := m.Reload(); err != nil {
+				log.Warningf("failed to reload whitelists: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package admin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/certdb/testdb"
+)
+
+const sqliteMigrations = "../../certdb/sqlite/migrations"
+
+func TestParseEntry(t *testing.T) {
+	for in, out := range map[string]string{
+		"10.0.0.1":      "10.0.0.1/32",
+		"10.0.0.1/8":    "10.0.0.0/8",
+		" 2001:db8::1 ": "2001:db8::1/128",
+		"2001:db8::/32": "2001:db8::/32",
+	} {
+		n, err := ParseEntry(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.String() != out {
+			t.Fatalf("ParseEntry(%q) = %s, expected %s", in, n, out)
+		}
+	}
+
+	for _, in := range []string{"", "example.com", "10.0.0.1/33"} {
+		if _, err := ParseEntry(in); err == nil {
+			t.Fatalf("ParseEntry(%q) should fail", in)
+		}
+	}
+}
+
+func testManager(t *testing.T, newStore func() Store) {
+	m := NewManager(newStore())
+	acl, err := m.Register("api", []string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Register("no/such", nil); err == nil {
+		t.Fatal("invalid whitelist name was registered")
+	}
+	if !acl.Permitted(net.IP{10, 1, 2, 3}) || acl.Permitted(net.IP{192, 0, 2, 1}) {
+		t.Fatal("whitelist wasn't seeded")
+	}
+
+	if err = m.Add("api", "192.0.2.1", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Add("api", "192.0.2.1/32", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Remove("api", "10.0.0.0/8", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Add("web", "192.0.2.1", "alice"); err != ErrUnknownWhitelist {
+		t.Fatalf("expected ErrUnknownWhitelist, but got %v", err)
+	}
+	if !acl.Permitted(net.IP{192, 0, 2, 1}) || acl.Permitted(net.IP{10, 1, 2, 3}) {
+		t.Fatal("whitelist wasn't changed in place")
+	}
+
+	entries, err := m.Entries("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"127.0.0.1/32", "192.0.2.1/32"}; !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("entries are %v, expected %v", entries, expected)
+	}
+
+	history, err := m.History("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actors []string
+	for _, c := range history {
+		actors = append(actors, c.Action+" "+c.Entry+" "+c.Actor)
+	}
+	expected := []string{
+		"add 127.0.0.1/32 config",
+		"add 10.0.0.0/8 config",
+		"add 192.0.2.1/32 alice",
+		"remove 10.0.0.0/8 bob",
+	}
+	if !reflect.DeepEqual(actors, expected) {
+		t.Fatalf("history is %v, expected %v", actors, expected)
+	}
+
+	// Another server sharing the store isn't seeded again, and sees
+	// its changes once reloaded.
+	other := NewManager(newStore())
+	otherACL, err := other.Register("api", []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherACL.Permitted(net.IP{10, 1, 2, 3}) || !otherACL.Permitted(net.IP{192, 0, 2, 1}) {
+		t.Fatal("whitelist was seeded again")
+	}
+	if err = other.Remove("api", "192.0.2.1", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if acl.Permitted(net.IP{192, 0, 2, 1}) {
+		t.Fatal("whitelist wasn't reloaded")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "whitelist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testManager(t, func() Store {
+		store, err := OpenStore(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+
+	in, err := ioutil.ReadFile(filepath.Join(dir, "api.acl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(in) != "127.0.0.1/32\n" {
+		t.Fatalf("unexpected whitelist file %q", in)
+	}
+}
+
+func TestDBStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "whitelist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db := testdb.MigratedSQLiteDB(filepath.Join(dir, "certdb.db"), sqliteMigrations)
+	defer db.Close()
+	testManager(t, func() Store {
+		store, err := OpenStore("db", db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+
+	if _, err = OpenStore("db", nil); err == nil {
+		t.Fatal("expected failure without a database")
+	}
+	if _, err = NewDBStore(struct{ certdb.Accessor }{}); err == nil {
+		t.Fatal("expected failure for a database without whitelists")
+	}
+}
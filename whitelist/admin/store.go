@@ -0,0 +1,218 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cfssl/certdb"
+)
+
+// AuditFile is the name of the file in which a FileStore records the
+// changes made to its whitelists, one JSON object per line.
+const AuditFile = "audit.log"
+
+// A FileStore keeps each whitelist in a file in a directory, named
+// after the whitelist with the ".acl" extension and holding one entry
+// per line.
+type FileStore struct {
+	dir  string
+	lock sync.Mutex
+}
+
+// NewFileStore returns a store keeping whitelists in dir, which is
+// created if it doesn't exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) path(name string) string {
+	return filepath.Join(fs.dir, name+".acl")
+}
+
+func (fs *FileStore) entries(name string) ([]string, error) {
+	in, err := ioutil.ReadFile(fs.path(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(in), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries, nil
+}
+
+// Entries returns the entries of the named whitelist.
+func (fs *FileStore) Entries(name string) ([]string, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.entries(name)
+}
+
+// Apply rewrites the whitelist's file, then appends the change to the
+// audit file.
+func (fs *FileStore) Apply(c Change) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	entries, err := fs.entries(c.Whitelist)
+	if err != nil {
+		return err
+	}
+	set := map[string]bool{}
+	for _, entry := range entries {
+		set[entry] = true
+	}
+	if c.Action == ActionAdd {
+		set[c.Entry] = true
+	} else {
+		delete(set, c.Entry)
+	}
+	entries = entries[:0]
+	for entry := range set {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+
+	// Replace the file, so that readers never see it half-written.
+	tmp, err := ioutil.TempFile(fs.dir, "."+c.Whitelist)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.WriteString(strings.Join(entries, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), fs.path(c.Whitelist)); err != nil {
+		return err
+	}
+
+	record, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	audit, err := os.OpenFile(filepath.Join(fs.dir, AuditFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = audit.Write(append(record, '\n')); err != nil {
+		audit.Close()
+		return err
+	}
+	return audit.Close()
+}
+
+// History reads the changes made to the named whitelist from the audit
+// file.
+func (fs *FileStore) History(name string) ([]Change, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	audit, err := os.Open(filepath.Join(fs.dir, AuditFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer audit.Close()
+
+	var history []Change
+	scanner := bufio.NewScanner(audit)
+	for scanner.Scan() {
+		var c Change
+		if err = json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return nil, err
+		}
+		if c.Whitelist == name {
+			history = append(history, c)
+		}
+	}
+	return history, scanner.Err()
+}
+
+// A DBStore keeps whitelists in the certificate database.
+type DBStore struct {
+	accessor certdb.WhitelistAccessor
+}
+
+// NewDBStore returns a store keeping whitelists in the database, whose
+// accessor must also be a certdb.WhitelistAccessor.
+func NewDBStore(accessor certdb.Accessor) (*DBStore, error) {
+	wa, ok := accessor.(certdb.WhitelistAccessor)
+	if !ok {
+		return nil, errors.New("whitelist: the certificate database does not keep whitelists")
+	}
+	return &DBStore{accessor: wa}, nil
+}
+
+// Entries returns the entries of the named whitelist.
+func (ds *DBStore) Entries(name string) ([]string, error) {
+	wrs, err := ds.accessor.GetWhitelistEntries(name)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]string, len(wrs))
+	for i := range wrs {
+		entries[i] = wrs[i].Entry
+	}
+	return entries, nil
+}
+
+// Apply changes the whitelist's entries, and records the change.
+func (ds *DBStore) Apply(c Change) error {
+	wr := certdb.WhitelistRecord{Name: c.Whitelist, Entry: c.Entry}
+	var err error
+	if c.Action == ActionAdd {
+		err = ds.accessor.InsertWhitelistEntry(wr)
+	} else {
+		err = ds.accessor.DeleteWhitelistEntry(wr)
+	}
+	if err != nil {
+		return err
+	}
+
+	return ds.accessor.InsertWhitelistChange(certdb.WhitelistChangeRecord{
+		Name:      c.Whitelist,
+		Action:    c.Action,
+		Entry:     c.Entry,
+		Actor:     c.Actor,
+		ChangedAt: c.Time,
+	})
+}
+
+// History returns the changes recorded for the named whitelist.
+func (ds *DBStore) History(name string) ([]Change, error) {
+	crs, err := ds.accessor.GetWhitelistChanges(name)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]Change, len(crs))
+	for i, cr := range crs {
+		history[i] = Change{
+			Whitelist: cr.Name,
+			Action:    cr.Action,
+			Entry:     cr.Entry,
+			Actor:     cr.Actor,
+			Time:      cr.ChangedAt,
+		}
+	}
+	return history, nil
+}
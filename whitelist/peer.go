@@ -0,0 +1,230 @@
+package whitelist
+
+// This file contains ACLs deciding on both the address and the
+// certificates of a peer, which combine the address-based and
+// certificate-based ACLs.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+)
+
+// A Peer describes the remote end of a connection or request.
+type Peer struct {
+	// IP is the peer's address.
+	IP net.IP
+
+	// Certificates is the chain the peer authenticated with, leaf
+	// first, or empty if it did not present a certificate. The
+	// ACLs trust these certificates: they must have been verified,
+	// as they are by a TLS server requiring verified client
+	// certificates. The lookup functions only fill it in from a
+	// verified chain.
+	Certificates []*x509.Certificate
+}
+
+// A PeerACL decides on the address and certificates of a peer.
+type PeerACL interface {
+	// PermittedPeer returns true if the peer is whitelisted.
+	PermittedPeer(*Peer) bool
+}
+
+type addressACL struct {
+	acl ACL
+}
+
+func (wl addressACL) PermittedPeer(p *Peer) bool {
+	return p != nil && wl.acl.Permitted(p.IP)
+}
+
+// Address returns a PeerACL permitting the peers whose address is
+// permitted by acl.
+func Address(acl ACL) PeerACL {
+	return addressACL{acl}
+}
+
+// And permits a peer if all of its ACLs do. An empty And permits any
+// peer.
+type And []PeerACL
+
+// PermittedPeer returns true if every ACL permits the peer.
+func (wl And) PermittedPeer(p *Peer) bool {
+	for _, acl := range wl {
+		if !acl.PermittedPeer(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Or permits a peer if one of its ACLs does. An empty Or permits no
+// peer.
+type Or []PeerACL
+
+// PermittedPeer returns true if any ACL permits the peer.
+func (wl Or) PermittedPeer(p *Peer) bool {
+	for _, acl := range wl {
+		if acl.PermittedPeer(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsCertificates returns the chain a TLS peer authenticated with, or
+// nil if its certificates weren't verified, so that ACLs never trust
+// a certificate the peer merely presented.
+func tlsCertificates(state *tls.ConnectionState) []*x509.Certificate {
+	if len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0]
+}
+
+// NetConnPeerLookup extracts the peer of a net.Conn. If it is a TLS
+// connection, the handshake is completed to obtain the peer's
+// verified certificates: a peer whose certificates the server doesn't
+// verify, as with tls.RequireAnyClientCert, has none.
+func NetConnPeerLookup(conn net.Conn) (*Peer, error) {
+	ip, err := NetConnLookup(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Peer{IP: ip}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err = tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		state := tlsConn.ConnectionState()
+		p.Certificates = tlsCertificates(&state)
+	}
+	return p, nil
+}
+
+// HTTPRequestPeerLookup extracts the peer of a *http.Request, with the
+// verified certificates of a TLS client.
+func HTTPRequestPeerLookup(req *http.Request) (*Peer, error) {
+	ip, err := HTTPRequestLookup(req)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Peer{IP: ip}
+	if req.TLS != nil {
+		p.Certificates = tlsCertificates(req.TLS)
+	}
+	return p, nil
+}
+
+// PeerHandler wraps an HTTP handler with peer whitelisting.
+type PeerHandler struct {
+	allowHandler http.Handler
+	denyHandler  http.Handler
+	whitelist    PeerACL
+}
+
+// NewPeerHandler returns a new whitelisting-wrapped HTTP handler,
+// like NewHandler, that checks the peer of each request against acl.
+func NewPeerHandler(allow, deny http.Handler, acl PeerACL) (*PeerHandler, error) {
+	if allow == nil {
+		return nil, errors.New("whitelist: allow cannot be nil")
+	}
+
+	if acl == nil {
+		return nil, errors.New("whitelist: ACL cannot be nil")
+	}
+
+	return &PeerHandler{
+		allowHandler: allow,
+		denyHandler:  deny,
+		whitelist:    acl,
+	}, nil
+}
+
+// NewPeerHandlerFunc is NewPeerHandler for a pair of handler
+// functions.
+func NewPeerHandlerFunc(allow, deny func(http.ResponseWriter, *http.Request), acl PeerACL) (*PeerHandler, error) {
+	if allow == nil {
+		return nil, errors.New("whitelist: allow cannot be nil")
+	}
+
+	var denyHandler http.Handler
+	if deny != nil {
+		denyHandler = http.HandlerFunc(deny)
+	}
+	return NewPeerHandler(http.HandlerFunc(allow), denyHandler, acl)
+}
+
+// ServeHTTP wraps the request in a whitelist check.
+func (h *PeerHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p, err := HTTPRequestPeerLookup(req)
+	if err != nil {
+		log.Printf("failed to lookup request peer: %v", err)
+		status := http.StatusInternalServerError
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if h.whitelist.PermittedPeer(p) {
+		h.allowHandler.ServeHTTP(w, req)
+	} else {
+		if h.denyHandler == nil {
+			status := http.StatusUnauthorized
+			http.Error(w, http.StatusText(status), status)
+		} else {
+			h.denyHandler.ServeHTTP(w, req)
+		}
+	}
+}
+
+// A ConnHandler contains a pair of functions that will be called
+// with a connection depending on whether its peer is allowed or
+// denied.
+type ConnHandler struct {
+	allow     func(net.Conn)
+	deny      func(net.Conn)
+	whitelist PeerACL
+}
+
+// NewConnHandler returns a new connection whitelisting handler. If
+// deny is nil, denied connections are closed.
+func NewConnHandler(allow, deny func(net.Conn), acl PeerACL) (*ConnHandler, error) {
+	if allow == nil {
+		return nil, errors.New("whitelist: allow cannot be nil")
+	}
+
+	if acl == nil {
+		return nil, errors.New("whitelist: ACL cannot be nil")
+	}
+
+	return &ConnHandler{
+		allow:     allow,
+		deny:      deny,
+		whitelist: acl,
+	}, nil
+}
+
+// ServeConn checks the peer of the connection to see whether it is
+// permitted, and calls the appropriate function. Connections whose
+// peer can't be looked up are closed.
+func (h *ConnHandler) ServeConn(conn net.Conn) {
+	p, err := NetConnPeerLookup(conn)
+	if err != nil {
+		log.Printf("failed to lookup connection peer: %v", err)
+		conn.Close()
+		return
+	}
+
+	if h.whitelist.PermittedPeer(p) {
+		h.allow(conn)
+	} else if h.deny == nil {
+		conn.Close()
+	} else {
+		h.deny(conn)
+	}
+}
@@ -0,0 +1,172 @@
+package whitelist
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompositeACLs(t *testing.T) {
+	cert := newTestCA(t, pkix.Name{CommonName: "Example CA"}).issue(t, "api", nil).Leaf
+	localhost := NewBasic()
+	localhost.Add(net.IP{127, 0, 0, 1})
+	names := NewBasicCommonName()
+	names.Add("api")
+
+	local := &Peer{IP: net.IP{127, 0, 0, 1}, Certificates: []*x509.Certificate{cert}}
+	remote := &Peer{IP: net.IP{192, 0, 2, 1}, Certificates: []*x509.Certificate{cert}}
+	anonymous := &Peer{IP: net.IP{127, 0, 0, 1}}
+
+	for _, test := range []struct {
+		acl                      PeerACL
+		local, remote, anonymous bool
+	}{
+		{And{Address(localhost), names}, true, false, false},
+		{Or{Address(localhost), names}, true, true, true},
+		{And{}, true, true, true},
+		{Or{}, false, false, false},
+		{Or{And{Address(localhost), names}, And{}}, true, true, true},
+	} {
+		if test.acl.PermittedPeer(local) != test.local ||
+			test.acl.PermittedPeer(remote) != test.remote ||
+			test.acl.PermittedPeer(anonymous) != test.anonymous {
+			t.Fatalf("%#v: wrong decision", test.acl)
+		}
+	}
+}
+
+// newTLSServer starts a server serving h, which verifies client
+// certificates against ca, or merely requires them if ca is nil.
+func newTLSServer(h http.Handler, ca *testCA) *httptest.Server {
+	srv := httptest.NewUnstartedServer(h)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	if ca != nil {
+		srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: ca.pool()}
+	}
+	srv.StartTLS()
+	return srv
+}
+
+func testTLSResponse(t *testing.T, srv *httptest.Server, cert tls.Certificate) string {
+	// A new client for each certificate, so that no connection is
+	// reused.
+	cfg := srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	cfg.Certificates = []tls.Certificate{cert}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestPeerHandler(t *testing.T) {
+	names := NewBasicCommonName()
+	names.Add("api")
+
+	if _, err := NewPeerHandler(nil, testDenyHandler, names); err == nil {
+		t.Fatal("expected failure without an allow handler")
+	}
+	if _, err := NewPeerHandlerFunc(newTestHandlerFunc("OK"), nil, nil); err == nil {
+		t.Fatal("expected failure without an ACL")
+	}
+
+	ca := newTestCA(t, pkix.Name{CommonName: "Example CA"})
+	api := ca.issue(t, "api", nil)
+	web := ca.issue(t, "web", nil)
+
+	h, err := NewPeerHandler(testAllowHandler, testDenyHandler, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTLSServer(h, ca)
+	defer srv.Close()
+	if response := testTLSResponse(t, srv, api); response != "OK" {
+		t.Fatalf("Expected OK, but got %s", response)
+	}
+	if response := testTLSResponse(t, srv, web); response != "NO" {
+		t.Fatalf("Expected NO, but got %s", response)
+	}
+
+	// Certificates the server doesn't verify aren't trusted.
+	unverified := newTLSServer(h, nil)
+	defer unverified.Close()
+	if response := testTLSResponse(t, unverified, api); response != "NO" {
+		t.Fatalf("Expected NO for an unverified certificate, but got %s", response)
+	}
+
+	hf, err := NewPeerHandlerFunc(newTestHandlerFunc("OK"), nil, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvFunc := newTLSServer(hf, ca)
+	defer srvFunc.Close()
+	if response := testTLSResponse(t, srvFunc, web); response != "Unauthorized\n" {
+		t.Fatalf("Expected Unauthorized, but got %s", response)
+	}
+}
+
+func TestConnHandler(t *testing.T) {
+	ca := newTestCA(t, pkix.Name{CommonName: "Example CA"})
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server", nil)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	names := NewBasicURI()
+	names.Add("spiffe://example.com/*")
+	reply := func(message string) func(net.Conn) {
+		return func(conn net.Conn) {
+			conn.Write([]byte(message))
+			conn.Close()
+		}
+	}
+	h, err := NewConnHandler(reply("OK"), nil, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go h.ServeConn(conn)
+		}
+	}()
+
+	dial := func(cert tls.Certificate) string {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		out, _ := ioutil.ReadAll(conn)
+		return string(out)
+	}
+
+	if out := dial(ca.issue(t, "api", nil, "spiffe://example.com/api")); out != "OK" {
+		t.Fatalf("Expected OK, but got %q", out)
+	}
+	if out := dial(ca.issue(t, "api", nil, "spiffe://example.net/api")); out != "" {
+		t.Fatalf("denied connection received %q", out)
+	}
+}
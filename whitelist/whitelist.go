@@ -1,6 +1,9 @@
 // Package whitelist implements IP whitelisting for various types
 // of connections. Two types of access control lists (ACLs) are
-// supported: host-based and network-based.
+// supported: host-based and network-based. Peers authenticated with
+// TLS certificates may also be whitelisted by the identity in their
+// certificate, with the certificate-based ACLs, and by combinations of
+// their address and certificate, with the peer ACLs.
 package whitelist
 
 import (
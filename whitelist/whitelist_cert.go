@@ -0,0 +1,363 @@
+package whitelist
+
+// This file contains ACLs that operate on the certificate a peer
+// authenticated with, rather than its address. Like the ACLs in
+// whitelist.go, they serialise to a comma-separated list.
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+// A CertACL stores a list of permitted certificate identities, such
+// as names or public keys.
+type CertACL interface {
+	PeerACL
+
+	// PermittedCertificate takes a peer's leaf certificate, and
+	// returns true if it is whitelisted.
+	PermittedCertificate(*x509.Certificate) bool
+
+	// Add whitelists an identity, or a pattern of identities.
+	Add(string)
+
+	// Remove drops an identity, or a pattern of identities, from
+	// the whitelist.
+	Remove(string)
+}
+
+// MatchName reports whether a name matches a pattern. The pattern "*"
+// matches any name; a pattern starting with "*." matches names with
+// exactly one more leading DNS label, such as "a.example.com" for
+// "*.example.com"; and a pattern ending with "/*" matches the URIs
+// below it, such as "spiffe://example.com/ns/prod/sa/api" for
+// "spiffe://example.com/ns/prod/*". Other patterns match the same
+// name, ignoring case unless it is a URI.
+func MatchName(pattern, name string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(name, pattern[:len(pattern)-1])
+	case strings.HasPrefix(pattern, "*."):
+		i := strings.IndexByte(name, '.')
+		return i > 0 && strings.EqualFold(name[i:], pattern[1:])
+	case strings.Contains(pattern, "://"):
+		return pattern == name
+	}
+	return strings.EqualFold(pattern, name)
+}
+
+// SPKIPin returns the pin of a certificate's public key: the
+// base64-encoded SHA-256 digest of its SubjectPublicKeyInfo.
+func SPKIPin(cert *x509.Certificate) string {
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// certList is the set of patterns shared by the certificate ACLs.
+type certList struct {
+	lock      *sync.Mutex
+	whitelist map[string]bool
+}
+
+func newCertList() certList {
+	return certList{
+		lock:      new(sync.Mutex),
+		whitelist: map[string]bool{},
+	}
+}
+
+// Add whitelists a pattern.
+func (wl *certList) Add(pattern string) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" || !validPattern(pattern) {
+		return
+	}
+
+	wl.lock.Lock()
+	defer wl.lock.Unlock()
+	wl.whitelist[pattern] = true
+}
+
+// Remove clears a pattern from the whitelist.
+func (wl *certList) Remove(pattern string) {
+	wl.lock.Lock()
+	defer wl.lock.Unlock()
+	delete(wl.whitelist, strings.TrimSpace(pattern))
+}
+
+// matches returns true if one of the names matches one of the
+// patterns in the whitelist, according to match.
+func (wl *certList) matches(match func(pattern, name string) bool, names ...string) bool {
+	wl.lock.Lock()
+	defer wl.lock.Unlock()
+	for _, name := range names {
+		for pattern := range wl.whitelist {
+			if match(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MarshalJSON serialises a certificate whitelist to a comma-separated
+// list of patterns, implementing the json.Marshaler interface.
+func (wl *certList) MarshalJSON() ([]byte, error) {
+	wl.lock.Lock()
+	defer wl.lock.Unlock()
+	var ss = make([]string, 0, len(wl.whitelist))
+	for pattern := range wl.whitelist {
+		ss = append(ss, pattern)
+	}
+	sort.Strings(ss)
+
+	out := []byte(`"` + strings.Join(ss, ",") + `"`)
+	return out, nil
+}
+
+// unmarshalJSON loads a comma-separated string of patterns, each of
+// which must be accepted by valid.
+func (wl *certList) unmarshalJSON(in []byte, valid func(string) bool) error {
+	if len(in) < 2 || in[0] != '"' || in[len(in)-1] != '"' {
+		return errors.New("whitelist: invalid whitelist")
+	}
+
+	if wl.lock == nil {
+		wl.lock = new(sync.Mutex)
+	}
+
+	wl.lock.Lock()
+	defer wl.lock.Unlock()
+
+	patterns := strings.Split(string(in[1:len(in)-1]), ",")
+	wl.whitelist = map[string]bool{}
+	for i := range patterns {
+		pattern := strings.TrimSpace(patterns[i])
+		if pattern == "" {
+			continue
+		}
+
+		if !valid(pattern) {
+			wl.whitelist = nil
+			return errors.New("whitelist: invalid pattern " + pattern)
+		}
+		wl.whitelist[pattern] = true
+	}
+
+	return nil
+}
+
+// validPattern accepts the patterns that can be serialised in a
+// comma-separated list.
+func validPattern(pattern string) bool {
+	return !strings.ContainsAny(pattern, ",\"\\")
+}
+
+// permittedPeer checks a peer's leaf certificate against acl.
+func permittedPeer(acl CertACL, p *Peer) bool {
+	if p == nil || len(p.Certificates) == 0 {
+		return false
+	}
+	return acl.PermittedCertificate(p.Certificates[0])
+}
+
+// BasicCommonName whitelists certificates by the common name of their
+// subject, matched against patterns as by MatchName.
+type BasicCommonName struct {
+	certList
+}
+
+// NewBasicCommonName returns a new initialised common name whitelist.
+func NewBasicCommonName() *BasicCommonName {
+	return &BasicCommonName{newCertList()}
+}
+
+// PermittedCertificate returns true if the certificate's common name
+// has been whitelisted.
+func (wl *BasicCommonName) PermittedCertificate(cert *x509.Certificate) bool {
+	return cert != nil && cert.Subject.CommonName != "" &&
+		wl.matches(MatchName, cert.Subject.CommonName)
+}
+
+// PermittedPeer returns true if the peer's certificate is whitelisted.
+func (wl *BasicCommonName) PermittedPeer(p *Peer) bool {
+	return permittedPeer(wl, p)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, taking a
+// comma-separated string of patterns.
+func (wl *BasicCommonName) UnmarshalJSON(in []byte) error {
+	return wl.unmarshalJSON(in, validPattern)
+}
+
+// BasicDNSName whitelists certificates by their DNS subject
+// alternative names, matched against patterns as by MatchName.
+type BasicDNSName struct {
+	certList
+}
+
+// NewBasicDNSName returns a new initialised DNS name whitelist.
+func NewBasicDNSName() *BasicDNSName {
+	return &BasicDNSName{newCertList()}
+}
+
+// PermittedCertificate returns true if one of the certificate's DNS
+// names has been whitelisted.
+func (wl *BasicDNSName) PermittedCertificate(cert *x509.Certificate) bool {
+	return cert != nil && wl.matches(MatchName, cert.DNSNames...)
+}
+
+// PermittedPeer returns true if the peer's certificate is whitelisted.
+func (wl *BasicDNSName) PermittedPeer(p *Peer) bool {
+	return permittedPeer(wl, p)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, taking a
+// comma-separated string of patterns.
+func (wl *BasicDNSName) UnmarshalJSON(in []byte) error {
+	return wl.unmarshalJSON(in, validPattern)
+}
+
+// BasicURI whitelists certificates by their URI subject alternative
+// names, such as SPIFFE IDs, matched against patterns as by MatchName.
+type BasicURI struct {
+	certList
+}
+
+// NewBasicURI returns a new initialised URI whitelist.
+func NewBasicURI() *BasicURI {
+	return &BasicURI{newCertList()}
+}
+
+// PermittedCertificate returns true if one of the certificate's URIs
+// has been whitelisted. A certificate whose subject alternative names
+// can't be parsed is not permitted.
+func (wl *BasicURI) PermittedCertificate(cert *x509.Certificate) bool {
+	if cert == nil {
+		return false
+	}
+	parsed, err := helpers.CertificateURIs(cert)
+	if err != nil {
+		return false
+	}
+	uris := make([]string, len(parsed))
+	for i := range parsed {
+		uris[i] = parsed[i].String()
+	}
+	return wl.matches(MatchName, uris...)
+}
+
+// PermittedPeer returns true if the peer's certificate is whitelisted.
+func (wl *BasicURI) PermittedPeer(p *Peer) bool {
+	return permittedPeer(wl, p)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, taking a
+// comma-separated string of patterns.
+func (wl *BasicURI) UnmarshalJSON(in []byte) error {
+	return wl.unmarshalJSON(in, validPattern)
+}
+
+// IssuerPin returns the pin of a CA's distinguished name: the
+// base64-encoded SHA-256 digest of its raw subject, which is the raw
+// issuer of the certificates it issues.
+func IssuerPin(ca *x509.Certificate) string {
+	return namePin(ca.RawSubject)
+}
+
+func namePin(rawName []byte) string {
+	digest := sha256.Sum256(rawName)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// BasicIssuer whitelists certificates by their issuer, identified by
+// the pin of its distinguished name as returned by IssuerPin. Whole
+// names are compared, byte for byte, so that CAs sharing a common name
+// aren't confused. The issuer is not otherwise authenticated: the
+// certificate must have been verified.
+type BasicIssuer struct {
+	certList
+}
+
+// NewBasicIssuer returns a new initialised issuer whitelist.
+func NewBasicIssuer() *BasicIssuer {
+	return &BasicIssuer{newCertList()}
+}
+
+// PermittedCertificate returns true if the certificate's issuer has
+// been whitelisted.
+func (wl *BasicIssuer) PermittedCertificate(cert *x509.Certificate) bool {
+	return cert != nil && len(cert.RawIssuer) > 0 && wl.matches(func(pin, name string) bool {
+		return pin == name
+	}, namePin(cert.RawIssuer))
+}
+
+// PermittedPeer returns true if the peer's certificate is whitelisted.
+func (wl *BasicIssuer) PermittedPeer(p *Peer) bool {
+	return permittedPeer(wl, p)
+}
+
+// Add whitelists the pin of an issuer.
+func (wl *BasicIssuer) Add(pin string) {
+	if validPin(strings.TrimSpace(pin)) {
+		wl.certList.Add(pin)
+	}
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, taking a
+// comma-separated string of pins.
+func (wl *BasicIssuer) UnmarshalJSON(in []byte) error {
+	return wl.unmarshalJSON(in, validPin)
+}
+
+// BasicSPKI whitelists certificates by their public key, identified
+// by its pin as returned by SPKIPin.
+type BasicSPKI struct {
+	certList
+}
+
+// NewBasicSPKI returns a new initialised public key whitelist.
+func NewBasicSPKI() *BasicSPKI {
+	return &BasicSPKI{newCertList()}
+}
+
+// PermittedCertificate returns true if the certificate's public key
+// has been whitelisted.
+func (wl *BasicSPKI) PermittedCertificate(cert *x509.Certificate) bool {
+	return cert != nil && wl.matches(func(pin, name string) bool {
+		return pin == name
+	}, SPKIPin(cert))
+}
+
+// PermittedPeer returns true if the peer's certificate is whitelisted.
+func (wl *BasicSPKI) PermittedPeer(p *Peer) bool {
+	return permittedPeer(wl, p)
+}
+
+// Add whitelists a pin.
+func (wl *BasicSPKI) Add(pin string) {
+	if validPin(strings.TrimSpace(pin)) {
+		wl.certList.Add(pin)
+	}
+}
+
+// validPin accepts the base64 encoding of a SHA-256 digest.
+func validPin(pin string) bool {
+	digest, err := base64.StdEncoding.DecodeString(pin)
+	return err == nil && len(digest) == sha256.Size
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, taking a
+// comma-separated string of pins.
+func (wl *BasicSPKI) UnmarshalJSON(in []byte) error {
+	return wl.unmarshalJSON(in, validPin)
+}
@@ -0,0 +1,213 @@
+package whitelist
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// A testCA issues test certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCA returns a self-signed CA with the subject.
+func newTestCA(t *testing.T, subject pkix.Name) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// pool returns a pool trusting the CA.
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue returns a certificate for the common name, DNS names and URIs.
+// The names are encoded by hand, as x509.Certificate only has URIs from
+// Go 1.10.
+func (ca *testCA) issue(t *testing.T, cn string, dnsNames []string, uris ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	var names []asn1.RawValue
+	for _, name := range dnsNames {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte(name)})
+	}
+	for _, uri := range uris {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(uri)})
+	}
+	if len(names) > 0 {
+		san, err := asn1.Marshal(names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 17}, Value: san}}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+func TestMatchName(t *testing.T) {
+	for _, test := range []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"*", "anything", true},
+		{"api.example.com", "API.example.com", true},
+		{"api.example.com", "www.example.com", false},
+		{"*.example.com", "api.example.com", true},
+		{"*.example.com", "a.api.example.com", false},
+		{"*.example.com", "example.com", false},
+		{"spiffe://example.com/ns/prod/*", "spiffe://example.com/ns/prod/sa/api", true},
+		{"spiffe://example.com/ns/prod/*", "spiffe://example.com/ns/dev/sa/api", false},
+		{"spiffe://example.com/sa/api", "spiffe://example.com/sa/API", false},
+	} {
+		if MatchName(test.pattern, test.name) != test.match {
+			t.Errorf("MatchName(%q, %q) != %v", test.pattern, test.name, test.match)
+		}
+	}
+}
+
+func TestCertACLs(t *testing.T) {
+	ca := newTestCA(t, pkix.Name{CommonName: "Example CA", Organization: []string{"Example"}})
+	cert := ca.issue(t, "api", []string{"api.example.com"}, "spiffe://example.com/ns/prod/sa/api").Leaf
+	// The other CA shares the common name of the first.
+	other := newTestCA(t, pkix.Name{CommonName: "Example CA", Organization: []string{"Other"}}).
+		issue(t, "web", []string{"web.example.net"}).Leaf
+
+	for _, test := range []struct {
+		acl     CertACL
+		pattern string
+	}{
+		{NewBasicCommonName(), "api"},
+		{NewBasicDNSName(), "*.example.com"},
+		{NewBasicURI(), "spiffe://example.com/ns/prod/*"},
+		{NewBasicIssuer(), IssuerPin(ca.cert)},
+		{NewBasicSPKI(), SPKIPin(cert)},
+	} {
+		if test.acl.PermittedCertificate(cert) {
+			t.Fatalf("%T: empty whitelist permitted a certificate", test.acl)
+		}
+		test.acl.Add(test.pattern)
+		if !test.acl.PermittedCertificate(cert) {
+			t.Fatalf("%T: certificate should be permitted by %s", test.acl, test.pattern)
+		}
+		if test.acl.PermittedCertificate(other) {
+			t.Fatalf("%T: certificate shouldn't be permitted by %s", test.acl, test.pattern)
+		}
+		if !test.acl.PermittedPeer(&Peer{Certificates: []*x509.Certificate{cert, other}}) {
+			t.Fatalf("%T: peer should be permitted by its leaf certificate", test.acl)
+		}
+		if test.acl.PermittedPeer(&Peer{}) {
+			t.Fatalf("%T: peer without a certificate was permitted", test.acl)
+		}
+		test.acl.Remove(test.pattern)
+		if test.acl.PermittedCertificate(cert) {
+			t.Fatalf("%T: certificate should no longer be permitted", test.acl)
+		}
+	}
+
+	pins := NewBasicSPKI()
+	pins.Add("not a pin")
+	if out, _ := json.Marshal(pins); string(out) != `""` {
+		t.Fatalf("invalid pin was added: %s", out)
+	}
+}
+
+func TestMarshalCert(t *testing.T) {
+	names := NewBasicDNSName()
+	names.Add("*.example.com")
+	names.Add("api.example.net")
+	names.Add("bad,name")
+
+	out, err := json.Marshal(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `"*.example.com,api.example.net"` {
+		t.Fatalf("unexpected whitelist %s", out)
+	}
+
+	var loaded BasicDNSName
+	if err = json.Unmarshal(out, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	cert := newTestCA(t, pkix.Name{CommonName: "Example CA"}).issue(t, "api", []string{"api.example.net"}).Leaf
+	if !loaded.PermittedCertificate(cert) {
+		t.Fatal("loaded whitelist should permit the certificate")
+	}
+
+	var pins BasicSPKI
+	if err = json.Unmarshal([]byte(`"`+SPKIPin(cert)+`, `+SPKIPin(cert)+`"`), &pins); err != nil {
+		t.Fatal(err)
+	}
+	if !pins.PermittedCertificate(cert) {
+		t.Fatal("loaded pins should permit the certificate")
+	}
+}
+
+func TestMarshalCertFail(t *testing.T) {
+	for _, test := range []struct {
+		acl interface{}
+		in  string
+	}{
+		{&BasicCommonName{}, `["api"]`},
+		{&BasicURI{}, `"spiffe://example.com/a\"b"`},
+		{&BasicSPKI{}, `"AAAA"`},
+		{&BasicSPKI{}, `"not base64"`},
+		{&BasicIssuer{}, `"Example CA"`},
+	} {
+		if err := json.Unmarshal([]byte(test.in), test.acl); err == nil {
+			t.Fatalf("%T: %s should not be loaded", test.acl, test.in)
+		}
+	}
+}
@@ -87,6 +87,19 @@ func (wl *BasicNet) Remove(n *net.IPNet) {
 	wl.whitelist = append(wl.whitelist[:index], wl.whitelist[index+1:]...)
 }
 
+// Networks returns the networks in the whitelist.
+func (wl *BasicNet) Networks() []*net.IPNet {
+	wl.lock.Lock()
+	defer wl.lock.Unlock()
+	nets := make([]*net.IPNet, 0, len(wl.whitelist))
+	for i := range wl.whitelist {
+		if wl.whitelist[i] != nil {
+			nets = append(nets, wl.whitelist[i])
+		}
+	}
+	return nets
+}
+
 // NewBasicNet constructs a new basic network-based whitelist.
 func NewBasicNet() *BasicNet {
 	return &BasicNet{
@@ -34,6 +34,13 @@ func TestMarshalNet(t *testing.T) {
 	if err != nil {
 		t.Fatalf("%v", err)
 	}
+
+	if nets := tvPrime["test-a"].Networks(); len(nets) != 2 {
+		t.Fatalf("expected two networks, got %v", nets)
+	}
+	if nets := tvPrime["test-b"].Networks(); len(nets) != 0 {
+		t.Fatalf("expected no networks, got %v", nets)
+	}
 }
 
 func TestMarshalNetFail(t *testing.T) {